@@ -0,0 +1,79 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// podNamespacesRefresh is how often the pods of the store are listed again when a process
+// runs in a pod that isn't known yet
+const podNamespacesRefresh = 10 * time.Second
+
+// newArgsPolicy creates the policy applied to process arguments from the process-args
+// flags
+func newArgsPolicy(opts ...argpolicy.Option) (*argpolicy.Policy, error) {
+	fallback, err := argpolicy.Parse(collectorOpts.argsDefault)
+	if err != nil {
+		return nil, fmt.Errorf("invalid process-args-default: %w", err)
+	}
+	return argpolicy.New(collectorOpts.argsRules, fallback, opts...), nil
+}
+
+// podNamespaces resolves the Kubernetes namespace of host processes from the pod UID in
+// their cgroup and the pods indexed in the store by the Kubernetes controller
+type podNamespaces struct {
+	store   resource.Store
+	cgroups *cgroup.Reader
+
+	mu        sync.Mutex
+	byUID     map[string]string
+	refreshed time.Time
+}
+
+// Namespace returns the namespace of the pod pid runs in, empty if it doesn't run in a
+// known pod
+func (p *podNamespaces) Namespace(pid int32) string {
+	path, err := p.cgroups.ProcessCgroup(hostProcPath(), int(pid))
+	if err != nil {
+		return ""
+	}
+	uid, ok := cgroup.PodUID(path)
+	if !ok {
+		return ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if namespace, ok := p.byUID[uid]; ok || time.Since(p.refreshed) < podNamespacesRefresh {
+		return namespace
+	}
+	p.refreshed = time.Now()
+	pods, err := p.store.ListResources(&resourcev1.TypeDescriptor{
+		Kind: typeurl.Name(&resourcev1.Resource{}),
+		Type: typeurl.Name(&corev1.Pod{}),
+	})
+	if err != nil {
+		return ""
+	}
+	// Rebuilt rather than updated so that deleted pods don't accumulate
+	p.byUID = make(map[string]string, len(pods))
+	for _, pod := range pods {
+		p.byUID[pod.GetMetadata().GetProviderId()] = pod.GetMetadata().GetNamespace().GetKube().GetNamespace()
+	}
+	return p.byUID[uid]
+}
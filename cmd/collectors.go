@@ -0,0 +1,537 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antimetal/agent/internal/version"
+	"github.com/antimetal/agent/pkg/ebpf"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/antimetal/agent/pkg/performance/history"
+	"github.com/antimetal/agent/pkg/performance/samples"
+	"github.com/antimetal/agent/pkg/performance/schema"
+)
+
+// collectorOptions are the flags shared by the commands that run performance collectors
+type collectorOptions struct {
+	collectors   string
+	hostProcPath string
+	hostSysPath  string
+	hostDevPath  string
+	timeout      time.Duration
+
+	collectorTimeout time.Duration
+
+	certificatePaths     string
+	certificateEndpoints string
+
+	crashDumpDir string
+
+	diskSaturationUtilization float64
+	diskSaturationSamples     int
+
+	kernelMessageLimit int
+	kernelDedupWindow  time.Duration
+
+	tcpSocketStates     string
+	tcpSocketPorts      string
+	tcpSocketSampleRate uint64
+
+	argsRules   []argpolicy.Rule
+	argsDefault string
+
+	enableEBPF bool
+}
+
+var (
+	collectorOpts collectorOptions
+
+	testCollectorsVerbose  bool
+	testCollectorsCSV      string
+	testCollectorsParquet  string
+	testCollectorsDuration time.Duration
+	testCollectorsInterval time.Duration
+	testCollectorsValidate bool
+	testCollectorsJSON     bool
+	snapshotOutput         string
+)
+
+func collectorFlags(fs *flag.FlagSet) {
+	collectorSelectionFlags(fs)
+	fs.DurationVar(&collectorOpts.timeout, "timeout", 30*time.Second,
+		"Maximum time to wait for all collectors to finish")
+}
+
+// collectorSelectionFlags registers the flags selecting which collectors run and where
+// they read host data from
+func collectorSelectionFlags(fs *flag.FlagSet) {
+	fs.StringVar(&collectorOpts.collectors, "collectors", "",
+		"Comma separated list of collectors to run. Defaults to all available collectors, except "+
+			"those irrelevant in the detected environment, e.g. power on VMs, and the opt-in ones, "+
+			"e.g. lock_contention: "+
+			strings.Join(availableCollectors(), ", "))
+	fs.StringVar(&collectorOpts.hostProcPath, "host-proc-path", "/proc",
+		"Path to the host's /proc. Overridden by the HOST_PROC environment variable")
+	fs.StringVar(&collectorOpts.hostSysPath, "host-sys-path", "/sys",
+		"Path to the host's /sys. Overridden by the HOST_SYS environment variable")
+	fs.StringVar(&collectorOpts.hostDevPath, "host-dev-path", "/dev",
+		"Path to the host's /dev. Overridden by the HOST_DEV environment variable")
+	fs.DurationVar(&collectorOpts.collectorTimeout, "collector-timeout",
+		performance.DefaultCollectionConfig().CollectorTimeout,
+		"Maximum time a single collector may take. A collector that takes longer fails and is "+
+			"not run again until its previous run finished")
+	fs.StringVar(&collectorOpts.certificatePaths, "certificate-paths",
+		strings.Join(performance.DefaultCertificatePaths, ","),
+		"Comma separated list of certificate files checked for expiry. Glob patterns are allowed")
+	fs.StringVar(&collectorOpts.certificateEndpoints, "certificate-endpoints",
+		strings.Join(performance.DefaultCertificateEndpoints, ","),
+		"Comma separated list of host:port TLS endpoints whose certificates are checked for expiry")
+	fs.StringVar(&collectorOpts.crashDumpDir, "crash-dump-dir", performance.DefaultCrashDumpDir,
+		"Directory where kdump and apport write crash dumps, checked for previous kernel crashes")
+	fs.Float64Var(&collectorOpts.diskSaturationUtilization, "disk-saturation-utilization",
+		performance.DefaultDiskSaturationUtilization,
+		"Utilization percentage above which a disk is considered saturated")
+	fs.IntVar(&collectorOpts.diskSaturationSamples, "disk-saturation-samples",
+		performance.DefaultDiskSaturationSamples,
+		"Number of consecutive saturated collections after which a disk saturation episode is reported")
+	fs.IntVar(&collectorOpts.kernelMessageLimit, "kernel-message-limit", performance.DefaultKernelMessageLimit,
+		"Number of the most recent kernel log messages reported by each collection")
+	fs.DurationVar(&collectorOpts.kernelDedupWindow, "kernel-dedup-window", performance.DefaultKernelDedupWindow,
+		"Identical kernel log messages logged within this long of one reported are folded into a "+
+			"single message with a repeat count, e.g. those of flapping hardware. 0 reports every message")
+	fs.StringVar(&collectorOpts.tcpSocketStates, "tcp-socket-states", "",
+		"Comma separated list of the TCP states, e.g. ESTABLISHED,LISTEN, of the sockets counted "+
+			"by the tcp_sockets collector. Empty counts sockets in every state")
+	fs.StringVar(&collectorOpts.tcpSocketPorts, "tcp-socket-ports", "",
+		"Comma separated list of the local or remote ports of the sockets counted by the "+
+			"tcp_sockets collector. Empty counts sockets on every port")
+	fs.Uint64Var(&collectorOpts.tcpSocketSampleRate, "tcp-socket-sample-rate", 1,
+		"The tcp_sockets collector reports the details of 1 in this many of the sockets it counts, "+
+			"always the same ones. Raise it on hosts with many connections")
+	fs.Func("process-args-rule",
+		"Rule deciding what is kept of the arguments of traced execs and inspected processes, "+
+			"written as \"<action>[:<max length>] [namespace=<ns>,...] [uid=<uid>,...] "+
+			"[command=<name>,...] [sample=<rate>]\" where action is capture, truncate, hash or drop, "+
+			"e.g. \"drop namespace=payments\" or \"truncate:32 uid=0\". The first matching rule "+
+			"applies. Namespaces only match when the Kubernetes controller is enabled. Can be repeated",
+		func(value string) error {
+			rule, err := argpolicy.Parse(value)
+			if err != nil {
+				return err
+			}
+			collectorOpts.argsRules = append(collectorOpts.argsRules, rule)
+			return nil
+		})
+	fs.StringVar(&collectorOpts.argsDefault, "process-args-default", string(argpolicy.ActionCapture),
+		"Action applied to the arguments of the processes no process-args-rule matches, e.g. "+
+			"\"hash\" or \"capture sample=0.1\"")
+	fs.BoolVar(&collectorOpts.enableEBPF, "enable-ebpf", true,
+		"Load BPF programs into the host kernel. If false, the collectors requiring eBPF are "+
+			"disabled and nothing is loaded, even where the kernel supports it")
+}
+
+func testCollectorsFlags(fs *flag.FlagSet) {
+	collectorFlags(fs)
+	fs.BoolVar(&testCollectorsVerbose, "verbose", false,
+		"Print the data returned by each collector as JSON")
+	fs.StringVar(&testCollectorsCSV, "csv", "",
+		"Write the collected values to this CSV file, one row per sample with the columns "+
+			"timestamp, collector, metric and value")
+	fs.StringVar(&testCollectorsParquet, "parquet", "",
+		"Write the collected values to this Parquet file, with the same columns as -csv")
+	fs.DurationVar(&testCollectorsDuration, "duration", 0,
+		"Keep collecting every -interval for this long, e.g. to capture samples with -csv or "+
+			"-parquet. 0 collects once. The results are reported for the last collection")
+	fs.DurationVar(&testCollectorsInterval, "interval", 10*time.Second,
+		"How often collectors run when -duration is set")
+	fs.BoolVar(&testCollectorsValidate, "validate", false,
+		"Check the data returned by each collector against its JSON schema. Collectors whose "+
+			"data doesn't match fail")
+	fs.BoolVar(&testCollectorsJSON, "json", false,
+		"Print the results as a JSON report, with the data of each collector and the "+
+			"mismatches found by -validate, instead of a table. Attach it to bug reports")
+}
+
+func snapshotFlags(fs *flag.FlagSet) {
+	collectorFlags(fs)
+	fs.StringVar(&snapshotOutput, "output", "-",
+		"File to write the snapshot to. Use - for stdout")
+}
+
+// splitList splits a comma separated flag value. An empty value returns an empty, non-nil
+// list so that it isn't replaced by the collector defaults.
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func availableCollectors() []string {
+	names := make([]string, 0)
+	for metricType := range collectors.PointCollectorFactories() {
+		names = append(names, string(metricType))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newCollectorManager creates a performance manager from opts with the collectors selected
+// by collectorOpts registered. Collectors that can't be created on this host are returned
+// in failed rather than failing the whole command. Unless the collectors are selected
+// explicitly, those irrelevant in the detected environment and the opt-in ones aren't
+// created and are returned in failed with an error wrapping performance.ErrCollectorDisabled.
+func newCollectorManager(opts performance.ManagerOptions) (*performance.Manager, map[performance.MetricType]error, error) {
+	factories := collectors.PointCollectorFactories()
+
+	selected := availableCollectors()
+	if collectorOpts.collectors != "" {
+		selected = strings.Split(collectorOpts.collectors, ",")
+	}
+
+	enabled := make(map[performance.MetricType]bool, len(selected))
+	for _, name := range selected {
+		metricType := performance.MetricType(strings.TrimSpace(name))
+		if _, ok := factories[metricType]; !ok {
+			return nil, nil, fmt.Errorf("unknown collector %q, available collectors: %s",
+				name, strings.Join(availableCollectors(), ", "))
+		}
+		enabled[metricType] = true
+	}
+
+	ebpf.SetEnabled(collectorOpts.enableEBPF)
+	opts.Logger = setupLog.WithName("collectors")
+	opts.Config.EnabledCollectors = enabled
+	opts.Config.HostProcPath = collectorOpts.hostProcPath
+	opts.Config.HostSysPath = collectorOpts.hostSysPath
+	opts.Config.HostDevPath = collectorOpts.hostDevPath
+	opts.Config.CollectorTimeout = collectorOpts.collectorTimeout
+	opts.Config.CertificatePaths = splitList(collectorOpts.certificatePaths)
+	opts.Config.CertificateEndpoints = splitList(collectorOpts.certificateEndpoints)
+	opts.Config.CrashDumpDir = collectorOpts.crashDumpDir
+	opts.Config.DiskSaturationUtilization = collectorOpts.diskSaturationUtilization
+	opts.Config.DiskSaturationSamples = collectorOpts.diskSaturationSamples
+	opts.Config.KernelMessageLimit = collectorOpts.kernelMessageLimit
+	opts.Config.KernelDedupWindow = collectorOpts.kernelDedupWindow
+	opts.Config.TCPSocketStates = splitList(collectorOpts.tcpSocketStates)
+	opts.Config.TCPSocketSampleRate = collectorOpts.tcpSocketSampleRate
+	for _, port := range splitList(collectorOpts.tcpSocketPorts) {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid TCP socket port %q: %w", port, err)
+		}
+		opts.Config.TCPSocketPorts = append(opts.Config.TCPSocketPorts, uint16(p))
+	}
+	if opts.Config.ArgsPolicy == nil {
+		policy, err := newArgsPolicy()
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Config.ArgsPolicy = policy
+	}
+	mgr, err := performance.NewManager(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create performance manager: %w", err)
+	}
+
+	failed := make(map[performance.MetricType]error)
+	disabled := mgr.Environment().DisabledCollectors()
+	maps.Copy(disabled, collectors.OptInCollectors())
+	logger := setupLog.WithName("collectors")
+	for metricType := range enabled {
+		if reason, ok := disabled[metricType]; ok && collectorOpts.collectors == "" {
+			failed[metricType] = fmt.Errorf("%w: %s", performance.ErrCollectorDisabled, reason)
+			continue
+		}
+		collector, err := factories[metricType](logger, mgr.GetConfig())
+		if err != nil {
+			failed[metricType] = fmt.Errorf("failed to create collector: %w", err)
+			continue
+		}
+		if err := mgr.RegisterPointCollector(collector); err != nil {
+			failed[metricType] = err
+			continue
+		}
+		if mgr.GetConfig().BurstThreshold > 0 &&
+			(metricType == performance.MetricTypeCPU || metricType == performance.MetricTypeProcess) {
+			// A second instance, so that bursts don't shorten the period snapshot rates cover
+			burst, err := factories[metricType](logger, mgr.GetConfig())
+			if err == nil {
+				err = mgr.RegisterBurstCollector(burst)
+			}
+			if err != nil {
+				logger.Error(err, "unable to sample collector during bursts", "collector", metricType)
+			}
+		}
+	}
+	return mgr, failed, nil
+}
+
+func runTestCollectors(ctx context.Context, _ []string) error {
+	if testCollectorsDuration > 0 && testCollectorsInterval <= 0 {
+		return fmt.Errorf("-interval must be positive, got %s", testCollectorsInterval)
+	}
+
+	mgr, failed, err := newCollectorManager(performance.ManagerOptions{})
+	if err != nil {
+		return err
+	}
+
+	var writers []*samples.Writer
+	for format, path := range map[samples.Format]string{
+		samples.FormatCSV:     testCollectorsCSV,
+		samples.FormatParquet: testCollectorsParquet,
+	} {
+		if path == "" {
+			continue
+		}
+		w, err := samples.Create(path, format)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		writers = append(writers, w)
+	}
+
+	collect := func() (*performance.Snapshot, error) {
+		ctx, cancel := context.WithTimeout(ctx, collectorOpts.timeout)
+		defer cancel()
+		snapshot := mgr.CollectSnapshot(ctx)
+		for _, w := range writers {
+			if err := w.WriteSnapshot(snapshot); err != nil {
+				return nil, err
+			}
+		}
+		return snapshot, nil
+	}
+
+	snapshot, err := collect()
+	if err != nil {
+		return err
+	}
+	if testCollectorsDuration > 0 {
+		ticker := time.NewTicker(testCollectorsInterval)
+		defer ticker.Stop()
+		deadline := time.After(testCollectorsDuration)
+	capture:
+		for {
+			select {
+			case <-ctx.Done():
+				break capture
+			case <-deadline:
+				break capture
+			case <-ticker.C:
+				if snapshot, err = collect(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to write sample file: %w", err)
+		}
+	}
+
+	report := testCollectorsReport{
+		Version:     version.Get(),
+		Environment: mgr.Environment(),
+		Collectors:  testCollectorResults(snapshot, failed),
+	}
+	failures := 0
+	for _, result := range report.Collectors {
+		if result.failed {
+			failures++
+		}
+	}
+
+	if testCollectorsJSON {
+		if err := writeJSON(os.Stdout, report); err != nil {
+			return err
+		}
+	} else if err := printTestCollectorsReport(os.Stdout, report); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d collectors failed", failures, len(report.Collectors))
+	}
+	return nil
+}
+
+// testCollectorsReport is the result of the test-collectors command
+type testCollectorsReport struct {
+	Version     version.Info            `json:"version"`
+	Environment performance.Environment `json:"environment"`
+	Collectors  []testCollectorResult   `json:"collectors"`
+}
+
+// testCollectorResult is the result of a collector in a testCollectorsReport
+type testCollectorResult struct {
+	Collector performance.MetricType      `json:"collector"`
+	Status    performance.CollectorStatus `json:"status"`
+	Duration  time.Duration               `json:"duration,omitempty"`
+	Error     string                      `json:"error,omitempty"`
+	// SchemaErrors are the mismatches of Data with the collector's schema, with -validate
+	SchemaErrors []string `json:"schemaErrors,omitempty"`
+	Data         any      `json:"data,omitempty"`
+
+	// failed is whether the collector counts as broken. Collectors that are disabled or
+	// can't run on this host aren't.
+	failed bool
+}
+
+// testCollectorResults returns the results of the collectors that ran in snapshot and of
+// those that couldn't be created, failed, sorted by collector. Successful results are
+// validated against their schema with -validate.
+func testCollectorResults(snapshot *performance.Snapshot, failed map[performance.MetricType]error) []testCollectorResult {
+	results := make([]testCollectorResult, 0, len(snapshot.CollectorRun.CollectorStats)+len(failed))
+	for metricType, err := range failed {
+		result := testCollectorResult{
+			Collector: metricType,
+			Status:    performance.CollectorStatusDisabled,
+			Error:     err.Error(),
+		}
+		if !errors.Is(err, performance.ErrCollectorDisabled) {
+			result.Status = performance.CollectorStatusFailed
+			result.failed = true
+		}
+		results = append(results, result)
+	}
+	for metricType, stat := range snapshot.CollectorRun.CollectorStats {
+		result := testCollectorResult{
+			Collector: metricType,
+			Status:    stat.Status,
+			Duration:  stat.Duration,
+		}
+		if stat.Error != nil {
+			result.Error = stat.Error.Error()
+			result.failed = stat.Status != performance.CollectorStatusUnsupported
+			results = append(results, result)
+			continue
+		}
+		result.Data = stat.Data
+		if testCollectorsValidate {
+			mismatches, err := schema.Validate(metricType, stat.Data)
+			if err != nil {
+				mismatches = []schema.ValidationError{{Message: err.Error()}}
+			}
+			for _, mismatch := range mismatches {
+				result.SchemaErrors = append(result.SchemaErrors, mismatch.Error())
+			}
+			result.failed = len(mismatches) > 0
+		}
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b testCollectorResult) int {
+		return strings.Compare(string(a.Collector), string(b.Collector))
+	})
+	return results
+}
+
+// printTestCollectorsReport prints report as a table, followed by the schema mismatches
+// and, with -verbose, the data of each collector
+func printTestCollectorsReport(out io.Writer, report testCollectorsReport) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTOR\tSTATUS\tDURATION\tERROR")
+	for _, result := range report.Collectors {
+		duration, errMsg := "-", result.Error
+		if result.Duration > 0 {
+			duration = result.Duration.String()
+		}
+		if len(result.SchemaErrors) > 0 {
+			errMsg = fmt.Sprintf("%d schema mismatches", len(result.SchemaErrors))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Collector, result.Status, duration, errMsg)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, result := range report.Collectors {
+		if len(result.SchemaErrors) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n==> %s does not match its schema\n", result.Collector)
+		for _, mismatch := range result.SchemaErrors {
+			fmt.Fprintf(out, "  %s\n", mismatch)
+		}
+	}
+
+	if testCollectorsVerbose {
+		for _, result := range report.Collectors {
+			if result.Data == nil {
+				continue
+			}
+			fmt.Fprintf(out, "\n==> %s\n", result.Collector)
+			if err := writeJSON(out, result.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runSnapshot(ctx context.Context, _ []string) error {
+	mgr, failed, err := newCollectorManager(performance.ManagerOptions{})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, collectorOpts.timeout)
+	defer cancel()
+	snapshot := mgr.CollectSnapshot(ctx)
+
+	doc := history.NewRecord(snapshot)
+	for metricType, err := range failed {
+		status := performance.CollectorStatusFailed
+		if errors.Is(err, performance.ErrCollectorDisabled) {
+			status = performance.CollectorStatusDisabled
+		}
+		doc.Collectors[metricType] = history.CollectorRun{
+			Status: status,
+			Error:  err.Error(),
+		}
+	}
+
+	if snapshotOutput == "-" {
+		return writeJSON(os.Stdout, doc)
+	}
+	f, err := os.Create(snapshotOutput)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := writeJSON(f, doc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,92 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/antimetal/agent/pkg/performance/history"
+	"github.com/antimetal/agent/pkg/performance/hwdiff"
+)
+
+var (
+	hwdiffJSON     bool
+	hwdiffExitCode bool
+)
+
+func hwdiffFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&hwdiffJSON, "json", false,
+		"Print the changes as a JSON list")
+	fs.BoolVar(&hwdiffExitCode, "exit-code", false,
+		"Exit with status 1 if the hardware changed")
+}
+
+func runHWDiff(_ context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected 2 snapshot files, got %d", len(args))
+	}
+	before, err := readSnapshotRecord(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := readSnapshotRecord(args[1])
+	if err != nil {
+		return err
+	}
+
+	changes := hwdiff.Diff(&before.Metrics, &after.Metrics)
+	if hwdiffJSON {
+		if changes == nil {
+			changes = []hwdiff.Change{}
+		}
+		if err := writeJSON(os.Stdout, changes); err != nil {
+			return err
+		}
+	} else if len(changes) == 0 {
+		fmt.Println("No hardware changes")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "COMPONENT\tDEVICE\tCHANGE\tFIELD\tBEFORE\tAFTER")
+		for _, c := range changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Component, orDash(c.Device), c.Kind,
+				orDash(c.Field), orDash(c.Before), orDash(c.After))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if hwdiffExitCode && len(changes) > 0 {
+		return fmt.Errorf("%d hardware changes", len(changes))
+	}
+	return nil
+}
+
+// readSnapshotRecord reads a snapshot written by the snapshot command
+func readSnapshotRecord(path string) (*history.Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var record history.Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+	return &record, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antimetal/agent/internal/intake"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+var (
+	queueEncryptionKeyFile string
+	queueOlderThan         time.Duration
+	queueJSON              bool
+)
+
+func intakeQueueFlags(fs *flag.FlagSet) {
+	intakeFlags(fs)
+	fs.StringVar(&intakeQueueDir, "intake-queue-dir", "",
+		"The intake queue directory of the agent, as set with run --intake-queue-dir")
+	fs.StringVar(&queueEncryptionKeyFile, "store-encryption-key-file", "",
+		"File containing the resource inventory encryption key. If empty, the key is read from the "+
+			store.EncryptionKeyEnv+" environment variable")
+	fs.DurationVar(&queueOlderThan, "older-than", 0,
+		"purge: only delete the batches queued longer ago than this. 0 deletes all batches")
+	fs.BoolVar(&queueJSON, "json", false,
+		"ls: print the batches as a JSON list")
+}
+
+// queuedBatch is a batch listed by intake-queue ls
+type queuedBatch struct {
+	Priority string    `json:"priority"`
+	Seq      uint64    `json:"seq"`
+	Queued   time.Time `json:"queued"`
+	Deltas   int       `json:"deltas"`
+	Bytes    int       `json:"bytes"`
+}
+
+func runIntakeQueue(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one of ls, replay or purge, got %d arguments", len(args))
+	}
+	if intakeQueueDir == "" {
+		return fmt.Errorf("--intake-queue-dir is required")
+	}
+
+	key, err := store.LoadEncryptionKey(queueEncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	queue, err := intake.OpenQueue(intakeQueueDir, key)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	switch args[0] {
+	case "ls":
+		return listIntakeQueue(queue)
+	case "replay":
+		conn, err := newIntakeConn()
+		if err != nil {
+			return fmt.Errorf("unable to connect to cloud inventory service: %w", err)
+		}
+		defer conn.Close()
+		labels, err := loadIntakeLabels(ctx)
+		if err != nil {
+			return err
+		}
+		sent, err := intake.ReplayQueue(ctx, queue, conn, intakeAPIKey, labels)
+		fmt.Printf("Sent %d batches\n", sent)
+		return err
+	case "purge":
+		before := time.Now()
+		if queueOlderThan > 0 {
+			before = before.Add(-queueOlderThan)
+		}
+		purged, err := queue.Purge(before)
+		fmt.Printf("Purged %d batches\n", purged)
+		return err
+	default:
+		return fmt.Errorf("unknown intake-queue command %q, expected ls, replay or purge", args[0])
+	}
+}
+
+func listIntakeQueue(queue *intake.Queue) error {
+	batches, err := queue.List()
+	if err != nil {
+		return err
+	}
+	listed := make([]queuedBatch, 0, len(batches))
+	for _, b := range batches {
+		listed = append(listed, queuedBatch{
+			Priority: b.Priority,
+			Seq:      b.Seq,
+			Queued:   b.Queued,
+			Deltas:   len(b.Deltas),
+			Bytes:    b.Size,
+		})
+	}
+	if queueJSON {
+		return writeJSON(os.Stdout, listed)
+	}
+	if len(listed) == 0 {
+		fmt.Println("No queued batches")
+		return nil
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PRIORITY\tSEQ\tQUEUED\tAGE\tDELTAS\tBYTES")
+	for _, b := range listed {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%d\n", b.Priority, b.Seq, b.Queued.Format(time.RFC3339),
+			now.Sub(b.Queued).Round(time.Second), b.Deltas, b.Bytes)
+	}
+	return w.Flush()
+}
@@ -7,257 +7,230 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
-	"time"
+	"strings"
 
 	"github.com/go-logr/logr"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/keepalive"
-
-	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
-	// to ensure that exec-entrypoint and run can make use of them.
-	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
-	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
-	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
-	"github.com/antimetal/agent/internal/intake"
-	k8sagent "github.com/antimetal/agent/internal/kubernetes/agent"
-	"github.com/antimetal/agent/internal/kubernetes/cluster"
-	"github.com/antimetal/agent/internal/kubernetes/scheme"
-	"github.com/antimetal/agent/pkg/resource/store"
+	"github.com/antimetal/agent/internal/crash"
+	"github.com/antimetal/agent/pkg/failpoint"
 )
 
 var (
 	setupLog logr.Logger
-
-	// CLI Options
-	intakeAddr           string
-	intakeAPIKey         string
-	intakeSecure         bool
-	metricsAddr          string
-	metricsSecure        bool
-	metricsCertDir       string
-	metricsCertName      string
-	metricsKeyName       string
-	enableLeaderElection bool
-	probeAddr            string
-	enableHTTP2          bool
-	enableK8sController  bool
-	kubernetesProvider   string
-	eksAccountID         string
-	eksRegion            string
-	eksClusterName       string
-	eksAutodiscover      bool
-	maxStreamAge         time.Duration
-	pprofAddr            string
+	// crashHandler writes a diagnostic bundle when the command panics, nil unless
+	// crash-bundle-dir is set
+	crashHandler *crash.Handler
+	// failpointsEnabled is whether the hidden failpoints flag is set, which also lets the
+	// failpoints be changed on the debug server
+	failpointsEnabled bool
 )
 
-func init() {
-	flag.StringVar(&intakeAddr, "intake-address", "intake.antimetal.com:443",
-		"The address of the cloud inventory intake service")
-	flag.StringVar(&intakeAPIKey, "intake-api-key", "",
-		"The API key to use upload resources",
-	)
-	flag.BoolVar(&intakeSecure, "intake-secure", true,
-		"Use secure connection to the Antimetal intake service",
-	)
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080",
-		"The address the metric endpoint binds to. Set this to '0' to disable the metrics server")
-	flag.BoolVar(&metricsSecure, "metrics-secure", false,
-		"If set the metrics endpoint is served securely")
-	flag.StringVar(&metricsCertDir, "metrics-cert-dir", "",
-		"The directory where the metrics server TLS certificates are stored.",
-	)
-	flag.StringVar(&metricsCertName, "metrics-cert-name", "",
-		"The name of the TLS certificate file for the metrics server.",
-	)
-	flag.StringVar(&metricsKeyName, "metrics-key-name", "",
-		"The name of the TLS key file for the metrics server.",
-	)
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081",
-		"The address the probe endpoint binds to. Set this to '0' to disable the metrics server")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", true,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
-	flag.BoolVar(&enableHTTP2, "enable-http2", false,
-		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
-	flag.BoolVar(&enableK8sController, "enable-kubernetes-controller", true,
-		"Enable Kubernetes cluster snapshot collector")
-	flag.StringVar(&kubernetesProvider, "kubernetes-provider", "kind", "The Kubernetes provider")
-	flag.StringVar(&eksAccountID, "kubernetes-provider-eks-account-id", "",
-		"The AWS account ID the EKS cluster is deployed in")
-	flag.StringVar(&eksRegion, "kubernetes-provider-eks-region", "",
-		"The AWS region the EKS cluster is deployed in")
-	flag.StringVar(&eksClusterName, "kubernetes-provider-eks-cluster-name", "",
-		"The name of the EKS cluster")
-	flag.BoolVar(&eksAutodiscover, "kubernetes-provider-eks-autodiscover", true,
-		"Autodiscover EKS cluster name")
-	flag.DurationVar(&maxStreamAge, "max-stream-age", 10*time.Minute,
-		"Maximum age of the intake stream before it is reset")
-	flag.StringVar(&pprofAddr, "pprof-address", "0",
-		"The address the pprof server binds to. Set this to '0' to disable the pprof server")
-
-	opts := zap.Options{}
-	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
-
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-	setupLog = ctrl.Log.WithName("setup")
+// hiddenFlags are left out of the help of commands, since they are for testing the agent
+var hiddenFlags = map[string]bool{"failpoints": true}
+
+// command is an agent subcommand. Every command parses its own flag set so that flags
+// of one command don't leak into, or conflict with, the flags of another.
+type command struct {
+	name string
+	// args describes the positional arguments in the usage line, if any
+	args string
+	// short is the one line description shown in the command list
+	short string
+	// long is the description shown in the command's help
+	long  string
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, args []string) error
 }
 
-func main() {
-	ctx := ctrl.SetupSignalHandler()
-
-	// if the enable-http2 flag is false (the default), http/2 should be disabled
-	// due to its vulnerabilities. More specifically, disabling http/2 will
-	// prevent from being vulnerable to the HTTP/2 Stream Cancelation and
-	// Rapid Reset CVEs. For more information see:
-	// - https://github.com/advisories/GHSA-qppj-fm5r-hxr3
-	// - https://github.com/advisories/GHSA-4374-p667-p6c8
-	disableHTTP2 := func(c *tls.Config) {
-		setupLog.Info("disabling http/2")
-		c.NextProtos = []string{"http/1.1"}
-	}
-
-	tlsOpts := []func(*tls.Config){}
-	if !enableHTTP2 {
-		tlsOpts = append(tlsOpts, disableHTTP2)
-	}
-
-	metricsServerOpts := metricsserver.Options{
-		BindAddress:   metricsAddr,
-		SecureServing: metricsSecure,
-		TLSOpts:       tlsOpts,
+// defaultCommand runs when no command is given. Invoking the binary with only flags
+// (e.g. `agent --leader-elect`) runs the agent for compatibility with existing deployments.
+const defaultCommand = "run"
+
+func commands() []*command {
+	return []*command{
+		{
+			name:  "run",
+			short: "Run the agent",
+			long: "Run the agent. It collects the Kubernetes cluster inventory and streams it " +
+				"to the Antimetal intake service.",
+			flags: runFlags,
+			run:   runAgent,
+		},
+		{
+			name:  "test-collectors",
+			short: "Run performance collectors once and report the results",
+			long: "Run each performance collector once against the host and report whether it " +
+				"succeeded, how long it took and what it collected. Useful to check that the agent " +
+				"can read the host's /proc and /sys before deploying it. With -validate, the data " +
+				"is checked against the schema of each collector, and with -json the results are " +
+				"written as a report to attach to bug reports about unusual hardware.",
+			flags: testCollectorsFlags,
+			run:   runTestCollectors,
+		},
+		{
+			name:  "snapshot",
+			short: "Write a JSON snapshot of all performance collectors",
+			long: "Run all performance collectors once and write the combined results as a single " +
+				"JSON document.",
+			flags: snapshotFlags,
+			run:   runSnapshot,
+		},
+		{
+			name:  "hwdiff",
+			args:  "<before.json> <after.json>",
+			short: "Compare the hardware of two snapshots",
+			long: "Compare the hardware inventory of two JSON snapshots written by the snapshot " +
+				"command and list what changed: CPUs, memory, instance type, disks and network " +
+				"interfaces. Useful to check that hardware changes match maintenance records.",
+			flags: hwdiffFlags,
+			run:   runHWDiff,
+		},
+		{
+			name:  "store-dump",
+			short: "Dump a persisted resource inventory as JSON",
+			long: "Open a resource inventory persisted with run --store-data-dir in read-only mode and " +
+				"write its resources and relationships as JSON. The agent using the data dir must be stopped.",
+			flags: storeDumpFlags,
+			run:   runStoreDump,
+		},
+		{
+			name:  "intake-queue",
+			args:  "<ls|replay|purge>",
+			short: "Inspect and manage the deltas queued for the intake",
+			long: "Manage the deltas persisted with run --intake-queue-dir that are waiting to be sent " +
+				"to the intake service, e.g. after a long outage. ls lists the queued batches, replay " +
+				"sends them to the intake service and purge deletes them. The agent using the queue " +
+				"must be stopped.",
+			flags: intakeQueueFlags,
+			run:   runIntakeQueue,
+		},
+		{
+			name:  "version",
+			short: "Print the agent version",
+			long:  "Print the agent version, commit, build date, Go version and platform.",
+			flags: versionFlags,
+			run:   runVersion,
+		},
 	}
-	if metricsSecure {
-		metricsServerOpts.FilterProvider = filters.WithAuthenticationAndAuthorization
+}
 
-		// NOTE: If CertDir, CertName, and KeyName are empty, controller-runtime will
-		// automatically generate self-signed certificates for the metrics server. While convenient for
-		// development and testing, this setup is not recommended for production.
-		metricsServerOpts.CertDir = metricsCertDir
-		metricsServerOpts.CertName = metricsCertName
-		metricsServerOpts.KeyName = metricsKeyName
-	}
+func main() {
+	os.Exit(execute(os.Args[1:]))
+}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme.Get(),
-		Metrics:                metricsServerOpts,
-		HealthProbeBindAddress: probeAddr,
-		PprofBindAddress:       pprofAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "4927b366.antimetal.com",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
-	})
-	if err != nil {
-		setupLog.Error(err, "unable to start manager")
-		os.Exit(1)
-	}
+func execute(args []string) int {
+	cmds := commands()
 
-	// Shared resources
-	rsrcStore, err := store.New()
-	if err != nil {
-		setupLog.Error(err, "unable to create resource inventory")
-		os.Exit(1)
-	}
-	if err := mgr.Add(rsrcStore); err != nil {
-		setupLog.Error(err, "unable to register resource inventory")
-		os.Exit(1)
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	var creds credentials.TransportCredentials
-	if intakeSecure {
-		creds = credentials.NewTLS(&tls.Config{})
-	} else {
-		creds = insecure.NewCredentials()
-	}
-	intakeConn, err := grpc.NewClient(intakeAddr,
-		grpc.WithTransportCredentials(creds),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time: 5 * time.Minute,
-		}),
-	)
-	if err != nil {
-		setupLog.Error(err, "unable to connect to cloud inventory service")
-		os.Exit(1)
+	if name == "help" {
+		if len(args) == 0 {
+			printUsage(os.Stdout, cmds)
+			return 0
+		}
+		name, args = args[0], []string{"-h"}
 	}
 
-	// Setup Intake Worker
-	intakeWorker, err := intake.NewWorker(rsrcStore,
-		intake.WithLogger(mgr.GetLogger().WithName("intake-worker")),
-		intake.WithGRPCConn(intakeConn),
-		intake.WithAPIKey(intakeAPIKey),
-		intake.WithMaxStreamAge(maxStreamAge),
-	)
-	if err != nil {
-		setupLog.Error(err, "unable to create intake worker")
-		os.Exit(1)
+	var cmd *command
+	for _, c := range cmds {
+		if c.name == name {
+			cmd = c
+			break
+		}
 	}
-	if err := mgr.Add(intakeWorker); err != nil {
-		setupLog.Error(err, "unable to register intake worker")
-		os.Exit(1)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr, cmds)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: agent %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.long)
+		printDefaults(fs)
+	}
+	cmd.flags(fs)
+	zapOpts := zap.Options{}
+	zapOpts.BindFlags(fs)
+	crashOpts := crash.Options{}
+	fs.StringVar(&crashOpts.Dir, "crash-bundle-dir", "",
+		"Write a diagnostic bundle to this directory when the agent panics: the panic, a dump of "+
+			"all goroutines, the recent logs, the collector statuses and the last performance "+
+			"snapshot. If empty, no bundle is written")
+	fs.IntVar(&crashOpts.LogLines, "crash-bundle-log-lines", crash.DefaultLogLines,
+		"Number of recent log lines included in crash bundles")
+	fs.Func("failpoints",
+		"Inject failures into collectors, the resource inventory or the intake stream to test "+
+			"alerting and degraded modes, e.g. collector/cpu=error;intake/send=10%error. An empty "+
+			"spec only lets the failpoints be changed at "+failpointsPath+" on the debug server. "+
+			"Never set in production",
+		func(spec string) error {
+			failpointsEnabled = true
+			return failpoint.Set(spec)
+		})
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
 	}
 
-	// Setup Kubernetes Collector Controller
-	if enableK8sController {
-		providerOpts := getProviderOptions(setupLog.WithName("cluster-provider"))
-		provider, err := cluster.GetProvider(ctx, kubernetesProvider, providerOpts)
+	if crashOpts.Dir != "" {
+		var err error
+		crashHandler, err = crash.NewHandler(crashOpts)
 		if err != nil {
-			setupLog.Error(err, "unable to determine cluster provider")
-			os.Exit(1)
-		}
-		ctrl := &k8sagent.Controller{
-			Provider: provider,
-			Store:    rsrcStore,
-		}
-		if err := ctrl.SetupWithManager(mgr); err != nil {
-			setupLog.Error(err, "unable to create controller", "controller", "K8sCollector")
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
 		}
+		defer crashHandler.Recover()
 	}
 
-	// Final setup and start Manager
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
-	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+	zapOpts.DestWriter = crashHandler.LogWriter(os.Stderr)
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts)))
+	setupLog = ctrl.Log.WithName("setup")
+	if failpointsEnabled {
+		setupLog.Info("WARNING: failpoints are enabled, failures will be injected", "failpoints", failpoint.List())
 	}
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(ctx); err != nil {
-		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+	if err := cmd.run(ctrl.SetupSignalHandler(), fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
 	}
+	return 0
 }
 
-func getProviderOptions(logger logr.Logger) cluster.ProviderOptions {
-	return cluster.ProviderOptions{
-		Logger: logger,
-		EKS: cluster.EKSOptions{
-			Autodiscover: eksAutodiscover,
-			AccountID:    eksAccountID,
-			Region:       eksRegion,
-			ClusterName:  eksClusterName,
-		},
+// printDefaults prints the flags of fs like fs.PrintDefaults, leaving out hiddenFlags
+func printDefaults(fs *flag.FlagSet) {
+	visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue
+	})
+	visible.PrintDefaults()
+}
+
+func printUsage(w io.Writer, cmds []*command) {
+	fmt.Fprintf(w, "Usage: agent <command> [flags]\n\nCommands:\n")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.short)
 	}
+	fmt.Fprintf(w, "  %-16s %s\n", "help", "Show help for a command")
+	fmt.Fprintf(w, "\nIf no command is given, %q is run.\n", defaultCommand)
+	fmt.Fprintf(w, "Use \"agent help <command>\" for more information about a command.\n")
 }
@@ -0,0 +1,117 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"slices"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+
+	"github.com/antimetal/agent/internal/alerts"
+	cloudaws "github.com/antimetal/agent/internal/cloud/aws"
+	"github.com/antimetal/agent/internal/heartbeat"
+	"github.com/antimetal/agent/internal/host"
+	"github.com/antimetal/agent/internal/intake"
+	"github.com/antimetal/agent/internal/integrity"
+	k8sagent "github.com/antimetal/agent/internal/kubernetes/agent"
+	"github.com/antimetal/agent/internal/nodelease"
+	"github.com/antimetal/agent/internal/oom"
+	"github.com/antimetal/agent/internal/podlatency"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// capabilityManifest returns the capability manifest of the agent from its flags, after
+// they were adjusted to the platform, e.g. with the Kubernetes features disabled when
+// running standalone. perfMgr runs the performance collectors, nil if none run.
+func capabilityManifest(name string, perfMgr *performance.Manager) intake.Manifest {
+	manifest := intake.Manifest{
+		Name: name,
+		Features: map[string]bool{
+			"kubernetes-controller": enableK8sController,
+			"image-inventory":       enableImageInventory,
+			"storage-topology":      enableStorageTopology,
+			"connection-map":        enableConnectionMap,
+			"listener-inventory":    enableListenerInventory,
+			"numa-topology":         enableNUMATopology,
+			"node-lease-monitor":    enableNodeLeaseMonitor,
+			"pod-startup-latency":   enablePodStartupLatency,
+			"container-oom-events":  enableContainerOOMEvents,
+			"file-integrity":        enableFileIntegrity,
+			"host-inventory":        standalone,
+			"cloud-inventory":       enableCloudInventory,
+			"performance-history":   enablePerformanceHistory,
+			"alerts":                len(alertRules) > 0,
+			"heartbeat":             heartbeatInterval > 0,
+			"redaction":             enableRedaction,
+			"ebpf":                  collectorOpts.enableEBPF,
+		},
+	}
+	add := func(coverage k8sagent.Coverage) {
+		manifest.ResourceTypes = append(manifest.ResourceTypes, coverage.ResourceTypes...)
+		manifest.Predicates = append(manifest.Predicates, coverage.Predicates...)
+	}
+	containment := []string{typeurl.Name(&k8sv1.Contains{}), typeurl.Name(&k8sv1.ContainedBy{})}
+
+	if enableK8sController {
+		add((&k8sagent.Controller{WatchedTypes: splitList(k8sWatchedTypes)}).Coverage())
+	}
+	if enableImageInventory {
+		add((&k8sagent.ImageInventory{}).Coverage())
+	}
+	if enableStorageTopology {
+		add((&k8sagent.StorageInventory{}).Coverage())
+	}
+	if enableConnectionMap {
+		add((&k8sagent.ConnectionInventory{}).Coverage())
+	}
+	if enableListenerInventory {
+		add((&k8sagent.ListenerInventory{}).Coverage())
+	}
+	if enableNUMATopology {
+		add((&k8sagent.NUMAInventory{}).Coverage())
+	}
+	if enableNodeLeaseMonitor {
+		add(k8sagent.Coverage{ResourceTypes: []string{nodelease.ResourceType}})
+	}
+	if enablePodStartupLatency {
+		add(k8sagent.Coverage{ResourceTypes: []string{podlatency.ResourceType}})
+	}
+	if enableFileIntegrity {
+		add(k8sagent.Coverage{ResourceTypes: []string{integrity.ResourceType}})
+	}
+	if enableContainerOOMEvents {
+		add(k8sagent.Coverage{ResourceTypes: []string{oom.ResourceType}})
+	}
+	if standalone {
+		add(k8sagent.Coverage{
+			ResourceTypes: []string{host.HostResourceType, host.ServiceResourceType, host.ProcessResourceType},
+			Predicates:    containment,
+		})
+	}
+	if enableCloudInventory {
+		add(k8sagent.Coverage{
+			ResourceTypes: []string{cloudaws.InstanceResourceType, cloudaws.VolumeResourceType,
+				cloudaws.LoadBalancerResourceType},
+			Predicates: slices.Concat(containment,
+				[]string{typeurl.Name(&k8sv1.AttachedTo{}), typeurl.Name(&k8sv1.VolumeMount{})}),
+		})
+	}
+	if len(alertRules) > 0 {
+		add(k8sagent.Coverage{ResourceTypes: []string{alerts.ResourceType}})
+	}
+	if heartbeatInterval > 0 {
+		add(k8sagent.Coverage{ResourceTypes: []string{heartbeat.ResourceType}})
+	}
+
+	if perfMgr != nil {
+		for _, collector := range perfMgr.GetRegistry().GetEnabledPoint(perfMgr.GetConfig()) {
+			manifest.MetricTypes = append(manifest.MetricTypes, string(collector.Type()))
+		}
+	}
+	return manifest
+}
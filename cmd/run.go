@@ -0,0 +1,1624 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	// to ensure that exec-entrypoint and run can make use of them.
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/antimetal/agent/internal/alerts"
+	"github.com/antimetal/agent/internal/budget"
+	"github.com/antimetal/agent/internal/cloud"
+	cloudaws "github.com/antimetal/agent/internal/cloud/aws"
+	"github.com/antimetal/agent/internal/cri"
+	"github.com/antimetal/agent/internal/diag"
+	"github.com/antimetal/agent/internal/heartbeat"
+	"github.com/antimetal/agent/internal/host"
+	"github.com/antimetal/agent/internal/intake"
+	"github.com/antimetal/agent/internal/integrity"
+	k8sagent "github.com/antimetal/agent/internal/kubernetes/agent"
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/kubernetes/scheme"
+	"github.com/antimetal/agent/internal/nodelease"
+	"github.com/antimetal/agent/internal/oom"
+	"github.com/antimetal/agent/internal/podlatency"
+	"github.com/antimetal/agent/internal/version"
+	pkgaws "github.com/antimetal/agent/pkg/aws"
+	"github.com/antimetal/agent/pkg/ebpf/loader"
+	"github.com/antimetal/agent/pkg/enrich"
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/failpoint"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+	"github.com/antimetal/agent/pkg/performance/history"
+	"github.com/antimetal/agent/pkg/performance/hotplug"
+	"github.com/antimetal/agent/pkg/performance/process"
+	"github.com/antimetal/agent/pkg/performance/rules"
+	"github.com/antimetal/agent/pkg/redact"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/replica"
+	"github.com/antimetal/agent/pkg/resource/store"
+	"github.com/antimetal/agent/pkg/tracing"
+)
+
+var (
+	// CLI Options
+	intakeAddr           string
+	intakeAPIKey         string
+	intakeSecure         bool
+	intakeTLSCertFile    string
+	intakeTLSKeyFile     string
+	intakeTLSCAFile      string
+	intakeTLSMinVersion  string
+	intakeTLSServerName  string
+	intakeTLSPinnedSANs  []string
+	intakeLabels         = map[string]string{}
+	intakeLabelsFile     string
+	intakeQueueDir       string
+	metricsAddr          string
+	metricsSecure        bool
+	metricsCertDir       string
+	metricsCertName      string
+	metricsKeyName       string
+	enableLeaderElection bool
+	probeAddr            string
+	enableHTTP2          bool
+	enableK8sController  bool
+	standalone           bool
+	hostMountValidation  string
+	k8sWatchedTypes      string
+	kubernetesRegion     string
+	kubernetesProvider   string
+	eksAccountID         string
+	eksRegion            string
+	eksClusterName       string
+	eksAutodiscover      bool
+	k8sClusterName       string
+	k8sClusterNameFile   string
+	k8sClusterNameUID    bool
+	maxStreamAge         time.Duration
+	pprofAddr            string
+	debugAddr            string
+
+	debugBundleDir             string
+	debugBundleTriggerInterval time.Duration
+
+	storeDataDir                   string
+	storeEncryptionKeyFile         string
+	storePreviousEncryptionKeyFile string
+	storeDataKeyRotation           time.Duration
+	storeSizeBudget                int64
+	storeSubscriberStallTimeout    time.Duration
+	storeCodec                     string
+	storeReplicaBindAddr           string
+	storeReplicaAddr               string
+
+	enableImageInventory   bool
+	criEndpoint            string
+	imageInventoryInterval time.Duration
+
+	enableStorageTopology   bool
+	storageTopologyInterval time.Duration
+
+	enableConnectionMap   bool
+	connectionMapInterval time.Duration
+
+	enableListenerInventory   bool
+	listenerInventoryInterval time.Duration
+
+	enableNUMATopology   bool
+	numaTopologyInterval time.Duration
+
+	hotplugInterval time.Duration
+
+	enableNodeLeaseMonitor bool
+	nodeLeaseStaleAfter    time.Duration
+
+	enablePodStartupLatency bool
+
+	enableContainerOOMEvents bool
+
+	enableFileIntegrity bool
+	fileIntegrityPaths  string
+	fileIntegrityResync time.Duration
+
+	hostInventoryInterval      time.Duration
+	hostInventoryMinProcessAge time.Duration
+
+	enableCloudInventory   bool
+	cloudInventoryInterval time.Duration
+
+	enablePerformanceHistory    bool
+	performanceHistoryDir       string
+	performanceHistoryRetention time.Duration
+	performanceHistoryInterval  time.Duration
+	performanceHistoryRollups   string
+	performanceStateDir         string
+
+	burstCPUThreshold float64
+	burstInterval     time.Duration
+	burstDuration     time.Duration
+
+	heartbeatInterval time.Duration
+
+	memoryBudget int64
+	cpuBudget    float64
+
+	alertRules []rules.Rule
+
+	noisyNeighborStealThreshold float64
+	noisyNeighborDuration       time.Duration
+
+	dirtyPressureThreshold float64
+	reclaimStallThreshold  float64
+	ioStallThreshold       float64
+	memoryPressureDuration time.Duration
+
+	enableRedaction          bool
+	redactEnvVars            bool
+	redactAnnotationPatterns []string
+	redactSecretPatterns     []string
+
+	tagsFile            string
+	tagsEnvPrefix       string
+	tagsFromEC2         bool
+	tagsRefreshInterval time.Duration
+
+	tracingEndpoint    string
+	tracingInsecure    bool
+	tracingSampleRatio float64
+)
+
+// intakeFlags registers the flags of the connection to the intake service
+func intakeFlags(fs *flag.FlagSet) {
+	fs.StringVar(&intakeAddr, "intake-address", "intake.antimetal.com:443",
+		"The address of the cloud inventory intake service")
+	fs.StringVar(&intakeAPIKey, "intake-api-key", "",
+		"The API key to use upload resources",
+	)
+	fs.BoolVar(&intakeSecure, "intake-secure", true,
+		"Use secure connection to the Antimetal intake service",
+	)
+	fs.StringVar(&intakeTLSCertFile, "intake-tls-cert-file", "",
+		"The client certificate file to use for mTLS authentication with the intake service. "+
+			"The certificate is reloaded when the file changes",
+	)
+	fs.StringVar(&intakeTLSKeyFile, "intake-tls-key-file", "",
+		"The client key file to use for mTLS authentication with the intake service",
+	)
+	fs.StringVar(&intakeTLSCAFile, "intake-tls-ca-file", "",
+		"The CA bundle used to verify the intake service, e.g. the CA of a TLS-intercepting proxy. "+
+			"Defaults to the system roots",
+	)
+	fs.StringVar(&intakeTLSMinVersion, "intake-tls-min-version", "1.2",
+		"The minimum TLS version of the connection to the intake service, 1.2 or 1.3",
+	)
+	fs.StringVar(&intakeTLSServerName, "intake-tls-server-name", "",
+		"The name the intake service's certificate is verified against. Defaults to the host of "+
+			"intake-address",
+	)
+	fs.Func("intake-tls-pinned-san",
+		"Pin the intake service's certificate to a subject alternative name, e.g. a SPIFFE ID such as "+
+			"spiffe://example.org/intake or a DNS name. The certificate must present at least one of "+
+			"the pinned names. Can be repeated",
+		func(san string) error {
+			intakeTLSPinnedSANs = append(intakeTLSPinnedSANs, san)
+			return nil
+		})
+	fs.Func("intake-labels",
+		"Comma-separated key=value labels, e.g. customer=acme,env=prod, sent to the intake service "+
+			"when a stream is opened and added as tags to every resource and performance snapshot, so "+
+			"that multi-tenant intakes can route and partition data by them. Can be repeated",
+		func(s string) error {
+			labels, err := enrich.ParseTags(s)
+			if err != nil {
+				return err
+			}
+			maps.Copy(intakeLabels, labels)
+			return nil
+		})
+	fs.StringVar(&intakeLabelsFile, "intake-labels-file", "",
+		"File with key=value intake labels, one per line, read on startup. Labels set with "+
+			"intake-labels override those of the file")
+}
+
+// runFlags registers the flags of the run command
+func runFlags(fs *flag.FlagSet) {
+	intakeFlags(fs)
+	fs.StringVar(&intakeQueueDir, "intake-queue-dir", "",
+		"Persist the deltas waiting to be sent to the intake service to this directory, so that "+
+			"they survive restarts during intake outages. The queue is encrypted with the resource "+
+			"inventory encryption key, if set. If empty, the deltas are kept in memory")
+	fs.StringVar(&metricsAddr, "metrics-bind-address", ":8080",
+		"The address the metric endpoint binds to. Set this to '0' to disable the metrics server")
+	fs.BoolVar(&metricsSecure, "metrics-secure", false,
+		"If set the metrics endpoint is served securely")
+	fs.StringVar(&metricsCertDir, "metrics-cert-dir", "",
+		"The directory where the metrics server TLS certificates are stored.",
+	)
+	fs.StringVar(&metricsCertName, "metrics-cert-name", "",
+		"The name of the TLS certificate file for the metrics server.",
+	)
+	fs.StringVar(&metricsKeyName, "metrics-key-name", "",
+		"The name of the TLS key file for the metrics server.",
+	)
+	fs.StringVar(&probeAddr, "health-probe-bind-address", ":8081",
+		"The address the probe endpoint binds to. Set this to '0' to disable the metrics server")
+	fs.BoolVar(&enableLeaderElection, "leader-elect", true,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+	fs.BoolVar(&enableHTTP2, "enable-http2", false,
+		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	fs.BoolVar(&enableK8sController, "enable-kubernetes-controller", true,
+		"Enable Kubernetes cluster snapshot collector")
+	fs.BoolVar(&standalone, "standalone", false,
+		"Run on a host without Kubernetes. The agent doesn't connect to an API server, disabling "+
+			"leader election and the Kubernetes controller, image inventory, storage topology and "+
+			"connection map, and indexes the host, its systemd services and its long-running "+
+			"processes instead")
+	fs.StringVar(&hostMountValidation, "host-mount-validation", "warn",
+		"What to do when the agent runs in a container and the host's /proc, /sys or /dev aren't "+
+			"mounted at the host paths, so it would observe its own container: warn, fail or off")
+	fs.StringVar(&k8sWatchedTypes, "kubernetes-watched-types", "",
+		"Comma separated list of the types the Kubernetes controller watches, "+
+			"all of them if empty. Available types: "+strings.Join(k8sagent.WatchableTypes(), ", "))
+	fs.StringVar(&kubernetesProvider, "kubernetes-provider", "kind", "The Kubernetes provider")
+	fs.StringVar(&kubernetesRegion, "kubernetes-region", "",
+		"The region of the cluster, set on resources without a region if the Kubernetes provider "+
+			"doesn't know it. Defaults to the region of the EC2 instance the agent runs on in AWS")
+	fs.StringVar(&eksAccountID, "kubernetes-provider-eks-account-id", "",
+		"The AWS account ID the EKS cluster is deployed in")
+	fs.StringVar(&eksRegion, "kubernetes-provider-eks-region", "",
+		"The AWS region the EKS cluster is deployed in")
+	fs.StringVar(&eksClusterName, "kubernetes-provider-eks-cluster-name", "",
+		"The name of the EKS cluster")
+	fs.BoolVar(&eksAutodiscover, "kubernetes-provider-eks-autodiscover", true,
+		"Autodiscover EKS cluster name. Disabled on nodes detected to run in another cloud")
+	fs.StringVar(&k8sClusterName, "cluster-name", "",
+		"The name of the cluster. If empty, the Kubernetes provider is asked for it, falling back "+
+			"to cluster-name-namespace-uid-fallback and cluster-name-file")
+	fs.BoolVar(&k8sClusterNameUID, "cluster-name-namespace-uid-fallback", true,
+		"Name the cluster after the UID of its kube-system namespace if the Kubernetes provider "+
+			"can't name it")
+	fs.StringVar(&k8sClusterNameFile, "cluster-name-file", "",
+		"File holding the name of the cluster, read if neither the Kubernetes provider nor the "+
+			"kube-system namespace can name it")
+	fs.DurationVar(&maxStreamAge, "max-stream-age", 10*time.Minute,
+		"Maximum age of an intake stream before it is rotated to a new one")
+	fs.StringVar(&pprofAddr, "pprof-address", "0",
+		"The address the pprof server binds to. Set this to '0' to disable the pprof server")
+	fs.StringVar(&debugAddr, "debug-bind-address", "0",
+		"The address the debug endpoint binds to. It serves the performance history at "+
+			performanceHistoryPath+" and the open files, sockets and memory maps of a process at "+
+			processInspectPath+"?pid=<pid>, and collects diagnostic bundles on a POST to "+
+			debugBundlePath+" if debug-bundle-dir is set. Set this to '0' to disable the debug server")
+	fs.StringVar(&debugBundleDir, "debug-bundle-dir", "",
+		"Save on-demand diagnostic bundles to this directory: a fresh collection of all performance "+
+			"collectors, goroutine and heap dumps, resource inventory statistics and the recent logs. "+
+			"Bundles are requested by setting or changing the "+diag.TriggerAnnotation+" annotation "+
+			"of the agent's pod, which requires the POD_NAME and POD_NAMESPACE environment variables, "+
+			"or through the debug server. If empty, bundles are disabled")
+	fs.DurationVar(&debugBundleTriggerInterval, "debug-bundle-trigger-interval", diag.DefaultTriggerInterval,
+		"How often the annotations of the agent's pod are checked for diagnostic bundle requests")
+	fs.StringVar(&storeDataDir, "store-data-dir", "",
+		"Persist the resource inventory to this directory. If empty, the inventory is kept in memory")
+	fs.StringVar(&storeEncryptionKeyFile, "store-encryption-key-file", "",
+		"File containing the raw or base64 encoded 16, 24 or 32 byte AES key used to encrypt the "+
+			"resource inventory at rest. If empty, the key is read from the "+store.EncryptionKeyEnv+
+			" environment variable. Encryption is disabled if neither is set")
+	fs.StringVar(&storePreviousEncryptionKeyFile, "store-previous-encryption-key-file", "",
+		"File containing the previous resource inventory encryption key. "+
+			"If set, a store encrypted with this key is re-encrypted with the current key on startup")
+	fs.DurationVar(&storeDataKeyRotation, "store-data-key-rotation", 0,
+		"How often the resource inventory data encryption keys are rotated. Defaults to 10 days")
+	fs.Int64Var(&storeSizeBudget, "store-size-budget", 0,
+		"Maximum size in bytes of the resource inventory. When exceeded, large resources are "+
+			"compressed and then the oldest relationships are evicted. 0 means unlimited")
+	fs.DurationVar(&storeSubscriberStallTimeout, "store-subscriber-stall-timeout", time.Minute,
+		"Log a warning when a resource inventory subscriber hasn't received an event for this long. "+
+			"0 disables the warning")
+	fs.StringVar(&storeCodec, "store-codec", string(store.CodecProto),
+		"Encoding of the resources persisted in the inventory: proto, proto+zstd to trade CPU for size, "+
+			"or json to debug the raw contents of --store-data-dir. Can be changed between restarts")
+	fs.StringVar(&storeReplicaBindAddr, "store-replica-bind-address", "",
+		"Address the elected agent serves the resource inventory on over gRPC, e.g. :8090, for the "+
+			"other agents to read the cluster-scoped resources from with --store-replica-address. "+
+			"The service is unencrypted and read only. Empty disables it")
+	fs.StringVar(&storeReplicaAddr, "store-replica-address", "",
+		"gRPC target of the resource inventory of the elected agent, e.g. the DNS name of a headless "+
+			"Service selecting the pods of the agent: dns:///antimetal-agent-store.antimetal-system:8090. "+
+			"Agents that aren't elected read the cluster-scoped resources from it rather than keep a copy "+
+			"of their own. Empty disables it")
+	fs.BoolVar(&enableImageInventory, "enable-image-inventory", false,
+		"Index the container images present on the node the agent runs on. Requires access to the "+
+			"container runtime's CRI socket and the NODE_NAME environment variable")
+	fs.StringVar(&criEndpoint, "cri-endpoint", cri.DefaultEndpoint,
+		"The CRI endpoint of the node's container runtime")
+	fs.DurationVar(&imageInventoryInterval, "image-inventory-interval", 5*time.Minute,
+		"How often the container images on the node are indexed")
+	fs.BoolVar(&enableStorageTopology, "enable-storage-topology", false,
+		"Index the disks, partitions and filesystems of the node the agent runs on and relate them "+
+			"to the PersistentVolumes mounted from them. Requires the host's /proc, /sys and /dev and the "+
+			"NODE_NAME environment variable")
+	fs.DurationVar(&storageTopologyInterval, "storage-topology-interval", 5*time.Minute,
+		"How often the storage of the node is indexed")
+	fs.BoolVar(&enableConnectionMap, "enable-connection-map", false,
+		"Map the TCP connections of the processes of the node the agent runs on to other processes "+
+			"and remote endpoints, joining their sockets with the host's TCP tables. Requires the host's "+
+			"/proc, the host PID namespace and the NODE_NAME environment variable")
+	fs.DurationVar(&connectionMapInterval, "connection-map-interval", time.Minute,
+		"How often the connections of the node are mapped")
+	fs.BoolVar(&enableListenerInventory, "enable-listener-inventory", false,
+		"Index the listening TCP and UDP sockets of the node the agent runs on with their owning "+
+			"processes and bind addresses. Requires the host's /proc, the host PID namespace and the "+
+			"NODE_NAME environment variable")
+	fs.DurationVar(&listenerInventoryInterval, "listener-inventory-interval", time.Minute,
+		"How often the listening sockets of the node are indexed")
+	fs.BoolVar(&enableNUMATopology, "enable-numa-topology", false,
+		"Index the NUMA nodes of the node the agent runs on and the NUMA affinity and local CPUs of "+
+			"its PCI devices. Requires the host's /sys and the NODE_NAME environment variable")
+	fs.DurationVar(&numaTopologyInterval, "numa-topology-interval", 10*time.Minute,
+		"How often the NUMA topology of the node is indexed")
+	fs.DurationVar(&hotplugInterval, "hotplug-interval", hotplug.DefaultInterval,
+		"How often the online CPUs and memory of the node are checked, so that the NUMA topology "+
+			"is indexed again as soon as CPUs or memory are hot-plugged. 0 disables the check")
+	fs.BoolVar(&enableNodeLeaseMonitor, "enable-node-lease-monitor", false,
+		"Watch the heartbeat Leases of the cluster's kubelets and record nodes that stop renewing "+
+			"them as silent, before the node lifecycle controller marks them NotReady")
+	fs.DurationVar(&nodeLeaseStaleAfter, "node-lease-stale-after", 0,
+		"How long a node lease goes without renewal before its node is silent. 0 uses half the "+
+			"lease duration, two missed kubelet renewals")
+	fs.BoolVar(&enablePodStartupLatency, "enable-pod-startup-latency", false,
+		"Break down how long the cluster's pods take to be ready into scheduling, initialization, "+
+			"starting and readiness, from their status transitions, and export it as the "+
+			"antimetal_k8s_pod_startup_duration_seconds metric and a PodStartup resource per pod")
+	fs.BoolVar(&enableContainerOOMEvents, "enable-container-oom-events", false,
+		"Record every OOM kill logged by the kernel collector as a ContainerOOM resource, with the "+
+			"pod and container of the killed process from the container runtime and the OOM kill "+
+			"count of its cgroup")
+	fs.BoolVar(&enableFileIntegrity, "enable-file-integrity", false,
+		"Watch the critical configuration files of the node with inotify and record every change "+
+			"to them with the SHA-256 hashes of their contents, detecting drift and tampering")
+	fs.StringVar(&fileIntegrityPaths, "file-integrity-paths", strings.Join(integrity.DefaultPaths, ","),
+		"Comma separated list of files and directories watched by enable-file-integrity, as "+
+			"mounted in the agent. Directories are watched recursively")
+	fs.DurationVar(&fileIntegrityResync, "file-integrity-resync", 10*time.Minute,
+		"How often every watched file is hashed again, catching changes inotify missed")
+	fs.DurationVar(&hostInventoryInterval, "host-inventory-interval", time.Minute,
+		"How often the host, its services and its processes are indexed in standalone mode")
+	fs.DurationVar(&hostInventoryMinProcessAge, "host-inventory-min-process-age", 5*time.Minute,
+		"How long a process runs before it is indexed in standalone mode, leaving out "+
+			"short-lived commands")
+	fs.BoolVar(&enableCloudInventory, "enable-cloud-inventory", false,
+		"Index the EC2 instances, EBS volumes and load balancers of the cluster and relate them "+
+			"to its Nodes. Uses the kubernetes-provider-eks-* flags to determine the account, region "+
+			"and cluster")
+	fs.DurationVar(&cloudInventoryInterval, "cloud-inventory-interval", 5*time.Minute,
+		"How often the cloud resources are indexed")
+	fs.BoolVar(&enablePerformanceHistory, "enable-performance-history", false,
+		"Periodically collect performance snapshots and keep them locally for post-incident analysis")
+	fs.StringVar(&performanceHistoryDir, "performance-history-dir", "",
+		"Persist the performance history to this directory. If empty, the history is kept in memory")
+	fs.DurationVar(&performanceHistoryRetention, "performance-history-retention", history.DefaultRetention,
+		"How long performance snapshots are kept")
+	fs.DurationVar(&performanceHistoryInterval, "performance-history-interval", 15*time.Second,
+		"How often a performance snapshot is collected")
+	fs.StringVar(&performanceHistoryRollups, "performance-history-rollups", history.DefaultRollups,
+		"Comma separated list of resolution:retention rollup tiers of a persisted performance "+
+			"history, e.g. 1m:168h. Each tier keeps the minimum, maximum and average of every metric "+
+			"over each resolution interval, along with its last snapshot, for its retention, so weeks "+
+			"of history fit on disk. Ignored if the history is kept in memory")
+	fs.StringVar(&performanceStateDir, "performance-state-dir", "",
+		"Persist the last counters of rate computing collectors to this directory, so that rates "+
+			"are computed right after an agent restart. If empty, the first collection after a "+
+			"restart has no rates")
+	fs.Float64Var(&burstCPUThreshold, "burst-cpu-threshold", 0,
+		"CPU utilization percentage of a performance snapshot above which the cpu and process "+
+			"collectors are sampled every burst-interval for burst-duration, capturing spikes "+
+			"shorter than performance-history-interval. 0 disables bursts")
+	fs.DurationVar(&burstInterval, "burst-interval", performance.DefaultBurstInterval,
+		"How often the cpu and process collectors are sampled during a burst")
+	fs.DurationVar(&burstDuration, "burst-duration", performance.DefaultBurstDuration,
+		"How long a burst lasts")
+	fs.DurationVar(&heartbeatInterval, "heartbeat-interval", time.Minute,
+		"How often the agent's Heartbeat resource is updated, with the last successful collection "+
+			"of each performance collector. 0 disables the heartbeat")
+	fs.Float64Var(&noisyNeighborStealThreshold, "noisy-neighbor-steal-threshold", 10,
+		"CPU steal percentage above which a virtualized node has a noisy neighbor. A "+
+			rules.NoisyNeighborRule+" alert fires once the steal stays above it for "+
+			"noisy-neighbor-duration. Evaluated with the alert rules, when alert-rule or "+
+			"enable-performance-history is set. 0 disables the alert")
+	fs.DurationVar(&noisyNeighborDuration, "noisy-neighbor-duration", 5*time.Minute,
+		"How long the CPU steal must stay above noisy-neighbor-steal-threshold before the "+
+			rules.NoisyNeighborRule+" alert fires")
+	fs.Float64Var(&dirtyPressureThreshold, "dirty-pressure-threshold", 80,
+		"Percentage of the dirty threshold (vm.dirty_ratio or vm.dirty_bytes) above which the dirty "+
+			"and writeback page cache throttles writers. A "+rules.DirtyPressureRule+" alert fires "+
+			"once it stays above it for memory-pressure-duration. Evaluated with the alert rules, "+
+			"when alert-rule or enable-performance-history is set. 0 disables the alert")
+	fs.Float64Var(&reclaimStallThreshold, "reclaim-stall-threshold", 10,
+		"Allocations per second stalling in direct reclaim above which a "+rules.ReclaimStallRule+
+			" alert fires once they stay above it for memory-pressure-duration. 0 disables the alert")
+	fs.Float64Var(&ioStallThreshold, "io-stall-threshold", 20,
+		"Percentage of time all non-idle tasks stall on IO, from the pressure stall information, "+
+			"above which an "+rules.IOStallRule+" alert fires once it stays above it for "+
+			"memory-pressure-duration. 0 disables the alert")
+	fs.DurationVar(&memoryPressureDuration, "memory-pressure-duration", 2*time.Minute,
+		"How long the memory and IO pressure must stay above dirty-pressure-threshold, "+
+			"reclaim-stall-threshold or io-stall-threshold before their alert fires")
+	fs.Func("alert-rule",
+		"Alert rule evaluated against every performance snapshot on the node, written as "+
+			"\"[<name>:] <metric> <op> <threshold>[%] [for <duration>]\", e.g. "+
+			"\"low-memory: mem available < 5% for 2m\". Firing and resolved alerts are logged and "+
+			"indexed as Alert resources, so basic alerting works without the upstream analytics. "+
+			"Snapshots are collected every performance-history-interval. Available metrics: "+
+			strings.Join(rules.Metrics(), ", ")+". Can be repeated",
+		func(expr string) error {
+			rule, err := rules.Parse(expr)
+			if err != nil {
+				return err
+			}
+			alertRules = append(alertRules, rule)
+			return nil
+		})
+	fs.BoolVar(&enableRedaction, "enable-redaction", true,
+		"Redact sensitive data from Kubernetes resources before they are uploaded and from kernel "+
+			"log messages of the performance history: annotations with secret-like keys and "+
+			"secret-like strings such as private keys, access keys, tokens and passwords")
+	fs.BoolVar(&redactEnvVars, "redact-env-vars", true,
+		"Redact the values of all environment variables set inline in container specs. If false, "+
+			"only their secret-like strings are redacted")
+	fs.Func("redact-annotation-pattern",
+		"Regular expression matching the keys of annotations whose values are redacted, in addition "+
+			"to the defaults. Can be repeated",
+		func(pattern string) error {
+			redactAnnotationPatterns = append(redactAnnotationPatterns, pattern)
+			return nil
+		})
+	fs.Func("redact-secret-pattern",
+		"Regular expression matching secret-like strings that are redacted, in addition to the "+
+			"defaults. If it has a subexpression named \"secret\" only that part of the match is "+
+			"redacted. Can be repeated",
+		func(pattern string) error {
+			redactSecretPatterns = append(redactSecretPatterns, pattern)
+			return nil
+		})
+	fs.StringVar(&tagsFile, "tags-file", "",
+		"File with key=value tags, one per line, added to every resource and performance snapshot, "+
+			"e.g. the node's datacenter, rack or team")
+	fs.StringVar(&tagsEnvPrefix, "tags-env-prefix", "",
+		"Add the environment variables starting with this prefix as tags to every resource and "+
+			"performance snapshot. The tag key is the rest of the variable name in lower case")
+	fs.BoolVar(&tagsFromEC2, "tags-from-ec2", false,
+		"Add the tags of the EC2 instance the agent runs on to every resource and performance "+
+			"snapshot. Requires access to tags in the instance metadata")
+	fs.DurationVar(&tagsRefreshInterval, "tags-refresh-interval", enrich.DefaultRefreshInterval,
+		"How often tags are reloaded from their sources")
+	fs.Int64Var(&memoryBudget, "memory-budget", 0,
+		"Soft memory limit of the agent in bytes. The garbage collector works harder as the agent "+
+			"approaches it, rather than letting it grow until it is OOM-killed. The GOMEMLIMIT "+
+			"environment variable takes precedence. 0 means unlimited")
+	fs.Float64Var(&cpuBudget, "cpu-budget", 0,
+		"Average number of cores the agent may use, e.g. 0.25. The agent runs on at most that many "+
+			"cores rounded up, and performance collections are delayed while it is over budget. "+
+			"0 means unlimited")
+	fs.StringVar(&tracingEndpoint, "tracing-endpoint", "",
+		"Export OpenTelemetry traces of performance collections, resource inventory writes and "+
+			"intake sends to this OTLP/HTTP endpoint, e.g. localhost:4318. If empty, tracing is disabled")
+	fs.BoolVar(&tracingInsecure, "tracing-insecure", false,
+		"Export traces over plain HTTP instead of HTTPS")
+	fs.Float64Var(&tracingSampleRatio, "tracing-sample-ratio", 0.1,
+		"Fraction of the traces exported, between 0 and 1")
+	collectorSelectionFlags(fs)
+}
+
+const (
+	performanceHistoryPath = "/debug/performance/snapshots"
+	collectorStatusPath    = "/debug/performance/collectors"
+	processInspectPath     = "/debug/process"
+	debugBundlePath        = "/debug/bundle"
+	failpointsPath         = "/debug/failpoints"
+)
+
+// runAgent runs the agent until ctx is done
+func runAgent(ctx context.Context, _ []string) error {
+	setupLog.Info("starting agent", version.Get().KeysAndValues()...)
+
+	// Setup self-imposed resource budgets
+	if limit := budget.SetMemoryLimit(memoryBudget); limit != math.MaxInt64 {
+		setupLog.Info("memory budget set", "bytes", limit)
+	}
+	var cpuThrottle performance.Throttle
+	if cpuBudget > 0 {
+		cpu := budget.NewCPU(cpuBudget)
+		setupLog.Info("CPU budget set", "cores", cpu.Cores(), "gomaxprocs", runtime.GOMAXPROCS(0))
+		cpuThrottle = cpu
+	}
+
+	// Export traces before anything starts spans
+	if tracingEndpoint != "" {
+		hostname, _ := nodeName()
+		shutdown, err := tracing.Setup(ctx, tracing.Options{
+			Endpoint:    tracingEndpoint,
+			Insecure:    tracingInsecure,
+			SampleRatio: tracingSampleRatio,
+			Attributes: map[string]string{
+				"service.version": version.Get().Version,
+				"host.name":       hostname,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to set up tracing: %w", err)
+		}
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				setupLog.Error(err, "failed to flush traces")
+			}
+		}()
+		setupLog.Info("tracing enabled", "endpoint", tracingEndpoint, "sampleRatio", tracingSampleRatio)
+	}
+
+	// Check that the agent observes the host rather than its own container
+	if err := validateHostMounts(); err != nil {
+		return fmt.Errorf("invalid host mounts: %w", err)
+	}
+
+	// if the enable-http2 flag is false (the default), http/2 should be disabled
+	// due to its vulnerabilities. More specifically, disabling http/2 will
+	// prevent from being vulnerable to the HTTP/2 Stream Cancelation and
+	// Rapid Reset CVEs. For more information see:
+	// - https://github.com/advisories/GHSA-qppj-fm5r-hxr3
+	// - https://github.com/advisories/GHSA-4374-p667-p6c8
+	disableHTTP2 := func(c *tls.Config) {
+		setupLog.Info("disabling http/2")
+		c.NextProtos = []string{"http/1.1"}
+	}
+
+	tlsOpts := []func(*tls.Config){}
+	if !enableHTTP2 {
+		tlsOpts = append(tlsOpts, disableHTTP2)
+	}
+
+	metricsServerOpts := metricsserver.Options{
+		BindAddress:   metricsAddr,
+		SecureServing: metricsSecure,
+		TLSOpts:       tlsOpts,
+	}
+	if metricsSecure {
+		// Authenticating and authorizing metrics requests needs the API server
+		if !standalone {
+			metricsServerOpts.FilterProvider = filters.WithAuthenticationAndAuthorization
+		}
+
+		// NOTE: If CertDir, CertName, and KeyName are empty, controller-runtime will
+		// automatically generate self-signed certificates for the metrics server. While convenient for
+		// development and testing, this setup is not recommended for production.
+		metricsServerOpts.CertDir = metricsCertDir
+		metricsServerOpts.CertName = metricsCertName
+		metricsServerOpts.KeyName = metricsKeyName
+	}
+
+	// Without Kubernetes the manager only runs the agent's runnables. Its client is never
+	// used, so it gets an empty config rather than failing to find a kubeconfig.
+	restConfig := &rest.Config{}
+	if standalone {
+		setupLog.Info("running standalone, Kubernetes features are disabled")
+		enableLeaderElection = false
+		enableK8sController = false
+		enableImageInventory = false
+		enableStorageTopology = false
+		enableConnectionMap = false
+		enableListenerInventory = false
+		enableNUMATopology = false
+		enableNodeLeaseMonitor = false
+		enablePodStartupLatency = false
+		storeReplicaBindAddr = ""
+		storeReplicaAddr = ""
+	} else {
+		restConfig = ctrl.GetConfigOrDie()
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                 scheme.Get(),
+		Metrics:                metricsServerOpts,
+		HealthProbeBindAddress: probeAddr,
+		PprofBindAddress:       pprofAddr,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "4927b366.antimetal.com",
+		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
+		// when the Manager ends. This requires the binary to immediately end when the
+		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
+		// speeds up voluntary leader transitions as the new leader don't have to wait
+		// LeaseDuration time first.
+		//
+		// In the default scaffold provided, the program ends immediately after
+		// the manager stops, so would be fine to enable this option. However,
+		// if you are doing or is intended to do any operation such as perform cleanups
+		// after the manager stops then its usage might be unsafe.
+		// LeaderElectionReleaseOnCancel: true,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to start manager: %w", err)
+	}
+	if crashHandler != nil {
+		mgr = crashSafeManager{mgr}
+		crashHandler.AddSection("version", func() any { return version.Get() })
+	}
+
+	// Shared resources
+	storeOpts := []store.Option{
+		store.WithDataDir(storeDataDir),
+		store.WithDataKeyRotation(storeDataKeyRotation),
+		store.WithSizeBudget(storeSizeBudget),
+		store.WithLogger(mgr.GetLogger().WithName("store")),
+		store.WithSubscriberStallTimeout(storeSubscriberStallTimeout),
+		store.WithCodec(store.Codec(storeCodec)),
+	}
+	encryptionKey, err := store.LoadEncryptionKey(storeEncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load resource inventory encryption key: %w", err)
+	}
+	if encryptionKey != nil {
+		storeOpts = append(storeOpts, store.WithEncryptionKey(encryptionKey))
+	}
+	if storePreviousEncryptionKeyFile != "" {
+		prevKey, err := store.LoadEncryptionKey(storePreviousEncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load previous resource inventory encryption key: %w", err)
+		}
+		storeOpts = append(storeOpts, store.WithPreviousEncryptionKey(prevKey))
+	}
+	rsrcStore, err := store.New(storeOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to create resource inventory: %w", err)
+	}
+	if err := mgr.Add(rsrcStore); err != nil {
+		return fmt.Errorf("unable to register resource inventory: %w", err)
+	}
+
+	// Setup the store replica: the elected agent serves the cluster-scoped resources it
+	// indexes to the others, which read them through readStore
+	var readStore resource.Store = rsrcStore
+	if storeReplicaBindAddr != "" {
+		replicaServer := replica.NewServer(rsrcStore)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return replicaServer.ListenAndServe(ctx, storeReplicaBindAddr)
+		})); err != nil {
+			return fmt.Errorf("unable to register store replica server: %w", err)
+		}
+	}
+	if storeReplicaAddr != "" {
+		replicaConn, err := grpc.NewClient(storeReplicaAddr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultServiceConfig(replica.ServiceConfig),
+		)
+		if err != nil {
+			return fmt.Errorf("unable to connect to store replica: %w", err)
+		}
+		defer replicaConn.Close()
+		elected := mgr.Elected()
+		readStore = replica.NewClient(rsrcStore, replicaConn, k8sagent.WatchableTypeNames(),
+			replica.WithLeader(func() bool {
+				select {
+				case <-elected:
+					return true
+				default:
+					return false
+				}
+			}),
+		)
+	}
+
+	intakeConn, err := newIntakeConn()
+	if err != nil {
+		return fmt.Errorf("unable to connect to cloud inventory service: %w", err)
+	}
+
+	var redaction *redact.Policy
+	if enableRedaction {
+		redaction, err = redact.NewPolicy(redactEnvVars,
+			slices.Concat(redact.DefaultAnnotationPatterns, redactAnnotationPatterns),
+			slices.Concat(redact.DefaultSecretPatterns, redactSecretPatterns),
+		)
+		if err != nil {
+			return fmt.Errorf("unable to create redaction policy: %w", err)
+		}
+	}
+
+	labels, err := loadIntakeLabels(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to load intake labels: %w", err)
+	}
+	enricher, err := newEnricher(ctx, setupLog.WithName("tags"), labels)
+	if err != nil {
+		return fmt.Errorf("unable to set up tags: %w", err)
+	}
+	var tags func() map[string]string
+	if enricher != nil {
+		tags = enricher.Tags
+		if err := enricher.Refresh(ctx); err != nil {
+			setupLog.Error(err, "unable to load tags")
+		}
+		if err := mgr.Add(everyReplica{manager.RunnableFunc(enricher.Start)}); err != nil {
+			return fmt.Errorf("unable to register tags: %w", err)
+		}
+	}
+
+	// Setup Intake Worker. The capability manifest is built once every component is set
+	// up, before the manager starts the worker.
+	var manifest intake.Manifest
+	intakeOpts := []intake.WorkerOpts{
+		intake.WithLogger(mgr.GetLogger().WithName("intake-worker")),
+		intake.WithGRPCConn(intakeConn),
+		intake.WithAPIKey(intakeAPIKey),
+		intake.WithMaxStreamAge(maxStreamAge),
+		intake.WithLabels(labels),
+		intake.WithManifest(func() intake.Manifest { return manifest }),
+	}
+	if redaction != nil {
+		intakeOpts = append(intakeOpts, intake.WithRedactionPolicy(redaction))
+	}
+	if tags != nil {
+		intakeOpts = append(intakeOpts, intake.WithTags(tags))
+	}
+	if intakeQueueDir != "" {
+		queue, err := intake.OpenQueue(intakeQueueDir, encryptionKey)
+		if err != nil {
+			return fmt.Errorf("unable to open intake queue: %w", err)
+		}
+		defer queue.Close()
+		intakeOpts = append(intakeOpts, intake.WithQueue(queue))
+	}
+	intakeWorker, err := intake.NewWorker(rsrcStore, intakeOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to create intake worker: %w", err)
+	}
+	if err := mgr.Add(intakeWorker); err != nil {
+		return fmt.Errorf("unable to register intake worker: %w", err)
+	}
+
+	// EKS autodiscovery looks up the instance metadata, which only times out on other clouds
+	env := performance.DetectEnvironment(hostProcPath(), hostSysPath())
+	if eksAutodiscover && env.Cloud != "" && env.Cloud != performance.CloudAWS {
+		setupLog.Info("disabling EKS autodiscovery outside of AWS", "cloud", env.Cloud)
+		eksAutodiscover = false
+	}
+
+	var provider cluster.Provider
+	if enableK8sController || enableImageInventory || enableStorageTopology || enableConnectionMap ||
+		enableListenerInventory || enableNUMATopology || enableNodeLeaseMonitor || enablePodStartupLatency {
+		providerOpts := getProviderOptions(setupLog.WithName("cluster-provider"))
+		provider, err = cluster.GetProvider(ctx, kubernetesProvider, providerOpts)
+		if err != nil {
+			return fmt.Errorf("unable to determine cluster provider: %w", err)
+		}
+	}
+
+	// Setup Kubernetes Collector Controller
+	if enableK8sController {
+		region := kubernetesRegion
+		// The EKS provider already looks up the region of the instance
+		if region == "" && env.Cloud == performance.CloudAWS && kubernetesProvider != cluster.ProviderEKS {
+			if region, err = instanceRegion(ctx); err != nil {
+				setupLog.Error(err, "unable to look up the region of the instance")
+			}
+		}
+		ctrl := &k8sagent.Controller{
+			Provider:     provider,
+			Store:        rsrcStore,
+			WatchedTypes: splitList(k8sWatchedTypes),
+			Region:       region,
+		}
+		if err := ctrl.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create K8sCollector controller: %w", err)
+		}
+	}
+
+	// Setup container image inventory
+	if enableImageInventory {
+		runtime, err := cri.NewClient(criEndpoint)
+		if err != nil {
+			return fmt.Errorf("unable to connect to container runtime: %w", err)
+		}
+		defer runtime.Close()
+		images := &k8sagent.ImageInventory{
+			Runtime:  runtime,
+			Provider: provider,
+			Store:    rsrcStore,
+			NodeName: os.Getenv("NODE_NAME"),
+			Interval: imageInventoryInterval,
+		}
+		if err := images.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create image inventory: %w", err)
+		}
+	}
+
+	// Setup node storage topology
+	if enableStorageTopology {
+		storage := &k8sagent.StorageInventory{
+			Provider:     provider,
+			Store:        rsrcStore,
+			NodeName:     os.Getenv("NODE_NAME"),
+			HostProcPath: hostProcPath(),
+			HostSysPath:  hostSysPath(),
+			HostDevPath:  hostDevPath(),
+			Interval:     storageTopologyInterval,
+		}
+		if err := storage.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create storage topology: %w", err)
+		}
+	}
+
+	// Setup process connection map
+	if enableConnectionMap {
+		connections := &k8sagent.ConnectionInventory{
+			Provider:     provider,
+			Store:        rsrcStore,
+			NodeName:     os.Getenv("NODE_NAME"),
+			HostProcPath: hostProcPath(),
+			Interval:     connectionMapInterval,
+		}
+		if err := connections.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create connection map: %w", err)
+		}
+	}
+
+	// Setup listening services inventory
+	if enableListenerInventory {
+		listeners := &k8sagent.ListenerInventory{
+			Provider:     provider,
+			Store:        rsrcStore,
+			NodeName:     os.Getenv("NODE_NAME"),
+			HostProcPath: hostProcPath(),
+			Interval:     listenerInventoryInterval,
+		}
+		if err := listeners.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create listener inventory: %w", err)
+		}
+	}
+
+	// Watch CPU and memory hotplug
+	var hotplugWatcher *hotplug.Watcher
+	if hotplugInterval > 0 {
+		hotplugWatcher, err = hotplug.NewWatcher(mgr.GetLogger().WithName("hotplug"),
+			hostProcPath(), hostSysPath(), hotplugInterval)
+		if err != nil {
+			setupLog.Info("CPU and memory hotplug won't be followed", "reason", err.Error())
+		} else if err := mgr.Add(everyReplica{hotplugWatcher}); err != nil {
+			return fmt.Errorf("unable to add hotplug watcher: %w", err)
+		}
+	}
+
+	// Setup NUMA topology inventory
+	if enableNUMATopology {
+		numa := &k8sagent.NUMAInventory{
+			Provider:    provider,
+			Store:       rsrcStore,
+			NodeName:    os.Getenv("NODE_NAME"),
+			HostSysPath: hostSysPath(),
+			Interval:    numaTopologyInterval,
+			Hotplug:     hotplugWatcher,
+		}
+		if err := numa.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create NUMA topology inventory: %w", err)
+		}
+	}
+
+	// Setup node lease monitor
+	if enableNodeLeaseMonitor {
+		leases := &nodelease.Monitor{
+			Store:      rsrcStore,
+			Provider:   provider,
+			StaleAfter: nodeLeaseStaleAfter,
+		}
+		if err := leases.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create node lease monitor: %w", err)
+		}
+	}
+
+	// Setup pod startup latency tracker
+	if enablePodStartupLatency {
+		tracker := &podlatency.Tracker{
+			Store:    rsrcStore,
+			Provider: provider,
+		}
+		if err := tracker.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create pod startup latency tracker: %w", err)
+		}
+	}
+
+	// Setup file integrity watch
+	if enableFileIntegrity {
+		name, err := nodeName()
+		if err != nil {
+			return fmt.Errorf("unable to determine node name: %w", err)
+		}
+		watcher := &integrity.Watcher{
+			Store:    rsrcStore,
+			NodeName: name,
+			Paths:    splitList(fileIntegrityPaths),
+			Resync:   fileIntegrityResync,
+		}
+		if err := watcher.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create file integrity watcher: %w", err)
+		}
+	}
+
+	// Setup host inventory
+	if standalone {
+		name, err := nodeName()
+		if err != nil {
+			return fmt.Errorf("unable to determine host name: %w", err)
+		}
+		hostInventory := &host.Inventory{
+			Store:         rsrcStore,
+			HostName:      name,
+			HostProcPath:  hostProcPath(),
+			Environment:   env,
+			Interval:      hostInventoryInterval,
+			MinProcessAge: hostInventoryMinProcessAge,
+		}
+		if err := hostInventory.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create host inventory: %w", err)
+		}
+	}
+
+	// Setup cloud resource inventory
+	if enableCloudInventory {
+		awsProvider, err := newAWSCloudProvider(ctx, setupLog.WithName("cloud-provider"))
+		if err != nil {
+			return fmt.Errorf("unable to create AWS cloud provider: %w", err)
+		}
+		cloudInventory := &cloud.Reconciler{
+			Providers: []cloud.Provider{awsProvider},
+			Store:     rsrcStore,
+			Interval:  cloudInventoryInterval,
+		}
+		if err := cloudInventory.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create cloud inventory: %w", err)
+		}
+	}
+
+	// Setup node-local alert rules
+	var alertEngine *rules.Engine
+	var alertWriter *alerts.Writer
+	// Steal is always 0 outside of virtual machines
+	if (len(alertRules) > 0 || enablePerformanceHistory) && env.Virtualized && noisyNeighborStealThreshold > 0 {
+		alertRules = append(alertRules, rules.NoisyNeighbor(noisyNeighborStealThreshold, noisyNeighborDuration))
+	}
+	if len(alertRules) > 0 || enablePerformanceHistory {
+		if dirtyPressureThreshold > 0 {
+			alertRules = append(alertRules, rules.DirtyPressure(dirtyPressureThreshold, memoryPressureDuration))
+		}
+		if reclaimStallThreshold > 0 {
+			alertRules = append(alertRules, rules.ReclaimStall(reclaimStallThreshold, memoryPressureDuration))
+		}
+		if ioStallThreshold > 0 {
+			alertRules = append(alertRules, rules.IOStall(ioStallThreshold, memoryPressureDuration))
+		}
+	}
+	if len(alertRules) > 0 {
+		name, err := nodeName()
+		if err != nil {
+			return fmt.Errorf("unable to determine node name: %w", err)
+		}
+		alertEngine = rules.NewEngine(alertRules)
+		alertWriter = &alerts.Writer{
+			Store:    rsrcStore,
+			NodeName: name,
+			Provider: provider,
+			Logger:   mgr.GetLogger().WithName("alerts"),
+		}
+	}
+
+	// Setup the policy deciding what is kept of the arguments of processes
+	var argsOpts []argpolicy.Option
+	if enableK8sController {
+		if cgroups, err := cgroup.NewReader(hostSysPath()); err != nil {
+			setupLog.Error(err, "unable to read cgroups, namespaces of process-args-rule won't match")
+		} else {
+			namespaces := &podNamespaces{store: readStore, cgroups: cgroups}
+			argsOpts = append(argsOpts, argpolicy.WithNamespaceResolver(namespaces.Namespace))
+		}
+	}
+	argsPolicy, err := newArgsPolicy(argsOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to create process arguments policy: %w", err)
+	}
+
+	// Setup container OOM events, correlated from the kernel messages of the snapshots
+	var oomWriter *oom.Writer
+	if enableContainerOOMEvents {
+		name, err := nodeName()
+		if err != nil {
+			return fmt.Errorf("unable to determine node name: %w", err)
+		}
+		var runtime oom.ContainerRuntime
+		if client, err := cri.NewClient(criEndpoint); err != nil {
+			setupLog.Error(err, "unable to connect to container runtime, OOM kills won't name their container")
+		} else {
+			defer client.Close()
+			runtime = client
+		}
+		cgroups, err := cgroup.NewReader(hostSysPath())
+		if err != nil {
+			setupLog.Error(err, "unable to read cgroups, OOM kills won't count the kills of their cgroup")
+			cgroups = nil
+		}
+		oomWriter = &oom.Writer{
+			Correlator: oom.NewCorrelator(setupLog.WithName("oom"), runtime, cgroups),
+			Store:      rsrcStore,
+			NodeName:   name,
+			Provider:   provider,
+		}
+	}
+
+	// Setup performance history and the collection of snapshots alert rules are evaluated
+	// on and OOM kills are correlated from
+	var perfHistory *history.History
+	var perfMgr *performance.Manager
+	var collectorStatus *performance.StatusHandler
+	var lastSnapshot atomic.Pointer[performance.Snapshot]
+	if enablePerformanceHistory || alertEngine != nil || oomWriter != nil {
+		if enablePerformanceHistory {
+			historyOpts := []history.Option{
+				history.WithDataDir(performanceHistoryDir),
+				history.WithRetention(performanceHistoryRetention),
+			}
+			if performanceHistoryDir != "" {
+				tiers, err := history.ParseTiers(performanceHistoryRollups)
+				if err != nil {
+					return fmt.Errorf("invalid performance-history-rollups: %w", err)
+				}
+				historyOpts = append(historyOpts, history.WithRollups(tiers...))
+			}
+			perfHistory, err = history.New(historyOpts...)
+			if err != nil {
+				return fmt.Errorf("unable to create performance history: %w", err)
+			}
+			defer perfHistory.Close()
+		}
+
+		historyLog := setupLog.WithName("performance-history")
+		var failed map[performance.MetricType]error
+		perfMgr, failed, err = newCollectorManager(performance.ManagerOptions{
+			Config: performance.CollectionConfig{
+				Interval:       performanceHistoryInterval,
+				ArgsPolicy:     argsPolicy,
+				BurstThreshold: burstCPUThreshold,
+				BurstInterval:  burstInterval,
+				BurstDuration:  burstDuration,
+			},
+			Throttle: cpuThrottle,
+			StateDir: performanceStateDir,
+			Tags:     tags,
+			OnSnapshot: func(snapshot *performance.Snapshot) {
+				if alertEngine != nil {
+					for _, alert := range alertEngine.Evaluate(snapshot) {
+						if err := alertWriter.Write(ctx, alert); err != nil {
+							alertWriter.Logger.Error(err, "unable to write alert", "rule", alert.Rule.Name)
+						}
+					}
+				}
+				if oomWriter != nil {
+					if err := oomWriter.OnSnapshot(ctx, snapshot); err != nil {
+						setupLog.Error(err, "unable to write OOM kills")
+					}
+				}
+				if redaction != nil {
+					redaction.Snapshot(snapshot)
+				}
+				if perfHistory != nil {
+					if err := perfHistory.Add(snapshot); err != nil {
+						historyLog.Error(err, "unable to store performance snapshot")
+					}
+				}
+				lastSnapshot.Store(snapshot)
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create performance collectors: %w", err)
+		}
+		crashHandler.AddSection("collectors", func() any { return perfMgr.LastSuccessfulCollections() })
+		crashHandler.AddSection("snapshot", func() any {
+			if snapshot := lastSnapshot.Load(); snapshot != nil {
+				return history.NewRecord(snapshot)
+			}
+			return nil
+		})
+		for metricType, err := range failed {
+			msg := "performance collector unavailable"
+			if errors.Is(err, performance.ErrCollectorDisabled) {
+				msg = "performance collector disabled"
+			}
+			setupLog.Info(msg, "collector", metricType, "reason", err.Error())
+		}
+		collectorStatus = checkCollectorSecurity(perfMgr, failed)
+		if err := mgr.Add(everyReplica{perfMgr}); err != nil {
+			return fmt.Errorf("unable to register performance collectors: %w", err)
+		}
+	}
+
+	// Setup heartbeat
+	if heartbeatInterval > 0 {
+		name, err := nodeName()
+		if err != nil {
+			return fmt.Errorf("unable to determine node name: %w", err)
+		}
+		beat := &heartbeat.Heartbeat{
+			Store:    rsrcStore,
+			NodeName: name,
+			Provider: provider,
+			Interval: heartbeatInterval,
+		}
+		if perfMgr != nil {
+			beat.Collections = perfMgr.LastSuccessfulCollections
+		}
+		if err := beat.SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create heartbeat: %w", err)
+		}
+	}
+
+	// Setup on-demand diagnostic bundles
+	var bundler *diag.Bundler
+	if debugBundleDir != "" {
+		bundler, err = diag.NewBundler(diag.Options{Dir: debugBundleDir, Logs: crashHandler.Logs})
+		if err != nil {
+			return fmt.Errorf("unable to create diagnostic bundler: %w", err)
+		}
+		bundler.AddSection("version", func(context.Context) (any, error) { return version.Get(), nil })
+		bundler.AddSection("runtime", func(context.Context) (any, error) { return runtimeStats(), nil })
+		bundler.AddSection("store", func(context.Context) (any, error) { return storeStats(rsrcStore) })
+		bundler.AddSection("snapshot", func(ctx context.Context) (any, error) {
+			snapshotMgr := perfMgr
+			if snapshotMgr == nil {
+				// Without the performance history, the collectors are created for each bundle
+				var err error
+				if snapshotMgr, _, err = newCollectorManager(performance.ManagerOptions{}); err != nil {
+					return nil, err
+				}
+			}
+			snapshot := snapshotMgr.CollectSnapshot(ctx)
+			if redaction != nil {
+				redaction.Snapshot(snapshot)
+			}
+			return history.NewRecord(snapshot), nil
+		})
+		if perfMgr != nil {
+			bundler.AddSection("collectors", func(context.Context) (any, error) {
+				return perfMgr.LastSuccessfulCollections(), nil
+			})
+		}
+		if alertEngine != nil {
+			bundler.AddSection("alerts", func(context.Context) (any, error) { return alertEngine.Firing(), nil })
+		}
+
+		podName, podNamespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+		if podName != "" && podNamespace != "" && !standalone {
+			reader := mgr.GetAPIReader()
+			trigger := &diag.Trigger{
+				Bundler: bundler,
+				Annotations: func(ctx context.Context) (map[string]string, error) {
+					pod := &corev1.Pod{}
+					key := types.NamespacedName{Namespace: podNamespace, Name: podName}
+					if err := reader.Get(ctx, key, pod); err != nil {
+						return nil, err
+					}
+					return pod.GetAnnotations(), nil
+				},
+				Interval: debugBundleTriggerInterval,
+				Logger:   mgr.GetLogger().WithName("debug-bundle"),
+			}
+			if err := mgr.Add(trigger); err != nil {
+				return fmt.Errorf("unable to register diagnostic bundle trigger: %w", err)
+			}
+		} else {
+			setupLog.Info("POD_NAME or POD_NAMESPACE not set, diagnostic bundles can't be requested with a pod annotation")
+		}
+	}
+
+	if debugAddr != "0" {
+		mux := http.NewServeMux()
+		if perfHistory != nil {
+			mux.Handle(performanceHistoryPath, perfHistory)
+		}
+		if collectorStatus != nil {
+			mux.Handle(collectorStatusPath, collectorStatus)
+		}
+		if bundler != nil {
+			mux.Handle(debugBundlePath, bundler)
+		}
+		inspector, err := process.NewInspector(hostProcPath(), process.WithArgsPolicy(argsPolicy))
+		if err != nil {
+			return fmt.Errorf("unable to create process inspector: %w", err)
+		}
+		mux.Handle(processInspectPath, inspector)
+		if failpointsEnabled {
+			mux.Handle(failpointsPath, failpoint.Handler())
+		}
+		if err := mgr.Add(everyReplica{debugServer(debugAddr, mux)}); err != nil {
+			return fmt.Errorf("unable to register debug server: %w", err)
+		}
+	}
+
+	manifestName, _ := nodeName()
+	manifest = capabilityManifest(manifestName, perfMgr)
+	setupLog.Info("capability manifest", "features", manifest.Features, "metricTypes", manifest.MetricTypes)
+
+	// Final setup and start Manager
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		return fmt.Errorf("unable to set up health check: %w", err)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		return fmt.Errorf("unable to set up ready check: %w", err)
+	}
+
+	setupLog.Info("starting manager")
+	if err := mgr.Start(ctx); err != nil {
+		return fmt.Errorf("problem running manager: %w", err)
+	}
+	return nil
+}
+
+// nodeName returns the name of the node the agent runs on, from NODE_NAME or the hostname
+func nodeName() (string, error) {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// hostProcPath returns the path to the host's /proc, from HOST_PROC or host-proc-path
+func hostProcPath() string {
+	if path := os.Getenv("HOST_PROC"); path != "" {
+		return path
+	}
+	return collectorOpts.hostProcPath
+}
+
+// hostSysPath returns the path to the host's /sys, from HOST_SYS or host-sys-path
+func hostSysPath() string {
+	if path := os.Getenv("HOST_SYS"); path != "" {
+		return path
+	}
+	return collectorOpts.hostSysPath
+}
+
+// hostDevPath returns the path to the host's /dev, from HOST_DEV or host-dev-path
+func hostDevPath() string {
+	if path := os.Getenv("HOST_DEV"); path != "" {
+		return path
+	}
+	return collectorOpts.hostDevPath
+}
+
+// validateHostMounts validates the host paths according to host-mount-validation when the
+// agent runs in a container. Problems are logged, and returned if they are fatal.
+func validateHostMounts() error {
+	switch hostMountValidation {
+	case "off":
+		return nil
+	case "warn", "fail":
+	default:
+		return fmt.Errorf("invalid host-mount-validation %q, expected warn, fail or off", hostMountValidation)
+	}
+	if !performance.InContainer("/", "/proc") {
+		return nil
+	}
+	errs := performance.ValidateHostMounts("/proc", hostProcPath(), hostSysPath(), hostDevPath())
+	for _, err := range errs {
+		setupLog.Error(err, "the agent is observing its own container rather than the host")
+	}
+	if len(errs) > 0 && hostMountValidation == "fail" {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// checkCollectorSecurity logs the capabilities and seccomp mode of the agent, and how to
+// give the collectors of perfMgr what they need but aren't allowed. failed are the
+// collectors that weren't registered; the disabled ones aren't checked. It returns the
+// status of the collectors for the debug server.
+func checkCollectorSecurity(perfMgr *performance.Manager, failed map[performance.MetricType]error) *performance.StatusHandler {
+	status := &performance.StatusHandler{
+		Manager:     perfMgr,
+		Unavailable: failed,
+		BPF:         loader.Shared(perfMgr.GetConfig().HostSysPath),
+	}
+	security, err := performance.ReadSecurity("/proc")
+	if err != nil {
+		setupLog.Error(err, "unable to read the capabilities of the agent")
+		return status
+	}
+	status.Security = security
+	setupLog.Info("agent security context", "uid", security.UID,
+		"capabilities", security.Capabilities.Names(), "seccomp", security.Seccomp)
+
+	enabled := maps.Clone(perfMgr.GetConfig().EnabledCollectors)
+	for metricType, err := range failed {
+		if errors.Is(err, performance.ErrCollectorDisabled) {
+			delete(enabled, metricType)
+		}
+	}
+	status.Findings = performance.CheckSecurity(hostProcPath(), security, enabled)
+	for _, finding := range status.Findings {
+		setupLog.Info("performance collector lacks permissions", "collector", finding.Collector,
+			"hint", finding.Hint)
+	}
+	return status
+}
+
+// everyReplica runs a node local runnable on every agent replica rather than only on the
+// elected leader
+type everyReplica struct {
+	manager.Runnable
+}
+
+func (everyReplica) NeedLeaderElection() bool {
+	return false
+}
+
+// crashSafeManager writes a crash bundle when one of its runnables panics. Runnables run
+// in goroutines of their own, which the recover of the command doesn't cover.
+type crashSafeManager struct {
+	manager.Manager
+}
+
+func (m crashSafeManager) Add(r manager.Runnable) error {
+	needLeaderElection := true
+	if l, ok := r.(manager.LeaderElectionRunnable); ok {
+		needLeaderElection = l.NeedLeaderElection()
+	}
+	return m.Manager.Add(crashSafeRunnable{Runnable: r, needLeaderElection: needLeaderElection})
+}
+
+type crashSafeRunnable struct {
+	manager.Runnable
+	needLeaderElection bool
+}
+
+func (r crashSafeRunnable) Start(ctx context.Context) error {
+	defer crashHandler.Recover()
+	return r.Runnable.Start(ctx)
+}
+
+func (r crashSafeRunnable) NeedLeaderElection() bool {
+	return r.needLeaderElection
+}
+
+// debugServer serves handler on addr until the manager stops
+func debugServer(addr string, handler http.Handler) manager.Runnable {
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		srv := &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+
+		setupLog.Info("starting debug server", "address", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+}
+
+// runtimeStats returns the goroutine count and memory statistics of the agent
+func runtimeStats() map[string]any {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"memory":     mem,
+	}
+}
+
+// storeStats returns the number of resources of each type and of relationships in the
+// resource inventory
+func storeStats(inv resource.Store) (map[string]any, error) {
+	rsrcs, err := inv.ListResources(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resources: %w", err)
+	}
+	byType := make(map[string]int)
+	for _, rsrc := range rsrcs {
+		byType[rsrc.GetType().GetType()]++
+	}
+	rels, err := inv.ListRelationships()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list relationships: %w", err)
+	}
+	return map[string]any{
+		"resources":       len(rsrcs),
+		"resourcesByType": byType,
+		"relationships":   len(rels),
+	}, nil
+}
+
+// instanceRegion returns the region of the EC2 instance the agent runs on from the
+// instance metadata
+func instanceRegion(ctx context.Context) (string, error) {
+	client, err := pkgaws.NewClient(pkgaws.WithAutoDiscovery(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to create AWS client: %w", err)
+	}
+	return client.GetRegion(ctx)
+}
+
+// loadIntakeLabels returns the labels of the intake-labels-file and intake-labels flags
+func loadIntakeLabels(ctx context.Context) (map[string]string, error) {
+	labels := make(map[string]string)
+	if intakeLabelsFile != "" {
+		fileLabels, err := enrich.FileSource{Path: intakeLabelsFile}.Tags(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", intakeLabelsFile, err)
+		}
+		maps.Copy(labels, fileLabels)
+	}
+	maps.Copy(labels, intakeLabels)
+	return labels, nil
+}
+
+// newEnricher returns an enricher loading tags from the sources set by the tags-* flags and
+// the intake labels, nil if there are none. The tags of later sources override those of
+// earlier ones: the file, then the environment, then the EC2 instance tags, then the
+// intake labels.
+func newEnricher(ctx context.Context, logger logr.Logger, labels map[string]string) (*enrich.Enricher, error) {
+	var sources []enrich.Source
+	if tagsFile != "" {
+		sources = append(sources, enrich.FileSource{Path: tagsFile})
+	}
+	if tagsEnvPrefix != "" {
+		sources = append(sources, enrich.EnvSource{Prefix: tagsEnvPrefix})
+	}
+	if tagsFromEC2 {
+		client, err := pkgaws.NewClient(pkgaws.WithLogger(logger), pkgaws.WithAutoDiscovery(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS client: %w", err)
+		}
+		sources = append(sources, enrich.EC2Source{Client: client})
+	}
+	if len(labels) > 0 {
+		sources = append(sources, enrich.StaticSource(labels))
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	return enrich.NewEnricher(logger, tagsRefreshInterval, sources...), nil
+}
+
+func getProviderOptions(logger logr.Logger) cluster.ProviderOptions {
+	return cluster.ProviderOptions{
+		Logger: logger,
+		EKS: cluster.EKSOptions{
+			Autodiscover: eksAutodiscover,
+			AccountID:    eksAccountID,
+			Region:       eksRegion,
+			ClusterName:  eksClusterName,
+		},
+		ClusterName:          k8sClusterName,
+		NamespaceUIDFallback: k8sClusterNameUID,
+		ClusterNameFile:      k8sClusterNameFile,
+	}
+}
+
+// newAWSCloudProvider returns a cloud provider discovering the EC2 instances and EBS volumes
+// of the EKS cluster the agent runs in. The account, region and cluster name are taken from
+// the kubernetes-provider-eks-* flags or autodiscovered. If the cluster name can't be
+// determined, all the resources of the region are discovered.
+func newAWSCloudProvider(ctx context.Context, logger logr.Logger) (*cloudaws.Provider, error) {
+	clientOpts := []pkgaws.ClientOption{pkgaws.WithLogger(logger)}
+	if eksAccountID != "" {
+		clientOpts = append(clientOpts, pkgaws.WithAccountID(eksAccountID))
+	}
+	if eksRegion != "" {
+		clientOpts = append(clientOpts, pkgaws.WithRegion(eksRegion))
+	}
+	if eksClusterName != "" {
+		clientOpts = append(clientOpts, pkgaws.WithEKSClusterName(eksClusterName))
+	}
+	if eksAutodiscover {
+		clientOpts = append(clientOpts, pkgaws.WithAutoDiscovery(ctx))
+	}
+	client, err := pkgaws.NewClient(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS client: %w", err)
+	}
+
+	region, err := client.GetRegion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := client.GetAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clusterName, err := client.GetEKSClusterName(ctx)
+	if err != nil {
+		logger.Info("unable to determine EKS cluster name, discovering all resources of the region",
+			"region", region, "error", err)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &cloudaws.Provider{
+		EC2:         ec2.NewFromConfig(cfg),
+		AccountID:   accountID,
+		Region:      region,
+		ClusterName: clusterName,
+		ELB:         elbv2.NewFromConfig(cfg),
+	}, nil
+}
+
+// newIntakeConn returns a client connection to the intake service configured by the
+// intake flags
+func newIntakeConn() (*grpc.ClientConn, error) {
+	var creds credentials.TransportCredentials
+	if intakeSecure {
+		tlsConfig, err := intake.NewTLSConfig(intake.TLSOptions{
+			CertFile:   intakeTLSCertFile,
+			KeyFile:    intakeTLSKeyFile,
+			CAFile:     intakeTLSCAFile,
+			MinVersion: intakeTLSMinVersion,
+			ServerName: intakeTLSServerName,
+			PinnedSANs: intakeTLSPinnedSANs,
+		}, setupLog.WithName("intake-tls"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure intake TLS: %w", err)
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	} else {
+		creds = insecure.NewCredentials()
+	}
+	return grpc.NewClient(intakeAddr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time: 5 * time.Minute,
+		}),
+	)
+}
@@ -0,0 +1,124 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/antimetal/agent/pkg/resource/store"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+var (
+	dumpDataDir           string
+	dumpEncryptionKeyFile string
+)
+
+func storeDumpFlags(fs *flag.FlagSet) {
+	fs.StringVar(&dumpDataDir, "store-data-dir", "",
+		"The data dir of the resource inventory to dump")
+	fs.StringVar(&dumpEncryptionKeyFile, "store-encryption-key-file", "",
+		"File containing the resource inventory encryption key. If empty, the key is read from the "+
+			store.EncryptionKeyEnv+" environment variable")
+}
+
+type storeDump struct {
+	Resources     []dumpedResource  `json:"resources"`
+	Relationships []json.RawMessage `json:"relationships"`
+}
+
+type dumpedResource struct {
+	Resource json.RawMessage `json:"resource"`
+	// Spec is the decoded resource spec, or the raw protobuf bytes if the spec type is unknown
+	Spec any `json:"spec,omitempty"`
+}
+
+func runStoreDump(_ context.Context, _ []string) error {
+	if dumpDataDir == "" {
+		return fmt.Errorf("--store-data-dir is required")
+	}
+
+	opts := []store.Option{store.WithDataDir(dumpDataDir), store.WithReadOnly()}
+	key, err := store.LoadEncryptionKey(dumpEncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		opts = append(opts, store.WithEncryptionKey(key))
+	}
+	inv, err := store.New(opts...)
+	if err != nil {
+		return fmt.Errorf("failed to open resource inventory: %w", err)
+	}
+	defer inv.Close()
+
+	rsrcs, err := inv.ListResources(nil)
+	if err != nil {
+		return err
+	}
+	rels, err := inv.ListRelationships()
+	if err != nil {
+		return fmt.Errorf("failed to list relationships: %w", err)
+	}
+
+	dump := storeDump{
+		Resources:     make([]dumpedResource, 0, len(rsrcs)),
+		Relationships: make([]json.RawMessage, 0, len(rels)),
+	}
+	for _, rsrc := range rsrcs {
+		spec := rsrc.GetSpec()
+		meta := proto.Clone(rsrc).(*resourcev1.Resource)
+		meta.Spec = nil
+		data, err := protojson.Marshal(meta)
+		if err != nil {
+			return fmt.Errorf("failed to marshal resource %s: %w", rsrc.GetMetadata().GetName(), err)
+		}
+		dump.Resources = append(dump.Resources, dumpedResource{
+			Resource: data,
+			Spec:     decodeSpec(spec.GetTypeUrl(), spec.GetValue()),
+		})
+	}
+	for _, rel := range rels {
+		data, err := protojson.Marshal(rel)
+		if err != nil {
+			return fmt.Errorf("failed to marshal relationship: %w", err)
+		}
+		dump.Relationships = append(dump.Relationships, data)
+	}
+
+	return writeJSON(os.Stdout, dump)
+}
+
+// decodeSpec decodes a resource spec. Kubernetes objects are gogo protobuf messages
+// so they're looked up in the gogo registry; anything else is looked up in the
+// protobuf-go registry. Unknown types are returned as raw bytes.
+func decodeSpec(typeURL string, value []byte) any {
+	if typeURL == "" {
+		return nil
+	}
+	if t := gogoproto.MessageType(typeurl.ToName(typeURL)); t != nil && t.Kind() == reflect.Ptr {
+		msg, ok := reflect.New(t.Elem()).Interface().(gogoproto.Message)
+		if ok && gogoproto.Unmarshal(value, msg) == nil {
+			return msg
+		}
+	}
+	if data, err := protojson.Marshal(&anypb.Any{TypeUrl: typeurl.Normalize(typeURL), Value: value}); err == nil {
+		return json.RawMessage(data)
+	}
+	return value
+}
@@ -0,0 +1,35 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/antimetal/agent/internal/version"
+)
+
+var versionJSON bool
+
+func versionFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&versionJSON, "json", false,
+		"Print the build information as JSON")
+}
+
+func runVersion(_ context.Context, _ []string) error {
+	info := version.Get()
+	if versionJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	fmt.Println(info)
+	return nil
+}
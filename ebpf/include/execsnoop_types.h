@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: GPL-2.0-only
+// Copyright Antimetal, Inc. All rights reserved.
+
+#ifndef __EXECSNOOP_TYPES_H
+#define __EXECSNOOP_TYPES_H
+
+#define EXECSNOOP_TASK_COMM_LEN 16
+// Size of a single captured argument, including its NUL terminator
+#define EXECSNOOP_ARG_SIZE 128
+// Maximum number of captured arguments, including the filename
+#define EXECSNOOP_MAX_ARGS 20
+#define EXECSNOOP_ARGS_MAX (EXECSNOOP_MAX_ARGS * EXECSNOOP_ARG_SIZE)
+#define EXECSNOOP_LAST_ARG (EXECSNOOP_ARGS_MAX - EXECSNOOP_ARG_SIZE)
+
+// execsnoop_event is sent to user space for every completed execve call. Only the first
+// args_size bytes of args are sent. The layout is decoded by
+// pkg/performance/collectors/execsnoop and must be kept in sync with it.
+struct execsnoop_event {
+	__u64 timestamp_ns; // bpf_ktime_get_ns() at syscall exit
+	__u32 pid;
+	__u32 ppid;
+	__u32 uid;
+	__s32 ret; // 0, or the negated errno on failure
+	__u32 args_count;
+	__u32 args_size;
+	__u32 truncated; // Arguments were left out or cut short
+	char comm[EXECSNOOP_TASK_COMM_LEN]; // Task name after the exec
+	char args[EXECSNOOP_ARGS_MAX]; // Filename, then argv[1:], each NUL terminated
+};
+
+#endif /* __EXECSNOOP_TYPES_H */
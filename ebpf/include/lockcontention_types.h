@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: GPL-2.0-only
+// Copyright Antimetal, Inc. All rights reserved.
+
+#ifndef __LOCKCONTENTION_TYPES_H
+#define __LOCKCONTENTION_TYPES_H
+
+#define LOCKCONTENTION_TASK_COMM_LEN 16
+#define LOCKCONTENTION_MAX_PROCESSES 10240
+
+// lockcontention_stats are the waits of a process since user space last read them. The
+// layout is decoded by pkg/performance/collectors/lockcontention and must be kept in sync
+// with it.
+struct lockcontention_stats {
+	__u64 futex_waits; // Blocking futex calls that returned
+	__u64 futex_wait_ns;
+	__u64 futex_max_wait_ns;
+	__u64 lock_contentions; // Contended kernel locks that were acquired or given up
+	__u64 lock_wait_ns;
+	__u64 lock_max_wait_ns;
+	char comm[LOCKCONTENTION_TASK_COMM_LEN]; // Of the thread that waited first
+};
+
+#endif /* __LOCKCONTENTION_TYPES_H */
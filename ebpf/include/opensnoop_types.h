@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: GPL-2.0-only
+// Copyright Antimetal, Inc. All rights reserved.
+
+#ifndef __OPENSNOOP_TYPES_H
+#define __OPENSNOOP_TYPES_H
+
+#define OPENSNOOP_TASK_COMM_LEN 16
+#define OPENSNOOP_PATH_MAX 256
+
+// opensnoop_event is sent to user space for every completed openat call. The layout is
+// decoded by pkg/performance/collectors/opensnoop and must be kept in sync with it.
+struct opensnoop_event {
+	__u64 timestamp_ns; // bpf_ktime_get_ns() at syscall exit
+	__u32 pid;
+	__u32 tid;
+	__u32 uid;
+	__s32 ret; // File descriptor, or the negated errno on failure
+	__s32 flags; // O_* flags passed to openat
+	__u32 mode;
+	char comm[OPENSNOOP_TASK_COMM_LEN];
+	char path[OPENSNOOP_PATH_MAX]; // Truncated, NUL terminated
+};
+
+#endif /* __OPENSNOOP_TYPES_H */
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: GPL-2.0-only
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// execsnoop traces execve(2) calls. The filename and arguments are captured on syscall
+// entry, keyed by thread, and sent to user space with the result on syscall exit.
+
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+
+#include "execsnoop_types.h"
+
+char LICENSE[] SEC("license") = "GPL";
+
+// Set by user space before loading
+const volatile __u32 max_args = EXECSNOOP_MAX_ARGS;
+const volatile __u32 max_args_len = EXECSNOOP_ARGS_MAX;
+const volatile bool filter_uid = false;
+
+static const struct execsnoop_event empty_event = {};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 1024);
+	__type(key, __u32);
+	__type(value, struct execsnoop_event);
+} execs SEC(".maps");
+
+// UIDs whose execs are traced when filter_uid is set
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 64);
+	__type(key, __u32);
+	__type(value, __u8);
+} uids SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, 256 * 1024);
+} events SEC(".maps");
+
+SEC("tracepoint/syscalls/sys_enter_execve")
+int tracepoint__syscalls__sys_enter_execve(struct trace_event_raw_sys_enter *ctx)
+{
+	__u64 pid_tgid = bpf_get_current_pid_tgid();
+	__u32 tid = (__u32)pid_tgid;
+	__u32 uid = (__u32)bpf_get_current_uid_gid();
+	const char **argv = (const char **)ctx->args[1];
+	struct execsnoop_event *event;
+	struct task_struct *task;
+	const char *argp;
+	__u32 size;
+	int ret, i;
+
+	if (filter_uid && !bpf_map_lookup_elem(&uids, &uid))
+		return 0;
+
+	if (bpf_map_update_elem(&execs, &tid, &empty_event, BPF_NOEXIST))
+		return 0;
+	event = bpf_map_lookup_elem(&execs, &tid);
+	if (!event)
+		return 0;
+
+	task = (struct task_struct *)bpf_get_current_task();
+	event->pid = pid_tgid >> 32;
+	event->ppid = BPF_CORE_READ(task, real_parent, tgid);
+	event->uid = uid;
+
+	size = max_args_len < EXECSNOOP_ARG_SIZE ? max_args_len : EXECSNOOP_ARG_SIZE;
+	if (size == 0) {
+		event->truncated = 1;
+		return 0;
+	}
+	ret = bpf_probe_read_user_str(event->args, size, (const char *)ctx->args[0]);
+	if (ret < 0)
+		return 0;
+	if (ret == size)
+		event->truncated = 1;
+	event->args_count = 1;
+	event->args_size = ret;
+
+	for (i = 1; i < EXECSNOOP_MAX_ARGS; i++) {
+		if (bpf_probe_read_user(&argp, sizeof(argp), &argv[i]) || !argp)
+			return 0;
+		if (i >= max_args || event->args_size >= max_args_len ||
+		    event->args_size > EXECSNOOP_LAST_ARG) {
+			event->truncated = 1;
+			return 0;
+		}
+		size = max_args_len - event->args_size;
+		if (size > EXECSNOOP_ARG_SIZE)
+			size = EXECSNOOP_ARG_SIZE;
+		ret = bpf_probe_read_user_str(&event->args[event->args_size], size, argp);
+		if (ret < 0)
+			return 0;
+		// The argument was cut short if it filled the space it was read into
+		if (ret == size)
+			event->truncated = 1;
+		event->args_count++;
+		event->args_size += ret;
+	}
+
+	// More arguments than fit in the event
+	if (!bpf_probe_read_user(&argp, sizeof(argp), &argv[EXECSNOOP_MAX_ARGS]) && argp)
+		event->truncated = 1;
+	return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_execve")
+int tracepoint__syscalls__sys_exit_execve(struct trace_event_raw_sys_exit *ctx)
+{
+	__u32 tid = (__u32)bpf_get_current_pid_tgid();
+	struct execsnoop_event *event;
+	__u32 size;
+
+	event = bpf_map_lookup_elem(&execs, &tid);
+	if (!event)
+		return 0;
+
+	event->timestamp_ns = bpf_ktime_get_ns();
+	event->ret = (__s32)ctx->ret;
+	bpf_get_current_comm(&event->comm, sizeof(event->comm));
+
+	// Drop the event rather than block when user space falls behind
+	size = offsetof(struct execsnoop_event, args) + event->args_size;
+	if (size <= sizeof(*event))
+		bpf_ringbuf_output(&events, event, size, 0);
+
+	bpf_map_delete_elem(&execs, &tid);
+	return 0;
+}
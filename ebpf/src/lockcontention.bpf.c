@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: GPL-2.0-only
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// lockcontention measures how long the threads of each process wait on futexes and, on
+// kernels with the lock tracepoints (5.19+), on contended kernel locks. The start of a
+// wait is saved by thread and its duration added to the stats of the process when it
+// ends. User space reads and deletes the stats of every process on each collection.
+
+#include "vmlinux.h"
+
+#include <bpf/bpf_helpers.h>
+
+#include "lockcontention_types.h"
+
+char LICENSE[] SEC("license") = "GPL";
+
+// Blocking futex operations, from include/uapi/linux/futex.h
+#define FUTEX_WAIT 0
+#define FUTEX_LOCK_PI 6
+#define FUTEX_WAIT_BITSET 9
+#define FUTEX_WAIT_REQUEUE_PI 11
+#define FUTEX_LOCK_PI2 13
+#define FUTEX_PRIVATE_FLAG 128
+#define FUTEX_CLOCK_REALTIME 256
+#define FUTEX_CMD_MASK ~(FUTEX_PRIVATE_FLAG | FUTEX_CLOCK_REALTIME)
+
+// lock_contention_args are the fields of the lock/contention_begin and
+// lock/contention_end tracepoints the programs read. They are declared here rather than
+// taken from vmlinux.h, which lacks them when generated on kernels before 5.19.
+struct lock_contention_args {
+	__u64 common; // Common fields of all tracepoints
+	__u64 lock_addr;
+};
+
+// lock_wait identifies a wait on a kernel lock. Spinlocks contended in interrupts
+// interrupt the waits of the current thread, so waits are keyed by lock too.
+struct lock_wait {
+	__u32 tid;
+	__u32 pad;
+	__u64 lock_addr;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 10240);
+	__type(key, __u32);
+	__type(value, __u64);
+} futex_start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 10240);
+	__type(key, struct lock_wait);
+	__type(value, __u64);
+} lock_start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, LOCKCONTENTION_MAX_PROCESSES);
+	__type(key, __u32);
+	__type(value, struct lockcontention_stats);
+} stats SEC(".maps");
+
+// process_stats returns the stats of the process tgid, creating them on its first wait
+static __always_inline struct lockcontention_stats *process_stats(__u32 tgid)
+{
+	struct lockcontention_stats *s;
+
+	s = bpf_map_lookup_elem(&stats, &tgid);
+	if (s)
+		return s;
+
+	struct lockcontention_stats zero = {};
+	bpf_get_current_comm(&zero.comm, sizeof(zero.comm));
+	// Fails once LOCKCONTENTION_MAX_PROCESSES processes waited since the last read
+	bpf_map_update_elem(&stats, &tgid, &zero, BPF_NOEXIST);
+	return bpf_map_lookup_elem(&stats, &tgid);
+}
+
+SEC("tracepoint/syscalls/sys_enter_futex")
+int tracepoint__syscalls__sys_enter_futex(struct trace_event_raw_sys_enter *ctx)
+{
+	__u32 tid = (__u32)bpf_get_current_pid_tgid();
+	__u64 ts;
+
+	switch ((int)ctx->args[1] & FUTEX_CMD_MASK) {
+	case FUTEX_WAIT:
+	case FUTEX_LOCK_PI:
+	case FUTEX_WAIT_BITSET:
+	case FUTEX_WAIT_REQUEUE_PI:
+	case FUTEX_LOCK_PI2:
+		ts = bpf_ktime_get_ns();
+		bpf_map_update_elem(&futex_start, &tid, &ts, BPF_ANY);
+	}
+	return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_futex")
+int tracepoint__syscalls__sys_exit_futex(struct trace_event_raw_sys_exit *ctx)
+{
+	__u64 pid_tgid = bpf_get_current_pid_tgid();
+	__u32 tid = (__u32)pid_tgid;
+	struct lockcontention_stats *s;
+	__u64 *start, wait_ns;
+
+	start = bpf_map_lookup_elem(&futex_start, &tid);
+	if (!start)
+		return 0;
+	wait_ns = bpf_ktime_get_ns() - *start;
+	bpf_map_delete_elem(&futex_start, &tid);
+
+	s = process_stats(pid_tgid >> 32);
+	if (!s)
+		return 0;
+	__sync_fetch_and_add(&s->futex_waits, 1);
+	__sync_fetch_and_add(&s->futex_wait_ns, wait_ns);
+	// Racy, but only concurrent waits of the same process can lose a maximum
+	if (wait_ns > s->futex_max_wait_ns)
+		s->futex_max_wait_ns = wait_ns;
+	return 0;
+}
+
+SEC("tracepoint/lock/contention_begin")
+int tracepoint__lock__contention_begin(struct lock_contention_args *ctx)
+{
+	struct lock_wait key = {
+		.tid = (__u32)bpf_get_current_pid_tgid(),
+		.lock_addr = ctx->lock_addr,
+	};
+	__u64 ts = bpf_ktime_get_ns();
+
+	// Sleeping locks begin contending again after spinning, the wait starts at the first
+	bpf_map_update_elem(&lock_start, &key, &ts, BPF_NOEXIST);
+	return 0;
+}
+
+SEC("tracepoint/lock/contention_end")
+int tracepoint__lock__contention_end(struct lock_contention_args *ctx)
+{
+	__u64 pid_tgid = bpf_get_current_pid_tgid();
+	struct lock_wait key = {
+		.tid = (__u32)pid_tgid,
+		.lock_addr = ctx->lock_addr,
+	};
+	struct lockcontention_stats *s;
+	__u64 *start, wait_ns;
+
+	start = bpf_map_lookup_elem(&lock_start, &key);
+	if (!start)
+		return 0;
+	wait_ns = bpf_ktime_get_ns() - *start;
+	bpf_map_delete_elem(&lock_start, &key);
+
+	s = process_stats(pid_tgid >> 32);
+	if (!s)
+		return 0;
+	__sync_fetch_and_add(&s->lock_contentions, 1);
+	__sync_fetch_and_add(&s->lock_wait_ns, wait_ns);
+	if (wait_ns > s->lock_max_wait_ns)
+		s->lock_max_wait_ns = wait_ns;
+	return 0;
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: GPL-2.0-only
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// opensnoop traces openat(2) calls. The arguments are saved on syscall entry, keyed by
+// thread, and sent to user space with the result on syscall exit.
+
+#include "vmlinux.h"
+
+#include <bpf/bpf_helpers.h>
+
+#include "opensnoop_types.h"
+
+char LICENSE[] SEC("license") = "GPL";
+
+struct open_args {
+	const char *path;
+	__s32 flags;
+	__u32 mode;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 10240);
+	__type(key, __u32);
+	__type(value, struct open_args);
+} start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, 256 * 1024);
+} events SEC(".maps");
+
+SEC("tracepoint/syscalls/sys_enter_openat")
+int tracepoint__syscalls__sys_enter_openat(struct trace_event_raw_sys_enter *ctx)
+{
+	__u32 tid = (__u32)bpf_get_current_pid_tgid();
+	struct open_args args = {
+		.path = (const char *)ctx->args[1],
+		.flags = (__s32)ctx->args[2],
+		.mode = (__u32)ctx->args[3],
+	};
+
+	bpf_map_update_elem(&start, &tid, &args, BPF_ANY);
+	return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_openat")
+int tracepoint__syscalls__sys_exit_openat(struct trace_event_raw_sys_exit *ctx)
+{
+	__u64 pid_tgid = bpf_get_current_pid_tgid();
+	__u32 tid = (__u32)pid_tgid;
+	struct open_args *args;
+	struct opensnoop_event *event;
+
+	args = bpf_map_lookup_elem(&start, &tid);
+	if (!args)
+		return 0;
+
+	// Drop the event rather than block when user space falls behind
+	event = bpf_ringbuf_reserve(&events, sizeof(*event), 0);
+	if (!event)
+		goto cleanup;
+
+	event->timestamp_ns = bpf_ktime_get_ns();
+	event->pid = pid_tgid >> 32;
+	event->tid = tid;
+	event->uid = (__u32)bpf_get_current_uid_gid();
+	event->ret = (__s32)ctx->ret;
+	event->flags = args->flags;
+	event->mode = args->mode;
+	bpf_get_current_comm(&event->comm, sizeof(event->comm));
+	bpf_probe_read_user_str(&event->path, sizeof(event->path), args->path);
+
+	bpf_ringbuf_submit(event, 0);
+
+cleanup:
+	bpf_map_delete_elem(&start, &tid);
+	return 0;
+}
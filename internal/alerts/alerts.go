@@ -0,0 +1,134 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package alerts writes the alerts of the node-local rules engine to the resource store,
+// from which the intake ships them like any other resource.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/pkg/performance/rules"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// ResourceType is the resource type of alerts. There is no generated message for it; its
+// spec is a google.protobuf.Struct.
+const ResourceType = "antimetal.agent.v1.Alert"
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+// Writer upserts an Alert resource for each rule and metric instance of the node when its
+// alert fires or resolves. Firing alerts are critical events so that the intake sends
+// them ahead of everything else.
+//
+// The spec of the resource is a google.protobuf.Struct with the rule, metric, op,
+// threshold and for duration of the rule, the instance of the metric, the state of the
+// alert, the value of the metric, since when the condition held, the timestamp of the
+// state change and the nodeName.
+type Writer struct {
+	Store    resource.Store
+	NodeName string
+	// Provider namespaces the alerts to the cluster. Optional.
+	Provider cluster.Provider
+	Logger   logr.Logger
+
+	mu        sync.Mutex
+	namespace *resourcev1.Namespace
+}
+
+// Write writes alert to the store
+func (w *Writer) Write(ctx context.Context, alert rules.Alert) error {
+	w.Logger.Info("Alert "+string(alert.State),
+		"rule", alert.Rule.Name, "instance", alert.Instance, "value", alert.Value, "since", alert.Since)
+
+	namespace, err := w.clusterNamespace(ctx)
+	if err != nil {
+		return err
+	}
+	spec, err := structpb.NewStruct(map[string]any{
+		"rule":      alert.Rule.Name,
+		"metric":    alert.Rule.Metric,
+		"op":        alert.Rule.Op,
+		"threshold": alert.Rule.Threshold,
+		"for":       alert.Rule.For.String(),
+		"instance":  alert.Instance,
+		"state":     string(alert.State),
+		"value":     alert.Value,
+		"since":     alert.Since.UTC().Format(time.RFC3339Nano),
+		"timestamp": alert.Time.UTC().Format(time.RFC3339Nano),
+		"nodeName":  w.NodeName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create alert spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert spec: %w", err)
+	}
+
+	name := w.NodeName + "/" + alert.Rule.Name
+	if alert.Instance != "" {
+		name += "/" + alert.Instance
+	}
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: name,
+			Name:       name,
+			Namespace:  namespace,
+		},
+		Spec: specAny,
+	}
+	if w.Provider != nil {
+		rsrc.Metadata.Provider = resourcev1.Provider_PROVIDER_KUBERNETES
+	}
+	var opts []resource.WriteOption
+	if alert.State == rules.StateFiring {
+		opts = append(opts, resource.WithEventClass(resource.EventClassCritical))
+	}
+	if err := w.Store.UpdateResource(rsrc, opts...); err != nil {
+		return fmt.Errorf("failed to update alert in inventory: %w", err)
+	}
+	return nil
+}
+
+// clusterNamespace returns the namespace of the cluster, looked up on the first alert
+func (w *Writer) clusterNamespace(ctx context.Context) (*resourcev1.Namespace, error) {
+	if w.Provider == nil {
+		return nil, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.namespace != nil {
+		return w.namespace, nil
+	}
+	clusterName, err := w.Provider.ClusterName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	w.namespace = &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{
+				Cluster: clusterName,
+			},
+		},
+	}
+	return w.namespace, nil
+}
@@ -0,0 +1,93 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package alerts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/pkg/performance/rules"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+func TestWriter_Write(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+	events := inv.Subscribe(nil, resource.WithoutInitialList())
+
+	rule, err := rules.Parse("full-disk: disk util > 95% for 5m")
+	if err != nil {
+		t.Fatalf("failed to parse rule: %v", err)
+	}
+	w := &Writer{Store: inv, NodeName: "node-1", Logger: logr.Discard()}
+	since := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	alert := rules.Alert{
+		Rule:     rule,
+		Instance: "nvme0n1",
+		State:    rules.StateFiring,
+		Value:    99.5,
+		Since:    since,
+		Time:     since.Add(5 * time.Minute),
+	}
+	if err := w.Write(context.Background(), alert); err != nil {
+		t.Fatalf("failed to write alert: %v", err)
+	}
+
+	get := func() map[string]any {
+		t.Helper()
+		rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "node-1/full-disk/nvme0n1"})
+		if err != nil {
+			t.Fatalf("failed to get alert: %v", err)
+		}
+		spec := &structpb.Struct{}
+		if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+			t.Fatalf("failed to unmarshal alert spec: %v", err)
+		}
+		return spec.AsMap()
+	}
+	spec := get()
+	if spec["state"] != "firing" || spec["value"] != 99.5 || spec["metric"] != "disk util" {
+		t.Errorf("unexpected spec of firing alert: %v", spec)
+	}
+	if spec["since"] != "2026-01-01T12:00:00Z" || spec["timestamp"] != "2026-01-01T12:05:00Z" {
+		t.Errorf("unexpected times of firing alert: %v", spec)
+	}
+	select {
+	case e := <-events:
+		if e.Class != resource.EventClassCritical {
+			t.Errorf("expected a critical event for a firing alert, got %s", e.Class)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an event for the firing alert")
+	}
+
+	alert.State = rules.StateResolved
+	alert.Value = 40
+	if err := w.Write(context.Background(), alert); err != nil {
+		t.Fatalf("failed to write alert: %v", err)
+	}
+	if spec := get(); spec["state"] != "resolved" || spec["value"] != 40.0 {
+		t.Errorf("unexpected spec of resolved alert: %v", spec)
+	}
+	select {
+	case e := <-events:
+		if e.Class != resource.EventClassNormal {
+			t.Errorf("expected a normal event for a resolved alert, got %s", e.Class)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an event for the resolved alert")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package budget keeps the agent within self-imposed CPU and memory budgets, so that it
+// degrades gracefully on constrained nodes instead of being OOM-killed or taking CPU from
+// the workloads it monitors.
+package budget
+
+import (
+	"context"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// window is the period over which the CPU usage of the agent is averaged
+	window = 10 * time.Second
+	// maxDelay bounds how long a single Wait blocks, so that loops still make progress
+	// when the budget is much lower than what they need
+	maxDelay = 30 * time.Second
+)
+
+// SetMemoryLimit sets the soft memory limit of the Go runtime to limit bytes, making the
+// garbage collector work harder as the agent approaches it. The GOMEMLIMIT environment
+// variable takes precedence. It returns the limit in effect.
+func SetMemoryLimit(limit int64) int64 {
+	if _, ok := os.LookupEnv("GOMEMLIMIT"); ok || limit <= 0 {
+		return debug.SetMemoryLimit(-1)
+	}
+	debug.SetMemoryLimit(limit)
+	return limit
+}
+
+// CPU throttles the loops of the agent to keep its average CPU usage within a number of
+// cores. Loops call Wait before each iteration, which blocks while the agent used more
+// CPU than its budget over the current window, and so run less often when the agent is
+// over budget. CPU time is that of the whole process, so a loop is also throttled by the
+// CPU other parts of the agent use.
+type CPU struct {
+	cores float64
+
+	// for tests
+	now     func() time.Time
+	cpuTime func() (time.Duration, bool)
+
+	mu sync.Mutex
+	// Start of the current window and the CPU time of the process then
+	since    time.Time
+	cpuSince time.Duration
+}
+
+// NewCPU creates a CPU budget of cores, e.g. 0.2 to use at most a fifth of a core on
+// average. GOMAXPROCS is lowered to the budget rounded up, so that the Go runtime doesn't
+// run on more cores at once. Without a way to measure the CPU time of the process on this
+// platform, the budget only limits GOMAXPROCS.
+func NewCPU(cores float64) *CPU {
+	if procs := int(math.Ceil(cores)); procs < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(max(procs, 1))
+	}
+	return newCPU(cores, time.Now, processCPUTime)
+}
+
+func newCPU(cores float64, now func() time.Time, cpuTime func() (time.Duration, bool)) *CPU {
+	c := &CPU{cores: cores, now: now, cpuTime: cpuTime}
+	c.since = now()
+	c.cpuSince, _ = cpuTime()
+	return c
+}
+
+// Cores returns the budget in cores
+func (c *CPU) Cores() float64 {
+	return c.cores
+}
+
+// Wait blocks while the agent is over its CPU budget, or until ctx is done
+func (c *CPU) Wait(ctx context.Context) error {
+	delay := c.delay()
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// delay returns how long to wait for the average CPU usage of the current window to get
+// back within the budget, starting a new window once the current one is over
+func (c *CPU) delay() time.Duration {
+	cpu, ok := c.cpuTime()
+	if !ok || c.cores <= 0 {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	elapsed := now.Sub(c.since)
+	used := cpu - c.cpuSince
+	allowed := time.Duration(float64(elapsed) * c.cores)
+	var delay time.Duration
+	if used > allowed {
+		delay = min(time.Duration(float64(used-allowed)/c.cores), maxDelay)
+	}
+	if elapsed >= window {
+		// The delay is spent mostly idle, so the next window starts after it
+		c.since = now.Add(delay)
+		c.cpuSince = cpu
+	}
+	return delay
+}
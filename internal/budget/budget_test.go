@@ -0,0 +1,73 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package budget
+
+import (
+	"context"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+	cpu time.Duration
+}
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+func (f *fakeClock) CPUTime() (time.Duration, bool) { return f.cpu, true }
+
+func TestCPU_Delay(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := newCPU(0.5, clock.Now, clock.CPUTime)
+
+	// Half a core over 4s is 2s of CPU
+	clock.now = clock.now.Add(4 * time.Second)
+	clock.cpu = 2 * time.Second
+	if d := c.delay(); d != 0 {
+		t.Errorf("delay within budget = %v, want 0", d)
+	}
+
+	// 1s over budget takes 2s at half a core to make up for
+	clock.cpu = 3 * time.Second
+	if d := c.delay(); d != 2*time.Second {
+		t.Errorf("delay over budget = %v, want 2s", d)
+	}
+
+	// The window ends, the next one starts after the delay
+	clock.now = clock.now.Add(6 * time.Second)
+	clock.cpu = 30 * time.Second
+	if d := c.delay(); d != maxDelay {
+		t.Errorf("delay far over budget = %v, want %v", d, maxDelay)
+	}
+	clock.now = clock.now.Add(maxDelay + time.Second)
+	clock.cpu += 100 * time.Millisecond
+	if d := c.delay(); d != 0 {
+		t.Errorf("delay in a new window = %v, want 0", d)
+	}
+}
+
+func TestCPU_Wait(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	c := newCPU(1, clock.Now, clock.CPUTime)
+	clock.cpu = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Wait(ctx); err == nil {
+		t.Errorf("Wait() over budget with a canceled context = nil, want an error")
+	}
+}
+
+func TestSetMemoryLimit(t *testing.T) {
+	t.Setenv("GOMEMLIMIT", "")
+	previous := debug.SetMemoryLimit(-1)
+	if got := SetMemoryLimit(64 << 20); got != previous {
+		t.Errorf("SetMemoryLimit() with GOMEMLIMIT set = %d, want %d", got, previous)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/internal/cloud"
+)
+
+// maxTagsResources is how many resources DescribeTags accepts at once
+const maxTagsResources = 20
+
+// ELBAPI is the subset of the Elastic Load Balancing v2 client used to discover load
+// balancers
+type ELBAPI interface {
+	elbv2.DescribeLoadBalancersAPIClient
+	elbv2.DescribeTargetGroupsAPIClient
+	elbv2.DescribeTargetHealthAPIClient
+	DescribeTags(ctx context.Context, params *elbv2.DescribeTagsInput, optFns ...func(*elbv2.Options),
+	) (*elbv2.DescribeTagsOutput, error)
+}
+
+// loadBalancerTargets are the target groups of a load balancer and the instances registered
+// in them
+type loadBalancerTargets struct {
+	groups    []any
+	instances []string
+}
+
+// discoverLoadBalancers adds the application, network and gateway load balancers of the
+// region to inv, related to the discovered instances registered in their target groups.
+// When discovering the instances of a cluster, only the load balancers targeting them are
+// discovered.
+func (p *Provider) discoverLoadBalancers(ctx context.Context, instances map[string]*resourcev1.ResourceRef,
+	inv *cloud.Inventory,
+) error {
+	targets, err := p.loadBalancerTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	var lbs []elbtypes.LoadBalancer
+	paginator := elbv2.NewDescribeLoadBalancersPaginator(p.ELB, &elbv2.DescribeLoadBalancersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to describe load balancers: %w", err)
+		}
+		for _, lb := range page.LoadBalancers {
+			if p.ClusterName != "" && !targetsAny(targets[aws.ToString(lb.LoadBalancerArn)].instances, instances) {
+				continue
+			}
+			lbs = append(lbs, lb)
+		}
+	}
+	if len(lbs) == 0 {
+		return nil
+	}
+
+	arns := make([]string, len(lbs))
+	for i, lb := range lbs {
+		arns[i] = aws.ToString(lb.LoadBalancerArn)
+	}
+	tags, err := p.loadBalancerTags(ctx, arns)
+	if err != nil {
+		return err
+	}
+
+	contains := &k8sv1.Contains{}
+	containedBy := &k8sv1.ContainedBy{}
+	for _, lb := range lbs {
+		arn := aws.ToString(lb.LoadBalancerArn)
+		rsrc, err := p.loadBalancerResource(lb, targets[arn].groups, tags[arn])
+		if err != nil {
+			return err
+		}
+		inv.Resources = append(inv.Resources, rsrc)
+		for _, id := range targets[arn].instances {
+			instanceRef, ok := instances[id]
+			if !ok {
+				continue
+			}
+			rels, err := cloud.RelationshipPair(cloud.ResourceRef(rsrc), instanceRef,
+				contains.ProtoReflect().Type(), containedBy.ProtoReflect().Type())
+			if err != nil {
+				return err
+			}
+			inv.Relationships = append(inv.Relationships, rels...)
+		}
+	}
+	return nil
+}
+
+// loadBalancerTargets returns the target groups of the region by the ARN of the load
+// balancers forwarding to them. Instances registered in several target groups of a load
+// balancer are listed once.
+func (p *Provider) loadBalancerTargets(ctx context.Context) (map[string]loadBalancerTargets, error) {
+	targets := make(map[string]loadBalancerTargets)
+	paginator := elbv2.NewDescribeTargetGroupsPaginator(p.ELB, &elbv2.DescribeTargetGroupsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe target groups: %w", err)
+		}
+		for _, group := range page.TargetGroups {
+			if len(group.LoadBalancerArns) == 0 {
+				continue
+			}
+			health, err := p.ELB.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+				TargetGroupArn: group.TargetGroupArn,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe the health of target group %s: %w",
+					aws.ToString(group.TargetGroupName), err)
+			}
+			var instanceIDs []string
+			healthy := 0
+			for _, desc := range health.TargetHealthDescriptions {
+				if desc.TargetHealth != nil && desc.TargetHealth.State == elbtypes.TargetHealthStateEnumHealthy {
+					healthy++
+				}
+				// Targets of other types are IP addresses, Lambda functions or load balancers
+				if group.TargetType == elbtypes.TargetTypeEnumInstance && desc.Target != nil {
+					instanceIDs = append(instanceIDs, aws.ToString(desc.Target.Id))
+				}
+			}
+			spec := map[string]any{
+				"name":           aws.ToString(group.TargetGroupName),
+				"protocol":       string(group.Protocol),
+				"port":           float64(aws.ToInt32(group.Port)),
+				"targetType":     string(group.TargetType),
+				"targets":        float64(len(health.TargetHealthDescriptions)),
+				"healthyTargets": float64(healthy),
+			}
+			for _, arn := range group.LoadBalancerArns {
+				t := targets[arn]
+				t.groups = append(t.groups, spec)
+				t.instances = append(t.instances, instanceIDs...)
+				targets[arn] = t
+			}
+		}
+	}
+	for arn, t := range targets {
+		slices.Sort(t.instances)
+		t.instances = slices.Compact(t.instances)
+		targets[arn] = t
+	}
+	return targets, nil
+}
+
+// loadBalancerTags returns the tags of the load balancers with arns by ARN
+func (p *Provider) loadBalancerTags(ctx context.Context, arns []string) (map[string][]elbtypes.Tag, error) {
+	tags := make(map[string][]elbtypes.Tag, len(arns))
+	for start := 0; start < len(arns); start += maxTagsResources {
+		out, err := p.ELB.DescribeTags(ctx, &elbv2.DescribeTagsInput{
+			ResourceArns: arns[start:min(start+maxTagsResources, len(arns))],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe load balancer tags: %w", err)
+		}
+		for _, desc := range out.TagDescriptions {
+			tags[aws.ToString(desc.ResourceArn)] = desc.Tags
+		}
+	}
+	return tags, nil
+}
+
+func (p *Provider) loadBalancerResource(lb elbtypes.LoadBalancer, groups []any, tags []elbtypes.Tag,
+) (*resourcev1.Resource, error) {
+	spec, err := loadBalancerSpec(lb, groups)
+	if err != nil {
+		return nil, err
+	}
+	// Load balancers span the zones listed in their spec
+	return p.resource(LoadBalancerResourceType, aws.ToString(lb.LoadBalancerName), "", elbTagsToTags(tags), spec)
+}
+
+func loadBalancerSpec(lb elbtypes.LoadBalancer, groups []any) (*structpb.Struct, error) {
+	zones := make([]any, 0, len(lb.AvailabilityZones))
+	for _, zone := range lb.AvailabilityZones {
+		zones = append(zones, aws.ToString(zone.ZoneName))
+	}
+	if groups == nil {
+		groups = []any{}
+	}
+	fields := map[string]any{
+		"arn":               aws.ToString(lb.LoadBalancerArn),
+		"name":              aws.ToString(lb.LoadBalancerName),
+		"type":              string(lb.Type),
+		"scheme":            string(lb.Scheme),
+		"dnsName":           aws.ToString(lb.DNSName),
+		"vpcId":             aws.ToString(lb.VpcId),
+		"ipAddressType":     string(lb.IpAddressType),
+		"availabilityZones": zones,
+		"targetGroups":      groups,
+	}
+	if lb.State != nil {
+		fields["state"] = string(lb.State.Code)
+	}
+	if lb.CreatedTime != nil {
+		fields["createdTime"] = lb.CreatedTime.UTC().Format(time.RFC3339)
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create load balancer spec: %w", err)
+	}
+	return spec, nil
+}
+
+// targetsAny reports whether any of the instance IDs is a discovered instance
+func targetsAny(instanceIDs []string, instances map[string]*resourcev1.ResourceRef) bool {
+	for _, id := range instanceIDs {
+		if _, ok := instances[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// elbTagsToTags converts load balancer tags to resource tags ordered by key, so that
+// unchanged tags encode to the same bytes
+func elbTagsToTags(elbTags []elbtypes.Tag) []*resourcev1.Tag {
+	tags := make([]*resourcev1.Tag, 0, len(elbTags))
+	for _, tag := range elbTags {
+		tags = append(tags, &resourcev1.Tag{
+			Key:   aws.ToString(tag.Key),
+			Value: aws.ToString(tag.Value),
+		})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Key < tags[j].Key
+	})
+	return tags
+}
@@ -0,0 +1,205 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeELB returns its load balancers and target groups in a single page
+type fakeELB struct {
+	loadBalancers []elbtypes.LoadBalancer
+	targetGroups  []elbtypes.TargetGroup
+	// targets by target group ARN
+	targets map[string][]elbtypes.TargetHealthDescription
+	tags    map[string][]elbtypes.Tag
+	// tagRequests holds the number of ARNs of each DescribeTags call
+	tagRequests []int
+}
+
+func (f *fakeELB) DescribeLoadBalancers(_ context.Context, _ *elbv2.DescribeLoadBalancersInput,
+	_ ...func(*elbv2.Options),
+) (*elbv2.DescribeLoadBalancersOutput, error) {
+	return &elbv2.DescribeLoadBalancersOutput{LoadBalancers: f.loadBalancers}, nil
+}
+
+func (f *fakeELB) DescribeTargetGroups(_ context.Context, _ *elbv2.DescribeTargetGroupsInput,
+	_ ...func(*elbv2.Options),
+) (*elbv2.DescribeTargetGroupsOutput, error) {
+	return &elbv2.DescribeTargetGroupsOutput{TargetGroups: f.targetGroups}, nil
+}
+
+func (f *fakeELB) DescribeTargetHealth(_ context.Context, in *elbv2.DescribeTargetHealthInput,
+	_ ...func(*elbv2.Options),
+) (*elbv2.DescribeTargetHealthOutput, error) {
+	return &elbv2.DescribeTargetHealthOutput{
+		TargetHealthDescriptions: f.targets[aws.ToString(in.TargetGroupArn)],
+	}, nil
+}
+
+func (f *fakeELB) DescribeTags(_ context.Context, in *elbv2.DescribeTagsInput, _ ...func(*elbv2.Options),
+) (*elbv2.DescribeTagsOutput, error) {
+	f.tagRequests = append(f.tagRequests, len(in.ResourceArns))
+	out := &elbv2.DescribeTagsOutput{}
+	for _, arn := range in.ResourceArns {
+		out.TagDescriptions = append(out.TagDescriptions, elbtypes.TagDescription{
+			ResourceArn: aws.String(arn),
+			Tags:        f.tags[arn],
+		})
+	}
+	return out, nil
+}
+
+func loadBalancer(name string) elbtypes.LoadBalancer {
+	return elbtypes.LoadBalancer{
+		LoadBalancerArn:  aws.String("arn:lb/" + name),
+		LoadBalancerName: aws.String(name),
+		Type:             elbtypes.LoadBalancerTypeEnumApplication,
+		Scheme:           elbtypes.LoadBalancerSchemeEnumInternetFacing,
+		State:            &elbtypes.LoadBalancerState{Code: elbtypes.LoadBalancerStateEnumActive},
+		AvailabilityZones: []elbtypes.AvailabilityZone{
+			{ZoneName: aws.String("us-west-2a")},
+			{ZoneName: aws.String("us-west-2b")},
+		},
+	}
+}
+
+func targetGroup(name string, targetType elbtypes.TargetTypeEnum, loadBalancers ...string) elbtypes.TargetGroup {
+	group := elbtypes.TargetGroup{
+		TargetGroupArn:  aws.String("arn:tg/" + name),
+		TargetGroupName: aws.String(name),
+		TargetType:      targetType,
+		Protocol:        elbtypes.ProtocolEnumHttp,
+		Port:            aws.Int32(8080),
+	}
+	for _, lb := range loadBalancers {
+		group.LoadBalancerArns = append(group.LoadBalancerArns, "arn:lb/"+lb)
+	}
+	return group
+}
+
+func targets(state elbtypes.TargetHealthStateEnum, ids ...string) []elbtypes.TargetHealthDescription {
+	var descs []elbtypes.TargetHealthDescription
+	for _, id := range ids {
+		descs = append(descs, elbtypes.TargetHealthDescription{
+			Target:       &elbtypes.TargetDescription{Id: aws.String(id)},
+			TargetHealth: &elbtypes.TargetHealth{State: state},
+		})
+	}
+	return descs
+}
+
+func testELB() *fakeELB {
+	return &fakeELB{
+		loadBalancers: []elbtypes.LoadBalancer{
+			loadBalancer("web"), loadBalancer("staging"), loadBalancer("pods"), loadBalancer("idle"),
+		},
+		targetGroups: []elbtypes.TargetGroup{
+			targetGroup("web-http", elbtypes.TargetTypeEnumInstance, "web"),
+			targetGroup("web-admin", elbtypes.TargetTypeEnumInstance, "web"),
+			targetGroup("staging-http", elbtypes.TargetTypeEnumInstance, "staging"),
+			targetGroup("pods-http", elbtypes.TargetTypeEnumIp, "pods"),
+			targetGroup("unused", elbtypes.TargetTypeEnumInstance),
+		},
+		targets: map[string][]elbtypes.TargetHealthDescription{
+			"arn:tg/web-http":     targets(elbtypes.TargetHealthStateEnumHealthy, "i-1", "i-2"),
+			"arn:tg/web-admin":    targets(elbtypes.TargetHealthStateEnumUnhealthy, "i-1"),
+			"arn:tg/staging-http": targets(elbtypes.TargetHealthStateEnumHealthy, "i-3"),
+			"arn:tg/pods-http":    targets(elbtypes.TargetHealthStateEnumHealthy, "10.0.0.1"),
+		},
+		tags: map[string][]elbtypes.Tag{
+			"arn:lb/web": {
+				{Key: aws.String("team"), Value: aws.String("web")},
+				{Key: aws.String("env"), Value: aws.String("prod")},
+			},
+		},
+	}
+}
+
+func TestProvider_DiscoverLoadBalancers(t *testing.T) {
+	p := &Provider{EC2: testEC2(), ELB: testELB(), AccountID: "123456789012", Region: "us-west-2", ClusterName: "prod"}
+	inv, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+
+	// Only the load balancer targeting the instances of the cluster is discovered
+	var lbs []string
+	for _, rsrc := range inv.Resources {
+		if rsrc.GetType().GetType() == LoadBalancerResourceType {
+			lbs = append(lbs, rsrc.GetMetadata().GetName())
+		}
+	}
+	if !reflect.DeepEqual(lbs, []string{"web"}) {
+		t.Fatalf("load balancers = %v, want [web]", lbs)
+	}
+	web := inv.Resources[len(inv.Resources)-1]
+	if tags := web.GetMetadata().GetTags(); len(tags) != 2 || tags[0].GetKey() != "env" {
+		t.Errorf("tags are not sorted by key: %v", tags)
+	}
+	spec := &structpb.Struct{}
+	if err := web.GetSpec().UnmarshalTo(spec); err != nil {
+		t.Fatalf("failed to unmarshal spec: %v", err)
+	}
+	groups := spec.GetFields()["targetGroups"].GetListValue().GetValues()
+	if len(groups) != 2 {
+		t.Fatalf("got %d target groups, want 2", len(groups))
+	}
+	http := groups[0].GetStructValue().GetFields()
+	if http["name"].GetStringValue() != "web-http" || http["healthyTargets"].GetNumberValue() != 2 {
+		t.Errorf("unexpected target group: %v", http)
+	}
+	if admin := groups[1].GetStructValue().GetFields(); admin["healthyTargets"].GetNumberValue() != 0 {
+		t.Errorf("unexpected target group: %v", admin)
+	}
+
+	// i-1 is registered in both target groups of web but related once
+	var related []string
+	for _, rel := range inv.Relationships {
+		if rel.GetSubject().GetName() == "web" {
+			related = append(related, rel.GetObject().GetName())
+		}
+	}
+	if !reflect.DeepEqual(related, []string{"i-1", "i-2"}) {
+		t.Errorf("web is related to %v, want [i-1 i-2]", related)
+	}
+}
+
+func TestProvider_DiscoverRegionLoadBalancers(t *testing.T) {
+	names, rels := resourceNames(t, &Provider{EC2: testEC2(), ELB: testELB(), AccountID: "123456789012",
+		Region: "us-west-2"})
+	want := []string{"i-1", "i-2", "i-3", "idle", "pods", "staging", "vol-1", "vol-2", "vol-3", "web"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("resources = %v, want %v", names, want)
+	}
+	// The volumes, web to i-1 and i-2 and staging to i-3, each with its inverse
+	if rels != 10 {
+		t.Errorf("got %d relationships, want 10", rels)
+	}
+}
+
+func TestProvider_LoadBalancerTagBatches(t *testing.T) {
+	elb := &fakeELB{}
+	for i := range 45 {
+		elb.loadBalancers = append(elb.loadBalancers, loadBalancer(fmt.Sprintf("lb-%d", i)))
+	}
+	p := &Provider{EC2: testEC2(), ELB: elb, AccountID: "123456789012", Region: "us-west-2"}
+	if _, err := p.Discover(context.Background()); err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+	if want := []int{20, 20, 5}; !reflect.DeepEqual(elb.tagRequests, want) {
+		t.Errorf("DescribeTags requests = %v, want %v", elb.tagRequests, want)
+	}
+}
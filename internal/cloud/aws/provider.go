@@ -0,0 +1,301 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package aws discovers the EC2 instances, EBS volumes and Elastic Load Balancing load
+// balancers of an AWS region.
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/internal/cloud"
+)
+
+const (
+	ProviderName = "aws"
+
+	// Resource types of the discovered resources. Their specs are google.protobuf.Struct
+	// messages with the fields listed in instanceSpec, volumeSpec and loadBalancerSpec.
+	InstanceResourceType     = "aws.ec2.Instance"
+	VolumeResourceType       = "aws.ec2.Volume"
+	LoadBalancerResourceType = "aws.elbv2.LoadBalancer"
+)
+
+var (
+	// Tags marking EC2 instances as members of a Kubernetes cluster. The cluster name is
+	// the value of eksClusterTags and the suffix of clusterTagPrefixes.
+	eksClusterTags     = []string{"aws:eks:cluster-name", "eks:cluster-name"}
+	clusterTagPrefixes = []string{"kubernetes.io/cluster/", "k8s.io/cluster/"}
+)
+
+// EC2API is the subset of the EC2 client used to discover resources
+type EC2API interface {
+	ec2.DescribeInstancesAPIClient
+	ec2.DescribeVolumesAPIClient
+}
+
+// Provider discovers the EC2 instances of a region, the EBS volumes attached to them and
+// the load balancers whose target groups they are registered in. Terminated instances are
+// skipped.
+type Provider struct {
+	EC2       EC2API
+	AccountID string
+	Region    string
+	// ClusterName limits the discovered instances to the members of a Kubernetes cluster.
+	// If empty, all instances of the region are discovered.
+	ClusterName string
+	// ELB discovers the load balancers, which aren't discovered if nil
+	ELB ELBAPI
+}
+
+var _ cloud.Provider = &Provider{}
+
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+func (p *Provider) Discover(ctx context.Context) (*cloud.Inventory, error) {
+	inv := &cloud.Inventory{Instances: make(map[string]*resourcev1.ResourceRef)}
+
+	instances := make(map[string]*resourcev1.ResourceRef)
+	paginator := ec2.NewDescribeInstancesPaginator(p.EC2, &ec2.DescribeInstancesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe instances: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.State != nil && instance.State.Name == ec2types.InstanceStateNameTerminated {
+					continue
+				}
+				if p.ClusterName != "" && !isClusterMember(instance.Tags, p.ClusterName) {
+					continue
+				}
+				rsrc, err := p.instanceResource(instance)
+				if err != nil {
+					return nil, err
+				}
+				ref := cloud.ResourceRef(rsrc)
+				instances[aws.ToString(instance.InstanceId)] = ref
+				inv.Resources = append(inv.Resources, rsrc)
+				inv.Instances[nodeProviderID(instance)] = ref
+			}
+		}
+	}
+
+	attachedTo := &k8sv1.AttachedTo{}
+	volumeMount := &k8sv1.VolumeMount{}
+	volumes := ec2.NewDescribeVolumesPaginator(p.EC2, &ec2.DescribeVolumesInput{})
+	for volumes.HasMorePages() {
+		page, err := volumes.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe volumes: %w", err)
+		}
+		for _, volume := range page.Volumes {
+			var instanceRefs []*resourcev1.ResourceRef
+			for _, attachment := range volume.Attachments {
+				if ref, ok := instances[aws.ToString(attachment.InstanceId)]; ok {
+					instanceRefs = append(instanceRefs, ref)
+				}
+			}
+			// Unattached volumes are only discovered when discovering the whole region
+			if len(instanceRefs) == 0 && p.ClusterName != "" {
+				continue
+			}
+
+			rsrc, err := p.volumeResource(volume)
+			if err != nil {
+				return nil, err
+			}
+			inv.Resources = append(inv.Resources, rsrc)
+			for _, instanceRef := range instanceRefs {
+				rels, err := cloud.RelationshipPair(cloud.ResourceRef(rsrc), instanceRef,
+					attachedTo.ProtoReflect().Type(), volumeMount.ProtoReflect().Type())
+				if err != nil {
+					return nil, err
+				}
+				inv.Relationships = append(inv.Relationships, rels...)
+			}
+		}
+	}
+
+	if p.ELB != nil {
+		if err := p.discoverLoadBalancers(ctx, instances, inv); err != nil {
+			return nil, err
+		}
+	}
+
+	return inv, nil
+}
+
+func (p *Provider) instanceResource(instance ec2types.Instance) (*resourcev1.Resource, error) {
+	spec, err := instanceSpec(instance)
+	if err != nil {
+		return nil, err
+	}
+	var zone string
+	if instance.Placement != nil {
+		zone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	return p.resource(InstanceResourceType, aws.ToString(instance.InstanceId), zone, ec2TagsToTags(instance.Tags), spec)
+}
+
+func (p *Provider) volumeResource(volume ec2types.Volume) (*resourcev1.Resource, error) {
+	spec, err := volumeSpec(volume)
+	if err != nil {
+		return nil, err
+	}
+	return p.resource(VolumeResourceType, aws.ToString(volume.VolumeId), aws.ToString(volume.AvailabilityZone),
+		ec2TagsToTags(volume.Tags), spec)
+}
+
+func (p *Provider) resource(typ, id, zone string, tags []*resourcev1.Tag, spec *structpb.Struct,
+) (*resourcev1.Resource, error) {
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s spec: %w", typ, err)
+	}
+	return &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: cloud.KindResource,
+			Type: typ,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			Provider:   resourcev1.Provider_PROVIDER_AWS,
+			ProviderId: id,
+			Name:       id,
+			Namespace: &resourcev1.Namespace{
+				Namespace: &resourcev1.Namespace_Cloud{
+					Cloud: &resourcev1.CloudNamespace{
+						Account: &resourcev1.ProviderAccount{AccountId: p.AccountID},
+						Region:  p.Region,
+					},
+				},
+			},
+			Region: p.Region,
+			Zone:   zone,
+			Tags:   tags,
+		},
+		Spec: specAny,
+	}, nil
+}
+
+func instanceSpec(instance ec2types.Instance) (*structpb.Struct, error) {
+	lifecycle := string(instance.InstanceLifecycle)
+	if lifecycle == "" {
+		lifecycle = "on-demand"
+	}
+	fields := map[string]any{
+		"instanceId":       aws.ToString(instance.InstanceId),
+		"instanceType":     string(instance.InstanceType),
+		"lifecycle":        lifecycle,
+		"architecture":     string(instance.Architecture),
+		"imageId":          aws.ToString(instance.ImageId),
+		"vpcId":            aws.ToString(instance.VpcId),
+		"subnetId":         aws.ToString(instance.SubnetId),
+		"privateIpAddress": aws.ToString(instance.PrivateIpAddress),
+		"privateDnsName":   aws.ToString(instance.PrivateDnsName),
+	}
+	if instance.State != nil {
+		fields["state"] = string(instance.State.Name)
+	}
+	if instance.PublicIpAddress != nil {
+		fields["publicIpAddress"] = aws.ToString(instance.PublicIpAddress)
+	}
+	if instance.LaunchTime != nil {
+		fields["launchTime"] = instance.LaunchTime.UTC().Format(time.RFC3339)
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance spec: %w", err)
+	}
+	return spec, nil
+}
+
+func volumeSpec(volume ec2types.Volume) (*structpb.Struct, error) {
+	attachments := make([]any, 0, len(volume.Attachments))
+	for _, attachment := range volume.Attachments {
+		attachments = append(attachments, map[string]any{
+			"instanceId": aws.ToString(attachment.InstanceId),
+			"device":     aws.ToString(attachment.Device),
+			"state":      string(attachment.State),
+		})
+	}
+	fields := map[string]any{
+		"volumeId":    aws.ToString(volume.VolumeId),
+		"volumeType":  string(volume.VolumeType),
+		"sizeGiB":     float64(aws.ToInt32(volume.Size)),
+		"iops":        float64(aws.ToInt32(volume.Iops)),
+		"throughput":  float64(aws.ToInt32(volume.Throughput)),
+		"state":       string(volume.State),
+		"encrypted":   aws.ToBool(volume.Encrypted),
+		"snapshotId":  aws.ToString(volume.SnapshotId),
+		"attachments": attachments,
+	}
+	if volume.CreateTime != nil {
+		fields["createTime"] = volume.CreateTime.UTC().Format(time.RFC3339)
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume spec: %w", err)
+	}
+	return spec, nil
+}
+
+// nodeProviderID returns the provider ID the AWS cloud provider sets on the Kubernetes
+// Node of instance: aws:///<availability zone>/<instance ID>
+func nodeProviderID(instance ec2types.Instance) string {
+	var zone string
+	if instance.Placement != nil {
+		zone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	return fmt.Sprintf("aws:///%s/%s", zone, aws.ToString(instance.InstanceId))
+}
+
+func isClusterMember(tags []ec2types.Tag, clusterName string) bool {
+	for _, tag := range tags {
+		key := aws.ToString(tag.Key)
+		for _, eksTag := range eksClusterTags {
+			if key == eksTag && aws.ToString(tag.Value) == clusterName {
+				return true
+			}
+		}
+		for _, prefix := range clusterTagPrefixes {
+			if key == prefix+clusterName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ec2TagsToTags converts EC2 tags to resource tags ordered by key, so that unchanged tags
+// encode to the same bytes
+func ec2TagsToTags(ec2Tags []ec2types.Tag) []*resourcev1.Tag {
+	tags := make([]*resourcev1.Tag, 0, len(ec2Tags))
+	for _, tag := range ec2Tags {
+		tags = append(tags, &resourcev1.Tag{
+			Key:   aws.ToString(tag.Key),
+			Value: aws.ToString(tag.Value),
+		})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Key < tags[j].Key
+	})
+	return tags
+}
@@ -0,0 +1,208 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeEC2 returns each page of instances and volumes in turn
+type fakeEC2 struct {
+	instancePages [][]ec2types.Instance
+	volumePages   [][]ec2types.Volume
+	err           error
+}
+
+func (f *fakeEC2) DescribeInstances(_ context.Context, in *ec2.DescribeInstancesInput, _ ...func(*ec2.Options),
+) (*ec2.DescribeInstancesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	page := pageIndex(in.NextToken)
+	out := &ec2.DescribeInstancesOutput{
+		Reservations: []ec2types.Reservation{{Instances: f.instancePages[page]}},
+	}
+	if page+1 < len(f.instancePages) {
+		out.NextToken = nextToken(page)
+	}
+	return out, nil
+}
+
+func (f *fakeEC2) DescribeVolumes(_ context.Context, in *ec2.DescribeVolumesInput, _ ...func(*ec2.Options),
+) (*ec2.DescribeVolumesOutput, error) {
+	page := pageIndex(in.NextToken)
+	out := &ec2.DescribeVolumesOutput{Volumes: f.volumePages[page]}
+	if page+1 < len(f.volumePages) {
+		out.NextToken = nextToken(page)
+	}
+	return out, nil
+}
+
+func pageIndex(token *string) int {
+	if token == nil {
+		return 0
+	}
+	return int((*token)[0] - '0')
+}
+
+func nextToken(page int) *string {
+	return aws.String(string(rune('0' + page + 1)))
+}
+
+func tag(key, value string) ec2types.Tag {
+	return ec2types.Tag{Key: aws.String(key), Value: aws.String(value)}
+}
+
+func instance(id string, state ec2types.InstanceStateName, tags ...ec2types.Tag) ec2types.Instance {
+	return ec2types.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: ec2types.InstanceTypeM5Large,
+		State:        &ec2types.InstanceState{Name: state},
+		Placement:    &ec2types.Placement{AvailabilityZone: aws.String("us-west-2a")},
+		Tags:         tags,
+	}
+}
+
+func volume(id string, instanceIDs ...string) ec2types.Volume {
+	v := ec2types.Volume{
+		VolumeId:         aws.String(id),
+		VolumeType:       ec2types.VolumeTypeGp3,
+		Size:             aws.Int32(100),
+		AvailabilityZone: aws.String("us-west-2a"),
+	}
+	for _, instanceID := range instanceIDs {
+		v.Attachments = append(v.Attachments, ec2types.VolumeAttachment{
+			InstanceId: aws.String(instanceID),
+			Device:     aws.String("/dev/xvda"),
+			State:      ec2types.VolumeAttachmentStateAttached,
+		})
+	}
+	return v
+}
+
+func testEC2() *fakeEC2 {
+	return &fakeEC2{
+		instancePages: [][]ec2types.Instance{
+			{
+				instance("i-1", ec2types.InstanceStateNameRunning, tag("eks:cluster-name", "prod")),
+				instance("i-2", ec2types.InstanceStateNameRunning, tag("kubernetes.io/cluster/prod", "owned")),
+			},
+			{
+				instance("i-3", ec2types.InstanceStateNameRunning, tag("eks:cluster-name", "staging")),
+				instance("i-4", ec2types.InstanceStateNameTerminated, tag("eks:cluster-name", "prod")),
+			},
+		},
+		volumePages: [][]ec2types.Volume{
+			{volume("vol-1", "i-1"), volume("vol-2", "i-3")},
+			{volume("vol-3")},
+		},
+	}
+}
+
+func resourceNames(t *testing.T, p *Provider) ([]string, int) {
+	t.Helper()
+	inv, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+	var names []string
+	for _, rsrc := range inv.Resources {
+		names = append(names, rsrc.GetMetadata().GetName())
+	}
+	sort.Strings(names)
+	return names, len(inv.Relationships)
+}
+
+func TestProvider_Discover(t *testing.T) {
+	p := &Provider{EC2: testEC2(), AccountID: "123456789012", Region: "us-west-2", ClusterName: "prod"}
+	inv, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+
+	if len(inv.Resources) != 3 {
+		t.Fatalf("got %d resources, want 3", len(inv.Resources))
+	}
+	rsrc := inv.Resources[0]
+	if rsrc.GetType().GetType() != InstanceResourceType || rsrc.GetMetadata().GetName() != "i-1" {
+		t.Fatalf("unexpected first resource: %v", rsrc)
+	}
+	cloud := rsrc.GetMetadata().GetNamespace().GetCloud()
+	if cloud.GetAccount().GetAccountId() != "123456789012" || cloud.GetRegion() != "us-west-2" {
+		t.Errorf("unexpected namespace: %v", cloud)
+	}
+	if rsrc.GetMetadata().GetZone() != "us-west-2a" {
+		t.Errorf("Zone = %q, want us-west-2a", rsrc.GetMetadata().GetZone())
+	}
+	spec := &structpb.Struct{}
+	if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+		t.Fatalf("failed to unmarshal spec: %v", err)
+	}
+	if got := spec.GetFields()["lifecycle"].GetStringValue(); got != "on-demand" {
+		t.Errorf("lifecycle = %q, want on-demand", got)
+	}
+	if got := spec.GetFields()["instanceType"].GetStringValue(); got != "m5.large" {
+		t.Errorf("instanceType = %q, want m5.large", got)
+	}
+
+	var instanceIDs []string
+	for providerID := range inv.Instances {
+		instanceIDs = append(instanceIDs, providerID)
+	}
+	sort.Strings(instanceIDs)
+	wantIDs := []string{"aws:///us-west-2a/i-1", "aws:///us-west-2a/i-2"}
+	if !reflect.DeepEqual(instanceIDs, wantIDs) {
+		t.Errorf("Instances = %v, want %v", instanceIDs, wantIDs)
+	}
+
+	if len(inv.Relationships) != 2 {
+		t.Fatalf("got %d relationships, want 2", len(inv.Relationships))
+	}
+	rel := inv.Relationships[0]
+	if rel.GetSubject().GetName() != "vol-1" || rel.GetObject().GetName() != "i-1" {
+		t.Errorf("unexpected relationship: %v", rel)
+	}
+	if inv.Relationships[1].GetSubject().GetName() != "i-1" {
+		t.Errorf("unexpected inverse relationship: %v", inv.Relationships[1])
+	}
+}
+
+func TestProvider_DiscoverRegion(t *testing.T) {
+	names, rels := resourceNames(t, &Provider{EC2: testEC2(), AccountID: "123456789012", Region: "us-west-2"})
+	want := []string{"i-1", "i-2", "i-3", "vol-1", "vol-2", "vol-3"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("resources = %v, want %v", names, want)
+	}
+	if rels != 4 {
+		t.Errorf("got %d relationships, want 4", rels)
+	}
+}
+
+func TestProvider_DiscoverError(t *testing.T) {
+	fake := testEC2()
+	fake.err = errors.New("access denied")
+	p := &Provider{EC2: fake, AccountID: "123456789012", Region: "us-west-2"}
+	if _, err := p.Discover(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestEC2TagsToTags(t *testing.T) {
+	tags := ec2TagsToTags([]ec2types.Tag{tag("b", "2"), tag("a", "1")})
+	if len(tags) != 2 || tags[0].GetKey() != "a" || tags[1].GetKey() != "b" {
+		t.Errorf("tags are not sorted by key: %v", tags)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package cloud indexes the resources of cloud providers, such as the instances and volumes
+// backing a cluster's nodes, into the resource store. It is the counterpart of the
+// Kubernetes agent for resources that aren't Kubernetes objects.
+package cloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+var (
+	KindResource     = typeurl.Name(&resourcev1.Resource{})
+	KindRelationship = typeurl.Name(&resourcev1.Relationship{})
+)
+
+// Provider discovers the resources of a cloud provider
+type Provider interface {
+	// Name returns the name of the provider, e.g. aws
+	Name() string
+
+	// Discover returns the current resources of the provider. Resources that were
+	// discovered before and are missing from the returned Inventory are deleted from the
+	// store.
+	Discover(ctx context.Context) (*Inventory, error)
+}
+
+// Inventory is the set of resources of a provider at a point in time
+type Inventory struct {
+	Resources []*resourcev1.Resource
+	// Relationships between the resources
+	Relationships []*resourcev1.Relationship
+	// Instances maps the provider ID of Kubernetes Nodes (Node.Spec.ProviderID, e.g.
+	// aws:///us-west-2a/i-0123456789abcdef0) to the compute instance backing them, so
+	// that instances can be related to the Nodes running on them
+	Instances map[string]*resourcev1.ResourceRef
+}
+
+// ResourceRef returns the reference of rsrc
+func ResourceRef(rsrc *resourcev1.Resource) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl:   rsrc.GetType().GetType(),
+		Name:      rsrc.GetMetadata().GetName(),
+		Namespace: rsrc.GetMetadata().GetNamespace(),
+	}
+}
+
+// RelationshipPair returns the relationship subject -predicate-> object and its inverse
+// object -inverse-> subject
+func RelationshipPair(subject, object *resourcev1.ResourceRef, predicate, inverse protoreflect.MessageType,
+) ([]*resourcev1.Relationship, error) {
+	predicateAny, err := anypb.New(predicate.New().Interface())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create predicate: %w", err)
+	}
+	inverseAny, err := anypb.New(inverse.New().Interface())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create predicate: %w", err)
+	}
+	return []*resourcev1.Relationship{
+		{
+			Type: &resourcev1.TypeDescriptor{
+				Kind: KindRelationship,
+				Type: string(predicate.Descriptor().FullName()),
+			},
+			Subject:   subject,
+			Object:    object,
+			Predicate: predicateAny,
+		},
+		{
+			Type: &resourcev1.TypeDescriptor{
+				Kind: KindRelationship,
+				Type: string(inverse.Descriptor().FullName()),
+			},
+			Subject:   object,
+			Object:    subject,
+			Predicate: inverseAny,
+		},
+	}, nil
+}
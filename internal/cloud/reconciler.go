@@ -0,0 +1,285 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	reconcilerName = "cloud-inventory"
+
+	defaultInterval = 5 * time.Minute
+)
+
+// Reconciler periodically indexes the resources discovered by cloud Providers into the
+// resource store, deleting resources that disappeared since the previous sync. Compute
+// instances are related to the Kubernetes Nodes running on them through the Nodes'
+// provider IDs, so the Kubernetes agent should index into the same store.
+//
+// The store can't delete single relationships, so when a relationship of a resource goes
+// away (e.g. a volume was detached) the resource is deleted, which cascades to its
+// relationships, and indexed again with its current ones.
+type Reconciler struct {
+	Providers []Provider
+	Store     resource.Store
+	// Interval is how often the providers are synced. Defaults to 5 minutes.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the Reconciler to the provided manager
+func (r *Reconciler) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	runnable, err := r.runnable(mgr.GetLogger().WithName(reconcilerName))
+	if err != nil {
+		return err
+	}
+	return mgr.Add(runnable)
+}
+
+func (r *Reconciler) runnable(logger logr.Logger) (*reconciler, error) {
+	if r.Store == nil {
+		return nil, fmt.Errorf("Reconciler must be configured with a non-nil Store")
+	}
+	if len(r.Providers) == 0 {
+		return nil, fmt.Errorf("Reconciler must be configured with at least one Provider")
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	rec := &reconciler{
+		store:    r.Store,
+		interval: interval,
+		logger:   logger,
+		indexed:  make(map[string]map[string]indexedResource, len(r.Providers)),
+	}
+	for _, p := range r.Providers {
+		rec.providers = append(rec.providers, p)
+		rec.indexed[p.Name()] = make(map[string]indexedResource)
+	}
+	return rec, nil
+}
+
+type reconciler struct {
+	providers []Provider
+	store     resource.Store
+	interval  time.Duration
+	logger    logr.Logger
+
+	// indexed holds the resources in the store by provider and resource key so that
+	// unchanged resources aren't updated on every sync
+	indexed map[string]map[string]indexedResource
+}
+
+type indexedResource struct {
+	ref *resourcev1.ResourceRef
+	// encoded is the deterministic encoding of the resource
+	encoded []byte
+	// rels holds the encoded relationships the resource is the subject or object of
+	rels map[string]bool
+}
+
+func (r *reconciler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		for _, p := range r.providers {
+			if err := r.sync(ctx, p); err != nil {
+				r.logger.Error(err, "failed to index cloud resources", "provider", p.Name())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// cloud resources are only indexed into the store shipped by the leader.
+func (r *reconciler) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *reconciler) sync(ctx context.Context, p Provider) error {
+	inv, err := p.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover resources: %w", err)
+	}
+
+	nodeRels, err := r.nodeRelationships(inv.Instances)
+	if err != nil {
+		return err
+	}
+
+	// Relationships by the key of the resources they relate
+	rels := make(map[string][]*resourcev1.Relationship)
+	for _, rel := range append(inv.Relationships, nodeRels...) {
+		for _, ref := range []*resourcev1.ResourceRef{rel.GetSubject(), rel.GetObject()} {
+			key := refKey(ref)
+			rels[key] = append(rels[key], rel)
+		}
+	}
+
+	indexed := r.indexed[p.Name()]
+	present := make(map[string]bool, len(inv.Resources))
+	for _, rsrc := range inv.Resources {
+		present[refKey(ResourceRef(rsrc))] = true
+	}
+	for key, prev := range indexed {
+		if present[key] {
+			continue
+		}
+		err := r.store.DeleteResource(prev.ref)
+		if err != nil && !errors.Is(err, resource.ErrResourceNotFound) {
+			r.logger.Error(err, "failed to delete resource", "provider", p.Name(), "resource", key)
+			continue
+		}
+		delete(indexed, key)
+		// The deletion cascaded to the relationships of the resource
+		for _, other := range indexed {
+			for rel := range prev.rels {
+				delete(other.rels, rel)
+			}
+		}
+	}
+
+	for _, rsrc := range inv.Resources {
+		key := refKey(ResourceRef(rsrc))
+		if err := r.index(indexed, key, rsrc, rels[key]); err != nil {
+			r.logger.Error(err, "failed to index resource", "provider", p.Name(), "resource", key)
+		}
+	}
+	return nil
+}
+
+func (r *reconciler) index(indexed map[string]indexedResource, key string, rsrc *resourcev1.Resource,
+	rels []*resourcev1.Relationship,
+) error {
+	// Deterministic so that unchanged resources and relationships encode to the same bytes
+	marshal := proto.MarshalOptions{Deterministic: true}
+	encoded, err := marshal.Marshal(rsrc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %w", err)
+	}
+	cur := indexedResource{ref: ResourceRef(rsrc), encoded: encoded, rels: make(map[string]bool, len(rels))}
+	encodedRels := make([]string, len(rels))
+	for i, rel := range rels {
+		b, err := marshal.Marshal(rel)
+		if err != nil {
+			return fmt.Errorf("failed to marshal relationship: %w", err)
+		}
+		encodedRels[i] = string(b)
+		cur.rels[encodedRels[i]] = true
+	}
+
+	prev, wasIndexed := indexed[key]
+	if wasIndexed && hasRemoved(prev.rels, cur.rels) {
+		if err := r.store.DeleteResource(cur.ref); err != nil && !errors.Is(err, resource.ErrResourceNotFound) {
+			return fmt.Errorf("failed to delete resource with stale relationships: %w", err)
+		}
+		delete(indexed, key)
+		for _, other := range indexed {
+			for rel := range prev.rels {
+				delete(other.rels, rel)
+			}
+		}
+		wasIndexed = false
+	}
+
+	if !wasIndexed || !bytes.Equal(prev.encoded, encoded) {
+		if err := r.store.UpdateResource(rsrc); err != nil {
+			return fmt.Errorf("failed to update resource in inventory: %w", err)
+		}
+	}
+	var added []*resourcev1.Relationship
+	for i, rel := range rels {
+		if !wasIndexed || !prev.rels[encodedRels[i]] {
+			added = append(added, rel)
+		}
+	}
+	if len(added) > 0 {
+		if err := r.store.AddRelationships(added...); err != nil {
+			return fmt.Errorf("failed to add relationships to inventory: %w", err)
+		}
+	}
+	indexed[key] = cur
+	return nil
+}
+
+// hasRemoved reports whether prev has relationships missing from cur
+func hasRemoved(prev, cur map[string]bool) bool {
+	for rel := range prev {
+		if !cur[rel] {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeRelationships returns the Contains relationships between instances and the stored
+// Kubernetes Nodes whose provider ID matches them
+func (r *reconciler) nodeRelationships(instances map[string]*resourcev1.ResourceRef) ([]*resourcev1.Relationship, error) {
+	if len(instances) == 0 {
+		return nil, nil
+	}
+	nodes, err := r.store.ListResources(&resourcev1.TypeDescriptor{
+		Kind: KindResource,
+		Type: typeurl.Name(&corev1.Node{}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	contains := &k8sv1.Contains{}
+	containedBy := &k8sv1.ContainedBy{}
+	var rels []*resourcev1.Relationship
+	for _, rsrc := range nodes {
+		node := &corev1.Node{}
+		if err := gogoproto.Unmarshal(rsrc.GetSpec().GetValue(), node); err != nil {
+			r.logger.V(1).Info("failed to unmarshal node", "node", rsrc.GetMetadata().GetName(), "error", err)
+			continue
+		}
+		instance, ok := instances[node.Spec.ProviderID]
+		if !ok {
+			continue
+		}
+		pair, err := RelationshipPair(instance, ResourceRef(rsrc),
+			contains.ProtoReflect().Type(), containedBy.ProtoReflect().Type())
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, pair...)
+	}
+	return rels, nil
+}
+
+// refKey identifies a resource within the resources of a provider
+func refKey(ref *resourcev1.ResourceRef) string {
+	cloud := ref.GetNamespace().GetCloud()
+	return fmt.Sprintf("%s/%s/%s/%s", ref.GetTypeUrl(), cloud.GetAccount().GetAccountId(), cloud.GetRegion(), ref.GetName())
+}
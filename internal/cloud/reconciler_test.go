@@ -0,0 +1,241 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cloud
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+type fakeProvider struct {
+	inv *Inventory
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Discover(_ context.Context) (*Inventory, error) {
+	return p.inv, nil
+}
+
+func cloudResource(t *testing.T, typ, name string, fields map[string]any) *resourcev1.Resource {
+	t.Helper()
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		t.Fatalf("failed to marshal spec: %v", err)
+	}
+	return &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{Kind: KindResource, Type: typ},
+		Metadata: &resourcev1.ResourceMeta{
+			Provider: resourcev1.Provider_PROVIDER_AWS,
+			Name:     name,
+			Namespace: &resourcev1.Namespace{
+				Namespace: &resourcev1.Namespace_Cloud{
+					Cloud: &resourcev1.CloudNamespace{
+						Account: &resourcev1.ProviderAccount{AccountId: "123456789012"},
+						Region:  "us-west-2",
+					},
+				},
+			},
+		},
+		Spec: specAny,
+	}
+}
+
+func nodeResource(t *testing.T, name, providerID string) *resourcev1.Resource {
+	t.Helper()
+	node := &corev1.Node{}
+	node.Name = name
+	node.Spec.ProviderID = providerID
+	b, err := gogoproto.Marshal(node)
+	if err != nil {
+		t.Fatalf("failed to marshal node: %v", err)
+	}
+	return &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{Kind: KindResource, Type: typeurl.Name(node)},
+		Metadata: &resourcev1.ResourceMeta{
+			Name: name,
+		},
+		Spec: &anypb.Any{TypeUrl: typeurl.URL(node), Value: b},
+	}
+}
+
+func attachment(t *testing.T, volume, instance *resourcev1.Resource) []*resourcev1.Relationship {
+	t.Helper()
+	rels, err := RelationshipPair(ResourceRef(volume), ResourceRef(instance),
+		(&k8sv1.AttachedTo{}).ProtoReflect().Type(), (&k8sv1.VolumeMount{}).ProtoReflect().Type())
+	if err != nil {
+		t.Fatalf("failed to create relationships: %v", err)
+	}
+	return rels
+}
+
+func newTestReconciler(t *testing.T, p Provider) (*reconciler, resource.Store) {
+	t.Helper()
+	s, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	r := &Reconciler{Providers: []Provider{p}, Store: s}
+	rec, err := r.runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create reconciler: %v", err)
+	}
+	return rec, s
+}
+
+// relationshipTypes returns the predicate types of all relationships in s, sorted
+func relationshipTypes(t *testing.T, s resource.Store) []string {
+	t.Helper()
+	rels, err := s.ListRelationships()
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	types := make([]string, 0, len(rels))
+	for _, rel := range rels {
+		types = append(types, rel.GetType().GetType())
+	}
+	sort.Strings(types)
+	return types
+}
+
+func TestReconciler_Validation(t *testing.T) {
+	s, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	if _, err := (&Reconciler{Providers: []Provider{&fakeProvider{}}}).runnable(logr.Discard()); err == nil {
+		t.Errorf("expected error without a store")
+	}
+	if _, err := (&Reconciler{Store: s}).runnable(logr.Discard()); err == nil {
+		t.Errorf("expected error without providers")
+	}
+	rec, err := (&Reconciler{Providers: []Provider{&fakeProvider{}}, Store: s}).runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.interval != defaultInterval {
+		t.Errorf("interval = %v, want %v", rec.interval, defaultInterval)
+	}
+	if !rec.NeedLeaderElection() {
+		t.Errorf("reconciler must only run on the leader")
+	}
+}
+
+func TestReconciler_Sync(t *testing.T) {
+	ctx := context.Background()
+	instance := cloudResource(t, "aws.ec2.Instance", "i-1", map[string]any{"state": "running"})
+	volume := cloudResource(t, "aws.ec2.Volume", "vol-1", map[string]any{"sizeGiB": 20})
+	p := &fakeProvider{inv: &Inventory{
+		Resources:     []*resourcev1.Resource{instance, volume},
+		Relationships: attachment(t, volume, instance),
+		Instances:     map[string]*resourcev1.ResourceRef{"aws:///us-west-2a/i-1": ResourceRef(instance)},
+	}}
+	rec, s := newTestReconciler(t, p)
+
+	if err := s.AddResource(nodeResource(t, "node-1", "aws:///us-west-2a/i-1")); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	if err := s.AddResource(nodeResource(t, "node-2", "aws:///us-west-2a/i-2")); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+
+	if err := rec.sync(ctx, p); err != nil {
+		t.Fatalf("sync() failed: %v", err)
+	}
+	got := relationshipTypes(t, s)
+	want := []string{
+		"antimetal.kubernetes.v1.AttachedTo",
+		"antimetal.kubernetes.v1.ContainedBy",
+		"antimetal.kubernetes.v1.Contains",
+		"antimetal.kubernetes.v1.VolumeMount",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("relationships = %v, want %v", got, want)
+	}
+	contains, err := s.GetRelationships(ResourceRef(instance), nil, &k8sv1.Contains{})
+	if err != nil {
+		t.Fatalf("failed to get Contains relationships: %v", err)
+	}
+	if len(contains) != 1 || contains[0].GetObject().GetName() != "node-1" {
+		t.Errorf("unexpected Contains relationships: %v", contains)
+	}
+
+	// Updated resources are stored
+	updated := cloudResource(t, "aws.ec2.Instance", "i-1", map[string]any{"state": "stopped"})
+	p.inv.Resources[0] = updated
+	if err := rec.sync(ctx, p); err != nil {
+		t.Fatalf("sync() failed: %v", err)
+	}
+	stored, err := s.GetResource(ResourceRef(instance))
+	if err != nil {
+		t.Fatalf("failed to get instance: %v", err)
+	}
+	spec := &structpb.Struct{}
+	if err := stored.GetSpec().UnmarshalTo(spec); err != nil {
+		t.Fatalf("failed to unmarshal spec: %v", err)
+	}
+	if state := spec.GetFields()["state"].GetStringValue(); state != "stopped" {
+		t.Errorf("state = %q, want stopped", state)
+	}
+	if got := relationshipTypes(t, s); len(got) != 4 {
+		t.Errorf("relationships after update = %v, want 4", got)
+	}
+
+	// Detaching the volume removes its relationships but keeps both resources
+	p.inv.Relationships = nil
+	if err := rec.sync(ctx, p); err != nil {
+		t.Fatalf("sync() failed: %v", err)
+	}
+	got = relationshipTypes(t, s)
+	wantDetached := []string{"antimetal.kubernetes.v1.ContainedBy", "antimetal.kubernetes.v1.Contains"}
+	if !reflect.DeepEqual(got, wantDetached) {
+		t.Errorf("relationships after detach = %v, want %v", got, wantDetached)
+	}
+	for _, rsrc := range []*resourcev1.Resource{instance, volume} {
+		if _, err := s.GetResource(ResourceRef(rsrc)); err != nil {
+			t.Errorf("resource %s missing after detach: %v", rsrc.GetMetadata().GetName(), err)
+		}
+	}
+
+	// Resources that disappear are deleted with their relationships
+	p.inv = &Inventory{Resources: []*resourcev1.Resource{volume}}
+	if err := rec.sync(ctx, p); err != nil {
+		t.Fatalf("sync() failed: %v", err)
+	}
+	if _, err := s.GetResource(ResourceRef(instance)); !errors.Is(err, resource.ErrResourceNotFound) {
+		t.Errorf("GetResource() of deleted instance = %v, want ErrResourceNotFound", err)
+	}
+	if got := relationshipTypes(t, s); len(got) != 0 {
+		t.Errorf("relationships after delete = %v, want none", got)
+	}
+	if _, err := s.GetResource(ResourceRef(volume)); err != nil {
+		t.Errorf("volume missing after instance deletion: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package crash writes a diagnostic bundle when the agent panics, so that a crashing agent
+// leaves enough context behind to debug it offline: the panic and its stack, a dump of all
+// goroutines, the most recent log lines and the state registered as sections, such as the
+// last performance snapshot.
+package crash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLogLines is the number of log lines kept for a bundle
+	DefaultLogLines = 1000
+
+	// fatalOutputFile receives the output of crashes that can't be recovered, such as
+	// concurrent map writes or panics in goroutines that don't call Recover
+	fatalOutputFile = "fatal.log"
+
+	bundleTimeFormat = "20060102T150405.000Z"
+)
+
+// Handler writes a bundle to a directory of its own in Dir when a panic reaches Recover.
+// A nil Handler does nothing, so that callers don't have to check whether crash bundles
+// are enabled.
+//
+// Bundle layout:
+//
+//	crash-<time>/panic.txt        panic value and the stack of the panicking goroutine
+//	crash-<time>/goroutines.txt   stacks of all goroutines
+//	crash-<time>/logs.txt         the last log lines written to LogWriter
+//	crash-<time>/<section>.json   the value of each section
+//
+// Fatal errors of the runtime, which can't be recovered, are appended to fatal.log in Dir
+// with the stacks of all goroutines instead.
+type Handler struct {
+	dir  string
+	logs *logTail
+
+	mu       sync.Mutex
+	sections map[string]func() any
+	// crashed is set by the first panic, so that panics of several goroutines at once
+	// write a single bundle
+	crashed bool
+}
+
+// Options configures a Handler
+type Options struct {
+	// Dir is where bundles are written. It is created if it doesn't exist.
+	Dir string
+	// LogLines is the number of log lines kept. Defaults to DefaultLogLines.
+	LogLines int
+}
+
+// NewHandler returns a Handler writing bundles to opts.Dir. It sets the crash output of
+// the runtime to fatal.log in opts.Dir.
+func NewHandler(opts Options) (*Handler, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("crash bundle directory is required")
+	}
+	if opts.LogLines <= 0 {
+		opts.LogLines = DefaultLogLines
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create crash bundle directory: %w", err)
+	}
+
+	fatal, err := os.OpenFile(filepath.Join(opts.Dir, fatalOutputFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fatal error output: %w", err)
+	}
+	// The runtime duplicates the file, so it can be closed right away
+	defer fatal.Close()
+	if err := debug.SetCrashOutput(fatal, debug.CrashOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to set crash output: %w", err)
+	}
+	debug.SetTraceback("all")
+
+	return &Handler{
+		dir:      opts.Dir,
+		logs:     newLogTail(opts.LogLines),
+		sections: make(map[string]func() any),
+	}, nil
+}
+
+// LogWriter returns a writer that writes to w and keeps the last log lines for bundles
+func (h *Handler) LogWriter(w io.Writer) io.Writer {
+	if h == nil {
+		return w
+	}
+	return io.MultiWriter(w, h.logs)
+}
+
+// Logs returns the last log lines written to LogWriter, oldest first
+func (h *Handler) Logs() []byte {
+	if h == nil {
+		return nil
+	}
+	return h.logs.Bytes()
+}
+
+// AddSection adds the value returned by fn, encoded as JSON, to bundles as <name>.json.
+// fn is called while the agent is crashing, so it must not block.
+func (h *Handler) AddSection(name string, fn func() any) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sections[name] = fn
+}
+
+// Recover writes a bundle if the calling goroutine is panicking and panics again with the
+// same value, so that the agent still crashes. It must be deferred directly:
+//
+//	defer h.Recover()
+func (h *Handler) Recover() {
+	if h == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	if dir, err := h.WriteBundle(r, debug.Stack()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write crash bundle %s: %v\n", dir, err)
+	} else if dir != "" {
+		fmt.Fprintf(os.Stderr, "wrote crash bundle %s\n", dir)
+	}
+	panic(r)
+}
+
+// WriteBundle writes a bundle for the panic with value reason and the stack of the
+// goroutine that panicked, and returns its directory. Only the first call writes a bundle;
+// later calls return an empty directory. The parts that can be written are written even
+// if others fail.
+func (h *Handler) WriteBundle(reason any, stack []byte) (string, error) {
+	h.mu.Lock()
+	if h.crashed {
+		h.mu.Unlock()
+		return "", nil
+	}
+	h.crashed = true
+	sections := make(map[string]func() any, len(h.sections))
+	for name, fn := range h.sections {
+		sections[name] = fn
+	}
+	h.mu.Unlock()
+
+	dir := filepath.Join(h.dir, "crash-"+time.Now().UTC().Format(bundleTimeFormat))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return dir, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	var errs []error
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	write("panic.txt", fmt.Appendf(nil, "panic: %v\n\n%s", reason, stack))
+	write("goroutines.txt", allStacks())
+	write("logs.txt", h.logs.Bytes())
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := sectionJSON(sections[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("section %s: %w", name, err))
+			continue
+		}
+		write(name+".json", data)
+	}
+	return dir, errors.Join(errs...)
+}
+
+// sectionJSON encodes the value of a section. The state of a crashing agent can be broken
+// in any way, so a section that panics fails on its own rather than losing the bundle.
+func sectionJSON(fn func() any) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return json.MarshalIndent(fn(), "", "  ")
+}
+
+// allStacks returns the stacks of all goroutines
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// logTail keeps the last lines written to it
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+	// next is the index of the oldest line once lines is full
+	next int
+	size int
+}
+
+func newLogTail(size int) *logTail {
+	return &logTail{lines: make([]string, 0, size), size: size}
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(t.lines) < t.size {
+			t.lines = append(t.lines, line)
+			continue
+		}
+		t.lines[t.next] = line
+		t.next = (t.next + 1) % t.size
+	}
+	return len(p), nil
+}
+
+// Bytes returns the kept lines, oldest first
+func (t *logTail) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b strings.Builder
+	for i := range t.lines {
+		b.WriteString(t.lines[(t.next+i)%len(t.lines)])
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
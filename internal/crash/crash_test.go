@@ -0,0 +1,107 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package crash
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readBundleFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestHandler_Recover(t *testing.T) {
+	h, err := NewHandler(Options{Dir: t.TempDir(), LogLines: 2})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	logs := h.LogWriter(io.Discard)
+	for i := range 3 {
+		fmt.Fprintf(logs, "{\"msg\":\"line %d\"}\n", i)
+	}
+	h.AddSection("snapshot", func() any { return map[string]int{"cpus": 4} })
+	h.AddSection("broken", func() any { panic("broken state") })
+
+	func() {
+		defer func() {
+			if r := recover(); r != "collector failed" {
+				t.Errorf("expected the panic to continue, got %v", r)
+			}
+		}()
+		defer h.Recover()
+		panic("collector failed")
+	}()
+
+	bundles, err := filepath.Glob(filepath.Join(h.dir, "crash-*"))
+	if err != nil || len(bundles) != 1 {
+		t.Fatalf("expected one bundle, got %v (%v)", bundles, err)
+	}
+	dir := bundles[0]
+
+	if got := readBundleFile(t, dir, "panic.txt"); !strings.HasPrefix(got, "panic: collector failed\n") ||
+		!strings.Contains(got, "TestHandler_Recover") {
+		t.Errorf("unexpected panic.txt:\n%s", got)
+	}
+	if got := readBundleFile(t, dir, "goroutines.txt"); !strings.Contains(got, "goroutine ") {
+		t.Errorf("unexpected goroutines.txt:\n%s", got)
+	}
+	if got, want := readBundleFile(t, dir, "logs.txt"), "{\"msg\":\"line 1\"}\n{\"msg\":\"line 2\"}\n"; got != want {
+		t.Errorf("expected the last log lines %q, got %q", want, got)
+	}
+	if got, want := readBundleFile(t, dir, "snapshot.json"), "{\n  \"cpus\": 4\n}"; got != want {
+		t.Errorf("expected snapshot.json %q, got %q", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no file for a section that panics, got %v", err)
+	}
+
+	if dir, err := h.WriteBundle("second panic", nil); dir != "" || err != nil {
+		t.Errorf("expected a single bundle, got %q (%v)", dir, err)
+	}
+}
+
+func TestHandler_Nil(t *testing.T) {
+	var h *Handler
+	h.AddSection("snapshot", func() any { return nil })
+	if w := h.LogWriter(io.Discard); w != io.Discard {
+		t.Errorf("expected the writer to be returned as is, got %v", w)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != "failed" {
+				t.Errorf("expected the panic to continue, got %v", r)
+			}
+		}()
+		defer h.Recover()
+		panic("failed")
+	}()
+}
+
+func TestLogTail(t *testing.T) {
+	tail := newLogTail(3)
+	if got := string(tail.Bytes()); got != "" {
+		t.Errorf("expected no lines, got %q", got)
+	}
+	fmt.Fprint(tail, "a\nb\n")
+	if got := string(tail.Bytes()); got != "a\nb\n" {
+		t.Errorf("expected a and b, got %q", got)
+	}
+	fmt.Fprint(tail, "c\nd\ne\n")
+	if got := string(tail.Bytes()); got != "c\nd\ne\n" {
+		t.Errorf("expected the last 3 lines, got %q", got)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package cri is a minimal client for the image and runtime services of the Kubernetes
+// Container Runtime Interface (runtime.v1.ImageService and runtime.v1.RuntimeService), as
+// served by containerd and CRI-O.
+//
+// Only the read-only calls needed to inventory images and containers are implemented. Their
+// messages are encoded directly with protowire rather than depending on the generated
+// k8s.io/cri-api types; the CRI wire format is stable across runtime versions.
+package cri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// DefaultEndpoint is containerd's CRI socket
+	DefaultEndpoint = "unix:///run/containerd/containerd.sock"
+
+	listImagesMethod      = "/runtime.v1.ImageService/ListImages"
+	imageStatusMethod     = "/runtime.v1.ImageService/ImageStatus"
+	listContainersMethod  = "/runtime.v1.RuntimeService/ListContainers"
+	containerStatusMethod = "/runtime.v1.RuntimeService/ContainerStatus"
+)
+
+// Labels set by the kubelet on the containers it creates
+const (
+	LabelPodName       = "io.kubernetes.pod.name"
+	LabelPodNamespace  = "io.kubernetes.pod.namespace"
+	LabelPodUID        = "io.kubernetes.pod.uid"
+	LabelContainerName = "io.kubernetes.container.name"
+)
+
+// ContainerState is the state of a container
+type ContainerState int
+
+const (
+	ContainerCreated ContainerState = iota
+	ContainerRunning
+	ContainerExited
+	ContainerUnknown
+)
+
+func (s ContainerState) String() string {
+	switch s {
+	case ContainerCreated:
+		return "created"
+	case ContainerRunning:
+		return "running"
+	case ContainerExited:
+		return "exited"
+	default:
+		return "unknown"
+	}
+}
+
+// Container is a container on the node, including exited containers not yet removed
+type Container struct {
+	ID           string
+	PodSandboxID string
+	// Name is the name of the container in its pod
+	Name string
+	// Attempt counts the restarts of the container in its pod, starting at 0
+	Attempt   uint32
+	State     ContainerState
+	CreatedAt time.Time
+	Labels    map[string]string
+}
+
+// ContainerStatus is the detailed status of a container
+type ContainerStatus struct {
+	Container
+	StartedAt  time.Time
+	FinishedAt time.Time
+	ExitCode   int32
+	// Reason is a brief reason for the state of the container, e.g. OOMKilled
+	Reason  string
+	Message string
+}
+
+// Image is an image present on the node
+type Image struct {
+	// ID is the image ID, usually the digest of its config
+	ID string
+	// RepoTags are the tags the image is known by, e.g. docker.io/library/nginx:1.27
+	RepoTags []string
+	// RepoDigests are the repository digests of the image, e.g. docker.io/library/nginx@sha256:...
+	RepoDigests []string
+	// Size is the size of the image on disk in bytes
+	Size uint64
+	// Pinned images are never garbage collected by the kubelet
+	Pinned bool
+}
+
+// Client queries a container runtime's CRI services
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient creates a client for the CRI endpoint, e.g. unix:///run/containerd/containerd.sock.
+// The connection is established lazily on the first call.
+func NewClient(endpoint string) (*Client, error) {
+	conn, err := grpc.NewClient(endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRI client for %s: %w", endpoint, err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+// ListImages returns all images present on the node
+func (c *Client) ListImages(ctx context.Context) ([]Image, error) {
+	resp := &listImagesResponse{}
+	if err := c.conn.Invoke(ctx, listImagesMethod, &listImagesRequest{}, resp); err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	return resp.images, nil
+}
+
+// ImageCreated returns when the image with id was built. Runtimes only report it in
+// their verbose image status; the zero time is returned if the runtime doesn't.
+func (c *Client) ImageCreated(ctx context.Context, id string) (time.Time, error) {
+	resp := &imageStatusResponse{}
+	if err := c.conn.Invoke(ctx, imageStatusMethod, &imageStatusRequest{image: id, verbose: true}, resp); err != nil {
+		return time.Time{}, fmt.Errorf("failed to get status of image %s: %w", id, err)
+	}
+
+	// containerd and CRI-O report the OCI image config under the "info" key
+	var info struct {
+		ImageSpec struct {
+			Created time.Time `json:"created"`
+		} `json:"imageSpec"`
+	}
+	if raw, ok := resp.info["info"]; ok {
+		if err := json.Unmarshal([]byte(raw), &info); err != nil {
+			return time.Time{}, fmt.Errorf("failed to decode status info of image %s: %w", id, err)
+		}
+	}
+	return info.ImageSpec.Created, nil
+}
+
+// ListContainers returns all containers on the node
+func (c *Client) ListContainers(ctx context.Context) ([]Container, error) {
+	resp := &listContainersResponse{}
+	if err := c.conn.Invoke(ctx, listContainersMethod, &listContainersRequest{}, resp); err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	return resp.containers, nil
+}
+
+// ContainerStatus returns the status of the container with id
+func (c *Client) ContainerStatus(ctx context.Context, id string) (*ContainerStatus, error) {
+	resp := &containerStatusResponse{}
+	if err := c.conn.Invoke(ctx, containerStatusMethod, &containerStatusRequest{id: id}, resp); err != nil {
+		return nil, fmt.Errorf("failed to get status of container %s: %w", id, err)
+	}
+	return &resp.status, nil
+}
+
+// Close closes the connection to the runtime
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,246 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cri
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// rawMessage carries undecoded bytes through a fake runtime
+type rawMessage struct {
+	b []byte
+}
+
+func (m *rawMessage) marshal() []byte          { return m.b }
+func (m *rawMessage) unmarshal(b []byte) error { m.b = append([]byte(nil), b...); return nil }
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// startFakeRuntime serves responses keyed by full method name on a unix socket and
+// records the requests it receives
+func startFakeRuntime(t *testing.T, responses map[string][]byte) (string, map[string][]byte) {
+	t.Helper()
+	requests := make(map[string][]byte)
+
+	socket := filepath.Join(t.TempDir(), "cri.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(
+		grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			req := &rawMessage{}
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			requests[method] = req.b
+			return stream.SendMsg(&rawMessage{b: responses[method]})
+		}),
+		grpc.ForceServerCodec(codec{}),
+	)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	return "unix://" + socket, requests
+}
+
+func TestClient_ListImages(t *testing.T) {
+	var img []byte
+	img = appendString(img, 1, "sha256:abc")
+	img = appendString(img, 2, "docker.io/library/nginx:1.27")
+	img = appendString(img, 2, "docker.io/library/nginx:latest")
+	img = appendString(img, 3, "docker.io/library/nginx@sha256:def")
+	img = appendVarint(img, 4, 72_000_000)
+	img = appendString(img, 6, "nginx")
+	img = appendVarint(img, 8, 1)
+
+	var pause []byte
+	pause = appendString(pause, 1, "sha256:123")
+	pause = appendVarint(pause, 4, 300_000)
+
+	var resp []byte
+	resp = appendMessage(resp, 1, img)
+	resp = appendMessage(resp, 1, pause)
+
+	endpoint, _ := startFakeRuntime(t, map[string][]byte{listImagesMethod: resp})
+	c, err := NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	images, err := c.ListImages(context.Background())
+	if err != nil {
+		t.Fatalf("ListImages() error = %v", err)
+	}
+	want := []Image{
+		{
+			ID:          "sha256:abc",
+			RepoTags:    []string{"docker.io/library/nginx:1.27", "docker.io/library/nginx:latest"},
+			RepoDigests: []string{"docker.io/library/nginx@sha256:def"},
+			Size:        72_000_000,
+			Pinned:      true,
+		},
+		{ID: "sha256:123", Size: 300_000},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("ListImages() = %+v, want %+v", images, want)
+	}
+}
+
+func TestClient_ImageCreated(t *testing.T) {
+	var entry []byte
+	entry = appendString(entry, 1, "info")
+	entry = appendString(entry, 2, `{"imageSpec":{"created":"2025-01-02T03:04:05Z","architecture":"amd64"}}`)
+	var resp []byte
+	resp = appendMessage(resp, 2, entry)
+
+	endpoint, requests := startFakeRuntime(t, map[string][]byte{imageStatusMethod: resp})
+	c, err := NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	created, err := c.ImageCreated(context.Background(), "sha256:abc")
+	if err != nil {
+		t.Fatalf("ImageCreated() error = %v", err)
+	}
+	if want := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC); !created.Equal(want) {
+		t.Errorf("ImageCreated() = %v, want %v", created, want)
+	}
+
+	wantReq := appendMessage(nil, 1, appendString(nil, 1, "sha256:abc"))
+	wantReq = appendVarint(wantReq, 2, 1)
+	if got := requests[imageStatusMethod]; !reflect.DeepEqual(got, wantReq) {
+		t.Errorf("ImageStatus request = %x, want %x", got, wantReq)
+	}
+}
+
+func TestClient_ImageCreatedUnknown(t *testing.T) {
+	endpoint, _ := startFakeRuntime(t, map[string][]byte{imageStatusMethod: nil})
+	c, err := NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	created, err := c.ImageCreated(context.Background(), "sha256:abc")
+	if err != nil {
+		t.Fatalf("ImageCreated() error = %v", err)
+	}
+	if !created.IsZero() {
+		t.Errorf("ImageCreated() = %v, want zero time", created)
+	}
+}
+
+func appendLabel(b []byte, key, value string) []byte {
+	return appendMessage(b, 8, appendString(appendString(nil, 1, key), 2, value))
+}
+
+func TestClient_ListContainers(t *testing.T) {
+	var meta []byte
+	meta = appendString(meta, 1, "app")
+	meta = appendVarint(meta, 2, 3)
+
+	var ctr []byte
+	ctr = appendString(ctr, 1, "abc123")
+	ctr = appendString(ctr, 2, "sandbox1")
+	ctr = appendMessage(ctr, 3, meta)
+	ctr = appendVarint(ctr, 6, uint64(ContainerExited))
+	ctr = appendVarint(ctr, 7, 1_700_000_000_000_000_000)
+	ctr = appendLabel(ctr, LabelPodName, "web-0")
+	ctr = appendLabel(ctr, LabelPodNamespace, "default")
+
+	var resp []byte
+	resp = appendMessage(resp, 1, ctr)
+
+	endpoint, _ := startFakeRuntime(t, map[string][]byte{listContainersMethod: resp})
+	c, err := NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	containers, err := c.ListContainers(context.Background())
+	if err != nil {
+		t.Fatalf("ListContainers() error = %v", err)
+	}
+	want := []Container{{
+		ID:           "abc123",
+		PodSandboxID: "sandbox1",
+		Name:         "app",
+		Attempt:      3,
+		State:        ContainerExited,
+		CreatedAt:    time.Unix(0, 1_700_000_000_000_000_000),
+		Labels:       map[string]string{LabelPodName: "web-0", LabelPodNamespace: "default"},
+	}}
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("ListContainers() = %+v, want %+v", containers, want)
+	}
+}
+
+func TestClient_ContainerStatus(t *testing.T) {
+	var status []byte
+	status = appendString(status, 1, "abc123")
+	status = appendMessage(status, 2, appendString(nil, 1, "app"))
+	status = appendVarint(status, 3, uint64(ContainerExited))
+	status = appendVarint(status, 6, 1_700_000_060_000_000_000)
+	status = appendVarint(status, 7, 137)
+	status = appendString(status, 10, "OOMKilled")
+
+	var resp []byte
+	resp = appendMessage(resp, 1, status)
+
+	endpoint, requests := startFakeRuntime(t, map[string][]byte{containerStatusMethod: resp})
+	c, err := NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	got, err := c.ContainerStatus(context.Background(), "abc123")
+	if err != nil {
+		t.Fatalf("ContainerStatus() error = %v", err)
+	}
+	want := &ContainerStatus{
+		Container:  Container{ID: "abc123", Name: "app", State: ContainerExited},
+		FinishedAt: time.Unix(0, 1_700_000_060_000_000_000),
+		ExitCode:   137,
+		Reason:     "OOMKilled",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ContainerStatus() = %+v, want %+v", got, want)
+	}
+
+	if got, want := requests[containerStatusMethod], appendString(nil, 1, "abc123"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ContainerStatus request = %x, want %x", got, want)
+	}
+}
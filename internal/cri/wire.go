@@ -0,0 +1,337 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cri
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Wire encoding of the runtime.v1 messages used by Client. Field numbers are from
+// https://github.com/kubernetes/cri-api/blob/master/pkg/apis/runtime/v1/api.proto
+//
+//	message ImageSpec { string image = 1; ... }
+//	message ListImagesRequest { ImageFilter filter = 1; }
+//	message ListImagesResponse { repeated Image images = 1; }
+//	message Image { string id = 1; repeated string repo_tags = 2; repeated string repo_digests = 3;
+//	                uint64 size = 4; ...; bool pinned = 8; }
+//	message ImageStatusRequest { ImageSpec image = 1; bool verbose = 2; }
+//	message ImageStatusResponse { Image image = 1; map<string, string> info = 2; }
+//	message ContainerMetadata { string name = 1; uint32 attempt = 2; }
+//	message ListContainersRequest { ContainerFilter filter = 1; }
+//	message ListContainersResponse { repeated Container containers = 1; }
+//	message Container { string id = 1; string pod_sandbox_id = 2; ContainerMetadata metadata = 3; ...;
+//	                    ContainerState state = 6; int64 created_at = 7; map<string, string> labels = 8; ... }
+//	message ContainerStatusRequest { string container_id = 1; bool verbose = 2; }
+//	message ContainerStatusResponse { ContainerStatus status = 1; ... }
+//	message ContainerStatus { string id = 1; ContainerMetadata metadata = 2; ContainerState state = 3;
+//	                          int64 created_at = 4; int64 started_at = 5; int64 finished_at = 6;
+//	                          int32 exit_code = 7; ...; string reason = 10; string message = 11;
+//	                          map<string, string> labels = 12; ... }
+//
+// Timestamps are in nanoseconds since the Unix epoch.
+
+type message interface {
+	marshal() []byte
+	unmarshal([]byte) error
+}
+
+// codec passes messages to their own encoding. It is registered under the proto name
+// so requests carry the application/grpc+proto content type runtimes expect.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("cri: unsupported message type %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("cri: unsupported message type %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (codec) Name() string {
+	return "proto"
+}
+
+type listImagesRequest struct{}
+
+func (*listImagesRequest) marshal() []byte        { return nil }
+func (*listImagesRequest) unmarshal([]byte) error { return nil }
+
+type listImagesResponse struct {
+	images []Image
+}
+
+func (*listImagesResponse) marshal() []byte { return nil }
+
+func (r *listImagesResponse) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var img Image
+		if err := unmarshalImage(v, &img); err != nil {
+			return err
+		}
+		r.images = append(r.images, img)
+		return nil
+	})
+}
+
+type imageStatusRequest struct {
+	image   string
+	verbose bool
+}
+
+func (r *imageStatusRequest) marshal() []byte {
+	spec := protowire.AppendTag(nil, 1, protowire.BytesType)
+	spec = protowire.AppendString(spec, r.image)
+
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, spec)
+	if r.verbose {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func (*imageStatusRequest) unmarshal([]byte) error { return nil }
+
+type imageStatusResponse struct {
+	info map[string]string
+}
+
+func (*imageStatusResponse) marshal() []byte { return nil }
+
+func (r *imageStatusResponse) unmarshal(b []byte) error {
+	r.info = make(map[string]string)
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 2 || typ != protowire.BytesType {
+			return nil
+		}
+		var key, value string
+		err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				key = string(v)
+			case num == 2 && typ == protowire.BytesType:
+				value = string(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		r.info[key] = value
+		return nil
+	})
+}
+
+type listContainersRequest struct{}
+
+func (*listContainersRequest) marshal() []byte        { return nil }
+func (*listContainersRequest) unmarshal([]byte) error { return nil }
+
+type listContainersResponse struct {
+	containers []Container
+}
+
+func (*listContainersResponse) marshal() []byte { return nil }
+
+func (r *listContainersResponse) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var ctr Container
+		err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			var err error
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				ctr.ID = string(v)
+			case num == 2 && typ == protowire.BytesType:
+				ctr.PodSandboxID = string(v)
+			case num == 3 && typ == protowire.BytesType:
+				err = unmarshalContainerMetadata(v, &ctr)
+			case num == 6 && typ == protowire.VarintType:
+				n, _ := protowire.ConsumeVarint(v)
+				ctr.State = ContainerState(n)
+			case num == 7 && typ == protowire.VarintType:
+				ctr.CreatedAt = unixNanos(v)
+			case num == 8 && typ == protowire.BytesType:
+				err = unmarshalMapEntry(v, &ctr.Labels)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		r.containers = append(r.containers, ctr)
+		return nil
+	})
+}
+
+type containerStatusRequest struct {
+	id string
+}
+
+func (r *containerStatusRequest) marshal() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendString(b, r.id)
+}
+
+func (*containerStatusRequest) unmarshal([]byte) error { return nil }
+
+type containerStatusResponse struct {
+	status ContainerStatus
+}
+
+func (*containerStatusResponse) marshal() []byte { return nil }
+
+func (r *containerStatusResponse) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		s := &r.status
+		return walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			var err error
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				s.ID = string(v)
+			case num == 2 && typ == protowire.BytesType:
+				err = unmarshalContainerMetadata(v, &s.Container)
+			case num == 3 && typ == protowire.VarintType:
+				n, _ := protowire.ConsumeVarint(v)
+				s.State = ContainerState(n)
+			case num == 4 && typ == protowire.VarintType:
+				s.CreatedAt = unixNanos(v)
+			case num == 5 && typ == protowire.VarintType:
+				s.StartedAt = unixNanos(v)
+			case num == 6 && typ == protowire.VarintType:
+				s.FinishedAt = unixNanos(v)
+			case num == 7 && typ == protowire.VarintType:
+				n, _ := protowire.ConsumeVarint(v)
+				s.ExitCode = int32(n)
+			case num == 10 && typ == protowire.BytesType:
+				s.Reason = string(v)
+			case num == 11 && typ == protowire.BytesType:
+				s.Message = string(v)
+			case num == 12 && typ == protowire.BytesType:
+				err = unmarshalMapEntry(v, &s.Labels)
+			}
+			return err
+		})
+	})
+}
+
+func unmarshalContainerMetadata(b []byte, ctr *Container) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ctr.Name = string(v)
+		case num == 2 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			ctr.Attempt = uint32(n)
+		}
+		return nil
+	})
+}
+
+// unmarshalMapEntry adds the key and value of a map<string, string> entry to m
+func unmarshalMapEntry(b []byte, m *map[string]string) error {
+	var key, value string
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			value = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+	return nil
+}
+
+// unixNanos decodes a varint timestamp in nanoseconds since the Unix epoch. Zero is
+// decoded as the zero time.
+func unixNanos(v []byte) time.Time {
+	n, _ := protowire.ConsumeVarint(v)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(n))
+}
+
+func unmarshalImage(b []byte, img *Image) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			img.ID = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			img.RepoTags = append(img.RepoTags, string(v))
+		case num == 3 && typ == protowire.BytesType:
+			img.RepoDigests = append(img.RepoDigests, string(v))
+		case num == 4 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			img.Size = n
+		case num == 8 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			img.Pinned = n != 0
+		}
+		return nil
+	})
+}
+
+// walkFields calls fn with every field in b. The value of length delimited fields is
+// their content; for other types it is the raw encoded value.
+func walkFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("cri: invalid field tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return fmt.Errorf("cri: invalid field %d: %w", num, protowire.ParseError(m))
+			}
+			value, n = v, m
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("cri: invalid field %d: %w", num, protowire.ParseError(n))
+			}
+			value = b[:n]
+		}
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
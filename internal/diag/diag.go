@@ -0,0 +1,229 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package diag collects diagnostic bundles of a running agent on demand: a dump of all
+// goroutines, a heap profile, the recent logs and the state registered as sections, such
+// as a fresh collection of all performance collectors and the statistics of the resource
+// store. Bundles are requested through the debug server or by annotating the agent's pod,
+// so that an agent misbehaving in a cluster can be debugged without restarting it.
+package diag
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+)
+
+const bundleTimeFormat = "20060102T150405.000Z"
+
+// ErrInProgress is returned when a bundle is requested while another one is collected
+var ErrInProgress = errors.New("a diagnostic bundle is already being collected")
+
+// Bundler collects diagnostic bundles as gzipped tarballs.
+//
+// Bundle layout:
+//
+//	debug-<time>/manifest.json    when and why the bundle was collected, and what failed
+//	debug-<time>/goroutines.txt   stacks of all goroutines
+//	debug-<time>/heap.pprof       heap profile
+//	debug-<time>/logs.txt         the recent logs, if Options.Logs is set
+//	debug-<time>/<section>.json   the value of each section
+type Bundler struct {
+	dir  string
+	logs func() []byte
+
+	mu       sync.Mutex
+	sections map[string]func(ctx context.Context) (any, error)
+	// collecting is set while a bundle is collected; sections such as a full collection
+	// of the performance collectors are too expensive to run concurrently
+	collecting bool
+}
+
+// Options configures a Bundler
+type Options struct {
+	// Dir is where Save writes bundles. It is created if it doesn't exist.
+	Dir string
+	// Logs returns the recent log lines, if set
+	Logs func() []byte
+}
+
+// Manifest describes a bundle
+type Manifest struct {
+	Time     time.Time         `json:"time"`
+	Reason   string            `json:"reason"`
+	Duration string            `json:"duration"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// NewBundler returns a Bundler saving bundles to opts.Dir
+func NewBundler(opts Options) (*Bundler, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("diagnostic bundle directory is required")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create diagnostic bundle directory: %w", err)
+	}
+	return &Bundler{
+		dir:      opts.Dir,
+		logs:     opts.Logs,
+		sections: make(map[string]func(ctx context.Context) (any, error)),
+	}, nil
+}
+
+// AddSection adds the value returned by fn, encoded as JSON, to bundles as <name>.json.
+// A section that fails is left out and its error recorded in the manifest.
+func (b *Bundler) AddSection(name string, fn func(ctx context.Context) (any, error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sections[name] = fn
+}
+
+// Save collects a bundle for reason and writes it to the bundle directory. It returns the
+// path of the bundle.
+func (b *Bundler) Save(ctx context.Context, reason string) (string, error) {
+	now := time.Now().UTC()
+	path := filepath.Join(b.dir, "debug-"+now.Format(bundleTimeFormat)+".tar.gz")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := b.write(ctx, f, now, reason); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return path, nil
+}
+
+// Write collects a bundle for reason and writes it to w
+func (b *Bundler) Write(ctx context.Context, w io.Writer, reason string) error {
+	return b.write(ctx, w, time.Now().UTC(), reason)
+}
+
+func (b *Bundler) write(ctx context.Context, w io.Writer, now time.Time, reason string) error {
+	b.mu.Lock()
+	if b.collecting {
+		b.mu.Unlock()
+		return ErrInProgress
+	}
+	b.collecting = true
+	sections := maps.Clone(b.sections)
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.collecting = false
+		b.mu.Unlock()
+	}()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	root := "debug-" + now.Format(bundleTimeFormat)
+	add := func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    root + "/" + name,
+			Mode:    0o600,
+			Size:    int64(len(data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	manifest := Manifest{Time: now, Reason: reason, Errors: make(map[string]string)}
+	files := map[string][]byte{"goroutines.txt": allStacks()}
+	if heap, err := heapProfile(); err != nil {
+		manifest.Errors["heap.pprof"] = err.Error()
+	} else {
+		files["heap.pprof"] = heap
+	}
+	if b.logs != nil {
+		files["logs.txt"] = b.logs()
+	}
+	for _, name := range slices.Sorted(maps.Keys(sections)) {
+		data, err := sectionJSON(ctx, sections[name])
+		if err != nil {
+			manifest.Errors[name] = err.Error()
+			continue
+		}
+		files[name+".json"] = data
+	}
+	manifest.Duration = time.Since(now).Round(time.Millisecond).String()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := add("manifest.json", data); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := add(name, files[name]); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// sectionJSON encodes the value of a section. A section that panics fails on its own
+// rather than losing the bundle.
+func sectionJSON(ctx context.Context, fn func(ctx context.Context) (any, error)) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	v, err := fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func heapProfile() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// allStacks returns the stacks of all goroutines
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package diag
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// readBundle returns the files of a bundle by name, without the bundle directory
+func readBundle(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("failed to read bundle: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", hdr.Name, err)
+		}
+		_, name, _ := strings.Cut(hdr.Name, "/")
+		files[name] = string(data)
+	}
+}
+
+func newBundler(t *testing.T) *Bundler {
+	t.Helper()
+	b, err := NewBundler(Options{
+		Dir:  t.TempDir(),
+		Logs: func() []byte { return []byte("{\"msg\":\"started\"}\n") },
+	})
+	if err != nil {
+		t.Fatalf("failed to create bundler: %v", err)
+	}
+	b.AddSection("snapshot", func(context.Context) (any, error) { return map[string]int{"cpus": 4}, nil })
+	b.AddSection("failing", func(context.Context) (any, error) { return nil, errors.New("store is closed") })
+	b.AddSection("broken", func(context.Context) (any, error) { panic("broken state") })
+	return b
+}
+
+func TestBundler_Save(t *testing.T) {
+	b := newBundler(t)
+	path, err := b.Save(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("failed to save bundle: %v", err)
+	}
+	if filepath.Dir(path) != b.dir || !strings.HasSuffix(path, ".tar.gz") {
+		t.Errorf("unexpected bundle path %s", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	defer f.Close()
+	files := readBundle(t, f)
+
+	if !strings.Contains(files["goroutines.txt"], "TestBundler_Save") {
+		t.Errorf("expected goroutines.txt to contain the test goroutine:\n%s", files["goroutines.txt"])
+	}
+	if files["heap.pprof"] == "" {
+		t.Errorf("expected a heap profile")
+	}
+	if files["logs.txt"] != "{\"msg\":\"started\"}\n" {
+		t.Errorf("unexpected logs.txt: %q", files["logs.txt"])
+	}
+	if got := files["snapshot.json"]; !strings.Contains(got, `"cpus": 4`) {
+		t.Errorf("unexpected snapshot.json: %s", got)
+	}
+	for _, name := range []string{"failing.json", "broken.json"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("expected %s to be left out", name)
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	if manifest.Reason != "test" || manifest.Time.IsZero() {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	if manifest.Errors["failing"] != "store is closed" || manifest.Errors["broken"] != "panic: broken state" {
+		t.Errorf("expected the section errors in the manifest, got %v", manifest.Errors)
+	}
+}
+
+func TestBundler_InProgress(t *testing.T) {
+	b := newBundler(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	b.AddSection("slow", func(context.Context) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := b.Write(context.Background(), io.Discard, "first"); err != nil {
+			t.Errorf("failed to write bundle: %v", err)
+		}
+	}()
+	<-started
+	if _, err := b.Save(context.Background(), "second"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("expected ErrInProgress, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if entries, _ := os.ReadDir(b.dir); len(entries) != 0 {
+		t.Errorf("expected no bundle to be saved, got %d", len(entries))
+	}
+}
+
+func TestBundler_ServeHTTP(t *testing.T) {
+	b := newBundler(t)
+
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/bundle", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/bundle", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if files := readBundle(t, rec.Body); !strings.Contains(files["manifest.json"], "debug server") {
+		t.Errorf("unexpected manifest:\n%s", files["manifest.json"])
+	}
+
+	rec = httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/bundle?save=true", nil))
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if _, err := os.Stat(resp["path"]); err != nil {
+		t.Errorf("expected the bundle to be saved: %v", err)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	b := newBundler(t)
+	var mu sync.Mutex
+	annotations := map[string]string{TriggerAnnotation: "before-restart"}
+	setAnnotation := func(value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		annotations = map[string]string{TriggerAnnotation: value}
+	}
+	trigger := &Trigger{
+		Bundler: b,
+		Annotations: func(context.Context) (map[string]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return annotations, nil
+		},
+		Interval: 10 * time.Millisecond,
+		Logger:   logr.Discard(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- trigger.Start(ctx) }()
+
+	bundles := func() int {
+		entries, err := os.ReadDir(b.dir)
+		if err != nil {
+			t.Fatalf("failed to list bundles: %v", err)
+		}
+		return len(entries)
+	}
+	waitBundles := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for bundles() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d bundles, got %d", n, bundles())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The annotation set before the trigger started was already handled
+	time.Sleep(50 * time.Millisecond)
+	if n := bundles(); n != 0 {
+		t.Fatalf("expected no bundle for the initial annotation, got %d", n)
+	}
+
+	setAnnotation("1")
+	waitBundles(1)
+	time.Sleep(50 * time.Millisecond)
+	if n := bundles(); n != 1 {
+		t.Errorf("expected a single bundle per annotation value, got %d", n)
+	}
+
+	setAnnotation("2")
+	waitBundles(2)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
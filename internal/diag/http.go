@@ -0,0 +1,58 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeHTTP collects a bundle on POST and responds with it as a gzipped tarball. With the
+// save query parameter set to true, the bundle is saved to the bundle directory instead
+// and its path returned as JSON. It responds with 409 while another bundle is collected.
+func (b *Bundler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	save, _ := strconv.ParseBool(r.URL.Query().Get("save"))
+	reason := "requested through the debug server by " + r.RemoteAddr
+
+	if save {
+		path, err := b.Save(r.Context(), reason)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"path": path})
+		return
+	}
+
+	// Buffered so that a failure can still be reported with a status code
+	var buf bytes.Buffer
+	if err := b.Write(r.Context(), &buf, reason); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	name := fmt.Sprintf("debug-%s.tar.gz", time.Now().UTC().Format(bundleTimeFormat))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	_, _ = w.Write(buf.Bytes())
+}
+
+func errorStatus(err error) int {
+	if errors.Is(err, ErrInProgress) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,97 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package diag
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// TriggerAnnotation requests a bundle when set on the agent's pod, or changed to a new
+	// value, e.g. with:
+	//
+	//	kubectl annotate pod <agent-pod> --overwrite antimetal.com/debug-snapshot=$(date +%s)
+	TriggerAnnotation = "antimetal.com/debug-snapshot"
+
+	// DefaultTriggerInterval is how often the pod annotations are checked by default
+	DefaultTriggerInterval = 30 * time.Second
+)
+
+// Trigger saves a bundle whenever the TriggerAnnotation of the agent's pod changes. The
+// value the annotation has when the trigger starts doesn't request a bundle, so that a
+// restarted agent doesn't collect the bundle of a previous request again.
+type Trigger struct {
+	Bundler *Bundler
+	// Annotations returns the current annotations of the agent's pod
+	Annotations func(ctx context.Context) (map[string]string, error)
+	// Interval is how often the annotations are checked. Defaults to DefaultTriggerInterval.
+	Interval time.Duration
+	Logger   logr.Logger
+}
+
+// Start checks the annotations of the agent's pod until ctx is done. It implements the
+// controller-runtime manager.Runnable interface.
+func (t *Trigger) Start(ctx context.Context) error {
+	if t.Bundler == nil || t.Annotations == nil {
+		return errors.New("trigger requires a Bundler and Annotations")
+	}
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultTriggerInterval
+	}
+
+	last, seen := "", false
+	check := func() {
+		annotations, err := t.Annotations(ctx)
+		if err != nil {
+			t.Logger.V(1).Info("failed to get pod annotations", "error", err.Error())
+			return
+		}
+		value := annotations[TriggerAnnotation]
+		if !seen {
+			last, seen = value, true
+			return
+		}
+		if value == last {
+			return
+		}
+		last = value
+		if value == "" {
+			return
+		}
+
+		t.Logger.Info("collecting diagnostic bundle", "annotation", TriggerAnnotation, "value", value)
+		path, err := t.Bundler.Save(ctx, "requested with pod annotation "+TriggerAnnotation+"="+value)
+		if err != nil {
+			t.Logger.Error(err, "failed to collect diagnostic bundle")
+			return
+		}
+		t.Logger.Info("saved diagnostic bundle", "path", path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	check()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// NeedLeaderElection implements the controller-runtime manager.LeaderElectionRunnable
+// interface. Every replica watches its own pod.
+func (t *Trigger) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,192 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package heartbeat acts as a dead man's switch for the agent. It keeps a Heartbeat
+// resource in the store up to date, so the backend can tell an agent that went silent
+// from one that has nothing to report.
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/version"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+const (
+	heartbeatName = "heartbeat"
+
+	// ResourceType is the resource type of heartbeats. There is no generated message for
+	// it; its spec is a google.protobuf.Struct.
+	ResourceType = "antimetal.agent.v1.Heartbeat"
+
+	defaultInterval = time.Minute
+)
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+// Heartbeat upserts a Heartbeat resource named after the node every Interval. A
+// heartbeat whose timestamp stops advancing belongs to an agent that is dead, stuck or
+// can't reach the intake service.
+//
+// The spec of the resource is a google.protobuf.Struct with the timestamp of the beat,
+// the nodeName, agentVersion and commit of the agent, the time it started and, in
+// collectors, when each performance collector last collected successfully. A collector
+// whose time lags behind the timestamp is failing while the agent itself is alive.
+type Heartbeat struct {
+	Store    resource.Store
+	NodeName string
+	// Provider namespaces the heartbeat to the cluster. Optional.
+	Provider cluster.Provider
+	// Collections returns when each performance collector last succeeded, e.g.
+	// performance.Manager.LastSuccessfulCollections. Optional.
+	Collections func() map[performance.MetricType]time.Time
+	// Interval is how often the heartbeat is updated. Defaults to 1 minute.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the Heartbeat to the provided manager
+func (h *Heartbeat) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	runnable, err := h.runnable(mgr.GetLogger().WithName(heartbeatName))
+	if err != nil {
+		return err
+	}
+	return mgr.Add(runnable)
+}
+
+func (h *Heartbeat) runnable(logger logr.Logger) (*heartbeat, error) {
+	if h.Store == nil {
+		return nil, fmt.Errorf("Heartbeat must be configured with a non-nil Store")
+	}
+	if h.NodeName == "" {
+		return nil, fmt.Errorf("Heartbeat must be configured with a NodeName")
+	}
+	interval := h.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &heartbeat{
+		store:       h.Store,
+		nodeName:    h.NodeName,
+		provider:    h.Provider,
+		collections: h.Collections,
+		interval:    interval,
+		logger:      logger,
+		startedAt:   time.Now(),
+		now:         time.Now,
+	}, nil
+}
+
+type heartbeat struct {
+	store       resource.Store
+	nodeName    string
+	provider    cluster.Provider
+	collections func() map[performance.MetricType]time.Time
+	interval    time.Duration
+	logger      logr.Logger
+	startedAt   time.Time
+	now         func() time.Time
+
+	namespace *resourcev1.Namespace
+}
+
+func (h *heartbeat) Start(ctx context.Context) error {
+	if h.provider != nil {
+		clusterName, err := h.provider.ClusterName(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster name: %w", err)
+		}
+		h.namespace = &resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{
+					Cluster: clusterName,
+				},
+			},
+		}
+	}
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		if err := h.beat(); err != nil {
+			h.logger.Error(err, "failed to update heartbeat")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// the heartbeat is only written to the store shipped by the leader.
+func (h *heartbeat) NeedLeaderElection() bool {
+	return true
+}
+
+func (h *heartbeat) beat() error {
+	info := version.Get()
+	collectors := make(map[string]any)
+	if h.collections != nil {
+		last := h.collections()
+		for _, metricType := range slices.Sorted(maps.Keys(last)) {
+			collectors[string(metricType)] = last[metricType].UTC().Format(time.RFC3339Nano)
+		}
+	}
+	spec, err := structpb.NewStruct(map[string]any{
+		"timestamp":    h.now().UTC().Format(time.RFC3339Nano),
+		"nodeName":     h.nodeName,
+		"agentVersion": info.Version,
+		"commit":       info.Commit,
+		"startedAt":    h.startedAt.UTC().Format(time.RFC3339Nano),
+		"collectors":   collectors,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create heartbeat spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat spec: %w", err)
+	}
+
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: h.nodeName,
+			Name:       h.nodeName,
+			Namespace:  h.namespace,
+		},
+		Spec: specAny,
+	}
+	if h.provider != nil {
+		rsrc.Metadata.Provider = resourcev1.Provider_PROVIDER_KUBERNETES
+	}
+	// Heartbeats are periodic and each one supersedes the last
+	if err := h.store.UpdateResource(rsrc, resource.WithEventClass(resource.EventClassBulk)); err != nil {
+		return fmt.Errorf("failed to update heartbeat in inventory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/internal/version"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+func TestHeartbeat_Beat(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+
+	loadTime := time.Date(2026, 1, 1, 11, 59, 30, 0, time.UTC)
+	h := &Heartbeat{
+		Store:    inv,
+		NodeName: "node-1",
+		Collections: func() map[performance.MetricType]time.Time {
+			return map[performance.MetricType]time.Time{performance.MetricTypeLoad: loadTime}
+		},
+	}
+	runnable, err := h.runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create heartbeat: %v", err)
+	}
+
+	get := func() map[string]any {
+		t.Helper()
+		rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "node-1"})
+		if err != nil {
+			t.Fatalf("failed to get heartbeat: %v", err)
+		}
+		spec := &structpb.Struct{}
+		if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+			t.Fatalf("failed to unmarshal heartbeat spec: %v", err)
+		}
+		return spec.AsMap()
+	}
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	runnable.now = func() time.Time { return now }
+	if err := runnable.beat(); err != nil {
+		t.Fatalf("failed to beat: %v", err)
+	}
+	spec := get()
+	if spec["timestamp"] != "2026-01-01T12:00:00Z" {
+		t.Errorf("timestamp = %v, want 2026-01-01T12:00:00Z", spec["timestamp"])
+	}
+	if spec["nodeName"] != "node-1" {
+		t.Errorf("nodeName = %v, want node-1", spec["nodeName"])
+	}
+	if spec["agentVersion"] != version.Get().Version {
+		t.Errorf("agentVersion = %v, want %v", spec["agentVersion"], version.Get().Version)
+	}
+	collectors, _ := spec["collectors"].(map[string]any)
+	if collectors[string(performance.MetricTypeLoad)] != "2026-01-01T11:59:30Z" {
+		t.Errorf("collectors = %v, want load at 2026-01-01T11:59:30Z", collectors)
+	}
+
+	// Every beat updates the same resource
+	now = now.Add(time.Minute)
+	if err := runnable.beat(); err != nil {
+		t.Fatalf("failed to beat: %v", err)
+	}
+	if spec := get(); spec["timestamp"] != "2026-01-01T12:01:00Z" {
+		t.Errorf("timestamp = %v, want 2026-01-01T12:01:00Z", spec["timestamp"])
+	}
+
+	if _, err := (&Heartbeat{Store: inv}).runnable(logr.Discard()); err == nil {
+		t.Errorf("expected an error without a NodeName")
+	}
+}
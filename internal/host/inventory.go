@@ -0,0 +1,251 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package host indexes the host the agent runs on when it runs outside of Kubernetes, so
+// that the store has an inventory without the Kubernetes resources: the host, its systemd
+// services and its long-running processes.
+package host
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	inventoryName = "host-inventory"
+
+	// Resource types of the host inventory. There are no generated messages for them,
+	// their specs are google.protobuf.Structs.
+	HostResourceType    = "antimetal.agent.host.v1.Host"
+	ServiceResourceType = "antimetal.agent.host.v1.Service"
+	ProcessResourceType = "antimetal.agent.host.v1.Process"
+
+	defaultInterval      = time.Minute
+	defaultMinProcessAge = 5 * time.Minute
+)
+
+// Inventory periodically indexes the host the agent runs on, its systemd services and
+// its long-running processes:
+//
+//	Host -> Contains -> Service -> Contains -> Process
+//	Host -> Contains -> Process
+//
+// Services are the systemd units whose cgroup holds a process of the host, so only
+// running services are indexed. A process in the cgroup of a service is contained by
+// the service, other processes by the host. Kernel threads and processes younger than
+// MinProcessAge, e.g. shell commands and cron jobs, aren't indexed.
+//
+// The host is named HostName, services and processes <host>/<unit> and <host>/<pid>.
+// Their specs are google.protobuf.Structs with:
+//   - Host: hostname, kernelRelease, bootTime, and the virtualized and cloud of the
+//     environment
+//   - Service: unit and startTime, when its oldest process started
+//   - Process: pid, ppid, command, executable, uid, startTime and unit
+//
+// Process arguments aren't indexed since they may hold secrets.
+type Inventory struct {
+	Store    resource.Store
+	HostName string
+	// HostProcPath is where the host's /proc is mounted. Defaults to /proc.
+	HostProcPath string
+	// Environment is the environment of the host, see performance.DetectEnvironment
+	Environment performance.Environment
+	// Interval is how often the host is indexed. Defaults to 1 minute.
+	Interval time.Duration
+	// MinProcessAge is how long a process runs before it is indexed. Defaults to 5
+	// minutes.
+	MinProcessAge time.Duration
+}
+
+// SetupWithManager registers the Inventory to the provided manager
+func (i *Inventory) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	runnable, err := i.runnable(mgr.GetLogger().WithName(inventoryName))
+	if err != nil {
+		return err
+	}
+	return mgr.Add(runnable)
+}
+
+func (i *Inventory) runnable(logger logr.Logger) (*indexer, error) {
+	if i.Store == nil {
+		return nil, fmt.Errorf("Inventory must be configured with a non-nil Store")
+	}
+	if i.HostName == "" {
+		return nil, fmt.Errorf("Inventory must be configured with a HostName")
+	}
+	procPath := i.HostProcPath
+	if procPath == "" {
+		procPath = "/proc"
+	}
+	interval := i.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	minProcessAge := i.MinProcessAge
+	if minProcessAge <= 0 {
+		minProcessAge = defaultMinProcessAge
+	}
+	return &indexer{
+		hostName:      i.HostName,
+		procPath:      procPath,
+		env:           i.Environment,
+		interval:      interval,
+		minProcessAge: minProcessAge,
+		logger:        logger,
+		now:           time.Now,
+		// The host's resources have no provider
+		indexer: topology.NewIndexer(i.Store, resourcev1.Provider(0), "host resource", logger),
+	}, nil
+}
+
+type indexer struct {
+	hostName      string
+	procPath      string
+	env           performance.Environment
+	interval      time.Duration
+	minProcessAge time.Duration
+	logger        logr.Logger
+	now           func() time.Time
+	indexer       *topology.Indexer
+}
+
+func (i *indexer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+	for {
+		if err := i.sync(); err != nil {
+			i.logger.Error(err, "failed to index host")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable. The host
+// is local to every agent.
+func (i *indexer) NeedLeaderElection() bool {
+	return false
+}
+
+func (i *indexer) sync() error {
+	rsrcs, err := i.resources()
+	if err != nil {
+		return err
+	}
+	i.indexer.Sync(rsrcs)
+	return nil
+}
+
+// resources returns the host, its services and its long-running processes, containers
+// first. Services and processes are contained by the host or their service.
+func (i *indexer) resources() ([]topology.Resource, error) {
+	bootTime, err := readBootTime(i.procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read boot time: %w", err)
+	}
+	procs, err := readProcesses(i.procPath, bootTime)
+	if err != nil {
+		return nil, err
+	}
+
+	hostRef := i.ref(HostResourceType, i.hostName)
+	hostSpec := map[string]any{
+		"hostname":    i.hostName,
+		"bootTime":    bootTime.UTC().Format(time.RFC3339),
+		"virtualized": i.env.Virtualized,
+		"cloud":       i.env.Cloud,
+	}
+	if release, err := os.ReadFile(filepath.Join(i.procPath, "sys", "kernel", "osrelease")); err == nil {
+		hostSpec["kernelRelease"] = strings.TrimSpace(string(release))
+	}
+	rsrcs := []topology.Resource{{Ref: hostRef, Spec: hostSpec}}
+
+	services := make(map[string]time.Time)
+	var processes []topology.Resource
+	cutoff := i.now().Add(-i.minProcessAge)
+	for _, p := range procs {
+		if p.StartTime.After(cutoff) {
+			continue
+		}
+		container := hostRef
+		if p.Unit != "" {
+			if start, ok := services[p.Unit]; !ok || p.StartTime.Before(start) {
+				services[p.Unit] = p.StartTime
+			}
+			container = i.ref(ServiceResourceType, i.hostName+"/"+p.Unit)
+		}
+		ref := i.ref(ProcessResourceType, i.hostName+"/"+strconv.Itoa(int(p.PID)))
+		processes = append(processes, topology.Resource{
+			Ref: ref,
+			Spec: map[string]any{
+				"pid":        float64(p.PID),
+				"ppid":       float64(p.PPID),
+				"command":    p.Command,
+				"executable": p.Executable,
+				"uid":        float64(p.UID),
+				"startTime":  p.StartTime.UTC().Format(time.RFC3339),
+				"unit":       p.Unit,
+			},
+			Rels: contained(container, ref),
+		})
+	}
+
+	units := make([]string, 0, len(services))
+	for unit := range services {
+		units = append(units, unit)
+	}
+	slices.Sort(units)
+	for _, unit := range units {
+		ref := i.ref(ServiceResourceType, i.hostName+"/"+unit)
+		rsrcs = append(rsrcs, topology.Resource{
+			Ref: ref,
+			Spec: map[string]any{
+				"unit":      unit,
+				"startTime": services[unit].UTC().Format(time.RFC3339),
+			},
+			Rels: contained(hostRef, ref),
+		})
+	}
+	return append(rsrcs, processes...), nil
+}
+
+func (i *indexer) ref(typ, name string) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl: typ,
+		Name:    name,
+	}
+}
+
+// contained returns the relationships of container containing ref
+func contained(container, ref *resourcev1.ResourceRef) []topology.Relationship {
+	return []topology.Relationship{{
+		Subject:   container,
+		Object:    ref,
+		Predicate: (&k8sv1.Contains{}).ProtoReflect().Type(),
+		Inverse:   (&k8sv1.ContainedBy{}).ProtoReflect().Type(),
+	}}
+}
@@ -0,0 +1,210 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package host
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+// bootTime is the btime of the /proc of newTestProc
+var bootTime = time.Unix(1767225600, 0) // 2026-01-01T00:00:00Z
+
+// writeTestProcess writes the stat, comm, status and cgroup of a process started
+// startSeconds after boot
+func writeTestProcess(t *testing.T, procPath, pid, comm string, flags, startSeconds int, cgroup string) {
+	t.Helper()
+	pidPath := filepath.Join(procPath, pid)
+	if err := os.MkdirAll(pidPath, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	stat := pid + " (" + comm + ") S 1 1 1 0 -1 " + strconv.Itoa(flags) +
+		" 0 0 0 0 0 0 0 0 20 0 1 0 " + strconv.Itoa(startSeconds*userHZ) + " 0 0\n"
+	for name, content := range map[string]string{
+		"stat":   stat,
+		"comm":   comm + "\n",
+		"status": "Name:\t" + comm + "\nUid:\t1000\t1000\t1000\t1000\n",
+		"cgroup": cgroup,
+	} {
+		if err := os.WriteFile(filepath.Join(pidPath, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// newTestProc creates a /proc with systemd (1), nginx (10, 11) in nginx.service, a
+// shell in a session scope (20), a kernel thread (2) and a short-lived process (30)
+func newTestProc(t *testing.T) string {
+	t.Helper()
+	procPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procPath, "sys", "kernel"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	for name, content := range map[string]string{
+		"stat":                 "cpu  1 2 3 4\nbtime 1767225600\nprocesses 100\n",
+		"sys/kernel/osrelease": "6.8.0-1-generic\n",
+	} {
+		if err := os.WriteFile(filepath.Join(procPath, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	writeTestProcess(t, procPath, "1", "systemd", 0x400100, 1, "0::/init.scope\n")
+	writeTestProcess(t, procPath, "2", "kthreadd", 0x208040, 1, "0::/\n")
+	writeTestProcess(t, procPath, "10", "nginx", 0x400140, 10, "0::/system.slice/nginx.service\n")
+	writeTestProcess(t, procPath, "11", "nginx", 0x400140, 20, "0::/system.slice/nginx.service\n")
+	writeTestProcess(t, procPath, "20", "bash", 0x400100, 30, "0::/user.slice/user-1000.slice/session-1.scope\n")
+	writeTestProcess(t, procPath, "30", "sleep", 0x400000, 3590, "0::/system.slice/cron.service\n")
+	return procPath
+}
+
+func TestSystemdUnit(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgroup string
+		want   string
+	}{
+		{"cgroup v2 service", "0::/system.slice/nginx.service\n", "nginx.service"},
+		{"delegated subtree", "0::/system.slice/containerd.service/kubepods/pod1\n", "containerd.service"},
+		{"templated service", "0::/system.slice/system-getty.slice/getty@tty1.service\n", "getty@tty1.service"},
+		{"session scope", "0::/user.slice/user-1000.slice/session-1.scope\n", ""},
+		{"user manager", "0::/user.slice/user-1000.slice/user@1000.service/app.slice/dbus.service\n", ""},
+		{"root", "0::/\n", ""},
+		{
+			"cgroup v1",
+			"12:cpu,cpuacct:/system.slice/sshd.service\n1:name=systemd:/system.slice/sshd.service\n",
+			"sshd.service",
+		},
+		{
+			"hybrid",
+			"1:name=systemd:/system.slice/cron.service\n0::/system.slice/cron.service\n",
+			"cron.service",
+		},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := systemdUnit([]byte(tt.cgroup)); got != tt.want {
+				t.Errorf("systemdUnit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadProcesses(t *testing.T) {
+	procPath := newTestProc(t)
+	procs, err := readProcesses(procPath, bootTime)
+	if err != nil {
+		t.Fatalf("failed to read processes: %v", err)
+	}
+	got := make(map[int32]hostProcess)
+	for _, p := range procs {
+		got[p.PID] = p
+	}
+	if _, ok := got[2]; ok {
+		t.Errorf("expected kernel thread 2 to be skipped")
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 processes, got %d: %v", len(got), procs)
+	}
+	nginx := got[10]
+	want := hostProcess{
+		PID:       10,
+		PPID:      1,
+		Command:   "nginx",
+		UID:       1000,
+		StartTime: bootTime.Add(10 * time.Second),
+		Unit:      "nginx.service",
+	}
+	if nginx != want {
+		t.Errorf("expected %+v, got %+v", want, nginx)
+	}
+}
+
+func TestInventory_Sync(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+
+	procPath := newTestProc(t)
+	i := &Inventory{Store: inv, HostName: "host-1", HostProcPath: procPath}
+	runnable, err := i.runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	// An hour after boot, process 30 started 10s ago
+	runnable.now = func() time.Time { return bootTime.Add(time.Hour) }
+	if err := runnable.sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	hostRef := &resourcev1.ResourceRef{TypeUrl: HostResourceType, Name: "host-1"}
+	host, err := inv.GetResource(hostRef)
+	if err != nil {
+		t.Fatalf("failed to get host: %v", err)
+	}
+	spec := &structpb.Struct{}
+	if err := host.GetSpec().UnmarshalTo(spec); err != nil {
+		t.Fatalf("failed to unmarshal host spec: %v", err)
+	}
+	if got := spec.AsMap()["kernelRelease"]; got != "6.8.0-1-generic" {
+		t.Errorf("kernelRelease = %v, want 6.8.0-1-generic", got)
+	}
+	if got := spec.AsMap()["bootTime"]; got != "2026-01-01T00:00:00Z" {
+		t.Errorf("bootTime = %v, want 2026-01-01T00:00:00Z", got)
+	}
+
+	contained := func(container *resourcev1.ResourceRef) map[string]bool {
+		t.Helper()
+		rels, err := inv.GetRelationships(container, nil, &k8sv1.Contains{})
+		if err != nil {
+			t.Fatalf("failed to get relationships: %v", err)
+		}
+		names := make(map[string]bool)
+		for _, rel := range rels {
+			names[rel.GetObject().GetName()] = true
+		}
+		return names
+	}
+	serviceRef := &resourcev1.ResourceRef{TypeUrl: ServiceResourceType, Name: "host-1/nginx.service"}
+	if got, want := contained(hostRef), map[string]bool{
+		"host-1/nginx.service": true, "host-1/1": true, "host-1/20": true,
+	}; !maps.Equal(got, want) {
+		t.Errorf("expected the host to contain %v, got %v", want, got)
+	}
+	if got, want := contained(serviceRef), map[string]bool{"host-1/10": true, "host-1/11": true}; !maps.Equal(got, want) {
+		t.Errorf("expected nginx.service to contain %v, got %v", want, got)
+	}
+
+	// nginx stops, its service and processes are deleted
+	for _, pid := range []string{"10", "11"} {
+		if err := os.RemoveAll(filepath.Join(procPath, pid)); err != nil {
+			t.Fatalf("failed to remove process: %v", err)
+		}
+	}
+	if err := runnable.sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if _, err := inv.GetResource(serviceRef); err == nil {
+		t.Errorf("expected nginx.service to be deleted")
+	}
+	if got, want := contained(hostRef), map[string]bool{"host-1/1": true, "host-1/20": true}; !maps.Equal(got, want) {
+		t.Errorf("expected the host to contain %v, got %v", want, got)
+	}
+}
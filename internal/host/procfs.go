@@ -0,0 +1,195 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the USER_HZ clock tick rate of /proc/[pid]/stat, 100 on all architectures
+const userHZ = 100
+
+// pfKthread is the PF_KTHREAD flag of /proc/[pid]/stat set for kernel threads
+const pfKthread = 0x00200000
+
+// hostProcess is a user space process of the host
+type hostProcess struct {
+	PID        int32
+	PPID       int32
+	Command    string // From /proc/[pid]/comm
+	Executable string // Target of /proc/[pid]/exe, empty if it can't be read
+	UID        uint32
+	StartTime  time.Time
+	// Unit is the systemd unit whose cgroup the process is in, e.g. nginx.service, or
+	// empty outside of units
+	Unit string
+}
+
+// readBootTime returns the boot time from the btime line of /proc/stat
+func readBootTime(procPath string) (time.Time, error) {
+	file, err := os.Open(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			btime, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime %q: %w", value, err)
+			}
+			return time.Unix(btime, 0), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("btime not found in %s", file.Name())
+}
+
+// readProcesses returns the user space processes of the host. Kernel threads and
+// processes that exit while they are read are left out.
+func readProcesses(procPath string, bootTime time.Time) ([]hostProcess, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procPath, err)
+	}
+
+	var procs []hostProcess
+	for _, entry := range entries {
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		pidPath := filepath.Join(procPath, entry.Name())
+		proc, ok := readProcess(pidPath, int32(pid), bootTime)
+		if !ok {
+			continue
+		}
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+// readProcess reads the process at pidPath. It returns false for kernel threads and
+// processes that can't be read.
+//
+// Format of /proc/[pid]/stat: pid (comm) state ppid pgrp session tty_nr tpgid flags ...
+// starttime is field 22.
+func readProcess(pidPath string, pid int32, bootTime time.Time) (hostProcess, bool) {
+	data, err := os.ReadFile(filepath.Join(pidPath, "stat"))
+	if err != nil {
+		return hostProcess{}, false
+	}
+	line := string(data)
+	open := strings.IndexByte(line, '(')
+	closing := strings.LastIndexByte(line, ')')
+	if open < 0 || closing < open {
+		return hostProcess{}, false
+	}
+	// fields[0] is field 3 (state)
+	fields := strings.Fields(line[closing+1:])
+	if len(fields) < 20 {
+		return hostProcess{}, false
+	}
+	ppid, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return hostProcess{}, false
+	}
+	flags, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil || flags&pfKthread != 0 {
+		return hostProcess{}, false
+	}
+	startTicks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return hostProcess{}, false
+	}
+
+	proc := hostProcess{
+		PID:       pid,
+		PPID:      int32(ppid),
+		Command:   line[open+1 : closing],
+		StartTime: bootTime.Add(time.Duration(startTicks) * time.Second / userHZ),
+	}
+	if comm, err := os.ReadFile(filepath.Join(pidPath, "comm")); err == nil {
+		proc.Command = strings.TrimSpace(string(comm))
+	}
+	// Unreadable without root for processes of other users
+	proc.Executable, _ = os.Readlink(filepath.Join(pidPath, "exe"))
+	if uid, ok := readUID(filepath.Join(pidPath, "status")); ok {
+		proc.UID = uid
+	}
+	if cgroup, err := os.ReadFile(filepath.Join(pidPath, "cgroup")); err == nil {
+		proc.Unit = systemdUnit(cgroup)
+	}
+	return proc, true
+}
+
+// readUID returns the real UID from /proc/[pid]/status
+func readUID(path string) (uint32, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "Uid:"); ok {
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				return 0, false
+			}
+			uid, err := strconv.ParseUint(fields[0], 10, 32)
+			return uint32(uid), err == nil
+		}
+	}
+	return 0, false
+}
+
+// systemdUnit returns the systemd service or scope the cgroup of a process belongs to,
+// from the contents of /proc/[pid]/cgroup. It reads the unified hierarchy (0::/path) of
+// cgroup v2 and the name=systemd hierarchy of cgroup v1.
+//
+// A process in /system.slice/nginx.service/worker belongs to nginx.service. Processes
+// of user sessions (session-1.scope) and containers (docker-<id>.scope) belong to
+// scopes, which aren't reported. Neither are the user@.service units managing user
+// sessions.
+func systemdUnit(cgroup []byte) string {
+	var cgroupPath string
+	for line := range bytes.SplitSeq(cgroup, []byte("\n")) {
+		parts := strings.SplitN(string(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "name=systemd" || (parts[0] == "0" && parts[1] == "") {
+			cgroupPath = parts[2]
+			if parts[1] == "name=systemd" {
+				break
+			}
+		}
+	}
+
+	unit := ""
+	for dir := cgroupPath; dir != "/" && dir != "." && dir != ""; dir = path.Dir(dir) {
+		// The outermost service, nested cgroups being delegated to the service
+		if base := path.Base(dir); strings.HasSuffix(base, ".service") {
+			unit = base
+		}
+	}
+	if strings.HasPrefix(unit, "user@") {
+		return ""
+	}
+	return unit
+}
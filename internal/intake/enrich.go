@@ -0,0 +1,61 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"fmt"
+	"slices"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// enrich adds tags to the metadata of the resource in obj. Tags the resource already has,
+// e.g. from its Kubernetes labels, are kept. Event objects are copies of what the store
+// holds, so obj is modified in place.
+func (w *worker) enrich(obj *resourcev1.Object, tags map[string]string) error {
+	if len(tags) == 0 || !obj.GetObject().MessageIs(&resourcev1.Resource{}) {
+		return nil
+	}
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+		return fmt.Errorf("failed to unmarshal resource: %w", err)
+	}
+	if rsrc.Metadata == nil {
+		rsrc.Metadata = &resourcev1.ResourceMeta{}
+	}
+
+	existing := make(map[string]bool, len(rsrc.Metadata.Tags))
+	for _, tag := range rsrc.Metadata.Tags {
+		existing[tag.GetKey()] = true
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		if !existing[key] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	// Sorted so that the same tags always encode the same way
+	slices.Sort(keys)
+	for _, key := range keys {
+		rsrc.Metadata.Tags = append(rsrc.Metadata.Tags, &resourcev1.Tag{Key: key, Value: tags[key]})
+	}
+
+	value, err := proto.Marshal(rsrc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %w", err)
+	}
+	obj.Object = &anypb.Any{
+		TypeUrl: obj.GetObject().GetTypeUrl(),
+		Value:   value,
+	}
+	return nil
+}
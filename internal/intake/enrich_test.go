@@ -0,0 +1,52 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestWorker_Enrich(t *testing.T) {
+	value, err := anypb.New(&resourcev1.Resource{
+		Metadata: &resourcev1.ResourceMeta{
+			Name: "node-1",
+			Tags: []*resourcev1.Tag{{Key: "team", Value: "from-label"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &resourcev1.Object{Object: value}
+
+	w := &worker{}
+	if err := w.enrich(obj, map[string]string{"team": "storage", "rack": "r12", "datacenter": "dc2"}); err != nil {
+		t.Fatalf("enrich() failed: %v", err)
+	}
+
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*resourcev1.Tag{
+		{Key: "team", Value: "from-label"},
+		{Key: "datacenter", Value: "dc2"},
+		{Key: "rack", Value: "r12"},
+	}
+	tags := rsrc.GetMetadata().GetTags()
+	if len(tags) != len(expected) {
+		t.Fatalf("tags = %v, want %v", tags, expected)
+	}
+	for i := range expected {
+		if !proto.Equal(tags[i], expected[i]) {
+			t.Errorf("tag %d = %v, want %v", i, tags[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+)
+
+// Hook mutates, enriches or drops the objects the worker sends, e.g. to redact, tag or
+// downsample them. The worker runs its hooks in order on every object of every delta
+// before the object is batched: the redaction policy, then the tags, then the hooks of
+// WithHooks in the order they were registered.
+type Hook interface {
+	// Name identifies the hook in logs and metrics
+	Name() string
+	// Process is called with every outgoing object and the operation of its delta. Event
+	// objects are copies of what the store holds, so obj can be modified in place. It
+	// returns false to drop obj, which then isn't passed to the next hooks. An error is
+	// logged; whether obj is dropped is still up to keep.
+	Process(op intakev1.DeltaOperation, obj *resourcev1.Object) (keep bool, err error)
+}
+
+// NewHook returns a Hook named name that processes objects with process
+func NewHook(name string, process func(op intakev1.DeltaOperation, obj *resourcev1.Object) (bool, error)) Hook {
+	return hookFunc{name: name, process: process}
+}
+
+type hookFunc struct {
+	name    string
+	process func(op intakev1.DeltaOperation, obj *resourcev1.Object) (bool, error)
+}
+
+func (h hookFunc) Name() string {
+	return h.name
+}
+
+func (h hookFunc) Process(op intakev1.DeltaOperation, obj *resourcev1.Object) (bool, error) {
+	return h.process(op, obj)
+}
+
+// Results of a hook in the hook metrics
+const (
+	hookResultKept    = "kept"
+	hookResultDropped = "dropped"
+	hookResultError   = "error"
+)
+
+// buildHooks returns the hooks of w in the order they run
+func (w *worker) buildHooks() []Hook {
+	var hooks []Hook
+	if w.redaction != nil {
+		// Resources that can't be redacted are not sent
+		hooks = append(hooks, NewHook("redaction", func(_ intakev1.DeltaOperation, obj *resourcev1.Object) (bool, error) {
+			err := w.redact(obj)
+			return err == nil, err
+		}))
+	}
+	if w.tags != nil {
+		// Resources that can't be tagged are sent untagged
+		hooks = append(hooks, NewHook("tags", func(_ intakev1.DeltaOperation, obj *resourcev1.Object) (bool, error) {
+			return true, w.enrich(obj, w.tags())
+		}))
+	}
+	return append(hooks, w.extraHooks...)
+}
+
+// runHooks runs the hooks on obj and returns whether it is sent
+func (w *worker) runHooks(op intakev1.DeltaOperation, obj *resourcev1.Object) bool {
+	for _, hook := range w.hooks {
+		start := time.Now()
+		keep, err := hook.Process(op, obj)
+		hookDurationSeconds.WithLabelValues(hook.Name()).Observe(time.Since(start).Seconds())
+
+		result := hookResultKept
+		switch {
+		case err != nil:
+			result = hookResultError
+			w.logger.Error(err, "intake hook failed", "hook", hook.Name(), "type", obj.GetType().GetType(),
+				"dropped", !keep)
+		case !keep:
+			result = hookResultDropped
+		}
+		hookObjectsTotal.WithLabelValues(hook.Name(), result).Inc()
+		if !keep {
+			return false
+		}
+	}
+	return true
+}
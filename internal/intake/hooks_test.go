@@ -0,0 +1,78 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestWorker_RunHooks(t *testing.T) {
+	var calls []string
+	hook := func(name string, keep bool, err error) Hook {
+		return NewHook(name, func(_ intakev1.DeltaOperation, _ *resourcev1.Object) (bool, error) {
+			calls = append(calls, name)
+			return keep, err
+		})
+	}
+	tags := map[string]string{"team": "storage"}
+	w := &worker{
+		logger: logr.Discard(),
+		tags:   func() map[string]string { return tags },
+		extraHooks: []Hook{
+			hook("test-first", true, nil),
+			hook("test-failing", true, errors.New("boom")),
+			hook("test-drop", false, nil),
+			hook("test-never", true, nil),
+		},
+	}
+	w.hooks = w.buildHooks()
+
+	value, err := anypb.New(&resourcev1.Resource{Metadata: &resourcev1.ResourceMeta{Name: "node-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &resourcev1.Object{Object: value}
+	if w.runHooks(intakev1.DeltaOperation_DELTA_OPERATION_CREATE, obj) {
+		t.Errorf("expected the object to be dropped")
+	}
+	if want := []string{"test-first", "test-failing", "test-drop"}; !slices.Equal(calls, want) {
+		t.Errorf("expected hooks %v to run, got %v", want, calls)
+	}
+
+	// The tags ran before the registered hooks
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+		t.Fatal(err)
+	}
+	if got := rsrc.GetMetadata().GetTags(); len(got) != 1 || got[0].GetKey() != "team" {
+		t.Errorf("expected the object to be tagged, got %v", got)
+	}
+
+	for _, tt := range []struct {
+		hook, result string
+		want         float64
+	}{
+		{"tags", hookResultKept, 1},
+		{"test-first", hookResultKept, 1},
+		{"test-failing", hookResultError, 1},
+		{"test-drop", hookResultDropped, 1},
+		{"test-never", hookResultKept, 0},
+	} {
+		if got := testutil.ToFloat64(hookObjectsTotal.WithLabelValues(tt.hook, tt.result)); got != tt.want {
+			t.Errorf("expected %v %s objects for hook %s, got %v", tt.want, tt.result, tt.hook, got)
+		}
+	}
+}
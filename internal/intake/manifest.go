@@ -0,0 +1,122 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"fmt"
+	"slices"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// ManifestResourceType is the resource type of the capability manifest. There is no
+// generated message for it; its spec is a google.protobuf.Struct.
+const ManifestResourceType = "antimetal.agent.v1.CapabilityManifest"
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+// Manifest declares what the agent collects given its platform and configuration, so that
+// the intake can tell a metric type, resource type or relationship predicate the agent
+// doesn't collect from one it collects but has no data for.
+type Manifest struct {
+	// Name identifies the agent, e.g. the node it runs on
+	Name string
+	// Features are the optional features of the agent by name, and whether they are enabled
+	Features map[string]bool
+	// MetricTypes are the performance collectors that run
+	MetricTypes []string
+	// ResourceTypes are the types of the resources the agent sends, and Predicates the
+	// types of the predicates of its relationships
+	ResourceTypes []string
+	Predicates    []string
+}
+
+// WithManifest sets the capability manifest sent with the first request of every stream.
+// manifest is called for each stream, so that it reflects components started since the
+// worker was created.
+func WithManifest(manifest func() Manifest) WorkerOpts {
+	return func(w *worker) {
+		w.manifest = manifest
+	}
+}
+
+// manifestDelta returns the delta carrying m as a resource of type ManifestResourceType.
+// Lists are sorted and deduplicated so that the same manifest always encodes the same way.
+func manifestDelta(m Manifest) (*intakev1.Delta, error) {
+	features := make(map[string]any, len(m.Features))
+	for name, enabled := range m.Features {
+		features[name] = enabled
+	}
+	list := func(items []string) []any {
+		items = slices.Compact(slices.Sorted(slices.Values(items)))
+		values := make([]any, len(items))
+		for i, item := range items {
+			values[i] = item
+		}
+		return values
+	}
+	spec, err := structpb.NewStruct(map[string]any{
+		"features":      features,
+		"metricTypes":   list(m.MetricTypes),
+		"resourceTypes": list(m.ResourceTypes),
+		"predicates":    list(m.Predicates),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest spec: %w", err)
+	}
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ManifestResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: m.Name,
+			Name:       m.Name,
+		},
+		Spec: specAny,
+	}
+	rsrcAny, err := anypb.New(rsrc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	// Each manifest replaces the previous one, and expires with the agent like any object
+	return &intakev1.Delta{
+		Op: intakev1.DeltaOperation_DELTA_OPERATION_UPDATE,
+		Objects: []*resourcev1.Object{
+			{
+				Type:         rsrc.GetType(),
+				Object:       rsrcAny,
+				DeltaVersion: deltaVersion,
+				Ttl:          durationpb.New(defaultDeltaTTL),
+			},
+		},
+	}, nil
+}
+
+// request returns the request sending deltas on the stream of l, led by the manifest if
+// it wasn't sent on the stream yet
+func (w *worker) request(l *lane, deltas []*intakev1.Delta) *intakev1.DeltaRequest {
+	if w.manifest == nil || !l.manifestPending {
+		return &intakev1.DeltaRequest{Deltas: deltas}
+	}
+	delta, err := manifestDelta(w.manifest())
+	if err != nil {
+		w.logger.Error(err, "failed to build capability manifest", "priority", l.priority)
+		return &intakev1.DeltaRequest{Deltas: deltas}
+	}
+	return &intakev1.DeltaRequest{Deltas: append([]*intakev1.Delta{delta}, deltas...)}
+}
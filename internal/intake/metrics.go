@@ -0,0 +1,67 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	queueBatches = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "antimetal_intake_queue_batches",
+		Help: "Number of batches in the persistent intake queue waiting to be sent.",
+	}, []string{"priority"})
+	queueDeltas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "antimetal_intake_queue_deltas",
+		Help: "Number of deltas in the persistent intake queue waiting to be sent.",
+	}, []string{"priority"})
+	queueBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "antimetal_intake_queue_bytes",
+		Help: "Encoded size of the deltas in the persistent intake queue waiting to be sent.",
+	}, []string{"priority"})
+	queueOldestAgeSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "antimetal_intake_queue_oldest_batch_age_seconds",
+		Help: "Age of the oldest batch in the persistent intake queue. 0 if the queue is empty.",
+	}, []string{"priority"})
+	hookObjectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "antimetal_intake_hook_objects_total",
+		Help: "Number of outgoing objects processed by each intake hook, by result: kept, dropped or error.",
+	}, []string{"hook", "result"})
+	hookDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "antimetal_intake_hook_duration_seconds",
+		Help:    "Time each intake hook took to process an outgoing object.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"hook"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		queueBatches,
+		queueDeltas,
+		queueBytes,
+		queueOldestAgeSeconds,
+		hookObjectsTotal,
+		hookDurationSeconds,
+	)
+}
+
+// updateQueueMetrics sets the queue gauges of every lane from stats
+func updateQueueMetrics(stats map[string]QueueStats, now time.Time) {
+	for p, s := range stats {
+		queueBatches.WithLabelValues(p).Set(float64(s.Batches))
+		queueDeltas.WithLabelValues(p).Set(float64(s.Deltas))
+		queueBytes.WithLabelValues(p).Set(float64(s.Bytes))
+		var age float64
+		if !s.Oldest.IsZero() {
+			age = now.Sub(s.Oldest).Seconds()
+		}
+		queueOldestAgeSeconds.WithLabelValues(p).Set(age)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type priority int
+
+const (
+	priorityBulk priority = iota
+	priorityUrgent
+)
+
+func (p priority) String() string {
+	switch p {
+	case priorityUrgent:
+		return "urgent"
+	default:
+		return "bulk"
+	}
+}
+
+const reasonOOMKilled = "OOMKilled"
+
+// lane is an intake stream with its own queue and pending batch. Each lane is sent
+// independently, so the deltas of one lane never wait for the other's.
+//
+// Deltas of different lanes can be delivered out of order, e.g. the delete of a resource
+// can overtake its last update still queued in the bulk lane.
+type lane struct {
+	priority priority
+	queue    workqueue.TypedRateLimitingInterface[*deltasBatch]
+	batch    *deltasBatch
+	mu       sync.Mutex
+
+	// disk persists flushed batches until they were sent, if set
+	disk   *Queue
+	logger logr.Logger
+
+	// runtime fields
+	stream       intakev1.IntakeService_DeltaClient
+	streamCancel context.CancelFunc
+	streamID     string
+	streamOpened time.Time
+	// manifestPending is set until the capability manifest was sent on the stream
+	manifestPending bool
+	// token is the resume token of the newest batch sent, sent to the intake when a new
+	// stream is opened
+	token string
+}
+
+// newLane returns the lane of priority p. Batches are queued without delay and the rate
+// limiter only delays retries. The bulk lane also bounds the overall rate of retries, while
+// the urgent lane backs off each batch on its own so that a burst of failures doesn't
+// hold up the next urgent deltas.
+func newLane(p priority) *lane {
+	ratelimiter := workqueue.DefaultTypedControllerRateLimiter[*deltasBatch]()
+	if p == priorityUrgent {
+		ratelimiter = workqueue.NewTypedItemExponentialFailureRateLimiter[*deltasBatch](
+			5*time.Millisecond, 1000*time.Second)
+	}
+	queue := workqueue.NewTypedRateLimitingQueueWithConfig(ratelimiter,
+		workqueue.TypedRateLimitingQueueConfig[*deltasBatch]{
+			Name: workerName + "-" + p.String(),
+		},
+	)
+	return &lane{
+		priority: p,
+		queue:    queue,
+		batch:    newDeltasBatch([]*intakev1.Delta{}),
+	}
+}
+
+// add appends delta, of the store event with resumeToken, to the pending batch and returns
+// the size of the batch
+func (l *lane) add(delta *intakev1.Delta, resumeToken string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.batch.deltas = append(l.batch.deltas, delta)
+	if resumeToken != "" {
+		l.batch.token = resumeToken
+	}
+	return len(l.batch.deltas)
+}
+
+// flush queues the pending batch for sending. With a persistent queue the batch is
+// persisted first; batches that fail to persist are still sent, but are lost if the agent
+// restarts before.
+func (l *lane) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.batch.deltas) == 0 {
+		return
+	}
+
+	if l.disk != nil {
+		seq, err := l.disk.append(l.priority, l.batch.deltas)
+		if err != nil {
+			l.logger.Error(err, "failed to persist batch, keeping it in memory only", "priority", l.priority)
+		}
+		l.batch.seq = seq
+	}
+	l.queue.Add(l.batch)
+	l.batch = newDeltasBatch([]*intakev1.Delta{})
+}
+
+// deltaPriority returns the lane delta, from an event of class, is sent on. Critical
+// events are urgent and bulk events are not, whatever they contain. Of the others, deletes
+// and critical state changes are urgent: nodes that are not ready and pods with containers
+// killed for running out of memory. Everything else is bulk.
+func deltaPriority(class resource.EventClass, delta *intakev1.Delta) priority {
+	switch class {
+	case resource.EventClassCritical:
+		return priorityUrgent
+	case resource.EventClassBulk:
+		return priorityBulk
+	}
+	if delta.GetOp() == intakev1.DeltaOperation_DELTA_OPERATION_DELETE {
+		return priorityUrgent
+	}
+	for _, obj := range delta.GetObjects() {
+		if isCritical(obj) {
+			return priorityUrgent
+		}
+	}
+	return priorityBulk
+}
+
+// isCritical reports whether obj is a Kubernetes resource in a critical state. Objects
+// that can't be decoded are not critical.
+func isCritical(obj *resourcev1.Object) bool {
+	if !obj.GetObject().MessageIs(&resourcev1.Resource{}) {
+		return false
+	}
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+		return false
+	}
+	if rsrc.GetMetadata().GetProvider() != resourcev1.Provider_PROVIDER_KUBERNETES {
+		return false
+	}
+
+	switch typeurl.ToName(rsrc.GetSpec().GetTypeUrl()) {
+	case typeurl.Name(&corev1.Node{}):
+		node := &corev1.Node{}
+		if err := node.Unmarshal(rsrc.GetSpec().GetValue()); err != nil {
+			return false
+		}
+		return !nodeReady(node)
+	case typeurl.Name(&corev1.Pod{}):
+		pod := &corev1.Pod{}
+		if err := pod.Unmarshal(rsrc.GetSpec().GetValue()); err != nil {
+			return false
+		}
+		return podOOMKilled(pod)
+	}
+	return false
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	// Nodes that haven't reported their conditions yet are still registering
+	return true
+}
+
+// podOOMKilled reports whether a container of pod is, or last was, terminated because it
+// ran out of memory
+func podOOMKilled(pod *corev1.Pod) bool {
+	for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		for _, state := range []corev1.ContainerState{status.State, status.LastTerminationState} {
+			if state.Terminated != nil && state.Terminated.Reason == reasonOOMKilled {
+				return true
+			}
+		}
+	}
+	return false
+}
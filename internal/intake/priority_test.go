@@ -0,0 +1,117 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func kubernetesObject(t *testing.T, obj gogoproto.Message) *resourcev1.Object {
+	t.Helper()
+	spec, err := gogoproto.Marshal(obj)
+	if err != nil {
+		t.Fatalf("failed to marshal spec: %v", err)
+	}
+	rsrc, err := anypb.New(&resourcev1.Resource{
+		Metadata: &resourcev1.ResourceMeta{Provider: resourcev1.Provider_PROVIDER_KUBERNETES},
+		Spec:     &anypb.Any{TypeUrl: typeurl.URL(obj), Value: spec},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal resource: %v", err)
+	}
+	return &resourcev1.Object{Object: rsrc}
+}
+
+func TestDeltaPriority(t *testing.T) {
+	readyNode := &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+		{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+	}}}
+	downNode := &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+		{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+		{Type: corev1.NodeReady, Status: corev1.ConditionUnknown},
+	}}}
+	oomPod := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+		{
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+			},
+		},
+	}}}
+	completedPod := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+		{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}}},
+	}}}
+
+	tests := []struct {
+		name     string
+		class    resource.EventClass
+		op       intakev1.DeltaOperation
+		objs     []*resourcev1.Object
+		expected priority
+	}{
+		{"delete", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_DELETE, []*resourcev1.Object{kubernetesObject(t, readyNode)}, priorityUrgent},
+		{"ready node", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_UPDATE, []*resourcev1.Object{kubernetesObject(t, readyNode)}, priorityBulk},
+		{"node down", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_UPDATE, []*resourcev1.Object{kubernetesObject(t, downNode)}, priorityUrgent},
+		{"new node", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_CREATE, []*resourcev1.Object{kubernetesObject(t, &corev1.Node{})}, priorityBulk},
+		{"OOM killed pod", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_UPDATE, []*resourcev1.Object{kubernetesObject(t, completedPod), kubernetesObject(t, oomPod)}, priorityUrgent},
+		{"completed pod", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_UPDATE, []*resourcev1.Object{kubernetesObject(t, completedPod)}, priorityBulk},
+		{"relationship", resource.EventClassNormal, intakev1.DeltaOperation_DELTA_OPERATION_CREATE, []*resourcev1.Object{{}}, priorityBulk},
+		{"critical event", resource.EventClassCritical, intakev1.DeltaOperation_DELTA_OPERATION_UPDATE, []*resourcev1.Object{kubernetesObject(t, readyNode)}, priorityUrgent},
+		{"resync of node down", resource.EventClassBulk, intakev1.DeltaOperation_DELTA_OPERATION_UPDATE, []*resourcev1.Object{kubernetesObject(t, downNode)}, priorityBulk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deltaPriority(tt.class, &intakev1.Delta{Op: tt.op, Objects: tt.objs})
+			if got != tt.expected {
+				t.Errorf("deltaPriority() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLane_UrgentNotRateLimited(t *testing.T) {
+	l := newLane(priorityUrgent)
+	defer l.queue.ShutDown()
+
+	// More urgent deltas than the burst of 100 of the default controller rate limiter,
+	// which would delay the rest by 100ms each
+	const n = 150
+	start := time.Now()
+	batches := make([]*deltasBatch, 0, n)
+	for range n {
+		l.add(&intakev1.Delta{Op: intakev1.DeltaOperation_DELTA_OPERATION_DELETE}, "")
+		l.flush()
+		batch, _ := l.queue.Get()
+		l.queue.Done(batch)
+		batches = append(batches, batch)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected %d urgent batches to be queued without delay, took %v", n, elapsed)
+	}
+
+	// Retries back off each batch on its own
+	start = time.Now()
+	for _, batch := range batches {
+		l.queue.AddRateLimited(batch)
+	}
+	for range n {
+		batch, _ := l.queue.Get()
+		l.queue.Done(batch)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the first retries of %d urgent batches to be delayed by a few ms, took %v", n, elapsed)
+	}
+}
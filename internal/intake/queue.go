@@ -0,0 +1,336 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+var queuePrefix = []byte("batch/")
+
+// queueHeaderSize is the size of the header of queued values: the time the batch was
+// queued in Unix nanoseconds and the number of its deltas
+const queueHeaderSize = 12
+
+// Queue persists the batches of deltas waiting to be sent to the intake, so that the
+// deltas pending upload survive agent restarts, e.g. during a long intake outage, and can
+// be inspected and managed offline.
+//
+// Batches are keyed by the priority of their lane and a sequence number that keeps
+// increasing across restarts, so each lane replays its batches in the order they were
+// queued. A batch is deleted once it was sent.
+type Queue struct {
+	db *badger.DB
+
+	mu sync.Mutex
+	// seq is the sequence number of the last queued batch
+	seq     uint64
+	pending map[queueKey]queueEntry
+}
+
+type queueKey struct {
+	priority priority
+	seq      uint64
+}
+
+type queueEntry struct {
+	queued time.Time
+	deltas int
+	size   int
+}
+
+// QueuedBatch is a batch of deltas waiting in a Queue
+type QueuedBatch struct {
+	Seq      uint64
+	Priority string
+	Queued   time.Time
+	Deltas   []*intakev1.Delta
+	// Size is the encoded size of the deltas in bytes
+	Size int
+}
+
+// QueueStats summarizes the batches of a lane waiting in a Queue
+type QueueStats struct {
+	Batches int
+	Deltas  int
+	Bytes   int
+	// Oldest is when the oldest batch was queued, zero if there is none
+	Oldest time.Time
+}
+
+// OpenQueue opens the queue persisted in dir, creating it if it doesn't exist. If
+// encryptionKey is set the queue is encrypted at rest with it, like the resource store.
+func OpenQueue(dir string, encryptionKey []byte) (*Queue, error) {
+	if dir == "" {
+		return nil, errors.New("queue directory is required")
+	}
+	opts := badger.DefaultOptions(dir).WithLogger(nil)
+	if encryptionKey != nil {
+		opts = opts.WithEncryptionKey(encryptionKey).WithIndexCacheSize(16 << 20)
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open intake queue: %w", err)
+	}
+
+	q := &Queue{db: db, pending: make(map[queueKey]queueEntry)}
+	err = q.scan(func(key queueKey, value []byte) error {
+		entry, _, err := decodeQueueValue(value)
+		if err != nil {
+			return err
+		}
+		q.pending[key] = entry
+		q.seq = max(q.seq, key.seq)
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load intake queue: %w", err)
+	}
+	return q, nil
+}
+
+// Close closes the queue
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// append persists a batch of deltas of lane p and returns its sequence number
+func (q *Queue) append(p priority, deltas []*intakev1.Delta) (uint64, error) {
+	data, err := proto.Marshal(&intakev1.DeltaRequest{Deltas: deltas})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal deltas: %w", err)
+	}
+	entry := queueEntry{queued: time.Now(), deltas: len(deltas), size: len(data)}
+	value := make([]byte, queueHeaderSize, queueHeaderSize+len(data))
+	binary.BigEndian.PutUint64(value, uint64(entry.queued.UnixNano()))
+	binary.BigEndian.PutUint32(value[8:], uint32(entry.deltas))
+	value = append(value, data...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	key := queueKey{priority: p, seq: q.seq}
+	if err := q.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key.bytes(), value)
+	}); err != nil {
+		return 0, err
+	}
+	q.pending[key] = entry
+	return key.seq, nil
+}
+
+// remove deletes the batch of lane p with sequence number seq
+func (q *Queue) remove(p priority, seq uint64) error {
+	key := queueKey{priority: p, seq: seq}
+	if err := q.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key.bytes())
+	}); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, key)
+	return nil
+}
+
+// List returns the queued batches by lane, each lane's in queue order
+func (q *Queue) List() ([]QueuedBatch, error) {
+	var batches []QueuedBatch
+	err := q.scan(func(key queueKey, value []byte) error {
+		entry, data, err := decodeQueueValue(value)
+		if err != nil {
+			return err
+		}
+		req := &intakev1.DeltaRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			return fmt.Errorf("failed to unmarshal batch %d: %w", key.seq, err)
+		}
+		batches = append(batches, QueuedBatch{
+			Seq:      key.seq,
+			Priority: key.priority.String(),
+			Queued:   entry.queued,
+			Deltas:   req.GetDeltas(),
+			Size:     entry.size,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// Stats returns the stats of the queued batches keyed by the priority of their lane
+func (q *Queue) Stats() map[string]QueueStats {
+	stats := map[string]QueueStats{
+		priorityUrgent.String(): {},
+		priorityBulk.String():   {},
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for key, entry := range q.pending {
+		s := stats[key.priority.String()]
+		s.Batches++
+		s.Deltas += entry.deltas
+		s.Bytes += entry.size
+		if s.Oldest.IsZero() || entry.queued.Before(s.Oldest) {
+			s.Oldest = entry.queued
+		}
+		stats[key.priority.String()] = s
+	}
+	return stats
+}
+
+// Purge deletes the batches queued before before and returns how many were deleted
+func (q *Queue) Purge(before time.Time) (int, error) {
+	q.mu.Lock()
+	var keys []queueKey
+	for key, entry := range q.pending {
+		if entry.queued.Before(before) {
+			keys = append(keys, key)
+		}
+	}
+	q.mu.Unlock()
+
+	for i, key := range keys {
+		if err := q.remove(key.priority, key.seq); err != nil {
+			return i, fmt.Errorf("failed to delete batch %d: %w", key.seq, err)
+		}
+	}
+	return len(keys), nil
+}
+
+// ReplayQueue sends the batches in queue to the intake over conn outside of a worker, e.g.
+// to flush the queue of a node that is being decommissioned, and returns how many were
+// sent. Each lane is sent on its own stream, and its batches are deleted once the intake
+// acknowledged the stream.
+//
+// The objects are sent with the delta version they were queued with, so unless the run
+// of the agent that queued them is still sending heartbeats they expire after their TTL.
+func ReplayQueue(ctx context.Context, queue *Queue, conn *grpc.ClientConn, apiKey string,
+	labels map[string]string) (int, error) {
+	batches, err := queue.List()
+	if err != nil {
+		return 0, err
+	}
+	byPriority := make(map[string][]QueuedBatch)
+	for _, b := range batches {
+		byPriority[b.Priority] = append(byPriority[b.Priority], b)
+	}
+
+	client := intakev1.NewIntakeServiceClient(conn)
+	sent := 0
+	for _, p := range []priority{priorityUrgent, priorityBulk} {
+		n, err := replayLane(ctx, queue, client, apiKey, labels, p, byPriority[p.String()])
+		sent += n
+		if err != nil {
+			return sent, fmt.Errorf("failed to replay %s batches: %w", p, err)
+		}
+	}
+	return sent, nil
+}
+
+func replayLane(ctx context.Context, queue *Queue, client intakev1.IntakeServiceClient, apiKey string,
+	labels map[string]string, p priority, batches []QueuedBatch) (int, error) {
+	if len(batches) == 0 {
+		return 0, nil
+	}
+
+	stream, err := client.Delta(metadata.NewOutgoingContext(ctx, streamMetadata(p, apiKey, "", labels)))
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range batches {
+		if err := stream.Send(&intakev1.DeltaRequest{Deltas: b.Deltas}); err != nil {
+			// Send only returns io.EOF when the stream failed, the actual error is
+			// returned by CloseAndRecv
+			if _, closeErr := stream.CloseAndRecv(); closeErr != nil {
+				err = closeErr
+			}
+			return 0, err
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return 0, err
+	}
+
+	for i, b := range batches {
+		if err := queue.remove(p, b.Seq); err != nil {
+			return i, fmt.Errorf("failed to delete sent batch %d: %w", b.Seq, err)
+		}
+	}
+	return len(batches), nil
+}
+
+// scan calls fn with the key and value of every queued batch in key order
+func (q *Queue) scan(fn func(key queueKey, value []byte) error) error {
+	return q.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = queuePrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key, err := parseQueueKey(item.Key())
+			if err != nil {
+				return err
+			}
+			if err := item.Value(func(value []byte) error {
+				return fn(key, value)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// bytes encodes the key as the queue prefix, the priority and the big endian sequence
+// number, so that the batches of a lane sort in queue order
+func (k queueKey) bytes() []byte {
+	key := make([]byte, 0, len(queuePrefix)+9)
+	key = append(key, queuePrefix...)
+	key = append(key, byte(k.priority))
+	return binary.BigEndian.AppendUint64(key, k.seq)
+}
+
+func parseQueueKey(key []byte) (queueKey, error) {
+	rest, ok := bytes.CutPrefix(key, queuePrefix)
+	if !ok || len(rest) != 9 {
+		return queueKey{}, fmt.Errorf("invalid queue key %q", key)
+	}
+	return queueKey{priority: priority(rest[0]), seq: binary.BigEndian.Uint64(rest[1:])}, nil
+}
+
+// decodeQueueValue returns the entry of a queued value and its encoded deltas. The
+// deltas are only valid as long as value.
+func decodeQueueValue(value []byte) (queueEntry, []byte, error) {
+	if len(value) < queueHeaderSize {
+		return queueEntry{}, nil, fmt.Errorf("invalid queue value of %d bytes", len(value))
+	}
+	data := value[queueHeaderSize:]
+	return queueEntry{
+		queued: time.Unix(0, int64(binary.BigEndian.Uint64(value))),
+		deltas: int(binary.BigEndian.Uint32(value[8:])),
+		size:   len(data),
+	}, data, nil
+}
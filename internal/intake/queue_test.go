@@ -0,0 +1,120 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"testing"
+	"time"
+
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+)
+
+func createDeltas(n int) []*intakev1.Delta {
+	deltas := make([]*intakev1.Delta, n)
+	for i := range deltas {
+		deltas[i] = &intakev1.Delta{Op: intakev1.DeltaOperation_DELTA_OPERATION_CREATE}
+	}
+	return deltas
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+
+	q, err := OpenQueue(dir, key)
+	if err != nil {
+		t.Fatalf("failed to open queue: %v", err)
+	}
+	sent, err := q.append(priorityBulk, createDeltas(1))
+	if err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+	if _, err := q.append(priorityUrgent, createDeltas(2)); err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+	if _, err := q.append(priorityBulk, createDeltas(3)); err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+	if err := q.remove(priorityBulk, sent); err != nil {
+		t.Fatalf("failed to remove batch: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %v", err)
+	}
+
+	// The batches left survive reopening the queue, in queue order per lane
+	q, err = OpenQueue(dir, key)
+	if err != nil {
+		t.Fatalf("failed to reopen queue: %v", err)
+	}
+	defer q.Close()
+
+	batches, err := q.List()
+	if err != nil {
+		t.Fatalf("failed to list batches: %v", err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(batches))
+	}
+	for _, b := range batches {
+		want := 3
+		if b.Priority == priorityUrgent.String() {
+			want = 2
+		}
+		if len(b.Deltas) != want {
+			t.Errorf("expected %d deltas in %s batch %d, got %d", want, b.Priority, b.Seq, len(b.Deltas))
+		}
+	}
+
+	stats := q.Stats()
+	if s := stats[priorityBulk.String()]; s.Batches != 1 || s.Deltas != 3 || s.Oldest.IsZero() {
+		t.Errorf("unexpected bulk stats: %+v", s)
+	}
+	if s := stats[priorityUrgent.String()]; s.Batches != 1 || s.Deltas != 2 || s.Oldest.IsZero() {
+		t.Errorf("unexpected urgent stats: %+v", s)
+	}
+
+	// Sequence numbers keep increasing across restarts
+	seq, err := q.append(priorityBulk, createDeltas(1))
+	if err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+	if seq != 4 {
+		t.Errorf("expected sequence number 4, got %d", seq)
+	}
+
+	purged, err := q.Purge(time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("expected no batch queued an hour ago, purged %d: %v", purged, err)
+	}
+	purged, err = q.Purge(time.Now())
+	if err != nil || purged != 3 {
+		t.Errorf("expected to purge 3 batches, purged %d: %v", purged, err)
+	}
+	if batches, _ := q.List(); len(batches) != 0 {
+		t.Errorf("expected empty queue after purge, got %d batches", len(batches))
+	}
+}
+
+func TestOpenQueueWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(dir, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("failed to open queue: %v", err)
+	}
+	if _, err := q.append(priorityBulk, createDeltas(1)); err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+	q.Close()
+
+	wrongKey := make([]byte, 32)
+	wrongKey[0] = 1
+	if q, err := OpenQueue(dir, wrongKey); err == nil {
+		q.Close()
+		t.Fatal("expected opening the queue with another key to fail")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"fmt"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// redact applies the worker's redaction policy to the Kubernetes spec of obj. Event
+// objects are copies of what the store holds, so obj is modified in place.
+func (w *worker) redact(obj *resourcev1.Object) error {
+	if !obj.GetObject().MessageIs(&resourcev1.Resource{}) {
+		return nil
+	}
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+		return fmt.Errorf("failed to unmarshal resource: %w", err)
+	}
+	if rsrc.GetMetadata().GetProvider() != resourcev1.Provider_PROVIDER_KUBERNETES || rsrc.GetSpec() == nil {
+		return nil
+	}
+
+	spec, err := w.redaction.KubernetesProto(typeurl.ToName(rsrc.GetSpec().GetTypeUrl()), rsrc.GetSpec().GetValue())
+	if err != nil {
+		return err
+	}
+	rsrc.Spec.Value = spec
+	value, err := proto.Marshal(rsrc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %w", err)
+	}
+	obj.Object = &anypb.Any{
+		TypeUrl: obj.GetObject().GetTypeUrl(),
+		Value:   value,
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package testserver provides an in-process intake service for tests. It records the
+// deltas it receives and can inject faults (authentication failures, stream resets and
+// slow receives) to exercise the intake worker's retry and buffering behavior.
+package testserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const headerAuthorize = "authorization"
+
+// The stream resumption handshake of the intake worker
+const (
+	headerResumeToken = "x-intake-resume-token"
+	trailerRelistFrom = "x-intake-relist-from"
+)
+
+// The stream rotation of the intake worker
+const (
+	headerStreamID        = "x-intake-stream-id"
+	headerStreamContinues = "x-intake-stream-continues"
+)
+
+// headerLabels carries the labels of the intake worker
+const headerLabels = "x-intake-labels"
+
+type options struct {
+	addr         string
+	apiKey       string
+	authFailures int
+	resetEvery   int
+	relist       bool
+	recvDelay    time.Duration
+	onDelta      func(*intakev1.Delta)
+}
+
+// Option configures a Server created with New.
+type Option func(*options)
+
+// WithAddress listens on addr instead of a random localhost port, e.g. 0.0.0.0:0 to
+// accept connections from agents running in containers.
+func WithAddress(addr string) Option {
+	return func(o *options) {
+		o.addr = addr
+	}
+}
+
+// WithAPIKey requires streams to authenticate with key as a bearer token, like the
+// intake service does. Streams without it fail with codes.Unauthenticated.
+func WithAPIKey(key string) Option {
+	return func(o *options) {
+		o.apiKey = key
+	}
+}
+
+// WithAuthFailures fails the first n streams with codes.Unauthenticated regardless of
+// their credentials.
+func WithAuthFailures(n int) Option {
+	return func(o *options) {
+		o.authFailures = n
+	}
+}
+
+// WithStreamResets ends every stream with codes.Unavailable once it received n
+// requests, as the intake service does when a connection is drained. The requests
+// received before the reset are recorded.
+func WithStreamResets(n int) Option {
+	return func(o *options) {
+		o.resetEvery = n
+	}
+}
+
+// WithRelistOnReset asks the agent for the changes since the resume token of a stream
+// when the stream is reset, as if the server had lost the deltas received on it.
+func WithRelistOnReset() Option {
+	return func(o *options) {
+		o.relist = true
+	}
+}
+
+// WithRecvDelay waits d before receiving each request, simulating a slow intake
+// service. Once gRPC's flow control window fills up, the client's sends block.
+func WithRecvDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.recvDelay = d
+	}
+}
+
+// WithDeltaHandler calls fn with every delta received instead of recording it, for load
+// tests that would run out of memory keeping all deltas. Deltas and WaitForDeltas don't
+// see the deltas passed to fn. fn is called from the goroutine of the stream that
+// received the delta and must not retain it.
+func WithDeltaHandler(fn func(*intakev1.Delta)) Option {
+	return func(o *options) {
+		o.onDelta = fn
+	}
+}
+
+// Server is an intake service listening on a random localhost port unless configured
+// with WithAddress.
+type Server struct {
+	intakev1.UnimplementedIntakeServiceServer
+
+	opts   options
+	lis    net.Listener
+	server *grpc.Server
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	deltas   []*intakev1.Delta
+	streams  int
+	rejected int
+	resets   int
+	accepted []*StreamInfo
+	// events orders the opening and closing of accepted streams
+	events int
+}
+
+// StreamInfo describes a stream accepted by the server.
+type StreamInfo struct {
+	// ID and Continues are the stream's ID and the ID of the stream it replaces, as sent
+	// by the agent
+	ID        string
+	Continues string
+	// Labels are the labels sent by the agent, decoded
+	Labels map[string]string
+	// Opened and Closed order the stream's opening and closing among those of all
+	// streams. Closed is 0 while the stream is open.
+	Opened, Closed int
+	// Requests is the number of requests received on the stream
+	Requests int
+	// Graceful is set if the agent closed the stream, rather than it failing or being
+	// reset
+	Graceful bool
+}
+
+// New starts a Server. It is stopped by Stop.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{opts: options{addr: "127.0.0.1:0"}}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	lis, err := net.Listen("tcp", s.opts.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	s.lis = lis
+	s.server = grpc.NewServer()
+	intakev1.RegisterIntakeServiceServer(s.server, s)
+	go func() {
+		_ = s.server.Serve(lis)
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.lis.Addr().String()
+}
+
+// Dial returns an insecure connection to the server.
+func (s *Server) Dial() (*grpc.ClientConn, error) {
+	return grpc.NewClient(s.Addr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+// Stop stops the server, closing all open streams.
+func (s *Server) Stop() {
+	s.server.Stop()
+
+	// Wake up anyone waiting for deltas that will never arrive
+	s.mu.Lock()
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Deltas returns a copy of the deltas received so far in the order they were received.
+func (s *Server) Deltas() []*intakev1.Delta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deltas := make([]*intakev1.Delta, len(s.deltas))
+	for i, d := range s.deltas {
+		deltas[i] = proto.Clone(d).(*intakev1.Delta)
+	}
+	return deltas
+}
+
+// WaitForDeltas blocks until match returns true for the received deltas or ctx is done.
+func (s *Server) WaitForDeltas(ctx context.Context, match func([]*intakev1.Delta) bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !match(s.deltas) {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%w: received %d deltas", err, len(s.deltas))
+		}
+		s.cond.Wait()
+	}
+	return nil
+}
+
+// Stats returns the number of streams opened, rejected with codes.Unauthenticated and
+// reset by the server.
+func (s *Server) Stats() (streams, rejected, resets int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams, s.rejected, s.resets
+}
+
+// Streams returns a copy of the streams accepted so far in the order they were opened.
+func (s *Server) Streams() []StreamInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	streams := make([]StreamInfo, len(s.accepted))
+	for i, info := range s.accepted {
+		streams[i] = *info
+	}
+	return streams
+}
+
+// Delta implements intakev1.IntakeServiceServer.
+func (s *Server) Delta(stream intakev1.IntakeService_DeltaServer) (err error) {
+	if err := s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	s.mu.Lock()
+	s.events++
+	info := &StreamInfo{Opened: s.events}
+	if ids := md.Get(headerStreamID); len(ids) > 0 {
+		info.ID = ids[0]
+	}
+	if ids := md.Get(headerStreamContinues); len(ids) > 0 {
+		info.Continues = ids[0]
+	}
+	if labels := md.Get(headerLabels); len(labels) > 0 {
+		values, err := url.ParseQuery(labels[0])
+		if err != nil {
+			s.mu.Unlock()
+			return status.Errorf(codes.InvalidArgument, "invalid labels: %v", err)
+		}
+		info.Labels = make(map[string]string, len(values))
+		for key := range values {
+			info.Labels[key] = values.Get(key)
+		}
+	}
+	s.accepted = append(s.accepted, info)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.events++
+		info.Closed = s.events
+		info.Graceful = err == nil
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}()
+
+	received := 0
+	for {
+		if s.opts.recvDelay > 0 {
+			select {
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			case <-time.After(s.opts.recvDelay):
+			}
+		}
+
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&intakev1.DeltaResponse{})
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.opts.onDelta != nil {
+			for _, d := range req.GetDeltas() {
+				s.opts.onDelta(d)
+			}
+		}
+
+		s.mu.Lock()
+		if s.opts.onDelta == nil {
+			for _, d := range req.GetDeltas() {
+				s.deltas = append(s.deltas, proto.Clone(d).(*intakev1.Delta))
+			}
+		}
+		s.cond.Broadcast()
+		received++
+		info.Requests++
+		reset := s.opts.resetEvery > 0 && received >= s.opts.resetEvery
+		if reset {
+			s.resets++
+		}
+		s.mu.Unlock()
+
+		if reset {
+			if s.opts.relist {
+				if tokens := md.Get(headerResumeToken); len(tokens) > 0 {
+					stream.SetTrailer(metadata.Pairs(trailerRelistFrom, tokens[0]))
+				}
+			}
+			return status.Error(codes.Unavailable, "stream reset by test server")
+		}
+	}
+}
+
+func (s *Server) authenticate(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.streams++
+	if s.streams <= s.opts.authFailures {
+		s.rejected++
+		return status.Error(codes.Unauthenticated, "injected authentication failure")
+	}
+	if s.opts.apiKey == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(headerAuthorize) {
+		if v == "bearer "+s.opts.apiKey {
+			return nil
+		}
+	}
+	s.rejected++
+	return status.Error(codes.Unauthenticated, "invalid API key")
+}
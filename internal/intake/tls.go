@@ -0,0 +1,206 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// TLSOptions configures the TLS connection to the intake service.
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded client certificate and key used for mTLS.
+	// Both must be set to enable client certificate authentication.
+	CertFile string
+	KeyFile  string
+	// CAFile is a PEM encoded CA bundle used to verify the intake server, e.g. the CA of a
+	// TLS-intercepting proxy. If empty, the system roots are used.
+	CAFile string
+	// MinVersion is the minimum TLS version, "1.2" or "1.3". Defaults to 1.2.
+	MinVersion string
+	// ServerName overrides the name the intake server's certificate is verified against,
+	// which defaults to the host of the intake address
+	ServerName string
+	// PinnedSANs, if set, are the subject alternative names the intake server's
+	// certificate must present at least one of, in addition to being verified. spiffe://
+	// and other URIs are matched against URI SANs, anything else against DNS SANs.
+	PinnedSANs []string
+}
+
+// tlsVersions are the TLS versions TLSOptions.MinVersion accepts
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig returns a tls.Config for connecting to the intake service.
+// When a client certificate is configured, it is reloaded from disk whenever the
+// certificate or key files change so that rotated certificates (e.g. by cert-manager)
+// are picked up on the next handshake without restarting the agent.
+func NewTLSConfig(opts TLSOptions, logger logr.Logger) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.ServerName,
+	}
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q, must be 1.2 or 1.3", opts.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, fmt.Errorf("both client certificate and key files must be set for mTLS")
+	}
+
+	if opts.CertFile != "" {
+		reloader, err := newCertReloader(opts.CertFile, opts.KeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(opts.PinnedSANs) > 0 {
+		pinned := slices.Clone(opts.PinnedSANs)
+		// Runs after the certificate chain and server name were verified
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("intake server presented no certificate")
+			}
+			return verifyPinnedSANs(cs.PeerCertificates[0], pinned)
+		}
+	}
+
+	return cfg, nil
+}
+
+// verifyPinnedSANs returns an error unless cert has one of the pinned subject alternative
+// names
+func verifyPinnedSANs(cert *x509.Certificate, pinned []string) error {
+	for _, san := range pinned {
+		if strings.Contains(san, "://") {
+			for _, uri := range cert.URIs {
+				if uri.String() == san {
+					return nil
+				}
+			}
+			continue
+		}
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, san) {
+				return nil
+			}
+		}
+	}
+	var presented []string
+	for _, uri := range cert.URIs {
+		presented = append(presented, uri.String())
+	}
+	presented = append(presented, cert.DNSNames...)
+	return fmt.Errorf("intake server certificate presents none of the pinned SANs %v, got %v", pinned, presented)
+}
+
+// certReloader serves a client certificate loaded from disk, reloading it when the
+// modification time of the certificate or key file changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   logr.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger logr.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+// If the files changed but the new pair can't be loaded (e.g. the certificate was
+// written before the key), the previous certificate is served and loading is retried
+// on the next handshake.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed, err := r.filesChanged()
+	if err != nil {
+		r.logger.Error(err, "failed to stat client certificate, using previously loaded certificate")
+		return r.cert, nil
+	}
+	if changed {
+		if err := r.reload(); err != nil {
+			r.logger.Error(err, "failed to reload client certificate, using previously loaded certificate")
+		} else {
+			r.logger.Info("reloaded intake client certificate", "certFile", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) filesChanged() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod), nil
+}
+
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat client certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat client key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package intake
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	cfg, err := NewTLSConfig(TLSOptions{}, logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create TLS config: %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.VerifyConnection != nil {
+		t.Errorf("expected TLS 1.2 without pinning by default, got version %x", cfg.MinVersion)
+	}
+
+	cfg, err = NewTLSConfig(TLSOptions{
+		MinVersion: "1.3",
+		ServerName: "intake.internal",
+		PinnedSANs: []string{"spiffe://antimetal.com/intake"},
+	}, logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create TLS config: %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || cfg.ServerName != "intake.internal" {
+		t.Errorf("expected TLS 1.3 and server name intake.internal, got %x and %q", cfg.MinVersion, cfg.ServerName)
+	}
+	if err := cfg.VerifyConnection(tls.ConnectionState{}); err == nil {
+		t.Error("expected a connection without certificates to fail")
+	}
+
+	if _, err := NewTLSConfig(TLSOptions{MinVersion: "1.1"}, logr.Discard()); err == nil {
+		t.Error("expected TLS 1.1 to be rejected")
+	}
+}
+
+func TestVerifyPinnedSANs(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://antimetal.com/intake")
+	cert := &x509.Certificate{
+		DNSNames: []string{"intake.antimetal.com"},
+		URIs:     []*url.URL{spiffeID},
+	}
+	tests := []struct {
+		pinned  []string
+		wantErr bool
+	}{
+		{pinned: []string{"spiffe://antimetal.com/intake"}},
+		{pinned: []string{"Intake.Antimetal.com"}},
+		{pinned: []string{"proxy.corp.example", "intake.antimetal.com"}},
+		{pinned: []string{"spiffe://antimetal.com/other"}, wantErr: true},
+		// A DNS pin doesn't match the host of a URI SAN
+		{pinned: []string{"antimetal.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := verifyPinnedSANs(cert, tt.pinned)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pinned %v: expected error %t, got %v", tt.pinned, tt.wantErr, err)
+		}
+	}
+}
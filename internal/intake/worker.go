@@ -11,6 +11,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,10 +19,16 @@ import (
 	"github.com/cenkalti/backoff/v5"
 	"k8s.io/client-go/util/workqueue"
 
+	"github.com/antimetal/agent/internal/version"
+	"github.com/antimetal/agent/pkg/failpoint"
+	"github.com/antimetal/agent/pkg/redact"
 	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/tracing"
 	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
 	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -36,11 +43,58 @@ const (
 	heartbeatInterval   = 1 * time.Minute
 	defaultMaxBatchSize = 100         // Default maximum number of deltas in a batch
 	defaultFlushPeriod  = time.Second // Default flush period
+	queueMetricsPeriod  = 10 * time.Second
 )
 
+var tracer = tracing.Tracer("github.com/antimetal/agent/internal/intake")
+
+// Build information of the agent sent when a stream is opened
+const (
+	headerAgentVersion   = "x-agent-version"
+	headerAgentCommit    = "x-agent-commit"
+	headerAgentBuildDate = "x-agent-build-date"
+	headerAgentGoVersion = "x-agent-go-version"
+)
+
+// headerStreamPriority tells the intake which priority the deltas of a stream have
+const headerStreamPriority = "x-intake-priority"
+
+// Stream resumption handshake. A stream opened after deltas were sent on its lane tells
+// the intake the resume token of the newest of them in headerResumeToken. When the intake
+// ends a stream whose deltas it couldn't process, e.g. when it is drained, it can ask for
+// the changes since a token it received that way in trailerRelistFrom. The worker then
+// resumes its store subscription from the token, so that the intake receives the current
+// state of the resources changed since and the deletes since, rather than a full list or
+// nothing.
+const (
+	headerResumeToken = "x-intake-resume-token"
+	trailerRelistFrom = "x-intake-relist-from"
+)
+
+// Stream rotation. Every stream is identified by headerStreamID. A stream opened to
+// replace one older than the max stream age names the stream it continues in
+// headerStreamContinues, and is opened and sent a heartbeat before the old stream is
+// closed, so that the intake never sees a gap in the lane and knows that the old stream
+// ending is a rotation rather than the agent going away.
+const (
+	headerStreamID        = "x-intake-stream-id"
+	headerStreamContinues = "x-intake-stream-continues"
+)
+
+// headerLabels carries the labels set with WithLabels, URL query encoded, e.g.
+// customer=acme&env=prod, so that multi-tenant intakes can route and partition the
+// deltas of a stream by them
+const headerLabels = "x-intake-labels"
+
 type deltasBatch struct {
 	deltas []*intakev1.Delta
 	id     uint64
+	// seq is the sequence number of the batch in the persistent queue, 0 if it isn't
+	// persisted
+	seq uint64
+	// token is the resume token of the newest event in the batch, empty for batches
+	// replayed from the persistent queue and heartbeats
+	token string
 }
 
 var deltaVersion string
@@ -69,18 +123,31 @@ type worker struct {
 	client intakev1.IntakeServiceClient
 	store  resource.Store
 	logger logr.Logger
-	queue  workqueue.TypedRateLimitingInterface[*deltasBatch]
-	batch  *deltasBatch
-	mu     sync.Mutex
+
+	// Deltas are split by priority across two streams so that urgent state changes
+	// (deletes, nodes going down, OOM kills) are never queued behind a burst of
+	// full-spec updates, e.g. during a resync.
+	urgent *lane
+	bulk   *lane
 
 	// configurable options
 	maxBatchSize int
 	flushPeriod  time.Duration
-
-	// runtime fields
-	stream       intakev1.IntakeService_DeltaClient
-	streamCancel context.CancelFunc
+	redaction    *redact.Policy
+	tags         func() map[string]string
+	labels       map[string]string
+	extraHooks   []Hook
 	maxStreamAge time.Duration
+	disk         *Queue
+	manifest     func() Manifest
+
+	// hooks process every outgoing object, in order
+	hooks []Hook
+
+	// relist signals the resume tokens the intake asked to re-list from
+	relist       chan struct{}
+	relistMu     sync.Mutex
+	relistTokens []string
 }
 
 type WorkerOpts func(*worker)
@@ -103,6 +170,9 @@ func WithAPIKey(apiKey string) WorkerOpts {
 	}
 }
 
+// WithMaxStreamAge rotates the streams to the intake once they are older than
+// maxStreamAge, on their next send. A new stream is opened before the old one is closed,
+// so no deltas are lost to the rotation.
 func WithMaxStreamAge(maxStreamAge time.Duration) WorkerOpts {
 	return func(w *worker) {
 		w.maxStreamAge = maxStreamAge
@@ -121,27 +191,60 @@ func WithFlushPeriod(period time.Duration) WorkerOpts {
 	}
 }
 
+// WithRedactionPolicy redacts the Kubernetes specs of resources with policy before they
+// are sent. Resources that can't be redacted are not sent.
+func WithRedactionPolicy(policy *redact.Policy) WorkerOpts {
+	return func(w *worker) {
+		w.redaction = policy
+	}
+}
+
+// WithQueue persists batches in queue until they were sent, so that the deltas pending
+// upload survive restarts. Batches left in queue are sent first when the worker starts.
+func WithQueue(queue *Queue) WorkerOpts {
+	return func(w *worker) {
+		w.disk = queue
+	}
+}
+
+// WithTags adds the tags returned by tags to the metadata of every resource before it is
+// sent, e.g. tags from node-local metadata
+func WithTags(tags func() map[string]string) WorkerOpts {
+	return func(w *worker) {
+		w.tags = tags
+	}
+}
+
+// WithLabels sends labels in the metadata of every stream opened to the intake. They
+// aren't added to the resources sent; to tag them too, add the labels to the tags of
+// WithTags.
+func WithLabels(labels map[string]string) WorkerOpts {
+	return func(w *worker) {
+		w.labels = labels
+	}
+}
+
+// WithHooks runs hooks on every outgoing object, after the redaction policy and the tags
+// and in the order they are given. It can be used several times.
+func WithHooks(hooks ...Hook) WorkerOpts {
+	return func(w *worker) {
+		w.extraHooks = append(w.extraHooks, hooks...)
+	}
+}
+
 func NewWorker(store resource.Store, opts ...WorkerOpts) (*worker, error) {
 	if store == nil {
 		return nil, fmt.Errorf("store can't be nil")
 	}
 
-	ratelimiter := workqueue.DefaultTypedControllerRateLimiter[*deltasBatch]()
-	queue := workqueue.NewTypedRateLimitingQueueWithConfig(ratelimiter,
-		workqueue.TypedRateLimitingQueueConfig[*deltasBatch]{
-			Name: workerName,
-		},
-	)
-
-	batch := newDeltasBatch([]*intakev1.Delta{})
-
 	w := &worker{
 		store:        store,
-		queue:        queue,
+		urgent:       newLane(priorityUrgent),
+		bulk:         newLane(priorityBulk),
 		maxStreamAge: 10 * time.Minute,
-		batch:        batch,
 		maxBatchSize: defaultMaxBatchSize,
 		flushPeriod:  defaultFlushPeriod,
+		relist:       make(chan struct{}, 1),
 	}
 	for _, opt := range opts {
 		opt(w)
@@ -150,28 +253,29 @@ func NewWorker(store resource.Store, opts ...WorkerOpts) (*worker, error) {
 	if w.client == nil {
 		return nil, fmt.Errorf("can't create client")
 	}
+	w.hooks = w.buildHooks()
+	for _, l := range []*lane{w.urgent, w.bulk} {
+		l.disk = w.disk
+		l.logger = w.logger
+	}
 	return w, nil
 }
 
-func (w *worker) flushBatch() {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	if len(w.batch.deltas) == 0 {
-		return
+func (w *worker) Start(ctx context.Context) error {
+	if w.disk != nil {
+		if err := w.replayQueue(); err != nil {
+			return fmt.Errorf("failed to replay intake queue: %w", err)
+		}
 	}
 
-	w.queue.AddRateLimited(w.batch)
-	w.batch = newDeltasBatch([]*intakev1.Delta{})
-}
-
-func (w *worker) Start(ctx context.Context) error {
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		w.streamer(ctx)
-	}()
+	for _, l := range []*lane{w.urgent, w.bulk} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.streamer(ctx, l)
+		}()
+	}
 
 	wg.Add(1)
 	go func() {
@@ -185,34 +289,98 @@ func (w *worker) Start(ctx context.Context) error {
 		w.batchFlusher(ctx)
 	}()
 
-	for event := range w.store.Subscribe(nil) {
-		for _, obj := range event.Objs {
-			obj.Ttl = durationpb.New(defaultDeltaTTL)
-			obj.DeltaVersion = deltaVersion
-		}
-
-		delta := &intakev1.Delta{
-			Op:      eventTypeToOp(event.Type),
-			Objects: event.Objs,
-		}
-
-		w.mu.Lock()
-		w.batch.deltas = append(w.batch.deltas, delta)
-		shouldFlush := len(w.batch.deltas) >= w.maxBatchSize
-		w.mu.Unlock()
+	if w.disk != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.queueMetricsUpdater(ctx)
+		}()
+	}
 
-		if shouldFlush {
-			w.flushBatch()
+	events := w.store.Subscribe(nil)
+	for events != nil {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			w.handleEvent(event)
+		case <-w.relist:
+			tokens := w.takeRelistTokens()
+			w.logger.Info("re-listing the changes requested by the intake", "tokens", tokens)
+			// Events not received yet are part of the changes since the tokens
+			w.store.Unsubscribe(events)
+			opts := make([]resource.SubscribeOption, 0, len(tokens))
+			for _, token := range tokens {
+				opts = append(opts, resource.WithResumeToken(token))
+			}
+			events = w.store.Subscribe(nil, opts...)
 		}
 	}
 
 	w.logger.Info("shutting down intake worker")
-	w.flushBatch()
-	w.queue.ShutDownWithDrain()
+	for _, l := range []*lane{w.urgent, w.bulk} {
+		l.flush()
+		l.queue.ShutDownWithDrain()
+	}
 	wg.Wait()
 	return nil
 }
 
+// handleEvent adds the delta of a store event to the pending batch of its lane
+func (w *worker) handleEvent(event resource.Event) {
+	op := eventTypeToOp(event.Type)
+	objs := make([]*resourcev1.Object, 0, len(event.Objs))
+	for _, obj := range event.Objs {
+		if !w.runHooks(op, obj) {
+			continue
+		}
+		obj.Ttl = durationpb.New(defaultDeltaTTL)
+		obj.DeltaVersion = deltaVersion
+		objs = append(objs, obj)
+	}
+	if len(objs) == 0 {
+		return
+	}
+
+	delta := &intakev1.Delta{
+		Op:      op,
+		Objects: objs,
+	}
+
+	// Urgent deltas are sent right away instead of waiting for the next flush
+	if deltaPriority(event.Class, delta) == priorityUrgent {
+		w.urgent.add(delta, event.ResumeToken)
+		w.urgent.flush()
+		return
+	}
+	if w.bulk.add(delta, event.ResumeToken) >= w.maxBatchSize {
+		w.bulk.flush()
+	}
+}
+
+// requestRelist asks the event loop to resume the store subscription from token
+func (w *worker) requestRelist(token string) {
+	w.relistMu.Lock()
+	w.relistTokens = append(w.relistTokens, token)
+	w.relistMu.Unlock()
+	select {
+	case w.relist <- struct{}{}:
+	default:
+		// A re-list is pending already and will include token
+	}
+}
+
+// takeRelistTokens returns and clears the tokens to re-list from
+func (w *worker) takeRelistTokens() []string {
+	w.relistMu.Lock()
+	defer w.relistMu.Unlock()
+	tokens := w.relistTokens
+	w.relistTokens = nil
+	return tokens
+}
+
 func (w *worker) batchFlusher(ctx context.Context) {
 	ticker := time.NewTicker(w.flushPeriod)
 	defer ticker.Stop()
@@ -222,29 +390,69 @@ func (w *worker) batchFlusher(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			w.flushBatch()
+			w.bulk.flush()
+		}
+	}
+}
+
+// replayQueue queues the batches left in the persistent queue by a previous run for
+// sending ahead of new deltas. Their objects are moved to the current delta version, so
+// that they are kept alive by the heartbeats of this run.
+func (w *worker) replayQueue() error {
+	batches, err := w.disk.List()
+	if err != nil {
+		return err
+	}
+	if len(batches) == 0 {
+		return nil
+	}
+
+	w.logger.Info("replaying queued batches", "batches", len(batches))
+	for _, b := range batches {
+		for _, delta := range b.Deltas {
+			for _, obj := range delta.GetObjects() {
+				obj.DeltaVersion = deltaVersion
+			}
+		}
+		batch := newDeltasBatch(b.Deltas)
+		batch.seq = b.Seq
+		l := w.bulk
+		if b.Priority == priorityUrgent.String() {
+			l = w.urgent
 		}
+		l.queue.Add(batch)
 	}
+	return nil
 }
 
-func (w *worker) streamer(ctx context.Context) {
+func (w *worker) queueMetricsUpdater(ctx context.Context) {
+	ticker := time.NewTicker(queueMetricsPeriod)
+	defer ticker.Stop()
+
 	for {
+		updateQueueMetrics(w.disk.Stats(), time.Now())
 		select {
 		case <-ctx.Done():
-			if w.stream != nil {
-				if _, err := w.stream.CloseAndRecv(); err != nil {
-					w.logger.Error(err, "error closing intake stream")
-				}
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
-				if w.streamCancel != nil {
-					w.streamCancel()
-					w.streamCancel = nil
+func (w *worker) streamer(ctx context.Context, l *lane) {
+	for {
+		select {
+		case <-ctx.Done():
+			if l.stream != nil {
+				if err := w.closeStream(l.priority, l.stream, l.streamCancel); err != nil {
+					w.logger.Error(err, "error closing intake stream", "priority", l.priority)
 				}
-				w.stream = nil
+				l.stream = nil
+				l.streamCancel = nil
 			}
 			return
 		default:
-			w.sendDelta(ctx)
+			w.sendDelta(ctx, l)
 		}
 	}
 }
@@ -258,43 +466,52 @@ func (w *worker) heartbeatWorker(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			w.queue.AddRateLimited(newDeltasBatch([]*intakev1.Delta{{
-				Op: intakev1.DeltaOperation_DELTA_OPERATION_HEARTBEAT,
-				Objects: []*resourcev1.Object{
-					{
-						DeltaVersion: deltaVersion,
-						Ttl:          durationpb.New(defaultDeltaTTL),
-					},
-				},
-			}}))
+			// Heartbeats keep the objects sent so far alive, so they must not be held up
+			// by a backlog of bulk deltas
+			w.urgent.queue.Add(newDeltasBatch([]*intakev1.Delta{heartbeatDelta()}))
 		}
 	}
 }
 
-func (w *worker) sendDelta(ctx context.Context) {
-	batch, shutdown := w.queue.Get()
+func heartbeatDelta() *intakev1.Delta {
+	return &intakev1.Delta{
+		Op: intakev1.DeltaOperation_DELTA_OPERATION_HEARTBEAT,
+		Objects: []*resourcev1.Object{
+			{
+				DeltaVersion: deltaVersion,
+				Ttl:          durationpb.New(defaultDeltaTTL),
+			},
+		},
+	}
+}
+
+func (w *worker) sendDelta(ctx context.Context, l *lane) {
+	batch, shutdown := l.queue.Get()
 	if shutdown {
 		return
 	}
-	defer w.queue.Done(batch)
-
-	if w.stream == nil {
+	defer l.queue.Done(batch)
+
+	// The span includes the time spent opening a stream, so that a stalled intake shows
+	_, span := tracer.Start(ctx, "intake.Send", trace.WithAttributes(
+		attribute.String("priority", l.priority.String()),
+		attribute.Int64("batch.id", int64(batch.id)),
+		attribute.Int("deltas", len(batch.deltas)),
+	))
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	if l.stream != nil && time.Since(l.streamOpened) >= w.maxStreamAge {
+		w.rotateStream(l)
+	}
+	if l.stream == nil {
 		// Continously try to create a new stream
 		for {
-			_, err := backoff.Retry(ctx, func() (bool, error) {
-				innerCtx, cancel := context.WithTimeout(context.Background(), w.maxStreamAge)
-				streamCtx := metadata.NewOutgoingContext(
-					innerCtx, metadata.Pairs(headerAuthorize, fmt.Sprintf("bearer %s", w.apiKey)),
-				)
-				stream, err := w.client.Delta(streamCtx)
-				if err != nil {
-					cancel()
-					w.logger.Error(err, "failed to create intake stream, retrying...")
+			_, err = backoff.Retry(ctx, func() (bool, error) {
+				if err := w.openStream(l, ""); err != nil {
+					w.logger.Error(err, "failed to create intake stream, retrying...", "priority", l.priority)
 					return false, err
 				}
-
-				w.stream = stream
-				w.streamCancel = cancel
 				return true, nil
 			}, backoff.WithBackOff(backoff.NewExponentialBackOff()))
 
@@ -304,37 +521,158 @@ func (w *worker) sendDelta(ctx context.Context) {
 
 			// Return if the context is canceled since that means we're shutting down.
 			if ctx.Err() == context.Canceled {
+				err = ctx.Err()
 				return
 			}
 		}
 	}
 
-	w.logger.V(1).Info("sending deltas", "numDeltas", len(batch.deltas), "version", deltaVersion, "batchID", batch.id)
-	err := w.stream.Send(&intakev1.DeltaRequest{Deltas: batch.deltas})
+	w.logger.V(1).Info("sending deltas", "numDeltas", len(batch.deltas), "version", deltaVersion,
+		"batchID", batch.id, "priority", l.priority)
+	span.SetAttributes(attribute.String("stream.id", l.streamID))
+	err = failpoint.Inject(failpoint.IntakeSend)
+	if err == nil {
+		err = l.stream.Send(w.request(l, batch.deltas))
+	}
 	if err != nil {
-		_, err = w.stream.CloseAndRecv()
-		if err != nil {
+		if err := w.closeStream(l.priority, l.stream, l.streamCancel); err != nil {
 			code := status.Code(err)
 			if code == codes.Unavailable || code == codes.Canceled || code == codes.DeadlineExceeded {
-				w.logger.V(1).Info("resetting intake stream")
+				w.logger.V(1).Info("resetting intake stream", "priority", l.priority)
 			} else {
-				w.logger.Error(err, "failed to send to intake stream, resetting stream...")
+				w.logger.Error(err, "failed to send to intake stream, resetting stream...", "priority", l.priority)
 			}
 		}
+		l.stream = nil
+		l.streamCancel = nil
 
-		// Cancel the stream context when stream is terminated
-		if w.streamCancel != nil {
-			w.streamCancel()
-			w.streamCancel = nil
+		if !l.queue.ShuttingDown() {
+			l.queue.AddRateLimited(batch)
 		}
-		w.stream = nil
-
-		if !w.queue.ShuttingDown() {
-			w.queue.AddRateLimited(batch)
+		return
+	}
+	l.queue.Forget(batch)
+	l.manifestPending = false
+	if batch.token != "" {
+		l.token = batch.token
+	}
+	if l.disk != nil && batch.seq != 0 {
+		if err := l.disk.remove(l.priority, batch.seq); err != nil {
+			w.logger.Error(err, "failed to remove sent batch from queue, it will be sent again on restart",
+				"batchID", batch.id, "priority", l.priority)
 		}
+	}
+}
+
+// openStream opens a stream for l. If continues is set, the stream replaces the stream
+// with that ID.
+func (w *worker) openStream(l *lane, continues string) error {
+	id := newStreamID()
+	streamCtx, cancel := context.WithCancel(context.Background())
+	md := streamMetadata(l.priority, w.apiKey, l.token, w.labels)
+	md.Set(headerStreamID, id)
+	if continues != "" {
+		md.Set(headerStreamContinues, continues)
+	}
+	stream, err := w.client.Delta(metadata.NewOutgoingContext(streamCtx, md))
+	if err != nil {
+		cancel()
+		return err
+	}
+	l.stream = stream
+	l.streamCancel = cancel
+	l.streamID = id
+	l.streamOpened = time.Now()
+	l.manifestPending = true
+	return nil
+}
+
+// rotateStream replaces the stream of l, make-before-break: the new stream is opened and
+// sent a heartbeat first, then the old stream is closed, waiting for the intake to
+// receive everything sent on it. If the new stream can't be opened the old one is kept
+// and rotated on a later send.
+func (w *worker) rotateStream(l *lane) {
+	old, oldCancel, oldID, oldOpened := l.stream, l.streamCancel, l.streamID, l.streamOpened
+	oldManifestPending := l.manifestPending
+	if err := w.openStream(l, oldID); err != nil {
+		w.logger.Error(err, "failed to open intake stream to rotate to, keeping the current one",
+			"priority", l.priority)
+		return
+	}
+	// The heartbeat marks the continuation and makes sure the new stream is established
+	if err := l.stream.Send(w.request(l, []*intakev1.Delta{heartbeatDelta()})); err != nil {
+		w.logger.V(1).Info("failed to send on intake stream rotated to, keeping the current one",
+			"priority", l.priority, "error", err.Error())
+		_ = w.closeStream(l.priority, l.stream, l.streamCancel)
+		l.stream, l.streamCancel, l.streamID, l.streamOpened = old, oldCancel, oldID, oldOpened
+		l.manifestPending = oldManifestPending
 		return
 	}
-	w.queue.Forget(batch)
+	l.manifestPending = false
+
+	w.logger.V(1).Info("rotating intake stream", "priority", l.priority, "from", oldID, "to", l.streamID)
+	if err := w.closeStream(l.priority, old, oldCancel); err != nil {
+		// Deltas the intake didn't process are re-listed if it asked for it
+		w.logger.V(1).Info("intake stream rotated from ended with an error", "priority", l.priority,
+			"error", err.Error())
+	}
+}
+
+// closeStream closes stream, waiting for the intake's response, and cancels its context.
+// It requests the re-lists the intake asked for in the trailer.
+func (w *worker) closeStream(p priority, stream intakev1.IntakeService_DeltaClient, cancel context.CancelFunc) error {
+	_, err := stream.CloseAndRecv()
+	for _, token := range stream.Trailer().Get(trailerRelistFrom) {
+		w.logger.V(1).Info("intake requested a re-list", "priority", p, "token", token)
+		w.requestRelist(token)
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return err
+}
+
+func newStreamID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// This should never happen because rand.Read should never return an error
+		panic(fmt.Sprintf("failed to generate stream ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// streamMetadata returns the metadata of a stream of lane p with labels, resuming after
+// the delta with resumeToken if set
+func streamMetadata(p priority, apiKey, resumeToken string, labels map[string]string) metadata.MD {
+	md := buildInfoMetadata()
+	md.Set(headerStreamPriority, p.String())
+	if resumeToken != "" {
+		md.Set(headerResumeToken, resumeToken)
+	}
+	if len(labels) > 0 {
+		values := make(url.Values, len(labels))
+		for key, value := range labels {
+			values.Set(key, value)
+		}
+		// Encode sorts by key
+		md.Set(headerLabels, values.Encode())
+	}
+	// The API key is optional when authenticating with a client certificate
+	if apiKey != "" {
+		md.Set(headerAuthorize, fmt.Sprintf("bearer %s", apiKey))
+	}
+	return md
+}
+
+// buildInfoMetadata returns the stream metadata identifying the agent build
+func buildInfoMetadata() metadata.MD {
+	info := version.Get()
+	return metadata.Pairs(
+		headerAgentVersion, info.Version,
+		headerAgentCommit, info.Commit,
+		headerAgentBuildDate, info.BuildDate,
+		headerAgentGoVersion, info.GoVersion,
+	)
 }
 
 func eventTypeToOp(e resource.EventType) intakev1.DeltaOperation {
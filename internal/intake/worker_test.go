@@ -0,0 +1,401 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build integration
+
+package intake_test
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/internal/intake"
+	"github.com/antimetal/agent/internal/intake/testserver"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+const testTimeout = 20 * time.Second
+
+// startWorker runs an intake worker against srv until the test ends
+func startWorker(t *testing.T, srv *testserver.Server, opts ...intake.WorkerOpts) resource.Store {
+	t.Helper()
+
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatalf("failed to dial test server: %v", err)
+	}
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+
+	opts = append([]intake.WorkerOpts{
+		intake.WithLogger(logr.Discard()),
+		intake.WithGRPCConn(conn),
+		intake.WithFlushPeriod(10 * time.Millisecond),
+	}, opts...)
+	w, err := intake.NewWorker(inv, opts...)
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = w.Start(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		inv.Close()
+		srv.Stop()
+		select {
+		case <-done:
+		case <-time.After(testTimeout):
+			t.Error("worker did not stop")
+		}
+		conn.Close()
+	})
+	return inv
+}
+
+func addResource(t *testing.T, inv resource.Store, name string) {
+	t.Helper()
+	err := inv.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "test", Type: "test.Resource"},
+		Metadata: &resourcev1.ResourceMeta{Name: name},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource %s: %v", name, err)
+	}
+}
+
+// received returns a matcher for WaitForDeltas reporting whether the resources with
+// names were all received
+func received(names ...string) func([]*intakev1.Delta) bool {
+	return func(deltas []*intakev1.Delta) bool {
+		seen := make(map[string]bool)
+		for _, d := range deltas {
+			if d.GetOp() != intakev1.DeltaOperation_DELTA_OPERATION_CREATE {
+				continue
+			}
+			for _, obj := range d.GetObjects() {
+				rsrc := &resourcev1.Resource{}
+				if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err == nil {
+					seen[rsrc.GetMetadata().GetName()] = true
+				}
+			}
+		}
+		for _, name := range names {
+			if !seen[name] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func waitForResources(t *testing.T, srv *testserver.Server, names ...string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := srv.WaitForDeltas(ctx, received(names...)); err != nil {
+		t.Fatalf("resources %v not received: %v", names, err)
+	}
+}
+
+func TestWorker_SendsDeltas(t *testing.T) {
+	srv, err := testserver.New(testserver.WithAPIKey("secret"))
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv, intake.WithAPIKey("secret"))
+
+	addResource(t, inv, "a")
+	addResource(t, inv, "b")
+	waitForResources(t, srv, "a", "b")
+
+	for _, d := range srv.Deltas() {
+		for _, obj := range d.GetObjects() {
+			if obj.GetDeltaVersion() == "" || obj.GetTtl() == nil {
+				t.Errorf("delta object is missing its version or TTL: %v", obj)
+			}
+		}
+	}
+	if _, rejected, _ := srv.Stats(); rejected != 0 {
+		t.Errorf("expected no rejected streams, got %d", rejected)
+	}
+}
+
+func TestWorker_SendsLabels(t *testing.T) {
+	srv, err := testserver.New()
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	labels := map[string]string{"customer": "acme & co", "env": "prod"}
+	inv := startWorker(t, srv, intake.WithLabels(labels))
+
+	addResource(t, inv, "a")
+	waitForResources(t, srv, "a")
+
+	streams := srv.Streams()
+	if len(streams) == 0 {
+		t.Fatalf("expected streams to be opened")
+	}
+	for _, s := range streams {
+		if !maps.Equal(s.Labels, labels) {
+			t.Errorf("expected stream %s to have labels %v, got %v", s.ID, labels, s.Labels)
+		}
+	}
+}
+
+func TestWorker_RecoversFromAuthFailures(t *testing.T) {
+	srv, err := testserver.New(testserver.WithAuthFailures(2))
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv)
+
+	// A client stream only learns that it was rejected on its next send, so the worker
+	// needs further deltas to notice the failure and reconnect. Keep adding resources
+	// until one gets through.
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	for i := 0; ; i++ {
+		addResource(t, inv, fmt.Sprintf("r%d", i))
+		waitCtx, waitCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		err := srv.WaitForDeltas(waitCtx, func(d []*intakev1.Delta) bool { return len(d) > 0 })
+		waitCancel()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("no deltas received after authentication failures: %v", err)
+		}
+	}
+
+	streams, rejected, _ := srv.Stats()
+	if rejected != 2 {
+		t.Errorf("expected 2 rejected streams, got %d", rejected)
+	}
+	if streams < 3 {
+		t.Errorf("expected the worker to open at least 3 streams, got %d", streams)
+	}
+}
+
+func TestWorker_ReconnectsAfterStreamReset(t *testing.T) {
+	srv, err := testserver.New(testserver.WithStreamResets(1))
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv)
+
+	// Every stream is reset after its first request, so each resource after the first
+	// is sent on a stream that has to be re-established.
+	names := []string{"a", "b", "c"}
+	for _, name := range names {
+		addResource(t, inv, name)
+		waitForResources(t, srv, name)
+	}
+
+	streams, _, resets := srv.Stats()
+	if resets < len(names) {
+		t.Errorf("expected at least %d stream resets, got %d", len(names), resets)
+	}
+	if streams < len(names) {
+		t.Errorf("expected at least %d streams, got %d", len(names), streams)
+	}
+}
+
+func TestWorker_RelistsAfterStreamReset(t *testing.T) {
+	srv, err := testserver.New(testserver.WithStreamResets(1), testserver.WithRelistOnReset())
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv)
+
+	addResource(t, inv, "a")
+	waitForResources(t, srv, "a")
+	addResource(t, inv, "b")
+
+	// The second stream resumes after a. The server asks for the changes since when it
+	// resets the stream, and b is sent again, this time as an update from the store
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	err = srv.WaitForDeltas(ctx, func(deltas []*intakev1.Delta) bool {
+		for _, d := range deltas {
+			if d.GetOp() == intakev1.DeltaOperation_DELTA_OPERATION_UPDATE {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatalf("re-listed changes not received: %v", err)
+	}
+}
+
+func TestWorker_SlowIntake(t *testing.T) {
+	srv, err := testserver.New(testserver.WithRecvDelay(50 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv, intake.WithMaxBatchSize(5))
+
+	// Deltas are buffered in the worker's queue while the intake is slow
+	names := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("r%d", i)
+		names = append(names, name)
+		addResource(t, inv, name)
+	}
+	waitForResources(t, srv, names...)
+}
+
+func TestWorker_UrgentDeltasOvertakeBulk(t *testing.T) {
+	srv, err := testserver.New(testserver.WithRecvDelay(50 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv, intake.WithMaxBatchSize(1))
+
+	// Build a backlog of bulk deltas while the intake is slow
+	for i := 0; i < 50; i++ {
+		addResource(t, inv, fmt.Sprintf("r%d", i))
+	}
+	err = inv.DeleteResource(&resourcev1.ResourceRef{TypeUrl: "test.Resource", Name: "r0"})
+	if err != nil {
+		t.Fatalf("failed to delete resource: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	err = srv.WaitForDeltas(ctx, func(deltas []*intakev1.Delta) bool {
+		for _, d := range deltas {
+			if d.GetOp() == intakev1.DeltaOperation_DELTA_OPERATION_DELETE {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatalf("delete not received: %v", err)
+	}
+	if received("r49")(srv.Deltas()) {
+		t.Error("expected the delete to be received before the bulk backlog was drained")
+	}
+}
+
+func TestWorker_RotatesStreamsMakeBeforeBreak(t *testing.T) {
+	srv, err := testserver.New()
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	inv := startWorker(t, srv, intake.WithMaxStreamAge(100*time.Millisecond), intake.WithMaxBatchSize(1))
+
+	// Keep sending across several rotations
+	names := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("r%d", i)
+		names = append(names, name)
+		addResource(t, inv, name)
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitForResources(t, srv, names...)
+
+	streams := srv.Streams()
+	byID := make(map[string]testserver.StreamInfo, len(streams))
+	for _, s := range streams {
+		byID[s.ID] = s
+	}
+	rotations := 0
+	for _, s := range streams {
+		if s.Continues == "" {
+			continue
+		}
+		prev, ok := byID[s.Continues]
+		if !ok {
+			t.Errorf("stream %s continues unknown stream %s", s.ID, s.Continues)
+			continue
+		}
+		// The stream rotated from may still be closing
+		if prev.Closed == 0 {
+			continue
+		}
+		rotations++
+		if prev.Closed < s.Opened {
+			t.Errorf("stream %s was closed before the stream continuing it was opened", prev.ID)
+		}
+		if !prev.Graceful {
+			t.Errorf("expected stream %s to be closed by the agent", prev.ID)
+		}
+	}
+	if rotations == 0 {
+		t.Fatalf("expected the streams to be rotated, got %+v", streams)
+	}
+	if _, _, resets := srv.Stats(); resets != 0 {
+		t.Errorf("expected no stream resets, got %d", resets)
+	}
+}
+
+func TestWorker_SendsManifest(t *testing.T) {
+	srv, err := testserver.New(testserver.WithStreamResets(1))
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	manifest := intake.Manifest{
+		Name:        "node-1",
+		Features:    map[string]bool{"heartbeat": true, "cloud-inventory": false},
+		MetricTypes: []string{"memory", "cpu"},
+	}
+	inv := startWorker(t, srv, intake.WithManifest(func() intake.Manifest { return manifest }))
+
+	// Every stream is reset after its first request, so each resource is sent on a new
+	// stream, with the manifest
+	for _, name := range []string{"a", "b"} {
+		addResource(t, inv, name)
+		waitForResources(t, srv, name)
+	}
+
+	var manifests int
+	for _, d := range srv.Deltas() {
+		for _, obj := range d.GetObjects() {
+			if obj.GetType().GetType() != intake.ManifestResourceType {
+				continue
+			}
+			manifests++
+			rsrc := &resourcev1.Resource{}
+			if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+				t.Fatalf("failed to unmarshal manifest: %v", err)
+			}
+			spec := &structpb.Struct{}
+			if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+				t.Fatalf("failed to unmarshal manifest spec: %v", err)
+			}
+			got := spec.AsMap()
+			if rsrc.GetMetadata().GetName() != "node-1" || got["features"].(map[string]any)["cloud-inventory"] != false {
+				t.Errorf("unexpected manifest: %v", got)
+			}
+			if types := got["metricTypes"].([]any); len(types) != 2 || types[0] != "cpu" {
+				t.Errorf("expected sorted metric types, got %v", types)
+			}
+		}
+	}
+	if manifests < 2 {
+		t.Errorf("expected a manifest on every stream, got %d", manifests)
+	}
+}
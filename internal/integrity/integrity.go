@@ -0,0 +1,475 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package integrity watches the critical configuration files of the node, e.g. the kubelet
+// and containerd configs and the static pod manifests, and records every change to them
+// with the hashes of their contents. Configuration drifting from what provisioned the node,
+// or tampered with, is a common cause of nodes behaving unlike their peers.
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+const (
+	watcherName = "file-integrity-watcher"
+
+	// ResourceType is the resource type of watched files. There is no generated message
+	// for it; its spec is a google.protobuf.Struct.
+	ResourceType = "antimetal.agent.v1.FileIntegrity"
+
+	defaultResync = 10 * time.Minute
+	// settleDelay is how long the events of a file are coalesced before it is hashed, so
+	// that a file written in several steps is hashed once
+	settleDelay = 500 * time.Millisecond
+)
+
+// Changes of a watched file
+const (
+	// ChangeBaseline is a file first seen when the watcher starts
+	ChangeBaseline = "baseline"
+	// ChangeCreated is a file created while the watcher runs
+	ChangeCreated = "created"
+	// ChangeModified is a file whose contents, mode or owner changed
+	ChangeModified = "modified"
+	// ChangeDeleted is a file removed, or no longer a regular file
+	ChangeDeleted = "deleted"
+)
+
+// DefaultPaths are the control plane configs and static pod manifests, the kubelet config
+// and the containerd config of kubeadm-style nodes
+var DefaultPaths = []string{
+	"/etc/kubernetes",
+	"/var/lib/kubelet/config.yaml",
+	"/etc/containerd/config.toml",
+}
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+var changesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "antimetal_file_integrity_changes_total",
+	Help: "Number of changes to the watched files of the node, by change: created, modified or deleted.",
+}, []string{"change"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(changesTotal)
+}
+
+// Watcher watches Paths with inotify and upserts a FileIntegrity resource named
+// <node>/<path> for every regular file in them, with the SHA-256 hash of its contents.
+// Directories are watched recursively. Once the files are first hashed, every change is a
+// critical event so that the intake sends it ahead of everything else.
+//
+// The hashes in the store are the baseline of the watcher: with a persistent store, files
+// changed while the agent wasn't running are reported modified when it starts.
+//
+// The spec of the resource is a google.protobuf.Struct with the nodeName and path of the
+// file, the change, whether it exists, its sha256, size, mode, uid, gid and modified time,
+// the previousSha256 and previousMode of a modified or deleted file and the timestamp the
+// change was detected.
+type Watcher struct {
+	Store    resource.Store
+	NodeName string
+	// Paths are the files and directories to watch, as seen by the agent. Paths that
+	// don't exist are watched for being created.
+	Paths []string
+	// Resync is how often every file is hashed again, catching the changes inotify
+	// missed, e.g. when its queue overflowed. Defaults to 10 minutes.
+	Resync time.Duration
+}
+
+// SetupWithManager registers the Watcher to the provided manager
+func (w *Watcher) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	runnable, err := w.runnable(mgr.GetLogger().WithName(watcherName))
+	if err != nil {
+		return err
+	}
+	return mgr.Add(runnable)
+}
+
+func (w *Watcher) runnable(logger logr.Logger) (*watcher, error) {
+	if w.Store == nil {
+		return nil, fmt.Errorf("Watcher must be configured with a non-nil Store")
+	}
+	if w.NodeName == "" {
+		return nil, fmt.Errorf("Watcher must be configured with a NodeName")
+	}
+	if len(w.Paths) == 0 {
+		return nil, fmt.Errorf("Watcher must be configured with Paths")
+	}
+	roots := make([]string, 0, len(w.Paths))
+	for _, path := range w.Paths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("path must be absolute, got: %q", path)
+		}
+		roots = append(roots, filepath.Clean(path))
+	}
+	resync := w.Resync
+	if resync <= 0 {
+		resync = defaultResync
+	}
+	return &watcher{
+		store:    w.Store,
+		nodeName: w.NodeName,
+		roots:    roots,
+		resync:   resync,
+		logger:   logger,
+		now:      time.Now,
+		files:    make(map[string]fileState),
+	}, nil
+}
+
+type watcher struct {
+	store    resource.Store
+	nodeName string
+	roots    []string
+	resync   time.Duration
+	logger   logr.Logger
+	now      func() time.Time
+
+	// inotify is nil until the watcher starts
+	inotify *fsnotify.Watcher
+	// files holds the state of every existing file as last recorded in the store
+	files map[string]fileState
+}
+
+// fileState is what is compared of a file to detect its changes. The modified time isn't:
+// touching a file doesn't change it.
+type fileState struct {
+	sha256   string
+	size     int64
+	mode     uint32
+	uid      uint32
+	gid      uint32
+	modified time.Time
+}
+
+func (f fileState) differs(other fileState) bool {
+	return f.sha256 != other.sha256 || f.mode != other.mode || f.uid != other.uid || f.gid != other.gid
+}
+
+func (w *watcher) Start(ctx context.Context) error {
+	inotify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create inotify watcher: %w", err)
+	}
+	defer inotify.Close()
+	w.inotify = inotify
+
+	if err := w.load(); err != nil {
+		w.logger.Error(err, "failed to load the files of the store, every file is a new baseline")
+	}
+	w.sync(true)
+
+	ticker := time.NewTicker(w.resync)
+	defer ticker.Stop()
+	pending := make(map[string]bool)
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-inotify.Events:
+			if !ok {
+				return nil
+			}
+			if w.watched(event.Name) {
+				pending[event.Name] = true
+				if settle == nil {
+					settle = time.After(settleDelay)
+				}
+			}
+		case <-settle:
+			for path := range pending {
+				w.scan(path, false)
+			}
+			clear(pending)
+			settle = nil
+		case err, ok := <-inotify.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.Error(err, "inotify failed")
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.sync(false)
+			}
+		case <-ticker.C:
+			w.sync(false)
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable. The files
+// are local to every agent.
+func (w *watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// load restores the files of the node recorded in the store
+func (w *watcher) load() error {
+	rsrcs, err := w.store.ListResources(&resourcev1.TypeDescriptor{Kind: kindResource, Type: ResourceType})
+	if err != nil {
+		return err
+	}
+	for _, rsrc := range rsrcs {
+		spec := &structpb.Struct{}
+		if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+			return fmt.Errorf("failed to unmarshal spec of %s: %w", rsrc.GetMetadata().GetName(), err)
+		}
+		fields := spec.GetFields()
+		path := fields["path"].GetStringValue()
+		if fields["nodeName"].GetStringValue() != w.nodeName || !fields["exists"].GetBoolValue() ||
+			!w.watched(path) {
+			continue
+		}
+		mode, _ := strconv.ParseUint(fields["mode"].GetStringValue(), 8, 32)
+		w.files[path] = fileState{
+			sha256: fields["sha256"].GetStringValue(),
+			size:   int64(fields["size"].GetNumberValue()),
+			mode:   uint32(mode),
+			uid:    uint32(fields["uid"].GetNumberValue()),
+			gid:    uint32(fields["gid"].GetNumberValue()),
+		}
+	}
+	return nil
+}
+
+// sync hashes every file of the watched paths. Files first seen are a baseline if
+// baseline is set, or created otherwise.
+func (w *watcher) sync(baseline bool) {
+	for _, root := range w.roots {
+		// The parent is watched for the path itself being created, removed or replaced
+		w.watch(filepath.Dir(root))
+		w.scan(root, baseline)
+	}
+}
+
+// scan hashes the file at path, or every file under it if it is a directory, and the
+// files recorded under it that are gone
+func (w *watcher) scan(path string, baseline bool) {
+	seen := make(map[string]bool)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if p != path {
+					w.logger.V(1).Info("failed to read directory", "path", p, "error", err.Error())
+				}
+				return nil
+			}
+			if d.IsDir() {
+				w.watch(p)
+				return nil
+			}
+			seen[p] = true
+			w.check(p, baseline)
+			return nil
+		})
+		if err != nil {
+			w.logger.Error(err, "failed to walk directory", "path", path)
+		}
+	} else {
+		seen[path] = true
+		w.check(path, baseline)
+	}
+
+	for p := range w.files {
+		if !seen[p] && within(p, path) {
+			w.check(p, baseline)
+		}
+	}
+}
+
+// watch adds an inotify watch on the directory dir
+func (w *watcher) watch(dir string) {
+	if w.inotify == nil {
+		return
+	}
+	if err := w.inotify.Add(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.logger.Error(err, "failed to watch directory", "path", dir)
+	}
+}
+
+// check hashes the file at path and records it in the store if it changed
+func (w *watcher) check(path string, baseline bool) {
+	state, err := hashFile(path)
+	prev, known := w.files[path]
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNotRegular):
+		if !known {
+			return
+		}
+		if err := w.write(path, ChangeDeleted, nil, &prev); err != nil {
+			w.logger.Error(err, "failed to record file", "path", path)
+			return
+		}
+		delete(w.files, path)
+		return
+	case err != nil:
+		w.logger.Error(err, "failed to hash file", "path", path)
+		return
+	case !known:
+		change := ChangeCreated
+		if baseline {
+			change = ChangeBaseline
+		}
+		err = w.write(path, change, &state, nil)
+	case state.differs(prev):
+		err = w.write(path, ChangeModified, &state, &prev)
+	default:
+		return
+	}
+	if err != nil {
+		w.logger.Error(err, "failed to record file", "path", path)
+		return
+	}
+	w.files[path] = state
+}
+
+// write upserts the resource of the file at path. state is nil for a deleted file and
+// prev for a file first seen.
+func (w *watcher) write(path, change string, state, prev *fileState) error {
+	fields := map[string]any{
+		"nodeName":  w.nodeName,
+		"path":      path,
+		"change":    change,
+		"exists":    state != nil,
+		"timestamp": w.now().UTC().Format(time.RFC3339Nano),
+	}
+	if state != nil {
+		fields["sha256"] = state.sha256
+		fields["size"] = state.size
+		fields["mode"] = formatMode(state.mode)
+		fields["uid"] = state.uid
+		fields["gid"] = state.gid
+		fields["modified"] = state.modified.UTC().Format(time.RFC3339Nano)
+	}
+	if prev != nil {
+		fields["previousSha256"] = prev.sha256
+		fields["previousMode"] = formatMode(prev.mode)
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return fmt.Errorf("failed to create file spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal file spec: %w", err)
+	}
+
+	name := w.nodeName + path
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: name,
+			Name:       name,
+		},
+		Spec: specAny,
+	}
+	var opts []resource.WriteOption
+	if change != ChangeBaseline {
+		opts = append(opts, resource.WithEventClass(resource.EventClassCritical))
+		changesTotal.WithLabelValues(change).Inc()
+		values := []any{"path", path, "change", change}
+		if state != nil {
+			values = append(values, "sha256", state.sha256)
+		}
+		if prev != nil {
+			values = append(values, "previousSha256", prev.sha256)
+		}
+		w.logger.Info("Watched file changed", values...)
+	}
+	if err := w.store.UpdateResource(rsrc, opts...); err != nil {
+		return fmt.Errorf("failed to update file in inventory: %w", err)
+	}
+	return nil
+}
+
+// watched returns whether path is one of the watched paths or under one
+func (w *watcher) watched(path string) bool {
+	for _, root := range w.roots {
+		if within(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// within returns whether path is root or under it
+func within(path, root string) bool {
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/")
+}
+
+var errNotRegular = errors.New("not a regular file")
+
+// hashFile returns the state of the regular file at path, following symlinks
+func hashFile(path string) (fileState, error) {
+	// Opening a FIFO would block, so the file is checked to be regular first
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return fileState{}, errNotRegular
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fileState{}, err
+	}
+	state := fileState{
+		sha256:   hex.EncodeToString(hash.Sum(nil)),
+		size:     info.Size(),
+		mode:     uint32(info.Mode().Perm()),
+		modified: info.ModTime(),
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Unlike os.FileMode, the setuid, setgid and sticky bits of the stat mode are
+		// those of chmod
+		state.mode = uint32(stat.Mode) & 0o7777
+		state.uid, state.gid = stat.Uid, stat.Gid
+	}
+	return state, nil
+}
+
+// formatMode formats the permission bits of a file like chmod, e.g. 0644
+func formatMode(mode uint32) string {
+	return fmt.Sprintf("%04o", mode)
+}
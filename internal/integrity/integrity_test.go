@@ -0,0 +1,198 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package integrity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+// sha256V1 is the SHA-256 hash of v1
+const sha256V1 = "3bfc269594ef649228e9a74bab00f042efc91d5acc6fbee31a382e80d42388fe"
+
+func newTestWatcher(t *testing.T, inv resource.Store, paths ...string) *watcher {
+	t.Helper()
+	w := &Watcher{Store: inv, NodeName: "node-1", Paths: paths}
+	runnable, err := w.runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	return runnable
+}
+
+func getSpec(t *testing.T, inv resource.Store, path string) map[string]any {
+	t.Helper()
+	rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "node-1" + path})
+	if err != nil {
+		t.Fatalf("failed to get file %s: %v", path, err)
+	}
+	spec := &structpb.Struct{}
+	if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+		t.Fatalf("failed to unmarshal file spec: %v", err)
+	}
+	return spec.AsMap()
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher_Sync(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+	events := inv.Subscribe(nil, resource.WithoutInitialList())
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "kubernetes", "manifests", "etcd.yaml")
+	kubelet := filepath.Join(dir, "kubelet", "config.yaml")
+	writeFile(t, manifest, "v1")
+	writeFile(t, kubelet, "kind: KubeletConfiguration")
+	writeFile(t, filepath.Join(dir, "kubelet", "other.yaml"), "not watched")
+
+	w := newTestWatcher(t, inv, filepath.Join(dir, "kubernetes"), kubelet, filepath.Join(dir, "missing"))
+	w.sync(true)
+
+	spec := getSpec(t, inv, manifest)
+	if spec["change"] != ChangeBaseline || spec["exists"] != true || spec["mode"] != "0644" {
+		t.Errorf("unexpected spec of baseline file: %v", spec)
+	}
+	if spec["sha256"] != sha256V1 || spec["size"] != float64(2) {
+		t.Errorf("unexpected hash of baseline file: %v", spec)
+	}
+	if _, err := inv.GetResource(&resourcev1.ResourceRef{
+		TypeUrl: ResourceType,
+		Name:    "node-1" + filepath.Join(dir, "kubelet", "other.yaml"),
+	}); err == nil {
+		t.Error("expected a file next to a watched file not to be recorded")
+	}
+	for range 2 {
+		if e := <-events; e.Class == resource.EventClassCritical {
+			t.Errorf("expected baseline events not to be critical")
+		}
+	}
+
+	// Unchanged files aren't written again
+	w.sync(false)
+	select {
+	case e := <-events:
+		t.Fatalf("expected no event for unchanged files, got %v", e)
+	default:
+	}
+
+	writeFile(t, manifest, "v2")
+	if err := os.Chmod(kubelet, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(dir, "kubernetes", "admin.conf")
+	writeFile(t, created, "admin")
+	w.sync(false)
+
+	spec = getSpec(t, inv, manifest)
+	if spec["change"] != ChangeModified || spec["previousSha256"] != sha256V1 || spec["sha256"] == sha256V1 {
+		t.Errorf("unexpected spec of modified file: %v", spec)
+	}
+	spec = getSpec(t, inv, kubelet)
+	if spec["change"] != ChangeModified || spec["mode"] != "0600" || spec["previousMode"] != "0644" {
+		t.Errorf("unexpected spec of chmodded file: %v", spec)
+	}
+	if spec := getSpec(t, inv, created); spec["change"] != ChangeCreated {
+		t.Errorf("unexpected spec of created file: %v", spec)
+	}
+	for range 3 {
+		if e := <-events; e.Class != resource.EventClassCritical {
+			t.Errorf("expected changes to be critical events, got %s", e.Class)
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "kubernetes", "manifests")); err != nil {
+		t.Fatal(err)
+	}
+	w.sync(false)
+	spec = getSpec(t, inv, manifest)
+	if spec["change"] != ChangeDeleted || spec["exists"] != false || spec["previousSha256"] == nil {
+		t.Errorf("unexpected spec of deleted file: %v", spec)
+	}
+
+	// A new watcher on the same store reports what changed while it wasn't running
+	writeFile(t, created, "tampered")
+	restarted := newTestWatcher(t, inv, filepath.Join(dir, "kubernetes"), kubelet)
+	if err := restarted.load(); err != nil {
+		t.Fatalf("failed to load files: %v", err)
+	}
+	restarted.sync(true)
+	if spec := getSpec(t, inv, created); spec["change"] != ChangeModified {
+		t.Errorf("expected a file changed while not running to be modified, got %v", spec)
+	}
+	if spec := getSpec(t, inv, kubelet); spec["change"] != ChangeModified {
+		t.Errorf("expected an unchanged file not to be written again, got %v", spec)
+	}
+}
+
+func TestWatcher_Start(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "containerd", "config.toml")
+	writeFile(t, config, "v1")
+	w := newTestWatcher(t, inv, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := inv.Subscribe(nil, resource.WithoutInitialList())
+	go func() {
+		if err := w.Start(ctx); err != nil {
+			t.Errorf("failed to start watcher: %v", err)
+		}
+	}()
+
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the baseline of the watched file")
+	}
+
+	// Config files are usually replaced rather than written in place
+	replacement := filepath.Join(dir, "containerd", "config.toml.tmp")
+	writeFile(t, replacement, "v2")
+	if err := os.Rename(replacement, config); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Class != resource.EventClassCritical {
+			t.Errorf("expected a critical event, got %s", e.Class)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event for the replaced file")
+	}
+	if spec := getSpec(t, inv, config); spec["change"] != ChangeModified || spec["previousSha256"] != sha256V1 {
+		t.Errorf("unexpected spec of replaced file: %v", spec)
+	}
+}
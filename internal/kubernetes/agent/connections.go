@@ -0,0 +1,230 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/performance/process"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	connectionInventoryName = "connection-map"
+
+	// Resource types of the node's connections. There are no generated messages for them,
+	// their specs are google.protobuf.Structs.
+	processResourceType  = "antimetal.agent.network.v1.Process"
+	endpointResourceType = "antimetal.agent.network.v1.Endpoint"
+
+	defaultConnectionInventoryInterval = time.Minute
+)
+
+// ConnectionInventory periodically maps the TCP connections of the processes of the node
+// the agent runs on, giving the dependencies between services without eBPF:
+//
+//	Node -> Contains -> Process -> ConnectsTo -> Process
+//	Node -> Contains -> Process -> ConnectsTo -> Endpoint
+//
+// Connections are read from the socket inodes in the host's /proc/[pid]/fd joined with
+// the TCP tables of the network namespace of each process, so connections between pods
+// of the node are mapped from one process to the other. A connection relates its client
+// to the process accepting it, or to the remote endpoint when the other end isn't on the
+// node. Only processes listening or connected at the time of a sync are indexed, so
+// short-lived connections between syncs are missed.
+//
+// Processes are named <node>/<pid>, endpoints <ip>:<port>. Their specs are
+// google.protobuf.Structs with:
+//   - Process: pid, command and the listening addresses
+//   - Endpoint: address and port
+type ConnectionInventory struct {
+	Provider cluster.Provider
+	Store    resource.Store
+	NodeName string
+	// HostProcPath is where the host's /proc is mounted. Defaults to /proc.
+	HostProcPath string
+	// Interval is how often the connections are read. Defaults to 1 minute.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the ConnectionInventory to the provided manager
+func (c *ConnectionInventory) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if c.Store == nil {
+		return fmt.Errorf("ConnectionInventory must be configured with a non-nil Store")
+	}
+	if c.NodeName == "" {
+		return fmt.Errorf("ConnectionInventory must be configured with a NodeName")
+	}
+	procPath := c.HostProcPath
+	if procPath == "" {
+		procPath = "/proc"
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = defaultConnectionInventoryInterval
+	}
+
+	logger := mgr.GetLogger().WithName(connectionInventoryName)
+
+	return mgr.Add(&connectionIndexer{
+		provider: c.Provider,
+		nodeName: c.NodeName,
+		procPath: procPath,
+		interval: interval,
+		logger:   logger,
+		indexer:  topology.NewIndexer(c.Store, resourcev1.Provider_PROVIDER_KUBERNETES, "connection resource", logger),
+	})
+}
+
+type connectionIndexer struct {
+	provider    cluster.Provider
+	nodeName    string
+	clusterName string
+	procPath    string
+	interval    time.Duration
+	logger      logr.Logger
+	indexer     *topology.Indexer
+}
+
+func (c *connectionIndexer) Start(ctx context.Context) error {
+	clusterName, err := c.provider.ClusterName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	c.clusterName = clusterName
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.sync(); err != nil {
+			c.logger.Error(err, "failed to map connections")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// connections are only indexed into the store shipped by the leader.
+func (c *connectionIndexer) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *connectionIndexer) sync() error {
+	conns, err := process.ReadConnections(c.procPath)
+	if err != nil {
+		return err
+	}
+	c.indexer.Sync(c.resources(conns))
+	return nil
+}
+
+// resources returns the processes and remote endpoints of conns with the relationships
+// of the processes
+func (c *connectionIndexer) resources(conns *process.Connections) []topology.Resource {
+	nodeRef := &resourcev1.ResourceRef{
+		TypeUrl:   typeurl.Name(&corev1.Node{}),
+		Name:      c.nodeName,
+		Namespace: c.namespace(),
+	}
+	contains := (&k8sv1.Contains{}).ProtoReflect().Type()
+	containedBy := (&k8sv1.ContainedBy{}).ProtoReflect().Type()
+
+	pids := make([]int32, 0, len(conns.Processes))
+	for pid := range conns.Processes {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+
+	var rsrcs []topology.Resource
+	endpoints := make(map[string]*resourcev1.ResourceRef)
+	for _, pid := range pids {
+		p := conns.Processes[pid]
+		procRef := c.processRef(pid)
+		rsrc := topology.Resource{
+			Ref: procRef,
+			Spec: map[string]any{
+				"pid":       float64(p.PID),
+				"command":   p.Command,
+				"listening": stringsToAny(p.Listening),
+			},
+			Rels: []topology.Relationship{
+				{Subject: nodeRef, Object: procRef, Predicate: contains, Inverse: containedBy},
+			},
+		}
+		for _, peer := range p.Peers {
+			rsrc.Rels = append(rsrc.Rels, topology.Relationship{
+				Subject: procRef, Object: c.processRef(peer), Predicate: connectsToType, Inverse: connectedFromType,
+			})
+		}
+		for _, remote := range p.Remotes {
+			endpointRef, ok := endpoints[remote]
+			if !ok {
+				host, port, err := net.SplitHostPort(remote)
+				if err != nil {
+					continue
+				}
+				portNumber, _ := strconv.Atoi(port)
+				endpointRef = &resourcev1.ResourceRef{
+					TypeUrl:   endpointResourceType,
+					Name:      remote,
+					Namespace: c.namespace(),
+				}
+				endpoints[remote] = endpointRef
+				rsrcs = append(rsrcs, topology.Resource{
+					Ref:  endpointRef,
+					Spec: map[string]any{"address": host, "port": float64(portNumber)},
+				})
+			}
+			rsrc.Rels = append(rsrc.Rels, topology.Relationship{
+				Subject: procRef, Object: endpointRef, Predicate: connectsToType, Inverse: connectedFromType,
+			})
+		}
+		rsrcs = append(rsrcs, rsrc)
+	}
+	return rsrcs
+}
+
+// processRef returns the reference of the process pid on this node. Processes are named
+// <node>/<pid> since every node has e.g. a process 1.
+func (c *connectionIndexer) processRef(pid int32) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl:   processResourceType,
+		Name:      c.nodeName + "/" + strconv.Itoa(int(pid)),
+		Namespace: c.namespace(),
+	}
+}
+
+func (c *connectionIndexer) namespace() *resourcev1.Namespace {
+	return &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{
+				Cluster: c.clusterName,
+			},
+		},
+	}
+}
@@ -9,17 +9,22 @@ package agent
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/antimetal/agent/pkg/errors"
 	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
 	"github.com/go-logr/logr"
 	gogoproto "github.com/gogo/protobuf/proto"
 	"golang.org/x/sync/errgroup"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -41,6 +46,7 @@ import (
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch,resourceNames=cluster-info
 // +kubebuilder:rbac:groups=core,resources=nodes;persistentvolumes;persistentvolumeclaims;pods;services,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=nodes/status;persistentvolumes/status;persistentvolumeclaims/status;replicationcontrollers/status;services/status,verbs=get
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch
 
 const (
 	controllerName = "k8s-agent"
@@ -58,26 +64,53 @@ type object interface {
 }
 
 var (
-	resourcesToWatch = []object{
-		&corev1.Node{},
-		&corev1.Pod{},
-		&corev1.PersistentVolume{},
-		&corev1.PersistentVolumeClaim{},
-		&corev1.Service{},
-		&appsv1.DaemonSet{},
-		&appsv1.Deployment{},
-		&appsv1.ReplicaSet{},
-		&appsv1.StatefulSet{},
-		&batchv1.Job{},
+	// watchableTypes are the types the controller can watch by their plural resource name
+	watchableTypes = map[string]object{
+		"nodes":                  &corev1.Node{},
+		"pods":                   &corev1.Pod{},
+		"persistentvolumes":      &corev1.PersistentVolume{},
+		"persistentvolumeclaims": &corev1.PersistentVolumeClaim{},
+		"services":               &corev1.Service{},
+		"networkpolicies":        &networkingv1.NetworkPolicy{},
+		"daemonsets":             &appsv1.DaemonSet{},
+		"deployments":            &appsv1.Deployment{},
+		"replicasets":            &appsv1.ReplicaSet{},
+		"statefulsets":           &appsv1.StatefulSet{},
+		"jobs":                   &batchv1.Job{},
 	}
 )
 
+// WatchableTypes returns the sorted names of the types the Controller can watch
+func WatchableTypes() []string {
+	return slices.Sorted(maps.Keys(watchableTypes))
+}
+
+// WatchableTypeNames returns the sorted type names of the resources the Controller indexes
+// for the types it can watch, e.g. k8s.io.api.core.v1.Pod for pods
+func WatchableTypeNames() []string {
+	names := make([]string, 0, len(watchableTypes))
+	for _, obj := range watchableTypes {
+		names = append(names, typeurl.Name(obj))
+	}
+	slices.Sort(names)
+	return names
+}
+
 // Collector builds a snapshot of the state of the cluster
 type Controller struct {
 	Config    *rest.Config
 	K8sClient client.Client
 	Provider  cluster.Provider
 	Store     resource.Store
+	// WatchedTypes are the names of the types to watch, from WatchableTypes. Empty
+	// watches all types. Only watched types have an informer, so leaving out types that
+	// aren't needed, like jobs on clusters running many batch workloads, saves the memory
+	// of caching them. Resources of types that are no longer watched are deleted from
+	// the Store on start.
+	WatchedTypes []string
+	// Region is the region of the cluster when its Provider doesn't know it, e.g. from
+	// the instance metadata of the node. It is backfilled into resources without a region.
+	Region string
 }
 
 // SetupWithManger registers the Controller to the provided manager
@@ -96,6 +129,11 @@ func (c *Controller) SetupWithManager(mgr manager.Manager) error {
 		c.Config = mgr.GetConfig()
 	}
 
+	watched, unwatched, err := selectWatchedTypes(c.WatchedTypes)
+	if err != nil {
+		return err
+	}
+
 	cacheSyncTimeout := mgr.GetControllerOptions().CacheSyncTimeout
 	if cacheSyncTimeout == 0 {
 		// Use the same default as controller-runtime Controllers
@@ -112,22 +150,50 @@ func (c *Controller) SetupWithManager(mgr manager.Manager) error {
 	indexer := &indexer{
 		store:    c.Store,
 		provider: c.Provider,
+		region:   c.Region,
 	}
 
+	logger := mgr.GetLogger().WithName(controllerName)
 	ctrl := &controller{
 		cfg:              c.Config,
 		scheme:           mgr.GetScheme(),
 		provider:         c.Provider,
-		logger:           mgr.GetLogger().WithName(controllerName),
+		logger:           logger,
 		informerFactory:  mgr.GetCache(),
 		cacheSyncTimeout: cacheSyncTimeout,
 		indexer:          indexer,
 		queue:            queue,
+		retries:          newRetryQueue(logger),
+		received:         newReceivedTimes(),
+		watched:          watched,
+		unwatched:        unwatched,
 	}
 
 	return mgr.Add(ctrl)
 }
 
+// selectWatchedTypes splits the watchable types into the ones named in names, or all of
+// them if names is empty, and the rest
+func selectWatchedTypes(names []string) (watched, unwatched []object, err error) {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := watchableTypes[name]; !ok {
+			return nil, nil, fmt.Errorf("unknown type %q to watch, watchable types: %s",
+				name, strings.Join(WatchableTypes(), ", "))
+		}
+		selected[name] = true
+	}
+	for _, name := range WatchableTypes() {
+		if len(selected) == 0 || selected[name] {
+			watched = append(watched, watchableTypes[name])
+		} else {
+			unwatched = append(unwatched, watchableTypes[name])
+		}
+	}
+	return watched, unwatched, nil
+}
+
 type controller struct {
 	cfg              *rest.Config
 	scheme           *runtime.Scheme
@@ -136,7 +202,11 @@ type controller struct {
 	informerFactory  cache.Informers
 	cacheSyncTimeout time.Duration
 	queue            workqueue.TypedRateLimitingInterface[event]
+	retries          *retryQueue
+	received         *receivedTimes
 	indexer          *indexer
+	watched          []object
+	unwatched        []object
 
 	// runtime state
 	started bool
@@ -155,6 +225,14 @@ func (c *controller) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to load cluster info: %w", err)
 	}
 
+	// A persisted store can hold resources indexed while a type was still watched, which
+	// would otherwise never be deleted
+	for _, obj := range c.unwatched {
+		if err := c.indexer.DeleteType(obj); err != nil {
+			c.logger.Error(err, "failed to delete resources of unwatched type", "type", typeurl.Name(obj))
+		}
+	}
+
 	if err := c.syncCache(ctx); err != nil {
 		return fmt.Errorf("error syncing cache: %w", err)
 	}
@@ -167,6 +245,11 @@ func (c *controller) Start(ctx context.Context) error {
 			c.indexWorker(ctx)
 		}()
 	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.retryWorker(ctx)
+	}()
 
 	c.started = true
 	<-ctx.Done()
@@ -178,6 +261,7 @@ func (c *controller) Start(ctx context.Context) error {
 
 func (c *controller) Shutdown() {
 	c.queue.ShutDown()
+	c.retries.ShutDown()
 	c.started = false
 }
 
@@ -204,37 +288,81 @@ func (c *controller) indexObjects(ctx context.Context) {
 		return
 	}
 	defer c.queue.Done(ev)
+	// Failed events are retried through c.retries, so the informer queue never requeues
+	defer c.queue.Forget(ev)
+
+	if err := c.index(ctx, ev); err != nil {
+		c.handleIndexError(ev, err)
+		return
+	}
+	c.indexed(ev)
+}
+
+// retryWorker indexes the events that failed with a retryable error until ctx is done
+func (c *controller) retryWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			ev, shutdown := c.retries.Get()
+			if shutdown {
+				return
+			}
+			retrying := false
+			if err := c.index(ctx, ev); err != nil {
+				retrying = c.handleIndexError(ev, err)
+			} else {
+				c.indexed(ev)
+			}
+			c.retries.Done(ev, retrying)
+		}
+	}
+}
 
-	var err error
+// handleIndexError schedules ev for a retry if err is retryable. It returns whether ev
+// will be retried.
+func (c *controller) handleIndexError(ev event, err error) bool {
+	if !errors.Retryable(err) {
+		c.logger.V(1).Info("failed to index object", "error", err, "event", eventStr(ev.typ), "object", ev.obj)
+		droppedEventsTotal.WithLabelValues(objectKind(ev.obj), "error").Inc()
+		c.received.remove(ev)
+		return false
+	}
+	retryableErrorsTotal.WithLabelValues(objectKind(ev.obj)).Inc()
+	if !c.retries.Retry(ev, err) {
+		c.received.remove(ev)
+		return false
+	}
+	c.logger.V(1).Info("failed to index object; will retry", "error", err, "event", eventStr(ev.typ), "object", ev.obj)
+	return true
+}
+
+// indexed records the metrics of ev, which was written to the store
+func (c *controller) indexed(ev event) {
+	indexedObjectsTotal.WithLabelValues(objectKind(ev.obj), eventStr(ev.typ)).Inc()
+	if received, ok := c.received.remove(ev); ok {
+		eventLagSeconds.WithLabelValues(eventStr(ev.typ)).Observe(time.Since(received).Seconds())
+	}
+}
+
+func (c *controller) index(ctx context.Context, ev event) error {
 	switch ev.typ {
 	case EventAdd:
 		c.logger.V(1).Info("adding object to index", "event", eventStr(ev.typ), "object", ev.obj)
-		err = c.indexer.Add(ctx, ev.obj)
+		return c.indexer.Add(ctx, ev.obj)
 	case EventUpdate:
 		c.logger.V(1).Info("update object in index", "event", eventStr(ev.typ), "object", ev.obj)
-		err = c.indexer.Update(ctx, ev.obj)
+		return c.indexer.Update(ctx, ev.obj)
+	case EventResync:
+		c.logger.V(1).Info("resync object in index", "event", eventStr(ev.typ), "object", ev.obj)
+		return c.indexer.Update(ctx, ev.obj, resource.WithEventClass(resource.EventClassBulk))
 	case EventDelete:
 		c.logger.V(1).Info("deleting object to index", "event", eventStr(ev.typ), "object", ev.obj)
-		err = c.indexer.Delete(ctx, ev.obj)
+		return c.indexer.Delete(ctx, ev.obj)
 	default:
-		err = fmt.Errorf("unknown event type: %d", ev.typ)
+		return fmt.Errorf("unknown event type: %d", ev.typ)
 	}
-
-	if err != nil {
-		errLog := "failed to index object"
-		if errors.Retryable(err) {
-			// Requeue the event if the returned error is retryable/
-			// We'll keep doing this until successful
-			errLog += "; will retry"
-			c.queue.AddRateLimited(ev)
-		}
-		c.logger.V(1).Info(errLog, "error", err, "event", eventStr(ev.typ), "object", ev.obj)
-		return
-	}
-
-	// If we've successfully indexed the object, we can forget it so that it is
-	// cleared from requeuing.
-	c.queue.Forget(ev)
 }
 
 func (c *controller) syncCache(ctx context.Context) error {
@@ -242,7 +370,7 @@ func (c *controller) syncCache(ctx context.Context) error {
 	defer syncCancel()
 	g, gCtx := errgroup.WithContext(syncCtx)
 
-	for _, obj := range resourcesToWatch {
+	for _, obj := range c.watched {
 		g.Go(func() error {
 			var informer cache.Informer
 			var err error
@@ -274,9 +402,10 @@ func (c *controller) syncCache(ctx context.Context) error {
 			}
 
 			h := k8sCollectorHandler{
-				logger: c.logger,
-				scheme: c.scheme,
-				queue:  c.queue,
+				logger:   c.logger,
+				scheme:   c.scheme,
+				queue:    c.queue,
+				received: c.received,
 			}
 			_, err = informer.AddEventHandler(h)
 			if err != nil {
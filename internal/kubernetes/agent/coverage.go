@@ -0,0 +1,93 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Coverage is what a component writes to the store: the types of its resources and of the
+// predicates of its relationships, e.g. for the capability manifest sent to the intake
+type Coverage struct {
+	ResourceTypes []string
+	Predicates    []string
+}
+
+// predicateNames returns the names of the predicate types
+func predicateNames(types ...protoreflect.MessageType) []string {
+	names := make([]string, len(types))
+	for i, typ := range types {
+		names[i] = string(typ.Descriptor().FullName())
+	}
+	return names
+}
+
+// containment are the predicates relating a node to what runs or is attached on it
+func containment() []protoreflect.MessageType {
+	return []protoreflect.MessageType{
+		(&k8sv1.Contains{}).ProtoReflect().Type(),
+		(&k8sv1.ContainedBy{}).ProtoReflect().Type(),
+	}
+}
+
+// Coverage returns the resources the Controller indexes for its watched types and the
+// relationships between them. Types that aren't valid watchable types are left out.
+func (c *Controller) Coverage() Coverage {
+	watched, _, err := selectWatchedTypes(c.WatchedTypes)
+	if err != nil {
+		return Coverage{}
+	}
+	types := []string{typeurl.Name(&k8sv1.Cluster{})}
+	for _, obj := range watched {
+		types = append(types, typeurl.Name(obj))
+	}
+	predicates := append(containment(),
+		(&k8sv1.Owns{}).ProtoReflect().Type(), (&k8sv1.OwnedBy{}).ProtoReflect().Type(),
+		(&k8sv1.AttachedTo{}).ProtoReflect().Type(), (&k8sv1.VolumeMount{}).ProtoReflect().Type(),
+		(&k8sv1.ClaimsFrom{}).ProtoReflect().Type(), (&k8sv1.BoundBy{}).ProtoReflect().Type(),
+		selectsType, selectedByType, appliesToType, appliedByType,
+	)
+	return Coverage{ResourceTypes: types, Predicates: predicateNames(predicates...)}
+}
+
+func (i *ImageInventory) Coverage() Coverage {
+	return Coverage{
+		ResourceTypes: []string{imageResourceType},
+		Predicates:    predicateNames(containment()...),
+	}
+}
+
+func (s *StorageInventory) Coverage() Coverage {
+	return Coverage{
+		ResourceTypes: []string{diskResourceType, partitionResourceType, filesystemResourceType},
+		Predicates: predicateNames(append(containment(),
+			hasPartitionType, partitionOfType, backsType, backedByType)...),
+	}
+}
+
+func (c *ConnectionInventory) Coverage() Coverage {
+	return Coverage{
+		ResourceTypes: []string{processResourceType, endpointResourceType},
+		Predicates:    predicateNames(append(containment(), connectsToType, connectedFromType)...),
+	}
+}
+
+func (l *ListenerInventory) Coverage() Coverage {
+	return Coverage{
+		ResourceTypes: []string{listenerResourceType},
+		Predicates:    predicateNames(containment()...),
+	}
+}
+
+func (n *NUMAInventory) Coverage() Coverage {
+	return Coverage{
+		ResourceTypes: []string{numaNodeResourceType, pciDeviceResourceType},
+		Predicates:    predicateNames(containment()...),
+	}
+}
@@ -12,13 +12,14 @@ import (
 	"github.com/antimetal/agent/internal/kubernetes/scheme"
 	"github.com/antimetal/agent/pkg/errors"
 	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
 	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
 	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
-	gogoproto "github.com/gogo/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -57,7 +58,9 @@ func (i *indexer) generate(obj object) (rsrc *resourcev1.Resource, rels []*resou
 	case *corev1.PersistentVolumeClaim:
 		rsrc, rels, err = genPersistentVolumeClaim(i.clusterName, obj, owners...)
 	case *corev1.Service:
-		rsrc, rels, err = genService(i.clusterName, obj, owners...)
+		rsrc, rels, err = genService(i.store, i.clusterName, obj, owners...)
+	case *networkingv1.NetworkPolicy:
+		rsrc, rels, err = genNetworkPolicy(i.store, i.clusterName, obj, owners...)
 	case *appsv1.DaemonSet:
 		rsrc, rels, err = genDaemonSet(i.clusterName, obj, owners...)
 	case *appsv1.Deployment:
@@ -74,6 +77,9 @@ func (i *indexer) generate(obj object) (rsrc *resourcev1.Resource, rels []*resou
 			obj.GetNamespace(), obj.GetName(),
 		)
 	}
+	if err == nil {
+		i.backfillLocation(rsrc, obj)
+	}
 
 	return
 }
@@ -91,14 +97,14 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 	}
 
 	objRef := &resourcev1.ResourceRef{
-		TypeUrl:   gogoproto.MessageName(obj),
+		TypeUrl:   typeurl.Name(obj),
 		Name:      rsrc.GetMetadata().GetName(),
 		Namespace: rsrc.GetMetadata().GetNamespace(),
 	}
 
 	if podObj.Spec.NodeName != "" {
 		nodeRsrc, err := store.GetResource(&resourcev1.ResourceRef{
-			TypeUrl: gogoproto.MessageName(&corev1.Node{}),
+			TypeUrl: typeurl.Name(&corev1.Node{}),
 			Name:    podObj.Spec.NodeName,
 			Namespace: &resourcev1.Namespace{
 				Namespace: &resourcev1.Namespace_Kube{
@@ -136,7 +142,7 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 			&resourcev1.Relationship{
 				Type: &resourcev1.TypeDescriptor{
 					Kind: kindRelationship,
-					Type: string(contains.ProtoReflect().Descriptor().FullName()),
+					Type: typeurl.Name(contains),
 				},
 				Subject:   nodeRef,
 				Object:    objRef,
@@ -145,7 +151,7 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 			&resourcev1.Relationship{
 				Type: &resourcev1.TypeDescriptor{
 					Kind: kindRelationship,
-					Type: string(containedBy.ProtoReflect().Descriptor().FullName()),
+					Type: typeurl.Name(containedBy),
 				},
 				Subject:   objRef,
 				Object:    nodeRef,
@@ -157,7 +163,7 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 	for _, volume := range podObj.Spec.Volumes {
 		if volume.PersistentVolumeClaim != nil {
 			pvcRef := &resourcev1.ResourceRef{
-				TypeUrl: gogoproto.MessageName(&corev1.PersistentVolumeClaim{}),
+				TypeUrl: typeurl.Name(&corev1.PersistentVolumeClaim{}),
 				Name:    volume.PersistentVolumeClaim.ClaimName,
 				Namespace: &resourcev1.Namespace{
 					Namespace: &resourcev1.Namespace_Kube{
@@ -182,7 +188,7 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 				&resourcev1.Relationship{
 					Type: &resourcev1.TypeDescriptor{
 						Kind: kindRelationship,
-						Type: string(volumeMount.ProtoReflect().Descriptor().FullName()),
+						Type: typeurl.Name(volumeMount),
 					},
 					Subject:   pvcRef,
 					Object:    objRef,
@@ -191,7 +197,7 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 				&resourcev1.Relationship{
 					Type: &resourcev1.TypeDescriptor{
 						Kind: kindRelationship,
-						Type: string(attachedTo.ProtoReflect().Descriptor().FullName()),
+						Type: typeurl.Name(attachedTo),
 					},
 					Subject:   objRef,
 					Object:    pvcRef,
@@ -201,6 +207,12 @@ func genPod(store resource.Store, clusterName string, obj object, owners ...obje
 		}
 	}
 
+	topologyRels, err := genPodTopology(store, clusterName, podObj, objRef)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create topology relationships: %w", err)
+	}
+	rels = append(rels, topologyRels...)
+
 	return rsrc, rels, nil
 }
 
@@ -209,8 +221,7 @@ func genNode(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create resource and base relationships: %w", err)
 	}
-	rsrc.GetMetadata().Region = obj.GetLabels()["topology.kubernetes.io/region"]
-	rsrc.GetMetadata().Zone = obj.GetLabels()["topology.kubernetes.io/zone"]
+	rsrc.GetMetadata().Region, rsrc.GetMetadata().Zone = labelLocation(obj.GetLabels())
 	return rsrc, rels, nil
 }
 
@@ -219,8 +230,7 @@ func genPersistentVolume(clusterName string, obj object, owners ...object) (*res
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create resource and base relationships: %w", err)
 	}
-	rsrc.GetMetadata().Region = obj.GetLabels()["topology.kubernetes.io/region"]
-	rsrc.GetMetadata().Zone = obj.GetLabels()["topology.kubernetes.io/zone"]
+	rsrc.GetMetadata().Region, rsrc.GetMetadata().Zone = labelLocation(obj.GetLabels())
 	return rsrc, rels, nil
 }
 
@@ -237,12 +247,12 @@ func genPersistentVolumeClaim(clusterName string, obj object, owners ...object)
 
 	if pvcObj.Spec.VolumeName != "" {
 		objRef := &resourcev1.ResourceRef{
-			TypeUrl:   gogoproto.MessageName(obj),
+			TypeUrl:   typeurl.Name(obj),
 			Name:      rsrc.GetMetadata().GetName(),
 			Namespace: rsrc.GetMetadata().GetNamespace(),
 		}
 		pvRef := &resourcev1.ResourceRef{
-			TypeUrl: gogoproto.MessageName(&corev1.PersistentVolume{}),
+			TypeUrl: typeurl.Name(&corev1.PersistentVolume{}),
 			Name:    pvcObj.Spec.VolumeName,
 			Namespace: &resourcev1.Namespace{
 				Namespace: &resourcev1.Namespace_Kube{
@@ -266,7 +276,7 @@ func genPersistentVolumeClaim(clusterName string, obj object, owners ...object)
 			&resourcev1.Relationship{
 				Type: &resourcev1.TypeDescriptor{
 					Kind: kindRelationship,
-					Type: string(claimsFrom.ProtoReflect().Descriptor().FullName()),
+					Type: typeurl.Name(claimsFrom),
 				},
 				Subject:   objRef,
 				Object:    pvRef,
@@ -275,7 +285,7 @@ func genPersistentVolumeClaim(clusterName string, obj object, owners ...object)
 			&resourcev1.Relationship{
 				Type: &resourcev1.TypeDescriptor{
 					Kind: kindRelationship,
-					Type: string(boundBy.ProtoReflect().Descriptor().FullName()),
+					Type: typeurl.Name(boundBy),
 				},
 				Subject:   pvRef,
 				Object:    objRef,
@@ -287,8 +297,42 @@ func genPersistentVolumeClaim(clusterName string, obj object, owners ...object)
 	return rsrc, rels, nil
 }
 
-func genService(clusterName string, obj object, owners ...object) (*resourcev1.Resource, []*resourcev1.Relationship, error) {
-	return genBase(clusterName, obj, owners...)
+func genService(store resource.Store, clusterName string, obj object, owners ...object,
+) (*resourcev1.Resource, []*resourcev1.Relationship, error) {
+	svcObj, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, nil, fmt.Errorf("object is not a Service; got %s", obj.GetObjectKind().GroupVersionKind().String())
+	}
+
+	rsrc, rels, err := genBase(clusterName, obj, owners...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create resource and base relationships: %w", err)
+	}
+
+	topologyRels, err := genServiceTopology(store, clusterName, svcObj, resourceRef(rsrc))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create topology relationships: %w", err)
+	}
+	return rsrc, append(rels, topologyRels...), nil
+}
+
+func genNetworkPolicy(store resource.Store, clusterName string, obj object, owners ...object,
+) (*resourcev1.Resource, []*resourcev1.Relationship, error) {
+	policyObj, ok := obj.(*networkingv1.NetworkPolicy)
+	if !ok {
+		return nil, nil, fmt.Errorf("object is not a NetworkPolicy; got %s", obj.GetObjectKind().GroupVersionKind().String())
+	}
+
+	rsrc, rels, err := genBase(clusterName, obj, owners...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create resource and base relationships: %w", err)
+	}
+
+	topologyRels, err := genNetworkPolicyTopology(store, clusterName, policyObj, resourceRef(rsrc))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create topology relationships: %w", err)
+	}
+	return rsrc, append(rels, topologyRels...), nil
 }
 
 func genDaemonSet(clusterName string, obj object, owners ...object) (*resourcev1.Resource, []*resourcev1.Relationship, error) {
@@ -320,7 +364,7 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 	rsrc := &resourcev1.Resource{
 		Type: &resourcev1.TypeDescriptor{
 			Kind: kindResource,
-			Type: gogoproto.MessageName(obj),
+			Type: typeurl.Name(obj),
 		},
 		Metadata: &resourcev1.ResourceMeta{
 			Provider:   resourcev1.Provider_PROVIDER_KUBERNETES,
@@ -337,14 +381,14 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 			Tags: labelsToTags(obj.GetLabels()),
 		},
 		Spec: &anypb.Any{
-			TypeUrl: gogoproto.MessageName(obj),
+			TypeUrl: typeurl.URL(obj),
 			Value:   data,
 		},
 	}
 
 	// Add relationships to the cluster and the object.
 	clusterRef := &resourcev1.ResourceRef{
-		TypeUrl: string((&k8sv1.Cluster{}).ProtoReflect().Descriptor().FullName()),
+		TypeUrl: typeurl.Name(&k8sv1.Cluster{}),
 		Name:    clusterName,
 	}
 	objRef := &resourcev1.ResourceRef{
@@ -367,7 +411,7 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 		&resourcev1.Relationship{
 			Type: &resourcev1.TypeDescriptor{
 				Kind: kindRelationship,
-				Type: string(contains.ProtoReflect().Descriptor().FullName()),
+				Type: typeurl.Name(contains),
 			},
 			Subject:   clusterRef,
 			Object:    objRef,
@@ -376,7 +420,7 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 		&resourcev1.Relationship{
 			Type: &resourcev1.TypeDescriptor{
 				Kind: kindRelationship,
-				Type: string(containedBy.ProtoReflect().Descriptor().FullName()),
+				Type: typeurl.Name(containedBy),
 			},
 			Subject:   objRef,
 			Object:    clusterRef,
@@ -387,7 +431,7 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 	// Add relationships to the resource owners if any.
 	for _, owner := range owners {
 		ownerRef := &resourcev1.ResourceRef{
-			TypeUrl: gogoproto.MessageName(owner),
+			TypeUrl: typeurl.Name(owner),
 			Name:    owner.GetName(),
 			Namespace: &resourcev1.Namespace{
 				Namespace: &resourcev1.Namespace_Kube{
@@ -412,7 +456,7 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 			&resourcev1.Relationship{
 				Type: &resourcev1.TypeDescriptor{
 					Kind: kindRelationship,
-					Type: string(owns.ProtoReflect().Descriptor().FullName()),
+					Type: typeurl.Name(owns),
 				},
 				Subject:   ownerRef,
 				Object:    objRef,
@@ -421,7 +465,7 @@ func genBase(clusterName string, obj object, owners ...object) (*resourcev1.Reso
 			&resourcev1.Relationship{
 				Type: &resourcev1.TypeDescriptor{
 					Kind: kindRelationship,
-					Type: string(ownedBy.ProtoReflect().Descriptor().FullName()),
+					Type: typeurl.Name(ownedBy),
 				},
 				Subject:   objRef,
 				Object:    ownerRef,
@@ -8,6 +8,7 @@ package agent
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -20,6 +21,9 @@ const (
 	EventAdd eventType = iota
 	EventUpdate
 	EventDelete
+	// EventResync is an update of an unchanged object, redelivered by the periodic
+	// resync of its informer
+	EventResync
 )
 
 type event struct {
@@ -28,16 +32,23 @@ type event struct {
 }
 
 type k8sCollectorHandler struct {
-	logger logr.Logger
-	scheme *runtime.Scheme
-	queue  workqueue.TypedRateLimitingInterface[event]
+	logger   logr.Logger
+	scheme   *runtime.Scheme
+	queue    workqueue.TypedRateLimitingInterface[event]
+	received *receivedTimes
 }
 
 func (h k8sCollectorHandler) OnAdd(obj any, _ bool) {
 	h.handle(EventAdd, obj)
 }
 
-func (h k8sCollectorHandler) OnUpdate(_, newObj any) {
+func (h k8sCollectorHandler) OnUpdate(oldObj, newObj any) {
+	oldK8sObj, oldOk := oldObj.(object)
+	newK8sObj, newOk := newObj.(object)
+	if oldOk && newOk && oldK8sObj.GetResourceVersion() == newK8sObj.GetResourceVersion() {
+		h.handle(EventResync, newObj)
+		return
+	}
 	h.handle(EventUpdate, newObj)
 }
 
@@ -60,7 +71,9 @@ func (h k8sCollectorHandler) handle(ev eventType, obj any) {
 	k8sObj.GetObjectKind().SetGroupVersionKind(gvks[0])
 	k8sObj.SetManagedFields(nil)
 
-	h.queue.AddRateLimited(event{typ: ev, obj: k8sObj})
+	e := event{typ: ev, obj: k8sObj}
+	h.received.add(e, time.Now())
+	h.queue.AddRateLimited(e)
 }
 
 func eventStr(e eventType) string {
@@ -71,6 +84,8 @@ func eventStr(e eventType) string {
 		return "update"
 	case EventDelete:
 		return "delete"
+	case EventResync:
+		return "resync"
 	default:
 		return "unknown"
 	}
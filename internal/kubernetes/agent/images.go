@@ -0,0 +1,282 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/cri"
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	imageInventoryName = "image-inventory"
+
+	// imageResourceType is the resource type of container images. It's the name of the
+	// CRI message images are listed as.
+	imageResourceType = "runtime.v1.Image"
+
+	defaultImageInventoryInterval = 5 * time.Minute
+)
+
+// ImageLister lists the container images present on a node
+type ImageLister interface {
+	ListImages(ctx context.Context) ([]cri.Image, error)
+	ImageCreated(ctx context.Context, id string) (time.Time, error)
+}
+
+// ImageInventory periodically indexes the container images present on the node the
+// agent runs on, as reported by the node's container runtime. Images are stored as
+// resources contained by their node.
+//
+// The spec of an image resource is a google.protobuf.Struct with its id, repoTags,
+// repoDigests, sizeBytes, pinned and, if the runtime reports it, created fields.
+type ImageInventory struct {
+	Runtime  ImageLister
+	Provider cluster.Provider
+	Store    resource.Store
+	NodeName string
+	// Interval is how often the images are listed. Defaults to 5 minutes.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the ImageInventory to the provided manager
+func (i *ImageInventory) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if i.Store == nil {
+		return fmt.Errorf("ImageInventory must be configured with a non-nil Store")
+	}
+	if i.Runtime == nil {
+		return fmt.Errorf("ImageInventory must be configured with a non-nil Runtime")
+	}
+	if i.NodeName == "" {
+		return fmt.Errorf("ImageInventory must be configured with a NodeName")
+	}
+	interval := i.Interval
+	if interval <= 0 {
+		interval = defaultImageInventoryInterval
+	}
+
+	return mgr.Add(&imageIndexer{
+		runtime:  i.Runtime,
+		provider: i.Provider,
+		store:    i.Store,
+		nodeName: i.NodeName,
+		interval: interval,
+		logger:   mgr.GetLogger().WithName(imageInventoryName),
+		indexed:  make(map[string][]byte),
+		created:  make(map[string]time.Time),
+	})
+}
+
+type imageIndexer struct {
+	runtime     ImageLister
+	provider    cluster.Provider
+	store       resource.Store
+	nodeName    string
+	clusterName string
+	interval    time.Duration
+	logger      logr.Logger
+
+	// indexed holds the encoded spec of every image in the store by image ID so
+	// unchanged images aren't updated on every sync
+	indexed map[string][]byte
+	// created caches image creation times, which never change for an image ID
+	created map[string]time.Time
+}
+
+func (i *imageIndexer) Start(ctx context.Context) error {
+	clusterName, err := i.provider.ClusterName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	i.clusterName = clusterName
+
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+	for {
+		if err := i.sync(ctx); err != nil {
+			i.logger.Error(err, "failed to index images")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// images are only indexed into the store shipped by the leader.
+func (i *imageIndexer) NeedLeaderElection() bool {
+	return true
+}
+
+func (i *imageIndexer) sync(ctx context.Context) error {
+	images, err := i.runtime.ListImages(ctx)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool, len(images))
+	for _, img := range images {
+		present[img.ID] = true
+		if err := i.index(ctx, img); err != nil {
+			i.logger.Error(err, "failed to index image", "image", img.ID)
+		}
+	}
+
+	for id := range i.indexed {
+		if present[id] {
+			continue
+		}
+		err := i.store.DeleteResource(i.imageRef(id))
+		if err != nil && !errors.Is(err, resource.ErrResourceNotFound) {
+			i.logger.Error(err, "failed to delete image", "image", id)
+			continue
+		}
+		delete(i.indexed, id)
+		delete(i.created, id)
+	}
+	return nil
+}
+
+func (i *imageIndexer) index(ctx context.Context, img cri.Image) error {
+	created, ok := i.created[img.ID]
+	if !ok {
+		var err error
+		created, err = i.runtime.ImageCreated(ctx, img.ID)
+		if err != nil {
+			// The image is still indexed, only without its age
+			i.logger.V(1).Info("failed to get image creation time", "image", img.ID, "error", err)
+		} else {
+			i.created[img.ID] = created
+		}
+	}
+
+	spec, err := imageSpec(img, created)
+	if err != nil {
+		return err
+	}
+	prev, wasIndexed := i.indexed[img.ID]
+	// Deterministic so an unchanged spec encodes to the same bytes
+	encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image spec: %w", err)
+	}
+	if wasIndexed && bytes.Equal(prev, encoded) {
+		return nil
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image spec: %w", err)
+	}
+
+	ref := i.imageRef(img.ID)
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: imageResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			Provider:   resourcev1.Provider_PROVIDER_KUBERNETES,
+			ProviderId: img.ID,
+			Name:       ref.GetName(),
+			Namespace:  ref.GetNamespace(),
+		},
+		Spec: specAny,
+	}
+	if err := i.store.UpdateResource(rsrc); err != nil {
+		return fmt.Errorf("failed to update image in inventory: %w", err)
+	}
+
+	if !wasIndexed {
+		nodeRef := &resourcev1.ResourceRef{
+			TypeUrl: typeurl.Name(&corev1.Node{}),
+			Name:    i.nodeName,
+			Namespace: &resourcev1.Namespace{
+				Namespace: &resourcev1.Namespace_Kube{
+					Kube: &resourcev1.KubernetesNamespace{
+						Cluster: i.clusterName,
+					},
+				},
+			},
+		}
+		contains := &k8sv1.Contains{}
+		containedBy := &k8sv1.ContainedBy{}
+		rels, err := topology.RelationshipPair(nodeRef, ref, contains.ProtoReflect().Type(), containedBy.ProtoReflect().Type())
+		if err != nil {
+			return err
+		}
+		if err := i.store.AddRelationships(rels...); err != nil {
+			return fmt.Errorf("failed to add image relationships to inventory: %w", err)
+		}
+	}
+
+	i.indexed[img.ID] = encoded
+	return nil
+}
+
+// imageRef returns the reference of the image with id on this node. Images are named
+// <node>/<image ID> since the same image is present on many nodes.
+func (i *imageIndexer) imageRef(id string) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl: imageResourceType,
+		Name:    i.nodeName + "/" + id,
+		Namespace: &resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{
+					Cluster: i.clusterName,
+				},
+			},
+		},
+	}
+}
+
+func imageSpec(img cri.Image, created time.Time) (*structpb.Struct, error) {
+	fields := map[string]any{
+		"id":          img.ID,
+		"repoTags":    stringsToAny(img.RepoTags),
+		"repoDigests": stringsToAny(img.RepoDigests),
+		"sizeBytes":   float64(img.Size),
+		"pinned":      img.Pinned,
+	}
+	if !created.IsZero() {
+		fields["created"] = created.UTC().Format(time.RFC3339)
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image spec: %w", err)
+	}
+	return spec, nil
+}
+
+func stringsToAny(s []string) []any {
+	a := make([]any, len(s))
+	for i, v := range s {
+		a[i] = v
+	}
+	return a
+}
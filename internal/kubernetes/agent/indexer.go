@@ -13,22 +13,24 @@ import (
 	"github.com/antimetal/agent/internal/kubernetes/cluster"
 	"github.com/antimetal/agent/pkg/errors"
 	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
 	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
 	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
-	gogoproto "github.com/gogo/protobuf/proto"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
 var (
-	kindResource     = string((&resourcev1.Resource{}).ProtoReflect().Descriptor().FullName())
-	kindRelationship = string((&resourcev1.Relationship{}).ProtoReflect().Descriptor().FullName())
+	kindResource     = typeurl.Name(&resourcev1.Resource{})
+	kindRelationship = typeurl.Name(&resourcev1.Relationship{})
 )
 
 type indexer struct {
 	clusterName string
-	provider    cluster.Provider
-	store       resource.Store
+	// region of the cluster, backfilled into resources without one
+	region   string
+	provider cluster.Provider
+	store    resource.Store
 }
 
 func (i *indexer) LoadClusterInfo(ctx context.Context, major string, minor string) error {
@@ -40,6 +42,9 @@ func (i *indexer) LoadClusterInfo(ctx context.Context, major string, minor strin
 	if err != nil {
 		return fmt.Errorf("failed to get region: %w", err)
 	}
+	if region == "" {
+		region = i.region
+	}
 	cluster := &k8sv1.Cluster{
 		MajorVersion: major,
 		MinorVersion: minor,
@@ -50,11 +55,12 @@ func (i *indexer) LoadClusterInfo(ctx context.Context, major string, minor strin
 		return fmt.Errorf("failed to marshal cluster: %w", err)
 	}
 	i.clusterName = clusterName
+	i.region = region
 
 	return i.store.AddResource(&resourcev1.Resource{
 		Type: &resourcev1.TypeDescriptor{
 			Kind: kindResource,
-			Type: string(cluster.ProtoReflect().Descriptor().FullName()),
+			Type: typeurl.Name(cluster),
 		},
 		Metadata: &resourcev1.ResourceMeta{
 			Provider:   resourcev1.Provider_PROVIDER_KUBERNETES,
@@ -69,9 +75,10 @@ func (i *indexer) LoadClusterInfo(ctx context.Context, major string, minor strin
 func (i *indexer) Add(ctx context.Context, obj object) error {
 	rsrc, rels, err := i.generate(obj)
 	if err != nil {
+		countGenerationFailure(obj, err)
 		return fmt.Errorf("failed to generate resource and relationships: %w", err)
 	}
-	if err := i.store.AddResource(rsrc); err != nil {
+	if err := i.store.AddResource(rsrc, resource.WithParent(ctx)); err != nil {
 		return fmt.Errorf("failed to add resource to inventory: %w", err)
 	}
 	if err := i.store.AddRelationships(rels...); err != nil {
@@ -80,14 +87,21 @@ func (i *indexer) Add(ctx context.Context, obj object) error {
 	return nil
 }
 
-func (i *indexer) Update(ctx context.Context, obj object) error {
+func (i *indexer) Update(ctx context.Context, obj object, opts ...resource.WriteOption) error {
 	rsrc, rels, err := i.generate(obj)
 	if err != nil {
+		countGenerationFailure(obj, err)
 		return fmt.Errorf("failed to generate resource: %w", err)
 	}
-	if err := i.store.UpdateResource(rsrc); err != nil {
+	opts = append([]resource.WriteOption{resource.WithParent(ctx)}, opts...)
+	if err := i.store.UpdateResource(rsrc, opts...); err != nil {
 		return fmt.Errorf("failed to update resource to inventory: %w", err)
 	}
+	if hasTopology(obj) {
+		if err := replaceTopology(i.store, resourceRef(rsrc), rels); err != nil {
+			return err
+		}
+	}
 
 	relsToAdd := make([]*resourcev1.Relationship, 0)
 	for _, rel := range rels {
@@ -113,9 +127,30 @@ func (i *indexer) Update(ctx context.Context, obj object) error {
 	return nil
 }
 
+// DeleteType deletes the stored resources of the type of obj in the cluster
+func (i *indexer) DeleteType(obj object) error {
+	rsrcs, err := i.store.ListResources(&resourcev1.TypeDescriptor{
+		Kind: kindResource,
+		Type: typeurl.Name(obj),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s resources: %w", typeurl.Name(obj), err)
+	}
+	for _, rsrc := range rsrcs {
+		if rsrc.GetMetadata().GetNamespace().GetKube().GetCluster() != i.clusterName {
+			continue
+		}
+		err := i.store.DeleteResource(resourceRef(rsrc))
+		if err != nil && !errors.Is(err, resource.ErrResourceNotFound) {
+			return fmt.Errorf("failed to delete resource from inventory: %w", err)
+		}
+	}
+	return nil
+}
+
 func (i *indexer) Delete(ctx context.Context, obj object) error {
 	ref := &resourcev1.ResourceRef{
-		TypeUrl: gogoproto.MessageName(obj),
+		TypeUrl: typeurl.Name(obj),
 		Name:    obj.GetName(),
 		Namespace: &resourcev1.Namespace{
 			Namespace: &resourcev1.Namespace_Kube{
@@ -126,7 +161,7 @@ func (i *indexer) Delete(ctx context.Context, obj object) error {
 			},
 		},
 	}
-	return i.store.DeleteResource(ref)
+	return i.store.DeleteResource(ref, resource.WithParent(ctx))
 }
 
 func getProvider(prov cluster.Provider) k8sv1.ClusterProvider {
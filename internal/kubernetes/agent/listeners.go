@@ -0,0 +1,191 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/performance/process"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	listenerInventoryName = "listener-inventory"
+
+	// listenerResourceType is the resource type of the node's listening sockets. There is
+	// no generated message for it, its spec is a google.protobuf.Struct.
+	listenerResourceType = "antimetal.agent.network.v1.Listener"
+
+	defaultListenerInventoryInterval = time.Minute
+)
+
+// ListenerInventory periodically indexes the listening TCP sockets and unconnected UDP
+// sockets of the node the agent runs on, giving an inventory of the services the node
+// exposes:
+//
+//	Node -> Contains -> Listener
+//
+// Listeners are read from the socket inodes in the host's /proc/[pid]/fd joined with the
+// socket tables of the network namespace of each process, so the sockets of pods are
+// included and hostNetwork tells them apart from those exposed on the node's addresses.
+//
+// Listeners are named <node>/<pid>/<protocol>/<address>:<port>. Their specs are
+// google.protobuf.Structs with protocol, address, port, pid, command and hostNetwork.
+type ListenerInventory struct {
+	Provider cluster.Provider
+	Store    resource.Store
+	NodeName string
+	// HostProcPath is where the host's /proc is mounted. Defaults to /proc.
+	HostProcPath string
+	// Interval is how often the listeners are read. Defaults to 1 minute.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the ListenerInventory to the provided manager
+func (l *ListenerInventory) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if l.Store == nil {
+		return fmt.Errorf("ListenerInventory must be configured with a non-nil Store")
+	}
+	if l.NodeName == "" {
+		return fmt.Errorf("ListenerInventory must be configured with a NodeName")
+	}
+	procPath := l.HostProcPath
+	if procPath == "" {
+		procPath = "/proc"
+	}
+	interval := l.Interval
+	if interval <= 0 {
+		interval = defaultListenerInventoryInterval
+	}
+
+	logger := mgr.GetLogger().WithName(listenerInventoryName)
+
+	return mgr.Add(&listenerIndexer{
+		provider: l.Provider,
+		nodeName: l.NodeName,
+		procPath: procPath,
+		interval: interval,
+		logger:   logger,
+		indexer:  topology.NewIndexer(l.Store, resourcev1.Provider_PROVIDER_KUBERNETES, "listener", logger),
+	})
+}
+
+type listenerIndexer struct {
+	provider    cluster.Provider
+	nodeName    string
+	clusterName string
+	procPath    string
+	interval    time.Duration
+	logger      logr.Logger
+	indexer     *topology.Indexer
+}
+
+func (l *listenerIndexer) Start(ctx context.Context) error {
+	clusterName, err := l.provider.ClusterName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	l.clusterName = clusterName
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		if err := l.sync(); err != nil {
+			l.logger.Error(err, "failed to index listeners")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// listeners are only indexed into the store shipped by the leader.
+func (l *listenerIndexer) NeedLeaderElection() bool {
+	return true
+}
+
+func (l *listenerIndexer) sync() error {
+	listeners, err := process.ReadListeners(l.procPath)
+	if err != nil {
+		return err
+	}
+	l.indexer.Sync(l.resources(listeners))
+	return nil
+}
+
+// resources returns the listeners as resources contained by the node. A socket bound to
+// both the IPv4 and IPv6 addresses of a port is two listeners.
+func (l *listenerIndexer) resources(listeners []process.Listener) []topology.Resource {
+	nodeRef := &resourcev1.ResourceRef{
+		TypeUrl:   typeurl.Name(&corev1.Node{}),
+		Name:      l.nodeName,
+		Namespace: l.namespace(),
+	}
+	contains := (&k8sv1.Contains{}).ProtoReflect().Type()
+	containedBy := (&k8sv1.ContainedBy{}).ProtoReflect().Type()
+
+	rsrcs := make([]topology.Resource, 0, len(listeners))
+	seen := make(map[string]bool, len(listeners))
+	for _, listener := range listeners {
+		name := l.nodeName + "/" + strconv.Itoa(int(listener.PID)) + "/" + listener.Protocol + "/" +
+			net.JoinHostPort(listener.Address, strconv.Itoa(int(listener.Port)))
+		// SO_REUSEPORT lets a process bind several sockets to the same port
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		ref := &resourcev1.ResourceRef{
+			TypeUrl:   listenerResourceType,
+			Name:      name,
+			Namespace: l.namespace(),
+		}
+		rsrcs = append(rsrcs, topology.Resource{
+			Ref: ref,
+			Spec: map[string]any{
+				"protocol":    listener.Protocol,
+				"address":     listener.Address,
+				"port":        float64(listener.Port),
+				"pid":         float64(listener.PID),
+				"command":     listener.Command,
+				"hostNetwork": listener.HostNetwork,
+			},
+			Rels: []topology.Relationship{
+				{Subject: nodeRef, Object: ref, Predicate: contains, Inverse: containedBy},
+			},
+		})
+	}
+	return rsrcs
+}
+
+func (l *listenerIndexer) namespace() *resourcev1.Namespace {
+	return &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{
+				Cluster: l.clusterName,
+			},
+		},
+	}
+}
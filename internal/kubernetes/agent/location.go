@@ -0,0 +1,105 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// volumeZoneKeys are the node affinity keys volumes are pinned to a zone with: the
+// well-known topology labels and the topology keys of the cloud CSI drivers
+var volumeZoneKeys = map[string]bool{
+	corev1.LabelTopologyZone:           true,
+	corev1.LabelFailureDomainBetaZone:  true,
+	"topology.ebs.csi.aws.com/zone":    true,
+	"topology.gke.io/zone":             true,
+	"topology.disk.csi.azure.com/zone": true,
+}
+
+// labelLocation returns the region and zone of the topology labels of a node or volume,
+// falling back to the deprecated failure-domain labels still set by older volume plugins
+func labelLocation(labels map[string]string) (region, zone string) {
+	region = labels[corev1.LabelTopologyRegion]
+	if region == "" {
+		region = labels[corev1.LabelFailureDomainBetaRegion]
+	}
+	zone = labels[corev1.LabelTopologyZone]
+	if zone == "" {
+		zone = labels[corev1.LabelFailureDomainBetaZone]
+	}
+	return region, zone
+}
+
+// backfillLocation sets the region and zone of rsrc, generated from obj, that its
+// generator couldn't find in topology labels. Consumers of the inventory rely on them for
+// cost and locality analysis.
+//
+// Zones are backfilled from where volumes are pinned: persistent volumes from their node
+// affinity, claims from the volume they are bound to. The region of resources without
+// one is the region of the cluster.
+func (i *indexer) backfillLocation(rsrc *resourcev1.Resource, obj object) {
+	meta := rsrc.GetMetadata()
+	if meta.GetZone() == "" {
+		switch obj := obj.(type) {
+		case *corev1.PersistentVolume:
+			meta.Zone = volumeAffinityZone(obj)
+		case *corev1.PersistentVolumeClaim:
+			meta.Zone = i.boundVolumeZone(obj)
+		}
+	}
+	if meta.GetRegion() == "" {
+		meta.Region = i.region
+	}
+}
+
+// volumeAffinityZone returns the zone a persistent volume is pinned to by its node
+// affinity, empty if it isn't pinned to exactly one zone
+func volumeAffinityZone(pv *corev1.PersistentVolume) string {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return ""
+	}
+	zone := ""
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if !volumeZoneKeys[expr.Key] || expr.Operator != corev1.NodeSelectorOpIn {
+				continue
+			}
+			for _, value := range expr.Values {
+				if zone != "" && value != zone {
+					return ""
+				}
+				zone = value
+			}
+		}
+	}
+	return zone
+}
+
+// boundVolumeZone returns the zone of the persistent volume pvc is bound to, empty if it
+// isn't bound or the volume isn't indexed yet
+func (i *indexer) boundVolumeZone(pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.VolumeName == "" {
+		return ""
+	}
+	pv, err := i.store.GetResource(&resourcev1.ResourceRef{
+		TypeUrl: typeurl.Name(&corev1.PersistentVolume{}),
+		Name:    pvc.Spec.VolumeName,
+		Namespace: &resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{
+					Cluster: i.clusterName,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return ""
+	}
+	return pv.GetMetadata().GetZone()
+}
@@ -0,0 +1,97 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/antimetal/agent/pkg/errors"
+)
+
+// The depth, adds, latency and retries of the informer and retry queues of the Controller
+// are exported by controller-runtime as the workqueue_* metrics named k8s-agent and
+// k8s-agent-retry.
+var (
+	indexedObjectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "antimetal_k8s_indexer_indexed_objects_total",
+		Help: "Number of Kubernetes objects written to the resource store, by kind and event: add, update, resync or delete.",
+	}, []string{"kind", "event"})
+	generationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "antimetal_k8s_indexer_generation_failures_total",
+		Help: "Number of Kubernetes objects that could not be converted to resources and relationships, by kind.",
+	}, []string{"kind"})
+	retryableErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "antimetal_k8s_indexer_retryable_errors_total",
+		Help: "Number of Kubernetes objects that failed to index with a retryable error, e.g. a Pod whose Node isn't indexed yet, by kind.",
+	}, []string{"kind"})
+	droppedEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "antimetal_k8s_indexer_dropped_events_total",
+		Help: "Number of Kubernetes events dropped after failing to index, by kind and reason: error, retries_exhausted or retry_queue_full.",
+	}, []string{"kind", "reason"})
+	eventLagSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "antimetal_k8s_indexer_event_lag_seconds",
+		Help:    "Time from receiving a Kubernetes event from the API server to writing it to the resource store, retries included, by event.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"event"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		indexedObjectsTotal,
+		generationFailuresTotal,
+		retryableErrorsTotal,
+		droppedEventsTotal,
+		eventLagSeconds,
+	)
+}
+
+// objectKind returns the kind of obj for metric labels
+func objectKind(obj object) string {
+	return obj.GetObjectKind().GroupVersionKind().Kind
+}
+
+// receivedTimes holds when the events waiting to be indexed were received from the
+// informers. Events are the keys of the queues, which deduplicate them, so the time isn't
+// part of the event.
+type receivedTimes struct {
+	mu    sync.Mutex
+	times map[event]time.Time
+}
+
+func newReceivedTimes() *receivedTimes {
+	return &receivedTimes{times: make(map[event]time.Time)}
+}
+
+// add records that ev was received at t, unless it is already waiting
+func (r *receivedTimes) add(ev event, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.times[ev]; !ok {
+		r.times[ev] = t
+	}
+}
+
+// remove forgets ev and returns when it was received
+func (r *receivedTimes) remove(ev event) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.times[ev]
+	delete(r.times, ev)
+	return t, ok
+}
+
+// countGenerationFailure counts the failure to generate the resource of obj with err.
+// Retryable errors, such as a missing Node, are counted when they are retried instead.
+func countGenerationFailure(obj object, err error) {
+	if !errors.Retryable(err) {
+		generationFailuresTotal.WithLabelValues(objectKind(obj)).Inc()
+	}
+}
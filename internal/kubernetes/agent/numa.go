@@ -0,0 +1,262 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/antimetal/agent/pkg/performance/hotplug"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	numaInventoryName = "numa-topology"
+
+	// Resource types of the node's NUMA topology. There are no generated messages for
+	// them, their specs are google.protobuf.Structs.
+	numaNodeResourceType  = "antimetal.agent.hardware.v1.NUMANode"
+	pciDeviceResourceType = "antimetal.agent.hardware.v1.PCIDevice"
+
+	defaultNUMAInventoryInterval = 10 * time.Minute
+)
+
+// NUMAInventory periodically indexes the NUMA topology of the node the agent runs on, the
+// hints the kubelet's Topology Manager aligns the CPUs and devices of pods with, so that
+// schedulers and capacity tools can reason about NUMA-affine placement:
+//
+//	Node -> Contains -> NUMANode -> Contains -> PCIDevice -> Contains -> Disk
+//	Node -> Contains -> PCIDevice
+//
+// A PCI device is contained by the NUMA node it is attached to, or by the node if it has
+// no NUMA affinity. A device contains the disks of the storage topology it holds, if
+// they are indexed. The topology is read by the topology collector, see
+// collectors.TopologyCollector.
+//
+// NUMA nodes are named <node>/numa<id> and PCI devices <node>/<address>. Their specs are
+// google.protobuf.Structs with:
+//   - NUMANode: id, cpus, memoryBytes and distances
+//   - PCIDevice: address, class, vendor, device, driver, numaNode, localCpus, interfaces
+//     and blockDevices
+type NUMAInventory struct {
+	Provider cluster.Provider
+	Store    resource.Store
+	NodeName string
+	// HostSysPath is where the host's /sys is mounted. Defaults to /sys.
+	HostSysPath string
+	// Interval is how often the topology is read. Defaults to 10 minutes.
+	Interval time.Duration
+	// Hotplug, if set, has the topology read as soon as CPUs or memory are brought online
+	// or taken offline rather than at the next interval
+	Hotplug *hotplug.Watcher
+}
+
+// SetupWithManager registers the NUMAInventory to the provided manager
+func (n *NUMAInventory) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if n.Store == nil {
+		return fmt.Errorf("NUMAInventory must be configured with a non-nil Store")
+	}
+	if n.NodeName == "" {
+		return fmt.Errorf("NUMAInventory must be configured with a NodeName")
+	}
+	sysPath := n.HostSysPath
+	if sysPath == "" {
+		sysPath = "/sys"
+	}
+	interval := n.Interval
+	if interval <= 0 {
+		interval = defaultNUMAInventoryInterval
+	}
+	logger := mgr.GetLogger().WithName(numaInventoryName)
+	collector, err := collectors.NewTopologyCollector(logger, performance.CollectionConfig{HostSysPath: sysPath})
+	if err != nil {
+		return fmt.Errorf("failed to create topology collector: %w", err)
+	}
+	var changes <-chan hotplug.Change
+	if n.Hotplug != nil {
+		changes = n.Hotplug.Subscribe()
+	}
+
+	return mgr.Add(&numaIndexer{
+		provider:  n.Provider,
+		store:     n.Store,
+		nodeName:  n.NodeName,
+		collector: collector,
+		interval:  interval,
+		hotplug:   changes,
+		logger:    logger,
+		indexer:   topology.NewIndexer(n.Store, resourcev1.Provider_PROVIDER_KUBERNETES, "NUMA topology resource", logger),
+	})
+}
+
+type numaIndexer struct {
+	provider    cluster.Provider
+	store       resource.Store
+	nodeName    string
+	clusterName string
+	collector   *collectors.TopologyCollector
+	interval    time.Duration
+	hotplug     <-chan hotplug.Change
+	logger      logr.Logger
+	indexer     *topology.Indexer
+}
+
+func (n *numaIndexer) Start(ctx context.Context) error {
+	clusterName, err := n.provider.ClusterName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	n.clusterName = clusterName
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		if err := n.sync(ctx); err != nil {
+			n.logger.Error(err, "failed to index NUMA topology")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case change := <-n.hotplug:
+			n.logger.V(1).Info("online hardware changed, indexing NUMA topology",
+				"onlinedCPUs", change.OnlinedCPUs(), "offlinedCPUs", change.OfflinedCPUs())
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// the topology is only indexed into the store shipped by the leader.
+func (n *numaIndexer) NeedLeaderElection() bool {
+	return true
+}
+
+func (n *numaIndexer) sync(ctx context.Context) error {
+	data, err := n.collector.Collect(ctx)
+	if err != nil {
+		return err
+	}
+	stats, ok := data.(*performance.TopologyStats)
+	if !ok {
+		return fmt.Errorf("unexpected topology data %T", data)
+	}
+	n.indexer.Sync(n.resources(stats))
+	return nil
+}
+
+// resources returns the NUMA nodes and PCI devices of stats as resources
+func (n *numaIndexer) resources(stats *performance.TopologyStats) []topology.Resource {
+	nodeRef := &resourcev1.ResourceRef{
+		TypeUrl:   typeurl.Name(&corev1.Node{}),
+		Name:      n.nodeName,
+		Namespace: n.namespace(),
+	}
+	contains := (&k8sv1.Contains{}).ProtoReflect().Type()
+	containedBy := (&k8sv1.ContainedBy{}).ProtoReflect().Type()
+
+	rsrcs := make([]topology.Resource, 0, len(stats.Nodes)+len(stats.Devices))
+	numaRefs := make(map[int]*resourcev1.ResourceRef, len(stats.Nodes))
+	for _, node := range stats.Nodes {
+		ref := n.ref(numaNodeResourceType, "numa"+strconv.Itoa(node.ID))
+		numaRefs[node.ID] = ref
+		rsrcs = append(rsrcs, topology.Resource{
+			Ref: ref,
+			Spec: map[string]any{
+				"id":          float64(node.ID),
+				"cpus":        intsToAny(node.CPUs),
+				"memoryBytes": float64(node.MemoryBytes),
+				"distances":   intsToAny(node.Distances),
+			},
+			Rels: []topology.Relationship{
+				{Subject: nodeRef, Object: ref, Predicate: contains, Inverse: containedBy},
+			},
+		})
+	}
+
+	for _, device := range stats.Devices {
+		ref := n.ref(pciDeviceResourceType, device.Address)
+		parent := nodeRef
+		if numaRef, ok := numaRefs[device.NUMANode]; ok {
+			parent = numaRef
+		}
+		rsrc := topology.Resource{
+			Ref: ref,
+			Spec: map[string]any{
+				"address":      device.Address,
+				"class":        device.Class,
+				"vendor":       device.Vendor,
+				"device":       device.Device,
+				"driver":       device.Driver,
+				"numaNode":     float64(device.NUMANode),
+				"localCpus":    intsToAny(device.LocalCPUs),
+				"interfaces":   stringsToAny(device.Interfaces),
+				"blockDevices": stringsToAny(device.BlockDevices),
+			},
+			Rels: []topology.Relationship{
+				{Subject: parent, Object: ref, Predicate: contains, Inverse: containedBy},
+			},
+		}
+		for _, name := range device.BlockDevices {
+			diskRef := n.ref(diskResourceType, name)
+			if _, err := n.store.GetResource(diskRef); err != nil {
+				if !errors.Is(err, resource.ErrResourceNotFound) {
+					n.logger.Error(err, "failed to get disk", "name", diskRef.GetName())
+				}
+				continue
+			}
+			rsrc.Rels = append(rsrc.Rels, topology.Relationship{
+				Subject: ref, Object: diskRef, Predicate: contains, Inverse: containedBy,
+			})
+		}
+		rsrcs = append(rsrcs, rsrc)
+	}
+	return rsrcs
+}
+
+// ref returns the reference of the resource name of type typ on this node
+func (n *numaIndexer) ref(typ, name string) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl:   typ,
+		Name:      n.nodeName + "/" + name,
+		Namespace: n.namespace(),
+	}
+}
+
+func (n *numaIndexer) namespace() *resourcev1.Namespace {
+	return &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{
+				Cluster: n.clusterName,
+			},
+		},
+	}
+}
+
+func intsToAny(s []int) []any {
+	a := make([]any, len(s))
+	for i, v := range s {
+		a[i] = float64(v)
+	}
+	return a
+}
@@ -0,0 +1,86 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Traffic, storage and connection topology predicates.
+//
+// The apis module doesn't define predicates for traffic, storage or connection topology
+// yet, so they are described here and registered with the global protobuf registry on
+// init. Like the generated predicates they carry no fields, and being registered lets the
+// indexer resolve them with anypb.UnmarshalNew.
+var (
+	// selectsType relates a Service to the Pods matching its selector
+	selectsType protoreflect.MessageType
+	// selectedByType relates a Pod to the Services selecting it
+	selectedByType protoreflect.MessageType
+	// appliesToType relates a NetworkPolicy to the Pods matching its pod selector
+	appliesToType protoreflect.MessageType
+	// appliedByType relates a Pod to the NetworkPolicies applying to it
+	appliedByType protoreflect.MessageType
+	// hasPartitionType relates a disk to its partitions
+	hasPartitionType protoreflect.MessageType
+	// partitionOfType relates a partition to its disk
+	partitionOfType protoreflect.MessageType
+	// backsType relates a disk or partition to the filesystem stored on it, and a
+	// filesystem to the PersistentVolumes mounted from it
+	backsType protoreflect.MessageType
+	// backedByType is the inverse of backsType
+	backedByType protoreflect.MessageType
+	// connectsToType relates a process to the processes and remote endpoints it
+	// connected to
+	connectsToType protoreflect.MessageType
+	// connectedFromType is the inverse of connectsToType
+	connectedFromType protoreflect.MessageType
+)
+
+const topologyProtoPackage = "antimetal.agent.kubernetes.v1"
+
+func init() {
+	names := []string{
+		"Selects", "SelectedBy", "AppliesTo", "AppliedBy",
+		"HasPartition", "PartitionOf", "Backs", "BackedBy",
+		"ConnectsTo", "ConnectedFrom",
+	}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("antimetal/agent/kubernetes/v1/topology.proto"),
+		Package: proto.String(topologyProtoPackage),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, name := range names {
+		fdp.MessageType = append(fdp.MessageType, &descriptorpb.DescriptorProto{Name: proto.String(name)})
+	}
+
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("failed to build topology predicates: %v", err))
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(fmt.Sprintf("failed to register topology predicates: %v", err))
+	}
+
+	types := make([]protoreflect.MessageType, len(names))
+	for i, name := range names {
+		types[i] = dynamicpb.NewMessageType(fd.Messages().ByName(protoreflect.Name(name)))
+		if err := protoregistry.GlobalTypes.RegisterMessage(types[i]); err != nil {
+			panic(fmt.Sprintf("failed to register predicate %s: %v", name, err))
+		}
+	}
+	selectsType, selectedByType, appliesToType, appliedByType = types[0], types[1], types[2], types[3]
+	hasPartitionType, partitionOfType, backsType, backedByType = types[4], types[5], types[6], types[7]
+	connectsToType, connectedFromType = types[8], types[9]
+}
@@ -0,0 +1,124 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// Events failing with a retryable error are retried at most maxRetryAttempts times,
+	// backing off exponentially from retryBaseDelay to retryMaxDelay
+	maxRetryAttempts = 10
+	retryBaseDelay   = 500 * time.Millisecond
+	retryMaxDelay    = 2 * time.Minute
+
+	// Retries are limited to retryQPS overall, so that a burst of failures, e.g. all the
+	// Pods of a Node that isn't indexed yet, doesn't crowd out new events
+	retryQPS   = 10
+	retryBurst = 100
+
+	// At most maxPendingRetries events wait for a retry. Events failing while the retry
+	// queue is full are dropped rather than growing it without bound.
+	maxPendingRetries = 5000
+)
+
+// retryQueue holds the events that failed to index with a retryable error, most commonly
+// a Pod whose Node isn't in the store yet. They are retried apart from the informer events
+// with their own backoff, and dead-lettered once they run out of attempts.
+type retryQueue struct {
+	queue       workqueue.TypedRateLimitingInterface[event]
+	maxAttempts int
+	maxPending  int
+	logger      logr.Logger
+
+	mu sync.Mutex
+	// pending is the number of events accepted for a retry that weren't done with yet. The
+	// queue's Len doesn't count the events waiting out their backoff.
+	pending int
+}
+
+func newRetryQueue(logger logr.Logger) *retryQueue {
+	ratelimiter := workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[event](retryBaseDelay, retryMaxDelay),
+		&workqueue.TypedBucketRateLimiter[event]{Limiter: rate.NewLimiter(rate.Limit(retryQPS), retryBurst)},
+	)
+	queue := workqueue.NewTypedRateLimitingQueueWithConfig(ratelimiter,
+		workqueue.TypedRateLimitingQueueConfig[event]{
+			Name: controllerName + "-retry",
+		},
+	)
+	return &retryQueue{
+		queue:       queue,
+		maxAttempts: maxRetryAttempts,
+		maxPending:  maxPendingRetries,
+		logger:      logger,
+	}
+}
+
+// Retry schedules ev to be indexed again after it failed with err. It returns false if ev
+// was dead-lettered instead because it ran out of attempts or the queue is full.
+func (r *retryQueue) Retry(ev event, err error) bool {
+	attempts := r.queue.NumRequeues(ev)
+	if attempts >= r.maxAttempts {
+		r.deadLetter(ev, err, "retry attempts exhausted", "retries_exhausted", attempts)
+		return false
+	}
+	// Events already waiting keep their place, only new ones are subject to the limit
+	if attempts == 0 {
+		r.mu.Lock()
+		full := r.pending >= r.maxPending
+		if !full {
+			r.pending++
+		}
+		r.mu.Unlock()
+		if full {
+			r.deadLetter(ev, err, "retry queue is full", "retry_queue_full", attempts)
+			return false
+		}
+	}
+	r.queue.AddRateLimited(ev)
+	return true
+}
+
+// Get blocks until an event is due for a retry. Done must be called once it has been
+// processed.
+func (r *retryQueue) Get() (event, bool) {
+	return r.queue.Get()
+}
+
+// Done marks ev as processed. Unless it was scheduled for another retry, its attempts are
+// cleared and it no longer counts towards the pending retries.
+func (r *retryQueue) Done(ev event, retrying bool) {
+	if !retrying {
+		r.queue.Forget(ev)
+		r.mu.Lock()
+		r.pending--
+		r.mu.Unlock()
+	}
+	r.queue.Done(ev)
+}
+
+func (r *retryQueue) ShutDown() {
+	r.queue.ShutDown()
+}
+
+// deadLetter drops ev. reason is logged and metricReason labels the dropped events.
+func (r *retryQueue) deadLetter(ev event, err error, reason, metricReason string, attempts int) {
+	r.queue.Forget(ev)
+	droppedEventsTotal.WithLabelValues(objectKind(ev.obj), metricReason).Inc()
+	r.logger.Error(err, "dropping event that failed to index", "reason", reason,
+		"attempts", attempts, "event", eventStr(ev.typ),
+		"kind", ev.obj.GetObjectKind().GroupVersionKind().Kind,
+		"namespace", ev.obj.GetNamespace(), "name", ev.obj.GetName(),
+	)
+}
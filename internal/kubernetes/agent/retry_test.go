@@ -0,0 +1,113 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// newTestRetryQueue returns a retryQueue retrying after a millisecond
+func newTestRetryQueue(t *testing.T, maxAttempts, maxPending int) *retryQueue {
+	queue := workqueue.NewTypedRateLimitingQueue(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[event](time.Millisecond, time.Millisecond),
+	)
+	t.Cleanup(queue.ShutDown)
+	return &retryQueue{
+		queue:       queue,
+		maxAttempts: maxAttempts,
+		maxPending:  maxPending,
+		logger:      logr.Discard(),
+	}
+}
+
+func testPodEvent(name string) event {
+	return event{typ: EventAdd, obj: &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+	}}
+}
+
+func TestRetryQueue_MaxPending(t *testing.T) {
+	r := newTestRetryQueue(t, maxRetryAttempts, 2)
+	errNotIndexed := errors.New("node not indexed")
+	dropped := droppedEventsTotal.WithLabelValues("Pod", "retry_queue_full")
+	droppedBefore := testutil.ToFloat64(dropped)
+
+	first, second, third := testPodEvent("a"), testPodEvent("b"), testPodEvent("c")
+	if !r.Retry(first, errNotIndexed) || !r.Retry(second, errNotIndexed) {
+		t.Fatal("Retry() = false below the limit")
+	}
+	// Both events are still waiting out their backoff, so they aren't in the queue yet
+	if r.Retry(third, errNotIndexed) {
+		t.Fatal("Retry() = true with a full retry queue")
+	}
+	if got := testutil.ToFloat64(dropped) - droppedBefore; got != 1 {
+		t.Errorf("dropped %v events, want 1", got)
+	}
+
+	// Events already waiting are retried even when the queue is full
+	ev, _ := r.Get()
+	if !r.Retry(ev, errNotIndexed) {
+		t.Fatal("Retry() = false for an event being retried")
+	}
+	r.Done(ev, true)
+
+	// An event done with frees its place
+	ev, _ = r.Get()
+	r.Done(ev, false)
+	if !r.Retry(third, errNotIndexed) {
+		t.Fatal("Retry() = false after an event was done with")
+	}
+	if r.pending != 2 {
+		t.Errorf("pending = %d, want 2", r.pending)
+	}
+}
+
+func TestRetryQueue_MaxAttempts(t *testing.T) {
+	r := newTestRetryQueue(t, 3, maxPendingRetries)
+	errNotIndexed := errors.New("node not indexed")
+	dropped := droppedEventsTotal.WithLabelValues("Pod", "retries_exhausted")
+	droppedBefore := testutil.ToFloat64(dropped)
+
+	ev := testPodEvent("a")
+	if !r.Retry(ev, errNotIndexed) {
+		t.Fatal("Retry() = false for a new event")
+	}
+	for attempt := 1; ; attempt++ {
+		got, _ := r.Get()
+		retrying := r.Retry(got, errNotIndexed)
+		r.Done(got, retrying)
+		if !retrying {
+			if attempt != 3 {
+				t.Errorf("dead-lettered after %d attempts, want 3", attempt)
+			}
+			break
+		}
+	}
+	if got := testutil.ToFloat64(dropped) - droppedBefore; got != 1 {
+		t.Errorf("dropped %v events, want 1", got)
+	}
+	if r.pending != 0 {
+		t.Errorf("pending = %d, want 0", r.pending)
+	}
+
+	// Its attempts were cleared, so the same event can be retried again
+	if n := r.queue.NumRequeues(ev); n != 0 {
+		t.Errorf("NumRequeues() = %d, want 0", n)
+	}
+	if !r.Retry(ev, errNotIndexed) {
+		t.Error("Retry() = false for a dead-lettered event failing again")
+	}
+}
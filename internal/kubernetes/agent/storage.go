@@ -0,0 +1,329 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	gogoproto "github.com/gogo/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+const (
+	storageInventoryName = "storage-topology"
+
+	// Resource types of the node's storage. There are no generated messages for them,
+	// their specs are google.protobuf.Structs.
+	diskResourceType       = "antimetal.agent.storage.v1.Disk"
+	partitionResourceType  = "antimetal.agent.storage.v1.Partition"
+	filesystemResourceType = "antimetal.agent.storage.v1.Filesystem"
+
+	defaultStorageInventoryInterval = 5 * time.Minute
+)
+
+// StorageInventory periodically indexes the storage of the node the agent runs on so
+// that a PersistentVolume can be traced down to the device holding its data:
+//
+//	Node -> Contains -> Disk -> HasPartition -> Partition -> Backs -> Filesystem -> Backs -> PersistentVolume
+//	Node -> Contains -> Disk -> Backs -> PersistentVolume
+//
+// Disks and partitions are read from the host's /sys, filesystems from the mount table
+// of the host's init process. Only filesystems stored on block devices are indexed. A
+// filesystem on a whole disk is backed by the disk, one on a device mapper or md device
+// by the devices beneath it. A filesystem backs the PersistentVolumes the kubelet mounted
+// from it. A disk that is an EBS volume or GCE persistent disk backs the PersistentVolume
+// provisioned from that volume, including block mode volumes without a filesystem.
+//
+// Resources are named <node>/<kernel device name>, e.g. node-1/nvme0n1p1. Their specs
+// are google.protobuf.Structs with:
+//   - Disk: device, majorMinor, sizeBytes, model, serial, rotational and removable, and
+//     the cloudProvider and volumeId of cloud volumes
+//   - Partition: device, disk, number, majorMinor and sizeBytes
+//   - Filesystem: device, majorMinor, fsType, uuid, label and mountPoints
+//
+// Disks and partitions holding a filesystem also have its fsType, uuid and label, read
+// from its superblock in the host's /dev or the udev /dev/disk/by-uuid and by-label
+// symlinks, whether it is mounted or not.
+type StorageInventory struct {
+	Provider cluster.Provider
+	Store    resource.Store
+	NodeName string
+	// HostProcPath, HostSysPath and HostDevPath are where the host's /proc, /sys and /dev
+	// are mounted. Default to /proc, /sys and /dev.
+	HostProcPath string
+	HostSysPath  string
+	HostDevPath  string
+	// Interval is how often the storage is read. Defaults to 5 minutes.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the StorageInventory to the provided manager
+func (s *StorageInventory) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if s.Store == nil {
+		return fmt.Errorf("StorageInventory must be configured with a non-nil Store")
+	}
+	if s.NodeName == "" {
+		return fmt.Errorf("StorageInventory must be configured with a NodeName")
+	}
+	procPath := s.HostProcPath
+	if procPath == "" {
+		procPath = "/proc"
+	}
+	sysPath := s.HostSysPath
+	if sysPath == "" {
+		sysPath = "/sys"
+	}
+	devPath := s.HostDevPath
+	if devPath == "" {
+		devPath = "/dev"
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultStorageInventoryInterval
+	}
+
+	logger := mgr.GetLogger().WithName(storageInventoryName)
+
+	return mgr.Add(&storageIndexer{
+		provider: s.Provider,
+		store:    s.Store,
+		nodeName: s.NodeName,
+		procPath: procPath,
+		sysPath:  sysPath,
+		devPath:  devPath,
+		interval: interval,
+		logger:   logger,
+		indexer:  topology.NewIndexer(s.Store, resourcev1.Provider_PROVIDER_KUBERNETES, "storage resource", logger),
+	})
+}
+
+type storageIndexer struct {
+	provider    cluster.Provider
+	store       resource.Store
+	nodeName    string
+	clusterName string
+	procPath    string
+	sysPath     string
+	devPath     string
+	interval    time.Duration
+	logger      logr.Logger
+	indexer     *topology.Indexer
+}
+
+func (s *storageIndexer) Start(ctx context.Context) error {
+	clusterName, err := s.provider.ClusterName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	s.clusterName = clusterName
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.sync(); err != nil {
+			s.logger.Error(err, "failed to index storage")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// storage is only indexed into the store shipped by the leader.
+func (s *storageIndexer) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *storageIndexer) sync() error {
+	storage, err := readStorageTopology(s.sysPath, s.procPath, s.devPath)
+	if err != nil {
+		return err
+	}
+	s.indexer.Sync(s.resources(storage))
+	return nil
+}
+
+// resources returns the resources of storage with their relationships, every resource
+// after the resources it relates to
+func (s *storageIndexer) resources(storage *storageTopology) []topology.Resource {
+	nodeRef := &resourcev1.ResourceRef{
+		TypeUrl:   typeurl.Name(&corev1.Node{}),
+		Name:      s.nodeName,
+		Namespace: s.namespace(),
+	}
+	contains := (&k8sv1.Contains{}).ProtoReflect().Type()
+	containedBy := (&k8sv1.ContainedBy{}).ProtoReflect().Type()
+
+	var cloudPVs map[cloudVolume]*resourcev1.ResourceRef
+	if slices.ContainsFunc(storage.Disks, func(d disk) bool { return d.Cloud.ID != "" }) {
+		cloudPVs = s.cloudPersistentVolumes()
+	}
+
+	var rsrcs []topology.Resource
+	// Disks and partitions by kernel name, the devices filesystems can be backed by
+	devices := make(map[string]*resourcev1.ResourceRef)
+	for _, d := range storage.Disks {
+		diskRef := s.ref(diskResourceType, d.Name)
+		devices[d.Name] = diskRef
+		rsrc := topology.Resource{
+			Ref: diskRef,
+			Spec: withFSIdentity(map[string]any{
+				"device":     d.Name,
+				"majorMinor": d.Dev,
+				"sizeBytes":  float64(d.SizeBytes),
+				"model":      d.Model,
+				"serial":     d.Serial,
+				"rotational": d.Rotational,
+				"removable":  d.Removable,
+			}, d.FS),
+			Rels: []topology.Relationship{
+				{Subject: nodeRef, Object: diskRef, Predicate: contains, Inverse: containedBy},
+			},
+		}
+		if d.Cloud.ID != "" {
+			rsrc.Spec["cloudProvider"] = d.Cloud.Provider
+			rsrc.Spec["volumeId"] = d.Cloud.ID
+			if pvRef, ok := cloudPVs[d.Cloud]; ok {
+				rsrc.Rels = append(rsrc.Rels, topology.Relationship{
+					Subject: diskRef, Object: pvRef, Predicate: backsType, Inverse: backedByType,
+				})
+			}
+		}
+		rsrcs = append(rsrcs, rsrc)
+
+		for _, p := range d.Partitions {
+			partRef := s.ref(partitionResourceType, p.Name)
+			devices[p.Name] = partRef
+			rsrcs = append(rsrcs, topology.Resource{
+				Ref: partRef,
+				Spec: withFSIdentity(map[string]any{
+					"device":     p.Name,
+					"disk":       d.Name,
+					"number":     float64(p.Number),
+					"majorMinor": p.Dev,
+					"sizeBytes":  float64(p.SizeBytes),
+				}, p.FS),
+				Rels: []topology.Relationship{
+					{Subject: diskRef, Object: partRef, Predicate: hasPartitionType, Inverse: partitionOfType},
+				},
+			})
+		}
+	}
+
+	for _, fs := range storage.Filesystems {
+		fsRef := s.ref(filesystemResourceType, fs.Device)
+		rsrc := topology.Resource{
+			Ref: fsRef,
+			Spec: map[string]any{
+				"device":      fs.Device,
+				"majorMinor":  fs.Dev,
+				"fsType":      fs.Type,
+				"uuid":        fs.UUID,
+				"label":       fs.Label,
+				"mountPoints": stringsToAny(fs.MountPoints),
+			},
+		}
+		for _, dev := range fs.BackingDevices {
+			if devRef, ok := devices[dev]; ok {
+				rsrc.Rels = append(rsrc.Rels, topology.Relationship{
+					Subject: devRef, Object: fsRef, Predicate: backsType, Inverse: backedByType,
+				})
+			}
+		}
+		for _, pv := range fs.PersistentVolumes {
+			pvRef := &resourcev1.ResourceRef{
+				TypeUrl:   typeurl.Name(&corev1.PersistentVolume{}),
+				Name:      pv,
+				Namespace: s.namespace(),
+			}
+			// The directory of a volume is named after its PersistentVolume, or after the
+			// pod volume for inline volumes which have none
+			if _, err := s.store.GetResource(pvRef); err != nil {
+				if !errors.Is(err, resource.ErrResourceNotFound) {
+					s.logger.Error(err, "failed to get persistent volume", "name", pv)
+				}
+				continue
+			}
+			rsrc.Rels = append(rsrc.Rels, topology.Relationship{
+				Subject: fsRef, Object: pvRef, Predicate: backsType, Inverse: backedByType,
+			})
+		}
+		rsrcs = append(rsrcs, rsrc)
+	}
+	return rsrcs
+}
+
+// cloudPersistentVolumes returns the stored PersistentVolumes provisioned from cloud
+// volumes by their volume
+func (s *storageIndexer) cloudPersistentVolumes() map[cloudVolume]*resourcev1.ResourceRef {
+	rsrcs, err := listNamespaced(s.store, &corev1.PersistentVolume{}, s.clusterName, "")
+	if err != nil {
+		s.logger.Error(err, "failed to list persistent volumes")
+		return nil
+	}
+	pvs := make(map[cloudVolume]*resourcev1.ResourceRef)
+	for _, rsrc := range rsrcs {
+		pv := &corev1.PersistentVolume{}
+		if err := gogoproto.Unmarshal(rsrc.GetSpec().GetValue(), pv); err != nil {
+			s.logger.Error(err, "failed to unmarshal persistent volume", "name", rsrc.GetMetadata().GetName())
+			continue
+		}
+		if vol, ok := persistentVolumeCloudVolume(pv); ok && vol.ID != "" {
+			pvs[vol] = resourceRef(rsrc)
+		}
+	}
+	return pvs
+}
+
+// ref returns the reference of the device name of type typ on this node. Devices are
+// named <node>/<device> since every node has e.g. an nvme0n1.
+func (s *storageIndexer) ref(typ, device string) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl:   typ,
+		Name:      s.nodeName + "/" + device,
+		Namespace: s.namespace(),
+	}
+}
+
+func (s *storageIndexer) namespace() *resourcev1.Namespace {
+	return &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{
+				Cluster: s.clusterName,
+			},
+		},
+	}
+}
+
+// withFSIdentity adds the fsType, uuid and label of the filesystem on a device to its spec
+func withFSIdentity(spec map[string]any, id fsIdentity) map[string]any {
+	for key, value := range map[string]string{"fsType": id.Type, "uuid": id.UUID, "label": id.Label} {
+		if value != "" {
+			spec[key] = value
+		}
+	}
+	return spec
+}
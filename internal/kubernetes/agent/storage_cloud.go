@@ -0,0 +1,96 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Cloud volume identification of disks, so that a disk can be matched with the cloud
+// volume attached to the instance and the PersistentVolume provisioned from it.
+//
+// EBS volumes are NVMe devices on Nitro instances whose serial is the volume ID without
+// its dash. GCE persistent disks are named after their device name, which is the disk
+// name unless set otherwise when attaching it, by the udev google-<name> symlinks in
+// /dev/disk/by-id or, for SCSI disks, the unit serial number VPD page.
+
+const (
+	cloudProviderAWS = "aws"
+	cloudProviderGCP = "gcp"
+
+	ebsModel       = "Amazon Elastic Block Store"
+	gcePDModel     = "PersistentDisk"
+	gcePDNVMeModel = "nvme_card-pd"
+
+	ebsCSIDriver   = "ebs.csi.aws.com"
+	gcePDCSIDriver = "pd.csi.storage.gke.io"
+)
+
+type cloudVolume struct {
+	Provider string
+	// ID is the EBS volume ID, or the device name of a GCE persistent disk
+	ID string
+}
+
+// readCloudVolume returns the cloud volume of the disk name whose sysfs directory is dir.
+// The zero cloudVolume is returned for other disks, like instance store or local SSDs.
+func readCloudVolume(dir, devPath, name, model, serial string) cloudVolume {
+	switch model {
+	case ebsModel:
+		if id, ok := strings.CutPrefix(serial, "vol"); ok && id != "" {
+			return cloudVolume{Provider: cloudProviderAWS, ID: "vol-" + strings.TrimPrefix(id, "-")}
+		}
+	case gcePDModel, gcePDNVMeModel:
+		for _, link := range udevLinks(filepath.Join(devPath, "disk", "by-id"), name) {
+			if id, ok := strings.CutPrefix(link, "google-"); ok {
+				return cloudVolume{Provider: cloudProviderGCP, ID: id}
+			}
+		}
+		if id := readVPDSerial(filepath.Join(dir, "device", "vpd_pg80")); id != "" {
+			return cloudVolume{Provider: cloudProviderGCP, ID: id}
+		}
+	}
+	return cloudVolume{}
+}
+
+// readVPDSerial returns the product serial number of the unit serial number VPD page
+// (0x80) of a SCSI device: a 4 byte header with the length of the serial that follows
+func readVPDSerial(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < 4 || data[1] != 0x80 {
+		return ""
+	}
+	n := int(data[2])<<8 | int(data[3])
+	if 4+n > len(data) {
+		return ""
+	}
+	return strings.TrimSpace(string(data[4 : 4+n]))
+}
+
+// persistentVolumeCloudVolume returns the cloud volume pv was provisioned from, by the
+// EBS or GCE PD CSI drivers or the in-tree volume plugins they replace
+func persistentVolumeCloudVolume(pv *corev1.PersistentVolume) (cloudVolume, bool) {
+	src := pv.Spec.PersistentVolumeSource
+	switch {
+	case src.CSI != nil && src.CSI.Driver == ebsCSIDriver:
+		return cloudVolume{Provider: cloudProviderAWS, ID: src.CSI.VolumeHandle}, true
+	case src.CSI != nil && src.CSI.Driver == gcePDCSIDriver:
+		// projects/<project>/zones/<zone>/disks/<name>
+		return cloudVolume{Provider: cloudProviderGCP, ID: path.Base(src.CSI.VolumeHandle)}, true
+	case src.AWSElasticBlockStore != nil:
+		// vol-<id> or aws://<zone>/vol-<id>
+		return cloudVolume{Provider: cloudProviderAWS, ID: path.Base(src.AWSElasticBlockStore.VolumeID)}, true
+	case src.GCEPersistentDisk != nil:
+		return cloudVolume{Provider: cloudProviderGCP, ID: src.GCEPersistentDisk.PDName}, true
+	}
+	return cloudVolume{}, false
+}
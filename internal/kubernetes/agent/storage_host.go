@@ -0,0 +1,274 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Block devices and filesystems of the node, read from the host's /sys and /proc
+
+type blockDevice struct {
+	Name      string // Kernel name, e.g. nvme0n1 or nvme0n1p1
+	Dev       string // major:minor
+	SizeBytes uint64
+	// FS is the filesystem written directly on the device, if any
+	FS fsIdentity
+}
+
+type disk struct {
+	blockDevice
+	Model      string
+	Serial     string
+	Rotational bool
+	Removable  bool
+	// Cloud is the cloud volume attached as the disk, if any
+	Cloud      cloudVolume
+	Partitions []partition
+}
+
+type partition struct {
+	blockDevice
+	Number int
+}
+
+type filesystem struct {
+	// Device is the kernel name of the block device holding the filesystem, e.g.
+	// nvme1n1p1 or dm-0
+	Device      string
+	Dev         string // major:minor
+	Type        string
+	UUID        string
+	Label       string
+	MountPoints []string
+	// BackingDevices are the disks or partitions the filesystem is stored on: its device
+	// itself, or the devices beneath a device mapper or md device
+	BackingDevices []string
+	// PersistentVolumes are the names of the PersistentVolumes the kubelet mounted from
+	// the filesystem
+	PersistentVolumes []string
+}
+
+type storageTopology struct {
+	Disks       []disk
+	Filesystems []filesystem
+}
+
+// kubeletVolumeRe matches the directories the kubelet mounts pod volumes at,
+// /var/lib/kubelet/pods/<pod UID>/volumes/<plugin>/<volume>, or <volume>/mount for CSI
+var kubeletVolumeRe = regexp.MustCompile(`/pods/[^/]+/volumes/([^/]+)/([^/]+)(?:/mount)?$`)
+
+// Volume plugins whose volumes are never PersistentVolumes
+var ephemeralVolumePlugins = map[string]bool{
+	"kubernetes.io~empty-dir":    true,
+	"kubernetes.io~configmap":    true,
+	"kubernetes.io~secret":       true,
+	"kubernetes.io~projected":    true,
+	"kubernetes.io~downward-api": true,
+	"kubernetes.io~git-repo":     true,
+}
+
+// readStorageTopology reads the disks, their partitions and the filesystems stored on
+// them from the host's sysfs at sysPath, procfs at procPath and device nodes at devPath
+func readStorageTopology(sysPath, procPath, devPath string) (*storageTopology, error) {
+	disks, err := readDisks(sysPath, devPath)
+	if err != nil {
+		return nil, err
+	}
+	filesystems, err := readFilesystems(sysPath, procPath, devPath)
+	if err != nil {
+		return nil, err
+	}
+	return &storageTopology{Disks: disks, Filesystems: filesystems}, nil
+}
+
+// readDisks reads the whole disks in /sys/block with their partitions. Loop, ram, device
+// mapper and md devices are left out: they don't hold data of their own.
+func readDisks(sysPath, devPath string) ([]disk, error) {
+	blockDir := filepath.Join(sysPath, "block")
+	entries, err := os.ReadDir(blockDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", blockDir, err)
+	}
+
+	var disks []disk
+	for _, entry := range entries {
+		name := entry.Name()
+		if isVirtualBlockDevice(name) {
+			continue
+		}
+		dir := filepath.Join(blockDir, name)
+		d := disk{
+			blockDevice: readBlockDevice(dir, devPath, name),
+			Model:       readTrimmed(filepath.Join(dir, "device", "model")),
+			Serial:      readTrimmed(filepath.Join(dir, "device", "serial")),
+			Rotational:  readTrimmed(filepath.Join(dir, "queue", "rotational")) == "1",
+			Removable:   readTrimmed(filepath.Join(dir, "removable")) == "1",
+		}
+		d.Cloud = readCloudVolume(dir, devPath, name, d.Model, d.Serial)
+
+		// Partitions are subdirectories with a partition file
+		subdirs, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		for _, sub := range subdirs {
+			partDir := filepath.Join(dir, sub.Name())
+			number, err := strconv.Atoi(readTrimmed(filepath.Join(partDir, "partition")))
+			if err != nil {
+				continue
+			}
+			d.Partitions = append(d.Partitions, partition{
+				blockDevice: readBlockDevice(partDir, devPath, sub.Name()),
+				Number:      number,
+			})
+		}
+		slices.SortFunc(d.Partitions, func(a, b partition) int { return a.Number - b.Number })
+		disks = append(disks, d)
+	}
+	return disks, nil
+}
+
+func isVirtualBlockDevice(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "zram", "dm-", "md", "nbd"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func readBlockDevice(dir, devPath, name string) blockDevice {
+	// The size is in 512 byte sectors regardless of the device's sector size
+	sectors, _ := strconv.ParseUint(readTrimmed(filepath.Join(dir, "size")), 10, 64)
+	return blockDevice{
+		Name:      name,
+		Dev:       readTrimmed(filepath.Join(dir, "dev")),
+		SizeBytes: sectors * 512,
+		FS:        readFSIdentity(devPath, name),
+	}
+}
+
+// readFilesystems reads the mounted filesystems stored on block devices from the mount
+// table of the host's init process
+func readFilesystems(sysPath, procPath, devPath string) ([]filesystem, error) {
+	path := filepath.Join(procPath, "1", "mountinfo")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer f.Close()
+
+	byDev := make(map[string]*filesystem)
+	var order []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 259:1 / /var/lib/kubelet rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw
+		fields := strings.Fields(scanner.Text())
+		sep := slices.Index(fields, "-")
+		if sep < 6 || sep+1 >= len(fields) {
+			continue
+		}
+		dev, mountPoint, fsType := fields[2], unescapeMountPath(fields[4]), fields[sep+1]
+		if strings.HasPrefix(dev, "0:") {
+			// Filesystems without a block device, e.g. tmpfs, overlay or proc
+			continue
+		}
+
+		fs, ok := byDev[dev]
+		if !ok {
+			device, backing := resolveBlockDevice(sysPath, dev)
+			if device == "" {
+				continue
+			}
+			id := readFSIdentity(devPath, device)
+			fs = &filesystem{
+				Device:         device,
+				Dev:            dev,
+				Type:           fsType,
+				UUID:           id.UUID,
+				Label:          id.Label,
+				BackingDevices: backing,
+			}
+			byDev[dev] = fs
+			order = append(order, dev)
+		}
+		if !slices.Contains(fs.MountPoints, mountPoint) {
+			fs.MountPoints = append(fs.MountPoints, mountPoint)
+		}
+		if m := kubeletVolumeRe.FindStringSubmatch(mountPoint); m != nil && !ephemeralVolumePlugins[m[1]] {
+			if !slices.Contains(fs.PersistentVolumes, m[2]) {
+				fs.PersistentVolumes = append(fs.PersistentVolumes, m[2])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+
+	filesystems := make([]filesystem, 0, len(order))
+	for _, dev := range order {
+		filesystems = append(filesystems, *byDev[dev])
+	}
+	return filesystems, nil
+}
+
+// resolveBlockDevice returns the kernel name of the block device dev (major:minor) and
+// the disks or partitions it is stored on. A device mapper or md device is stored on the
+// devices in its slaves directory, any other device on itself.
+func resolveBlockDevice(sysPath, dev string) (string, []string) {
+	target, err := os.Readlink(filepath.Join(sysPath, "dev", "block", dev))
+	if err != nil {
+		return "", nil
+	}
+	name := filepath.Base(target)
+
+	slaves, err := os.ReadDir(filepath.Join(sysPath, "class", "block", name, "slaves"))
+	if err != nil || len(slaves) == 0 {
+		return name, []string{name}
+	}
+	backing := make([]string, 0, len(slaves))
+	for _, slave := range slaves {
+		backing = append(backing, slave.Name())
+	}
+	return name, backing
+}
+
+// unescapeMountPath decodes the octal escapes of spaces, tabs, newlines and backslashes
+// in mountinfo paths
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
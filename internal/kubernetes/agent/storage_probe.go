@@ -0,0 +1,231 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Filesystem identification of block devices, so that devices can be matched with the
+// UUID= and LABEL= entries of fstab and mount configuration.
+//
+// The superblock of the device is probed for the common filesystems, like blkid does.
+// Devices that can't be read or hold another filesystem fall back to the symlinks udev
+// creates in /dev/disk/by-uuid and /dev/disk/by-label, which carry no type.
+
+type fsIdentity struct {
+	Type  string
+	UUID  string
+	Label string
+}
+
+// Superblock layouts, from the kernel's and util-linux's definitions
+const (
+	extSuperblockOffset = 1024
+	extMagicOffset      = 56
+	extUUIDOffset       = 104
+	extLabelOffset      = 120
+	extMagic            = 0xef53
+	// ext3 has a journal, ext4 uses any feature ext3 doesn't support
+	extCompatHasJournal    = 0x0004
+	extIncompatExt3Support = 0x0002 | 0x0004 | 0x0010 // filetype, recover, meta_bg
+	extROCompatExt3Support = 0x0001 | 0x0002 | 0x0004 // sparse_super, large_file, btree_dir
+
+	xfsUUIDOffset  = 32
+	xfsLabelOffset = 108
+	xfsLabelLen    = 12
+
+	btrfsSuperblockOffset = 64 << 10
+	btrfsUUIDOffset       = 0x20
+	btrfsMagicOffset      = 0x40
+	btrfsLabelOffset      = 0x12b
+	btrfsLabelLen         = 256
+
+	swapUUIDOffset  = 1024 + 12
+	swapLabelOffset = 1024 + 28
+	swapLabelLen    = 16
+
+	// The last of the superblocks probed ends with the btrfs label
+	probeSize = btrfsSuperblockOffset + btrfsLabelOffset + btrfsLabelLen
+)
+
+// readFSIdentity returns the filesystem of the block device name, probing its device
+// node in devPath and falling back to the udev symlinks in devPath/disk
+func readFSIdentity(devPath, name string) fsIdentity {
+	id, err := probeDevice(filepath.Join(devPath, name))
+	if err == nil && id.Type != "" {
+		return id
+	}
+	return fsIdentity{
+		UUID:  udevLink(filepath.Join(devPath, "disk", "by-uuid"), name),
+		Label: udevLink(filepath.Join(devPath, "disk", "by-label"), name),
+	}
+}
+
+func probeDevice(path string) (fsIdentity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fsIdentity{}, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, probeSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return fsIdentity{}, fmt.Errorf("failed to read superblock of %s: %w", path, err)
+	}
+	return probeSuperblock(buf[:n]), nil
+}
+
+// probeSuperblock identifies the filesystem whose first bytes are buf. The zero
+// fsIdentity is returned for unknown filesystems.
+func probeSuperblock(buf []byte) fsIdentity {
+	le := binary.LittleEndian
+	at := func(off, n int) []byte {
+		if off+n > len(buf) {
+			return nil
+		}
+		return buf[off : off+n]
+	}
+
+	if sb := at(extSuperblockOffset, 1024); sb != nil && le.Uint16(sb[extMagicOffset:]) == extMagic {
+		fsType := "ext2"
+		compat, incompat, roCompat := le.Uint32(sb[92:]), le.Uint32(sb[96:]), le.Uint32(sb[100:])
+		switch {
+		case incompat&^extIncompatExt3Support != 0 || roCompat&^extROCompatExt3Support != 0:
+			fsType = "ext4"
+		case compat&extCompatHasJournal != 0:
+			fsType = "ext3"
+		}
+		return fsIdentity{
+			Type:  fsType,
+			UUID:  formatUUID(sb[extUUIDOffset : extUUIDOffset+16]),
+			Label: cString(sb[extLabelOffset : extLabelOffset+16]),
+		}
+	}
+
+	if sb := at(0, 512); sb != nil && string(sb[:4]) == "XFSB" {
+		return fsIdentity{
+			Type:  "xfs",
+			UUID:  formatUUID(sb[xfsUUIDOffset : xfsUUIDOffset+16]),
+			Label: cString(sb[xfsLabelOffset : xfsLabelOffset+xfsLabelLen]),
+		}
+	}
+
+	if sb := at(btrfsSuperblockOffset, btrfsLabelOffset+btrfsLabelLen); sb != nil &&
+		string(sb[btrfsMagicOffset:btrfsMagicOffset+8]) == "_BHRfS_M" {
+		return fsIdentity{
+			Type:  "btrfs",
+			UUID:  formatUUID(sb[btrfsUUIDOffset : btrfsUUIDOffset+16]),
+			Label: cString(sb[btrfsLabelOffset : btrfsLabelOffset+btrfsLabelLen]),
+		}
+	}
+
+	// The swap signature ends the first page, whose size depends on the architecture
+	for _, pageSize := range []int{4096, 16384, 65536} {
+		if sig := at(pageSize-10, 10); sig != nil && (string(sig) == "SWAPSPACE2" || string(sig) == "SWAP-SPACE") {
+			return fsIdentity{
+				Type:  "swap",
+				UUID:  formatUUID(buf[swapUUIDOffset : swapUUIDOffset+16]),
+				Label: cString(buf[swapLabelOffset : swapLabelOffset+swapLabelLen]),
+			}
+		}
+	}
+
+	// FAT has no magic number, but a boot sector signature and a type string
+	if bs := at(0, 512); bs != nil && bs[510] == 0x55 && bs[511] == 0xaa {
+		// FAT32 keeps its volume ID and label after the larger BPB of FAT32
+		if strings.HasPrefix(string(bs[0x52:0x5a]), "FAT32") {
+			return fsIdentity{Type: "vfat", UUID: formatFATID(bs[0x43:0x47]), Label: fatLabel(bs[0x47:0x52])}
+		}
+		if strings.HasPrefix(string(bs[0x36:0x3e]), "FAT1") {
+			return fsIdentity{Type: "vfat", UUID: formatFATID(bs[0x27:0x2b]), Label: fatLabel(bs[0x2b:0x36])}
+		}
+	}
+	return fsIdentity{}
+}
+
+// formatUUID formats a 16 byte UUID in its canonical form, or returns "" if it is all zeros
+func formatUUID(b []byte) string {
+	if bytes.Count(b, []byte{0}) == len(b) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// formatFATID formats the 32 bit volume ID of a FAT filesystem as blkid does, e.g. 1A2B-3C4D
+func formatFATID(b []byte) string {
+	return fmt.Sprintf("%02X%02X-%02X%02X", b[3], b[2], b[1], b[0])
+}
+
+// fatLabel returns the label of a FAT boot sector, which is space padded and NO NAME if unset
+func fatLabel(b []byte) string {
+	label := strings.TrimRight(string(b), " \x00")
+	if label == "NO NAME" {
+		return ""
+	}
+	return label
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// udevLink returns the name of the symlink in dir that points to the device name, e.g.
+// the UUID in /dev/disk/by-uuid, or "" if there is none
+func udevLink(dir, name string) string {
+	if links := udevLinks(dir, name); len(links) > 0 {
+		return links[0]
+	}
+	return ""
+}
+
+// udevLinks returns the names of the symlinks in dir that point to the device name, like
+// the links of the different ID types in /dev/disk/by-id. udev escapes characters like
+// spaces and slashes in labels as \xNN.
+func udevLinks(dir, name string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var links []string
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err == nil && filepath.Base(target) == name {
+			links = append(links, unescapeUdev(entry.Name()))
+		}
+	}
+	return links
+}
+
+func unescapeUdev(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,180 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+var testUUID = []byte{
+	0x0b, 0x9a, 0x3c, 0x5e, 0x1f, 0x2d, 0x4e, 0x6a, 0x8b, 0x7c, 0x0d, 0x1e, 0x2f, 0x3a, 0x4b, 0x5c,
+}
+
+const testUUIDString = "0b9a3c5e-1f2d-4e6a-8b7c-0d1e2f3a4b5c"
+
+// extSuperblock returns the first bytes of an ext filesystem with the feature flags
+func extSuperblock(compat, incompat, roCompat uint32) []byte {
+	buf := make([]byte, 2048)
+	sb := buf[extSuperblockOffset:]
+	binary.LittleEndian.PutUint16(sb[extMagicOffset:], extMagic)
+	binary.LittleEndian.PutUint32(sb[92:], compat)
+	binary.LittleEndian.PutUint32(sb[96:], incompat)
+	binary.LittleEndian.PutUint32(sb[100:], roCompat)
+	copy(sb[extUUIDOffset:], testUUID)
+	copy(sb[extLabelOffset:], "root")
+	return buf
+}
+
+func xfsSuperblock() []byte {
+	buf := make([]byte, 512)
+	copy(buf, "XFSB")
+	copy(buf[xfsUUIDOffset:], testUUID)
+	copy(buf[xfsLabelOffset:], "data")
+	return buf
+}
+
+func btrfsSuperblock() []byte {
+	buf := make([]byte, probeSize)
+	sb := buf[btrfsSuperblockOffset:]
+	copy(sb[btrfsMagicOffset:], "_BHRfS_M")
+	copy(sb[btrfsUUIDOffset:], testUUID)
+	copy(sb[btrfsLabelOffset:], "pool")
+	return buf
+}
+
+func swapHeader(pageSize int, signature string) []byte {
+	buf := make([]byte, pageSize)
+	copy(buf[swapUUIDOffset:], testUUID)
+	copy(buf[swapLabelOffset:], "swap0")
+	copy(buf[pageSize-10:], signature)
+	return buf
+}
+
+func fatBootSector(fat32 bool, label string) []byte {
+	buf := make([]byte, 512)
+	id, labelOff, typeOff, fsType := 0x27, 0x2b, 0x36, "FAT16   "
+	if fat32 {
+		id, labelOff, typeOff, fsType = 0x43, 0x47, 0x52, "FAT32   "
+	}
+	binary.LittleEndian.PutUint32(buf[id:], 0x1a2b3c4d)
+	copy(buf[labelOff:], label+"           "[len(label):])
+	copy(buf[typeOff:], fsType)
+	buf[510], buf[511] = 0x55, 0xaa
+	return buf
+}
+
+func TestProbeSuperblock(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want fsIdentity
+	}{
+		{
+			name: "ext2",
+			buf:  extSuperblock(0, 0x0002, 0x0001),
+			want: fsIdentity{Type: "ext2", UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext3",
+			buf:  extSuperblock(extCompatHasJournal, 0x0002, 0x0001),
+			want: fsIdentity{Type: "ext3", UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext4 with extents",
+			buf:  extSuperblock(extCompatHasJournal, 0x0002|0x0040, 0x0001),
+			want: fsIdentity{Type: "ext4", UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext4 with huge files",
+			buf:  extSuperblock(extCompatHasJournal, 0x0002, 0x0001|0x0008),
+			want: fsIdentity{Type: "ext4", UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "xfs",
+			buf:  xfsSuperblock(),
+			want: fsIdentity{Type: "xfs", UUID: testUUIDString, Label: "data"},
+		},
+		{
+			name: "btrfs",
+			buf:  btrfsSuperblock(),
+			want: fsIdentity{Type: "btrfs", UUID: testUUIDString, Label: "pool"},
+		},
+		{
+			name: "swap",
+			buf:  swapHeader(4096, "SWAPSPACE2"),
+			want: fsIdentity{Type: "swap", UUID: testUUIDString, Label: "swap0"},
+		},
+		{
+			name: "swap with 64K pages",
+			buf:  swapHeader(65536, "SWAPSPACE2"),
+			want: fsIdentity{Type: "swap", UUID: testUUIDString, Label: "swap0"},
+		},
+		{
+			name: "old swap signature",
+			buf:  swapHeader(4096, "SWAP-SPACE"),
+			want: fsIdentity{Type: "swap", UUID: testUUIDString, Label: "swap0"},
+		},
+		{
+			name: "vfat FAT32",
+			buf:  fatBootSector(true, "EFI"),
+			want: fsIdentity{Type: "vfat", UUID: "1A2B-3C4D", Label: "EFI"},
+		},
+		{
+			name: "vfat FAT16 without label",
+			buf:  fatBootSector(false, "NO NAME"),
+			want: fsIdentity{Type: "vfat", UUID: "1A2B-3C4D"},
+		},
+		{
+			name: "ext without UUID",
+			buf: func() []byte {
+				buf := extSuperblock(0, 0, 0)
+				clear(buf[extSuperblockOffset+extUUIDOffset : extSuperblockOffset+extUUIDOffset+16])
+				return buf
+			}(),
+			want: fsIdentity{Type: "ext2", Label: "root"},
+		},
+		{
+			name: "unknown filesystem",
+			buf:  make([]byte, probeSize),
+		},
+		{
+			name: "boot sector without FAT type",
+			buf: func() []byte {
+				buf := make([]byte, 512)
+				buf[510], buf[511] = 0x55, 0xaa
+				return buf
+			}(),
+		},
+		{
+			name: "truncated ext superblock",
+			buf:  extSuperblock(0, 0, 0)[:extSuperblockOffset+extMagicOffset+2],
+		},
+		{
+			name: "truncated btrfs superblock",
+			buf:  btrfsSuperblock()[:btrfsSuperblockOffset+btrfsLabelOffset],
+		},
+		{
+			name: "truncated swap header",
+			buf:  swapHeader(4096, "SWAPSPACE2")[:4095],
+		},
+		{
+			name: "short buffer",
+			buf:  []byte("XFS"),
+		},
+		{
+			name: "empty buffer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probeSuperblock(tt.buf); got != tt.want {
+				t.Errorf("probeSuperblock() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"fmt"
+
+	"github.com/antimetal/agent/internal/topology"
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Traffic topology relationships are resolved from both ends since either side can be
+// indexed first: a Service or NetworkPolicy is related to the stored Pods it selects, and
+// a Pod to the stored Services and NetworkPolicies selecting it. Relationships are keyed
+// by content in the store, so generating the same one from both ends is harmless. On an
+// update, the stored relationships that weren't generated again, e.g. those of a Pod
+// relabeled since, are deleted by replaceTopology.
+
+// genServiceTopology returns the Selects relationships between svc and the stored Pods
+// matching its selector. A Service without a selector selects no Pods.
+func genServiceTopology(store resource.Store, clusterName string, svc *corev1.Service, svcRef *resourcev1.ResourceRef,
+) ([]*resourcev1.Relationship, error) {
+	if len(svc.Spec.Selector) == 0 {
+		return nil, nil
+	}
+	selector := labels.SelectorFromSet(svc.Spec.Selector)
+
+	pods, err := listNamespaced(store, &corev1.Pod{}, clusterName, svc.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	var rels []*resourcev1.Relationship
+	for _, pod := range pods {
+		if !selector.Matches(tagsToLabels(pod.GetMetadata().GetTags())) {
+			continue
+		}
+		pair, err := topology.RelationshipPair(svcRef, resourceRef(pod), selectsType, selectedByType)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, pair...)
+	}
+	return rels, nil
+}
+
+// genNetworkPolicyTopology returns the AppliesTo relationships between policy and the
+// stored Pods matching its pod selector. An empty pod selector selects all Pods in the
+// policy's namespace.
+func genNetworkPolicyTopology(store resource.Store, clusterName string, policy *networkingv1.NetworkPolicy,
+	policyRef *resourcev1.ResourceRef,
+) ([]*resourcev1.Relationship, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod selector: %w", err)
+	}
+
+	pods, err := listNamespaced(store, &corev1.Pod{}, clusterName, policy.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	var rels []*resourcev1.Relationship
+	for _, pod := range pods {
+		if !selector.Matches(tagsToLabels(pod.GetMetadata().GetTags())) {
+			continue
+		}
+		pair, err := topology.RelationshipPair(policyRef, resourceRef(pod), appliesToType, appliedByType)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, pair...)
+	}
+	return rels, nil
+}
+
+// genPodTopology returns the relationships between pod and the stored Services and
+// NetworkPolicies selecting it.
+func genPodTopology(store resource.Store, clusterName string, pod *corev1.Pod, podRef *resourcev1.ResourceRef,
+) ([]*resourcev1.Relationship, error) {
+	podLabels := labels.Set(pod.GetLabels())
+	var rels []*resourcev1.Relationship
+
+	svcs, err := listNamespaced(store, &corev1.Service{}, clusterName, pod.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	for _, rsrc := range svcs {
+		svc := &corev1.Service{}
+		if err := gogoproto.Unmarshal(rsrc.GetSpec().GetValue(), svc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal service %s: %w", rsrc.GetMetadata().GetName(), err)
+		}
+		if len(svc.Spec.Selector) == 0 || !labels.SelectorFromSet(svc.Spec.Selector).Matches(podLabels) {
+			continue
+		}
+		pair, err := topology.RelationshipPair(resourceRef(rsrc), podRef, selectsType, selectedByType)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, pair...)
+	}
+
+	policies, err := listNamespaced(store, &networkingv1.NetworkPolicy{}, clusterName, pod.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	for _, rsrc := range policies {
+		policy := &networkingv1.NetworkPolicy{}
+		if err := gogoproto.Unmarshal(rsrc.GetSpec().GetValue(), policy); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal network policy %s: %w", rsrc.GetMetadata().GetName(), err)
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err != nil || !selector.Matches(podLabels) {
+			continue
+		}
+		pair, err := topology.RelationshipPair(resourceRef(rsrc), podRef, appliesToType, appliedByType)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, pair...)
+	}
+
+	return rels, nil
+}
+
+// hasTopology returns whether obj has traffic topology relationships
+func hasTopology(obj object) bool {
+	switch obj.(type) {
+	case *corev1.Pod, *corev1.Service, *networkingv1.NetworkPolicy:
+		return true
+	default:
+		return false
+	}
+}
+
+// replaceTopology deletes the stored traffic topology relationships of ref that aren't in
+// rels, the relationships generated for it. Failing to read or write the store is
+// retryable.
+func replaceTopology(store resource.Store, ref *resourcev1.ResourceRef, rels []*resourcev1.Relationship) error {
+	generated := make(map[string]bool, len(rels))
+	for _, rel := range rels {
+		generated[topologyKey(rel)] = true
+	}
+
+	var stale []*resourcev1.Relationship
+	for _, typ := range []protoreflect.MessageType{selectsType, selectedByType, appliesToType, appliedByType} {
+		predicate := typ.New().Interface()
+		for _, ends := range [][2]*resourcev1.ResourceRef{{ref, nil}, {nil, ref}} {
+			stored, err := store.GetRelationships(ends[0], ends[1], predicate)
+			if errors.Is(err, resource.ErrRelationshipsNotFound) {
+				continue
+			}
+			if err != nil {
+				err = fmt.Errorf("failed to get topology relationships: %w", err)
+				return errors.NewRetryable(err.Error())
+			}
+			for _, rel := range stored {
+				if !generated[topologyKey(rel)] {
+					stale = append(stale, rel)
+				}
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if err := store.DeleteRelationships(stale...); err != nil {
+		err = fmt.Errorf("failed to delete topology relationships: %w", err)
+		return errors.NewRetryable(err.Error())
+	}
+	return nil
+}
+
+// topologyKey identifies rel among the relationships of a resource
+func topologyKey(rel *resourcev1.Relationship) string {
+	return topology.Key(rel.GetSubject()) + " " + rel.GetPredicate().GetTypeUrl() + " " + topology.Key(rel.GetObject())
+}
+
+// listNamespaced returns the stored resources of typ in the given cluster namespace.
+// Failing to read the store is retryable.
+func listNamespaced(store resource.Store, typ object, clusterName, namespace string) ([]*resourcev1.Resource, error) {
+	rsrcs, err := store.ListNamespacedResources(
+		&resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: typeurl.Name(typ),
+		},
+		&resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{
+					Cluster:   clusterName,
+					Namespace: namespace,
+				},
+			},
+		},
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to list %s resources: %w", typeurl.Name(typ), err)
+		return nil, errors.NewRetryable(err.Error())
+	}
+	return rsrcs, nil
+}
+
+func resourceRef(rsrc *resourcev1.Resource) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{
+		TypeUrl:   rsrc.GetType().GetType(),
+		Name:      rsrc.GetMetadata().GetName(),
+		Namespace: rsrc.GetMetadata().GetNamespace(),
+	}
+}
+
+func tagsToLabels(tags []*resourcev1.Tag) labels.Set {
+	set := make(labels.Set, len(tags))
+	for _, tag := range tags {
+		set[tag.GetKey()] = tag.GetValue()
+	}
+	return set
+}
@@ -0,0 +1,95 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+func TestIndexer_RelabeledPodTopology(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+	i := &indexer{clusterName: "test-cluster", store: inv}
+	ctx := context.Background()
+
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1", Labels: map[string]string{"app": "web"}},
+	}
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+	}
+	if err := i.Add(ctx, pod); err != nil {
+		t.Fatalf("failed to add pod: %v", err)
+	}
+	if err := i.Add(ctx, svc); err != nil {
+		t.Fatalf("failed to add service: %v", err)
+	}
+
+	namespace := &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{Cluster: "test-cluster", Namespace: "default"},
+		},
+	}
+	svcRef := &resourcev1.ResourceRef{TypeUrl: typeurl.Name(svc), Name: "web", Namespace: namespace}
+	podRef := &resourcev1.ResourceRef{TypeUrl: typeurl.Name(pod), Name: "web-1", Namespace: namespace}
+	selects := func() int {
+		t.Helper()
+		selects, err := inv.GetRelationships(svcRef, podRef, selectsType.New().Interface())
+		if err != nil && !errors.Is(err, resource.ErrRelationshipsNotFound) {
+			t.Fatalf("failed to get relationships: %v", err)
+		}
+		selectedBy, err := inv.GetRelationships(podRef, svcRef, selectedByType.New().Interface())
+		if err != nil && !errors.Is(err, resource.ErrRelationshipsNotFound) {
+			t.Fatalf("failed to get relationships: %v", err)
+		}
+		return len(selects) + len(selectedBy)
+	}
+	if got := selects(); got != 2 {
+		t.Fatalf("expected the service to select the pod both ways, got %d relationships", got)
+	}
+
+	// The service no longer selects the relabeled pod
+	pod.Labels = map[string]string{"app": "api"}
+	if err := i.Update(ctx, pod); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+	if got := selects(); got != 0 {
+		t.Fatalf("expected the relationships of the relabeled pod to be deleted, got %d", got)
+	}
+
+	// Nor a pod it selected before its selector changed
+	pod.Labels = map[string]string{"app": "web"}
+	if err := i.Update(ctx, pod); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+	if got := selects(); got != 2 {
+		t.Fatalf("expected the service to select the pod again, got %d relationships", got)
+	}
+	svc.Spec.Selector = map[string]string{"app": "api"}
+	if err := i.Update(ctx, svc); err != nil {
+		t.Fatalf("failed to update service: %v", err)
+	}
+	if got := selects(); got != 0 {
+		t.Fatalf("expected the relationships of the service to be deleted, got %d", got)
+	}
+}
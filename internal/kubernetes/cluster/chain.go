@@ -0,0 +1,175 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NameSource is a source of the name of the cluster in a Chain
+type NameSource interface {
+	// Source names the source in logs and errors
+	Source() string
+	ClusterName(ctx context.Context) (string, error)
+}
+
+type staticName string
+
+// StaticName returns the source of a cluster name set explicitly, e.g. by a flag
+func StaticName(name string) NameSource {
+	return staticName(name)
+}
+
+func (s staticName) Source() string {
+	return "flag"
+}
+
+func (s staticName) ClusterName(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+type providerName struct {
+	provider Provider
+}
+
+// ProviderName returns the source of the cluster name of provider, e.g. from the API of
+// the cloud provider
+func ProviderName(provider Provider) NameSource {
+	return providerName{provider: provider}
+}
+
+func (s providerName) Source() string {
+	return "provider " + s.provider.Name()
+}
+
+func (s providerName) ClusterName(ctx context.Context) (string, error) {
+	return s.provider.ClusterName(ctx)
+}
+
+type namespaceUID struct {
+	client kubernetes.Interface
+}
+
+// NamespaceUIDName returns the source of a cluster name that is the UID of the kube-system
+// namespace. The name means nothing to users, but it is unique, the same on every node and
+// stable for the life of the cluster, so it still namespaces the resources of the cluster.
+func NamespaceUIDName(client kubernetes.Interface) NameSource {
+	return namespaceUID{client: client}
+}
+
+func (s namespaceUID) Source() string {
+	return "kube-system namespace UID"
+}
+
+func (s namespaceUID) ClusterName(ctx context.Context) (string, error) {
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return string(ns.UID), nil
+}
+
+type fileName string
+
+// FileName returns the source of a cluster name read from the file at path, e.g. a
+// ConfigMap mounted by the user. Surrounding whitespace is trimmed.
+func FileName(path string) NameSource {
+	return fileName(path)
+}
+
+func (s fileName) Source() string {
+	return "file " + string(s)
+}
+
+func (s fileName) ClusterName(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(s))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Chain is a Provider whose cluster name is resolved by the first of its sources that
+// resolves it, so that the failure of one source, e.g. EKS autodiscovery, doesn't break the
+// namespacing of every resource. The name is resolved once: once resolved, it never
+// changes, even if a source before it recovers. Failed resolutions are tried again on the
+// next call.
+//
+// The name of a Chain is that of its Provider. A region the Provider fails to determine
+// is unknown rather than an error, and is backfilled like any missing region.
+type Chain struct {
+	provider Provider
+	sources  []NameSource
+	logger   logr.Logger
+
+	mu          sync.Mutex
+	clusterName string
+}
+
+var _ Provider = &Chain{}
+
+// NewChain returns a Chain resolving the cluster name from sources in order
+func NewChain(provider Provider, logger logr.Logger, sources ...NameSource) *Chain {
+	return &Chain{
+		provider: provider,
+		sources:  sources,
+		logger:   logger,
+	}
+}
+
+func (c *Chain) Name() string {
+	return c.provider.Name()
+}
+
+func (c *Chain) ClusterName(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clusterName != "" {
+		return c.clusterName, nil
+	}
+
+	var errs []error
+	for _, source := range c.sources {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		name, err := source.ClusterName(ctx)
+		if err == nil && name == "" {
+			err = errors.New("empty cluster name")
+		}
+		if err != nil {
+			c.logger.Error(err, "unable to resolve cluster name", "source", source.Source())
+			errs = append(errs, fmt.Errorf("%s: %w", source.Source(), err))
+			continue
+		}
+		c.logger.Info("resolved cluster name", "source", source.Source(), "clusterName", name)
+		c.clusterName = name
+		return name, nil
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("failed to resolve cluster name: no sources")
+	}
+	return "", fmt.Errorf("failed to resolve cluster name: %w", errors.Join(errs...))
+}
+
+func (c *Chain) Region(ctx context.Context) (string, error) {
+	region, err := c.provider.Region(ctx)
+	if err != nil {
+		c.logger.Error(err, "unable to determine region of cluster", "provider", c.provider.Name())
+		return "", nil
+	}
+	return region, nil
+}
@@ -0,0 +1,143 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeProvider struct {
+	clusterName string
+	err         error
+	calls       int
+}
+
+func (p *fakeProvider) Name() string {
+	return ProviderEKS
+}
+
+func (p *fakeProvider) ClusterName(ctx context.Context) (string, error) {
+	p.calls++
+	return p.clusterName, p.err
+}
+
+func (p *fakeProvider) Region(ctx context.Context) (string, error) {
+	return "", p.err
+}
+
+func TestChain_ClusterName(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem, UID: "4b2f6c1e-uid"},
+	})
+	file := filepath.Join(t.TempDir(), "cluster-name")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	autodiscovery := errors.New("autodiscovery failed")
+
+	tests := []struct {
+		name     string
+		provider *fakeProvider
+		sources  func(p Provider) []NameSource
+		want     string
+	}{
+		{
+			name:     "flag first",
+			provider: &fakeProvider{clusterName: "eks-cluster"},
+			sources: func(p Provider) []NameSource {
+				return []NameSource{StaticName("from-flag"), ProviderName(p)}
+			},
+			want: "from-flag",
+		},
+		{
+			name:     "provider",
+			provider: &fakeProvider{clusterName: "eks-cluster"},
+			sources: func(p Provider) []NameSource {
+				return []NameSource{ProviderName(p), NamespaceUIDName(client)}
+			},
+			want: "eks-cluster",
+		},
+		{
+			name:     "namespace UID fallback",
+			provider: &fakeProvider{err: autodiscovery},
+			sources: func(p Provider) []NameSource {
+				return []NameSource{ProviderName(p), NamespaceUIDName(client), FileName(file)}
+			},
+			want: "4b2f6c1e-uid",
+		},
+		{
+			name:     "file fallback",
+			provider: &fakeProvider{err: autodiscovery},
+			sources: func(p Provider) []NameSource {
+				return []NameSource{ProviderName(p), NamespaceUIDName(fake.NewClientset()), FileName(file)}
+			},
+			want: "from-file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := NewChain(tt.provider, logr.Discard(), tt.sources(tt.provider)...)
+			for range 2 {
+				got, err := chain.ClusterName(ctx)
+				if err != nil {
+					t.Fatalf("ClusterName() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("ClusterName() = %q, want %q", got, tt.want)
+				}
+			}
+			if tt.provider.calls > 1 {
+				t.Errorf("expected the resolved name to be kept, provider called %d times", tt.provider.calls)
+			}
+			if chain.Name() != ProviderEKS {
+				t.Errorf("Name() = %q, want that of the provider", chain.Name())
+			}
+		})
+	}
+}
+
+func TestChain_Errors(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeProvider{err: errors.New("autodiscovery failed")}
+	empty := filepath.Join(t.TempDir(), "cluster-name")
+	if err := os.WriteFile(empty, []byte("\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	chain := NewChain(provider, logr.Discard(), ProviderName(provider), FileName(empty))
+
+	_, err := chain.ClusterName(ctx)
+	if err == nil {
+		t.Fatal("expected an error when no source resolves the cluster name")
+	}
+	for _, want := range []string{"provider eks: autodiscovery failed", "empty cluster name"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err, want)
+		}
+	}
+
+	// Failures are tried again
+	provider.err, provider.clusterName = nil, "eks-cluster"
+	if got, err := chain.ClusterName(ctx); err != nil || got != "eks-cluster" {
+		t.Errorf("ClusterName() = %q, %v after the provider recovered", got, err)
+	}
+
+	provider.err = errors.New("no instance metadata")
+	if region, err := chain.Region(ctx); err != nil || region != "" {
+		t.Errorf("Region() = %q, %v, want an unknown region", region, err)
+	}
+}
@@ -14,6 +14,8 @@ import (
 
 type EKS struct {
 	awsClient aws.Client
+	// err is why the AWS client couldn't be created, returned by every call
+	err error
 }
 
 var _ Provider = &EKS{}
@@ -23,9 +25,15 @@ func (p *EKS) Name() string {
 }
 
 func (p *EKS) ClusterName(ctx context.Context) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
 	return p.awsClient.GetEKSClusterName(ctx)
 }
 
 func (p *EKS) Region(ctx context.Context) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
 	return p.awsClient.GetRegion(ctx)
 }
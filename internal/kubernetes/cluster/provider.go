@@ -12,6 +12,8 @@ import (
 
 	"github.com/antimetal/agent/pkg/aws"
 	"github.com/go-logr/logr"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 const (
@@ -30,6 +32,20 @@ type Provider interface {
 type ProviderOptions struct {
 	Logger logr.Logger
 	EKS    EKSOptions
+
+	// ClusterName is the name of the cluster. If set, the provider isn't asked for it.
+	ClusterName string
+	// NamespaceUIDFallback names the cluster after the UID of its kube-system namespace if
+	// the provider can't name it
+	NamespaceUIDFallback bool
+	// ClusterNameFile is a file holding the name of the cluster, read if neither the
+	// provider nor the kube-system namespace can name it
+	ClusterNameFile string
+}
+
+// hasFallback returns whether the cluster name can be resolved without the provider
+func (o ProviderOptions) hasFallback() bool {
+	return o.ClusterName != "" || o.NamespaceUIDFallback || o.ClusterNameFile != ""
 }
 
 type EKSOptions struct {
@@ -39,12 +55,54 @@ type EKSOptions struct {
 	ClusterName  string
 }
 
+// GetProvider returns the provider named provider, wrapped in a Chain resolving the name
+// of the cluster from, in order: opts.ClusterName, the provider, the UID of the kube-system
+// namespace and opts.ClusterNameFile.
 func GetProvider(ctx context.Context, provider string, opts ProviderOptions) (Provider, error) {
+	p, err := getProvider(ctx, provider, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []NameSource
+	if opts.ClusterName != "" {
+		sources = append(sources, StaticName(opts.ClusterName))
+	}
+	sources = append(sources, ProviderName(p))
+	if opts.NamespaceUIDFallback {
+		client, err := newClientset()
+		if err != nil {
+			opts.Logger.Error(err, "unable to create Kubernetes client, cluster can't be named after its kube-system namespace")
+		} else {
+			sources = append(sources, NamespaceUIDName(client))
+		}
+	}
+	if opts.ClusterNameFile != "" {
+		sources = append(sources, FileName(opts.ClusterNameFile))
+	}
+	return NewChain(p, opts.Logger, sources...), nil
+}
+
+func newClientset() (kubernetes.Interface, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+func getProvider(ctx context.Context, provider string, opts ProviderOptions) (Provider, error) {
 	switch provider {
 	case ProviderEKS:
 		awsClient, err := aws.NewClient(constructAwsClientOpts(ctx, opts)...)
 		if err != nil {
-			return nil, fmt.Errorf("error creating EKS provider: failed to create AWS client: %w", err)
+			err = fmt.Errorf("error creating EKS provider: failed to create AWS client: %w", err)
+			if !opts.hasFallback() {
+				return nil, err
+			}
+			// The cluster name is resolved by the fallbacks of the chain
+			opts.Logger.Error(err, "EKS provider unavailable, falling back")
+			return &EKS{err: err}, nil
 		}
 		return &EKS{
 			awsClient: awsClient,
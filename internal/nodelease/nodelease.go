@@ -0,0 +1,301 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package nodelease detects silent nodes from the heartbeat Leases kubelets renew in the
+// kube-node-lease namespace. A kubelet renews its lease every quarter of the lease
+// duration, so a node that stopped renewing shows well before the node lifecycle
+// controller marks the node NotReady, and independently of whether the agent on that node
+// is still running. Together with the agent's Heartbeat, this tells the backend a dead
+// agent on a healthy node from a dead node.
+package nodelease
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch
+
+const (
+	monitorName = "node-lease-monitor"
+
+	// ResourceType is the resource type of node liveness resources. There is no generated
+	// message for it; its spec is a google.protobuf.Struct.
+	ResourceType = "antimetal.agent.v1.NodeLiveness"
+
+	// StateSilent is the state of a node that stopped renewing its lease
+	StateSilent = "silent"
+	// StateRenewing is the state of a node renewing its lease
+	StateRenewing = "renewing"
+
+	defaultInterval = 5 * time.Second
+
+	// defaultLeaseDuration is the lease duration of kubelets, used for leases without one
+	defaultLeaseDuration = 40 * time.Second
+)
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+// Monitor watches the node leases of the cluster and upserts a NodeLiveness resource
+// named after each node when the node goes silent or starts renewing again. Nodes going
+// silent are critical events so that the intake sends them ahead of everything else. The
+// resource of a node whose lease is deleted, e.g. because the node was removed, is
+// deleted.
+//
+// The spec of the resource is a google.protobuf.Struct with the nodeName, the state
+// (silent or renewing), the renewTime and holderIdentity of the lease, since when the
+// node is silent, if it is, and the timestamp of the state change.
+//
+// Staleness is measured with the agent's clock, from when it observed the last renewal,
+// rather than from the renewTime set with the node's clock, so clock skew between nodes
+// doesn't make nodes silent. A lease seen for the first time counts as just renewed.
+type Monitor struct {
+	// Reader lists the leases, e.g. the client of a controller-runtime Manager, which
+	// watches them through its cache
+	Reader client.Reader
+	Store  resource.Store
+	// Provider namespaces the resources to the cluster. Optional.
+	Provider cluster.Provider
+	// StaleAfter is how long a lease goes without renewal before its node is silent.
+	// Defaults to half the lease duration, two missed renewals of a kubelet.
+	StaleAfter time.Duration
+	// Interval is how often the leases are checked. Defaults to 5 seconds.
+	Interval time.Duration
+}
+
+// SetupWithManager registers the Monitor to the provided manager
+func (m *Monitor) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if m.Reader == nil {
+		m.Reader = mgr.GetClient()
+	}
+	runnable, err := m.runnable(mgr.GetLogger().WithName(monitorName))
+	if err != nil {
+		return err
+	}
+	return mgr.Add(runnable)
+}
+
+func (m *Monitor) runnable(logger logr.Logger) (*monitor, error) {
+	if m.Reader == nil {
+		return nil, fmt.Errorf("Monitor must be configured with a non-nil Reader")
+	}
+	if m.Store == nil {
+		return nil, fmt.Errorf("Monitor must be configured with a non-nil Store")
+	}
+	interval := m.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &monitor{
+		reader:     m.Reader,
+		store:      m.Store,
+		provider:   m.Provider,
+		staleAfter: m.StaleAfter,
+		interval:   interval,
+		logger:     logger,
+		now:        time.Now,
+		nodes:      make(map[string]*nodeState),
+	}, nil
+}
+
+type monitor struct {
+	reader     client.Reader
+	store      resource.Store
+	provider   cluster.Provider
+	staleAfter time.Duration
+	interval   time.Duration
+	logger     logr.Logger
+	now        func() time.Time
+
+	namespace *resourcev1.Namespace
+	// nodes is the last observed lease of every node
+	nodes map[string]*nodeState
+}
+
+// nodeState is what the monitor observed of the lease of a node
+type nodeState struct {
+	renewTime  time.Time // From the lease, with the node's clock
+	observedAt time.Time // When renewTime last changed, with the agent's clock
+	silent     bool
+	// written is whether the state was written to the store
+	written bool
+}
+
+func (m *monitor) Start(ctx context.Context) error {
+	if m.provider != nil {
+		clusterName, err := m.provider.ClusterName(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster name: %w", err)
+		}
+		m.namespace = &resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{
+					Cluster: clusterName,
+				},
+			},
+		}
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.check(ctx); err != nil {
+			m.logger.Error(err, "failed to check node leases")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// the leases of the cluster are only watched by the leader.
+func (m *monitor) NeedLeaderElection() bool {
+	return true
+}
+
+// check updates the state of every node from its lease, writing the nodes whose state
+// changed to the store
+func (m *monitor) check(ctx context.Context) error {
+	leases := &coordinationv1.LeaseList{}
+	if err := m.reader.List(ctx, leases, client.InNamespace(corev1.NamespaceNodeLease)); err != nil {
+		return fmt.Errorf("failed to list node leases: %w", err)
+	}
+
+	now := m.now()
+	seen := make(map[string]bool, len(leases.Items))
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		name := lease.Name
+		seen[name] = true
+
+		var renewTime time.Time
+		if lease.Spec.RenewTime != nil {
+			renewTime = lease.Spec.RenewTime.Time
+		}
+		state, ok := m.nodes[name]
+		if !ok {
+			state = &nodeState{renewTime: renewTime, observedAt: now}
+			m.nodes[name] = state
+		} else if !renewTime.Equal(state.renewTime) {
+			state.renewTime = renewTime
+			state.observedAt = now
+		}
+
+		silent := now.Sub(state.observedAt) > m.staleAfterFor(lease)
+		if state.written && silent == state.silent {
+			continue
+		}
+		// Nodes first seen renewing are bulk, every later change is worth sending
+		class := resource.EventClassNormal
+		switch {
+		case silent:
+			class = resource.EventClassCritical
+		case !state.written:
+			class = resource.EventClassBulk
+		}
+		if err := m.write(lease, state, silent, now, class); err != nil {
+			m.logger.Error(err, "failed to write node liveness", "node", name)
+			continue
+		}
+		if silent {
+			m.logger.Info("Node stopped renewing its lease", "node", name, "renewTime", renewTime)
+		} else if state.silent {
+			m.logger.Info("Node renews its lease again", "node", name, "renewTime", renewTime)
+		}
+		state.silent = silent
+		state.written = true
+	}
+
+	for name := range m.nodes {
+		if seen[name] {
+			continue
+		}
+		delete(m.nodes, name)
+		ref := &resourcev1.ResourceRef{TypeUrl: ResourceType, Name: name, Namespace: m.namespace}
+		if err := m.store.DeleteResource(ref); err != nil {
+			m.logger.V(1).Info("failed to delete node liveness", "node", name, "error", err.Error())
+		}
+	}
+	return nil
+}
+
+// staleAfterFor returns how long lease can go without renewal before its node is silent
+func (m *monitor) staleAfterFor(lease *coordinationv1.Lease) time.Duration {
+	if m.staleAfter > 0 {
+		return m.staleAfter
+	}
+	duration := defaultLeaseDuration
+	if seconds := lease.Spec.LeaseDurationSeconds; seconds != nil && *seconds > 0 {
+		duration = time.Duration(*seconds) * time.Second
+	}
+	return duration / 2
+}
+
+func (m *monitor) write(lease *coordinationv1.Lease, state *nodeState, silent bool, now time.Time, class resource.EventClass) error {
+	fields := map[string]any{
+		"nodeName":  lease.Name,
+		"state":     StateRenewing,
+		"timestamp": now.UTC().Format(time.RFC3339Nano),
+	}
+	if silent {
+		fields["state"] = StateSilent
+		fields["silentSince"] = state.observedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if !state.renewTime.IsZero() {
+		fields["renewTime"] = state.renewTime.UTC().Format(time.RFC3339Nano)
+	}
+	if lease.Spec.HolderIdentity != nil {
+		fields["holderIdentity"] = *lease.Spec.HolderIdentity
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return fmt.Errorf("failed to create node liveness spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node liveness spec: %w", err)
+	}
+
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: lease.Name,
+			Name:       lease.Name,
+			Namespace:  m.namespace,
+		},
+		Spec: specAny,
+	}
+	if m.provider != nil {
+		rsrc.Metadata.Provider = resourcev1.Provider_PROVIDER_KUBERNETES
+	}
+	if err := m.store.UpdateResource(rsrc, resource.WithEventClass(class)); err != nil {
+		return fmt.Errorf("failed to update node liveness in inventory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package nodelease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/structpb"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/antimetal/agent/internal/kubernetes/scheme"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+func newLease(node string, renewTime time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: node, Namespace: corev1.NamespaceNodeLease},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(node),
+			LeaseDurationSeconds: ptr.To[int32](40),
+			RenewTime:            &metav1.MicroTime{Time: renewTime},
+		},
+	}
+}
+
+func TestMonitor_Check(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+	events := inv.Subscribe(nil, resource.WithoutInitialList())
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Leader election leases of other namespaces are ignored
+	other := newLease("controller", start)
+	other.Namespace = "kube-system"
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme.Get()).
+		WithObjects(newLease("node-1", start), newLease("node-2", start), other).
+		Build()
+
+	m := &Monitor{Reader: k8sClient, Store: inv}
+	runnable, err := m.runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create monitor: %v", err)
+	}
+	now := start
+	runnable.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	check := func() {
+		t.Helper()
+		if err := runnable.check(ctx); err != nil {
+			t.Fatalf("failed to check leases: %v", err)
+		}
+	}
+	get := func(node string) map[string]any {
+		t.Helper()
+		rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: node})
+		if err != nil {
+			t.Fatalf("failed to get node liveness of %s: %v", node, err)
+		}
+		spec := &structpb.Struct{}
+		if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+			t.Fatalf("failed to unmarshal node liveness spec: %v", err)
+		}
+		return spec.AsMap()
+	}
+	nextEvent := func() resource.Event {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for an event")
+			return resource.Event{}
+		}
+	}
+	renew := func(node string) {
+		t.Helper()
+		lease := &coordinationv1.Lease{}
+		if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: corev1.NamespaceNodeLease, Name: node}, lease); err != nil {
+			t.Fatalf("failed to get lease: %v", err)
+		}
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+		if err := k8sClient.Update(ctx, lease); err != nil {
+			t.Fatalf("failed to renew lease: %v", err)
+		}
+	}
+
+	check()
+	for _, node := range []string{"node-1", "node-2"} {
+		if ev := nextEvent(); ev.Class != resource.EventClassBulk {
+			t.Errorf("first state of a renewing node should be bulk, got %v", ev.Class)
+		}
+		if spec := get(node); spec["state"] != StateRenewing || spec["holderIdentity"] != node {
+			t.Errorf("%s = %v, want renewing", node, spec)
+		}
+	}
+	if _, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "controller"}); err == nil {
+		t.Error("leases outside of kube-node-lease should be ignored")
+	}
+
+	// node-1 renews every 10s, node-2 stops renewing and is silent after 20s, half of
+	// its lease duration
+	for range 2 {
+		now = now.Add(10 * time.Second)
+		renew("node-1")
+		check()
+	}
+	if spec := get("node-2"); spec["state"] != StateRenewing {
+		t.Errorf("node-2 should still be renewing 20s after its last renewal, got %v", spec)
+	}
+	now = now.Add(time.Second)
+	check()
+	if ev := nextEvent(); ev.Class != resource.EventClassCritical {
+		t.Errorf("a silent node should be a critical event, got %v", ev.Class)
+	}
+	spec := get("node-2")
+	if spec["state"] != StateSilent || spec["silentSince"] != "2026-01-01T12:00:00Z" {
+		t.Errorf("node-2 = %v, want silent since 12:00:00", spec)
+	}
+	if spec := get("node-1"); spec["state"] != StateRenewing {
+		t.Errorf("node-1 = %v, want renewing", spec)
+	}
+
+	// node-2 comes back
+	now = now.Add(time.Minute)
+	renew("node-2")
+	check()
+	if ev := nextEvent(); ev.Class != resource.EventClassNormal {
+		t.Errorf("a node renewing again should be a normal event, got %v", ev.Class)
+	}
+	if spec := get("node-2"); spec["state"] != StateRenewing || spec["silentSince"] != nil {
+		t.Errorf("node-2 = %v, want renewing", spec)
+	}
+
+	// node-2 is removed from the cluster
+	if err := k8sClient.Delete(ctx, newLease("node-2", now)); err != nil {
+		t.Fatalf("failed to delete lease: %v", err)
+	}
+	check()
+	if _, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "node-2"}); err == nil {
+		t.Error("expected the node liveness of a deleted lease to be deleted")
+	}
+}
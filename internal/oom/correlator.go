@@ -0,0 +1,202 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package oom turns the kernel's OOM kill messages into container OOM events. The kernel
+// only logs the process and cgroup that were killed; the cgroup's memory.events counters
+// and the container runtime add which pod and container it was, how often the cgroup has
+// been OOM killed and whether the container was restarted.
+package oom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/antimetal/agent/internal/cri"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+)
+
+// ContainerRuntime lists the containers on the node, e.g. a *cri.Client
+type ContainerRuntime interface {
+	ListContainers(ctx context.Context) ([]cri.Container, error)
+	ContainerStatus(ctx context.Context, id string) (*cri.ContainerStatus, error)
+}
+
+// Event is a process killed by the OOM killer, with the container it ran in
+type Event struct {
+	Time    time.Time
+	PID     int
+	Process string
+	// AnonRSSBytes is the anonymous memory of the process when it was killed
+	AnonRSSBytes uint64
+	// Constraint is CONSTRAINT_MEMCG when the cgroup hit its memory limit and
+	// CONSTRAINT_NONE when the node ran out of memory
+	Constraint string
+	// Cgroup of the killed process, relative to the cgroup root
+	Cgroup string
+	// CgroupOOMKills is the number of OOM kills in the cgroup so far, from memory.events.
+	// Once the container's cgroup is removed it is read from the pod's cgroup instead.
+	CgroupOOMKills uint64
+
+	// Pod and container of the process, empty if it didn't run in a Kubernetes container
+	PodNamespace string
+	PodName      string
+	PodUID       string
+	Container    string
+	ContainerID  string
+
+	// Restarted is whether the container was restarted after the kill, RestartCount the
+	// restarts of the container in its pod so far
+	Restarted    bool
+	RestartCount uint32
+	// Exit of the killed container as reported by the runtime, e.g. OOMKilled and 137
+	ExitReason string
+	ExitCode   int32
+}
+
+// Correlator builds container OOM events from kernel messages
+type Correlator struct {
+	logger  logr.Logger
+	runtime ContainerRuntime
+	cgroups *cgroup.Reader
+}
+
+// NewCorrelator creates a correlator looking up containers with runtime and cgroup
+// counters with cgroups. Either can be nil to skip that source.
+func NewCorrelator(logger logr.Logger, runtime ContainerRuntime, cgroups *cgroup.Reader) *Correlator {
+	return &Correlator{
+		logger:  logger,
+		runtime: runtime,
+		cgroups: cgroups,
+	}
+}
+
+// Correlate returns an event for every OOM kill in messages, the kernel messages read by
+// the kernel collector (performance.MetricTypeKernel) with their typed events. A kill whose container or cgroup
+// can't be looked up still produces an event with what is known; the errors of the lookups
+// are returned alongside.
+func (c *Correlator) Correlate(ctx context.Context, messages []performance.KernelMessage) ([]Event, error) {
+	kills := parseKills(messages)
+	if len(kills) == 0 {
+		return nil, nil
+	}
+
+	var errs []error
+	var containers []cri.Container
+	if c.runtime != nil {
+		var err error
+		containers, err = c.runtime.ListContainers(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	events := make([]Event, 0, len(kills))
+	for _, k := range kills {
+		ev := Event{
+			Time:         k.time,
+			PID:          k.pid,
+			Process:      k.process,
+			AnonRSSBytes: k.anonRSS,
+			Constraint:   k.constraint,
+			Cgroup:       k.cgroup,
+		}
+		if k.cgroup != "" {
+			if err := c.addCgroupStats(&ev); err != nil {
+				errs = append(errs, err)
+			}
+			if err := c.addContainer(ctx, &ev, containers); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		c.logger.V(1).Info("container OOM killed", "pid", ev.PID, "process", ev.Process,
+			"namespace", ev.PodNamespace, "pod", ev.PodName, "container", ev.Container,
+			"restarted", ev.Restarted)
+		events = append(events, ev)
+	}
+	return events, errors.Join(errs...)
+}
+
+// addCgroupStats sets the OOM kill count of the cgroup of ev, falling back to its parent,
+// the pod cgroup, if the container's cgroup was already removed
+func (c *Correlator) addCgroupStats(ev *Event) error {
+	if c.cgroups == nil {
+		return nil
+	}
+	stats, err := c.cgroups.Stats(ev.Cgroup)
+	if errors.Is(err, cgroup.ErrNotFound) {
+		stats, err = c.cgroups.Stats(path.Dir(ev.Cgroup))
+	}
+	if errors.Is(err, cgroup.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cgroup %s: %w", ev.Cgroup, err)
+	}
+	ev.CgroupOOMKills = stats.Memory.OOMKills
+	return nil
+}
+
+// addContainer sets the pod and container of ev from the container whose cgroup was killed
+// and the restarts of the container since
+func (c *Correlator) addContainer(ctx context.Context, ev *Event, containers []cri.Container) error {
+	ev.PodUID, _ = cgroup.PodUID(ev.Cgroup)
+	id, ok := containerID(ev.Cgroup)
+	if !ok {
+		return nil
+	}
+	ev.ContainerID = id
+
+	var killed *cri.Container
+	for i := range containers {
+		if containers[i].ID == id {
+			killed = &containers[i]
+			break
+		}
+	}
+	if killed == nil {
+		// The runtime already removed the container
+		return nil
+	}
+	ev.PodNamespace = killed.Labels[cri.LabelPodNamespace]
+	ev.PodName = killed.Labels[cri.LabelPodName]
+	if uid := killed.Labels[cri.LabelPodUID]; uid != "" {
+		ev.PodUID = uid
+	}
+	ev.Container = killed.Name
+	ev.RestartCount = killed.Attempt
+
+	// The kubelet restarts a container in the same sandbox with the next attempt number
+	for _, ctr := range containers {
+		if ctr.PodSandboxID == killed.PodSandboxID && ctr.Name == killed.Name && ctr.Attempt > killed.Attempt {
+			ev.Restarted = true
+			ev.RestartCount = max(ev.RestartCount, ctr.Attempt)
+		}
+	}
+
+	if killed.State != cri.ContainerExited {
+		// Another process of the container was killed and it kept running
+		return nil
+	}
+	ctrStatus, err := c.runtime.ContainerStatus(ctx, id)
+	if err != nil {
+		// The container may have been removed in the meantime
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+	}
+	ev.ExitReason = ctrStatus.Reason
+	ev.ExitCode = ctrStatus.ExitCode
+	return nil
+}
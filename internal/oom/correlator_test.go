@@ -0,0 +1,110 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package oom
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/internal/cri"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+)
+
+type fakeRuntime struct {
+	containers []cri.Container
+	statuses   map[string]*cri.ContainerStatus
+}
+
+func (f *fakeRuntime) ListContainers(context.Context) ([]cri.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeRuntime) ContainerStatus(_ context.Context, id string) (*cri.ContainerStatus, error) {
+	return f.statuses[id], nil
+}
+
+func TestCorrelator(t *testing.T) {
+	podCgroup := "/kubepods/burstable/pod" + testPodUID
+	ctrCgroup := podCgroup + "/" + testContainerID
+
+	// The container's cgroup is already gone, only the pod's is left
+	sys := t.TempDir()
+	for name, content := range map[string]string{
+		"fs/cgroup/cgroup.controllers":             "cpu io memory\n",
+		"fs/cgroup" + podCgroup + "/memory.events": "low 0\nhigh 0\nmax 12\noom 3\noom_kill 3\n",
+	} {
+		path := filepath.Join(sys, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	cgroups, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+
+	labels := map[string]string{
+		cri.LabelPodName:      "web-0",
+		cri.LabelPodNamespace: "shop",
+		cri.LabelPodUID:       testPodUID,
+	}
+	runtime := &fakeRuntime{
+		containers: []cri.Container{
+			{ID: testContainerID, PodSandboxID: "sandbox", Name: "app", Attempt: 2, State: cri.ContainerExited, Labels: labels},
+			{ID: "next", PodSandboxID: "sandbox", Name: "app", Attempt: 3, State: cri.ContainerRunning, Labels: labels},
+			{ID: "sidecar", PodSandboxID: "sandbox", Name: "proxy", Attempt: 7, State: cri.ContainerRunning, Labels: labels},
+		},
+		statuses: map[string]*cri.ContainerStatus{
+			testContainerID: {Reason: "OOMKilled", ExitCode: 137},
+		},
+	}
+
+	ts := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	messages := withEvents([]performance.KernelMessage{
+		{Timestamp: ts, Message: "oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),task_memcg=" + ctrCgroup + ",task=app,pid=4242,uid=0"},
+		{Timestamp: ts, Message: "Memory cgroup out of memory: Killed process 4242 (app) total-vm:2048kB, anon-rss:1024kB, file-rss:0kB"},
+		{Timestamp: ts, Message: "Out of memory: Killed process 77 (sshd) total-vm:2048kB, anon-rss:512kB, file-rss:0kB"},
+	})
+
+	c := NewCorrelator(logr.Discard(), runtime, cgroups)
+	events, err := c.Correlate(context.Background(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{
+		{
+			Time:           ts,
+			PID:            4242,
+			Process:        "app",
+			AnonRSSBytes:   1024 * 1024,
+			Constraint:     "CONSTRAINT_MEMCG",
+			Cgroup:         ctrCgroup,
+			CgroupOOMKills: 3,
+			PodNamespace:   "shop",
+			PodName:        "web-0",
+			PodUID:         testPodUID,
+			Container:      "app",
+			ContainerID:    testContainerID,
+			Restarted:      true,
+			RestartCount:   3,
+			ExitReason:     "OOMKilled",
+			ExitCode:       137,
+		},
+		// Killed outside of any container
+		{Time: ts, PID: 77, Process: "sshd", AnonRSSBytes: 512 * 1024},
+	}, events)
+}
+
+func TestCorrelator_NoKills(t *testing.T) {
+	c := NewCorrelator(logr.Discard(), nil, nil)
+	events, err := c.Correlate(context.Background(), []performance.KernelMessage{{Message: "eth0: link up"}})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
@@ -0,0 +1,103 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package oom
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Summary of the kill, logged since kernel 4.19:
+//
+//	oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=...,mems_allowed=0,
+//	oom_memcg=/kubepods/burstable/pod<uid>,task_memcg=/kubepods/burstable/pod<uid>/<id>,
+//	task=java,pid=1234,uid=0
+//
+// The process killed and its memory usage are logged in the "Killed process" message,
+// which the kernel collector parses into a performance.KernelEventOOMKill event.
+var oomKillRe = regexp.MustCompile(`^oom-kill:(.*)$`)
+
+// kill is an OOM kill assembled from the kernel messages logged for it
+type kill struct {
+	time       time.Time
+	pid        int
+	process    string
+	constraint string
+	cgroup     string
+	anonRSS    uint64
+}
+
+// parseKills returns the OOM kills logged in messages, in the order they were logged. The
+// lines logged for the same kill are merged by PID.
+func parseKills(messages []performance.KernelMessage) []*kill {
+	var kills []*kill
+	byPID := make(map[int]*kill)
+	get := func(pid int, ts time.Time) *kill {
+		if k, ok := byPID[pid]; ok {
+			return k
+		}
+		k := &kill{pid: pid, time: ts}
+		byPID[pid] = k
+		kills = append(kills, k)
+		return k
+	}
+
+	for _, msg := range messages {
+		if m := oomKillRe.FindStringSubmatch(msg.Message); m != nil {
+			fields := make(map[string]string)
+			for _, field := range strings.Split(m[1], ",") {
+				key, value, _ := strings.Cut(field, "=")
+				fields[key] = value
+			}
+			pid, err := strconv.Atoi(fields["pid"])
+			if err != nil {
+				continue
+			}
+			k := get(pid, msg.Timestamp)
+			k.constraint = fields["constraint"]
+			k.cgroup = fields["task_memcg"]
+			if k.process == "" {
+				k.process = fields["task"]
+			}
+			continue
+		}
+		if ev := msg.Event; ev != nil && ev.Kind == performance.KernelEventOOMKill && ev.PID > 0 {
+			k := get(int(ev.PID), msg.Timestamp)
+			k.process = ev.Process
+			if rss, err := strconv.ParseUint(ev.Fields["anon_rss_kb"], 10, 64); err == nil {
+				k.anonRSS = rss * 1024
+			}
+		}
+	}
+	return kills
+}
+
+// Prefixes and suffixes container runtimes put around the container ID in the name of
+// its cgroup, e.g. cri-containerd-<id>.scope with the systemd cgroup driver
+var containerCgroupPrefixes = []string{"cri-containerd-", "crio-", "docker-", "containerd-"}
+
+// containerID returns the ID of the container whose cgroup is cgroupPath, e.g.
+// /kubepods/burstable/pod<uid>/<id> or
+// /kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/cri-containerd-<id>.scope
+func containerID(cgroupPath string) (string, bool) {
+	name := strings.TrimSuffix(path.Base(cgroupPath), ".scope")
+	for _, prefix := range containerCgroupPrefixes {
+		if id, ok := strings.CutPrefix(name, prefix); ok {
+			name = id
+			break
+		}
+	}
+	if len(name) != 64 || strings.Trim(name, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return name, true
+}
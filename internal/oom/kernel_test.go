@@ -0,0 +1,92 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package oom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+)
+
+const (
+	testContainerID = "4f3c2b1a0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b"
+	testPodUID      = "0b8f1c2d-3e4f-5a6b-7c8d-9e0f1a2b3c4d"
+)
+
+// withEvents sets the typed events of messages, as the kernel collector does
+func withEvents(messages []performance.KernelMessage) []performance.KernelMessage {
+	for i := range messages {
+		messages[i].Event = collectors.ParseKernelEvent(messages[i].Message)
+	}
+	return messages
+}
+
+func TestParseKills(t *testing.T) {
+	ts := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	messages := []performance.KernelMessage{
+		{Timestamp: ts, Message: "stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=984"},
+		{Timestamp: ts, Message: "oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=" + testContainerID +
+			",mems_allowed=0,oom_memcg=/kubepods/burstable/pod" + testPodUID + "/" + testContainerID +
+			",task_memcg=/kubepods/burstable/pod" + testPodUID + "/" + testContainerID + ",task=stress,pid=4242,uid=0"},
+		{Timestamp: ts, Message: "Memory cgroup out of memory: Killed process 4242 (stress) total-vm:1054588kB, " +
+			"anon-rss:262144kB, file-rss:4kB, shmem-rss:0kB, UID:0 pgtables:620kB oom_score_adj:984"},
+		{Timestamp: ts.Add(time.Second), Message: "Out of memory: Killed process 99 (java) total-vm:100kB, anon-rss:8kB"},
+		{Timestamp: ts, Message: "eth0: link up"},
+		// Kernels before 5.1
+		{Timestamp: ts.Add(2 * time.Second), Message: "Out of memory: Kill process 77 (node) score 900 or sacrifice child"},
+		{Timestamp: ts.Add(2 * time.Second), Message: "Killed process 77 (node) total-vm:2048kB, anon-rss:1024kB, file-rss:0kB, shmem-rss:0kB"},
+	}
+	kills := parseKills(withEvents(messages))
+	assert.Equal(t, []*kill{
+		{
+			time:       ts,
+			pid:        4242,
+			process:    "stress",
+			constraint: "CONSTRAINT_MEMCG",
+			cgroup:     "/kubepods/burstable/pod" + testPodUID + "/" + testContainerID,
+			anonRSS:    262144 * 1024,
+		},
+		{time: ts.Add(time.Second), pid: 99, process: "java", anonRSS: 8 * 1024},
+		{time: ts.Add(2 * time.Second), pid: 77, process: "node", anonRSS: 1024 * 1024},
+	}, kills)
+}
+
+func TestContainerIDAndPodUID(t *testing.T) {
+	systemdUID := "0b8f1c2d_3e4f_5a6b_7c8d_9e0f1a2b3c4d"
+	tests := []struct {
+		cgroup string
+		id     string
+		uid    string
+	}{
+		{cgroup: "/kubepods/burstable/pod" + testPodUID + "/" + testContainerID, id: testContainerID, uid: testPodUID},
+		{cgroup: "/kubepods/pod" + testPodUID + "/" + testContainerID, id: testContainerID, uid: testPodUID},
+		{
+			cgroup: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + systemdUID +
+				".slice/cri-containerd-" + testContainerID + ".scope",
+			id:  testContainerID,
+			uid: testPodUID,
+		},
+		{
+			cgroup: "/kubepods.slice/kubepods-pod" + systemdUID + ".slice/crio-" + testContainerID + ".scope",
+			id:     testContainerID,
+			uid:    testPodUID,
+		},
+		{cgroup: "/kubepods/burstable/pod" + testPodUID, uid: testPodUID},
+		{cgroup: "/system.slice/sshd.service"},
+	}
+	for _, tt := range tests {
+		id, _ := containerID(tt.cgroup)
+		assert.Equal(t, tt.id, id, "containerID(%q)", tt.cgroup)
+		uid, _ := cgroup.PodUID(tt.cgroup)
+		assert.Equal(t, tt.uid, uid, "PodUID(%q)", tt.cgroup)
+	}
+}
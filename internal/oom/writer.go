@@ -0,0 +1,151 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package oom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// ResourceType is the resource type of container OOM kills. There is no generated message
+// for it; its spec is a google.protobuf.Struct.
+const ResourceType = "antimetal.agent.v1.ContainerOOM"
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+// Writer upserts a ContainerOOM resource named <node>/<pid>/<unix time> for each OOM kill
+// in the kernel messages of the performance snapshots it is given. The kills are critical
+// events so that the intake sends them ahead of everything else.
+//
+// The spec of the resource is a google.protobuf.Struct with the fields of the Event in
+// lower camel case, its timestamp and the nodeName.
+type Writer struct {
+	Correlator *Correlator
+	Store      resource.Store
+	NodeName   string
+	// Provider namespaces the kills to the cluster. Optional.
+	Provider cluster.Provider
+
+	mu sync.Mutex
+	// lastSeq is the sequence number of the last kernel message correlated
+	lastSeq   uint64
+	namespace *resourcev1.Namespace
+}
+
+// OnSnapshot writes the OOM kills logged since the previous snapshot. The kernel collector
+// returns the most recent messages on every collection, so the messages already seen are
+// skipped by their sequence number.
+func (w *Writer) OnSnapshot(ctx context.Context, snapshot *performance.Snapshot) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var messages []performance.KernelMessage
+	for _, msg := range snapshot.Metrics.Kernel {
+		if msg.SequenceNum > w.lastSeq {
+			messages = append(messages, msg)
+		}
+	}
+	for _, msg := range messages {
+		w.lastSeq = max(w.lastSeq, msg.SequenceNum)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	events, err := w.Correlator.Correlate(ctx, messages)
+	for _, ev := range events {
+		if werr := w.write(ctx, ev); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+	return err
+}
+
+func (w *Writer) write(ctx context.Context, ev Event) error {
+	namespace, err := w.clusterNamespace(ctx)
+	if err != nil {
+		return err
+	}
+	spec, err := structpb.NewStruct(map[string]any{
+		"timestamp":      ev.Time.UTC().Format(time.RFC3339Nano),
+		"pid":            float64(ev.PID),
+		"process":        ev.Process,
+		"anonRssBytes":   float64(ev.AnonRSSBytes),
+		"constraint":     ev.Constraint,
+		"cgroup":         ev.Cgroup,
+		"cgroupOomKills": float64(ev.CgroupOOMKills),
+		"podNamespace":   ev.PodNamespace,
+		"podName":        ev.PodName,
+		"podUid":         ev.PodUID,
+		"container":      ev.Container,
+		"containerId":    ev.ContainerID,
+		"restarted":      ev.Restarted,
+		"restartCount":   float64(ev.RestartCount),
+		"exitReason":     ev.ExitReason,
+		"exitCode":       float64(ev.ExitCode),
+		"nodeName":       w.NodeName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create OOM kill spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal OOM kill spec: %w", err)
+	}
+
+	name := fmt.Sprintf("%s/%d/%d", w.NodeName, ev.PID, ev.Time.Unix())
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: name,
+			Name:       name,
+			Namespace:  namespace,
+		},
+		Spec: specAny,
+	}
+	if w.Provider != nil {
+		rsrc.Metadata.Provider = resourcev1.Provider_PROVIDER_KUBERNETES
+	}
+	if err := w.Store.UpdateResource(rsrc, resource.WithEventClass(resource.EventClassCritical)); err != nil {
+		return fmt.Errorf("failed to update OOM kill in inventory: %w", err)
+	}
+	return nil
+}
+
+// clusterNamespace returns the namespace of the cluster, looked up on the first kill
+func (w *Writer) clusterNamespace(ctx context.Context) (*resourcev1.Namespace, error) {
+	if w.Provider == nil || w.namespace != nil {
+		return w.namespace, nil
+	}
+	clusterName, err := w.Provider.ClusterName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster name: %w", err)
+	}
+	w.namespace = &resourcev1.Namespace{
+		Namespace: &resourcev1.Namespace_Kube{
+			Kube: &resourcev1.KubernetesNamespace{
+				Cluster: clusterName,
+			},
+		},
+	}
+	return w.namespace, nil
+}
@@ -0,0 +1,70 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package oom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+func TestWriter_OnSnapshot(t *testing.T) {
+	inv, err := store.New()
+	require.NoError(t, err)
+	defer inv.Close()
+	events := inv.Subscribe(nil, resource.WithoutInitialList())
+
+	w := &Writer{Correlator: NewCorrelator(logr.Discard(), nil, nil), Store: inv, NodeName: "node-1"}
+	ts := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	snapshot := &performance.Snapshot{Metrics: performance.Metrics{Kernel: withEvents([]performance.KernelMessage{
+		{SequenceNum: 10, Timestamp: ts, Message: "eth0: link up"},
+		{SequenceNum: 11, Timestamp: ts, Message: "Out of memory: Killed process 77 (sshd) total-vm:2048kB, anon-rss:512kB"},
+	})}}
+	require.NoError(t, w.OnSnapshot(context.Background(), snapshot))
+
+	rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "node-1/77/1748779200"})
+	require.NoError(t, err)
+	spec := &structpb.Struct{}
+	require.NoError(t, rsrc.GetSpec().UnmarshalTo(spec))
+	assert.Equal(t, "sshd", spec.AsMap()["process"])
+	assert.Equal(t, 512.0*1024, spec.AsMap()["anonRssBytes"])
+	assert.Equal(t, "2025-06-01T12:00:00Z", spec.AsMap()["timestamp"])
+	select {
+	case e := <-events:
+		assert.Equal(t, resource.EventClassCritical, e.Class)
+	case <-time.After(time.Second):
+		t.Fatal("expected an event for the OOM kill")
+	}
+
+	// The kernel collector returns the same messages again with the new ones
+	snapshot.Metrics.Kernel = append(snapshot.Metrics.Kernel, withEvents([]performance.KernelMessage{
+		{SequenceNum: 12, Timestamp: ts.Add(time.Minute), Message: "Out of memory: Killed process 78 (java) total-vm:2048kB, anon-rss:1024kB"},
+	})...)
+	require.NoError(t, w.OnSnapshot(context.Background(), snapshot))
+	_, err = inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "node-1/78/1748779260"})
+	require.NoError(t, err)
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("expected an event for the new OOM kill")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event for a kill already written: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
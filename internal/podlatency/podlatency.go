@@ -0,0 +1,348 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package podlatency breaks down how long pods take to start, from the status transitions
+// of the pods the agent watches. The time from creating a pod to it being ready is a key
+// SLO of platform teams, and which stage it is spent in tells a busy scheduler from slow
+// image pulls or slow readiness probes.
+package podlatency
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/antimetal/agent/internal/kubernetes/cluster"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+const (
+	trackerName = "pod-startup-tracker"
+
+	// ResourceType is the resource type of pod startups. There is no generated message
+	// for it; its spec is a google.protobuf.Struct.
+	ResourceType = "antimetal.agent.v1.PodStartup"
+)
+
+// Stages of the startup of a pod, the label values of the startup duration metric
+const (
+	// StageScheduling is from the creation of the pod until it is scheduled to a node
+	StageScheduling = "scheduling"
+	// StageInitialization is from scheduling until the pod sandbox is created and the
+	// init containers completed, pulling their images included
+	StageInitialization = "initialization"
+	// StageStarting is from initialization until all containers started. Pod status has no
+	// image pull times, so pulling the images of the containers is part of this stage.
+	StageStarting = "starting"
+	// StageReadiness is from all containers started until the pod is ready, i.e. its
+	// startup and readiness probes succeeded
+	StageReadiness = "readiness"
+	// StageTotal is from the creation of the pod until it is ready
+	StageTotal = "total"
+)
+
+var kindResource = typeurl.Name(&resourcev1.Resource{})
+
+var startupDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "antimetal_k8s_pod_startup_duration_seconds",
+	Help:    "Time pods spent in each stage of their startup, by stage: scheduling, initialization, starting, readiness or total.",
+	Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+}, []string{"stage"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(startupDurationSeconds)
+}
+
+// Tracker watches the pods of the cluster and, once a pod is ready for the first time,
+// records how long each stage of its startup took in the
+// antimetal_k8s_pod_startup_duration_seconds histogram and, if it has a Store, upserts a
+// PodStartup resource named <namespace>/<pod>. The resource is deleted with the pod.
+//
+// The spec of the resource is a google.protobuf.Struct with the namespace, podName,
+// podUID and nodeName of the pod, the created, scheduled, initialized, started and ready
+// timestamps and the schedulingSeconds, initializationSeconds, startingSeconds,
+// readinessSeconds and totalSeconds durations of the stages.
+//
+// The stages are measured from the transition times of the pod conditions and the start
+// times of the containers, which the API server stores with a precision of one second.
+// Stages that can't be measured, such as the starting stage of a pod whose containers
+// restarted before it was ready, are left out. Pods that were ready before the Tracker
+// started aren't reported.
+type Tracker struct {
+	// Informers watches the pods, e.g. the cache of a controller-runtime Manager, which
+	// shares the informer with the Kubernetes controller
+	Informers cache.Informers
+	// Store gets a PodStartup resource per pod. Optional.
+	Store resource.Store
+	// Provider namespaces the resources to the cluster. Optional.
+	Provider cluster.Provider
+}
+
+// SetupWithManager registers the Tracker to the provided manager
+func (t *Tracker) SetupWithManager(mgr manager.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("must provide a non-nil Manager")
+	}
+	if t.Informers == nil {
+		t.Informers = mgr.GetCache()
+	}
+	runnable, err := t.runnable(mgr.GetLogger().WithName(trackerName))
+	if err != nil {
+		return err
+	}
+	return mgr.Add(runnable)
+}
+
+func (t *Tracker) runnable(logger logr.Logger) (*tracker, error) {
+	if t.Informers == nil {
+		return nil, fmt.Errorf("Tracker must be configured with non-nil Informers")
+	}
+	return &tracker{
+		informers: t.Informers,
+		store:     t.Store,
+		provider:  t.Provider,
+		logger:    logger,
+		started:   time.Now(),
+		reported:  make(map[types.UID]string),
+	}, nil
+}
+
+type tracker struct {
+	informers cache.Informers
+	store     resource.Store
+	provider  cluster.Provider
+	logger    logr.Logger
+
+	namespace *resourcev1.Namespace
+	// started is when the tracker started. Pods ready before aren't reported.
+	started time.Time
+	// reported holds the name of the PodStartup resource of every pod that was ready,
+	// or an empty name if it wasn't reported, by UID. The informer calls the handlers of
+	// the tracker one at a time, so it needs no lock.
+	reported map[types.UID]string
+}
+
+func (t *tracker) Start(ctx context.Context) error {
+	if t.provider != nil && t.store != nil {
+		clusterName, err := t.provider.ClusterName(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster name: %w", err)
+		}
+		t.namespace = &resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{
+					Cluster: clusterName,
+				},
+			},
+		}
+	}
+
+	informer, err := t.informers.GetInformer(ctx, &corev1.Pod{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod informer: %w", err)
+	}
+	registration, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.observe(pod)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.observe(pod)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.forget(pod)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch pods: %w", err)
+	}
+	<-ctx.Done()
+	if err := informer.RemoveEventHandler(registration); err != nil {
+		t.logger.Error(err, "failed to stop watching pods")
+	}
+	return nil
+}
+
+// Implements sigs.k8s.io/controller-runtime/pkg/manager.LeaderElectionRunnable so that
+// the pods of the cluster are only reported by the leader.
+func (t *tracker) NeedLeaderElection() bool {
+	return true
+}
+
+// observe reports pod if it is ready for the first time
+func (t *tracker) observe(pod *corev1.Pod) {
+	if _, ok := t.reported[pod.UID]; ok {
+		return
+	}
+	startup, ok := newStartup(pod)
+	if !ok {
+		return
+	}
+	if startup.ready.Before(t.started) {
+		t.reported[pod.UID] = ""
+		return
+	}
+
+	for stage, duration := range startup.stages() {
+		startupDurationSeconds.WithLabelValues(stage).Observe(duration.Seconds())
+	}
+	t.logger.V(1).Info("Pod ready", "pod", pod.Namespace+"/"+pod.Name,
+		"node", pod.Spec.NodeName, "total", startup.ready.Sub(startup.created))
+
+	name := ""
+	if t.store != nil {
+		name = pod.Namespace + "/" + pod.Name
+		if err := t.write(pod, startup, name); err != nil {
+			t.logger.Error(err, "failed to write pod startup", "pod", name)
+		}
+	}
+	t.reported[pod.UID] = name
+}
+
+// forget deletes the PodStartup resource of pod
+func (t *tracker) forget(pod *corev1.Pod) {
+	name, ok := t.reported[pod.UID]
+	delete(t.reported, pod.UID)
+	if !ok || name == "" {
+		return
+	}
+	ref := &resourcev1.ResourceRef{TypeUrl: ResourceType, Name: name, Namespace: t.namespace}
+	if err := t.store.DeleteResource(ref); err != nil {
+		t.logger.V(1).Info("failed to delete pod startup", "pod", name, "error", err.Error())
+	}
+}
+
+// startup holds when a pod reached each stage of its startup. Stages that can't be
+// measured are zero.
+type startup struct {
+	created     time.Time
+	scheduled   time.Time
+	initialized time.Time
+	started     time.Time
+	ready       time.Time
+}
+
+// newStartup returns the startup of pod, or false if it isn't ready
+func newStartup(pod *corev1.Pod) (startup, bool) {
+	s := startup{created: pod.CreationTimestamp.Time}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case corev1.PodScheduled:
+			s.scheduled = cond.LastTransitionTime.Time
+		case corev1.PodInitialized:
+			s.initialized = cond.LastTransitionTime.Time
+		case corev1.PodReady:
+			s.ready = cond.LastTransitionTime.Time
+		}
+	}
+	if s.ready.IsZero() {
+		return startup{}, false
+	}
+
+	// The last container to start, unless a container restarted, since it only has the
+	// start time of its last run
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.RestartCount > 0 || status.State.Running == nil {
+			s.started = time.Time{}
+			break
+		}
+		if started := status.State.Running.StartedAt.Time; started.After(s.started) {
+			s.started = started
+		}
+	}
+	return s, true
+}
+
+// stages returns the durations of the stages of s that can be measured
+func (s startup) stages() map[string]time.Duration {
+	stages := make(map[string]time.Duration, 5)
+	add := func(stage string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() && !to.Before(from) {
+			stages[stage] = to.Sub(from)
+		}
+	}
+	add(StageScheduling, s.created, s.scheduled)
+	add(StageInitialization, s.scheduled, s.initialized)
+	add(StageStarting, s.initialized, s.started)
+	add(StageReadiness, s.started, s.ready)
+	add(StageTotal, s.created, s.ready)
+	return stages
+}
+
+func (t *tracker) write(pod *corev1.Pod, s startup, name string) error {
+	fields := map[string]any{
+		"namespace": pod.Namespace,
+		"podName":   pod.Name,
+		"podUID":    string(pod.UID),
+		"nodeName":  pod.Spec.NodeName,
+	}
+	for key, ts := range map[string]time.Time{
+		"created":     s.created,
+		"scheduled":   s.scheduled,
+		"initialized": s.initialized,
+		"started":     s.started,
+		"ready":       s.ready,
+	} {
+		if !ts.IsZero() {
+			fields[key] = ts.UTC().Format(time.RFC3339)
+		}
+	}
+	for stage, duration := range s.stages() {
+		fields[stage+"Seconds"] = duration.Seconds()
+	}
+	spec, err := structpb.NewStruct(fields)
+	if err != nil {
+		return fmt.Errorf("failed to create pod startup spec: %w", err)
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pod startup spec: %w", err)
+	}
+
+	rsrc := &resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: ResourceType,
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			ProviderId: string(pod.UID),
+			Name:       name,
+			Namespace:  t.namespace,
+		},
+		Spec: specAny,
+	}
+	if t.provider != nil {
+		rsrc.Metadata.Provider = resourcev1.Provider_PROVIDER_KUBERNETES
+	}
+	if err := t.store.UpdateResource(rsrc); err != nil {
+		return fmt.Errorf("failed to update pod startup in inventory: %w", err)
+	}
+	return nil
+}
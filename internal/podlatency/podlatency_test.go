@@ -0,0 +1,187 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package podlatency
+
+import (
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+var created = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newPod returns a pod created at 12:00:00, scheduled 2s later, initialized 1s later, whose
+// container started 10s later, ready 5s later
+func newPod(name string) *corev1.Pod {
+	at := func(seconds int) metav1.Time {
+		return metav1.NewTime(created.Add(time.Duration(seconds) * time.Second))
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			UID:               types.UID(name + "-uid"),
+			CreationTimestamp: at(0),
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: at(2)},
+				{Type: corev1.PodInitialized, Status: corev1.ConditionTrue, LastTransitionTime: at(3)},
+				{Type: corev1.ContainersReady, Status: corev1.ConditionTrue, LastTransitionTime: at(18)},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: at(18)},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(8)}}},
+				{Name: "app", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(13)}}},
+			},
+		},
+	}
+}
+
+func TestStartup_Stages(t *testing.T) {
+	pod := newPod("web")
+	s, ok := newStartup(pod)
+	if !ok {
+		t.Fatal("expected a ready pod to have a startup")
+	}
+	expected := map[string]time.Duration{
+		StageScheduling:     2 * time.Second,
+		StageInitialization: time.Second,
+		StageStarting:       10 * time.Second,
+		StageReadiness:      5 * time.Second,
+		StageTotal:          18 * time.Second,
+	}
+	stages := s.stages()
+	if len(stages) != len(expected) {
+		t.Errorf("expected stages %v, got %v", expected, stages)
+	}
+	for stage, duration := range expected {
+		if stages[stage] != duration {
+			t.Errorf("expected %s to take %s, got %s", stage, duration, stages[stage])
+		}
+	}
+
+	// The start time of a restarted container is that of its last run
+	pod.Status.ContainerStatuses[1].RestartCount = 1
+	s, _ = newStartup(pod)
+	stages = s.stages()
+	if _, ok := stages[StageStarting]; ok {
+		t.Errorf("expected no starting stage with a restarted container, got %v", stages)
+	}
+	if _, ok := stages[StageReadiness]; ok {
+		t.Errorf("expected no readiness stage with a restarted container, got %v", stages)
+	}
+	if stages[StageTotal] != 18*time.Second {
+		t.Errorf("expected total of 18s, got %v", stages)
+	}
+
+	pod.Status.Conditions[3].Status = corev1.ConditionFalse
+	if _, ok := newStartup(pod); ok {
+		t.Error("expected no startup for a pod that isn't ready")
+	}
+}
+
+func TestTracker_Observe(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+
+	tr := &Tracker{Informers: &informertest.FakeInformers{}, Store: inv}
+	runnable, err := tr.runnable(logr.Discard())
+	if err != nil {
+		t.Fatalf("failed to create tracker: %v", err)
+	}
+	runnable.started = created
+
+	get := func(name string) (map[string]any, bool) {
+		t.Helper()
+		rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "default/" + name})
+		if err != nil {
+			return nil, false
+		}
+		spec := &structpb.Struct{}
+		if err := rsrc.GetSpec().UnmarshalTo(spec); err != nil {
+			t.Fatalf("failed to unmarshal pod startup spec: %v", err)
+		}
+		return spec.AsMap(), true
+	}
+
+	// Pending pods aren't reported until they are ready
+	pod := newPod("web")
+	pending := pod.DeepCopy()
+	pending.Status.Conditions = pending.Status.Conditions[:2]
+	runnable.observe(pending)
+	if _, ok := get("web"); ok {
+		t.Fatal("expected no pod startup for a pending pod")
+	}
+
+	runnable.observe(pod)
+	spec, ok := get("web")
+	if !ok {
+		t.Fatal("expected a pod startup for a ready pod")
+	}
+	for key, expected := range map[string]any{
+		"podUID":                "web-uid",
+		"nodeName":              "node-1",
+		"scheduled":             "2026-01-01T12:00:02Z",
+		"ready":                 "2026-01-01T12:00:18Z",
+		"schedulingSeconds":     2.0,
+		"initializationSeconds": 1.0,
+		"startingSeconds":       10.0,
+		"readinessSeconds":      5.0,
+		"totalSeconds":          18.0,
+	} {
+		if spec[key] != expected {
+			t.Errorf("expected %s = %v, got %v", key, expected, spec[key])
+		}
+	}
+
+	// A pod is reported once, even if it becomes ready again
+	if err := inv.DeleteResource(&resourcev1.ResourceRef{TypeUrl: ResourceType, Name: "default/web"}); err != nil {
+		t.Fatalf("failed to delete pod startup: %v", err)
+	}
+	again := pod.DeepCopy()
+	again.Status.Conditions[3].LastTransitionTime = metav1.NewTime(created.Add(time.Hour))
+	runnable.observe(again)
+	if _, ok := get("web"); ok {
+		t.Error("expected a pod to be reported once")
+	}
+	runnable.observe(pod)
+	runnable.forget(pod)
+	if len(runnable.reported) != 0 {
+		t.Errorf("expected deleted pods to be forgotten, got %v", runnable.reported)
+	}
+
+	// Pods ready before the tracker started aren't reported
+	runnable.started = created.Add(time.Minute)
+	runnable.observe(newPod("old"))
+	if _, ok := get("old"); ok {
+		t.Error("expected no pod startup for a pod ready before the tracker started")
+	}
+
+	runnable.started = created
+	runnable.observe(newPod("api"))
+	if _, ok := get("api"); !ok {
+		t.Fatal("expected a pod startup for a ready pod")
+	}
+	runnable.forget(newPod("api"))
+	if _, ok := get("api"); ok {
+		t.Error("expected the pod startup of a deleted pod to be deleted")
+	}
+}
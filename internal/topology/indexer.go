@@ -0,0 +1,252 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package topology indexes the resources the agent reads from the node or host it runs
+// on, e.g. its disks or processes, with the relationships they own. The resources are
+// read anew on every sync and only those that changed since are written to the store.
+package topology
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+var (
+	kindResource     = typeurl.Name(&resourcev1.Resource{})
+	kindRelationship = typeurl.Name(&resourcev1.Relationship{})
+)
+
+// Resource is a resource to index with the relationships it owns. Its spec is stored as a
+// google.protobuf.Struct.
+type Resource struct {
+	Ref  *resourcev1.ResourceRef
+	Spec map[string]any
+	Rels []Relationship
+}
+
+// Relationship is the relationship Subject -> Predicate -> Object and its inverse
+type Relationship struct {
+	Subject, Object    *resourcev1.ResourceRef
+	Predicate, Inverse protoreflect.MessageType
+}
+
+// Indexer keeps the resources of a sync in the store. It isn't safe for concurrent use.
+type Indexer struct {
+	store    resource.Store
+	provider resourcev1.Provider
+	kind     string
+	logger   logr.Logger
+
+	// indexed holds every resource in the store by type and name so unchanged resources
+	// aren't updated on every sync
+	indexed map[string]indexedResource
+}
+
+type indexedResource struct {
+	ref *resourcev1.ResourceRef
+	// Deterministic encoding of the spec
+	spec []byte
+	// rels identifies the relationships added with the resource
+	rels string
+}
+
+// NewIndexer returns an Indexer of the resources of provider. kind names the resources in
+// logs and errors, e.g. "storage resource".
+func NewIndexer(store resource.Store, provider resourcev1.Provider, kind string, logger logr.Logger) *Indexer {
+	return &Indexer{
+		store:    store,
+		provider: provider,
+		kind:     kind,
+		logger:   logger,
+		indexed:  make(map[string]indexedResource),
+	}
+}
+
+// Sync indexes rsrcs and deletes the resources of the previous syncs that aren't in rsrcs.
+// Resources are indexed in order, so every resource should come after the resources it
+// relates to. Failures are logged and the resource is indexed again on the next sync.
+func (x *Indexer) Sync(rsrcs []Resource) {
+	present := make(map[string]bool, len(rsrcs))
+	for _, rsrc := range rsrcs {
+		present[Key(rsrc.Ref)] = true
+	}
+	for key, prev := range x.indexed {
+		if present[key] {
+			continue
+		}
+		if err := x.delete(key); err != nil {
+			x.logger.Error(err, "failed to delete "+x.kind, "type", prev.ref.GetTypeUrl(), "name", prev.ref.GetName())
+		}
+	}
+
+	// Relationships can't be removed on their own but are deleted with the resource, so a
+	// resource whose relationships changed, e.g. a filesystem a volume was unmounted from,
+	// is indexed anew. Deleting it also deletes the relationships other resources own to
+	// it, so they are indexed anew too.
+	rels := make(map[string]string, len(rsrcs))
+	stale := make(map[string]bool)
+	for _, rsrc := range rsrcs {
+		key := Key(rsrc.Ref)
+		rels[key] = relationshipsKey(rsrc.Rels)
+		if prev, ok := x.indexed[key]; ok && prev.rels != rels[key] {
+			stale[key] = true
+		}
+	}
+	for changed := len(stale) > 0; changed; {
+		changed = false
+		for _, rsrc := range rsrcs {
+			key := Key(rsrc.Ref)
+			if _, ok := x.indexed[key]; !ok || stale[key] {
+				continue
+			}
+			if slices.ContainsFunc(rsrc.Rels, func(rel Relationship) bool {
+				return stale[Key(rel.Subject)] || stale[Key(rel.Object)]
+			}) {
+				stale[key] = true
+				changed = true
+			}
+		}
+	}
+	for key := range stale {
+		if err := x.delete(key); err != nil {
+			x.logger.Error(err, "failed to delete "+x.kind, "key", key)
+		}
+	}
+
+	for _, rsrc := range rsrcs {
+		if err := x.index(rsrc, rels[Key(rsrc.Ref)]); err != nil {
+			x.logger.Error(err, "failed to index "+x.kind, "type", rsrc.Ref.GetTypeUrl(), "name", rsrc.Ref.GetName())
+		}
+	}
+}
+
+func (x *Indexer) index(rsrc Resource, rels string) error {
+	spec, err := structpb.NewStruct(rsrc.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to create %s spec: %w", x.kind, err)
+	}
+	// Deterministic so an unchanged spec encodes to the same bytes
+	encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s spec: %w", x.kind, err)
+	}
+
+	key := Key(rsrc.Ref)
+	prev, wasIndexed := x.indexed[key]
+	if wasIndexed && bytes.Equal(prev.spec, encoded) {
+		return nil
+	}
+	specAny, err := anypb.New(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s spec: %w", x.kind, err)
+	}
+
+	if err := x.store.UpdateResource(&resourcev1.Resource{
+		Type: &resourcev1.TypeDescriptor{
+			Kind: kindResource,
+			Type: rsrc.Ref.GetTypeUrl(),
+		},
+		Metadata: &resourcev1.ResourceMeta{
+			Provider:   x.provider,
+			ProviderId: rsrc.Ref.GetName(),
+			Name:       rsrc.Ref.GetName(),
+			Namespace:  rsrc.Ref.GetNamespace(),
+		},
+		Spec: specAny,
+	}); err != nil {
+		return fmt.Errorf("failed to update %s in inventory: %w", x.kind, err)
+	}
+
+	if !wasIndexed && len(rsrc.Rels) > 0 {
+		var pairs []*resourcev1.Relationship
+		for _, rel := range rsrc.Rels {
+			pair, err := RelationshipPair(rel.Subject, rel.Object, rel.Predicate, rel.Inverse)
+			if err != nil {
+				return err
+			}
+			pairs = append(pairs, pair...)
+		}
+		if err := x.store.AddRelationships(pairs...); err != nil {
+			return fmt.Errorf("failed to add %s relationships to inventory: %w", x.kind, err)
+		}
+	}
+
+	x.indexed[key] = indexedResource{ref: rsrc.Ref, spec: encoded, rels: rels}
+	return nil
+}
+
+// delete deletes the indexed resource with key from the store
+func (x *Indexer) delete(key string) error {
+	err := x.store.DeleteResource(x.indexed[key].ref)
+	if err != nil && !errors.Is(err, resource.ErrResourceNotFound) {
+		return err
+	}
+	delete(x.indexed, key)
+	return nil
+}
+
+// Key identifies the resource ref within its namespace
+func Key(ref *resourcev1.ResourceRef) string {
+	return ref.GetTypeUrl() + "/" + ref.GetName()
+}
+
+// relationshipsKey identifies the relationships of a resource
+func relationshipsKey(rels []Relationship) string {
+	var keys []string
+	for _, rel := range rels {
+		keys = append(keys, strings.Join([]string{
+			string(rel.Predicate.Descriptor().Name()), Key(rel.Subject), Key(rel.Object),
+		}, " "))
+	}
+	return strings.Join(keys, "\n")
+}
+
+// RelationshipPair returns the relationship subject -> predicate -> object and its
+// inverse object -> inverse -> subject.
+func RelationshipPair(subject, object *resourcev1.ResourceRef, predicate, inverse protoreflect.MessageType,
+) ([]*resourcev1.Relationship, error) {
+	predicateAny, err := anypb.New(predicate.New().Interface())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create predicate: %w", err)
+	}
+	inverseAny, err := anypb.New(inverse.New().Interface())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create predicate: %w", err)
+	}
+	return []*resourcev1.Relationship{
+		{
+			Type: &resourcev1.TypeDescriptor{
+				Kind: kindRelationship,
+				Type: string(predicate.Descriptor().FullName()),
+			},
+			Subject:   subject,
+			Object:    object,
+			Predicate: predicateAny,
+		},
+		{
+			Type: &resourcev1.TypeDescriptor{
+				Kind: kindRelationship,
+				Type: string(inverse.Descriptor().FullName()),
+			},
+			Subject:   object,
+			Object:    subject,
+			Predicate: inverseAny,
+		},
+	}, nil
+}
@@ -0,0 +1,102 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package topology
+
+import (
+	"testing"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"github.com/go-logr/logr"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+// countingStore counts the resources updated in its Store
+type countingStore struct {
+	resource.Store
+	updates int
+}
+
+func (s *countingStore) UpdateResource(rsrc *resourcev1.Resource, opts ...resource.WriteOption) error {
+	s.updates++
+	return s.Store.UpdateResource(rsrc, opts...)
+}
+
+func TestIndexer_Sync(t *testing.T) {
+	inv, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer inv.Close()
+	s := &countingStore{Store: inv}
+	x := NewIndexer(s, resourcev1.Provider_PROVIDER_KUBERNETES, "test resource", logr.Discard())
+
+	contains := (&k8sv1.Contains{}).ProtoReflect().Type()
+	containedBy := (&k8sv1.ContainedBy{}).ProtoReflect().Type()
+	nodeRef := &resourcev1.ResourceRef{TypeUrl: "test.Node", Name: "node-1"}
+	diskRef := &resourcev1.ResourceRef{TypeUrl: "test.Disk", Name: "node-1/sda"}
+	partRef := &resourcev1.ResourceRef{TypeUrl: "test.Partition", Name: "node-1/sda1"}
+	volumeRef := &resourcev1.ResourceRef{TypeUrl: "test.Volume", Name: "pv-1"}
+	disk := Resource{
+		Ref:  diskRef,
+		Spec: map[string]any{"sizeBytes": 1024.0},
+		Rels: []Relationship{{Subject: nodeRef, Object: diskRef, Predicate: contains, Inverse: containedBy}},
+	}
+	part := Resource{
+		Ref:  partRef,
+		Spec: map[string]any{"number": 1.0},
+		Rels: []Relationship{{Subject: diskRef, Object: partRef, Predicate: contains, Inverse: containedBy}},
+	}
+	contained := func(container *resourcev1.ResourceRef) []string {
+		t.Helper()
+		rels, err := inv.GetRelationships(container, nil, &k8sv1.Contains{})
+		if err != nil {
+			t.Fatalf("failed to get relationships: %v", err)
+		}
+		var names []string
+		for _, rel := range rels {
+			names = append(names, rel.GetObject().GetName())
+		}
+		return names
+	}
+
+	x.Sync([]Resource{disk, part})
+	if s.updates != 2 {
+		t.Errorf("expected 2 updates, got %d", s.updates)
+	}
+	if got := contained(diskRef); len(got) != 1 || got[0] != "node-1/sda1" {
+		t.Errorf("expected the disk to contain node-1/sda1, got %v", got)
+	}
+
+	// Unchanged resources aren't updated
+	x.Sync([]Resource{disk, part})
+	if s.updates != 2 {
+		t.Errorf("expected no updates of unchanged resources, got %d", s.updates-2)
+	}
+
+	// The disk is indexed anew with its new relationship, and the partition with it since
+	// deleting the disk deletes the relationship the partition owns
+	disk.Rels = append(disk.Rels, Relationship{Subject: diskRef, Object: volumeRef, Predicate: contains, Inverse: containedBy})
+	x.Sync([]Resource{disk, part})
+	if s.updates != 4 {
+		t.Errorf("expected the disk and partition to be indexed anew, got %d updates", s.updates-2)
+	}
+	if got := contained(diskRef); len(got) != 2 {
+		t.Errorf("expected the disk to contain the partition and the volume, got %v", got)
+	}
+
+	// Resources missing from a sync are deleted
+	x.Sync([]Resource{disk})
+	if _, err := inv.GetResource(partRef); err == nil {
+		t.Errorf("expected the partition to be deleted")
+	}
+	if got := contained(diskRef); len(got) != 1 || got[0] != "pv-1" {
+		t.Errorf("expected the disk to contain pv-1, got %v", got)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package version holds the build information of the agent binary.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with
+//
+//	-ldflags "-X github.com/antimetal/agent/internal/version.Version=...
+//	          -X github.com/antimetal/agent/internal/version.Commit=...
+//	          -X github.com/antimetal/agent/internal/version.BuildDate=..."
+//
+// Binaries built without them, e.g. with go build or go run, fall back to the VCS
+// information the Go toolchain embeds.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info is the build information of the running agent
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build information of the running agent
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		applyBuildSettings(&info, bi.Settings)
+	}
+	return info
+}
+
+// applyBuildSettings fills the fields that weren't set at build time from the VCS build
+// settings
+func applyBuildSettings(info *Info, settings []debug.BuildSetting) {
+	var revision string
+	modified := false
+	for _, s := range settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			if info.BuildDate == "unknown" {
+				info.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if info.Commit == "unknown" && revision != "" {
+		info.Commit = revision
+		if modified {
+			info.Commit += "-dirty"
+		}
+	}
+}
+
+// String returns the build information in a single line
+func (i Info) String() string {
+	return fmt.Sprintf("agent %s (commit %s, built %s, %s %s)",
+		i.Version, i.Commit, i.BuildDate, i.GoVersion, i.Platform)
+}
+
+// KeysAndValues returns the build information as logr key/value pairs
+func (i Info) KeysAndValues() []any {
+	return []any{
+		"version", i.Version,
+		"commit", i.Commit,
+		"buildDate", i.BuildDate,
+		"goVersion", i.GoVersion,
+		"platform", i.Platform,
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBuildSettings(t *testing.T) {
+	settings := []debug.BuildSetting{
+		{Key: "vcs", Value: "git"},
+		{Key: "vcs.revision", Value: "0123abcd"},
+		{Key: "vcs.time", Value: "2026-01-02T03:04:05Z"},
+		{Key: "vcs.modified", Value: "true"},
+	}
+
+	t.Run("unstamped", func(t *testing.T) {
+		info := Info{Version: "dev", Commit: "unknown", BuildDate: "unknown"}
+		applyBuildSettings(&info, settings)
+		assert.Equal(t, "0123abcd-dirty", info.Commit)
+		assert.Equal(t, "2026-01-02T03:04:05Z", info.BuildDate)
+	})
+
+	t.Run("stamped", func(t *testing.T) {
+		info := Info{Version: "1.2.3", Commit: "fedc9876", BuildDate: "2026-02-03"}
+		applyBuildSettings(&info, settings)
+		assert.Equal(t, "fedc9876", info.Commit)
+		assert.Equal(t, "2026-02-03", info.BuildDate)
+	})
+}
+
+func TestInfo_KeysAndValues(t *testing.T) {
+	kv := Info{Version: "1.2.3", Commit: "abc", BuildDate: "d", GoVersion: "go1.24", Platform: "linux/amd64"}.KeysAndValues()
+	assert.Equal(t, []any{
+		"version", "1.2.3",
+		"commit", "abc",
+		"buildDate", "d",
+		"goVersion", "go1.24",
+		"platform", "linux/amd64",
+	}, kv)
+}
@@ -28,6 +28,10 @@ type Client interface {
 
 	// GetEKSClusterName returns the name of the EKS cluster
 	GetEKSClusterName(ctx context.Context) (string, error)
+
+	// GetInstanceTags returns the tags of the EC2 instance the agent runs on. The instance
+	// must allow access to tags in its instance metadata.
+	GetInstanceTags(ctx context.Context) (map[string]string, error)
 }
 
 var (
@@ -218,6 +222,23 @@ func (c *client) GetEKSClusterName(ctx context.Context) (string, error) {
 	return c.eksClusterName, nil
 }
 
+func (c *client) GetInstanceTags(ctx context.Context) (map[string]string, error) {
+	keys, err := c.getMetadata(ctx, "tags/instance")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get instance tags from IMDS server: %w", err)
+	}
+
+	tags := make(map[string]string)
+	for _, key := range strings.Fields(keys) {
+		value, err := c.getMetadata(ctx, "tags/instance/"+key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get instance tag %s from IMDS server: %w", key, err)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
 func (c *client) getMetadata(ctx context.Context, path string) (string, error) {
 	if c.imdsClient == nil {
 		return "", fmt.Errorf("initialize Client with WithAutoDiscovery")
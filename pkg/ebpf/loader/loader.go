@@ -0,0 +1,321 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package loader owns the lifecycle of the BPF programs and maps of the agent: it loads
+// the compiled objects, attaches their programs, reports their health and tears
+// everything down, so that collectors only describe what they need.
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+
+	agentebpf "github.com/antimetal/agent/pkg/ebpf"
+)
+
+// Tracepoint is a kernel tracepoint, e.g. syscalls/sys_enter_execve
+type Tracepoint struct {
+	Group string
+	Name  string
+	// Optional programs are left detached on kernels without the tracepoint, e.g.
+	// lock/contention_begin before Linux 5.19, rather than failing the object
+	Optional bool
+}
+
+func (t Tracepoint) String() string {
+	return t.Group + "/" + t.Name
+}
+
+// Object describes a compiled BPF object and how its programs are attached
+type Object struct {
+	// Name is the file name of the object, e.g. "execsnoop.bpf.o", looked up with
+	// agentebpf.ObjectPath
+	Name string
+	// Constants sets the global variables of the object before it is loaded, e.g. the
+	// capture limits, so that the verifier prunes the branches they disable
+	Constants map[string]any
+	// Tracepoints are the tracepoints each program is attached to, by program name
+	Tracepoints map[string]Tracepoint
+	// Setup is called, if set, after the maps are created and before any program is
+	// attached, e.g. to populate the maps the programs filter events with
+	Setup func(*ebpf.Collection) error
+}
+
+// Manager loads the BPF objects of the agent. It parses the BTF of the host kernel once
+// for all of them, rather than once per object, and keeps track of the health of every
+// program it attached.
+//
+// The Manager of a host is shared by all the collectors, see Shared.
+type Manager struct {
+	sysPath string
+
+	mu      sync.Mutex
+	btf     *btf.Spec
+	handles map[string]*Handle
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   = make(map[string]*Manager)
+)
+
+// Shared returns the Manager of the host whose /sys is mounted at sysPath, creating it
+// on first use
+func Shared(sysPath string) *Manager {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	m, ok := shared[sysPath]
+	if !ok {
+		m = NewManager(sysPath)
+		shared[sysPath] = m
+	}
+	return m
+}
+
+// NewManager returns a Manager for the host whose /sys is mounted at sysPath
+func NewManager(sysPath string) *Manager {
+	return &Manager{
+		sysPath: sysPath,
+		handles: make(map[string]*Handle),
+	}
+}
+
+// Load loads obj and attaches its programs. An object can only be loaded once at a time;
+// it must be closed before it is loaded again. Whatever was set up is released if it
+// fails.
+//
+// It returns agentebpf.ErrDisabled if eBPF is turned off and an error wrapping
+// agentebpf.ErrUnsupported if the host can't run the object.
+func (m *Manager) Load(obj Object) (*Handle, error) {
+	if err := agentebpf.CheckSupport(m.sysPath); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.handles[obj.Name]; ok {
+		return nil, fmt.Errorf("%s is already loaded", obj.Name)
+	}
+	kernelTypes, err := m.kernelTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := m.load(obj, kernelTypes)
+	if err != nil {
+		return nil, err
+	}
+	m.handles[obj.Name] = h
+	return h, nil
+}
+
+func (m *Manager) load(obj Object, kernelTypes *btf.Spec) (*Handle, error) {
+	path, err := agentebpf.ObjectPath(obj.Name)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ebpf.LoadCollectionSpec(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", obj.Name, err)
+	}
+
+	for name, value := range obj.Constants {
+		v, ok := spec.Variables[name]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not found in %s", name, obj.Name)
+		}
+		if err := v.Set(value); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", name, err)
+		}
+	}
+
+	opts := ebpf.CollectionOptions{
+		Programs: ebpf.ProgramOptions{KernelTypes: kernelTypes},
+	}
+	coll, err := ebpf.NewCollectionWithOptions(spec, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BPF collection of %s: %w", obj.Name, err)
+	}
+	h := &Handle{
+		manager:  m,
+		name:     obj.Name,
+		coll:     coll,
+		programs: make(map[string]*programState, len(obj.Tracepoints)),
+	}
+	if obj.Setup != nil {
+		if err := obj.Setup(coll); err != nil {
+			h.close()
+			return nil, fmt.Errorf("failed to set up %s: %w", obj.Name, err)
+		}
+	}
+
+	for program, tracepoint := range obj.Tracepoints {
+		prog, ok := coll.Programs[program]
+		if !ok {
+			h.close()
+			return nil, fmt.Errorf("program %s not found in %s", program, obj.Name)
+		}
+		l, err := link.Tracepoint(tracepoint.Group, tracepoint.Name, prog, nil)
+		if tracepoint.Optional && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			h.close()
+			return nil, fmt.Errorf("failed to attach to tracepoint %s: %w", tracepoint, err)
+		}
+		h.programs[program] = &programState{
+			prog:       prog,
+			link:       l,
+			attachment: tracepoint.String(),
+			attachedAt: time.Now(),
+		}
+	}
+	return h, nil
+}
+
+// kernelTypes returns the BTF of the host kernel, parsed on first use. It is read from
+// the host's /sys rather than with btf.LoadKernelSpec, which reads that of the /sys of
+// the agent's container. m.mu must be held.
+func (m *Manager) kernelTypes() (*btf.Spec, error) {
+	if m.btf != nil {
+		return m.btf, nil
+	}
+	spec, err := btf.LoadSpec(filepath.Join(m.sysPath, "kernel", "btf", "vmlinux"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kernel BTF: %w", err)
+	}
+	m.btf = spec
+	return spec, nil
+}
+
+// ProgramHealth is the state of a program attached by a Manager
+type ProgramHealth struct {
+	Object     string    `json:"object"`
+	Program    string    `json:"program"`
+	Attachment string    `json:"attachment"` // e.g. syscalls/sys_enter_execve
+	AttachedAt time.Time `json:"attachedAt"`
+	// RunCount and Runtime are how often and how long the program ran, if the kernel
+	// collects BPF statistics (kernel.bpf_stats_enabled)
+	RunCount uint64        `json:"runCount"`
+	Runtime  time.Duration `json:"runtime"`
+	// Error is why the statistics couldn't be read, e.g. the program was unloaded
+	Error string `json:"error,omitempty"`
+}
+
+// Health returns the state of every program attached, sorted by object and program
+func (m *Manager) Health() []ProgramHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var health []ProgramHealth
+	for name, h := range m.handles {
+		for program, state := range h.programs {
+			ph := ProgramHealth{
+				Object:     name,
+				Program:    program,
+				Attachment: state.attachment,
+				AttachedAt: state.attachedAt,
+			}
+			if stats, err := state.prog.Stats(); err != nil {
+				ph.Error = err.Error()
+			} else {
+				ph.RunCount = stats.RunCount
+				ph.Runtime = stats.Runtime
+			}
+			health = append(health, ph)
+		}
+	}
+	slices.SortFunc(health, func(a, b ProgramHealth) int {
+		if c := strings.Compare(a.Object, b.Object); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Program, b.Program)
+	})
+	return health
+}
+
+// Close closes every object still loaded
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	handles := make([]*Handle, 0, len(m.handles))
+	for _, h := range m.handles {
+		handles = append(handles, h)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, h := range handles {
+		errs = append(errs, h.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Handle is a BPF object loaded by a Manager
+type Handle struct {
+	manager  *Manager
+	name     string
+	coll     *ebpf.Collection
+	programs map[string]*programState
+}
+
+type programState struct {
+	prog       *ebpf.Program
+	link       link.Link
+	attachment string
+	attachedAt time.Time
+}
+
+// Map returns the map name of the object
+func (h *Handle) Map(name string) (*ebpf.Map, error) {
+	m, ok := h.coll.Maps[name]
+	if !ok {
+		return nil, fmt.Errorf("map %s not found in %s", name, h.name)
+	}
+	return m, nil
+}
+
+// Attached returns whether program is attached, which optional programs aren't on kernels
+// without their tracepoint
+func (h *Handle) Attached(program string) bool {
+	h.manager.mu.Lock()
+	defer h.manager.mu.Unlock()
+	_, ok := h.programs[program]
+	return ok
+}
+
+// Close detaches the programs of the object and releases its maps
+func (h *Handle) Close() error {
+	h.manager.mu.Lock()
+	defer h.manager.mu.Unlock()
+	if h.manager.handles[h.name] == h {
+		delete(h.manager.handles, h.name)
+	}
+	return h.close()
+}
+
+func (h *Handle) close() error {
+	var errs []error
+	for program, state := range h.programs {
+		if err := state.link.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to detach %s: %w", program, err))
+		}
+	}
+	h.programs = nil
+	if h.coll != nil {
+		h.coll.Close()
+		h.coll = nil
+	}
+	return errors.Join(errs...)
+}
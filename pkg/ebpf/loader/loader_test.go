@@ -0,0 +1,43 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package loader
+
+import (
+	"errors"
+	"testing"
+
+	agentebpf "github.com/antimetal/agent/pkg/ebpf"
+)
+
+func TestManager_LoadUnsupported(t *testing.T) {
+	m := NewManager(t.TempDir())
+	obj := Object{Name: "probe.bpf.o"}
+
+	// The temporary /sys has no kernel BTF
+	if _, err := m.Load(obj); !errors.Is(err, agentebpf.ErrUnsupported) {
+		t.Fatalf("Load() = %v, want ErrUnsupported", err)
+	}
+
+	agentebpf.SetEnabled(false)
+	t.Cleanup(func() { agentebpf.SetEnabled(true) })
+	if _, err := m.Load(obj); !errors.Is(err, agentebpf.ErrDisabled) {
+		t.Fatalf("Load() = %v, want ErrDisabled", err)
+	}
+	if health := m.Health(); len(health) != 0 {
+		t.Fatalf("Health() = %v, want no programs", health)
+	}
+}
+
+func TestShared(t *testing.T) {
+	sysPath := t.TempDir()
+	if Shared(sysPath) != Shared(sysPath) {
+		t.Fatal("Shared() should return the same Manager for the same /sys")
+	}
+	if Shared(sysPath) == Shared(t.TempDir()) {
+		t.Fatal("Shared() should return a Manager per /sys")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package ebpf locates the compiled BPF objects for the running architecture and checks
+// whether the host kernel can run them. Loading and attaching the objects is left to
+// pkg/ebpf/loader, so that this package doesn't depend on a BPF library.
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+)
+
+const (
+	// ObjectPathEnv overrides the directory the compiled BPF objects are loaded from
+	ObjectPathEnv = "ANTIMETAL_BPF_PATH"
+
+	// DefaultObjectPath is where the BPF objects are installed in the agent image
+	DefaultObjectPath = "/usr/local/lib/antimetal/ebpf"
+)
+
+// ErrUnsupported is returned when eBPF collectors can't run on this host's architecture
+// or kernel.
+var ErrUnsupported = errors.New("eBPF is unsupported on this architecture or kernel")
+
+// ErrDisabled is returned when eBPF is turned off with SetEnabled
+var ErrDisabled = errors.New("eBPF is disabled")
+
+// disabled is the global switch of SetEnabled. eBPF is enabled by default.
+var disabled atomic.Bool
+
+// SetEnabled turns eBPF on or off for the whole agent. While it is off CheckSupport
+// returns ErrDisabled, so the collectors requiring eBPF are skipped, and no BPF program
+// is loaded.
+func SetEnabled(enabled bool) {
+	disabled.Store(!enabled)
+}
+
+// Enabled returns whether eBPF is turned on, see SetEnabled
+func Enabled() bool {
+	return !disabled.Load()
+}
+
+// bpfArchs maps GOARCH to the architecture name used for __TARGET_ARCH_* when compiling
+// the BPF objects. Only these architectures have objects built for them.
+var bpfArchs = map[string]string{
+	"amd64": "x86",
+	"arm64": "arm64",
+}
+
+// Arch returns the BPF target architecture of the running binary, or "" if no BPF
+// objects are built for it.
+func Arch() string {
+	return bpfArchs[runtime.GOARCH]
+}
+
+// CheckSupport returns an error wrapping ErrUnsupported if BPF objects aren't built for
+// the running architecture or the kernel doesn't expose BTF type information, which the
+// CO-RE objects need to be relocated. sysPath is the path of the host's /sys. It returns
+// ErrDisabled if eBPF is turned off.
+func CheckSupport(sysPath string) error {
+	if !Enabled() {
+		return ErrDisabled
+	}
+	return checkSupport(runtime.GOARCH, sysPath)
+}
+
+func checkSupport(goarch, sysPath string) error {
+	if _, ok := bpfArchs[goarch]; !ok {
+		return fmt.Errorf("%w: no BPF objects are built for %s", ErrUnsupported, goarch)
+	}
+
+	btf := filepath.Join(sysPath, "kernel", "btf", "vmlinux")
+	if _, err := os.Stat(btf); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: kernel BTF not found at %s, the kernel must be built with CONFIG_DEBUG_INFO_BTF",
+				ErrUnsupported, btf)
+		}
+		return fmt.Errorf("failed to check kernel BTF: %w", err)
+	}
+	return nil
+}
+
+// ObjectPath returns the path of the compiled BPF object name (e.g. "execsnoop.bpf.o")
+// for the running architecture.
+//
+// Objects are looked up in the <GOARCH> subdirectory of the object directory first, which
+// is how multi-arch builds are laid out, and then in the object directory itself for
+// builds of a single architecture. The object directory is ObjectPathEnv if set and
+// DefaultObjectPath otherwise.
+func ObjectPath(name string) (string, error) {
+	dir := os.Getenv(ObjectPathEnv)
+	if dir == "" {
+		dir = DefaultObjectPath
+	}
+	return objectPath(dir, runtime.GOARCH, name)
+}
+
+func objectPath(dir, goarch, name string) (string, error) {
+	if _, ok := bpfArchs[goarch]; !ok {
+		return "", fmt.Errorf("%w: no BPF objects are built for %s", ErrUnsupported, goarch)
+	}
+
+	candidates := []string{
+		filepath.Join(dir, goarch, name),
+		filepath.Join(dir, name),
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("BPF object %s for %s not found in %s", name, goarch, dir)
+}
@@ -0,0 +1,106 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package ebpf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestCheckSupport(t *testing.T) {
+	withBTF := t.TempDir()
+	writeFile(t, filepath.Join(withBTF, "kernel", "btf", "vmlinux"))
+	withoutBTF := t.TempDir()
+
+	tests := []struct {
+		name        string
+		goarch      string
+		sysPath     string
+		unsupported bool
+	}{
+		{name: "amd64 with BTF", goarch: "amd64", sysPath: withBTF},
+		{name: "arm64 with BTF", goarch: "arm64", sysPath: withBTF},
+		{name: "no BTF", goarch: "arm64", sysPath: withoutBTF, unsupported: true},
+		{name: "unsupported arch", goarch: "riscv64", sysPath: withBTF, unsupported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSupport(tt.goarch, tt.sysPath)
+			if tt.unsupported != errors.Is(err, ErrUnsupported) {
+				t.Fatalf("checkSupport() = %v, want unsupported = %t", err, tt.unsupported)
+			}
+			if !tt.unsupported && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "amd64", "probe.bpf.o"))
+	writeFile(t, filepath.Join(dir, "arm64", "probe.bpf.o"))
+	writeFile(t, filepath.Join(dir, "legacy.bpf.o"))
+
+	tests := []struct {
+		name    string
+		goarch  string
+		object  string
+		want    string
+		wantErr bool
+	}{
+		{name: "amd64 object", goarch: "amd64", object: "probe.bpf.o", want: filepath.Join(dir, "amd64", "probe.bpf.o")},
+		{name: "arm64 object", goarch: "arm64", object: "probe.bpf.o", want: filepath.Join(dir, "arm64", "probe.bpf.o")},
+		{name: "single arch build", goarch: "arm64", object: "legacy.bpf.o", want: filepath.Join(dir, "legacy.bpf.o")},
+		{name: "missing object", goarch: "amd64", object: "missing.bpf.o", wantErr: true},
+		{name: "unsupported arch", goarch: "s390x", object: "probe.bpf.o", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := objectPath(dir, tt.goarch, tt.object)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got path %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("objectPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	t.Cleanup(func() { SetEnabled(true) })
+	sysPath := t.TempDir()
+	writeFile(t, filepath.Join(sysPath, "kernel", "btf", "vmlinux"))
+
+	SetEnabled(false)
+	if err := CheckSupport(sysPath); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("CheckSupport() = %v, want ErrDisabled", err)
+	}
+	SetEnabled(true)
+	if err := CheckSupport(sysPath); errors.Is(err, ErrDisabled) {
+		t.Fatalf("CheckSupport() = %v after enabling eBPF", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package enrich attaches tags from node-local metadata, e.g. the datacenter, rack or team
+// a node belongs to, to the resources and metrics the agent produces, so that fleet owners
+// can slice the data by their own dimensions.
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultRefreshInterval is how often tags are reloaded from their sources
+const DefaultRefreshInterval = 5 * time.Minute
+
+// Source provides tags from node-local metadata
+type Source interface {
+	// Name identifies the source in logs
+	Name() string
+	// Tags returns the current tags of the source
+	Tags(ctx context.Context) (map[string]string, error)
+}
+
+// Enricher merges the tags of its sources and keeps them up to date. When several sources
+// set the same tag, the source listed last wins.
+type Enricher struct {
+	sources  []Source
+	interval time.Duration
+	logger   logr.Logger
+
+	mu sync.RWMutex
+	// Last tags loaded from each source, kept when a refresh of the source fails
+	bySource []map[string]string
+	tags     map[string]string
+}
+
+// NewEnricher creates an enricher reloading the tags of sources every interval. If interval
+// is 0, DefaultRefreshInterval is used.
+func NewEnricher(logger logr.Logger, interval time.Duration, sources ...Source) *Enricher {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	return &Enricher{
+		sources:  sources,
+		interval: interval,
+		logger:   logger,
+		bySource: make([]map[string]string, len(sources)),
+		tags:     map[string]string{},
+	}
+}
+
+// Tags returns the current tags. The returned map must not be modified.
+func (e *Enricher) Tags() map[string]string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.tags
+}
+
+// Refresh reloads the tags of all sources. A source that fails keeps its previous tags;
+// the errors of all failed sources are returned.
+func (e *Enricher) Refresh(ctx context.Context) error {
+	var errs []error
+	loaded := make([]map[string]string, len(e.sources))
+	for i, source := range e.sources {
+		tags, err := source.Tags(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load tags from %s: %w", source.Name(), err))
+			continue
+		}
+		loaded[i] = tags
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	merged := make(map[string]string)
+	for i := range e.sources {
+		if loaded[i] != nil {
+			e.bySource[i] = loaded[i]
+		}
+		maps.Copy(merged, e.bySource[i])
+	}
+	// Replace rather than update the map, so that callers of Tags can keep using theirs
+	e.tags = merged
+
+	return errors.Join(errs...)
+}
+
+// Start refreshes the tags every refresh interval until ctx is done. Call Refresh first
+// to have the tags loaded before Start runs.
+func (e *Enricher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.Refresh(ctx); err != nil {
+				e.logger.Error(err, "failed to refresh tags")
+			}
+		}
+	}
+}
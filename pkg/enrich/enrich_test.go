@@ -0,0 +1,120 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package enrich_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/antimetal/agent/pkg/enrich"
+)
+
+type fakeInstanceTags struct {
+	tags map[string]string
+	err  error
+}
+
+func (f *fakeInstanceTags) GetInstanceTags(context.Context) (map[string]string, error) {
+	return f.tags, f.err
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags")
+	content := "# placement\ndatacenter = dc2\n\nrack=r12\nempty=\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := enrich.FileSource{Path: path}.Tags(context.Background())
+	if err != nil {
+		t.Fatalf("Tags() failed: %v", err)
+	}
+	expected := map[string]string{"datacenter": "dc2", "rack": "r12", "empty": ""}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Tags() = %v, want %v", tags, expected)
+	}
+
+	if err := os.WriteFile(path, []byte("rack=r12\nnot a tag\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (enrich.FileSource{Path: path}).Tags(context.Background()); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := enrich.ParseTags("customer=acme, env = prod,,empty=,customer=other")
+	if err != nil {
+		t.Fatalf("ParseTags() failed: %v", err)
+	}
+	expected := map[string]string{"customer": "other", "env": "prod", "empty": ""}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("ParseTags() = %v, want %v", tags, expected)
+	}
+
+	for _, s := range []string{"customer", "=acme", "customer=acme,env"} {
+		if _, err := enrich.ParseTags(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	t.Setenv("TEST_TAG_TEAM", "storage")
+	t.Setenv("TEST_TAG_", "ignored")
+	t.Setenv("OTHER_TEAM", "ignored")
+	tags, err := enrich.EnvSource{Prefix: "TEST_TAG_"}.Tags(context.Background())
+	if err != nil {
+		t.Fatalf("Tags() failed: %v", err)
+	}
+	if expected := map[string]string{"team": "storage"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Tags() = %v, want %v", tags, expected)
+	}
+}
+
+func TestEnricher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags")
+	if err := os.WriteFile(path, []byte("datacenter=dc1\nteam=file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ec2 := &fakeInstanceTags{tags: map[string]string{"team": "ec2", "env": "prod"}}
+	e := enrich.NewEnricher(logr.Discard(), 0, enrich.FileSource{Path: path}, enrich.EC2Source{Client: ec2})
+
+	if len(e.Tags()) != 0 {
+		t.Errorf("expected no tags before the first refresh, got %v", e.Tags())
+	}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() failed: %v", err)
+	}
+	// The source listed last wins
+	expected := map[string]string{"datacenter": "dc1", "team": "ec2", "env": "prod"}
+	if !reflect.DeepEqual(e.Tags(), expected) {
+		t.Errorf("Tags() = %v, want %v", e.Tags(), expected)
+	}
+
+	// A failing source keeps its previous tags
+	previous := e.Tags()
+	ec2.err = errors.New("IMDS unavailable")
+	if err := os.WriteFile(path, []byte("datacenter=dc2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Error("expected the error of the failed source")
+	}
+	expected = map[string]string{"datacenter": "dc2", "team": "ec2", "env": "prod"}
+	if !reflect.DeepEqual(e.Tags(), expected) {
+		t.Errorf("Tags() = %v, want %v", e.Tags(), expected)
+	}
+	if previous["datacenter"] != "dc1" {
+		t.Error("refresh modified tags returned before it")
+	}
+}
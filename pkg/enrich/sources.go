@@ -0,0 +1,136 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package enrich
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+)
+
+// FileSource reads tags from a file with one key=value pair per line. Empty lines and lines
+// starting with # are ignored.
+//
+//	# Placement of the node
+//	datacenter=us-east-dc2
+//	rack=r12
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Name() string {
+	return "file " + s.Path
+}
+
+func (s FileSource) Tags(_ context.Context) (map[string]string, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key=value, got %q", n, line)
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// ParseTags parses a comma-separated list of key=value tags, e.g. customer=acme,env=prod.
+// Keys and values are trimmed of surrounding spaces, and later tags override earlier ones.
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for pair := range strings.SplitSeq(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// StaticSource provides tags that don't change, e.g. set on the command line
+type StaticSource map[string]string
+
+func (s StaticSource) Name() string {
+	return "static tags"
+}
+
+func (s StaticSource) Tags(_ context.Context) (map[string]string, error) {
+	return maps.Clone(map[string]string(s)), nil
+}
+
+// EnvSource reads tags from the environment variables starting with Prefix. The tag key is
+// the rest of the variable name in lower case, e.g. with the prefix AGENT_TAG_ the
+// variable AGENT_TAG_TEAM=storage sets the tag team=storage.
+type EnvSource struct {
+	Prefix string
+}
+
+func (s EnvSource) Name() string {
+	return "environment variables " + s.Prefix + "*"
+}
+
+func (s EnvSource) Tags(_ context.Context) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		key, ok := strings.CutPrefix(name, s.Prefix)
+		if !ok || key == "" {
+			continue
+		}
+		tags[strings.ToLower(key)] = value
+	}
+	return tags, nil
+}
+
+// InstanceTagsClient returns the tags of the cloud instance the agent runs on
+type InstanceTagsClient interface {
+	GetInstanceTags(ctx context.Context) (map[string]string, error)
+}
+
+// EC2Source reads the tags of the EC2 instance the agent runs on from the instance metadata
+// service. The instance must allow access to its tags in the instance metadata.
+type EC2Source struct {
+	Client InstanceTagsClient
+}
+
+func (s EC2Source) Name() string {
+	return "EC2 instance tags"
+}
+
+func (s EC2Source) Tags(ctx context.Context) (map[string]string, error) {
+	tags, err := s.Client.GetInstanceTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	return tags, nil
+}
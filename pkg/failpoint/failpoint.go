@@ -0,0 +1,216 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package failpoint injects failures at named points of the agent, so that operators and
+// developers can check that alerting and the degraded modes of the agent work without
+// breaking a real dependency, e.g. that a failing collector is reported degraded or that
+// the intake stream reconnects.
+//
+// Failpoints are set with a spec of ;-separated name=term pairs, where a term is an
+// action optionally preceded by the percentage of the calls it applies to:
+//
+//	collector/cpu=error;store/write=10%error(disk full);intake/send=delay(5s)
+//
+// The actions are error or error(message), returning an error wrapping ErrInjected,
+// delay(duration), sleeping before going on, panic or panic(message), and off, clearing
+// the failpoint. Names are those of the points calling Inject: Collector(metricType),
+// StoreWrite and IntakeSend. A failpoint nothing checks is accepted and never fires.
+//
+// Nothing is injected unless failpoints are set, and checking a failpoint then costs an
+// atomic load.
+package failpoint
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Failpoints checked by the agent
+const (
+	// StoreWrite fails the writes to the resource store: adds, updates, deletes and
+	// relationships
+	StoreWrite = "store/write"
+	// IntakeSend fails sending a batch of deltas on an intake stream, which resets the
+	// stream
+	IntakeSend = "intake/send"
+)
+
+// Collector returns the failpoint failing the Collect calls of the point collector, or the
+// Start calls of the continuous collector, of metricType, e.g. collector/cpu
+func Collector(metricType string) string {
+	return "collector/" + metricType
+}
+
+// ErrInjected is wrapped by the errors of failpoints
+var ErrInjected = errors.New("injected failure")
+
+var injectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "antimetal_failpoint_injections_total",
+	Help: "Number of failures injected by failpoints, by failpoint.",
+}, []string{"failpoint"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(injectionsTotal)
+}
+
+type action string
+
+const (
+	actionOff   action = "off"
+	actionError action = "error"
+	actionDelay action = "delay"
+	actionPanic action = "panic"
+)
+
+// term is what a failpoint does when it fires
+type term struct {
+	spec    string
+	action  action
+	percent float64
+	message string
+	delay   time.Duration
+}
+
+var (
+	mu     sync.RWMutex
+	active = make(map[string]*term)
+	// enabled is whether any failpoint is set, checked without taking mu
+	enabled atomic.Bool
+)
+
+// Set parses spec and sets its failpoints, replacing the terms of failpoints set before.
+// Failpoints spec doesn't name are kept. Nothing is set if spec is invalid.
+func Set(spec string) error {
+	terms := make(map[string]*term)
+	for pair := range strings.SplitSeq(spec, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("invalid failpoint %q: expected name=term", pair)
+		}
+		t, err := parseTerm(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid failpoint %s: %w", name, err)
+		}
+		terms[name] = t
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for name, t := range terms {
+		if t.action == actionOff {
+			delete(active, name)
+		} else {
+			active[name] = t
+		}
+	}
+	enabled.Store(len(active) > 0)
+	return nil
+}
+
+// Reset clears all failpoints
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	clear(active)
+	enabled.Store(false)
+}
+
+// List returns the failpoints set as name=term pairs, sorted by name
+func List() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]string, 0, len(active))
+	for name, t := range active {
+		list = append(list, name+"="+t.spec)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// Inject fires the failpoint name if it is set: it returns an error wrapping ErrInjected,
+// sleeps or panics. It returns nil if the failpoint isn't set, doesn't fire this time or
+// only delays.
+func Inject(name string) error {
+	if !enabled.Load() {
+		return nil
+	}
+	mu.RLock()
+	t := active[name]
+	mu.RUnlock()
+	if t == nil || (t.percent < 100 && rand.Float64()*100 >= t.percent) {
+		return nil
+	}
+
+	injectionsTotal.WithLabelValues(name).Inc()
+	switch t.action {
+	case actionDelay:
+		time.Sleep(t.delay)
+	case actionPanic:
+		panic(fmt.Sprintf("failpoint %s: %s", name, cmp.Or(t.message, ErrInjected.Error())))
+	case actionError:
+		if t.message == "" {
+			return fmt.Errorf("failpoint %s: %w", name, ErrInjected)
+		}
+		return fmt.Errorf("failpoint %s: %w: %s", name, ErrInjected, t.message)
+	}
+	return nil
+}
+
+// parseTerm parses [percent%]action[(argument)], e.g. 10%error(disk full)
+func parseTerm(spec string) (*term, error) {
+	t := &term{spec: spec, percent: 100}
+	s := spec
+	// A % in the argument isn't a percentage
+	if i := strings.IndexByte(s, '%'); i >= 0 && !strings.Contains(s[:i], "(") {
+		percent, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid percentage %q: must be in (0, 100]", s[:i])
+		}
+		t.percent, s = percent, s[i+1:]
+	}
+
+	name, arg, hasArg := strings.Cut(s, "(")
+	if hasArg {
+		var ok bool
+		if arg, ok = strings.CutSuffix(arg, ")"); !ok {
+			return nil, fmt.Errorf("invalid term %q: missing )", spec)
+		}
+	}
+	t.action = action(name)
+	switch t.action {
+	case actionOff:
+		if hasArg {
+			return nil, fmt.Errorf("invalid term %q: off takes no argument", spec)
+		}
+	case actionError, actionPanic:
+		t.message = arg
+	case actionDelay:
+		delay, err := time.ParseDuration(arg)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid delay %q: must be a non-negative duration", arg)
+		}
+		t.delay = delay
+	default:
+		return nil, fmt.Errorf("unknown action %q: must be error, delay, panic or off", name)
+	}
+	return t, nil
+}
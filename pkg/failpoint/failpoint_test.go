@@ -0,0 +1,143 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package failpoint
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInject(t *testing.T) {
+	t.Cleanup(Reset)
+
+	if err := Inject(StoreWrite); err != nil {
+		t.Fatalf("expected no failure without failpoints, got %v", err)
+	}
+
+	if err := Set("store/write=error; collector/cpu=error(disk full);intake/send=delay(20ms)"); err != nil {
+		t.Fatalf("failed to set failpoints: %v", err)
+	}
+	err := Inject(StoreWrite)
+	if !errors.Is(err, ErrInjected) || err.Error() != "failpoint store/write: injected failure" {
+		t.Errorf("expected an injected failure, got %v", err)
+	}
+	err = Inject(Collector("cpu"))
+	if !errors.Is(err, ErrInjected) || !strings.HasSuffix(err.Error(), ": disk full") {
+		t.Errorf("expected an injected failure with a message, got %v", err)
+	}
+	if err := Inject(Collector("memory")); err != nil {
+		t.Errorf("expected no failure of a failpoint that isn't set, got %v", err)
+	}
+	start := time.Now()
+	if err := Inject(IntakeSend); err != nil {
+		t.Errorf("expected a delay to not fail, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected a delay of 20ms, got %v", elapsed)
+	}
+	if got := testutil.ToFloat64(injectionsTotal.WithLabelValues(StoreWrite)); got != 1 {
+		t.Errorf("expected 1 injection, got %v", got)
+	}
+
+	func() {
+		if err := Set("store/write=panic"); err != nil {
+			t.Fatalf("failed to set failpoint: %v", err)
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		_ = Inject(StoreWrite)
+	}()
+
+	if err := Set("store/write=off"); err != nil {
+		t.Fatalf("failed to clear failpoint: %v", err)
+	}
+	if err := Inject(StoreWrite); err != nil {
+		t.Errorf("expected no failure once cleared, got %v", err)
+	}
+	expected := []string{"collector/cpu=error(disk full)", "intake/send=delay(20ms)"}
+	if list := List(); strings.Join(list, ";") != strings.Join(expected, ";") {
+		t.Errorf("expected failpoints %v, got %v", expected, list)
+	}
+
+	Reset()
+	if err := Inject(Collector("cpu")); err != nil || len(List()) != 0 {
+		t.Errorf("expected no failpoints after a reset, got %v %v", err, List())
+	}
+}
+
+func TestInject_Percent(t *testing.T) {
+	t.Cleanup(Reset)
+	if err := Set("store/write=50%error(50% full)"); err != nil {
+		t.Fatalf("failed to set failpoint: %v", err)
+	}
+	failed := 0
+	for range 1000 {
+		if err := Inject(StoreWrite); err != nil {
+			failed++
+		}
+	}
+	if failed < 350 || failed > 650 {
+		t.Errorf("expected about half of the calls to fail, got %d", failed)
+	}
+}
+
+func TestSet_Invalid(t *testing.T) {
+	t.Cleanup(Reset)
+	for _, spec := range []string{
+		"store/write",
+		"=error",
+		"store/write=explode",
+		"store/write=error(unterminated",
+		"store/write=0%error",
+		"store/write=150%error",
+		"store/write=delay",
+		"store/write=delay(-1s)",
+		"store/write=off(now)",
+		"store/write=error;intake/send=nope",
+	} {
+		if err := Set(spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+	if list := List(); len(list) != 0 {
+		t.Errorf("expected invalid specs to set nothing, got %v", list)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Cleanup(Reset)
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest(method, "/debug/failpoints", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := serve(http.MethodPut, "collector/cpu=error;store/write=delay(1ms)"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(http.MethodGet, ""); rec.Body.String() != "collector/cpu=error\nstore/write=delay(1ms)\n" {
+		t.Errorf("unexpected failpoints %q", rec.Body)
+	}
+	if rec := serve(http.MethodPut, "collector/cpu=nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid spec, got %d", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, ""); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("expected no failpoints after DELETE, got %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(http.MethodPost, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package failpoint
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxSpecSize bounds the body of requests setting failpoints
+const maxSpecSize = 64 << 10
+
+// Handler serves the failpoints: GET lists them, one name=term pair per line, PUT sets
+// the failpoints of the spec in the request body, and DELETE clears them all
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			spec, err := io.ReadAll(io.LimitReader(r.Body, maxSpecSize))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := Set(string(spec)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			Reset()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if list := List(); len(list) > 0 {
+			fmt.Fprintln(w, strings.Join(list, "\n"))
+		}
+	})
+}
@@ -0,0 +1,239 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package argpolicy decides what is kept of the arguments of processes, the argv of execs
+// traced by the exec collector and the /proc/[pid]/cmdline of inspected processes.
+// Arguments are invaluable to debug what a node runs, and just as often carry secrets
+// such as passwords and tokens passed on the command line.
+//
+// A policy is a list of rules, the first rule matching a process deciding its action:
+// capture the arguments as is, truncate each argument, hash them or drop them. Rules
+// select processes by Kubernetes namespace, UID and task name, and may only keep the
+// arguments of a sample of the processes they match.
+//
+// A rule is written as
+//
+//	<action>[:<max length>] [namespace=<ns>,...] [uid=<uid>,...] [command=<name>,...] [sample=<rate>]
+//
+// e.g. "drop namespace=payments", "truncate:32 uid=0" or "capture command=java sample=0.1".
+package argpolicy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Action is what a rule does with the arguments of the processes it matches
+type Action string
+
+const (
+	// ActionCapture keeps the arguments as is
+	ActionCapture Action = "capture"
+	// ActionTruncate keeps the first MaxLength bytes of each argument
+	ActionTruncate Action = "truncate"
+	// ActionHash replaces each argument with the prefix of its SHA-256, so that runs of
+	// the same command line can still be told apart and grouped
+	ActionHash Action = "hash"
+	// ActionDrop leaves out the arguments
+	ActionDrop Action = "drop"
+)
+
+// hashPrefix is prepended to hashed arguments
+const hashPrefix = "sha256:"
+
+// defaultMaxLength is the length arguments are truncated to if a truncate rule has none
+const defaultMaxLength = 16
+
+// Rule selects processes and the action applied to their arguments. Empty selectors
+// match every process.
+type Rule struct {
+	Action Action
+	// MaxLength is the number of bytes kept of each argument by ActionTruncate
+	MaxLength int
+	// Namespaces are Kubernetes namespaces. They only match if the policy has a namespace
+	// resolver.
+	Namespaces []string
+	UIDs       []uint32
+	// Commands are task names, e.g. the Command of a performance.ProcessExecEvent
+	Commands []string
+	// SampleRate is the fraction of the matching processes whose arguments are kept, the
+	// arguments of the others are dropped. 0 keeps them all.
+	SampleRate float64
+}
+
+// Parse parses a rule
+func Parse(rule string) (Rule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return Rule{}, fmt.Errorf("empty argument policy rule")
+	}
+
+	var r Rule
+	action, maxLength, hasMax := strings.Cut(fields[0], ":")
+	r.Action = Action(action)
+	switch r.Action {
+	case ActionCapture, ActionHash, ActionDrop:
+		if hasMax {
+			return Rule{}, fmt.Errorf("rule %q: only truncate takes a maximum length", rule)
+		}
+	case ActionTruncate:
+		r.MaxLength = defaultMaxLength
+		if hasMax {
+			n, err := strconv.Atoi(maxLength)
+			if err != nil || n <= 0 {
+				return Rule{}, fmt.Errorf("rule %q: invalid maximum length %q", rule, maxLength)
+			}
+			r.MaxLength = n
+		}
+	default:
+		return Rule{}, fmt.Errorf("rule %q: unknown action %q, must be capture, truncate, hash or drop", rule, action)
+	}
+
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("rule %q: expected key=value, got %q", rule, field)
+		}
+		values := strings.Split(value, ",")
+		switch key {
+		case "namespace":
+			r.Namespaces = append(r.Namespaces, values...)
+		case "uid":
+			for _, v := range values {
+				uid, err := strconv.ParseUint(v, 10, 32)
+				if err != nil {
+					return Rule{}, fmt.Errorf("rule %q: invalid uid %q", rule, v)
+				}
+				r.UIDs = append(r.UIDs, uint32(uid))
+			}
+		case "command":
+			r.Commands = append(r.Commands, values...)
+		case "sample":
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return Rule{}, fmt.Errorf("rule %q: sample rate must be in (0, 1], got %q", rule, value)
+			}
+			r.SampleRate = rate
+		default:
+			return Rule{}, fmt.Errorf("rule %q: unknown selector %q, must be namespace, uid, command or sample", rule, key)
+		}
+	}
+	return r, nil
+}
+
+// Process identifies the process whose arguments a policy is applied to
+type Process struct {
+	PID     int32
+	UID     uint32
+	Command string
+}
+
+// Policy applies the first matching rule to the arguments of a process, or its default
+// rule if none matches. A nil *Policy captures all arguments.
+type Policy struct {
+	rules       []Rule
+	fallback    Rule
+	namespaceOf func(pid int32) string
+	sample      func() float64
+}
+
+// Option configures a Policy
+type Option func(*Policy)
+
+// WithNamespaceResolver sets the function returning the Kubernetes namespace of a
+// process, empty if it doesn't run in a pod, that namespace selectors match against
+func WithNamespaceResolver(fn func(pid int32) string) Option {
+	return func(p *Policy) {
+		p.namespaceOf = fn
+	}
+}
+
+// New returns a policy applying rules, and fallback to the processes none of them matches.
+// The selectors of fallback are ignored.
+func New(rules []Rule, fallback Rule, opts ...Option) *Policy {
+	p := &Policy{
+		rules:    rules,
+		fallback: Rule{Action: fallback.Action, MaxLength: fallback.MaxLength, SampleRate: fallback.SampleRate},
+		sample:   rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Apply returns what is kept of the arguments args of proc and the action that was
+// applied. The returned arguments are nil when they are dropped.
+func (p *Policy) Apply(proc Process, args []string) ([]string, Action) {
+	if p == nil {
+		return args, ActionCapture
+	}
+	rule := p.match(proc)
+	if rule.SampleRate > 0 && rule.SampleRate < 1 && p.sample() >= rule.SampleRate {
+		return nil, ActionDrop
+	}
+
+	switch rule.Action {
+	case ActionDrop:
+		return nil, ActionDrop
+	case ActionTruncate:
+		kept := make([]string, len(args))
+		for i, arg := range args {
+			kept[i] = truncate(arg, rule.MaxLength)
+		}
+		return kept, ActionTruncate
+	case ActionHash:
+		hashed := make([]string, len(args))
+		for i, arg := range args {
+			sum := sha256.Sum256([]byte(arg))
+			hashed[i] = hashPrefix + hex.EncodeToString(sum[:8])
+		}
+		return hashed, ActionHash
+	}
+	return args, ActionCapture
+}
+
+func (p *Policy) match(proc Process) Rule {
+	namespace, resolved := "", false
+	for _, rule := range p.rules {
+		if len(rule.UIDs) > 0 && !slices.Contains(rule.UIDs, proc.UID) {
+			continue
+		}
+		if len(rule.Commands) > 0 && !slices.Contains(rule.Commands, proc.Command) {
+			continue
+		}
+		if len(rule.Namespaces) > 0 {
+			if p.namespaceOf == nil {
+				continue
+			}
+			// Resolved at most once, and only if a rule needs it
+			if !resolved {
+				namespace, resolved = p.namespaceOf(proc.PID), true
+			}
+			if !slices.Contains(rule.Namespaces, namespace) {
+				continue
+			}
+		}
+		return rule
+	}
+	return p.fallback
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
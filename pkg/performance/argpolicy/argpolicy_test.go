@@ -0,0 +1,144 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package argpolicy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    Rule
+		wantErr bool
+	}{
+		{rule: "capture", want: Rule{Action: ActionCapture}},
+		{rule: "truncate", want: Rule{Action: ActionTruncate, MaxLength: defaultMaxLength}},
+		{
+			rule: "truncate:32 uid=0,1000 command=java",
+			want: Rule{Action: ActionTruncate, MaxLength: 32, UIDs: []uint32{0, 1000}, Commands: []string{"java"}},
+		},
+		{
+			rule: "drop namespace=payments,vault sample=0.5",
+			want: Rule{Action: ActionDrop, Namespaces: []string{"payments", "vault"}, SampleRate: 0.5},
+		},
+		{rule: "", wantErr: true},
+		{rule: "redact", wantErr: true},
+		{rule: "hash:8", wantErr: true},
+		{rule: "truncate:0", wantErr: true},
+		{rule: "drop uid=root", wantErr: true},
+		{rule: "drop sample=2", wantErr: true},
+		{rule: "drop pod=web", wantErr: true},
+		{rule: "drop namespace", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.rule)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q): expected an error, got %+v", tt.rule, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, rule string) Rule {
+	t.Helper()
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("failed to parse rule: %v", err)
+	}
+	return r
+}
+
+func TestPolicy_Apply(t *testing.T) {
+	namespaces := map[int32]string{1: "payments", 2: "web"}
+	resolved := 0
+	policy := New([]Rule{
+		mustParse(t, "drop namespace=payments"),
+		mustParse(t, "hash uid=1000"),
+		mustParse(t, "capture command=java"),
+	}, mustParse(t, "truncate:4"), WithNamespaceResolver(func(pid int32) string {
+		resolved++
+		return namespaces[pid]
+	}))
+	args := []string{"--password=hunter2", "abcé"}
+
+	tests := []struct {
+		name       string
+		proc       Process
+		want       []string
+		wantAction Action
+	}{
+		{"namespace", Process{PID: 1, Command: "java"}, nil, ActionDrop},
+		{"uid", Process{PID: 2, UID: 1000, Command: "java"}, nil, ActionHash},
+		{"command", Process{PID: 2, Command: "java"}, args, ActionCapture},
+		// The é is 2 bytes long and isn't split
+		{"fallback", Process{PID: 3, Command: "sh"}, []string{"--pa", "abc"}, ActionTruncate},
+	}
+	for _, tt := range tests {
+		got, action := policy.Apply(tt.proc, args)
+		if action != tt.wantAction {
+			t.Errorf("%s: expected action %s, got %s", tt.name, tt.wantAction, action)
+		}
+		if tt.wantAction == ActionHash {
+			if len(got) != len(args) || !strings.HasPrefix(got[0], hashPrefix) || got[0] == got[1] {
+				t.Errorf("%s: expected distinct hashes, got %v", tt.name, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+	if resolved != len(tests) {
+		t.Errorf("expected the namespace to be resolved once per process, got %d times", resolved)
+	}
+
+	// Hashes are stable
+	first, _ := policy.Apply(Process{UID: 1000}, args)
+	second, _ := policy.Apply(Process{UID: 1000}, args)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected stable hashes, got %v and %v", first, second)
+	}
+}
+
+func TestPolicy_Sample(t *testing.T) {
+	policy := New(nil, mustParse(t, "capture sample=0.25"))
+	draws := []float64{0.1, 0.3, 0.24, 0.9}
+	policy.sample = func() float64 {
+		v := draws[0]
+		draws = draws[1:]
+		return v
+	}
+	var kept int
+	for range 4 {
+		if args, _ := policy.Apply(Process{}, []string{"a"}); args != nil {
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Errorf("expected the arguments of 2 processes to be kept, got %d", kept)
+	}
+}
+
+func TestPolicy_Nil(t *testing.T) {
+	var policy *Policy
+	args := []string{"a"}
+	if got, action := policy.Apply(Process{}, args); action != ActionCapture || !reflect.DeepEqual(got, args) {
+		t.Errorf("expected a nil policy to capture, got %v %s", got, action)
+	}
+}
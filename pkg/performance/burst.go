@@ -0,0 +1,156 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Burst is a series of CPU and process samples taken at sub-second resolution after the
+// utilization of all CPUs crossed CollectionConfig.BurstThreshold
+type Burst struct {
+	Start time.Time
+	// Utilization of all CPUs in the snapshot that triggered the burst
+	Utilization float64
+	Interval    time.Duration
+	Samples     []BurstSample
+}
+
+// BurstSample is a sample of a burst. CPU utilizations and process CPU percentages cover
+// the time since the previous sample.
+type BurstSample struct {
+	Timestamp time.Time
+	CPU       []CPUStats
+	Processes []ProcessStats
+}
+
+// RegisterBurstCollector registers collector to be sampled during bursts. Only CPU and
+// process collectors can be registered. They must be dedicated instances, not registered
+// with RegisterPointCollector, so that sampling them during bursts doesn't shorten the
+// period the rates of snapshots cover.
+func (m *Manager) RegisterBurstCollector(collector PointCollector) error {
+	switch collector.Type() {
+	case MetricTypeCPU, MetricTypeProcess:
+	default:
+		return fmt.Errorf("collector for metric type %s can't be sampled during bursts", collector.Type())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.burstCollectors {
+		if c.Type() == collector.Type() {
+			return fmt.Errorf("burst collector for metric type %s already registered", collector.Type())
+		}
+	}
+	m.burstCollectors = append(m.burstCollectors, collector)
+	return nil
+}
+
+// takeBurst returns the last burst that ended and wasn't returned yet, if any
+func (m *Manager) takeBurst() *Burst {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	burst := m.burst
+	m.burst = nil
+	return burst
+}
+
+// maybeStartBurst starts a burst in the background if the utilization of all CPUs in
+// snapshot is at least the burst threshold and no burst is running
+func (m *Manager) maybeStartBurst(ctx context.Context, snapshot *Snapshot) {
+	if m.config.BurstThreshold <= 0 {
+		return
+	}
+	utilization, ok := totalUtilization(snapshot.Metrics.CPU)
+	if !ok || utilization < m.config.BurstThreshold {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bursting || len(m.burstCollectors) == 0 {
+		return
+	}
+	m.bursting = true
+	m.logger.V(1).Info("CPU utilization above burst threshold, sampling at high resolution",
+		"utilization", utilization, "threshold", m.config.BurstThreshold,
+		"interval", m.config.BurstInterval, "duration", m.config.BurstDuration)
+	go m.runBurst(ctx, utilization, m.burstCollectors)
+}
+
+// runBurst samples collectors every burst interval for the burst duration. The burst is
+// returned by the next call of takeBurst, unless ctx is done before it ends.
+func (m *Manager) runBurst(ctx context.Context, utilization float64, collectors []PointCollector) {
+	defer func() {
+		m.mu.Lock()
+		m.bursting = false
+		m.mu.Unlock()
+	}()
+
+	// The collectors' rates would cover the time since the previous burst otherwise
+	m.sampleBurst(ctx, collectors)
+
+	burst := &Burst{
+		Start:       time.Now(),
+		Utilization: utilization,
+		Interval:    m.config.BurstInterval,
+	}
+	ticker := time.NewTicker(m.config.BurstInterval)
+	defer ticker.Stop()
+	end := time.NewTimer(m.config.BurstDuration)
+	defer end.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-end.C:
+			m.mu.Lock()
+			m.burst = burst
+			m.mu.Unlock()
+			return
+		case <-ticker.C:
+			if m.throttle != nil && m.throttle.Wait(ctx) != nil {
+				return
+			}
+			burst.Samples = append(burst.Samples, m.sampleBurst(ctx, collectors))
+		}
+	}
+}
+
+// sampleBurst collects a burst sample. Collectors that fail are left out of the sample.
+func (m *Manager) sampleBurst(ctx context.Context, collectors []PointCollector) BurstSample {
+	ctx, cancel := context.WithTimeout(WithCollection(ctx, m.host, nil), m.config.CollectorTimeout)
+	defer cancel()
+
+	sample := BurstSample{Timestamp: time.Now()}
+	for _, collector := range collectors {
+		data, err := InstrumentedCollect(ctx, collector)
+		if err != nil {
+			m.logger.V(1).Info("burst collector failed", "type", collector.Type(), "error", err.Error())
+			continue
+		}
+		switch v := data.(type) {
+		case []CPUStats:
+			sample.CPU = v
+		case []ProcessStats:
+			sample.Processes = v
+		}
+	}
+	return sample
+}
+
+// totalUtilization returns the utilization of all CPUs, reported by the aggregate entry
+func totalUtilization(cpus []CPUStats) (float64, bool) {
+	for _, cpu := range cpus {
+		if cpu.CPUIndex == -1 {
+			return cpu.Utilization, true
+		}
+	}
+	return 0, false
+}
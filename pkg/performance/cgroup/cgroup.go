@@ -0,0 +1,555 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package cgroup reads the CPU, memory and IO accounting of control groups, hiding the
+// difference between the cgroup v2 unified hierarchy and the cgroup v1 per-controller
+// hierarchies (cpu, cpuacct, memory and blkio) used by older distributions and hosts in
+// hybrid mode. Both versions are reported in the same units.
+//
+// Reference: https://docs.kernel.org/admin-guide/cgroup-v2.html
+// Reference: https://docs.kernel.org/admin-guide/cgroup-v1/index.html
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance/procparse"
+)
+
+// Version is the cgroup version of a host
+type Version int
+
+const (
+	V1 Version = 1
+	V2 Version = 2
+)
+
+func (v Version) String() string {
+	return fmt.Sprintf("v%d", int(v))
+}
+
+// userHZ is the unit of the times in cpuacct.stat. It is fixed at 100 on all architectures
+// the agent runs on.
+const userHZ = 100
+
+// cpuHierarchies are the names of the v1 hierarchy of the cpu controller, which is usually
+// co-mounted with cpuacct
+var cpuHierarchies = []string{"cpu", "cpu,cpuacct", "cpuacct,cpu"}
+
+// v1Unlimited is the lowest memory.limit_in_bytes reported for an unlimited cgroup v1. The
+// kernel reports PAGE_COUNTER_MAX rounded down to the page size, which depends on the
+// architecture, so anything this large counts as unlimited.
+const v1Unlimited = math.MaxInt64 / 2
+
+// ErrNotFound is returned when a cgroup doesn't exist
+var ErrNotFound = errors.New("cgroup not found")
+
+// Stats is the resource accounting of a cgroup. Fields of controllers that aren't enabled
+// for the cgroup are zero.
+type Stats struct {
+	Path   string // Path of the cgroup relative to the hierarchy root, e.g. /kubepods/pod1234
+	CPU    CPUStats
+	Memory MemoryStats
+	IO     []IOStats // Per block device, ordered as reported by the kernel
+}
+
+// CPUStats is the CPU usage and CFS bandwidth throttling of a cgroup
+type CPUStats struct {
+	// Usage in microseconds from cpu.stat (v2) or cpuacct.usage and cpuacct.stat (v1).
+	// User and System have a resolution of 10ms on v1.
+	UsageUsec  uint64
+	UserUsec   uint64
+	SystemUsec uint64
+	// Bandwidth enforcement from cpu.stat, zero if no CPU limit is set
+	Periods          uint64 // Enforcement intervals that elapsed
+	ThrottledPeriods uint64 // Intervals in which the cgroup was throttled
+	ThrottledUsec    uint64 // Total time throttled
+}
+
+// MemoryStats is the memory usage of a cgroup
+type MemoryStats struct {
+	UsageBytes uint64 // memory.current (v2) or memory.usage_in_bytes (v1)
+	LimitBytes uint64 // memory.max (v2) or memory.limit_in_bytes (v1), 0 if unlimited
+	// From memory.stat
+	AnonBytes uint64 // anon (v2) or total_rss (v1)
+	FileBytes uint64 // file (v2) or total_cache (v1)
+	// OOM kills in the cgroup from memory.events (v2) or memory.oom_control (v1, kernel 4.13+)
+	OOMKills uint64
+}
+
+// CPULimit is the CFS bandwidth limit and cpuset of a cgroup
+type CPULimit struct {
+	// CPU time the cgroup may use per period, from cpu.max (v2) or cpu.cfs_quota_us and
+	// cpu.cfs_period_us (v1). QuotaUsec is 0 if unlimited.
+	QuotaUsec  uint64
+	PeriodUsec uint64
+	// CPUs the cgroup may run on as a cpulist, e.g. 0-3,8, from cpuset.cpus.effective
+	// (v2) or cpuset.effective_cpus (v1). Empty if the cpuset controller isn't enabled.
+	CPUs string
+}
+
+// IOStats is the IO of a cgroup on a block device
+type IOStats struct {
+	Major      uint32
+	Minor      uint32
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadOps    uint64
+	WriteOps   uint64
+}
+
+// Reader reads cgroup stats from the cgroup filesystem of a host
+type Reader struct {
+	root    string
+	version Version
+}
+
+// NewReader detects the cgroup version of the host whose sysfs is mounted at sysPath.
+// Hosts in hybrid mode mount the unified hierarchy next to the v1 controllers, which only
+// holds the controllers not bound to v1, so they are read as v1.
+func NewReader(sysPath string) (*Reader, error) {
+	root := filepath.Join(sysPath, "fs", "cgroup")
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return &Reader{root: root, version: V2}, nil
+	}
+	for _, controller := range []string{"memory", "cpuacct", "cpu,cpuacct", "blkio"} {
+		if _, err := os.Stat(filepath.Join(root, controller)); err == nil {
+			return &Reader{root: root, version: V1}, nil
+		}
+	}
+	return nil, fmt.Errorf("no cgroup hierarchy found at %s", root)
+}
+
+// Version returns the cgroup version of the host
+func (r *Reader) Version() Version {
+	return r.version
+}
+
+// ProcessCgroup returns the cgroup of the process with pid, read from the process's cgroup
+// file under procPath. On v1 hosts it is the cgroup of the memory controller, which
+// container runtimes place in the same path as the other controllers.
+func (r *Reader) ProcessCgroup(procPath string, pid int) (string, error) {
+	file, err := os.Open(filepath.Join(procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var fallback string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if r.version == V2 {
+			if parts[0] == "0" && parts[1] == "" {
+				return parts[2], nil
+			}
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			switch controller {
+			case "memory":
+				return parts[2], nil
+			case "cpuacct":
+				fallback = parts[2]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("no %s cgroup for process %d", r.version, pid)
+}
+
+// PodUID returns the UID of the pod whose cgroup contains cgroupPath. The systemd cgroup
+// driver replaces the dashes of the UID with underscores.
+func PodUID(cgroupPath string) (string, bool) {
+	for _, dir := range strings.Split(cgroupPath, "/") {
+		dir = strings.TrimSuffix(dir, ".slice")
+		i := strings.LastIndex(dir, "pod")
+		if i < 0 || (i > 0 && dir[i-1] != '-') {
+			continue
+		}
+		uid := strings.ReplaceAll(dir[i+len("pod"):], "_", "-")
+		if len(uid) == 36 {
+			return uid, true
+		}
+	}
+	return "", false
+}
+
+// ContainerID returns the ID of the container whose cgroup is cgroupPath, the last
+// directory of the path: the ID itself with the cgroupfs driver, or a scope such as
+// cri-containerd-<id>.scope, crio-<id>.scope or docker-<id>.scope with the systemd driver
+func ContainerID(cgroupPath string) (string, bool) {
+	dir := strings.TrimSuffix(filepath.Base(cgroupPath), ".scope")
+	if i := strings.LastIndex(dir, "-"); i >= 0 {
+		dir = dir[i+1:]
+	}
+	if len(dir) != 64 {
+		return "", false
+	}
+	for _, c := range dir {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", false
+		}
+	}
+	return dir, true
+}
+
+// Stats reads the stats of the cgroup at path, relative to the hierarchy root. It returns
+// ErrNotFound if the cgroup doesn't exist in any hierarchy.
+func (r *Reader) Stats(path string) (*Stats, error) {
+	path = filepath.Join("/", path)
+	if r.version == V2 {
+		return r.statsV2(path)
+	}
+	return r.statsV1(path)
+}
+
+func (r *Reader) statsV2(path string) (*Stats, error) {
+	dir := filepath.Join(r.root, path)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	stats := &Stats{Path: path}
+
+	stats.CPU = cpuV2(dir)
+
+	stats.Memory.UsageBytes, _ = procparse.ReadUintFile(filepath.Join(dir, "memory.current"))
+	// memory.max is "max" if unlimited, which fails to parse and is left at 0
+	stats.Memory.LimitBytes, _ = procparse.ReadUintFile(filepath.Join(dir, "memory.max"))
+	if mem, err := procparse.ParseKVFile(filepath.Join(dir, "memory.stat")); err == nil {
+		stats.Memory.AnonBytes = mem["anon"]
+		stats.Memory.FileBytes = mem["file"]
+	}
+	if events, err := procparse.ParseKVFile(filepath.Join(dir, "memory.events")); err == nil {
+		stats.Memory.OOMKills = events["oom_kill"]
+	}
+
+	if io, err := readIOStatV2(filepath.Join(dir, "io.stat")); err == nil {
+		stats.IO = io
+	}
+	return stats, nil
+}
+
+func (r *Reader) statsV1(path string) (*Stats, error) {
+	stats := &Stats{Path: path}
+	var found bool
+	stats.CPU, found = r.cpuV1(path)
+
+	if dir, ok := r.controllerDir(path, "memory"); ok {
+		found = true
+		stats.Memory.UsageBytes, _ = procparse.ReadUintFile(filepath.Join(dir, "memory.usage_in_bytes"))
+		if limit, err := procparse.ReadUintFile(filepath.Join(dir, "memory.limit_in_bytes")); err == nil && limit < v1Unlimited {
+			stats.Memory.LimitBytes = limit
+		}
+		// The total_ fields include the descendants of the cgroup like the v2 fields do
+		if mem, err := procparse.ParseKVFile(filepath.Join(dir, "memory.stat")); err == nil {
+			stats.Memory.AnonBytes = mem["total_rss"]
+			stats.Memory.FileBytes = mem["total_cache"]
+		}
+		if oom, err := procparse.ParseKVFile(filepath.Join(dir, "memory.oom_control")); err == nil {
+			stats.Memory.OOMKills = oom["oom_kill"]
+		}
+	}
+
+	if dir, ok := r.controllerDir(path, "blkio"); ok {
+		found = true
+		// The throttle files are updated with the blk-mq schedulers, unlike the CFQ only
+		// blkio.io_service_bytes and blkio.io_serviced
+		stats.IO = readIOStatV1(
+			filepath.Join(dir, "blkio.throttle.io_service_bytes"),
+			filepath.Join(dir, "blkio.throttle.io_serviced"),
+		)
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+	return stats, nil
+}
+
+func cpuV2(dir string) CPUStats {
+	cpu, err := procparse.ParseKVFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return CPUStats{}
+	}
+	return CPUStats{
+		UsageUsec:        cpu["usage_usec"],
+		UserUsec:         cpu["user_usec"],
+		SystemUsec:       cpu["system_usec"],
+		Periods:          cpu["nr_periods"],
+		ThrottledPeriods: cpu["nr_throttled"],
+		ThrottledUsec:    cpu["throttled_usec"],
+	}
+}
+
+// cpuV1 reads the CPU stats of the cgroup at path from the cpuacct and cpu hierarchies,
+// and returns whether it exists in either
+func (r *Reader) cpuV1(path string) (CPUStats, bool) {
+	var stats CPUStats
+	found := false
+	if dir, ok := r.controllerDir(path, "cpuacct", "cpu,cpuacct", "cpuacct,cpu"); ok {
+		found = true
+		if usage, err := procparse.ReadUintFile(filepath.Join(dir, "cpuacct.usage")); err == nil {
+			stats.UsageUsec = usage / 1000
+		}
+		if cpu, err := procparse.ParseKVFile(filepath.Join(dir, "cpuacct.stat")); err == nil {
+			stats.UserUsec = cpu["user"] * (1e6 / userHZ)
+			stats.SystemUsec = cpu["system"] * (1e6 / userHZ)
+		}
+	}
+	if dir, ok := r.controllerDir(path, cpuHierarchies...); ok {
+		found = true
+		if cpu, err := procparse.ParseKVFile(filepath.Join(dir, "cpu.stat")); err == nil {
+			stats.Periods = cpu["nr_periods"]
+			stats.ThrottledPeriods = cpu["nr_throttled"]
+			stats.ThrottledUsec = cpu["throttled_time"] / 1000
+		}
+	}
+	return stats, found
+}
+
+// CPU reads the CPU stats of the cgroup at path, relative to the hierarchy root, without
+// the memory and IO stats Stats reads. It returns ErrNotFound if the cgroup doesn't exist.
+func (r *Reader) CPU(path string) (CPUStats, error) {
+	path = filepath.Join("/", path)
+	if r.version == V1 {
+		stats, found := r.cpuV1(path)
+		if !found {
+			return CPUStats{}, ErrNotFound
+		}
+		return stats, nil
+	}
+	dir := filepath.Join(r.root, path)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return CPUStats{}, ErrNotFound
+		}
+		return CPUStats{}, err
+	}
+	return cpuV2(dir), nil
+}
+
+// CPULimit reads the CFS bandwidth limit and the cpuset of the cgroup at path, relative to
+// the hierarchy root. Limits that aren't set, or whose controller isn't enabled for the
+// cgroup, are zero. It returns ErrNotFound if the cgroup doesn't exist.
+func (r *Reader) CPULimit(path string) (CPULimit, error) {
+	path = filepath.Join("/", path)
+	var limit CPULimit
+	if r.version == V1 {
+		dir, ok := r.controllerDir(path, cpuHierarchies...)
+		if !ok {
+			return CPULimit{}, ErrNotFound
+		}
+		// cpu.cfs_quota_us is -1 if unlimited, which fails to parse and is left at 0
+		limit.QuotaUsec, _ = procparse.ReadUintFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+		limit.PeriodUsec, _ = procparse.ReadUintFile(filepath.Join(dir, "cpu.cfs_period_us"))
+		if dir, ok := r.controllerDir(path, "cpuset"); ok {
+			limit.CPUs = procparse.ReadStringFile(filepath.Join(dir, "cpuset.effective_cpus"))
+		}
+		return limit, nil
+	}
+
+	dir := filepath.Join(r.root, path)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return CPULimit{}, ErrNotFound
+		}
+		return CPULimit{}, err
+	}
+	// "$MAX $PERIOD", where $MAX is "max" if unlimited
+	if fields := strings.Fields(procparse.ReadStringFile(filepath.Join(dir, "cpu.max"))); len(fields) == 2 {
+		limit.QuotaUsec, _ = procparse.ParseUint(fields[0])
+		limit.PeriodUsec, _ = procparse.ParseUint(fields[1])
+	}
+	limit.CPUs = procparse.ReadStringFile(filepath.Join(dir, "cpuset.cpus.effective"))
+	return limit, nil
+}
+
+// CPUCgroups returns the paths of all cgroups in the hierarchy of the cpu controller,
+// relative to its root, the root being /. Cgroups removed while the hierarchy is walked
+// are left out.
+func (r *Reader) CPUCgroups() ([]string, error) {
+	root := r.root
+	if r.version == V1 {
+		dir, ok := r.controllerDir("/", cpuHierarchies...)
+		if !ok {
+			return nil, fmt.Errorf("no cpu cgroup hierarchy found at %s", r.root)
+		}
+		root = dir
+	}
+
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.Join("/", rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk the cgroup hierarchy: %w", err)
+	}
+	return paths, nil
+}
+
+// controllerDir returns the directory of the cgroup at path in the first v1 hierarchy of
+// names that contains it. Co-mounted controllers are usually symlinked to their combined
+// hierarchy, but not on all distributions, so the combined names are tried as well.
+func (r *Reader) controllerDir(path string, names ...string) (string, bool) {
+	for _, name := range names {
+		dir := filepath.Join(r.root, name, path)
+		if _, err := os.Stat(dir); err == nil {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// parseDevice parses a major:minor device number
+func parseDevice(s string) (major, minor uint32, ok bool) {
+	majorStr, minorStr, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, false
+	}
+	ma, err := strconv.ParseUint(majorStr, 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	mi, err := strconv.ParseUint(minorStr, 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint32(ma), uint32(mi), true
+}
+
+// readIOStatV2 reads io.stat, a nested keyed file of lines like
+// "8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0"
+func readIOStatV2(path string) ([]IOStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var stats []IOStats
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		major, minor, ok := parseDevice(fields[0])
+		if !ok {
+			continue
+		}
+		dev := IOStats{Major: major, Minor: minor}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				dev.ReadBytes = v
+			case "wbytes":
+				dev.WriteBytes = v
+			case "rios":
+				dev.ReadOps = v
+			case "wios":
+				dev.WriteOps = v
+			}
+		}
+		stats = append(stats, dev)
+	}
+	return stats, scanner.Err()
+}
+
+// readIOStatV1 merges the blkio byte and operation counts, files of lines like
+// "8:0 Read 1024" followed by a "Total" line
+func readIOStatV1(bytesPath, opsPath string) []IOStats {
+	var stats []IOStats
+	index := make(map[[2]uint32]int)
+	read := func(path string, set func(dev *IOStats, op string, v uint64)) {
+		file, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				continue
+			}
+			major, minor, ok := parseDevice(fields[0])
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			key := [2]uint32{major, minor}
+			i, ok := index[key]
+			if !ok {
+				i = len(stats)
+				index[key] = i
+				stats = append(stats, IOStats{Major: major, Minor: minor})
+			}
+			set(&stats[i], fields[1], v)
+		}
+	}
+
+	read(bytesPath, func(dev *IOStats, op string, v uint64) {
+		switch op {
+		case "Read":
+			dev.ReadBytes = v
+		case "Write":
+			dev.WriteBytes = v
+		}
+	})
+	read(opsPath, func(dev *IOStats, op string, v uint64) {
+		switch op {
+		case "Read":
+			dev.ReadOps = v
+		case "Write":
+			dev.WriteOps = v
+		}
+	})
+	return stats
+}
@@ -0,0 +1,334 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package cgroup_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+)
+
+const podPath = "/kubepods/burstable/pod1234/abcd"
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+// expectedStats is what both the v1 and v2 fixtures report
+var expectedStats = &cgroup.Stats{
+	Path: podPath,
+	CPU: cgroup.CPUStats{
+		UsageUsec:        1500000,
+		UserUsec:         1000000,
+		SystemUsec:       500000,
+		Periods:          100,
+		ThrottledPeriods: 7,
+		ThrottledUsec:    250000,
+	},
+	Memory: cgroup.MemoryStats{
+		UsageBytes: 104857600,
+		LimitBytes: 268435456,
+		AnonBytes:  73400320,
+		FileBytes:  31457280,
+		OOMKills:   2,
+	},
+	IO: []cgroup.IOStats{
+		{Major: 8, Minor: 0, ReadBytes: 4096, WriteBytes: 8192, ReadOps: 1, WriteOps: 2},
+		{Major: 259, Minor: 0, ReadBytes: 1024, ReadOps: 3},
+	},
+}
+
+func TestReader_V2(t *testing.T) {
+	sys := t.TempDir()
+	dir := filepath.Join("fs", "cgroup", podPath)
+	writeFiles(t, sys, map[string]string{
+		"fs/cgroup/cgroup.controllers": "cpuset cpu io memory pids\n",
+		dir + "/cpu.stat": `usage_usec 1500000
+user_usec 1000000
+system_usec 500000
+nr_periods 100
+nr_throttled 7
+throttled_usec 250000
+`,
+		dir + "/memory.current": "104857600\n",
+		dir + "/memory.max":     "268435456\n",
+		dir + "/memory.stat":    "anon 73400320\nfile 31457280\nkernel 1024\n",
+		dir + "/memory.events":  "low 0\nhigh 0\nmax 5\noom 2\noom_kill 2\n",
+		dir + "/io.stat": `8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0
+259:0 rbytes=1024 wbytes=0 rios=3 wios=0 dbytes=0 dios=0
+`,
+	})
+
+	r, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.V2, r.Version())
+
+	stats, err := r.Stats(podPath)
+	require.NoError(t, err)
+	assert.Equal(t, expectedStats, stats)
+
+	_, err = r.Stats("/missing")
+	assert.ErrorIs(t, err, cgroup.ErrNotFound)
+}
+
+func TestReader_V2Unlimited(t *testing.T) {
+	sys := t.TempDir()
+	writeFiles(t, sys, map[string]string{
+		"fs/cgroup/cgroup.controllers": "cpu memory\n",
+		"fs/cgroup/app/memory.current": "1024\n",
+		"fs/cgroup/app/memory.max":     "max\n",
+	})
+
+	r, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+	stats, err := r.Stats("app")
+	require.NoError(t, err)
+	assert.Equal(t, "/app", stats.Path)
+	assert.Equal(t, uint64(1024), stats.Memory.UsageBytes)
+	assert.Zero(t, stats.Memory.LimitBytes)
+	assert.Empty(t, stats.IO)
+}
+
+func TestReader_V1(t *testing.T) {
+	sys := t.TempDir()
+	cpu := filepath.Join("fs", "cgroup", "cpu,cpuacct", podPath)
+	memory := filepath.Join("fs", "cgroup", "memory", podPath)
+	blkio := filepath.Join("fs", "cgroup", "blkio", podPath)
+	writeFiles(t, sys, map[string]string{
+		// hybrid mode: the unified hierarchy holds no controllers
+		"fs/cgroup/unified/cgroup.procs":  "",
+		cpu + "/cpuacct.usage":            "1500000000\n",
+		cpu + "/cpuacct.stat":             "user 100\nsystem 50\n",
+		cpu + "/cpu.stat":                 "nr_periods 100\nnr_throttled 7\nthrottled_time 250000000\n",
+		memory + "/memory.usage_in_bytes": "104857600\n",
+		memory + "/memory.limit_in_bytes": "268435456\n",
+		memory + "/memory.stat": `cache 1048576
+rss 1048576
+total_cache 31457280
+total_rss 73400320
+`,
+		memory + "/memory.oom_control": "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+		blkio + "/blkio.throttle.io_service_bytes": `8:0 Read 4096
+8:0 Write 8192
+8:0 Sync 0
+8:0 Async 12288
+8:0 Total 12288
+259:0 Read 1024
+259:0 Write 0
+259:0 Total 1024
+Total 13312
+`,
+		blkio + "/blkio.throttle.io_serviced": `8:0 Read 1
+8:0 Write 2
+8:0 Total 3
+259:0 Read 3
+259:0 Write 0
+259:0 Total 3
+Total 6
+`,
+	})
+
+	r, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.V1, r.Version())
+
+	stats, err := r.Stats(podPath)
+	require.NoError(t, err)
+	assert.Equal(t, expectedStats, stats)
+
+	_, err = r.Stats("/missing")
+	assert.ErrorIs(t, err, cgroup.ErrNotFound)
+}
+
+func TestReader_V1Unlimited(t *testing.T) {
+	sys := t.TempDir()
+	writeFiles(t, sys, map[string]string{
+		"fs/cgroup/memory/app/memory.usage_in_bytes": "1024\n",
+		"fs/cgroup/memory/app/memory.limit_in_bytes": "9223372036854771712\n",
+	})
+
+	r, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+	stats, err := r.Stats("/app")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024), stats.Memory.UsageBytes)
+	assert.Zero(t, stats.Memory.LimitBytes)
+}
+
+func TestNewReader_NoHierarchy(t *testing.T) {
+	_, err := cgroup.NewReader(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestReader_ProcessCgroup(t *testing.T) {
+	proc := t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"1/cgroup": "0::/system.slice/containerd.service\n",
+		"2/cgroup": `12:blkio:/kubepods/pod1
+4:memory:/kubepods/pod1/abcd
+3:cpu,cpuacct:/kubepods/pod1/abcd
+1:name=systemd:/kubepods/pod1/abcd
+0::/
+`,
+		"3/cgroup": "3:cpu,cpuacct:/user.slice\n",
+	})
+
+	sysV2 := t.TempDir()
+	writeFiles(t, sysV2, map[string]string{"fs/cgroup/cgroup.controllers": ""})
+	v2, err := cgroup.NewReader(sysV2)
+	require.NoError(t, err)
+
+	sysV1 := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(sysV1, "fs", "cgroup", "memory"), 0755))
+	v1, err := cgroup.NewReader(sysV1)
+	require.NoError(t, err)
+
+	path, err := v2.ProcessCgroup(proc, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "/system.slice/containerd.service", path)
+
+	path, err = v1.ProcessCgroup(proc, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "/kubepods/pod1/abcd", path)
+
+	path, err = v1.ProcessCgroup(proc, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "/user.slice", path)
+
+	_, err = v2.ProcessCgroup(proc, 3)
+	assert.Error(t, err)
+
+	_, err = v2.ProcessCgroup(proc, 4)
+	assert.Error(t, err)
+}
+
+func TestPodUID(t *testing.T) {
+	const uid = "0b8f1c2d-3e4f-5a6b-7c8d-9e0f1a2b3c4d"
+	tests := []struct {
+		cgroup string
+		uid    string
+	}{
+		{cgroup: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0b8f1c2d_3e4f_5a6b_7c8d_9e0f1a2b3c4d.slice/cri-containerd-abcd.scope", uid: uid},
+		{cgroup: "/kubepods/burstable/pod" + uid + "/abcd", uid: uid},
+		{cgroup: "/kubepods/burstable/pod1234/abcd"},
+		{cgroup: "/system.slice/sshd.service"},
+	}
+	for _, tt := range tests {
+		got, ok := cgroup.PodUID(tt.cgroup)
+		assert.Equal(t, tt.uid, got, "PodUID(%q)", tt.cgroup)
+		assert.Equal(t, tt.uid != "", ok, "PodUID(%q)", tt.cgroup)
+	}
+}
+
+func TestReader_CPUV2(t *testing.T) {
+	sys := t.TempDir()
+	writeFiles(t, sys, map[string]string{
+		"fs/cgroup/cgroup.controllers":                  "cpuset cpu io memory pids\n",
+		"fs/cgroup/cpu.stat":                            "usage_usec 9000000\n",
+		"fs/cgroup/kubepods/cpu.max":                    "max 100000\n",
+		"fs/cgroup/kubepods/pod1/cpu.max":               "50000 100000\n",
+		"fs/cgroup/kubepods/pod1/cpuset.cpus.effective": "0-3,8\n",
+		"fs/cgroup/kubepods/pod1/cpu.stat": `usage_usec 1500000
+nr_periods 100
+nr_throttled 7
+throttled_usec 250000
+`,
+	})
+
+	r, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+
+	paths, err := r.CPUCgroups()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/kubepods", "/kubepods/pod1"}, paths)
+
+	cpu, err := r.CPU("/kubepods/pod1")
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.CPUStats{UsageUsec: 1500000, Periods: 100, ThrottledPeriods: 7, ThrottledUsec: 250000}, cpu)
+
+	limit, err := r.CPULimit("/kubepods/pod1")
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.CPULimit{QuotaUsec: 50000, PeriodUsec: 100000, CPUs: "0-3,8"}, limit)
+
+	limit, err = r.CPULimit("/kubepods")
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.CPULimit{PeriodUsec: 100000}, limit)
+
+	_, err = r.CPU("/missing")
+	assert.ErrorIs(t, err, cgroup.ErrNotFound)
+	_, err = r.CPULimit("/missing")
+	assert.ErrorIs(t, err, cgroup.ErrNotFound)
+}
+
+func TestReader_CPUV1(t *testing.T) {
+	sys := t.TempDir()
+	cpu := filepath.Join("fs", "cgroup", "cpu,cpuacct")
+	writeFiles(t, sys, map[string]string{
+		cpu + "/cpuacct.usage":                                 "9000000000\n",
+		cpu + "/kubepods/cpu.cfs_quota_us":                     "-1\n",
+		cpu + "/kubepods/cpu.cfs_period_us":                    "100000\n",
+		cpu + "/kubepods/pod1/cpu.cfs_quota_us":                "50000\n",
+		cpu + "/kubepods/pod1/cpu.cfs_period_us":               "100000\n",
+		cpu + "/kubepods/pod1/cpu.stat":                        "nr_periods 100\nnr_throttled 7\nthrottled_time 250000000\n",
+		"fs/cgroup/cpuset/kubepods/pod1/cpuset.effective_cpus": "0-1\n",
+		// other hierarchies aren't walked
+		"fs/cgroup/memory/other/memory.usage_in_bytes": "1024\n",
+	})
+
+	r, err := cgroup.NewReader(sys)
+	require.NoError(t, err)
+
+	paths, err := r.CPUCgroups()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/kubepods", "/kubepods/pod1"}, paths)
+
+	stats, err := r.CPU("/kubepods/pod1")
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.CPUStats{Periods: 100, ThrottledPeriods: 7, ThrottledUsec: 250000}, stats)
+
+	limit, err := r.CPULimit("/kubepods/pod1")
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.CPULimit{QuotaUsec: 50000, PeriodUsec: 100000, CPUs: "0-1"}, limit)
+
+	limit, err = r.CPULimit("/kubepods")
+	require.NoError(t, err)
+	assert.Equal(t, cgroup.CPULimit{PeriodUsec: 100000}, limit)
+
+	_, err = r.CPU("/missing")
+	assert.ErrorIs(t, err, cgroup.ErrNotFound)
+}
+
+func TestContainerID(t *testing.T) {
+	const id = "4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b"
+	tests := []struct {
+		cgroup string
+		id     string
+	}{
+		{cgroup: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-" + id + ".scope", id: id},
+		{cgroup: "/kubepods.slice/kubepods-pod1234.slice/crio-" + id + ".scope", id: id},
+		{cgroup: "/system.slice/docker-" + id + ".scope", id: id},
+		{cgroup: "/kubepods/burstable/pod1234/" + id, id: id},
+		{cgroup: "/kubepods/burstable/pod1234"},
+		{cgroup: "/kubepods/burstable/pod1234/abcd"},
+		{cgroup: "/system.slice/sshd.service"},
+	}
+	for _, tt := range tests {
+		got, ok := cgroup.ContainerID(tt.cgroup)
+		assert.Equal(t, tt.id, got, "ContainerID(%q)", tt.cgroup)
+		assert.Equal(t, tt.id != "", ok, "ContainerID(%q)", tt.cgroup)
+	}
+}
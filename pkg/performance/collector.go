@@ -9,6 +9,8 @@ package performance
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/go-logr/logr"
 )
@@ -50,7 +52,9 @@ type CollectorCapabilities struct {
 	MinKernelVersion   string
 }
 
-// BaseCollector provides common functionality for all collectors
+// BaseCollector provides common functionality for all collectors. Their Collect and
+// Start calls are timed and counted by InstrumentedCollect and InstrumentedStart, so
+// collectors don't record metrics themselves.
 type BaseCollector struct {
 	metricType   MetricType
 	name         string
@@ -150,10 +154,42 @@ func (r *CollectorRegistry) RegisterPoint(collector PointCollector) error {
 	}
 
 	r.pointCollectors[metricType] = collector
+	if cycle := r.dependencyCycle(metricType); cycle != nil {
+		delete(r.pointCollectors, metricType)
+		return fmt.Errorf("point collector for metric type %s has a dependency cycle: %v", metricType, cycle)
+	}
 	r.logger.Info("registered point collector", "type", metricType, "name", collector.Name())
 	return nil
 }
 
+// dependencyCycle returns the metric types of a cycle of the dependencies of the point
+// collectors leading back to metricType, or nil if there is none
+func (r *CollectorRegistry) dependencyCycle(metricType MetricType) []MetricType {
+	visited := make(map[MetricType]bool)
+	var visit func(path []MetricType) []MetricType
+	visit = func(path []MetricType) []MetricType {
+		current := path[len(path)-1]
+		collector, ok := r.pointCollectors[current]
+		if !ok {
+			return nil
+		}
+		for _, dep := range dependencies(collector) {
+			if dep == metricType {
+				return append(path, dep)
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if cycle := visit(append(slices.Clip(path), dep)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return visit([]MetricType{metricType})
+}
+
 func (r *CollectorRegistry) RegisterContinuous(collector ContinuousCollector) error {
 	if collector == nil {
 		return fmt.Errorf("cannot register nil collector")
@@ -206,6 +242,41 @@ func (r *CollectorRegistry) GetEnabledPoint(config CollectionConfig) []PointColl
 	return enabled
 }
 
+// GetOrderedPoint returns the enabled point collectors in the order they run: in metric
+// type order, except that collectors run after the collectors they depend on
+func (r *CollectorRegistry) GetOrderedPoint(config CollectionConfig) []PointCollector {
+	pending := r.GetEnabledPoint(config)
+	slices.SortFunc(pending, func(a, b PointCollector) int {
+		return strings.Compare(string(a.Type()), string(b.Type()))
+	})
+	enabled := make(map[MetricType]bool, len(pending))
+	for _, collector := range pending {
+		enabled[collector.Type()] = true
+	}
+
+	// Registration rejects cycles, so a collector is always ready
+	ordered := make([]PointCollector, 0, len(pending))
+	done := make(map[MetricType]bool, len(pending))
+	for len(pending) > 0 {
+		for i, collector := range pending {
+			ready := true
+			for _, dep := range dependencies(collector) {
+				if enabled[dep] && !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, collector)
+				done[collector.Type()] = true
+				pending = slices.Delete(pending, i, i+1)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
 func (r *CollectorRegistry) GetEnabledContinuous(config CollectionConfig) []ContinuousCollector {
 	var enabled []ContinuousCollector
 	for metricType, collector := range r.continuousCollectors {
@@ -262,6 +333,82 @@ func (m *MetricsStore) UpdateKernel(messages []KernelMessage) {
 	m.snapshot.Metrics.Kernel = messages
 }
 
+func (m *MetricsStore) UpdatePower(stats *PowerStats) {
+	m.snapshot.Metrics.Power = stats
+}
+
+func (m *MetricsStore) UpdateProcessStates(stats *ProcessStateStats) {
+	m.snapshot.Metrics.ProcessStates = stats
+}
+
+func (m *MetricsStore) UpdateSwap(stats *SwapStats) {
+	m.snapshot.Metrics.Swap = stats
+}
+
+func (m *MetricsStore) UpdateCertificates(stats *CertificateStats) {
+	m.snapshot.Metrics.Certificates = stats
+}
+
+func (m *MetricsStore) UpdateCostHints(hints *CostHints) {
+	m.snapshot.Metrics.CostHints = hints
+}
+
+func (m *MetricsStore) UpdateKernelTaint(stats *KernelTaintStats) {
+	m.snapshot.Metrics.KernelTaint = stats
+}
+
+func (m *MetricsStore) UpdateNFS(stats *NFSStats) {
+	m.snapshot.Metrics.NFS = stats
+}
+
+func (m *MetricsStore) UpdateNeighbors(stats *NeighborStats) {
+	m.snapshot.Metrics.Neighbors = stats
+}
+
+func (m *MetricsStore) UpdateIPVS(stats *IPVSStats) {
+	m.snapshot.Metrics.IPVS = stats
+}
+
+func (m *MetricsStore) UpdateBoot(stats *BootStats) {
+	m.snapshot.Metrics.Boot = stats
+}
+
+func (m *MetricsStore) UpdateCPUPerf(stats *CPUPerfStats) {
+	m.snapshot.Metrics.CPUPerf = stats
+}
+
+func (m *MetricsStore) UpdateSlab(stats *SlabStats) {
+	m.snapshot.Metrics.Slab = stats
+}
+
+func (m *MetricsStore) UpdateCgroupCPU(stats *CgroupCPUStats) {
+	m.snapshot.Metrics.CgroupCPU = stats
+}
+
+func (m *MetricsStore) UpdateTCPSockets(stats *TCPSocketStats) {
+	m.snapshot.Metrics.TCPSockets = stats
+}
+
+func (m *MetricsStore) UpdateMemoryPressure(stats *MemoryPressureStats) {
+	m.snapshot.Metrics.MemoryPressure = stats
+}
+
+func (m *MetricsStore) UpdateMemoryBandwidth(stats *MemoryBandwidthStats) {
+	m.snapshot.Metrics.MemoryBandwidth = stats
+}
+
+func (m *MetricsStore) UpdateLockContention(stats *LockContentionStats) {
+	m.snapshot.Metrics.LockContention = stats
+}
+
+func (m *MetricsStore) UpdateNetworkInfo(info *NetworkInfo) {
+	m.snapshot.Metrics.NetworkInfo = info
+}
+
+func (m *MetricsStore) UpdateTopology(stats *TopologyStats) {
+	m.snapshot.Metrics.Topology = stats
+}
+
 func (m *MetricsStore) GetSnapshot() *Snapshot {
 	// In the future, we'll deep copy here for thread safety
 	return m.snapshot
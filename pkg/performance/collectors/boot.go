@@ -0,0 +1,286 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*BootCollector)(nil)
+
+// userHZ is the unit of the start times in /proc/[pid]/stat. It is 100 on every
+// architecture Linux exports to userspace.
+const userHZ = 100
+
+// Task names of the services that have to run before a node can run pods: the kubelet
+// and one of the container runtimes
+const bootKubelet = "kubelet"
+
+var (
+	bootRuntimes = []string{"containerd", "crio", "dockerd"}
+	bootServices = append([]string{bootKubelet}, bootRuntimes...)
+)
+
+// BootCollector reports how long the node took from boot until it could run pods, which
+// is the delay before new capacity is usable when autoscaling groups add nodes.
+//
+// Data sources:
+//   - /proc/stat: boot time (btime), unless shared by the Manager
+//   - /proc/uptime: time since boot
+//   - /dev/kmsg: kernel log, whose timestamps are relative to boot. The kernel is done
+//     booting once it frees its init memory and runs the first userspace process.
+//   - /proc/[pid]/stat: start time of the kubelet and the container runtime, relative to
+//     boot, instead of the unit start times of systemd, which are only available through
+//     D-Bus
+//
+// The kernel log is a ring buffer, so the boot messages are lost on nodes that logged a
+// lot since boot. The collector reads the log until it finds the end of the kernel boot
+// or learns the messages are gone, and remembers the result, since the agent doesn't
+// outlive the boot.
+//
+// Reference: https://www.kernel.org/doc/Documentation/ABI/testing/dev-kmsg
+// Reference: https://man7.org/linux/man-pages/man5/proc.5.html
+type BootCollector struct {
+	performance.BaseCollector
+	procPath string
+	kmsgPath string
+
+	mu sync.Mutex
+	// Kernel boot time, once kernelDone
+	kernel     time.Duration
+	kernelDone bool
+}
+
+func NewBootCollector(logger logr.Logger, config performance.CollectionConfig) (*BootCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false, // /dev/kmsg needs CAP_SYSLOG, the rest is collected without it
+		RequiresEBPF:       false,
+		MinKernelVersion:   "3.5.0", // /dev/kmsg
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	devPath := config.HostDevPath
+	if devPath == "" {
+		devPath = "/dev"
+	}
+
+	return &BootCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeBoot,
+			"Boot Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath: config.HostProcPath,
+		kmsgPath: filepath.Join(devPath, "kmsg"),
+	}, nil
+}
+
+func (c *BootCollector) Collect(ctx context.Context) (any, error) {
+	host, err := hostContext(ctx, c.procPath)
+	if err != nil {
+		return nil, err
+	}
+	stats := &performance.BootStats{BootTime: host.BootTime}
+
+	if uptime, err := readUptime(filepath.Join(c.procPath, "uptime")); err != nil {
+		c.Logger().V(1).Info("Failed to read uptime", "error", err)
+	} else {
+		stats.Uptime = uptime
+	}
+
+	stats.Kernel = c.kernelBootTime()
+
+	services, err := readBootServices(ctx, c.procPath)
+	if err != nil {
+		return nil, err
+	}
+	stats.Services = services
+	stats.TimeToReady = timeToReady(services)
+	return stats, nil
+}
+
+// kernelBootTime returns how long the kernel took to boot, reading the kernel log until
+// it is known
+func (c *BootCollector) kernelBootTime() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.kernelDone {
+		return c.kernel
+	}
+
+	kernel, done, err := findKernelBootEnd(c.kmsgPath)
+	if err != nil {
+		// Retried on the next collection, e.g. once the agent has CAP_SYSLOG
+		c.Logger().V(1).Info("Failed to read kernel log", "path", c.kmsgPath, "error", err)
+		return 0
+	}
+	c.kernel, c.kernelDone = kernel, done
+	return kernel
+}
+
+// findKernelBootEnd returns the timestamp of the kernel log message that ends the kernel
+// boot. done is false if the log was read to its end without finding it, which happens
+// while the kernel is still booting. The message is "Run /sbin/init as init process"
+// since Linux 5.7, and "Freeing unused kernel memory" right before it on all versions.
+func findKernelBootEnd(path string) (end time.Duration, done bool, err error) {
+	first := true
+	err = readKmsg(path, func(record string) bool {
+		seq, ts, msg, ok := parseKmsgRecord(record)
+		if !ok {
+			return true
+		}
+		if first && seq != 0 {
+			// The oldest record was overwritten, and with it the boot messages
+			done = true
+			return false
+		}
+		first = false
+		if strings.HasPrefix(msg, "Freeing unused kernel") ||
+			(strings.HasPrefix(msg, "Run ") && strings.HasSuffix(msg, " as init process")) {
+			end, done = ts, true
+			return false
+		}
+		return true
+	})
+	return end, done, err
+}
+
+// parseKmsgRecord parses a /dev/kmsg record.
+//
+// Format: <priority>,<sequence>,<timestamp>,<flags>[,...];<message>
+func parseKmsgRecord(record string) (seq uint64, ts time.Duration, msg string, ok bool) {
+	header, msg, ok := strings.Cut(record, ";")
+	if !ok {
+		return 0, 0, "", false
+	}
+	fields := strings.Split(header, ",")
+	if len(fields) < 4 {
+		return 0, 0, "", false
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	usec, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return seq, time.Duration(usec) * time.Microsecond, msg, true
+}
+
+// hostContext returns the HostContext the Manager shares with the collection ctx belongs
+// to, reading it from procPath when the collector runs on its own
+func hostContext(ctx context.Context, procPath string) (performance.HostContext, error) {
+	if host, ok := performance.HostContextFrom(ctx); ok {
+		return host, nil
+	}
+	return performance.ReadHostContext(procPath)
+}
+
+// readUptime reads the first field of /proc/uptime
+func readUptime(path string) (time.Duration, error) {
+	fields := strings.Fields(procparse.ReadStringFile(path))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("failed to read %s", path)
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse uptime %q: %w", fields[0], err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// readBootServices returns the running bootServices ordered by start. The first started
+// process of a service is reported if several run.
+func readBootServices(ctx context.Context, procPath string) ([]performance.BootService, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procPath, err)
+	}
+
+	byName := make(map[string]performance.BootService)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		// Processes can exit between listing /proc and reading their stat
+		stat, err := readProcStat(procPath, int32(pid))
+		if err != nil || !slices.Contains(bootServices, stat.command) {
+			continue
+		}
+		started := time.Duration(stat.startTime) * time.Second / userHZ
+		if prev, ok := byName[stat.command]; ok && prev.Started <= started {
+			continue
+		}
+		byName[stat.command] = performance.BootService{
+			Name:    stat.command,
+			PID:     stat.pid,
+			Started: started,
+		}
+	}
+
+	services := make([]performance.BootService, 0, len(byName))
+	for _, service := range byName {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Started != services[j].Started {
+			return services[i].Started < services[j].Started
+		}
+		return services[i].Name < services[j].Name
+	})
+	return services, nil
+}
+
+// timeToReady returns when the kubelet and the first container runtime had both started,
+// or 0 if the kubelet isn't running
+func timeToReady(services []performance.BootService) time.Duration {
+	var kubelet, runtime time.Duration
+	var hasKubelet, hasRuntime bool
+	for _, service := range services {
+		switch {
+		case service.Name == bootKubelet:
+			kubelet, hasKubelet = service.Started, true
+		case slices.Contains(bootRuntimes, service.Name) && !hasRuntime:
+			runtime, hasRuntime = service.Started, true
+		}
+	}
+	if !hasKubelet {
+		return 0
+	}
+	return max(kubelet, runtime)
+}
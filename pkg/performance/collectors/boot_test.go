@@ -0,0 +1,110 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build linux
+
+package collectors_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKmsg = `6,0,0,-;Linux version 6.8.0-1012-aws (buildd@lcy02-amd64-001)
+6,1,0,-;Command line: BOOT_IMAGE=/vmlinuz root=LABEL=cloudimg-rootfs
+ SUBSYSTEM=cpu
+6,512,1843210,-;Freeing unused kernel image (initmem) memory: 4360K
+6,513,1851020,-;Run /init as init process
+`
+
+// bootProcStat returns a /proc/[pid]/stat line of a process started ticks after boot
+func bootProcStat(pid int, comm string, ticks int) string {
+	return fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 %d 1000 100\n", pid, comm, pid, pid, ticks)
+}
+
+func collectBoot(t *testing.T, procFiles, devFiles map[string]string) *performance.BootStats {
+	procPath, devPath := t.TempDir(), t.TempDir()
+	writeSysFiles(t, procPath, procFiles)
+	writeSysFiles(t, devPath, devFiles)
+	collector, err := collectors.NewBootCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostDevPath:  devPath,
+	})
+	require.NoError(t, err)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.BootStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestBootCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewBootCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewBootCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestBootCollector_Collect(t *testing.T) {
+	stats := collectBoot(t, map[string]string{
+		"stat":     "cpu  1 2 3 4\nbtime 1760000000\nprocesses 1234\n",
+		"uptime":   "3600.50 7000.00\n",
+		"1/stat":   bootProcStat(1, "systemd", 190),
+		"410/stat": bootProcStat(410, "containerd", 612),
+		"455/stat": bootProcStat(455, "kubelet", 845),
+		// A second containerd started later doesn't move the runtime's start
+		"2001/stat": bootProcStat(2001, "containerd", 90000),
+		"2002/stat": bootProcStat(2002, "containerd-shim", 1200),
+	}, map[string]string{"kmsg": testKmsg})
+
+	assert.Equal(t, time.Unix(1760000000, 0), stats.BootTime)
+	assert.Equal(t, 3600500*time.Millisecond, stats.Uptime)
+	assert.Equal(t, 1843210*time.Microsecond, stats.Kernel)
+	assert.Equal(t, []performance.BootService{
+		{Name: "containerd", PID: 410, Started: 6120 * time.Millisecond},
+		{Name: "kubelet", PID: 455, Started: 8450 * time.Millisecond},
+	}, stats.Services)
+	assert.Equal(t, 8450*time.Millisecond, stats.TimeToReady)
+}
+
+func TestBootCollector_KernelLogOverwritten(t *testing.T) {
+	stats := collectBoot(t, map[string]string{
+		"stat":      "btime 1760000000\n",
+		"1200/stat": bootProcStat(1200, "crio", 700),
+	}, map[string]string{
+		// The boot messages were overwritten, the later ones don't end the kernel boot
+		"kmsg": "6,90211,86400000000,-;Run /bin/true as init process\n",
+	})
+
+	assert.Zero(t, stats.Kernel)
+	assert.Zero(t, stats.TimeToReady, "not ready without a kubelet")
+	assert.Equal(t, []performance.BootService{{Name: "crio", PID: 1200, Started: 7 * time.Second}}, stats.Services)
+}
+
+func TestBootCollector_WithoutKernelLog(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "btime 1760000000\n"})
+	collector, err := collectors.NewBootCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostDevPath:  filepath.Join(procPath, "missing"),
+	})
+	require.NoError(t, err)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, result.(*performance.BootStats).Kernel)
+}
@@ -0,0 +1,222 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*CertificateCollector)(nil)
+
+// certificateDialTimeout bounds how long connecting to a TLS endpoint may take
+const certificateDialTimeout = 5 * time.Second
+
+// CertificateCollector reports the expiry dates of x509 certificates read from files and
+// live TLS endpoints.
+//
+// Expired node certificates are a recurring outage source: a kubelet whose client
+// certificate expired can no longer talk to the API server and an expired etcd or API
+// server certificate takes down the control plane. The collector makes the expiry visible
+// ahead of time.
+//
+// Data sources:
+//   - Certificate files matching CollectionConfig.CertificatePaths. Files can contain
+//     several PEM blocks (bundles, or a certificate and its key); every CERTIFICATE block
+//     is reported. Symlinks to an already reported file are skipped.
+//   - TLS endpoints in CollectionConfig.CertificateEndpoints. The certificate chain
+//     presented by the server is reported without being verified, since self-signed
+//     serving certificates like the kubelet's are common.
+//
+// Files that don't exist are skipped silently because the defaults cover both control
+// plane and worker nodes. Files that can't be read or parsed and unreachable endpoints are
+// reported in CertificateStats.Errors rather than failing the collection.
+//
+// The paths are read as is, so in a container the host's certificate directories have to
+// be mounted at the same paths.
+type CertificateCollector struct {
+	performance.BaseCollector
+	paths     []string
+	endpoints []string
+}
+
+func NewCertificateCollector(logger logr.Logger, config performance.CollectionConfig) (*CertificateCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false, // private key files next to the certificates need root, certificates don't
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	for _, path := range config.CertificatePaths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("certificate path must be an absolute path, got: %q", path)
+		}
+		if _, err := filepath.Match(path, ""); err != nil {
+			return nil, fmt.Errorf("invalid certificate path pattern %q: %w", path, err)
+		}
+	}
+	for _, endpoint := range config.CertificateEndpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return nil, fmt.Errorf("certificate endpoint must be host:port, got: %q", endpoint)
+		}
+	}
+
+	return &CertificateCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeCertificate,
+			"Certificate Expiry Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		paths:     config.CertificatePaths,
+		endpoints: config.CertificateEndpoints,
+	}, nil
+}
+
+func (c *CertificateCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectCertificates(ctx, time.Now())
+}
+
+func (c *CertificateCollector) collectCertificates(ctx context.Context, now time.Time) (*performance.CertificateStats, error) {
+	stats := &performance.CertificateStats{Errors: make(map[string]string)}
+
+	seen := make(map[string]bool)
+	for _, pattern := range c.paths {
+		// The pattern was validated in the constructor so Glob can't fail
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			resolved, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				// Dangling symlink
+				continue
+			}
+			if seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+
+			certs, err := readCertificateFile(path)
+			if err != nil {
+				stats.Errors[path] = err.Error()
+				continue
+			}
+			for _, cert := range certs {
+				stats.Certificates = append(stats.Certificates, certificateInfo(path, cert, now))
+			}
+		}
+	}
+
+	for _, endpoint := range c.endpoints {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		certs, err := fetchCertificates(ctx, endpoint)
+		if err != nil {
+			stats.Errors[endpoint] = err.Error()
+			continue
+		}
+		for _, cert := range certs {
+			stats.Certificates = append(stats.Certificates, certificateInfo(endpoint, cert, now))
+		}
+	}
+
+	sort.SliceStable(stats.Certificates, func(i, j int) bool {
+		return stats.Certificates[i].NotAfter.Before(stats.Certificates[j].NotAfter)
+	})
+	return stats, nil
+}
+
+// readCertificateFile parses all CERTIFICATE PEM blocks in path. Other blocks such as
+// private keys are ignored.
+func readCertificateFile(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// fetchCertificates returns the certificate chain presented by the TLS server at endpoint
+func fetchCertificates(ctx context.Context, endpoint string) ([]*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: certificateDialTimeout},
+		Config: &tls.Config{
+			// Only the presented certificates are inspected, nothing is sent over the connection
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate presented")
+	}
+	return certs, nil
+}
+
+func certificateInfo(source string, cert *x509.Certificate, now time.Time) performance.CertificateInfo {
+	remaining := cert.NotAfter.Sub(now)
+	days := int(remaining / (24 * time.Hour))
+	if remaining < 0 && remaining%(24*time.Hour) != 0 {
+		// Round towards negative infinity so a certificate expired an hour ago has -1 days
+		days--
+	}
+	return performance.CertificateInfo{
+		Source:        source,
+		Subject:       cert.Subject.String(),
+		Issuer:        cert.Issuer.String(),
+		SerialNumber:  cert.SerialNumber.String(),
+		DNSNames:      cert.DNSNames,
+		IsCA:          cert.IsCA,
+		NotBefore:     cert.NotBefore,
+		NotAfter:      cert.NotAfter,
+		DaysRemaining: days,
+		Expired:       now.After(cert.NotAfter),
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	der []byte
+	key *ecdsa.PrivateKey
+}
+
+// newTestCert creates a self-signed certificate for name that expires at notAfter
+func newTestCert(t *testing.T, name string, serial int64, notAfter time.Time) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return testCert{der: der, key: key}
+}
+
+func (c testCert) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func collectCertificates(t *testing.T, config performance.CollectionConfig) *performance.CertificateStats {
+	collector, err := collectors.NewCertificateCollector(logr.Discard(), config)
+	require.NoError(t, err)
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.CertificateStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestCertificateCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewCertificateCollector(logr.Discard(), performance.CollectionConfig{
+		CertificatePaths: []string{"relative/*.crt"},
+	})
+	assert.ErrorContains(t, err, "must be an absolute path")
+
+	_, err = collectors.NewCertificateCollector(logr.Discard(), performance.CollectionConfig{
+		CertificatePaths: []string{"/etc/[.crt"},
+	})
+	assert.ErrorContains(t, err, "invalid certificate path pattern")
+
+	_, err = collectors.NewCertificateCollector(logr.Discard(), performance.CollectionConfig{
+		CertificateEndpoints: []string{"localhost"},
+	})
+	assert.ErrorContains(t, err, "must be host:port")
+}
+
+func TestCertificateCollector_Files(t *testing.T) {
+	now := time.Now()
+	kubelet := newTestCert(t, "kubelet", 1, now.Add(30*24*time.Hour+time.Hour))
+	ca := newTestCert(t, "kubernetes-ca", 2, now.Add(3650*24*time.Hour))
+	etcd := newTestCert(t, "etcd", 3, now.Add(-time.Hour))
+
+	dir := t.TempDir()
+	bundle := append(append(kubelet.pem(), kubelet.keyPEM(t)...), ca.pem()...)
+	writeSysFiles(t, dir, map[string]string{
+		"kubelet/kubelet-client-2024.pem": string(bundle),
+		"pki/etcd/server.crt":             string(etcd.pem()),
+		"pki/broken.crt":                  "-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydA==\n-----END CERTIFICATE-----\n",
+		"pki/empty.crt":                   "",
+	})
+	require.NoError(t, os.Symlink("kubelet-client-2024.pem", filepath.Join(dir, "kubelet", "kubelet-client-current.pem")))
+	require.NoError(t, os.Symlink("missing.pem", filepath.Join(dir, "kubelet", "dangling.pem")))
+
+	stats := collectCertificates(t, performance.CollectionConfig{
+		CertificatePaths: []string{
+			filepath.Join(dir, "kubelet", "*.pem"),
+			filepath.Join(dir, "pki", "*.crt"),
+			filepath.Join(dir, "pki", "etcd", "*.crt"),
+			filepath.Join(dir, "does-not-exist", "*.crt"),
+		},
+		CertificateEndpoints: []string{},
+	})
+
+	require.Len(t, stats.Certificates, 3, "the symlink to an already read bundle must be skipped")
+
+	// Ordered by expiry
+	expired := stats.Certificates[0]
+	assert.Equal(t, filepath.Join(dir, "pki", "etcd", "server.crt"), expired.Source)
+	assert.Equal(t, "CN=etcd", expired.Subject)
+	assert.True(t, expired.Expired)
+	assert.Equal(t, -1, expired.DaysRemaining)
+
+	client := stats.Certificates[1]
+	assert.Equal(t, filepath.Join(dir, "kubelet", "kubelet-client-2024.pem"), client.Source)
+	assert.Equal(t, "CN=kubelet", client.Subject)
+	assert.Equal(t, "CN=kubelet", client.Issuer)
+	assert.Equal(t, "1", client.SerialNumber)
+	assert.Equal(t, []string{"kubelet"}, client.DNSNames)
+	assert.False(t, client.Expired)
+	assert.Equal(t, 30, client.DaysRemaining)
+	assert.True(t, client.NotAfter.Equal(now.Add(30*24*time.Hour+time.Hour).Truncate(time.Second)))
+
+	assert.Equal(t, "CN=kubernetes-ca", stats.Certificates[2].Subject)
+	assert.Equal(t, 3649, stats.Certificates[2].DaysRemaining)
+
+	require.Len(t, stats.Errors, 2)
+	assert.Contains(t, stats.Errors[filepath.Join(dir, "pki", "broken.crt")], "failed to parse certificate")
+	assert.Contains(t, stats.Errors[filepath.Join(dir, "pki", "empty.crt")], "no PEM encoded certificate found")
+}
+
+func TestCertificateCollector_Endpoints(t *testing.T) {
+	serving := newTestCert(t, "localhost", 10, time.Now().Add(90*24*time.Hour+time.Hour))
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serving.der}, PrivateKey: serving.key}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// Reserve a port with nothing listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	endpoint := server.Listener.Addr().String()
+	stats := collectCertificates(t, performance.CollectionConfig{
+		CertificatePaths:     []string{},
+		CertificateEndpoints: []string{endpoint, closed},
+	})
+
+	require.Len(t, stats.Certificates, 1)
+	assert.Equal(t, endpoint, stats.Certificates[0].Source)
+	assert.Equal(t, "CN=localhost", stats.Certificates[0].Subject)
+	assert.Equal(t, 90, stats.Certificates[0].DaysRemaining)
+
+	require.Len(t, stats.Errors, 1)
+	assert.Contains(t, stats.Errors, closed)
+}
+
+func TestCertificateCollector_Cancelled(t *testing.T) {
+	dir := t.TempDir()
+	writeSysFiles(t, dir, map[string]string{
+		"server.crt": string(newTestCert(t, "server", 1, time.Now().Add(time.Hour)).pem()),
+	})
+	collector, err := collectors.NewCertificateCollector(logr.Discard(), performance.CollectionConfig{
+		CertificatePaths:     []string{filepath.Join(dir, "*.crt")},
+		CertificateEndpoints: []string{},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,208 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/cgroup"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.StatefulCollector = (*CgroupCPUCollector)(nil)
+
+// cgroupCPUTopCgroups is the number of the most throttled cgroups reported
+const cgroupCPUTopCgroups = 20
+
+// CgroupCPUCollector reports the cgroups, usually containers, that CFS bandwidth control
+// throttled since the previous collection, longest first. Throttling by a CPU limit is the
+// most common cause of a slow pod on an idle node: a multi-threaded process can use the
+// quota of a whole period within a few milliseconds and then wait for the next one.
+//
+// The collector remembers the counters of every cgroup at the previous collection, so the
+// first collection reports no throttling.
+//
+// Data sources:
+//   - cpu.stat of every cgroup of the cpu controller: nr_periods, nr_throttled and
+//     throttled_usec (v2) or throttled_time (v1), and usage_usec (v2) or cpuacct.usage (v1)
+//   - cpu.max (v2), or cpu.cfs_quota_us and cpu.cfs_period_us (v1): the quota
+//   - cpuset.cpus.effective (v2) or cpuset.effective_cpus (v1): the CPU affinity
+//
+// Reference: https://docs.kernel.org/scheduler/sched-bwc.html
+type CgroupCPUCollector struct {
+	performance.BaseCollector
+	cgroups *cgroup.Reader
+
+	mu sync.Mutex
+	// Counters of the previous collection used to compute the throttling of the interval
+	prevTime  time.Time
+	prevStats map[string]cgroup.CPUStats
+}
+
+func NewCgroupCPUCollector(logger logr.Logger, config performance.CollectionConfig) (*CgroupCPUCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "3.2.0", // CFS bandwidth control
+	}
+
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	cgroups, err := cgroup.NewReader(config.HostSysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CgroupCPUCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeCgroupCPU,
+			"Cgroup CPU Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		cgroups: cgroups,
+	}, nil
+}
+
+func (c *CgroupCPUCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectCgroupCPUStats(ctx, time.Now())
+}
+
+func (c *CgroupCPUCollector) collectCgroupCPUStats(ctx context.Context, now time.Time) (*performance.CgroupCPUStats, error) {
+	paths, err := c.cgroups.CPUCgroups()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := &performance.CgroupCPUStats{}
+	if !c.prevTime.IsZero() {
+		stats.Interval = now.Sub(c.prevTime)
+	}
+	current := make(map[string]cgroup.CPUStats, len(paths))
+	var throttled []performance.CgroupThrottling
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		cpu, err := c.cgroups.CPU(path)
+		if err != nil {
+			// Removed since the hierarchy was walked
+			continue
+		}
+		// Only cgroups with a quota count periods
+		if cpu.Periods == 0 {
+			continue
+		}
+		stats.LimitedCgroups++
+		current[path] = cpu
+
+		prev, ok := c.prevStats[path]
+		// Counters going backwards are those of a new cgroup at the same path
+		if !ok || stats.Interval <= 0 || cpu.Periods < prev.Periods ||
+			cpu.ThrottledPeriods < prev.ThrottledPeriods || cpu.ThrottledUsec < prev.ThrottledUsec {
+			continue
+		}
+		if cpu.ThrottledPeriods == prev.ThrottledPeriods && cpu.ThrottledUsec == prev.ThrottledUsec {
+			continue
+		}
+		stats.ThrottledCgroups++
+
+		t := performance.CgroupThrottling{
+			Path:             path,
+			Periods:          cpu.Periods - prev.Periods,
+			ThrottledPeriods: cpu.ThrottledPeriods - prev.ThrottledPeriods,
+			ThrottledTime:    time.Duration(cpu.ThrottledUsec-prev.ThrottledUsec) * time.Microsecond,
+		}
+		if t.Periods > 0 {
+			t.ThrottledPercent = min(100, float64(t.ThrottledPeriods)/float64(t.Periods)*100)
+		}
+		if cpu.UsageUsec >= prev.UsageUsec {
+			usage := time.Duration(cpu.UsageUsec-prev.UsageUsec) * time.Microsecond
+			t.UsageCPUs = usage.Seconds() / stats.Interval.Seconds()
+		}
+		throttled = append(throttled, t)
+	}
+	c.prevTime, c.prevStats = now, current
+
+	slices.SortFunc(throttled, func(a, b performance.CgroupThrottling) int {
+		if a.ThrottledTime != b.ThrottledTime {
+			return cmp.Compare(b.ThrottledTime, a.ThrottledTime)
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	stats.Throttled = throttled[:min(len(throttled), cgroupCPUTopCgroups)]
+
+	// Only the reported cgroups get their limits read
+	for i := range stats.Throttled {
+		t := &stats.Throttled[i]
+		t.PodUID, _ = cgroup.PodUID(t.Path)
+		t.ContainerID, _ = cgroup.ContainerID(t.Path)
+
+		limit, err := c.cgroups.CPULimit(t.Path)
+		if err != nil {
+			continue
+		}
+		if limit.QuotaUsec > 0 && limit.PeriodUsec > 0 {
+			t.QuotaCPUs = float64(limit.QuotaUsec) / float64(limit.PeriodUsec)
+		}
+		if limit.CPUs != "" {
+			cpus, err := procparse.ParseCPUList(limit.CPUs)
+			if err != nil {
+				c.Logger().V(1).Info("invalid cpuset", "cgroup", t.Path, "cpus", limit.CPUs, "error", err.Error())
+				continue
+			}
+			t.CPUs = cpus
+		}
+	}
+	return stats, nil
+}
+
+// cgroupCPUState is the persisted state of the CgroupCPUCollector
+type cgroupCPUState struct {
+	Time  time.Time                  `json:"time"`
+	Stats map[string]cgroup.CPUStats `json:"stats"`
+}
+
+func (c *CgroupCPUCollector) SaveState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prevTime.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(cgroupCPUState{Time: c.prevTime, Stats: c.prevStats})
+}
+
+func (c *CgroupCPUCollector) RestoreState(data []byte) error {
+	var state cgroupCPUState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prevTime = state.Time
+	c.prevStats = state.Stats
+	return nil
+}
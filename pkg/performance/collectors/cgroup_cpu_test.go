@@ -0,0 +1,156 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPodCgroup       = "fs/cgroup/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0b8f1c2d_3e4f_5a6b_7c8d_9e0f1a2b3c4d.slice"
+	testContainerID     = "4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b"
+	testContainerCgroup = testPodCgroup + "/cri-containerd-" + testContainerID + ".scope"
+)
+
+func testCPUStat(usage, periods, throttled, throttledUsec int) string {
+	return fmt.Sprintf("usage_usec %d\nuser_usec %d\nsystem_usec 0\nnr_periods %d\nnr_throttled %d\nthrottled_usec %d\n",
+		usage, usage, periods, throttled, throttledUsec)
+}
+
+func createCgroupCPUCollector(t *testing.T, files map[string]string) (*collectors.CgroupCPUCollector, string) {
+	sysPath := t.TempDir()
+	writeSysFiles(t, sysPath, map[string]string{"fs/cgroup/cgroup.controllers": "cpuset cpu io memory pids\n"})
+	writeSysFiles(t, sysPath, files)
+	collector, err := collectors.NewCgroupCPUCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: sysPath})
+	require.NoError(t, err)
+	return collector, sysPath
+}
+
+func collectCgroupCPUStats(t *testing.T, collector *collectors.CgroupCPUCollector) *performance.CgroupCPUStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.CgroupCPUStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestCgroupCPUCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewCgroupCPUCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: "relative"})
+	assert.ErrorContains(t, err, "HostSysPath must be an absolute path")
+
+	_, err = collectors.NewCgroupCPUCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: t.TempDir()})
+	assert.Error(t, err, "no cgroup hierarchy")
+}
+
+func TestCgroupCPUCollector_Collect(t *testing.T) {
+	collector, sysPath := createCgroupCPUCollector(t, map[string]string{
+		"fs/cgroup/cpu.stat":                           testCPUStat(9000000, 0, 0, 0),
+		testPodCgroup + "/cpu.stat":                    testCPUStat(1000000, 100, 0, 0),
+		testPodCgroup + "/cpu.max":                     "200000 100000\n",
+		testContainerCgroup + "/cpu.stat":              testCPUStat(1000000, 100, 10, 500000),
+		testContainerCgroup + "/cpu.max":               "50000 100000\n",
+		testContainerCgroup + "/cpuset.cpus.effective": "0-1,4\n",
+		"fs/cgroup/system.slice/sshd.service/cpu.stat": testCPUStat(1000, 0, 0, 0),
+	})
+
+	first := collectCgroupCPUStats(t, collector)
+	assert.Zero(t, first.Interval)
+	assert.Equal(t, 2, first.LimitedCgroups)
+	assert.Zero(t, first.ThrottledCgroups, "throttling needs a previous collection")
+	assert.Empty(t, first.Throttled)
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, sysPath, map[string]string{
+		testPodCgroup + "/cpu.stat":       testCPUStat(1050000, 140, 0, 0),
+		testContainerCgroup + "/cpu.stat": testCPUStat(1050000, 140, 30, 2500000),
+	})
+
+	second := collectCgroupCPUStats(t, collector)
+	assert.GreaterOrEqual(t, second.Interval, 50*time.Millisecond)
+	assert.Equal(t, 2, second.LimitedCgroups)
+	assert.Equal(t, 1, second.ThrottledCgroups)
+	require.Len(t, second.Throttled, 1)
+
+	throttled := second.Throttled[0]
+	assert.Equal(t, "/"+testContainerCgroup[len("fs/cgroup/"):], throttled.Path)
+	assert.Equal(t, "0b8f1c2d-3e4f-5a6b-7c8d-9e0f1a2b3c4d", throttled.PodUID)
+	assert.Equal(t, testContainerID, throttled.ContainerID)
+	assert.Equal(t, 0.5, throttled.QuotaCPUs)
+	assert.Equal(t, []int{0, 1, 4}, throttled.CPUs)
+	assert.Equal(t, uint64(40), throttled.Periods)
+	assert.Equal(t, uint64(20), throttled.ThrottledPeriods)
+	assert.Equal(t, 50.0, throttled.ThrottledPercent)
+	assert.Equal(t, 2*time.Second, throttled.ThrottledTime)
+	assert.Greater(t, throttled.UsageCPUs, 0.0)
+	assert.LessOrEqual(t, throttled.UsageCPUs, 0.05/0.05)
+}
+
+func TestCgroupCPUCollector_TopCgroups(t *testing.T) {
+	files := make(map[string]string)
+	for i := 1; i <= 30; i++ {
+		files[fmt.Sprintf("fs/cgroup/app-%02d/cpu.stat", i)] = testCPUStat(0, 10, 0, 0)
+	}
+	collector, sysPath := createCgroupCPUCollector(t, files)
+	collectCgroupCPUStats(t, collector)
+
+	for i := 1; i <= 30; i++ {
+		files[fmt.Sprintf("fs/cgroup/app-%02d/cpu.stat", i)] = testCPUStat(0, 20, 5, i*1000)
+	}
+	writeSysFiles(t, sysPath, files)
+	stats := collectCgroupCPUStats(t, collector)
+
+	assert.Equal(t, 30, stats.ThrottledCgroups)
+	require.Len(t, stats.Throttled, 20)
+	assert.Equal(t, "/app-30", stats.Throttled[0].Path)
+	assert.Equal(t, "/app-11", stats.Throttled[19].Path)
+	assert.Zero(t, stats.Throttled[0].QuotaCPUs, "no cpu.max")
+	assert.Empty(t, stats.Throttled[0].CPUs, "no cpuset")
+}
+
+func TestCgroupCPUCollector_RecreatedCgroup(t *testing.T) {
+	collector, sysPath := createCgroupCPUCollector(t, map[string]string{
+		"fs/cgroup/app/cpu.stat": testCPUStat(1000000, 100, 50, 5000000),
+	})
+	collectCgroupCPUStats(t, collector)
+
+	// A new cgroup at the same path starts its counters over
+	writeSysFiles(t, sysPath, map[string]string{"fs/cgroup/app/cpu.stat": testCPUStat(1000, 10, 5, 1000)})
+	stats := collectCgroupCPUStats(t, collector)
+	assert.Empty(t, stats.Throttled)
+
+	writeSysFiles(t, sysPath, map[string]string{"fs/cgroup/app/cpu.stat": testCPUStat(2000, 20, 6, 3000)})
+	stats = collectCgroupCPUStats(t, collector)
+	require.Len(t, stats.Throttled, 1)
+	assert.Equal(t, 2*time.Millisecond, stats.Throttled[0].ThrottledTime)
+}
+
+func TestCgroupCPUCollector_RestoredState(t *testing.T) {
+	files := map[string]string{"fs/cgroup/app/cpu.stat": testCPUStat(1000000, 100, 50, 5000000)}
+	collector, sysPath := createCgroupCPUCollector(t, files)
+	collectCgroupCPUStats(t, collector)
+	state, err := collector.SaveState()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+
+	restarted, err := collectors.NewCgroupCPUCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: sysPath})
+	require.NoError(t, err)
+	require.NoError(t, restarted.RestoreState(state))
+
+	writeSysFiles(t, sysPath, map[string]string{"fs/cgroup/app/cpu.stat": testCPUStat(1100000, 110, 55, 6000000)})
+	stats := collectCgroupCPUStats(t, restarted)
+	require.Len(t, stats.Throttled, 1)
+	assert.Equal(t, time.Second, stats.Throttled[0].ThrottledTime)
+}
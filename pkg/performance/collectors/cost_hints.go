@@ -0,0 +1,312 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.DependentCollector = (*CostHintsCollector)(nil)
+
+const (
+	// instanceMetadataTimeout bounds a lookup of the instance metadata so that nodes
+	// outside of a cloud don't stall the collection
+	instanceMetadataTimeout = 2 * time.Second
+
+	// instanceMetadataRetryInterval is how long a failed instance metadata lookup is
+	// cached before it is tried again
+	instanceMetadataRetryInterval = 10 * time.Minute
+)
+
+// CostHintsCollector estimates the idle CPU and memory capacity of the node and tags it
+// with the instance type and lifecycle, so the platform's cost features can attribute
+// the cost of idle capacity to nodes without joining several metrics on the backend.
+//
+// Data sources:
+// - /proc/stat: CPU time counters and number of online CPUs
+// - /proc/meminfo: MemTotal and MemAvailable, unless the memory collector read them
+// - /proc/uptime: utilization window of the first collection
+// - EC2 instance metadata service (IMDSv2): instance type, lifecycle, region and zone
+//
+// CPU utilization is computed from the difference between the counters of consecutive
+// collections. The first collection reports the average since boot.
+//
+// The instance metadata doesn't change while the node runs, so it is looked up once and
+// cached. Failed lookups are retried every 10 minutes and leave the instance fields
+// empty, which is expected on nodes outside of AWS. The metadata endpoint can be changed
+// or the lookup disabled with the AWS SDK's AWS_EC2_METADATA_SERVICE_ENDPOINT and
+// AWS_EC2_METADATA_DISABLED environment variables.
+type CostHintsCollector struct {
+	performance.BaseCollector
+	procPath string
+
+	mu sync.Mutex
+	// CPU counters of the previous collection
+	prevCPU  cpuTimes
+	prevTime time.Time
+	// Cached instance metadata
+	instance        *instanceMetadata
+	instanceErr     error
+	instanceFetched time.Time
+}
+
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+type instanceMetadata struct {
+	instanceType     string
+	lifecycle        string
+	region           string
+	availabilityZone string
+}
+
+func NewCostHintsCollector(logger logr.Logger, config performance.CollectionConfig) (*CostHintsCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "3.14.0", // MemAvailable in /proc/meminfo
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &CostHintsCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeCostHints,
+			"Cost Hints Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath: config.HostProcPath,
+	}, nil
+}
+
+// DependsOn implements performance.DependentCollector. The memory collector reads the
+// same meminfo fields.
+func (c *CostHintsCollector) DependsOn() []performance.MetricType {
+	return []performance.MetricType{performance.MetricTypeMemory}
+}
+
+func (c *CostHintsCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectCostHints(ctx, time.Now())
+}
+
+func (c *CostHintsCollector) collectCostHints(ctx context.Context, now time.Time) (*performance.CostHints, error) {
+	cpu, cores, err := c.readCPUTimes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CPU times: %w", err)
+	}
+	memTotal, memAvailable, err := c.readMemory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	memAvailable = min(memAvailable, memTotal)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hints := &performance.CostHints{
+		CPUCores:          cores,
+		MemoryBytes:       memTotal * 1024,
+		MemoryUtilization: 1 - float64(memAvailable)/float64(memTotal),
+		IdleMemoryBytes:   memAvailable * 1024,
+	}
+
+	// The counters are cumulative since boot, so without a previous collection the
+	// difference to zero is the average since boot
+	prev := c.prevCPU
+	if c.prevTime.IsZero() || cpu.total < prev.total || cpu.idle < prev.idle {
+		prev = cpuTimes{}
+		hints.UtilizationWindow = c.readUptime()
+	} else {
+		hints.UtilizationWindow = now.Sub(c.prevTime)
+	}
+	if total := cpu.total - prev.total; total > 0 {
+		hints.CPUUtilization = 1 - float64(cpu.idle-prev.idle)/float64(total)
+	}
+	hints.IdleCPUCores = float64(cores) * (1 - hints.CPUUtilization)
+	c.prevCPU = cpu
+	c.prevTime = now
+
+	if instance := c.instanceMetadata(ctx, now); instance != nil {
+		hints.Provider = "aws"
+		hints.InstanceType = instance.instanceType
+		hints.Lifecycle = instance.lifecycle
+		hints.Region = instance.region
+		hints.AvailabilityZone = instance.availabilityZone
+	}
+
+	return hints, nil
+}
+
+// readCPUTimes returns the aggregate idle and total CPU time from /proc/stat and the
+// number of online CPUs.
+//
+// Format: cpu user nice system idle iowait irq softirq steal guest guest_nice
+//
+// guest and guest_nice are already included in user and nice. Time waiting for I/O
+// counts as idle since the CPU is available to other work.
+func (c *CostHintsCollector) readCPUTimes() (cpuTimes, int, error) {
+	file, err := os.Open(filepath.Join(c.procPath, "stat"))
+	if err != nil {
+		return cpuTimes{}, 0, err
+	}
+	defer file.Close()
+
+	var times cpuTimes
+	found := false
+	cores := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			cores++
+			continue
+		}
+		if len(fields) < 5 {
+			return cpuTimes{}, 0, fmt.Errorf("unexpected cpu line format: %q", scanner.Text())
+		}
+		for i, field := range fields[1:min(len(fields), 9)] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, 0, fmt.Errorf("failed to parse cpu field %q: %w", field, err)
+			}
+			times.total += v
+			// idle and iowait
+			if i == 3 || i == 4 {
+				times.idle += v
+			}
+		}
+		found = true
+	}
+	if err := scanner.Err(); err != nil {
+		return cpuTimes{}, 0, err
+	}
+	if !found {
+		return cpuTimes{}, 0, fmt.Errorf("aggregate cpu line not found")
+	}
+	return times, cores, nil
+}
+
+// readUptime returns the system uptime or 0 if it can't be read
+func (c *CostHintsCollector) readUptime() time.Duration {
+	fields := strings.Fields(procparse.ReadStringFile(filepath.Join(c.procPath, "uptime")))
+	if len(fields) == 0 {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// instanceMetadata returns the cached instance metadata, looking it up if it hasn't been
+// yet or the previous lookup failed more than instanceMetadataRetryInterval ago.
+// c.mu must be held.
+func (c *CostHintsCollector) instanceMetadata(ctx context.Context, now time.Time) *instanceMetadata {
+	if c.instance != nil {
+		return c.instance
+	}
+	if c.instanceErr != nil && now.Sub(c.instanceFetched) < instanceMetadataRetryInterval {
+		return nil
+	}
+
+	c.instance, c.instanceErr = fetchInstanceMetadata(ctx)
+	c.instanceFetched = now
+	if c.instanceErr != nil {
+		c.Logger().V(1).Info("Instance metadata unavailable", "error", c.instanceErr)
+	}
+	return c.instance
+}
+
+func fetchInstanceMetadata(ctx context.Context) (*instanceMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, instanceMetadataTimeout)
+	defer cancel()
+
+	// Reads AWS_EC2_METADATA_SERVICE_ENDPOINT and AWS_EC2_METADATA_DISABLED
+	client := imds.New(imds.Options{})
+
+	get := func(path string) (string, error) {
+		resp, err := client.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s: %w", path, err)
+		}
+		defer resp.Content.Close()
+		data, err := io.ReadAll(resp.Content)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	var err error
+	instance := &instanceMetadata{}
+	for path, field := range map[string]*string{
+		"instance-type":               &instance.instanceType,
+		"instance-life-cycle":         &instance.lifecycle,
+		"placement/region":            &instance.region,
+		"placement/availability-zone": &instance.availabilityZone,
+	} {
+		if *field, err = get(path); err != nil {
+			return nil, err
+		}
+	}
+	return instance, nil
+}
+
+// readMemory returns MemTotal and MemAvailable in kB, from the data of the memory
+// collector if it ran before in the same snapshot
+func (c *CostHintsCollector) readMemory(ctx context.Context) (total, available uint64, err error) {
+	if data, ok := performance.DependencyData(ctx, performance.MetricTypeMemory); ok {
+		if memory, ok := data.(*performance.MemoryStats); ok && memory.MemTotal > 0 {
+			return memory.MemTotal, memory.MemAvailable, nil
+		}
+	}
+
+	meminfo, err := procparse.ParseKVFile(filepath.Join(c.procPath, "meminfo"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read meminfo: %w", err)
+	}
+	total, ok := meminfo["MemTotal"]
+	if !ok || total == 0 {
+		return 0, 0, fmt.Errorf("MemTotal missing from meminfo")
+	}
+	available, ok = meminfo["MemAvailable"]
+	if !ok {
+		return 0, 0, fmt.Errorf("MemAvailable missing from meminfo")
+	}
+	return total, available, nil
+}
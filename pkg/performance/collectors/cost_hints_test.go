@@ -0,0 +1,204 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const costHintsMeminfo = `MemTotal:        8000000 kB
+MemFree:         1000000 kB
+MemAvailable:    6000000 kB
+`
+
+// newIMDSServer serves the IMDSv2 token and metadata endpoints with the given metadata
+func newIMDSServer(t *testing.T, metadata map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			_, _ = w.Write([]byte("token"))
+			return
+		}
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		value, ok := metadata[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(value))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func createCostHintsCollector(t *testing.T, stat string) (*collectors.CostHintsCollector, string) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{
+		"stat":    stat,
+		"meminfo": costHintsMeminfo,
+		"uptime":  "3600.50 7000.00\n",
+	})
+	collector, err := collectors.NewCostHintsCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+	})
+	require.NoError(t, err)
+	return collector, procPath
+}
+
+func collectCostHints(t *testing.T, collector *collectors.CostHintsCollector) *performance.CostHints {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	hints, ok := result.(*performance.CostHints)
+	require.True(t, ok)
+	return hints
+}
+
+func TestCostHintsCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewCostHintsCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: "relative/proc",
+	})
+	assert.ErrorContains(t, err, "must be an absolute path")
+
+	_, err = collectors.NewCostHintsCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: "/does/not/exist",
+	})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestCostHintsCollector_Utilization(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	// user nice system idle iowait irq softirq steal guest guest_nice
+	collector, procPath := createCostHintsCollector(t, `cpu  600 0 200 1000 200 0 0 0 50 0
+cpu0 300 0 100 500 100 0 0 0 25 0
+cpu1 300 0 100 500 100 0 0 0 25 0
+intr 12345
+`)
+
+	hints := collectCostHints(t, collector)
+	assert.Equal(t, 2, hints.CPUCores)
+	assert.Equal(t, uint64(8000000*1024), hints.MemoryBytes)
+	assert.Equal(t, uint64(6000000*1024), hints.IdleMemoryBytes)
+	assert.InDelta(t, 0.25, hints.MemoryUtilization, 1e-9)
+	// First collection: average since boot, idle+iowait = 1200 of 2000
+	assert.InDelta(t, 0.4, hints.CPUUtilization, 1e-9)
+	assert.InDelta(t, 1.2, hints.IdleCPUCores, 1e-9)
+	assert.Equal(t, 3600500*time.Millisecond, hints.UtilizationWindow)
+	assert.Empty(t, hints.Provider)
+	assert.Empty(t, hints.InstanceType)
+
+	// 1000 more jiffies, 900 of them busy
+	writeSysFiles(t, procPath, map[string]string{
+		"stat": `cpu  1300 0 400 1100 200 0 0 0 50 0
+cpu0 650 0 200 550 100 0 0 0 25 0
+cpu1 650 0 200 550 100 0 0 0 25 0
+`,
+	})
+	hints = collectCostHints(t, collector)
+	assert.InDelta(t, 0.9, hints.CPUUtilization, 1e-9)
+	assert.InDelta(t, 0.2, hints.IdleCPUCores, 1e-9)
+	assert.Less(t, hints.UtilizationWindow, time.Minute)
+
+	// Counters went backwards, e.g. after a restore from hibernation
+	writeSysFiles(t, procPath, map[string]string{
+		"stat": "cpu  100 0 0 300 0 0 0 0 0 0\ncpu0 100 0 0 300 0 0 0 0 0 0\n",
+	})
+	hints = collectCostHints(t, collector)
+	assert.Equal(t, 1, hints.CPUCores)
+	assert.InDelta(t, 0.25, hints.CPUUtilization, 1e-9)
+	assert.Equal(t, 3600500*time.Millisecond, hints.UtilizationWindow)
+}
+
+func TestCostHintsCollector_MemoryDependency(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	collector, procPath := createCostHintsCollector(t, "cpu  100 0 0 300 0 0 0 0 0 0\ncpu0 100 0 0 300 0 0 0 0 0 0\n")
+	assert.Equal(t, []performance.MetricType{performance.MetricTypeMemory}, collector.DependsOn())
+	require.NoError(t, os.Remove(filepath.Join(procPath, "meminfo")))
+
+	// The meminfo fields collected by the memory collector in the same snapshot are used
+	ctx := performance.WithCollection(context.Background(), performance.HostContext{}, map[performance.MetricType]any{
+		performance.MetricTypeMemory: &performance.MemoryStats{MemTotal: 4000000, MemAvailable: 1000000},
+	})
+	result, err := collector.Collect(ctx)
+	require.NoError(t, err)
+	hints := result.(*performance.CostHints)
+	assert.Equal(t, uint64(4000000*1024), hints.MemoryBytes)
+	assert.InDelta(t, 0.75, hints.MemoryUtilization, 1e-9)
+
+	// Without them meminfo is read
+	_, err = collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to read meminfo")
+}
+
+func TestCostHintsCollector_InstanceMetadata(t *testing.T) {
+	server := newIMDSServer(t, map[string]string{
+		"instance-type":               "m5.xlarge",
+		"instance-life-cycle":         "spot",
+		"placement/region":            "us-west-2",
+		"placement/availability-zone": "us-west-2b",
+	})
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+
+	collector, _ := createCostHintsCollector(t, "cpu  100 0 0 300 0 0 0 0 0 0\ncpu0 100 0 0 300 0 0 0 0 0 0\n")
+	hints := collectCostHints(t, collector)
+	assert.Equal(t, "aws", hints.Provider)
+	assert.Equal(t, "m5.xlarge", hints.InstanceType)
+	assert.Equal(t, "spot", hints.Lifecycle)
+	assert.Equal(t, "us-west-2", hints.Region)
+	assert.Equal(t, "us-west-2b", hints.AvailabilityZone)
+
+	// The metadata is cached
+	server.Close()
+	hints = collectCostHints(t, collector)
+	assert.Equal(t, "m5.xlarge", hints.InstanceType)
+}
+
+func TestCostHintsCollector_InstanceMetadataUnavailable(t *testing.T) {
+	// Missing instance-life-cycle fails the lookup
+	server := newIMDSServer(t, map[string]string{
+		"instance-type": "m5.xlarge",
+	})
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+
+	collector, _ := createCostHintsCollector(t, "cpu  100 0 0 300 0 0 0 0 0 0\ncpu0 100 0 0 300 0 0 0 0 0 0\n")
+	hints := collectCostHints(t, collector)
+	assert.Empty(t, hints.Provider)
+	assert.Empty(t, hints.InstanceType)
+	assert.Equal(t, 1, hints.CPUCores)
+}
+
+func TestCostHintsCollector_InvalidFiles(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	collector, procPath := createCostHintsCollector(t, "intr 12345\n")
+	_, err := collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "aggregate cpu line not found")
+
+	writeSysFiles(t, procPath, map[string]string{
+		"stat":    "cpu  100 0 0 300 0 0 0 0 0 0\n",
+		"meminfo": "MemTotal: 8000000 kB\n",
+	})
+	_, err = collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "MemAvailable missing")
+}
@@ -0,0 +1,170 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*CPUCollector)(nil)
+
+// CPUCollector reports the time spent by every CPU, and all of them together, in each
+// state and their utilization and steal percentage since the previous collection. The
+// first collection reports them since boot, as do CPUs whose counters went backwards, e.g.
+// after being taken offline and brought back.
+//
+// Data sources:
+// - /proc/stat: the cpu and cpuN lines, in USER_HZ
+//
+// Guest time is already counted in user time, so it doesn't add to the total.
+//
+// Reference: https://man7.org/linux/man-pages/man5/proc_stat.5.html
+type CPUCollector struct {
+	performance.BaseCollector
+	statPath string
+
+	mu   sync.Mutex
+	prev map[int32]performance.CPUStats
+}
+
+func NewCPUCollector(logger logr.Logger, config performance.CollectionConfig) (*CPUCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.33", // guest_nice
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &CPUCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeCPU,
+			"CPU Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		statPath: filepath.Join(config.HostProcPath, "stat"),
+		prev:     make(map[int32]performance.CPUStats),
+	}, nil
+}
+
+func (c *CPUCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectCPUStats()
+}
+
+// collectCPUStats reads the cpu lines of /proc/stat, the aggregate first
+//
+// Format: cpu[N] user nice system idle iowait irq softirq steal guest guest_nice
+func (c *CPUCollector) collectCPUStats() ([]performance.CPUStats, error) {
+	file, err := os.Open(c.statPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", c.statPath, err)
+	}
+	defer file.Close()
+
+	stats := make([]performance.CPUStats, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		stat, err := parseCPULine(fields)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.statPath, err)
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("no cpu lines in %s", c.statPath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.prev
+	c.prev = make(map[int32]performance.CPUStats, len(stats))
+	for i := range stats {
+		c.prev[stats[i].CPUIndex] = stats[i]
+		p, ok := prev[stats[i].CPUIndex]
+		if !ok || cpuTotal(stats[i]) < cpuTotal(p) || cpuBusy(stats[i]) < cpuBusy(p) ||
+			stats[i].Steal < p.Steal {
+			p = performance.CPUStats{}
+		}
+		stats[i].DeltaTotal = cpuTotal(stats[i]) - cpuTotal(p)
+		if stats[i].DeltaTotal > 0 {
+			busy := cpuBusy(stats[i]) - cpuBusy(p)
+			stats[i].Utilization = 100 * float64(busy) / float64(stats[i].DeltaTotal)
+			stats[i].StealPercent = 100 * float64(stats[i].Steal-p.Steal) / float64(stats[i].DeltaTotal)
+		}
+	}
+	return stats, nil
+}
+
+// parseCPULine parses the fields of a cpu line of /proc/stat. Kernels before 2.6.33 lack
+// the last fields, which are left at 0.
+func parseCPULine(fields []string) (performance.CPUStats, error) {
+	stat := performance.CPUStats{CPUIndex: -1}
+	if index := strings.TrimPrefix(fields[0], "cpu"); index != "" {
+		i, err := strconv.ParseInt(index, 10, 32)
+		if err != nil {
+			return stat, fmt.Errorf("invalid cpu line %q", fields[0])
+		}
+		stat.CPUIndex = int32(i)
+	}
+	if len(fields) < 5 {
+		return stat, fmt.Errorf("unexpected format of %s line: got %d fields", fields[0], len(fields))
+	}
+
+	values := []*uint64{
+		&stat.User, &stat.Nice, &stat.System, &stat.Idle, &stat.IOWait,
+		&stat.IRQ, &stat.SoftIRQ, &stat.Steal, &stat.Guest, &stat.GuestNice,
+	}
+	for i, field := range fields[1:] {
+		if i >= len(values) {
+			break
+		}
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return stat, fmt.Errorf("failed to parse %s field %d %q: %w", fields[0], i+1, field, err)
+		}
+		*values[i] = v
+	}
+	return stat, nil
+}
+
+// cpuTotal returns the time of s in all states. Guest time is part of user time.
+func cpuTotal(s performance.CPUStats) uint64 {
+	return s.User + s.Nice + s.System + s.Idle + s.IOWait + s.IRQ + s.SoftIRQ + s.Steal
+}
+
+// cpuBusy returns the time of s not idle or waiting for I/O
+func cpuBusy(s performance.CPUStats) uint64 {
+	return cpuTotal(s) - s.Idle - s.IOWait
+}
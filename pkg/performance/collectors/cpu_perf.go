@@ -0,0 +1,272 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*CPUPerfCollector)(nil)
+
+// Hardware events counted on every CPU, in the order of perfReading.values
+const (
+	perfCycles = iota
+	perfInstructions
+	perfCacheReferences
+	perfCacheMisses
+	numPerfEvents
+)
+
+// perfReading is a reading of the counters of a CPU
+type perfReading struct {
+	// Time the counters were enabled and actually counting, in nanoseconds. Running is
+	// less than enabled when the counters were multiplexed with other perf events.
+	enabled uint64
+	running uint64
+	values  [numPerfEvents]uint64
+}
+
+// CPUPerfCollector reports the instructions per cycle and last level cache miss rate of
+// every CPU from hardware performance counters. A busy CPU with a low IPC or a high
+// cache miss rate is stalling on memory rather than doing useful work, which CPU
+// utilization doesn't tell apart.
+//
+// The counters are opened with perf_event_open for every online CPU when the collector is
+// created and count from then on, so every collection reports the counts since the
+// previous one, or since the collector was created. Every collection first follows CPU
+// hotplug: the counters of CPUs taken offline are closed, and counters are opened on CPUs
+// brought online, which report the counts since they were opened.
+//
+// Counting system-wide needs CAP_PERFMON (or CAP_SYS_ADMIN before Linux 5.8) or
+// kernel.perf_event_paranoid <= 0, and a PMU that exposes the generic hardware events,
+// which many VMs don't. The collector can't be created without them.
+//
+// Data sources:
+//   - perf_event_open: cycles, instructions, cache references and cache misses, which
+//     are last level cache accesses on most CPUs
+//   - /proc/sys/kernel/perf_event_paranoid: whether the kernel supports perf events
+//   - /sys/devices/system/cpu/online: the CPUs to count on
+//
+// Reference: https://man7.org/linux/man-pages/man2/perf_event_open.2.html
+type CPUPerfCollector struct {
+	performance.BaseCollector
+	onlinePath string
+
+	mu sync.Mutex
+	// Counters of the online CPUs, in ascending order of CPU
+	groups []*perfGroup
+	// Readings of the previous collection, in the order of groups
+	prev     []perfReading
+	prevTime time.Time
+}
+
+func NewCPUPerfCollector(logger logr.Logger, config performance.CollectionConfig) (*CPUPerfCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       true, // CAP_PERFMON, unless perf_event_paranoid allows system-wide events
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.31", // perf_event_open
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	paranoidPath := filepath.Join(config.HostProcPath, "sys", "kernel", "perf_event_paranoid")
+	paranoid, err := strconv.Atoi(procparse.ReadStringFile(paranoidPath))
+	if err != nil {
+		return nil, fmt.Errorf("perf events aren't supported by the kernel: failed to read %s", paranoidPath)
+	}
+
+	onlinePath := filepath.Join(config.HostSysPath, "devices", "system", "cpu", "online")
+	cpus, err := readOnlineCPUs(onlinePath)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*perfGroup, 0, len(cpus))
+	for _, cpu := range cpus {
+		group, err := openPerfGroup(cpu)
+		if err != nil {
+			for _, g := range groups {
+				g.close()
+			}
+			return nil, fmt.Errorf("failed to open perf events on CPU %d (perf_event_paranoid %d): %w", cpu, paranoid, err)
+		}
+		groups = append(groups, group)
+	}
+
+	return &CPUPerfCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeCPUPerf,
+			"CPU Performance Counter Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		onlinePath: onlinePath,
+		groups:     groups,
+		prev:       make([]perfReading, len(groups)),
+		prevTime:   time.Now(),
+	}, nil
+}
+
+func (c *CPUPerfCollector) Collect(ctx context.Context) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.followHotplug()
+	readings := make([]perfReading, len(c.groups))
+	for i, group := range c.groups {
+		reading, err := group.read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read perf events of CPU %d: %w", group.cpu, err)
+		}
+		readings[i] = reading
+	}
+	now := time.Now()
+
+	stats := &performance.CPUPerfStats{
+		Interval: now.Sub(c.prevTime),
+		CPUs:     make([]performance.CPUPerfCounters, 0, len(c.groups)),
+	}
+	for i, group := range c.groups {
+		counters := perfCounters(c.prev[i], readings[i])
+		counters.CPU = int32(group.cpu)
+		stats.CPUs = append(stats.CPUs, counters)
+
+		stats.Cycles += counters.Cycles
+		stats.Instructions += counters.Instructions
+		stats.CacheReferences += counters.CacheReferences
+		stats.CacheMisses += counters.CacheMisses
+	}
+	stats.IPC, stats.CacheMissRate = perfRatios(stats.Cycles, stats.Instructions, stats.CacheReferences, stats.CacheMisses)
+
+	c.prev, c.prevTime = readings, now
+	return stats, nil
+}
+
+// followHotplug closes the counters of the CPUs that were taken offline and opens counters
+// on the CPUs that were brought online since the previous collection. If the online CPUs
+// can't be read, the counters are kept as they are.
+func (c *CPUPerfCollector) followHotplug() {
+	cpus, err := readOnlineCPUs(c.onlinePath)
+	if err != nil {
+		c.Logger().V(1).Info("failed to read online CPUs, keeping the counted CPUs", "error", err)
+		return
+	}
+	if len(cpus) == len(c.groups) && slices.EqualFunc(cpus, c.groups, func(cpu int, g *perfGroup) bool {
+		return cpu == g.cpu
+	}) {
+		return
+	}
+
+	current := make(map[int]int, len(c.groups))
+	for i, group := range c.groups {
+		current[group.cpu] = i
+	}
+	groups := make([]*perfGroup, 0, len(cpus))
+	prev := make([]perfReading, 0, len(cpus))
+	var onlined, offlined []int
+	for _, cpu := range cpus {
+		if i, ok := current[cpu]; ok {
+			groups = append(groups, c.groups[i])
+			prev = append(prev, c.prev[i])
+			delete(current, cpu)
+			continue
+		}
+		group, err := openPerfGroup(cpu)
+		if err != nil {
+			c.Logger().Error(err, "failed to open perf events on CPU brought online", "cpu", cpu)
+			continue
+		}
+		// A new group counts from 0
+		groups = append(groups, group)
+		prev = append(prev, perfReading{})
+		onlined = append(onlined, cpu)
+	}
+	for cpu, i := range current {
+		c.groups[i].close()
+		offlined = append(offlined, cpu)
+	}
+	slices.Sort(offlined)
+	c.groups, c.prev = groups, prev
+	if len(onlined) > 0 || len(offlined) > 0 {
+		c.Logger().Info("counted CPUs changed", "onlined", onlined, "offlined", offlined)
+	}
+}
+
+// readOnlineCPUs reads the online CPUs, in ascending order
+func readOnlineCPUs(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read online CPUs: %w", err)
+	}
+	cpus, err := procparse.ParseCPUList(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	slices.Sort(cpus)
+	return cpus, nil
+}
+
+// perfCounters returns the counts between two readings of a CPU. Counts of multiplexed
+// counters are scaled up to the time they were enabled.
+func perfCounters(prev, cur perfReading) performance.CPUPerfCounters {
+	enabled := cur.enabled - prev.enabled
+	running := cur.running - prev.running
+
+	var deltas [numPerfEvents]uint64
+	for i := range deltas {
+		deltas[i] = cur.values[i] - prev.values[i]
+		if running > 0 && running < enabled {
+			deltas[i] = uint64(float64(deltas[i]) * float64(enabled) / float64(running))
+		}
+	}
+
+	counters := performance.CPUPerfCounters{
+		Cycles:          deltas[perfCycles],
+		Instructions:    deltas[perfInstructions],
+		CacheReferences: deltas[perfCacheReferences],
+		CacheMisses:     deltas[perfCacheMisses],
+	}
+	if enabled > 0 {
+		counters.Running = 100 * float64(running) / float64(enabled)
+	}
+	counters.IPC, counters.CacheMissRate = perfRatios(counters.Cycles, counters.Instructions,
+		counters.CacheReferences, counters.CacheMisses)
+	return counters
+}
+
+// perfRatios returns the instructions per cycle and the percentage of cache references
+// that missed, zero when there were no cycles or references
+func perfRatios(cycles, instructions, references, misses uint64) (ipc, missRate float64) {
+	if cycles > 0 {
+		ipc = float64(instructions) / float64(cycles)
+	}
+	if references > 0 {
+		missRate = 100 * float64(misses) / float64(references)
+	}
+	return ipc, missRate
+}
@@ -0,0 +1,157 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build linux
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCPUPerfCollector(t *testing.T, procFiles, sysFiles map[string]string) (*collectors.CPUPerfCollector, error) {
+	procPath, sysPath := t.TempDir(), t.TempDir()
+	writeSysFiles(t, procPath, procFiles)
+	writeSysFiles(t, sysPath, sysFiles)
+	return collectors.NewCPUPerfCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostSysPath:  sysPath,
+	})
+}
+
+func TestCPUPerfCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewCPUPerfCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: "relative",
+		HostSysPath:  "/sys",
+	})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewCPUPerfCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: "/proc",
+		HostSysPath:  "relative",
+	})
+	assert.ErrorContains(t, err, "HostSysPath must be an absolute path")
+}
+
+func TestCPUPerfCollector_Unsupported(t *testing.T) {
+	tests := []struct {
+		name      string
+		procFiles map[string]string
+		sysFiles  map[string]string
+		wantErr   string
+	}{
+		{
+			name:     "kernel without perf events",
+			sysFiles: map[string]string{"devices/system/cpu/online": "0-3\n"},
+			wantErr:  "perf events aren't supported by the kernel",
+		},
+		{
+			name:      "invalid online CPUs",
+			procFiles: map[string]string{"sys/kernel/perf_event_paranoid": "2\n"},
+			sysFiles:  map[string]string{"devices/system/cpu/online": "3-1\n"},
+			wantErr:   `invalid CPU range "3-1"`,
+		},
+		{
+			name:      "no online CPUs",
+			procFiles: map[string]string{"sys/kernel/perf_event_paranoid": "2\n"},
+			sysFiles:  map[string]string{"devices/system/cpu/online": "\n"},
+			wantErr:   "no CPUs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newCPUPerfCollector(t, tt.procFiles, tt.sysFiles)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+// TestCPUPerfCollector_Host counts on the CPUs of the host running the test if it exposes
+// hardware counters to the test, and otherwise checks that the collector explains why
+// it can't be created
+func TestCPUPerfCollector_Host(t *testing.T) {
+	collector, err := collectors.NewCPUPerfCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: "/proc",
+		HostSysPath:  "/sys",
+	})
+	if err != nil {
+		assert.Regexp(t, "need CAP_PERFMON|aren't available|aren't supported", err.Error())
+		return
+	}
+
+	// Keep the CPU busy so that there is something to count
+	sum := 0
+	for i := range 10_000_000 {
+		sum += i
+	}
+	require.NotZero(t, sum)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.CPUPerfStats)
+	require.True(t, ok)
+
+	require.NotEmpty(t, stats.CPUs)
+	assert.Positive(t, stats.Interval)
+	assert.Positive(t, stats.Cycles)
+	assert.Positive(t, stats.Instructions)
+	assert.InDelta(t, float64(stats.Instructions)/float64(stats.Cycles), stats.IPC, 1e-9)
+	var cycles uint64
+	for _, cpu := range stats.CPUs {
+		cycles += cpu.Cycles
+		assert.LessOrEqual(t, cpu.CacheMisses, cpu.CacheReferences)
+	}
+	assert.Equal(t, stats.Cycles, cycles)
+}
+
+// TestCPUPerfCollector_Hotplug follows fake online CPUs on the host running the test if it
+// exposes hardware counters to the test
+func TestCPUPerfCollector_Hotplug(t *testing.T) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("needs 2 CPUs")
+	}
+	sysPath := t.TempDir()
+	online := filepath.Join(sysPath, "devices/system/cpu/online")
+	writeSysFiles(t, sysPath, map[string]string{"devices/system/cpu/online": "0\n"})
+	collector, err := collectors.NewCPUPerfCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: "/proc",
+		HostSysPath:  sysPath,
+	})
+	if err != nil {
+		t.Skipf("hardware counters aren't available: %v", err)
+	}
+
+	countedCPUs := func() []int32 {
+		result, err := collector.Collect(context.Background())
+		require.NoError(t, err)
+		var cpus []int32
+		for _, cpu := range result.(*performance.CPUPerfStats).CPUs {
+			cpus = append(cpus, cpu.CPU)
+		}
+		return cpus
+	}
+	assert.Equal(t, []int32{0}, countedCPUs())
+
+	require.NoError(t, os.WriteFile(online, []byte("0-1\n"), 0o644))
+	assert.Equal(t, []int32{0, 1}, countedCPUs())
+
+	require.NoError(t, os.WriteFile(online, []byte("1\n"), 0o644))
+	assert.Equal(t, []int32{1}, countedCPUs())
+
+	// The counted CPUs are kept while the online CPUs can't be read
+	require.NoError(t, os.Remove(online))
+	assert.Equal(t, []int32{1}, countedCPUs())
+}
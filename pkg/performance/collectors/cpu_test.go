@@ -0,0 +1,103 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectCPU(t *testing.T, collector *collectors.CPUCollector) []performance.CPUStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.([]performance.CPUStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestCPUCollector_Utilization(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{
+		"stat": "cpu  100 0 100 700 100 0 0 0 0 0\n" +
+			"cpu0 50 0 50 350 50 0 0 0 0 0\n" +
+			"cpu1 50 0 50 350 50 0 0 0 0 0\n" +
+			"intr 12345\nbtime 1700000000\n",
+	})
+	collector, err := collectors.NewCPUCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	// The first collection reports the utilization since boot
+	stats := collectCPU(t, collector)
+	require.Len(t, stats, 3)
+	assert.Equal(t, int32(-1), stats[0].CPUIndex)
+	assert.Equal(t, uint64(1000), stats[0].DeltaTotal)
+	assert.InDelta(t, 20, stats[0].Utilization, 0.001)
+	assert.Equal(t, int32(1), stats[2].CPUIndex)
+
+	// cpu0 is fully busy and cpu1 idle since, and cpu1 has an old kernel's fields
+	writeSysFiles(t, procPath, map[string]string{
+		"stat": "cpu  180 0 120 800 100 0 0 0 0 0\n" +
+			"cpu0 130 0 70 350 50 0 0 0 0 0\n" +
+			"cpu1 50 0 50 450 50\n",
+	})
+	stats = collectCPU(t, collector)
+	require.Len(t, stats, 3)
+	assert.InDelta(t, 50, stats[0].Utilization, 0.001)
+	assert.InDelta(t, 100, stats[1].Utilization, 0.001)
+	assert.Equal(t, uint64(100), stats[1].DeltaTotal)
+	assert.InDelta(t, 0, stats[2].Utilization, 0.001)
+	assert.Equal(t, uint64(180), stats[0].User)
+}
+
+func TestCPUCollector_StealPercent(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "cpu  100 0 100 700 0 0 0 100 0 0\n"})
+	collector, err := collectors.NewCPUCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	stats := collectCPU(t, collector)
+	require.Len(t, stats, 1)
+	assert.InDelta(t, 10, stats[0].StealPercent, 0.001)
+
+	// A third of the interval was stolen
+	writeSysFiles(t, procPath, map[string]string{"stat": "cpu  150 0 100 750 0 0 0 150 0 0\n"})
+	stats = collectCPU(t, collector)
+	require.Len(t, stats, 1)
+	assert.Equal(t, uint64(150), stats[0].DeltaTotal)
+	assert.InDelta(t, 33.333, stats[0].StealPercent, 0.001)
+	assert.InDelta(t, 66.667, stats[0].Utilization, 0.001)
+}
+
+func TestCPUCollector_CountersReset(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "cpu0 500 0 500 1000 0 0 0 0 0 0\n"})
+	collector, err := collectors.NewCPUCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	collectCPU(t, collector)
+
+	// A CPU brought back online starts counting from 0
+	writeSysFiles(t, procPath, map[string]string{"stat": "cpu0 10 0 10 80 0 0 0 0 0 0\n"})
+	stats := collectCPU(t, collector)
+	require.Len(t, stats, 1)
+	assert.Equal(t, uint64(100), stats[0].DeltaTotal)
+	assert.InDelta(t, 20, stats[0].Utilization, 0.001)
+}
+
+func TestCPUCollector_Malformed(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "cpu  100 abc 100 700\n"})
+	collector, err := collectors.NewCPUCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	_, err = collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to parse")
+}
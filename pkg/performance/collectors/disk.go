@@ -0,0 +1,399 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.StatefulCollector = (*DiskCollector)(nil)
+
+// DiskCollector collects per-disk IO statistics and detects disk saturation episodes.
+//
+// The counters in /proc/diskstats are cumulative since boot, so the rates, utilization,
+// queue size and latencies are computed from the difference to the previous collection
+// and are zero on the first one. The extended statistics are computed like iostat -x does,
+// so they can be compared with its output: request and merge rates, merge ratios, average
+// request sizes, await and the service time, which iostat deprecated as it is only an
+// estimate on devices serving requests in parallel.
+//
+// Raw counters make it hard to tell when a disk was the bottleneck, so the collector also
+// reports saturation episodes with their start and end times: runs of consecutive
+// collections in which the disk's utilization stayed above
+// CollectionConfig.DiskSaturationUtilization or its queue depth spiked (see
+// DiskSaturationDetector).
+//
+// Data sources:
+//   - /proc/diskstats: IO counters of all block devices
+//   - /sys/block/: the whole disks; partitions and loop and ram devices are left out
+//
+// Reference: https://www.kernel.org/doc/html/latest/admin-guide/iostats.html
+type DiskCollector struct {
+	performance.BaseCollector
+	diskstatsPath string
+	blockPath     string
+
+	mu sync.Mutex
+	// Counters of the previous collection keyed by device
+	prev       map[string]performance.DiskStats
+	prevTime   time.Time
+	saturation *DiskSaturationDetector
+}
+
+func NewDiskCollector(logger logr.Logger, config performance.CollectionConfig) (*DiskCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	utilization := config.DiskSaturationUtilization
+	if utilization == 0 {
+		utilization = performance.DefaultDiskSaturationUtilization
+	}
+	samples := config.DiskSaturationSamples
+	if samples == 0 {
+		samples = performance.DefaultDiskSaturationSamples
+	}
+
+	return &DiskCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeDisk,
+			"Disk Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		diskstatsPath: filepath.Join(config.HostProcPath, "diskstats"),
+		blockPath:     filepath.Join(config.HostSysPath, "block"),
+		saturation:    NewDiskSaturationDetector(utilization, samples),
+	}, nil
+}
+
+func (c *DiskCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectDiskStats(ctx, time.Now())
+}
+
+func (c *DiskCollector) collectDiskStats(ctx context.Context, now time.Time) ([]performance.DiskStats, error) {
+	disks, err := c.parseDiskstats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read diskstats: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev := c.prev
+	prevTime := c.prevTime
+	c.prev = make(map[string]performance.DiskStats, len(disks))
+	c.prevTime = now
+
+	seen := make(map[string]bool, len(disks))
+	for i := range disks {
+		disk := &disks[i]
+		c.prev[disk.Device] = *disk
+		seen[disk.Device] = true
+
+		p, ok := prev[disk.Device]
+		if !ok || prevTime.IsZero() {
+			continue
+		}
+		elapsed := now.Sub(prevTime)
+		if elapsed <= 0 {
+			continue
+		}
+		setDiskRates(disk, p, elapsed)
+		disk.Saturation = c.saturation.Observe(prevTime, now, *disk)
+	}
+	c.saturation.retain(seen)
+
+	return disks, nil
+}
+
+// diskState is the persisted state of the DiskCollector
+type diskState struct {
+	Time  time.Time                        `json:"time"`
+	Disks map[string]performance.DiskStats `json:"disks"`
+}
+
+func (c *DiskCollector) SaveState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prevTime.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(diskState{Time: c.prevTime, Disks: c.prev})
+}
+
+func (c *DiskCollector) RestoreState(data []byte) error {
+	var state diskState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prevTime = state.Time
+	c.prev = state.Disks
+	return nil
+}
+
+// setDiskRates computes the rates of disk over the interval elapsed since prev
+func setDiskRates(disk *performance.DiskStats, prev performance.DiskStats, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	ms := float64(elapsed.Milliseconds())
+
+	reads := counterDelta(prev.ReadsCompleted, disk.ReadsCompleted)
+	writes := counterDelta(prev.WritesCompleted, disk.WritesCompleted)
+	readMerges := counterDelta(prev.ReadsMerged, disk.ReadsMerged)
+	writeMerges := counterDelta(prev.WritesMerged, disk.WritesMerged)
+	readTime := counterDelta(prev.ReadTime, disk.ReadTime)
+	writeTime := counterDelta(prev.WriteTime, disk.WriteTime)
+	ioTime := counterDelta(prev.IOTime, disk.IOTime)
+
+	disk.IOPS = float64(reads+writes) / seconds
+	disk.ReadsPerSec = float64(reads) / seconds
+	disk.WritesPerSec = float64(writes) / seconds
+	disk.ReadMergesPerSec = float64(readMerges) / seconds
+	disk.WriteMergesPerSec = float64(writeMerges) / seconds
+	disk.ReadBytesPerSec = counterRate(prev.SectorsRead, disk.SectorsRead, seconds) * 512
+	disk.WriteBytesPerSec = counterRate(prev.SectorsWritten, disk.SectorsWritten, seconds) * 512
+	if ms > 0 {
+		// io_ticks can run slightly ahead of the wall clock
+		disk.Utilization = min(float64(ioTime)/ms*100, 100)
+		disk.AvgQueueSize = float64(counterDelta(prev.WeightedIOTime, disk.WeightedIOTime)) / ms
+	}
+	if reads+readMerges > 0 {
+		disk.ReadMergeRatio = float64(readMerges) / float64(reads+readMerges) * 100
+	}
+	if writes+writeMerges > 0 {
+		disk.WriteMergeRatio = float64(writeMerges) / float64(writes+writeMerges) * 100
+	}
+	if reads > 0 {
+		disk.AvgReadLatency = float64(readTime) / float64(reads)
+		disk.AvgReadSize = float64(counterDelta(prev.SectorsRead, disk.SectorsRead)) * 512 / float64(reads)
+	}
+	if writes > 0 {
+		disk.AvgWriteLatency = float64(writeTime) / float64(writes)
+		disk.AvgWriteSize = float64(counterDelta(prev.SectorsWritten, disk.SectorsWritten)) * 512 / float64(writes)
+	}
+	if reads+writes > 0 {
+		disk.Await = float64(readTime+writeTime) / float64(reads+writes)
+		disk.ServiceTime = float64(ioTime) / float64(reads+writes)
+	}
+}
+
+// counterDelta returns the increase of a monotonic counter, 0 if it was reset
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+// parseDiskstats parses /proc/diskstats.
+//
+// Format:
+//
+//	8       0 sda 4123 1021 301234 2311 9841 3312 512344 10233 0 8123 12544 ...
+//
+// The fields after the first 14 (discards since 4.18, flushes since 5.5) aren't used.
+func (c *DiskCollector) parseDiskstats(ctx context.Context) ([]performance.DiskStats, error) {
+	file, err := os.Open(c.diskstatsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Without /sys/block, e.g. if /sys isn't mounted, partitions can't be told apart
+	_, err = os.Stat(c.blockPath)
+	filterPartitions := err == nil
+
+	var disks []performance.DiskStats
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		// Slashes in device names (e.g. cciss/c0d0) are replaced by '!' in sysfs
+		if filterPartitions && !exists(filepath.Join(c.blockPath, strings.ReplaceAll(name, "/", "!"))) {
+			continue
+		}
+
+		major, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		minor, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		var counters [11]uint64
+		valid := true
+		for i := range counters {
+			counters[i], err = strconv.ParseUint(fields[3+i], 10, 64)
+			if err != nil {
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		disks = append(disks, performance.DiskStats{
+			Device:          name,
+			Major:           uint32(major),
+			Minor:           uint32(minor),
+			ReadsCompleted:  counters[0],
+			ReadsMerged:     counters[1],
+			SectorsRead:     counters[2],
+			ReadTime:        counters[3],
+			WritesCompleted: counters[4],
+			WritesMerged:    counters[5],
+			SectorsWritten:  counters[6],
+			WriteTime:       counters[7],
+			IOsInProgress:   counters[8],
+			IOTime:          counters[9],
+			WeightedIOTime:  counters[10],
+		})
+	}
+	return disks, scanner.Err()
+}
+
+const (
+	// A queue depth spike is an average queue size of at least queueSpikeFactor times the
+	// device's baseline and at least minQueueSpike requests
+	queueSpikeFactor = 4
+	minQueueSpike    = 4
+	// Weight of a new unsaturated interval in the baseline queue size
+	queueBaselineWeight = 0.2
+)
+
+// DiskSaturationDetector turns the per-interval utilization and queue size of disks into
+// saturation episodes. An interval is saturated if the disk's utilization is at least the
+// utilization threshold or its average queue size spiked above its baseline, the moving
+// average of the queue size of unsaturated intervals. An episode is reported once it lasted
+// for the configured number of consecutive intervals, and ends with the first unsaturated
+// interval. Shorter bursts are ignored.
+type DiskSaturationDetector struct {
+	utilization float64
+	samples     int
+	devices     map[string]*diskSaturationState
+}
+
+type diskSaturationState struct {
+	baselineQueue float64
+	hasBaseline   bool
+	// Saturated intervals so far, nil if the last interval wasn't saturated
+	episode *performance.DiskSaturationEvent
+	// End of the last saturated interval
+	episodeEnd time.Time
+}
+
+// NewDiskSaturationDetector creates a detector reporting episodes of at least samples
+// intervals with a utilization percentage of at least utilization or a queue depth spike
+func NewDiskSaturationDetector(utilization float64, samples int) *DiskSaturationDetector {
+	return &DiskSaturationDetector{
+		utilization: utilization,
+		samples:     max(samples, 1),
+		devices:     make(map[string]*diskSaturationState),
+	}
+}
+
+// Observe records the utilization and queue size of disk over the interval from start to
+// end and returns the episode of the disk to report: the ongoing one, or the one that
+// ended with this interval.
+func (d *DiskSaturationDetector) Observe(start, end time.Time, disk performance.DiskStats) []performance.DiskSaturationEvent {
+	state, ok := d.devices[disk.Device]
+	if !ok {
+		state = &diskSaturationState{}
+		d.devices[disk.Device] = state
+	}
+
+	highUtilization := disk.Utilization >= d.utilization
+	queueSpike := state.hasBaseline && disk.AvgQueueSize >= minQueueSpike &&
+		disk.AvgQueueSize >= queueSpikeFactor*state.baselineQueue
+
+	if !highUtilization && !queueSpike {
+		if state.hasBaseline {
+			state.baselineQueue += queueBaselineWeight * (disk.AvgQueueSize - state.baselineQueue)
+		} else {
+			state.baselineQueue = disk.AvgQueueSize
+			state.hasBaseline = true
+		}
+
+		episode := state.episode
+		state.episode = nil
+		if episode == nil || episode.Samples < d.samples {
+			return nil
+		}
+		episode.End = state.episodeEnd
+		return []performance.DiskSaturationEvent{*episode}
+	}
+
+	if state.episode == nil {
+		state.episode = &performance.DiskSaturationEvent{
+			Device: disk.Device,
+			Start:  start,
+		}
+	}
+	episode := state.episode
+	state.episodeEnd = end
+	episode.Samples++
+	episode.HighUtilization = episode.HighUtilization || highUtilization
+	episode.QueueSpike = episode.QueueSpike || queueSpike
+	episode.PeakUtilization = max(episode.PeakUtilization, disk.Utilization)
+	episode.PeakQueueSize = max(episode.PeakQueueSize, disk.AvgQueueSize)
+
+	if episode.Samples < d.samples {
+		return nil
+	}
+	return []performance.DiskSaturationEvent{*episode}
+}
+
+// retain forgets the state of the devices not in devices, e.g. detached disks
+func (d *DiskSaturationDetector) retain(devices map[string]bool) {
+	for device := range d.devices {
+		if !devices[device] {
+			delete(d.devices, device)
+		}
+	}
+}
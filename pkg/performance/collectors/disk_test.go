@@ -0,0 +1,204 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDiskstats = `   7       0 loop0 10 0 20 1 0 0 0 0 0 1 1 0 0 0 0
+   8       0 sda 4000 100 300000 2000 1000 50 80000 3000 2 4000 5000 0 0 0 0 0 0
+   8       1 sda1 3900 100 290000 1900 1000 50 80000 3000 0 3900 4900 0 0 0 0 0 0
+ 259       0 nvme0n1 100 0 800 10 200 0 1600 40 0 50 50
+`
+
+func createDiskCollector(t *testing.T, diskstats string) (*collectors.DiskCollector, string) {
+	root := t.TempDir()
+	procPath := filepath.Join(root, "proc")
+	sysPath := filepath.Join(root, "sys")
+	writeSysFiles(t, procPath, map[string]string{"diskstats": diskstats})
+	writeSysFiles(t, sysPath, map[string]string{
+		"block/sda/dev":     "8:0\n",
+		"block/nvme0n1/dev": "259:0\n",
+		"block/loop0/dev":   "7:0\n",
+	})
+
+	collector, err := collectors.NewDiskCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostSysPath:  sysPath,
+	})
+	require.NoError(t, err)
+	return collector, procPath
+}
+
+func collectDiskStats(t *testing.T, collector *collectors.DiskCollector) []performance.DiskStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.([]performance.DiskStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestDiskCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewDiskCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative", HostSysPath: "/sys"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewDiskCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/proc", HostSysPath: "relative"})
+	assert.ErrorContains(t, err, "HostSysPath must be an absolute path")
+
+	_, err = collectors.NewDiskCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist", HostSysPath: "/sys"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestDiskCollector_Counters(t *testing.T) {
+	collector, _ := createDiskCollector(t, testDiskstats)
+	stats := collectDiskStats(t, collector)
+
+	require.Len(t, stats, 2, "partitions and loop devices are left out")
+	assert.Equal(t, performance.DiskStats{
+		Device:          "sda",
+		Major:           8,
+		Minor:           0,
+		ReadsCompleted:  4000,
+		ReadsMerged:     100,
+		SectorsRead:     300000,
+		ReadTime:        2000,
+		WritesCompleted: 1000,
+		WritesMerged:    50,
+		SectorsWritten:  80000,
+		WriteTime:       3000,
+		IOsInProgress:   2,
+		IOTime:          4000,
+		WeightedIOTime:  5000,
+	}, stats[0])
+	assert.Equal(t, "nvme0n1", stats[1].Device)
+	assert.Equal(t, uint64(50), stats[1].WeightedIOTime)
+	assert.Zero(t, stats[0].IOPS, "rates need a previous collection")
+	assert.Empty(t, stats[0].Saturation)
+}
+
+func TestDiskCollector_Rates(t *testing.T) {
+	collector, procPath := createDiskCollector(t, testDiskstats)
+	collectDiskStats(t, collector)
+
+	time.Sleep(50 * time.Millisecond)
+	// 100 reads of 800 sectors taking 500ms in total, the disk busy for 10ms
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "diskstats"), []byte(
+		"   8       0 sda 4100 100 300800 2500 1000 50 80000 3000 0 4010 5500 0 0 0 0 0 0\n",
+	), 0644))
+	stats := collectDiskStats(t, collector)
+
+	require.Len(t, stats, 1)
+	sda := stats[0]
+	assert.Greater(t, sda.IOPS, 0.0)
+	assert.LessOrEqual(t, sda.IOPS, 100/0.05)
+	assert.LessOrEqual(t, sda.ReadBytesPerSec, 800*512/0.05)
+	assert.Zero(t, sda.WriteBytesPerSec)
+	assert.Equal(t, 5.0, sda.AvgReadLatency)
+	assert.Zero(t, sda.AvgWriteLatency)
+	assert.Greater(t, sda.Utilization, 0.0)
+	assert.LessOrEqual(t, sda.Utilization, 10/0.05/10)
+}
+
+func TestDiskCollector_ExtendedStats(t *testing.T) {
+	collector, procPath := createDiskCollector(t, testDiskstats)
+	collectDiskStats(t, collector)
+
+	time.Sleep(50 * time.Millisecond)
+	// 100 reads, with 100 more merged into them, of 4KiB each taking 500ms in total, and
+	// 300 writes, with 100 more merged, of 2KiB taking 300ms. The disk was busy for 40ms.
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "diskstats"), []byte(
+		"   8       0 sda 4100 200 300800 2500 1300 150 81200 3300 0 4040 5500 0 0 0 0 0 0\n",
+	), 0644))
+	stats := collectDiskStats(t, collector)
+
+	require.Len(t, stats, 1)
+	sda := stats[0]
+	assert.Equal(t, sda.ReadsPerSec, sda.ReadMergesPerSec)
+	assert.InDelta(t, 3*sda.ReadsPerSec, sda.WritesPerSec, 0.001)
+	assert.Greater(t, sda.WriteMergesPerSec, 0.0)
+	assert.Equal(t, 50.0, sda.ReadMergeRatio)
+	assert.Equal(t, 25.0, sda.WriteMergeRatio)
+	assert.Equal(t, 4096.0, sda.AvgReadSize)
+	assert.Equal(t, 2048.0, sda.AvgWriteSize)
+	assert.Equal(t, 5.0, sda.AvgReadLatency)
+	assert.Equal(t, 1.0, sda.AvgWriteLatency)
+	assert.Equal(t, 2.0, sda.Await)
+	assert.Equal(t, 0.1, sda.ServiceTime)
+}
+
+func TestDiskSaturationDetector(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	interval := 10 * time.Second
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * interval) }
+
+	tests := []struct {
+		name   string
+		disks  []performance.DiskStats
+		events map[int]performance.DiskSaturationEvent // expected events by interval
+	}{
+		{
+			name: "short burst is ignored",
+			disks: []performance.DiskStats{
+				{Utilization: 20}, {Utilization: 95}, {Utilization: 99}, {Utilization: 20},
+			},
+		},
+		{
+			name: "sustained high utilization",
+			disks: []performance.DiskStats{
+				{Utilization: 20}, {Utilization: 95}, {Utilization: 99}, {Utilization: 92}, {Utilization: 30},
+			},
+			events: map[int]performance.DiskSaturationEvent{
+				3: {Device: "sda", Start: at(1), HighUtilization: true, Samples: 3, PeakUtilization: 99},
+				4: {Device: "sda", Start: at(1), End: at(4), HighUtilization: true, Samples: 3, PeakUtilization: 99},
+			},
+		},
+		{
+			name: "queue depth spike",
+			disks: []performance.DiskStats{
+				{AvgQueueSize: 2}, {AvgQueueSize: 2}, {AvgQueueSize: 40}, {AvgQueueSize: 30},
+				{AvgQueueSize: 25, Utilization: 95}, {AvgQueueSize: 2},
+			},
+			events: map[int]performance.DiskSaturationEvent{
+				4: {Device: "sda", Start: at(2), HighUtilization: true, QueueSpike: true, Samples: 3,
+					PeakUtilization: 95, PeakQueueSize: 40},
+				5: {Device: "sda", Start: at(2), End: at(5), HighUtilization: true, QueueSpike: true, Samples: 3,
+					PeakUtilization: 95, PeakQueueSize: 40},
+			},
+		},
+		{
+			name: "small queue is no spike",
+			disks: []performance.DiskStats{
+				{AvgQueueSize: 0.1}, {AvgQueueSize: 3}, {AvgQueueSize: 3}, {AvgQueueSize: 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := collectors.NewDiskSaturationDetector(90, 3)
+			for i, disk := range tt.disks {
+				disk.Device = "sda"
+				events := detector.Observe(at(i), at(i+1), disk)
+				if expected, ok := tt.events[i]; ok {
+					assert.Equal(t, []performance.DiskSaturationEvent{expected}, events, "interval %d", i)
+				} else {
+					assert.Empty(t, events, "interval %d", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package execsnoop traces the processes executed on the host with eBPF, to find out what
+// a node is spawning when its CPU or PID count spikes.
+package execsnoop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/go-logr/logr"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
+
+	"github.com/antimetal/agent/pkg/ebpf/loader"
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
+)
+
+// Compile-time interface check
+var _ performance.ContinuousCollector = (*Collector)(nil)
+
+const (
+	objectName = "execsnoop.bpf.o"
+	eventsMap  = "events"
+	uidsMap    = "uids"
+	// Size of the channel buffering events for the consumer. Events are dropped while it
+	// is full rather than stalling the ring buffer reader.
+	eventBuffer = 1024
+	// Capacity of the BPF map of UIDs to trace
+	maxUIDs = 64
+)
+
+// tracepoints are the programs of the BPF object and the syscall tracepoints they attach to
+var tracepoints = map[string]loader.Tracepoint{
+	"tracepoint__syscalls__sys_enter_execve": {Group: "syscalls", Name: "sys_enter_execve"},
+	"tracepoint__syscalls__sys_exit_execve":  {Group: "syscalls", Name: "sys_exit_execve"},
+}
+
+// Collector streams a performance.ProcessExecEvent for every execve(2) call on the host.
+//
+// The BPF program captures the filename and arguments of execve on syscall entry and
+// sends them with the result on syscall exit through a ring buffer. At most 20 arguments
+// of 127 bytes each are captured, less if CollectionConfig.ExecArgsMaxLength is set.
+// Execs by UIDs not in CollectionConfig.ExecUIDs are dropped in the kernel, those of
+// tasks not in CollectionConfig.ExecCommands and those over
+// CollectionConfig.ExecEventRateLimit when read. The arguments of the execs that are
+// reported go through CollectionConfig.ArgsPolicy. execveat(2) is not traced.
+type Collector struct {
+	performance.BaseContinuousCollector
+	loader      *loader.Manager
+	argsMaxLen  int
+	uids        []uint32
+	filter      Filter
+	rateLimit   float64
+	rateLimited uint64
+	argsPolicy  *argpolicy.Policy
+
+	mu      sync.Mutex
+	bpf     *loader.Handle
+	reader  *ringbuf.Reader
+	done    chan struct{}
+	dropped uint64
+}
+
+func NewCollector(logger logr.Logger, config performance.CollectionConfig) (*Collector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    false,
+		SupportsContinuous: true,
+		RequiresRoot:       true,
+		RequiresEBPF:       true,
+		MinKernelVersion:   "5.8", // BPF ring buffer
+	}
+
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+	if config.ExecArgsMaxLength < 0 {
+		return nil, fmt.Errorf("ExecArgsMaxLength must not be negative, got: %d", config.ExecArgsMaxLength)
+	}
+	if len(config.ExecUIDs) > maxUIDs {
+		return nil, fmt.Errorf("at most %d ExecUIDs can be traced, got: %d", maxUIDs, len(config.ExecUIDs))
+	}
+	if config.ExecEventRateLimit < 0 {
+		return nil, fmt.Errorf("ExecEventRateLimit must not be negative, got: %g", config.ExecEventRateLimit)
+	}
+
+	return &Collector{
+		BaseContinuousCollector: performance.NewBaseContinuousCollector(
+			performance.MetricTypeProcessExec,
+			"Process Exec Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		loader:     loader.Shared(config.HostSysPath),
+		argsMaxLen: min(config.ExecArgsMaxLength, argsMax),
+		uids:       config.ExecUIDs,
+		filter:     NewFilter(config.ExecCommands),
+		rateLimit:  config.ExecEventRateLimit,
+		argsPolicy: config.ArgsPolicy,
+	}, nil
+}
+
+// Start loads the BPF program, attaches it to the execve tracepoints and streams the
+// traced execs until ctx is done or Stop is called
+func (c *Collector) Start(ctx context.Context) (<-chan any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bpf != nil {
+		return nil, errors.New("collector is already running")
+	}
+
+	if err := c.load(); err != nil {
+		c.close()
+		c.SetError(err)
+		return nil, err
+	}
+	bootTime, err := monotonicBootTime()
+	if err != nil {
+		c.close()
+		c.SetError(err)
+		return nil, err
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if c.rateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(c.rateLimit), max(int(c.rateLimit), 1))
+	}
+
+	ch := make(chan any, eventBuffer)
+	c.done = make(chan struct{})
+	go c.read(ctx, ch, c.reader, limiter, bootTime, c.done)
+	c.SetStatus(performance.CollectorStatusActive)
+	return ch, nil
+}
+
+// load loads the BPF object configured with the capture limits and attaches its
+// programs. Whatever was set up is released by close if it fails.
+func (c *Collector) load() error {
+	constants := map[string]any{"filter_uid": len(c.uids) > 0}
+	if c.argsMaxLen > 0 {
+		constants["max_args_len"] = uint32(c.argsMaxLen)
+	}
+	var err error
+	c.bpf, err = c.loader.Load(loader.Object{
+		Name:        objectName,
+		Constants:   constants,
+		Tracepoints: tracepoints,
+		// Populated before attaching so no exec of another UID gets through
+		Setup: func(coll *ebpf.Collection) error {
+			uids, ok := coll.Maps[uidsMap]
+			if !ok {
+				return fmt.Errorf("map %s not found in %s", uidsMap, objectName)
+			}
+			for _, uid := range c.uids {
+				if err := uids.Put(uid, uint8(1)); err != nil {
+					return fmt.Errorf("failed to add UID %d to filter: %w", uid, err)
+				}
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	events, err := c.bpf.Map(eventsMap)
+	if err != nil {
+		return err
+	}
+	c.reader, err = ringbuf.NewReader(events)
+	if err != nil {
+		return fmt.Errorf("failed to open ring buffer: %w", err)
+	}
+	return nil
+}
+
+// read sends the events of reader to ch until the reader is closed. Events over the
+// rate of limiter are dropped.
+func (c *Collector) read(ctx context.Context, ch chan<- any, reader *ringbuf.Reader, limiter *rate.Limiter,
+	bootTime time.Time, done chan<- struct{}) {
+	defer close(done)
+	defer close(ch)
+
+	// Closing the reader unblocks Read
+	stop := context.AfterFunc(ctx, func() { _ = c.Stop() })
+	defer stop()
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, ringbuf.ErrClosed) {
+			return
+		}
+		if err != nil {
+			c.Logger().Error(err, "failed to read from ring buffer")
+			continue
+		}
+
+		event, err := decodeEvent(record.RawSample, bootTime)
+		if err != nil {
+			c.Logger().V(1).Info("dropping malformed event", "error", err)
+			continue
+		}
+		if !c.filter.Match(event.Command) {
+			continue
+		}
+		if !limiter.Allow() {
+			// Only read by this goroutine
+			c.rateLimited++
+			if c.rateLimited%eventBuffer == 1 {
+				c.Logger().Info("exec rate limit exceeded, dropping exec events",
+					"limit", c.rateLimit, "dropped", c.rateLimited)
+			}
+			continue
+		}
+		event.Args, event.ArgsAction = c.argsPolicy.Apply(argpolicy.Process{
+			PID:     int32(event.PID),
+			UID:     event.UID,
+			Command: event.Command,
+		}, event.Args)
+		select {
+		case ch <- event:
+		default:
+			c.mu.Lock()
+			c.dropped++
+			dropped := c.dropped
+			c.mu.Unlock()
+			if dropped%eventBuffer == 1 {
+				c.Logger().Info("consumer is too slow, dropping exec events", "dropped", dropped)
+			}
+		}
+	}
+}
+
+// Stop detaches the BPF programs and waits for the event channel to be closed
+func (c *Collector) Stop() error {
+	c.mu.Lock()
+	done := c.done
+	c.close()
+	c.SetStatus(performance.CollectorStatusDisabled)
+	c.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+	return nil
+}
+
+// close releases the BPF resources. c.mu must be held.
+func (c *Collector) close() {
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+	if c.bpf != nil {
+		c.bpf.Close()
+		c.bpf = nil
+	}
+	c.done = nil
+}
+
+// monotonicBootTime returns the wall clock time at which CLOCK_MONOTONIC, the clock of
+// bpf_ktime_get_ns, started
+func monotonicBootTime() (time.Time, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+	return time.Now().Add(-time.Duration(ts.Nano())), nil
+}
@@ -0,0 +1,104 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package execsnoop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Layout of struct execsnoop_event in ebpf/include/execsnoop_types.h
+const (
+	taskCommLen = 16
+	argSize     = 128
+	maxArgs     = 20
+	argsMax     = maxArgs * argSize
+	headerSize  = 8 + 7*4 + taskCommLen
+)
+
+// decodeEvent decodes an event sent by the BPF program, which only sends the used part
+// of its argument buffer. bootTime converts the event's monotonic timestamp to wall
+// clock time.
+func decodeEvent(raw []byte, bootTime time.Time) (performance.ProcessExecEvent, error) {
+	if len(raw) < headerSize {
+		return performance.ProcessExecEvent{}, fmt.Errorf("event too short: %d bytes, want at least %d", len(raw), headerSize)
+	}
+	le := binary.NativeEndian
+	argsSize := int(le.Uint32(raw[28:32]))
+	if argsSize > argsMax || len(raw) < headerSize+argsSize {
+		return performance.ProcessExecEvent{}, fmt.Errorf("event of %d bytes too short for %d bytes of arguments", len(raw), argsSize)
+	}
+
+	event := performance.ProcessExecEvent{
+		Timestamp:     bootTime.Add(time.Duration(le.Uint64(raw[0:8]))),
+		PID:           le.Uint32(raw[8:12]),
+		PPID:          le.Uint32(raw[12:16]),
+		UID:           le.Uint32(raw[16:20]),
+		ArgsTruncated: le.Uint32(raw[32:36]) != 0,
+		Command:       cString(raw[36:headerSize]),
+	}
+	// The syscall returns the negated errno on failure
+	if ret := int32(le.Uint32(raw[20:24])); ret < 0 {
+		event.Errno = -ret
+	}
+
+	count := int(le.Uint32(raw[24:28]))
+	args := raw[headerSize : headerSize+argsSize]
+	for i := 0; i < count && len(args) > 0; i++ {
+		end := bytes.IndexByte(args, 0)
+		if end < 0 {
+			end = len(args)
+		}
+		if i == 0 {
+			event.Filename = string(args[:end])
+		} else {
+			event.Args = append(event.Args, string(args[:end]))
+		}
+		args = args[min(end+1, len(args)):]
+	}
+	return event, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Filter selects the execs to report by the task name of the executed process. Execs are
+// filtered by UID in the BPF program already.
+type Filter struct {
+	commands []string
+}
+
+// NewFilter returns a filter matching the task names in commands, or every task name if
+// commands is empty. Task names are cut to 15 bytes by the kernel, so are the commands.
+func NewFilter(commands []string) Filter {
+	cleaned := make([]string, 0, len(commands))
+	for _, command := range commands {
+		command = strings.TrimSpace(command)
+		if len(command) >= taskCommLen {
+			command = command[:taskCommLen-1]
+		}
+		if command != "" {
+			cleaned = append(cleaned, command)
+		}
+	}
+	return Filter{commands: cleaned}
+}
+
+// Match reports whether the exec of a task named command is reported
+func (f Filter) Match(command string) bool {
+	return len(f.commands) == 0 || slices.Contains(f.commands, command)
+}
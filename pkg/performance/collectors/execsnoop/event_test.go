@@ -0,0 +1,95 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package execsnoop
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// rawEvent encodes an event the way the BPF program sends it, with only the used part
+// of the argument buffer
+func rawEvent(ret int32, truncated bool, comm string, args ...string) []byte {
+	var buf []byte
+	for _, arg := range args {
+		buf = append(buf, arg...)
+		buf = append(buf, 0)
+	}
+	raw := make([]byte, headerSize, headerSize+len(buf))
+	le := binary.NativeEndian
+	le.PutUint64(raw[0:8], uint64(5*time.Second))
+	le.PutUint32(raw[8:12], 1234)
+	le.PutUint32(raw[12:16], 1)
+	le.PutUint32(raw[16:20], 1000)
+	le.PutUint32(raw[20:24], uint32(ret))
+	le.PutUint32(raw[24:28], uint32(len(args)))
+	le.PutUint32(raw[28:32], uint32(len(buf)))
+	if truncated {
+		le.PutUint32(raw[32:36], 1)
+	}
+	copy(raw[36:headerSize], comm)
+	return append(raw, buf...)
+}
+
+func TestDecodeEvent(t *testing.T) {
+	boot := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	event, err := decodeEvent(rawEvent(0, false, "git", "/usr/bin/git", "clone", "--depth=1"), boot)
+	require.NoError(t, err)
+	assert.Equal(t, performance.ProcessExecEvent{
+		Timestamp: boot.Add(5 * time.Second),
+		PID:       1234,
+		PPID:      1,
+		UID:       1000,
+		Command:   "git",
+		Filename:  "/usr/bin/git",
+		Args:      []string{"clone", "--depth=1"},
+	}, event)
+
+	event, err = decodeEvent(rawEvent(-2, false, "sh", "/missing"), boot)
+	require.NoError(t, err)
+	assert.Equal(t, "/missing", event.Filename)
+	assert.Empty(t, event.Args)
+	assert.Equal(t, int32(2), event.Errno, "ENOENT")
+
+	// Cut short by the length limit: the last argument is still NUL terminated
+	event, err = decodeEvent(rawEvent(0, true, "make", "/usr/bin/make", "-j"), boot)
+	require.NoError(t, err)
+	assert.True(t, event.ArgsTruncated)
+	assert.Equal(t, []string{"-j"}, event.Args)
+
+	_, err = decodeEvent(make([]byte, headerSize-1), boot)
+	assert.Error(t, err, "short header")
+
+	raw := rawEvent(0, false, "ls", "/bin/ls", strings.Repeat("a", 10))
+	_, err = decodeEvent(raw[:len(raw)-1], boot)
+	assert.Error(t, err, "short arguments")
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		commands []string
+		command  string
+		expected bool
+	}{
+		{nil, "bash", true},
+		{[]string{" ", ""}, "bash", true},
+		{[]string{"bash", "git"}, "git", true},
+		{[]string{"bash"}, "bas", false},
+		{[]string{"kube-controller-manager"}, "kube-controller", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NewFilter(tt.commands).Match(tt.command), "commands %q, command %q", tt.commands, tt.command)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*IPVSCollector)(nil)
+
+// IPVSCollector collects the IPVS virtual services that kube-proxy programs in ipvs mode,
+// along with their connection counts and packet rates. Saturation of the kube-proxy
+// dataplane doesn't show in the interface counters of the network collectors.
+//
+// Data sources:
+//   - /proc/1/net/ip_vs: virtual services, their real servers and connection counts in
+//     the host's network namespace
+//   - /proc/1/net/ip_vs_stats: connection, packet and byte counters of IPVS and the rates
+//     the kernel estimates from them
+//   - /proc/self/net/ip_vs{,_stats}: fallback when PID 1 isn't visible. They only cover
+//     the agent's own network namespace.
+//
+// The files only exist while the ip_vs module is loaded, so their absence is reported as
+// IPVS being disabled rather than as an error. kube-proxy in iptables or nftables mode
+// doesn't use IPVS; the counters of nftables rules are only available through netlink and
+// aren't collected.
+//
+// Reference: https://git.kernel.org/pub/scm/linux/kernel/git/torvalds/linux.git/tree/net/netfilter/ipvs/ip_vs_ctl.c
+// Reference: https://kubernetes.io/docs/reference/networking/virtual-ips/#proxy-mode-ipvs
+type IPVSCollector struct {
+	performance.BaseCollector
+	netPaths []string
+}
+
+func NewIPVSCollector(logger logr.Logger, config performance.CollectionConfig) (*IPVSCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.10", // IPVS merged into the kernel
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &IPVSCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeIPVS,
+			"IPVS Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		netPaths: []string{
+			filepath.Join(config.HostProcPath, "1", "net"),
+			filepath.Join(config.HostProcPath, "self", "net"),
+		},
+	}, nil
+}
+
+func (c *IPVSCollector) Collect(ctx context.Context) (any, error) {
+	netPath, ok := c.netPath()
+	if !ok {
+		return &performance.IPVSStats{}, nil
+	}
+
+	stats, err := readIPVSServices(filepath.Join(netPath, "ip_vs"))
+	if err != nil {
+		return nil, err
+	}
+	if err := readIPVSCounters(filepath.Join(netPath, "ip_vs_stats"), stats); err != nil {
+		// The services are still useful without the counters
+		c.Logger().V(1).Info("Failed to read IPVS counters", "error", err)
+	}
+	return stats, nil
+}
+
+// netPath returns the first net directory with an IPVS table, or false if ip_vs isn't
+// loaded
+func (c *IPVSCollector) netPath() (string, bool) {
+	for _, path := range c.netPaths {
+		// The net directory of PID 1 is readable whether or not ip_vs is loaded, so only
+		// fall back to self if the directory itself isn't visible
+		if _, err := os.Stat(filepath.Join(path, "ip_vs")); err == nil {
+			return path, true
+		}
+		if _, err := os.Stat(path); err == nil {
+			c.Logger().V(1).Info("IPVS not loaded", "path", path)
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// readIPVSServices parses /proc/net/ip_vs. IPv4 addresses and all ports are hexadecimal,
+// and the flags of a service, like "persistent 360 FFFFFFFF", follow its scheduler.
+//
+// Format:
+//
+//	IP Virtual Server version 1.2.1 (size=4096)
+//	Prot LocalAddress:Port Scheduler Flags
+//	  -> RemoteAddress:Port Forward Weight ActiveConn InActConn
+//	TCP  0A600001:01BB rr
+//	  -> AC110002:1A0A      Masq    1      3          1
+//	TCP  [fd00:0000:0000:0000:0000:0000:0000:0001]:0050 rr
+//	FWM  00000001 rr
+func readIPVSServices(path string) (*performance.IPVSStats, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// ip_vs was unloaded since the directory was picked
+		return &performance.IPVSStats{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IPVS table: %w", err)
+	}
+	defer file.Close()
+
+	stats := &performance.IPVSStats{Enabled: true}
+	var service *performance.IPVSVirtualService
+	scanner := bufio.NewScanner(file)
+	for range 3 {
+		scanner.Scan() // Skip the header
+	}
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 6 && fields[0] == "->" {
+			if service == nil {
+				continue
+			}
+			active, _ := strconv.ParseUint(fields[4], 10, 64)
+			inactive, _ := strconv.ParseUint(fields[5], 10, 64)
+			service.Destinations++
+			service.ActiveConns += active
+			service.InactiveConns += inactive
+			continue
+		}
+		if len(fields) < 3 {
+			continue
+		}
+		address, err := parseIPVSAddress(fields[0], fields[1])
+		if err != nil {
+			service = nil
+			continue
+		}
+		stats.VirtualServices = append(stats.VirtualServices, performance.IPVSVirtualService{
+			Protocol:  fields[0],
+			Address:   address,
+			Scheduler: fields[2],
+		})
+		service = &stats.VirtualServices[len(stats.VirtualServices)-1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read IPVS table: %w", err)
+	}
+
+	stats.Services = len(stats.VirtualServices)
+	for _, vs := range stats.VirtualServices {
+		stats.Destinations += vs.Destinations
+		stats.ActiveConns += vs.ActiveConns
+		stats.InactiveConns += vs.InactiveConns
+	}
+	return stats, nil
+}
+
+// parseIPVSAddress formats the address of a virtual service as host:port, or as the
+// decimal firewall mark of FWM services
+func parseIPVSAddress(protocol, s string) (string, error) {
+	if protocol == "FWM" {
+		mark, err := strconv.ParseUint(s, 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid firewall mark %q: %w", s, err)
+		}
+		return strconv.FormatUint(mark, 10), nil
+	}
+
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port in %q: %w", s, err)
+	}
+
+	var ip net.IP
+	if host := s[:i]; strings.HasPrefix(host, "[") {
+		ip = net.ParseIP(strings.Trim(host, "[]"))
+	} else if addr, err := strconv.ParseUint(host, 16, 32); err == nil {
+		// Printed in host byte order from the network order address
+		ip = net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+	}
+	if ip == nil {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
+
+// readIPVSCounters parses /proc/net/ip_vs_stats into stats. The first row of values are
+// the counters, the second the estimated rates, all hexadecimal.
+//
+// Format:
+//
+//	  Total Incoming Outgoing         Incoming         Outgoing
+//	  Conns  Packets  Packets            Bytes            Bytes
+//	     1B      2D5      1F4             8A3C             F2E1
+//
+//	Conns/s   Pkts/s   Pkts/s          Bytes/s          Bytes/s
+//	      2       10        C              3E8              7D0
+func readIPVSCounters(path string, stats *performance.IPVSStats) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read IPVS counters: %w", err)
+	}
+
+	var rows [][5]uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			continue
+		}
+		var row [5]uint64
+		valid := true
+		for i, field := range fields {
+			if row[i], err = strconv.ParseUint(field, 16, 64); err != nil {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) != 2 {
+		return fmt.Errorf("unexpected format of %s: found %d rows of values", path, len(rows))
+	}
+
+	stats.Conns, stats.InPackets, stats.OutPackets, stats.InBytes, stats.OutBytes =
+		rows[0][0], rows[0][1], rows[0][2], rows[0][3], rows[0][4]
+	stats.ConnsPerSecond, stats.InPacketsPerSecond, stats.OutPacketsPerSecond,
+		stats.InBytesPerSecond, stats.OutBytesPerSecond =
+		rows[1][0], rows[1][1], rows[1][2], rows[1][3], rows[1][4]
+	return nil
+}
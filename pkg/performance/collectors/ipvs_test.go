@@ -0,0 +1,109 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A ClusterIP service with two endpoints, an IPv6 service without endpoints, a
+// persistent firewall mark service and an unparseable service whose real server is ignored
+const testIPVSTable = `IP Virtual Server version 1.2.1 (size=4096)
+Prot LocalAddress:Port Scheduler Flags
+  -> RemoteAddress:Port Forward Weight ActiveConn InActConn
+TCP  0A600001:01BB rr
+  -> AC110002:1A0A      Masq    1      3          1
+  -> AC110003:1A0A      Masq    1      2          0
+TCP  [fd00:0000:0000:0000:0000:0000:0000:000a]:0035 rr
+FWM  00000064 sh persistent 360 FFFFFFFF
+  -> AC110004:0050      Route   1      0          7
+TCP  garbage rr
+  -> AC110005:0050      Masq    1      9          9
+`
+
+const testIPVSStats = `   Total Incoming Outgoing         Incoming         Outgoing
+   Conns  Packets  Packets            Bytes            Bytes
+      1B      2D5      1F4             8A3C             F2E1
+
+ Conns/s   Pkts/s   Pkts/s          Bytes/s          Bytes/s
+       2       10        C              3E8              7D0
+`
+
+func collectIPVS(t *testing.T, files map[string]string) *performance.IPVSStats {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, files)
+	collector, err := collectors.NewIPVSCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.IPVSStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestIPVSCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewIPVSCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewIPVSCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestIPVSCollector_Services(t *testing.T) {
+	stats := collectIPVS(t, map[string]string{
+		"1/net/ip_vs":       testIPVSTable,
+		"1/net/ip_vs_stats": testIPVSStats,
+	})
+
+	assert.True(t, stats.Enabled)
+	assert.Equal(t, []performance.IPVSVirtualService{
+		{Protocol: "TCP", Address: "10.96.0.1:443", Scheduler: "rr", Destinations: 2, ActiveConns: 5, InactiveConns: 1},
+		{Protocol: "TCP", Address: "[fd00::a]:53", Scheduler: "rr"},
+		{Protocol: "FWM", Address: "100", Scheduler: "sh", Destinations: 1, InactiveConns: 7},
+	}, stats.VirtualServices)
+	assert.Equal(t, 3, stats.Services)
+	assert.Equal(t, 3, stats.Destinations)
+	assert.Equal(t, uint64(5), stats.ActiveConns)
+	assert.Equal(t, uint64(8), stats.InactiveConns)
+
+	assert.Equal(t, uint64(0x1b), stats.Conns)
+	assert.Equal(t, uint64(0x2d5), stats.InPackets)
+	assert.Equal(t, uint64(0x1f4), stats.OutPackets)
+	assert.Equal(t, uint64(0x8a3c), stats.InBytes)
+	assert.Equal(t, uint64(0xf2e1), stats.OutBytes)
+	assert.Equal(t, uint64(2), stats.ConnsPerSecond)
+	assert.Equal(t, uint64(0x10), stats.InPacketsPerSecond)
+	assert.Equal(t, uint64(0xc), stats.OutPacketsPerSecond)
+	assert.Equal(t, uint64(1000), stats.InBytesPerSecond)
+	assert.Equal(t, uint64(2000), stats.OutBytesPerSecond)
+}
+
+func TestIPVSCollector_WithoutCounters(t *testing.T) {
+	stats := collectIPVS(t, map[string]string{"self/net/ip_vs": testIPVSTable})
+
+	assert.True(t, stats.Enabled)
+	assert.Equal(t, 3, stats.Services)
+	assert.Zero(t, stats.Conns)
+}
+
+func TestIPVSCollector_NotLoaded(t *testing.T) {
+	// The host's net directory is visible, but without ip_vs kube-proxy isn't in ipvs mode
+	stats := collectIPVS(t, map[string]string{
+		"1/net/arp":      testARPTable,
+		"self/net/ip_vs": testIPVSTable,
+	})
+
+	assert.Equal(t, &performance.IPVSStats{}, stats)
+}
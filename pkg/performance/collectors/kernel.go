@@ -0,0 +1,321 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface checks
+var (
+	_ performance.PointCollector      = (*KernelCollector)(nil)
+	_ performance.ContinuousCollector = (*KernelCollector)(nil)
+)
+
+const (
+	// Size of the channel buffering kernel messages for the consumer
+	kernelMessageBuffer = 256
+	// How long the follower waits for new messages before checking whether it was stopped
+	kmsgPollTimeout = 500 * time.Millisecond
+)
+
+// KernelCollector reads the kernel log, e.g. to find OOM kills, hung tasks and hardware
+// errors.
+//
+// Each collection returns the CollectionConfig.KernelMessageLimit most recent messages.
+// When started continuously, the collector streams a performance.KernelMessage for every
+// message logged from then on. With CollectionConfig.KernelBackfill set, it first streams
+// that many of the most recent messages, read like a collection, and then follows the log
+// from the last of them, so no message logged in between is lost or repeated. That keeps
+// the messages logged right before the agent (re)started, often the ones explaining why.
+//
+// Messages matching a known pattern, e.g. OOM kills, I/O errors or hung tasks, carry a
+// typed event with the fields extracted from them (see ParseKernelEvent), so consumers
+// don't have to match the raw text.
+//
+// Identical messages logged within CollectionConfig.KernelDedupWindow of one reported,
+// e.g. by flapping hardware, are folded into a single message with the number of
+// repeats and the timestamps of the first and last of them, reported when the window
+// ends. That keeps log storms from flooding the stream and crowding out the other
+// messages of a collection.
+//
+// Reading the kernel log needs CAP_SYSLOG when kernel.dmesg_restrict is set.
+//
+// Data sources:
+//   - /dev/kmsg: kernel log records, whose timestamps are relative to boot
+//   - /proc/stat: boot time, to convert the timestamps to wall clock time
+//
+// Reference: https://www.kernel.org/doc/Documentation/ABI/testing/dev-kmsg
+type KernelCollector struct {
+	performance.BaseContinuousCollector
+	procPath     string
+	kmsgPath     string
+	messageLimit int
+	backfill     int
+	dedupWindow  time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewKernelCollector(logger logr.Logger, config performance.CollectionConfig) (*KernelCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: true,
+		RequiresRoot:       false, // CAP_SYSLOG if kernel.dmesg_restrict is set
+		RequiresEBPF:       false,
+		MinKernelVersion:   "3.5.0", // /dev/kmsg
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if config.KernelMessageLimit < 0 {
+		return nil, fmt.Errorf("KernelMessageLimit must not be negative, got: %d", config.KernelMessageLimit)
+	}
+	if config.KernelBackfill < 0 {
+		return nil, fmt.Errorf("KernelBackfill must not be negative, got: %d", config.KernelBackfill)
+	}
+
+	devPath := config.HostDevPath
+	if devPath == "" {
+		devPath = "/dev"
+	}
+	messageLimit := config.KernelMessageLimit
+	if messageLimit == 0 {
+		messageLimit = performance.DefaultKernelMessageLimit
+	}
+
+	return &KernelCollector{
+		BaseContinuousCollector: performance.NewBaseContinuousCollector(
+			performance.MetricTypeKernel,
+			"Kernel Message Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath:     config.HostProcPath,
+		kmsgPath:     filepath.Join(devPath, "kmsg"),
+		messageLimit: messageLimit,
+		backfill:     config.KernelBackfill,
+		dedupWindow:  config.KernelDedupWindow,
+	}, nil
+}
+
+// Collect returns the most recent messages of the kernel log, oldest first
+func (c *KernelCollector) Collect(ctx context.Context) (any, error) {
+	host, err := hostContext(ctx, c.procPath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openKmsg(c.kmsgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	dedup := newKernelDedup(c.dedupWindow)
+	messages, err := c.recentMessages(r, c.messageLimit, host.BootTime, dedup)
+	if err != nil {
+		return nil, err
+	}
+	// The messages still being folded are reported as of the end of the log
+	messages = append(messages, dedup.flush()...)
+	sortKernelMessages(messages)
+	return messages[max(len(messages)-c.messageLimit, 0):], nil
+}
+
+// Start streams the messages of the kernel log until ctx is done or Stop is called,
+// starting with the CollectionConfig.KernelBackfill most recent ones
+func (c *KernelCollector) Start(ctx context.Context) (<-chan any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return nil, errors.New("collector is already running")
+	}
+
+	host, err := hostContext(ctx, c.procPath)
+	if err != nil {
+		c.SetError(err)
+		return nil, err
+	}
+	bootTime := host.BootTime
+	r, err := openKmsg(c.kmsgPath)
+	if err != nil {
+		c.SetError(err)
+		return nil, err
+	}
+
+	// The backfill reads the log up to its end with the reader that then follows it,
+	// folding the messages repeated across both
+	dedup := newKernelDedup(c.dedupWindow)
+	var backfill []performance.KernelMessage
+	if c.backfill > 0 {
+		backfill, err = c.recentMessages(r, c.backfill, bootTime, dedup)
+	} else {
+		err = r.seekEnd()
+	}
+	if err != nil {
+		r.close()
+		c.SetError(err)
+		return nil, err
+	}
+
+	ch := make(chan any, kernelMessageBuffer)
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.follow(ctx, r, ch, backfill, dedup, bootTime, c.stop, c.done)
+	c.ClearError()
+	c.SetStatus(performance.CollectorStatusActive)
+	return ch, nil
+}
+
+// Stop stops streaming and waits until the channel returned by Start is closed
+func (c *KernelCollector) Stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop == nil {
+		return nil
+	}
+	close(c.stop)
+	<-c.done
+	c.stop, c.done = nil, nil
+	c.SetStatus(performance.CollectorStatusDisabled)
+	return nil
+}
+
+// follow sends the backfilled messages and then the messages read from r, deduplicated
+// by dedup, to ch until ctx is done or stop is closed
+func (c *KernelCollector) follow(ctx context.Context, r *kmsgReader, ch chan<- any, backfill []performance.KernelMessage,
+	dedup *kernelDedup, bootTime time.Time, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	defer close(ch)
+	defer r.close()
+
+	send := func(messages ...performance.KernelMessage) bool {
+		for _, msg := range messages {
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return false
+			case <-stop:
+				return false
+			}
+		}
+		return true
+	}
+	if !send(backfill...) {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		default:
+		}
+
+		record, ok, err := r.next()
+		if err != nil {
+			c.Logger().Error(err, "failed to read kernel log")
+			return
+		}
+		if !ok {
+			// Windows end while the log is quiet too
+			if !send(dedup.expire(time.Now())...) {
+				return
+			}
+			if err := r.wait(kmsgPollTimeout); err != nil {
+				c.Logger().Error(err, "failed to wait for kernel messages")
+				return
+			}
+			continue
+		}
+		if msg, ok := parseKernelMessage(record, bootTime); ok && !send(dedup.add(msg)...) {
+			return
+		}
+	}
+}
+
+// recentMessages reads r to the end of the log and returns its last n messages
+// deduplicated by dedup. The messages dedup is still folding aren't returned.
+func (c *KernelCollector) recentMessages(r *kmsgReader, n int, bootTime time.Time,
+	dedup *kernelDedup) ([]performance.KernelMessage, error) {
+	// Ring of the last n messages, oldest at next once full
+	ring := make([]performance.KernelMessage, 0, n)
+	next := 0
+	for {
+		record, ok, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		msg, ok := parseKernelMessage(record, bootTime)
+		if !ok {
+			continue
+		}
+		for _, msg := range dedup.add(msg) {
+			if len(ring) < n {
+				ring = append(ring, msg)
+				continue
+			}
+			ring[next] = msg
+			next = (next + 1) % n
+		}
+	}
+	return append(ring[next:], ring[:next]...), nil
+}
+
+// parseKernelMessage parses a /dev/kmsg record with its continuation lines. The
+// subsystem and device are set from the SUBSYSTEM and DEVICE continuation lines, and the
+// event from the message text.
+func parseKernelMessage(record string, bootTime time.Time) (performance.KernelMessage, bool) {
+	header, dict, _ := strings.Cut(record, "\n")
+	seq, ts, text, ok := parseKmsgRecord(header)
+	if !ok {
+		return performance.KernelMessage{}, false
+	}
+	prefix, _, _ := strings.Cut(header, ",")
+	priority, err := strconv.ParseUint(prefix, 10, 16)
+	if err != nil {
+		return performance.KernelMessage{}, false
+	}
+
+	msg := performance.KernelMessage{
+		Timestamp:   bootTime.Add(ts),
+		Facility:    uint8(priority >> 3),
+		Severity:    uint8(priority & 7),
+		SequenceNum: seq,
+		Message:     text,
+		Event:       ParseKernelEvent(text),
+	}
+	for _, line := range strings.Split(dict, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "SUBSYSTEM":
+			msg.Subsystem = value
+		case "DEVICE":
+			msg.Device = value
+		}
+	}
+	return msg, true
+}
@@ -0,0 +1,151 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"slices"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// maxKernelDedupEntries bounds the messages remembered by a kernelDedup. Messages logged
+// while it is full are reported without deduplication.
+const maxKernelDedupEntries = 1024
+
+// kernelDedup folds identical kernel messages, as logged by flapping hardware, into one.
+//
+// The first of a run of identical messages is reported as logged and opens a window.
+// The identical messages logged within the window are counted instead of reported, and
+// when the window ends the last of them is reported once with the count. An identical
+// message logged after the window reports as the first of a new run. Only messages with
+// an open window are remembered, so the memory used doesn't grow with the log.
+//
+// A nil kernelDedup reports every message as logged.
+type kernelDedup struct {
+	window  time.Duration
+	entries map[kernelMessageKey]*kernelDedupEntry
+	// next is when the earliest window ends
+	next time.Time
+}
+
+// kernelMessageKey is what identical messages have in common
+type kernelMessageKey struct {
+	facility  uint8
+	severity  uint8
+	subsystem string
+	device    string
+	message   string
+}
+
+type kernelDedupEntry struct {
+	start   time.Time // Timestamp of the message reported, which opened the window
+	repeats int
+	first   time.Time // Timestamp of the first message folded
+	last    performance.KernelMessage
+}
+
+// newKernelDedup returns a kernelDedup folding messages for window, or nil if window
+// isn't positive
+func newKernelDedup(window time.Duration) *kernelDedup {
+	if window <= 0 {
+		return nil
+	}
+	return &kernelDedup{window: window, entries: make(map[kernelMessageKey]*kernelDedupEntry)}
+}
+
+// add returns the messages to report once msg is logged: the messages folded in the
+// windows that ended by then, and msg unless it is folded
+func (d *kernelDedup) add(msg performance.KernelMessage) []performance.KernelMessage {
+	if d == nil {
+		return []performance.KernelMessage{msg}
+	}
+	out := d.expire(msg.Timestamp)
+
+	key := kernelMessageKey{
+		facility:  msg.Facility,
+		severity:  msg.Severity,
+		subsystem: msg.Subsystem,
+		device:    msg.Device,
+		message:   msg.Message,
+	}
+	if entry, ok := d.entries[key]; ok {
+		if entry.repeats == 0 {
+			entry.first = msg.Timestamp
+		}
+		entry.repeats++
+		entry.last = msg
+		return out
+	}
+	if len(d.entries) < maxKernelDedupEntries {
+		d.entries[key] = &kernelDedupEntry{start: msg.Timestamp}
+		if end := msg.Timestamp.Add(d.window); d.next.IsZero() || end.Before(d.next) {
+			d.next = end
+		}
+	}
+	return append(out, msg)
+}
+
+// expire returns the messages folded in the windows that ended by now, oldest first,
+// and forgets the messages of these windows
+func (d *kernelDedup) expire(now time.Time) []performance.KernelMessage {
+	if d == nil || d.next.IsZero() || now.Before(d.next) {
+		return nil
+	}
+	var out []performance.KernelMessage
+	d.next = time.Time{}
+	for key, entry := range d.entries {
+		end := entry.start.Add(d.window)
+		if now.Before(end) {
+			if d.next.IsZero() || end.Before(d.next) {
+				d.next = end
+			}
+			continue
+		}
+		if msg, ok := entry.summary(); ok {
+			out = append(out, msg)
+		}
+		delete(d.entries, key)
+	}
+	sortKernelMessages(out)
+	return out
+}
+
+// flush returns the messages folded in all open windows, oldest first, and forgets all
+// messages
+func (d *kernelDedup) flush() []performance.KernelMessage {
+	if d == nil {
+		return nil
+	}
+	var out []performance.KernelMessage
+	for _, entry := range d.entries {
+		if msg, ok := entry.summary(); ok {
+			out = append(out, msg)
+		}
+	}
+	clear(d.entries)
+	d.next = time.Time{}
+	sortKernelMessages(out)
+	return out
+}
+
+// summary returns the message reporting the messages folded in the window, if any
+func (e *kernelDedupEntry) summary() (performance.KernelMessage, bool) {
+	if e.repeats == 0 {
+		return performance.KernelMessage{}, false
+	}
+	msg := e.last
+	msg.Repeats = e.repeats
+	msg.FirstTimestamp = e.first
+	return msg, true
+}
+
+func sortKernelMessages(messages []performance.KernelMessage) {
+	slices.SortStableFunc(messages, func(a, b performance.KernelMessage) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+}
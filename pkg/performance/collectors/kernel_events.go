@@ -0,0 +1,149 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// kernelPattern recognizes the messages of a kind of kernel event. event builds the event
+// from the submatches of re.
+type kernelPattern struct {
+	re    *regexp.Regexp
+	event func(m []string) *performance.KernelEvent
+}
+
+var (
+	linkSpeedRe  = regexp.MustCompile(`(\d+)\s*([MG])bps`)
+	linkDuplexRe = regexp.MustCompile(`(?i)(full|half)(?:[ -]duplex)?\b`)
+)
+
+// kernelPatterns are the patterns of the messages turned into typed events, tried in
+// order. They cover the formats of the mainline drivers and filesystems that log them.
+var kernelPatterns = []kernelPattern{
+	{
+		// Out of memory: Killed process 1234 (java) total-vm:4194304kB, anon-rss:1048576kB, ...
+		// Memory cgroup out of memory: Killed process 1234 (java) total-vm:...
+		// Kernels before 5.1 log "Killed process" without the prefix, on the line after
+		// "Kill process 1234 (java) score 984 or sacrifice child". Commands can contain
+		// parentheses, so the command ends at the parenthesis followed by the memory usage.
+		re: regexp.MustCompile(`^(Memory cgroup out of memory: )?.*\bKilled process (\d+) \((.*?)\)(?: total-vm:(\d+)kB, anon-rss:(\d+)kB|,|$)`),
+		event: func(m []string) *performance.KernelEvent {
+			e := &performance.KernelEvent{Kind: performance.KernelEventOOMKill, PID: parsePID(m[2]), Process: m[3]}
+			if m[1] != "" {
+				setField(e, "constraint", "memcg")
+			}
+			setField(e, "total_vm_kb", m[4])
+			setField(e, "anon_rss_kb", m[5])
+			return e
+		},
+	},
+	{
+		// blk_update_request: I/O error, dev sda, sector 2048 op 0x0:(READ) flags 0x0 ...
+		// I/O error, dev nvme0n1, sector 123 op 0x1:(WRITE) flags 0x800 phys_seg 1 prio class 2
+		// Buffer I/O error on dev sda1, logical block 0, async page read
+		re: regexp.MustCompile(`I/O error,? (?:on )?dev ([^\s,]+)(?:, sector (\d+))?(?: op 0x[0-9a-f]+:\((\w+)\))?(?:, logical block (\d+))?`),
+		event: func(m []string) *performance.KernelEvent {
+			e := &performance.KernelEvent{Kind: performance.KernelEventIOError, Device: m[1]}
+			setField(e, "sector", m[2])
+			setField(e, "op", strings.ToLower(m[3]))
+			setField(e, "logical_block", m[4])
+			return e
+		},
+	},
+	{
+		// e1000e: eth0 NIC Link is Up 1000 Mbps Full Duplex, Flow Control: Rx/Tx
+		// i40e 0000:3b:00.0 eno1: NIC Link is Up, 25 Gbps Full Duplex, Requested FEC: None
+		// mlx5_core 0000:5e:00.0 ens1f0: Link down
+		// r8169 0000:02:00.0 enp2s0: Link is Up - 1Gbps/Full - flow control rx/tx
+		re: regexp.MustCompile(`(?i)(?:([\w.@-]+): (?:NIC )?|([\w.@-]+) NIC )Link (?:is )?(up|down)\b(.*)`),
+		event: func(m []string) *performance.KernelEvent {
+			e := &performance.KernelEvent{Kind: performance.KernelEventLinkDown, Device: m[1] + m[2]}
+			if strings.EqualFold(m[3], "up") {
+				e.Kind = performance.KernelEventLinkUp
+				if speed := linkSpeedRe.FindStringSubmatch(m[4]); speed != nil {
+					mbps, _ := strconv.Atoi(speed[1])
+					if speed[2] == "G" {
+						mbps *= 1000
+					}
+					setField(e, "speed_mbps", strconv.Itoa(mbps))
+				}
+				if duplex := linkDuplexRe.FindStringSubmatch(m[4]); duplex != nil {
+					setField(e, "duplex", strings.ToLower(duplex[1]))
+				}
+			}
+			return e
+		},
+	},
+	{
+		// IPv6: ADDRCONF(NETDEV_CHANGE): eth0: link becomes ready
+		re: regexp.MustCompile(`ADDRCONF\(NETDEV_CHANGE\): ([\w.@-]+): link becomes ready`),
+		event: func(m []string) *performance.KernelEvent {
+			return &performance.KernelEvent{Kind: performance.KernelEventLinkUp, Device: m[1]}
+		},
+	},
+	{
+		// EXT4-fs (sda1): Remounting filesystem read-only
+		// EXT3-fs (sda1): error: remounting filesystem read-only
+		// BTRFS info (device sda1): forced readonly
+		re: regexp.MustCompile(`(?i)^(EXT[234]|BTRFS)[-\w]*(?: \w+)? \((?:device )?([^)]+)\):? .*(?:remounting filesystem read-only|forced readonly)`),
+		event: func(m []string) *performance.KernelEvent {
+			e := &performance.KernelEvent{Kind: performance.KernelEventReadOnlyRemount, Device: m[2]}
+			setField(e, "filesystem", strings.ToLower(m[1]))
+			return e
+		},
+	},
+	{
+		// INFO: task jbd2/sda1-8:345 blocked for more than 120 seconds.
+		re: regexp.MustCompile(`task (.+):(\d+) blocked for more than (\d+) seconds`),
+		event: func(m []string) *performance.KernelEvent {
+			seconds, _ := strconv.Atoi(m[3])
+			return &performance.KernelEvent{
+				Kind:     performance.KernelEventHungTask,
+				PID:      parsePID(m[2]),
+				Process:  m[1],
+				Duration: time.Duration(seconds) * time.Second,
+			}
+		},
+	},
+}
+
+// ParseKernelEvent returns the typed event of a kernel log message: OOM kills, I/O errors,
+// network links going down or up, filesystems remounted read-only after errors and hung
+// tasks. It returns nil for other messages.
+//
+// The patterns match the message text, without the /dev/kmsg header, so they can be
+// applied to messages read from other sources, e.g. the journal.
+func ParseKernelEvent(message string) *performance.KernelEvent {
+	for _, p := range kernelPatterns {
+		if m := p.re.FindStringSubmatch(message); m != nil {
+			return p.event(m)
+		}
+	}
+	return nil
+}
+
+func parsePID(s string) int32 {
+	pid, _ := strconv.ParseInt(s, 10, 32)
+	return int32(pid)
+}
+
+// setField sets the field key of e to value, if value isn't empty
+func setField(e *performance.KernelEvent, key, value string) {
+	if value == "" {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[key] = value
+}
@@ -0,0 +1,105 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKernelEvent(t *testing.T) {
+	tests := []struct {
+		message string
+		want    *performance.KernelEvent
+	}{
+		{
+			message: "Out of memory: Killed process 1234 (java) total-vm:4194304kB, anon-rss:1048576kB, file-rss:0kB, shmem-rss:0kB, UID:0 pgtables:2048kB oom_score_adj:0",
+			want: &performance.KernelEvent{Kind: performance.KernelEventOOMKill, PID: 1234, Process: "java",
+				Fields: map[string]string{"total_vm_kb": "4194304", "anon_rss_kb": "1048576"}},
+		},
+		{
+			message: "Memory cgroup out of memory: Killed process 42 (python3 (worker)) total-vm:1024kB, anon-rss:512kB, file-rss:0kB",
+			want: &performance.KernelEvent{Kind: performance.KernelEventOOMKill, PID: 42, Process: "python3 (worker)",
+				Fields: map[string]string{"constraint": "memcg", "total_vm_kb": "1024", "anon_rss_kb": "512"}},
+		},
+		{
+			message: "Killed process 7 (nginx)",
+			want:    &performance.KernelEvent{Kind: performance.KernelEventOOMKill, PID: 7, Process: "nginx"},
+		},
+		{
+			message: "blk_update_request: I/O error, dev sda, sector 2048 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+			want: &performance.KernelEvent{Kind: performance.KernelEventIOError, Device: "sda",
+				Fields: map[string]string{"sector": "2048", "op": "read"}},
+		},
+		{
+			message: "I/O error, dev nvme0n1, sector 123 op 0x1:(WRITE) flags 0x800 phys_seg 1 prio class 2",
+			want: &performance.KernelEvent{Kind: performance.KernelEventIOError, Device: "nvme0n1",
+				Fields: map[string]string{"sector": "123", "op": "write"}},
+		},
+		{
+			message: "Buffer I/O error on dev sda1, logical block 0, async page read",
+			want: &performance.KernelEvent{Kind: performance.KernelEventIOError, Device: "sda1",
+				Fields: map[string]string{"logical_block": "0"}},
+		},
+		{
+			message: "e1000e: eth0 NIC Link is Down",
+			want:    &performance.KernelEvent{Kind: performance.KernelEventLinkDown, Device: "eth0"},
+		},
+		{
+			message: "e1000e: eth0 NIC Link is Up 1000 Mbps Full Duplex, Flow Control: Rx/Tx",
+			want: &performance.KernelEvent{Kind: performance.KernelEventLinkUp, Device: "eth0",
+				Fields: map[string]string{"speed_mbps": "1000", "duplex": "full"}},
+		},
+		{
+			message: "i40e 0000:3b:00.0 eno1: NIC Link is Up, 25 Gbps Full Duplex, Requested FEC: None",
+			want: &performance.KernelEvent{Kind: performance.KernelEventLinkUp, Device: "eno1",
+				Fields: map[string]string{"speed_mbps": "25000", "duplex": "full"}},
+		},
+		{
+			message: "mlx5_core 0000:5e:00.0 ens1f0: Link down",
+			want:    &performance.KernelEvent{Kind: performance.KernelEventLinkDown, Device: "ens1f0"},
+		},
+		{
+			message: "r8169 0000:02:00.0 enp2s0: Link is Up - 1Gbps/Full - flow control rx/tx",
+			want: &performance.KernelEvent{Kind: performance.KernelEventLinkUp, Device: "enp2s0",
+				Fields: map[string]string{"speed_mbps": "1000", "duplex": "full"}},
+		},
+		{
+			message: "IPv6: ADDRCONF(NETDEV_CHANGE): eth0: link becomes ready",
+			want:    &performance.KernelEvent{Kind: performance.KernelEventLinkUp, Device: "eth0"},
+		},
+		{
+			message: "EXT4-fs (nvme0n1p1): Remounting filesystem read-only",
+			want: &performance.KernelEvent{Kind: performance.KernelEventReadOnlyRemount, Device: "nvme0n1p1",
+				Fields: map[string]string{"filesystem": "ext4"}},
+		},
+		{
+			message: "BTRFS info (device sdb1): forced readonly",
+			want: &performance.KernelEvent{Kind: performance.KernelEventReadOnlyRemount, Device: "sdb1",
+				Fields: map[string]string{"filesystem": "btrfs"}},
+		},
+		{
+			message: "INFO: task kworker/u16:2:1234 blocked for more than 122 seconds.",
+			want: &performance.KernelEvent{Kind: performance.KernelEventHungTask, PID: 1234, Process: "kworker/u16:2",
+				Duration: 122 * time.Second},
+		},
+		// Similar messages that aren't events
+		{message: "ata1: SATA link up 6.0 Gbps (SStatus 133 SControl 300)"},
+		{message: "EXT4-fs error (device nvme0n1p1): ext4_find_entry:1455: comm kubelet: reading directory lblock 0"},
+		{message: "Out of memory: Kill process 1234 (java) score 900 or sacrifice child"},
+		{message: "hung_task_timeout_secs is set to 120"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			assert.Equal(t, tt.want, collectors.ParseKernelEvent(tt.message))
+		})
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*KernelTaintCollector)(nil)
+
+// kernelTaintFlags maps taint bits to the letter printed in oops reports and a description
+//
+// Reference: https://docs.kernel.org/admin-guide/tainted-kernels.html
+var kernelTaintFlags = []struct {
+	flag        string
+	description string
+}{
+	0:  {"P", "proprietary module was loaded"},
+	1:  {"F", "module was force loaded"},
+	2:  {"S", "kernel running on an out of specification system"},
+	3:  {"R", "module was force unloaded"},
+	4:  {"M", "processor reported a machine check exception"},
+	5:  {"B", "bad page referenced or unexpected page flags"},
+	6:  {"U", "taint requested by userspace"},
+	7:  {"D", "kernel died recently (oops or BUG)"},
+	8:  {"A", "ACPI table overridden by user"},
+	9:  {"W", "kernel issued a warning"},
+	10: {"C", "staging driver was loaded"},
+	11: {"I", "workaround for a platform firmware bug applied"},
+	12: {"O", "externally-built (out-of-tree) module was loaded"},
+	13: {"E", "unsigned module was loaded"},
+	14: {"L", "soft lockup occurred"},
+	15: {"K", "kernel has been live patched"},
+	16: {"X", "auxiliary taint, defined by distributions"},
+	17: {"T", "kernel built with the struct randomization plugin"},
+	18: {"N", "in-kernel test has been run"},
+	19: {"J", "userspace used a mutating debug operation in fwctl"},
+}
+
+// KernelTaintCollector reports the kernel taint flags and records of previous kernel crashes
+//
+// A tainted kernel (proprietary or out-of-tree modules, a previous oops, machine check
+// exceptions, soft lockups) or a node that panicked recently changes how an incident
+// should be triaged, so both are surfaced alongside the performance metrics.
+//
+// Data sources:
+//   - /proc/sys/kernel/tainted: taint bitmask, decoded into flags
+//   - /sys/fs/pstore/: records that firmware or ramoops backends persisted across the
+//     reboot that followed a panic or oops. Records stay until they are removed, so their
+//     modification time tells how recent the crash was.
+//   - CollectionConfig.CrashDumpDir (/var/crash by default): crash dumps written by kdump
+//     (one directory per crash) or apport (*.crash files)
+//
+// pstore requires the filesystem to be mounted and the crash dump directory is read as is,
+// so in a container it has to be mounted at the same path. Both are optional: missing
+// directories yield no records.
+type KernelTaintCollector struct {
+	performance.BaseCollector
+	taintedPath  string
+	pstorePath   string
+	crashDumpDir string
+}
+
+func NewKernelTaintCollector(logger logr.Logger, config performance.CollectionConfig) (*KernelTaintCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false, // pstore records are root-only but their names and sizes aren't
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+	if config.CrashDumpDir != "" && !filepath.IsAbs(config.CrashDumpDir) {
+		return nil, fmt.Errorf("CrashDumpDir must be an absolute path, got: %q", config.CrashDumpDir)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &KernelTaintCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeKernelTaint,
+			"Kernel Taint Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		taintedPath:  filepath.Join(config.HostProcPath, "sys", "kernel", "tainted"),
+		pstorePath:   filepath.Join(config.HostSysPath, "fs", "pstore"),
+		crashDumpDir: config.CrashDumpDir,
+	}, nil
+}
+
+func (c *KernelTaintCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectKernelTaint(ctx)
+}
+
+func (c *KernelTaintCollector) collectKernelTaint(ctx context.Context) (*performance.KernelTaintStats, error) {
+	tainted, err := procparse.ReadUintFile(c.taintedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.taintedPath, err)
+	}
+	stats := &performance.KernelTaintStats{
+		Tainted: tainted,
+		Taints:  decodeKernelTaint(tainted),
+	}
+
+	pstore, err := c.collectPstoreRecords(ctx)
+	if err != nil {
+		c.Logger().V(1).Info("Failed to read pstore records (continuing without them)", "path", c.pstorePath, "error", err)
+	}
+	stats.CrashRecords = append(stats.CrashRecords, pstore...)
+
+	if c.crashDumpDir != "" {
+		dumps, err := c.collectCrashDumps(ctx)
+		if err != nil {
+			c.Logger().V(1).Info("Failed to read crash dumps (continuing without them)", "path", c.crashDumpDir, "error", err)
+		}
+		stats.CrashRecords = append(stats.CrashRecords, dumps...)
+	}
+
+	// The parts above only log their errors, so a cancellation surfaces here
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(stats.CrashRecords, func(i, j int) bool {
+		return stats.CrashRecords[i].ModTime.After(stats.CrashRecords[j].ModTime)
+	})
+	return stats, nil
+}
+
+// decodeKernelTaint returns the flags set in the taint mask. Bits unknown to this version
+// of the agent are reported without a flag letter.
+func decodeKernelTaint(mask uint64) []performance.KernelTaint {
+	var taints []performance.KernelTaint
+	for bit := uint(0); bit < 64; bit++ {
+		if mask&(1<<bit) == 0 {
+			continue
+		}
+		taint := performance.KernelTaint{Bit: bit, Description: "unknown taint bit " + strconv.FormatUint(uint64(bit), 10)}
+		if int(bit) < len(kernelTaintFlags) {
+			taint.Flag = kernelTaintFlags[bit].flag
+			taint.Description = kernelTaintFlags[bit].description
+		}
+		taints = append(taints, taint)
+	}
+	return taints
+}
+
+// collectPstoreRecords lists the records in /sys/fs/pstore. Record names are
+// <type>-<backend>-<id>, e.g. dmesg-efi-170000000001 or console-ramoops-0.
+func (c *KernelTaintCollector) collectPstoreRecords(ctx context.Context) ([]performance.CrashRecord, error) {
+	entries, err := os.ReadDir(c.pstorePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []performance.CrashRecord
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return records, err
+		}
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordType, _, _ := strings.Cut(entry.Name(), "-")
+		records = append(records, performance.CrashRecord{
+			Source:  "pstore",
+			Path:    filepath.Join(c.pstorePath, entry.Name()),
+			Type:    recordType,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return records, nil
+}
+
+// collectCrashDumps lists the crash dumps in the crash dump directory. kdump writes a
+// directory per crash holding the vmcore and the dmesg of the crashed kernel, apport writes
+// *.crash files, which also cover userspace crashes and are reported all the same.
+// Hidden files and the lock and .upload markers of apport are skipped.
+func (c *KernelTaintCollector) collectCrashDumps(ctx context.Context) ([]performance.CrashRecord, error) {
+	entries, err := os.ReadDir(c.crashDumpDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []performance.CrashRecord
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return records, err
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || (!entry.IsDir() && !strings.HasSuffix(name, ".crash")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.crashDumpDir, name)
+		size := info.Size()
+		if entry.IsDir() {
+			size = dirSize(path)
+		}
+		records = append(records, performance.CrashRecord{
+			Source:  "crash_dir",
+			Path:    path,
+			Size:    size,
+			ModTime: info.ModTime(),
+		})
+	}
+	return records, nil
+}
+
+// dirSize returns the total size of the regular files under path, skipping unreadable
+// entries
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,178 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createKernelTaintCollector writes files under a temporary root that holds proc/, sys/
+// and crash/ and returns a collector reading from them
+func createKernelTaintCollector(t *testing.T, files map[string]string) (*collectors.KernelTaintCollector, string) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sys"), 0755))
+	writeSysFiles(t, root, files)
+
+	config := performance.CollectionConfig{
+		HostProcPath: filepath.Join(root, "proc"),
+		HostSysPath:  filepath.Join(root, "sys"),
+		CrashDumpDir: filepath.Join(root, "crash"),
+	}
+	collector, err := collectors.NewKernelTaintCollector(logr.Discard(), config)
+	require.NoError(t, err)
+	return collector, root
+}
+
+func collectKernelTaint(t *testing.T, collector *collectors.KernelTaintCollector) *performance.KernelTaintStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.KernelTaintStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestKernelTaintCollector_Constructor(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  performance.CollectionConfig
+		wantErr string
+	}{
+		{
+			name:    "relative proc path",
+			config:  performance.CollectionConfig{HostProcPath: "proc", HostSysPath: "/sys"},
+			wantErr: "HostProcPath must be an absolute path",
+		},
+		{
+			name:    "relative sys path",
+			config:  performance.CollectionConfig{HostProcPath: "/proc", HostSysPath: "sys"},
+			wantErr: "HostSysPath must be an absolute path",
+		},
+		{
+			name:    "relative crash dump dir",
+			config:  performance.CollectionConfig{HostProcPath: "/proc", HostSysPath: "/sys", CrashDumpDir: "crash"},
+			wantErr: "CrashDumpDir must be an absolute path",
+		},
+		{
+			name:    "non-existent proc path",
+			config:  performance.CollectionConfig{HostProcPath: "/non/existent/path", HostSysPath: "/sys"},
+			wantErr: "HostProcPath validation failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := collectors.NewKernelTaintCollector(logr.Discard(), tt.config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestKernelTaintCollector_NotTainted(t *testing.T) {
+	collector, _ := createKernelTaintCollector(t, map[string]string{
+		"proc/sys/kernel/tainted": "0\n",
+	})
+	stats := collectKernelTaint(t, collector)
+	assert.Zero(t, stats.Tainted)
+	assert.Empty(t, stats.Taints)
+	assert.Empty(t, stats.CrashRecords)
+}
+
+func TestKernelTaintCollector_Taints(t *testing.T) {
+	// P (0), W (9), O (12), E (13) and an unknown bit 40
+	collector, _ := createKernelTaintCollector(t, map[string]string{
+		"proc/sys/kernel/tainted": "1099511640577\n",
+	})
+	stats := collectKernelTaint(t, collector)
+	assert.Equal(t, uint64(1<<0|1<<9|1<<12|1<<13|1<<40), stats.Tainted)
+
+	var flags []string
+	var bits []uint
+	for _, taint := range stats.Taints {
+		flags = append(flags, taint.Flag)
+		bits = append(bits, taint.Bit)
+	}
+	assert.Equal(t, []uint{0, 9, 12, 13, 40}, bits)
+	assert.Equal(t, []string{"P", "W", "O", "E", ""}, flags)
+	assert.Equal(t, "proprietary module was loaded", stats.Taints[0].Description)
+	assert.Equal(t, "unknown taint bit 40", stats.Taints[4].Description)
+}
+
+func TestKernelTaintCollector_MissingTainted(t *testing.T) {
+	collector, _ := createKernelTaintCollector(t, nil)
+	_, err := collector.Collect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestKernelTaintCollector_CrashRecords(t *testing.T) {
+	collector, root := createKernelTaintCollector(t, map[string]string{
+		"proc/sys/kernel/tainted":               "128\n",
+		"sys/fs/pstore/dmesg-efi-170000000001":  "Oops#1 Part1\nKernel panic - not syncing",
+		"sys/fs/pstore/console-ramoops-0":       "console",
+		"crash/202401011200/vmcore":             "vmcore data",
+		"crash/202401011200/dmesg.202401011200": "dmesg",
+		"crash/_usr_bin_app.1000.crash":         "apport",
+		"crash/_usr_bin_app.1000.upload":        "",
+		"crash/.lock":                           "",
+		"crash/kexec_cmd":                       "",
+	})
+
+	// Order the records by age: the kdump directory is the most recent
+	now := time.Now()
+	times := map[string]time.Time{
+		"sys/fs/pstore/dmesg-efi-170000000001": now.Add(-3 * time.Hour),
+		"sys/fs/pstore/console-ramoops-0":      now.Add(-2 * time.Hour),
+		"crash/_usr_bin_app.1000.crash":        now.Add(-1 * time.Hour),
+		"crash/202401011200":                   now,
+	}
+	for path, mtime := range times {
+		require.NoError(t, os.Chtimes(filepath.Join(root, path), mtime, mtime))
+	}
+
+	stats := collectKernelTaint(t, collector)
+	require.Len(t, stats.Taints, 1)
+	assert.Equal(t, "D", stats.Taints[0].Flag)
+
+	require.Len(t, stats.CrashRecords, 4)
+	kdump := stats.CrashRecords[0]
+	assert.Equal(t, "crash_dir", kdump.Source)
+	assert.Equal(t, filepath.Join(root, "crash", "202401011200"), kdump.Path)
+	assert.Equal(t, int64(len("vmcore data")+len("dmesg")), kdump.Size)
+	assert.Empty(t, kdump.Type)
+
+	assert.Equal(t, filepath.Join(root, "crash", "_usr_bin_app.1000.crash"), stats.CrashRecords[1].Path)
+
+	console := stats.CrashRecords[2]
+	assert.Equal(t, "pstore", console.Source)
+	assert.Equal(t, "console", console.Type)
+
+	dmesg := stats.CrashRecords[3]
+	assert.Equal(t, "dmesg", dmesg.Type)
+	assert.Equal(t, int64(len("Oops#1 Part1\nKernel panic - not syncing")), dmesg.Size)
+}
+
+func TestKernelTaintCollector_Cancelled(t *testing.T) {
+	collector, _ := createKernelTaintCollector(t, map[string]string{
+		"proc/sys/kernel/tainted":              "0\n",
+		"sys/fs/pstore/dmesg-efi-170000000001": "oops",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
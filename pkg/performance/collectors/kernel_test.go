@@ -0,0 +1,230 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build linux
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKernelLog = `6,100,5000000,-;eth0: link up
+3,101,6000000,-;EXT4-fs error (device nvme0n1p1): ext4_find_entry:1455: comm kubelet: reading directory lblock 0
+ SUBSYSTEM=block
+ DEVICE=b259:1
+4,102,7000000,-;nvme nvme0: I/O 12 QID 3 timeout, aborting
+0,103,8000000,c;Out of memory: Killed process 4242 (java)
+`
+
+func newKernelCollector(t *testing.T, kmsg string, limit, backfill int) (*collectors.KernelCollector, string) {
+	procPath, devPath := t.TempDir(), t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "btime 1760000000\n"})
+	writeSysFiles(t, devPath, map[string]string{"kmsg": kmsg})
+	collector, err := collectors.NewKernelCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath:       procPath,
+		HostDevPath:        devPath,
+		KernelMessageLimit: limit,
+		KernelBackfill:     backfill,
+	})
+	require.NoError(t, err)
+	return collector, filepath.Join(devPath, "kmsg")
+}
+
+func TestKernelCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewKernelCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewKernelCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath:   "/proc",
+		KernelBackfill: -1,
+	})
+	assert.ErrorContains(t, err, "KernelBackfill must not be negative")
+}
+
+func TestKernelCollector_Collect(t *testing.T) {
+	collector, _ := newKernelCollector(t, testKernelLog, 3, 0)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	messages, ok := result.([]performance.KernelMessage)
+	require.True(t, ok)
+
+	require.Len(t, messages, 3)
+	assert.Equal(t, performance.KernelMessage{
+		Timestamp:   time.Unix(1760000006, 0),
+		Facility:    0,
+		Severity:    3,
+		SequenceNum: 101,
+		Message:     "EXT4-fs error (device nvme0n1p1): ext4_find_entry:1455: comm kubelet: reading directory lblock 0",
+		Subsystem:   "block",
+		Device:      "b259:1",
+	}, messages[0])
+	assert.Equal(t, uint64(102), messages[1].SequenceNum)
+	assert.Equal(t, uint8(4), messages[1].Severity)
+	assert.Equal(t, uint64(103), messages[2].SequenceNum)
+	assert.Equal(t, "Out of memory: Killed process 4242 (java)", messages[2].Message)
+}
+
+func TestKernelCollector_Start(t *testing.T) {
+	tests := []struct {
+		name     string
+		backfill int
+		wantSeqs []uint64
+	}{
+		{name: "new messages only", wantSeqs: []uint64{104}},
+		{name: "backfill", backfill: 2, wantSeqs: []uint64{102, 103, 104}},
+		{name: "backfill more than logged", backfill: 10, wantSeqs: []uint64{100, 101, 102, 103, 104}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector, kmsgPath := newKernelCollector(t, testKernelLog, 0, tt.backfill)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := collector.Start(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, performance.CollectorStatusActive, collector.Status())
+
+			file, err := os.OpenFile(kmsgPath, os.O_APPEND|os.O_WRONLY, 0)
+			require.NoError(t, err)
+			_, err = file.WriteString("6,104,9000000,-;eth0: link down\n")
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+
+			var seqs []uint64
+			for len(seqs) < len(tt.wantSeqs) {
+				select {
+				case event := <-ch:
+					msg, ok := event.(performance.KernelMessage)
+					require.True(t, ok)
+					seqs = append(seqs, msg.SequenceNum)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timed out waiting for kernel messages, got %v", seqs)
+				}
+			}
+			assert.Equal(t, tt.wantSeqs, seqs)
+
+			require.NoError(t, collector.Stop())
+			_, open := <-ch
+			assert.False(t, open, "channel should be closed after Stop")
+		})
+	}
+}
+
+// testKernelStorm is a flapping link, logging the same message over and over
+const testKernelStorm = `6,200,1000000,-;eth0: link down
+6,201,2000000,-;eth0: link down
+6,202,3000000,-;eth0: link up
+6,203,4000000,-;eth0: link down
+6,204,40000000,-;eth0: link down
+6,205,41000000,-;eth0: link down
+`
+
+func newDedupKernelCollector(t *testing.T, kmsg string) (*collectors.KernelCollector, string) {
+	procPath, devPath := t.TempDir(), t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "btime 1760000000\n"})
+	writeSysFiles(t, devPath, map[string]string{"kmsg": kmsg})
+	collector, err := collectors.NewKernelCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath:      procPath,
+		HostDevPath:       devPath,
+		KernelDedupWindow: 30 * time.Second,
+	})
+	require.NoError(t, err)
+	return collector, filepath.Join(devPath, "kmsg")
+}
+
+func TestKernelCollector_CollectDedup(t *testing.T) {
+	collector, _ := newDedupKernelCollector(t, testKernelStorm)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	messages, ok := result.([]performance.KernelMessage)
+	require.True(t, ok)
+
+	type folded struct {
+		seq     uint64
+		repeats int
+		first   time.Time
+	}
+	var got []folded
+	for _, msg := range messages {
+		got = append(got, folded{seq: msg.SequenceNum, repeats: msg.Repeats, first: msg.FirstTimestamp})
+	}
+	assert.Equal(t, []folded{
+		{seq: 200},
+		{seq: 202},
+		// 201 and 203, logged within 30s of 200
+		{seq: 203, repeats: 2, first: time.Unix(1760000002, 0)},
+		// A new window, still open at the end of the log
+		{seq: 204},
+		{seq: 205, repeats: 1, first: time.Unix(1760000041, 0)},
+	}, got)
+}
+
+func TestKernelCollector_StartDedup(t *testing.T) {
+	collector, kmsgPath := newDedupKernelCollector(t, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := collector.Start(ctx)
+	require.NoError(t, err)
+
+	file, err := os.OpenFile(kmsgPath, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(testKernelStorm[:strings.Index(testKernelStorm, "6,202")])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	next := func() performance.KernelMessage {
+		t.Helper()
+		select {
+		case event := <-ch:
+			msg, ok := event.(performance.KernelMessage)
+			require.True(t, ok)
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for kernel messages")
+			return performance.KernelMessage{}
+		}
+	}
+	first := next()
+	assert.Equal(t, uint64(200), first.SequenceNum)
+	assert.Zero(t, first.Repeats)
+	// The messages were logged long ago, so the window has ended by the time the log is
+	// quiet again
+	repeated := next()
+	assert.Equal(t, uint64(201), repeated.SequenceNum)
+	assert.Equal(t, 1, repeated.Repeats)
+	assert.Equal(t, time.Unix(1760000002, 0), repeated.FirstTimestamp)
+
+	require.NoError(t, collector.Stop())
+}
+
+func TestKernelCollector_StartWithoutKernelLog(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{"stat": "btime 1760000000\n"})
+	collector, err := collectors.NewKernelCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostDevPath:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	_, err = collector.Start(context.Background())
+	assert.ErrorContains(t, err, "failed to open")
+	assert.Equal(t, performance.CollectorStatusFailed, collector.Status())
+}
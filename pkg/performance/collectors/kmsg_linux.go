@@ -0,0 +1,125 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// kmsgRecordMax is the largest record /dev/kmsg returns (CONSOLE_EXT_LOG_MAX). Reads into
+// a smaller buffer fail with EINVAL.
+const kmsgRecordMax = 8192
+
+// readKmsg calls fn with the records of the kernel log at path, oldest first, until fn
+// returns false or the log is exhausted. Continuation lines are not passed to fn.
+func readKmsg(path string, fn func(record string) bool) error {
+	r, err := openKmsg(path)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	for {
+		record, ok, err := r.next()
+		if err != nil || !ok {
+			return err
+		}
+		header, _, _ := strings.Cut(record, "\n")
+		if !fn(header) {
+			return nil
+		}
+	}
+}
+
+// kmsgReader reads the records of a kernel log.
+//
+// Each read of /dev/kmsg returns one record, followed by continuation lines that start
+// with a space. The file is read with raw non-blocking reads: the Go runtime would park
+// a read at the end of the log until the next message is logged.
+type kmsgReader struct {
+	path string
+	fd   int
+	buf  []byte
+	// Records of the last read not returned yet. Regular files, e.g. in tests, return
+	// several records per read.
+	pending []string
+}
+
+func openKmsg(path string) (*kmsgReader, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &kmsgReader{path: path, fd: fd, buf: make([]byte, kmsgRecordMax)}, nil
+}
+
+func (r *kmsgReader) close() error {
+	return unix.Close(r.fd)
+}
+
+// seekEnd skips the records logged so far
+func (r *kmsgReader) seekEnd() error {
+	r.pending = nil
+	if _, err := unix.Seek(r.fd, 0, unix.SEEK_END); err != nil {
+		return fmt.Errorf("failed to seek to the end of %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// next returns the next record with its continuation lines. ok is false at the end of
+// the log.
+func (r *kmsgReader) next() (record string, ok bool, err error) {
+	for len(r.pending) == 0 {
+		n, err := unix.Read(r.fd, r.buf)
+		switch {
+		case errors.Is(err, unix.EAGAIN):
+			return "", false, nil
+		case errors.Is(err, unix.EPIPE), errors.Is(err, unix.EINTR):
+			// EPIPE: the next record was overwritten, reading continues at the oldest one
+			continue
+		case err != nil:
+			return "", false, fmt.Errorf("failed to read %s: %w", r.path, err)
+		case n == 0:
+			return "", false, nil
+		}
+		r.pending = splitKmsgRecords(string(r.buf[:n]))
+	}
+	record, r.pending = r.pending[0], r.pending[1:]
+	return record, true, nil
+}
+
+// wait waits until a record can be read or timeout passes
+func (r *kmsgReader) wait(timeout time.Duration) error {
+	fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN}}
+	if _, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil && !errors.Is(err, unix.EINTR) {
+		return fmt.Errorf("failed to poll %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// splitKmsgRecords splits data read from a kernel log into records, each with its
+// continuation lines
+func splitKmsgRecords(data string) []string {
+	var records []string
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		switch {
+		case line == "":
+		case line[0] == ' ':
+			if len(records) > 0 {
+				records[len(records)-1] += "\n" + line
+			}
+		default:
+			records = append(records, line)
+		}
+	}
+	return records
+}
@@ -0,0 +1,42 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build !linux
+
+package collectors
+
+import (
+	"errors"
+	"time"
+)
+
+var errKmsgUnsupported = errors.New("/dev/kmsg is only available on Linux")
+
+func readKmsg(path string, fn func(record string) bool) error {
+	return errKmsgUnsupported
+}
+
+type kmsgReader struct{}
+
+func openKmsg(path string) (*kmsgReader, error) {
+	return nil, errKmsgUnsupported
+}
+
+func (r *kmsgReader) close() error {
+	return errKmsgUnsupported
+}
+
+func (r *kmsgReader) seekEnd() error {
+	return errKmsgUnsupported
+}
+
+func (r *kmsgReader) next() (string, bool, error) {
+	return "", false, errKmsgUnsupported
+}
+
+func (r *kmsgReader) wait(timeout time.Duration) error {
+	return errKmsgUnsupported
+}
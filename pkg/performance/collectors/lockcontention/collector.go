@@ -0,0 +1,181 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package lockcontention measures how long processes wait on futexes and contended kernel
+// locks with eBPF, to diagnose applications that are slow while their CPU usage is low.
+package lockcontention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/go-logr/logr"
+
+	"github.com/antimetal/agent/pkg/ebpf/loader"
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*Collector)(nil)
+
+const (
+	objectName = "lockcontention.bpf.o"
+	statsMap   = "stats"
+
+	lockBeginProgram = "tracepoint__lock__contention_begin"
+	lockEndProgram   = "tracepoint__lock__contention_end"
+
+	// topProcesses is how many of the processes that waited the longest are reported
+	topProcesses = 50
+)
+
+// tracepoints are the programs of the BPF object and the tracepoints they attach to. The
+// lock tracepoints were added in Linux 5.19.
+var tracepoints = map[string]loader.Tracepoint{
+	"tracepoint__syscalls__sys_enter_futex": {Group: "syscalls", Name: "sys_enter_futex"},
+	"tracepoint__syscalls__sys_exit_futex":  {Group: "syscalls", Name: "sys_exit_futex"},
+	lockBeginProgram:                        {Group: "lock", Name: "contention_begin", Optional: true},
+	lockEndProgram:                          {Group: "lock", Name: "contention_end", Optional: true},
+}
+
+// Collector reports the processes whose threads waited the longest on futexes and
+// contended kernel locks since the previous collection.
+//
+// The BPF programs add up the waits of each process in a hash map, which each collection
+// reads and empties. The first collection loads the programs, which then stay attached
+// for the life of the agent. Kernel locks are only traced on kernels with the lock
+// contention tracepoints, Linux 5.19 and later.
+type Collector struct {
+	performance.BaseCollector
+	loader   *loader.Manager
+	procPath string
+
+	mu          sync.Mutex
+	bpf         *loader.Handle
+	stats       *ebpf.Map
+	kernelLocks bool
+	last        time.Time
+}
+
+func NewCollector(logger logr.Logger, config performance.CollectionConfig) (*Collector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       true,
+		RequiresEBPF:       true,
+		MinKernelVersion:   "5.4", // BTF for CO-RE
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	return &Collector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeLockContention,
+			"Lock Contention Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		loader:   loader.Shared(config.HostSysPath),
+		procPath: config.HostProcPath,
+	}, nil
+}
+
+// Collect returns the waits since the previous collection. The first collection starts
+// tracing and returns no process.
+func (c *Collector) Collect(ctx context.Context) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.bpf == nil {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+		c.last = now
+		return &performance.LockContentionStats{KernelLocks: c.kernelLocks}, nil
+	}
+
+	all, err := c.drain()
+	if err != nil {
+		return nil, err
+	}
+	stats := topWaits(all, topProcesses, c.command)
+	stats.Interval = now.Sub(c.last)
+	stats.KernelLocks = c.kernelLocks
+	c.last = now
+	return stats, nil
+}
+
+// load loads the BPF object and attaches its programs
+func (c *Collector) load() error {
+	bpf, err := c.loader.Load(loader.Object{Name: objectName, Tracepoints: tracepoints})
+	if err != nil {
+		return err
+	}
+	stats, err := bpf.Map(statsMap)
+	if err != nil {
+		bpf.Close()
+		return err
+	}
+	c.bpf = bpf
+	c.stats = stats
+	c.kernelLocks = bpf.Attached(lockBeginProgram) && bpf.Attached(lockEndProgram)
+	if !c.kernelLocks {
+		c.Logger().Info("kernel lock contention isn't traced, the kernel has no lock tracepoints")
+	}
+	return nil
+}
+
+// drain reads and deletes the waits of every process in the stats map. Waits that end
+// between reading and deleting the stats of their process are lost.
+func (c *Collector) drain() (map[uint32]waits, error) {
+	all := make(map[uint32]waits)
+	var (
+		pid uint32
+		raw []byte
+	)
+	iter := c.stats.Iterate()
+	for iter.Next(&pid, &raw) {
+		w, err := decodeWaits(raw)
+		if err != nil {
+			return nil, err
+		}
+		all[pid] = w
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lock contention stats: %w", err)
+	}
+
+	for pid := range all {
+		if err := c.stats.Delete(pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return nil, fmt.Errorf("failed to reset lock contention stats: %w", err)
+		}
+	}
+	return all, nil
+}
+
+// command returns the name of process pid, or an empty string if it exited
+func (c *Collector) command(pid uint32) string {
+	comm, err := os.ReadFile(filepath.Join(c.procPath, strconv.FormatUint(uint64(pid), 10), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
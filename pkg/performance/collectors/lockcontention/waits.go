@@ -0,0 +1,99 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package lockcontention
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Layout of struct lockcontention_stats in ebpf/include/lockcontention_types.h
+const (
+	taskCommLen = 16
+	statsSize   = 6*8 + taskCommLen
+)
+
+// waits are the waits of a process as counted by the BPF programs
+type waits struct {
+	futexWaits      uint64
+	futexWaitTime   time.Duration
+	futexMaxWait    time.Duration
+	lockContentions uint64
+	lockWaitTime    time.Duration
+	lockMaxWait     time.Duration
+	// comm is the name of the thread that waited first
+	comm string
+}
+
+// decodeWaits decodes the stats of a process in the map of the BPF programs
+func decodeWaits(raw []byte) (waits, error) {
+	if len(raw) < statsSize {
+		return waits{}, fmt.Errorf("stats too short: %d bytes, want %d", len(raw), statsSize)
+	}
+	le := binary.NativeEndian
+	comm := raw[48:statsSize]
+	if i := bytes.IndexByte(comm, 0); i >= 0 {
+		comm = comm[:i]
+	}
+	return waits{
+		futexWaits:      le.Uint64(raw[0:8]),
+		futexWaitTime:   time.Duration(le.Uint64(raw[8:16])),
+		futexMaxWait:    time.Duration(le.Uint64(raw[16:24])),
+		lockContentions: le.Uint64(raw[24:32]),
+		lockWaitTime:    time.Duration(le.Uint64(raw[32:40])),
+		lockMaxWait:     time.Duration(le.Uint64(raw[40:48])),
+		comm:            string(comm),
+	}, nil
+}
+
+// topWaits returns the limit processes of all that waited the longest. Their command is
+// looked up with command, falling back to the name of their thread that waited first if
+// they exited.
+func topWaits(all map[uint32]waits, limit int, command func(pid uint32) string) *performance.LockContentionStats {
+	pids := make([]uint32, 0, len(all))
+	for pid := range all {
+		pids = append(pids, pid)
+	}
+	slices.SortFunc(pids, func(a, b uint32) int {
+		wa, wb := all[a], all[b]
+		if c := cmp.Compare(wb.futexWaitTime+wb.lockWaitTime, wa.futexWaitTime+wa.lockWaitTime); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	stats := &performance.LockContentionStats{}
+	if len(pids) > limit {
+		stats.OtherProcesses = len(pids) - limit
+		pids = pids[:limit]
+	}
+	stats.Processes = make([]performance.ProcessLockContention, 0, len(pids))
+	for _, pid := range pids {
+		w := all[pid]
+		name := command(pid)
+		if name == "" {
+			name = w.comm
+		}
+		stats.Processes = append(stats.Processes, performance.ProcessLockContention{
+			PID:             int32(pid),
+			Command:         name,
+			FutexWaits:      w.futexWaits,
+			FutexWaitTime:   w.futexWaitTime,
+			FutexMaxWait:    w.futexMaxWait,
+			LockContentions: w.lockContentions,
+			LockWaitTime:    w.lockWaitTime,
+			LockMaxWait:     w.lockMaxWait,
+		})
+	}
+	return stats
+}
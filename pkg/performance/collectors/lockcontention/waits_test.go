@@ -0,0 +1,83 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package lockcontention
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// rawStats encodes the stats of a process the way the BPF programs lay them out
+func rawStats(futexWaits uint64, futexWait time.Duration, lockContentions uint64, lockWait time.Duration, comm string) []byte {
+	raw := make([]byte, statsSize)
+	le := binary.NativeEndian
+	le.PutUint64(raw[0:8], futexWaits)
+	le.PutUint64(raw[8:16], uint64(futexWait))
+	le.PutUint64(raw[16:24], uint64(futexWait/2))
+	le.PutUint64(raw[24:32], lockContentions)
+	le.PutUint64(raw[32:40], uint64(lockWait))
+	le.PutUint64(raw[40:48], uint64(lockWait/2))
+	copy(raw[48:], comm)
+	return raw
+}
+
+func TestDecodeWaits(t *testing.T) {
+	w, err := decodeWaits(rawStats(10, time.Second, 3, time.Millisecond, "worker-1"))
+	require.NoError(t, err)
+	assert.Equal(t, waits{
+		futexWaits:      10,
+		futexWaitTime:   time.Second,
+		futexMaxWait:    500 * time.Millisecond,
+		lockContentions: 3,
+		lockWaitTime:    time.Millisecond,
+		lockMaxWait:     500 * time.Microsecond,
+		comm:            "worker-1",
+	}, w)
+
+	// The name of the thread fills the whole field without a NUL
+	w, err = decodeWaits(rawStats(1, 0, 0, 0, "0123456789abcdef"))
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", w.comm)
+
+	_, err = decodeWaits(make([]byte, statsSize-1))
+	assert.Error(t, err)
+}
+
+func TestTopWaits(t *testing.T) {
+	all := map[uint32]waits{
+		100: {futexWaits: 5, futexWaitTime: time.Second, comm: "java"},
+		200: {lockContentions: 2, lockWaitTime: 3 * time.Second, comm: "postgres"},
+		300: {futexWaits: 1, futexWaitTime: time.Second, lockWaitTime: time.Second, comm: "gc-thread"},
+		400: {futexWaits: 1, futexWaitTime: time.Millisecond, comm: "sleep"},
+	}
+	commands := map[uint32]string{100: "java", 200: "postgres"}
+	command := func(pid uint32) string { return commands[pid] }
+
+	stats := topWaits(all, 3, command)
+	assert.Equal(t, 1, stats.OtherProcesses)
+	require.Len(t, stats.Processes, 3)
+	assert.Equal(t, performance.ProcessLockContention{
+		PID:             200,
+		Command:         "postgres",
+		LockContentions: 2,
+		LockWaitTime:    3 * time.Second,
+	}, stats.Processes[0])
+	assert.Equal(t, int32(300), stats.Processes[1].PID)
+	// The process exited, its name is that of the thread that waited
+	assert.Equal(t, "gc-thread", stats.Processes[1].Command)
+	assert.Equal(t, int32(100), stats.Processes[2].PID)
+
+	stats = topWaits(nil, 3, command)
+	assert.Empty(t, stats.Processes)
+	assert.Zero(t, stats.OtherProcesses)
+}
@@ -0,0 +1,270 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*MemoryBandwidthCollector)(nil)
+
+// MemoryBandwidthCollector reports the memory bandwidth and last level cache occupancy
+// measured by Intel RDT or AMD QoS. On dense nodes, workloads contending for memory
+// bandwidth or evicting each other from the L3 cache slow down without any sign in the
+// CPU or memory metrics.
+//
+// The hardware tags the memory traffic of every task with the monitoring ID of its
+// resctrl group and counts it per L3 cache domain. The collector reports the usage of
+// the host per domain, which is the sum of the default group and the resource groups,
+// and that of each group. Bandwidth is computed from the counters of the previous
+// collection.
+//
+// The collector is optional. It reports Supported false on hosts without the hardware
+// support or where resctrl isn't mounted at /sys/fs/resctrl.
+//
+// Data sources:
+//   - /sys/fs/resctrl/info/L3_MON/mon_features: supported monitoring events
+//   - /sys/fs/resctrl/[group/][mon_groups/group/]mon_data/mon_L3_<id>/: llc_occupancy,
+//     mbm_total_bytes and mbm_local_bytes of a group in a domain
+//   - /sys/devices/system/cpu/cpu*/cache/index*/{level,id}: CPUs of each L3 cache
+//
+// Reference: https://docs.kernel.org/arch/x86/resctrl.html
+type MemoryBandwidthCollector struct {
+	performance.BaseCollector
+	resctrlPath string
+	cpuPath     string
+
+	mu sync.Mutex
+	// Counters of the previous collection by group and domain, used to compute bandwidth
+	prevTime     time.Time
+	prevCounters map[mbmKey]mbmCounters
+}
+
+type mbmKey struct {
+	group  string
+	domain int
+}
+
+type mbmCounters struct {
+	total, local uint64
+}
+
+func NewMemoryBandwidthCollector(logger logr.Logger, config performance.CollectionConfig) (*MemoryBandwidthCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false, // Mounting resctrl does, its files are world readable
+		RequiresEBPF:       false,
+		MinKernelVersion:   "4.14", // resctrl monitoring
+	}
+
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	if _, err := os.Stat(config.HostSysPath); err != nil {
+		return nil, fmt.Errorf("HostSysPath validation failed: %w", err)
+	}
+
+	return &MemoryBandwidthCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeMemoryBandwidth,
+			"Memory Bandwidth Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		resctrlPath: filepath.Join(config.HostSysPath, "fs", "resctrl"),
+		cpuPath:     filepath.Join(config.HostSysPath, "devices", "system", "cpu"),
+	}, nil
+}
+
+func (c *MemoryBandwidthCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectMemoryBandwidth(ctx, time.Now())
+}
+
+func (c *MemoryBandwidthCollector) collectMemoryBandwidth(ctx context.Context, now time.Time) (*performance.MemoryBandwidthStats, error) {
+	features, err := os.ReadFile(filepath.Join(c.resctrlPath, "info", "L3_MON", "mon_features"))
+	if err != nil {
+		// Not mounted, or no L3 monitoring
+		return &performance.MemoryBandwidthStats{}, nil
+	}
+	stats := &performance.MemoryBandwidthStats{
+		Supported: true,
+		Features:  strings.Fields(string(features)),
+	}
+
+	groups, err := c.listGroups()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elapsed := 0.0
+	if !c.prevTime.IsZero() {
+		elapsed = now.Sub(c.prevTime).Seconds()
+	}
+	counters := make(map[mbmKey]mbmCounters)
+	host := make(map[int]*performance.MemoryBandwidthDomain)
+	for _, group := range groups {
+		domains := readMonData(filepath.Join(c.resctrlPath, group, "mon_data"))
+		for i := range domains {
+			domain := &domains[i]
+			key := mbmKey{group: group, domain: domain.ID}
+			counters[key] = mbmCounters{total: domain.TotalBytes, local: domain.LocalBytes}
+			if prev, ok := c.prevCounters[key]; ok && elapsed > 0 {
+				// A counter going backwards is a group recreated with the same name
+				if domain.TotalBytes >= prev.total {
+					domain.TotalBandwidth = float64(domain.TotalBytes-prev.total) / elapsed
+				}
+				if domain.LocalBytes >= prev.local {
+					domain.LocalBandwidth = float64(domain.LocalBytes-prev.local) / elapsed
+				}
+			}
+
+			// The mon_data of a resource group includes its monitoring groups, so the
+			// host is the default group and the resource groups
+			if filepath.Base(filepath.Dir(group)) == "mon_groups" {
+				continue
+			}
+			total, ok := host[domain.ID]
+			if !ok {
+				total = &performance.MemoryBandwidthDomain{ID: domain.ID}
+				host[domain.ID] = total
+			}
+			total.LLCOccupancy += domain.LLCOccupancy
+			total.TotalBytes += domain.TotalBytes
+			total.LocalBytes += domain.LocalBytes
+			total.TotalBandwidth += domain.TotalBandwidth
+			total.LocalBandwidth += domain.LocalBandwidth
+		}
+		if group != "" && len(domains) > 0 {
+			stats.Groups = append(stats.Groups, performance.MemoryBandwidthGroup{Name: group, Domains: domains})
+		}
+	}
+	c.prevTime, c.prevCounters = now, counters
+
+	cpus := c.l3CPUs()
+	for _, domain := range host {
+		domain.CPUs = cpus[domain.ID]
+		stats.Domains = append(stats.Domains, *domain)
+	}
+	slices.SortFunc(stats.Domains, func(a, b performance.MemoryBandwidthDomain) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	slices.SortFunc(stats.Groups, func(a, b performance.MemoryBandwidthGroup) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return stats, nil
+}
+
+// listGroups returns the paths relative to the resctrl mount of the default group (""),
+// the resource groups and the monitoring groups of both
+func (c *MemoryBandwidthCollector) listGroups() ([]string, error) {
+	entries, err := os.ReadDir(c.resctrlPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.resctrlPath, err)
+	}
+	parents := []string{""}
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case !entry.IsDir(), name == "info", name == "mon_groups", name == "mon_data":
+		default:
+			parents = append(parents, name)
+		}
+	}
+
+	var groups []string
+	for _, parent := range parents {
+		groups = append(groups, parent)
+		monGroups, err := os.ReadDir(filepath.Join(c.resctrlPath, parent, "mon_groups"))
+		if err != nil {
+			// Groups may be removed while they are listed
+			continue
+		}
+		for _, entry := range monGroups {
+			if entry.IsDir() {
+				groups = append(groups, filepath.Join(parent, "mon_groups", entry.Name()))
+			}
+		}
+	}
+	return groups, nil
+}
+
+// readMonData reads the counters of a group in every L3 domain from its mon_data
+// directory. A counter reads "Unavailable" while the hardware has no monitoring ID for
+// the group, and is left at 0.
+func readMonData(path string) []performance.MemoryBandwidthDomain {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	var domains []performance.MemoryBandwidthDomain
+	for _, entry := range entries {
+		idStr, ok := strings.CutPrefix(entry.Name(), "mon_L3_")
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(path, entry.Name())
+		domain := performance.MemoryBandwidthDomain{ID: id}
+		domain.LLCOccupancy, _ = procparse.ReadUintFile(filepath.Join(dir, "llc_occupancy"))
+		domain.TotalBytes, _ = procparse.ReadUintFile(filepath.Join(dir, "mbm_total_bytes"))
+		domain.LocalBytes, _ = procparse.ReadUintFile(filepath.Join(dir, "mbm_local_bytes"))
+		domains = append(domains, domain)
+	}
+	slices.SortFunc(domains, func(a, b performance.MemoryBandwidthDomain) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return domains
+}
+
+// l3CPUs returns the CPUs of each L3 cache by cache ID, from the cache topology of sysfs
+func (c *MemoryBandwidthCollector) l3CPUs() map[int][]int {
+	cpus := make(map[int][]int)
+	indexes, _ := filepath.Glob(filepath.Join(c.cpuPath, "cpu[0-9]*", "cache", "index[0-9]*"))
+	for _, index := range indexes {
+		if procparse.ReadStringFile(filepath.Join(index, "level")) != "3" {
+			continue
+		}
+		id, err := procparse.ReadUintFile(filepath.Join(index, "id"))
+		if err != nil {
+			continue
+		}
+		cpu, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(filepath.Dir(index))), "cpu"))
+		if err != nil {
+			continue
+		}
+		cpus[int(id)] = append(cpus[int(id)], cpu)
+	}
+	for _, list := range cpus {
+		slices.Sort(list)
+	}
+	return cpus
+}
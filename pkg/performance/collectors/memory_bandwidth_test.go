@@ -0,0 +1,138 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResctrlFiles is a two socket host with a resource group "gold" holding a
+// monitoring group "pod1"
+var testResctrlFiles = map[string]string{
+	"fs/resctrl/info/L3_MON/mon_features":                                "llc_occupancy\nmbm_total_bytes\nmbm_local_bytes\n",
+	"fs/resctrl/schemata":                                                "L3:0=fff;1=fff\n",
+	"fs/resctrl/mon_data/mon_L3_00/llc_occupancy":                        "1000\n",
+	"fs/resctrl/mon_data/mon_L3_00/mbm_total_bytes":                      "5000\n",
+	"fs/resctrl/mon_data/mon_L3_00/mbm_local_bytes":                      "4000\n",
+	"fs/resctrl/mon_data/mon_L3_01/llc_occupancy":                        "2000\n",
+	"fs/resctrl/mon_data/mon_L3_01/mbm_total_bytes":                      "Unavailable\n",
+	"fs/resctrl/mon_data/mon_L3_01/mbm_local_bytes":                      "Unavailable\n",
+	"fs/resctrl/gold/mon_data/mon_L3_00/llc_occupancy":                   "300\n",
+	"fs/resctrl/gold/mon_data/mon_L3_00/mbm_total_bytes":                 "700\n",
+	"fs/resctrl/gold/mon_data/mon_L3_00/mbm_local_bytes":                 "600\n",
+	"fs/resctrl/gold/mon_groups/pod1/mon_data/mon_L3_00/llc_occupancy":   "100\n",
+	"fs/resctrl/gold/mon_groups/pod1/mon_data/mon_L3_00/mbm_total_bytes": "200\n",
+	"fs/resctrl/gold/mon_groups/pod1/mon_data/mon_L3_00/mbm_local_bytes": "150\n",
+	"devices/system/cpu/cpu0/cache/index2/level":                         "2\n",
+	"devices/system/cpu/cpu0/cache/index2/id":                            "0\n",
+	"devices/system/cpu/cpu0/cache/index3/level":                         "3\n",
+	"devices/system/cpu/cpu0/cache/index3/id":                            "0\n",
+	"devices/system/cpu/cpu1/cache/index3/level":                         "3\n",
+	"devices/system/cpu/cpu1/cache/index3/id":                            "1\n",
+	"devices/system/cpu/cpu2/cache/index3/level":                         "3\n",
+	"devices/system/cpu/cpu2/cache/index3/id":                            "0\n",
+}
+
+func createMemoryBandwidthCollector(t *testing.T, files map[string]string) (*collectors.MemoryBandwidthCollector, string) {
+	sysPath := t.TempDir()
+	writeSysFiles(t, sysPath, files)
+	collector, err := collectors.NewMemoryBandwidthCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: sysPath})
+	require.NoError(t, err)
+	return collector, sysPath
+}
+
+func collectMemoryBandwidth(t *testing.T, collector *collectors.MemoryBandwidthCollector) *performance.MemoryBandwidthStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.MemoryBandwidthStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestMemoryBandwidthCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewMemoryBandwidthCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: "relative"})
+	assert.ErrorContains(t, err, "HostSysPath must be an absolute path")
+
+	_, err = collectors.NewMemoryBandwidthCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostSysPath validation failed")
+}
+
+func TestMemoryBandwidthCollector_NotSupported(t *testing.T) {
+	collector, _ := createMemoryBandwidthCollector(t, map[string]string{
+		"devices/system/cpu/cpu0/cache/index3/level": "3\n",
+	})
+	stats := collectMemoryBandwidth(t, collector)
+	assert.Equal(t, &performance.MemoryBandwidthStats{}, stats)
+}
+
+func TestMemoryBandwidthCollector_Collect(t *testing.T) {
+	collector, _ := createMemoryBandwidthCollector(t, testResctrlFiles)
+	stats := collectMemoryBandwidth(t, collector)
+
+	assert.True(t, stats.Supported)
+	assert.Equal(t, []string{"llc_occupancy", "mbm_total_bytes", "mbm_local_bytes"}, stats.Features)
+	assert.Equal(t, []performance.MemoryBandwidthDomain{
+		// The default group and gold, which includes pod1
+		{ID: 0, CPUs: []int{0, 2}, LLCOccupancy: 1300, TotalBytes: 5700, LocalBytes: 4600},
+		{ID: 1, CPUs: []int{1}, LLCOccupancy: 2000},
+	}, stats.Domains)
+	assert.Equal(t, []performance.MemoryBandwidthGroup{
+		{Name: "gold", Domains: []performance.MemoryBandwidthDomain{
+			{ID: 0, LLCOccupancy: 300, TotalBytes: 700, LocalBytes: 600},
+		}},
+		{Name: "gold/mon_groups/pod1", Domains: []performance.MemoryBandwidthDomain{
+			{ID: 0, LLCOccupancy: 100, TotalBytes: 200, LocalBytes: 150},
+		}},
+	}, stats.Groups)
+}
+
+func TestMemoryBandwidthCollector_Bandwidth(t *testing.T) {
+	collector, sysPath := createMemoryBandwidthCollector(t, testResctrlFiles)
+	first := collectMemoryBandwidth(t, collector)
+	for _, domain := range first.Domains {
+		assert.Zero(t, domain.TotalBandwidth, "bandwidth needs a previous collection")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, sysPath, map[string]string{
+		"fs/resctrl/mon_data/mon_L3_00/mbm_total_bytes":                      "1005000\n",
+		"fs/resctrl/gold/mon_data/mon_L3_00/mbm_total_bytes":                 "500700\n",
+		"fs/resctrl/gold/mon_groups/pod1/mon_data/mon_L3_00/mbm_total_bytes": "500200\n",
+	})
+	second := collectMemoryBandwidth(t, collector)
+
+	require.Len(t, second.Domains, 2)
+	host := second.Domains[0]
+	assert.Greater(t, host.TotalBandwidth, 0.0)
+	assert.LessOrEqual(t, host.TotalBandwidth, 1500000/0.05)
+	assert.Zero(t, host.LocalBandwidth)
+	require.Len(t, second.Groups, 2)
+	gold, pod := second.Groups[0].Domains[0], second.Groups[1].Domains[0]
+	assert.InDelta(t, gold.TotalBandwidth, pod.TotalBandwidth, 1e-6)
+	assert.InDelta(t, host.TotalBandwidth, 3*gold.TotalBandwidth, 1e-6, "the host is the default group and gold")
+}
+
+func TestMemoryBandwidthCollector_CounterReset(t *testing.T) {
+	collector, sysPath := createMemoryBandwidthCollector(t, testResctrlFiles)
+	collectMemoryBandwidth(t, collector)
+
+	time.Sleep(10 * time.Millisecond)
+	// gold was removed and created again
+	writeSysFiles(t, sysPath, map[string]string{
+		"fs/resctrl/gold/mon_data/mon_L3_00/mbm_total_bytes": "10\n",
+	})
+	stats := collectMemoryBandwidth(t, collector)
+	assert.Zero(t, stats.Groups[0].Domains[0].TotalBandwidth)
+}
@@ -0,0 +1,292 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.StatefulCollector = (*MemoryPressureCollector)(nil)
+
+// MemoryPressureCollector collects the signals behind "everything is slow" episodes caused
+// by memory and IO pressure rather than CPU: dirty page cache piling up against the dirty
+// limits, kswapd scanning for free pages and allocations stalling in direct reclaim.
+//
+// Once dirty and writeback pages pass the dirty threshold, every process writing to the
+// page cache is throttled until writeback catches up, and once kswapd can't free pages as
+// fast as they are allocated, allocating processes reclaim pages themselves. Both stall
+// processes that never show up as busy. The collector remembers the reclaim counters of the
+// previous collection and reports their rates, as the counters alone only grow.
+//
+// The dirty thresholds are computed the way the kernel computes its global ones, from
+// vm.dirty_ratio or vm.dirty_bytes and the dirtyable memory: free pages plus the file
+// pages of the page cache. The kernel leaves out the reserved pages, so the thresholds
+// are slightly higher than the kernel's. Memory cgroups have thresholds of their own,
+// which aren't reported.
+//
+// Data sources:
+// - /proc/meminfo: Dirty, Writeback, MemFree, Active(file) and Inactive(file)
+// - /proc/sys/vm/: dirty_ratio, dirty_bytes, dirty_background_ratio and dirty_background_bytes
+// - /proc/vmstat: pgscan_kswapd, pgsteal_kswapd, pgscan_direct, pgsteal_direct and
+// allocstall, per zone since Linux 4.8
+// - /proc/pressure/{memory,io}: pressure stall information (Linux 4.20+, CONFIG_PSI)
+//
+// Reference: https://docs.kernel.org/admin-guide/sysctl/vm.html
+// Reference: https://docs.kernel.org/accounting/psi.html
+type MemoryPressureCollector struct {
+	performance.BaseCollector
+	procPath string
+
+	mu sync.Mutex
+	// Counters from the previous collection used to compute rates
+	prevTime time.Time
+	prev     reclaimCounters
+}
+
+// reclaimCounters are the /proc/vmstat counters of page reclaim
+type reclaimCounters struct {
+	KswapdScanned   uint64 `json:"kswapdScanned"`
+	KswapdReclaimed uint64 `json:"kswapdReclaimed"`
+	DirectScanned   uint64 `json:"directScanned"`
+	DirectReclaimed uint64 `json:"directReclaimed"`
+	AllocStalls     uint64 `json:"allocStalls"`
+}
+
+func NewMemoryPressureCollector(logger logr.Logger, config performance.CollectionConfig) (*MemoryPressureCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &MemoryPressureCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeMemoryPressure,
+			"Memory Pressure Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath: config.HostProcPath,
+	}, nil
+}
+
+func (c *MemoryPressureCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectMemoryPressureStats(ctx, time.Now())
+}
+
+func (c *MemoryPressureCollector) collectMemoryPressureStats(ctx context.Context, now time.Time) (*performance.MemoryPressureStats, error) {
+	meminfo, err := procparse.ParseKVFile(filepath.Join(c.procPath, "meminfo"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read meminfo: %w", err)
+	}
+	vmstat, err := procparse.ParseKVFile(filepath.Join(c.procPath, "vmstat"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vmstat: %w", err)
+	}
+
+	stats := &performance.MemoryPressureStats{
+		DirtyBytes:     meminfo["Dirty"] * 1024,
+		WritebackBytes: meminfo["Writeback"] * 1024,
+	}
+	dirtyable := (meminfo["MemFree"] + meminfo["Active(file)"] + meminfo["Inactive(file)"]) * 1024
+	stats.DirtyBackgroundThresholdBytes, stats.DirtyThresholdBytes = c.dirtyThresholds(dirtyable)
+	if stats.DirtyThresholdBytes > 0 {
+		stats.DirtyPercent = 100 * float64(stats.DirtyBytes+stats.WritebackBytes) /
+			float64(stats.DirtyThresholdBytes)
+	}
+
+	counters := reclaimCounters{
+		KswapdScanned:   vmstat["pgscan_kswapd"],
+		KswapdReclaimed: vmstat["pgsteal_kswapd"],
+		DirectScanned:   vmstat["pgscan_direct"],
+		DirectReclaimed: vmstat["pgsteal_direct"],
+		AllocStalls:     allocStalls(vmstat),
+	}
+	stats.KswapdScanned = counters.KswapdScanned
+	stats.KswapdReclaimed = counters.KswapdReclaimed
+	stats.DirectScanned = counters.DirectScanned
+	stats.DirectReclaimed = counters.DirectReclaimed
+	stats.AllocStalls = counters.AllocStalls
+
+	if stats.Memory, err = c.parsePressure("memory"); err != nil {
+		c.Logger().V(2).Info("Failed to read memory pressure", "error", err)
+	}
+	if stats.IO, err = c.parsePressure("io"); err != nil {
+		c.Logger().V(2).Info("Failed to read IO pressure", "error", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.prevTime.IsZero() {
+		if elapsed := now.Sub(c.prevTime); elapsed > 0 {
+			seconds := elapsed.Seconds()
+			stats.Interval = elapsed
+			stats.KswapdScanRate = counterRate(c.prev.KswapdScanned, counters.KswapdScanned, seconds)
+			stats.KswapdReclaimRate = counterRate(c.prev.KswapdReclaimed, counters.KswapdReclaimed, seconds)
+			stats.DirectScanRate = counterRate(c.prev.DirectScanned, counters.DirectScanned, seconds)
+			stats.DirectReclaimRate = counterRate(c.prev.DirectReclaimed, counters.DirectReclaimed, seconds)
+			stats.AllocStallRate = counterRate(c.prev.AllocStalls, counters.AllocStalls, seconds)
+		}
+	}
+	c.prevTime = now
+	c.prev = counters
+
+	return stats, nil
+}
+
+// dirtyThresholds returns the dirty page cache in bytes above which the kernel starts
+// background writeback and throttles writers, given the dirtyable memory in bytes. The
+// *_bytes sysctls take precedence over the *_ratio ones when set, and the background
+// threshold is half the throttling one if it isn't below it, as in the kernel.
+func (c *MemoryPressureCollector) dirtyThresholds(dirtyable uint64) (background, throttle uint64) {
+	vmPath := filepath.Join(c.procPath, "sys", "vm")
+	threshold := func(name string) uint64 {
+		if bytes, _ := procparse.ReadUintFile(filepath.Join(vmPath, name+"_bytes")); bytes > 0 {
+			return bytes
+		}
+		ratio, _ := procparse.ReadUintFile(filepath.Join(vmPath, name+"_ratio"))
+		return dirtyable * ratio / 100
+	}
+	throttle = threshold("dirty")
+	background = threshold("dirty_background")
+	if background >= throttle {
+		background = throttle / 2
+	}
+	return background, throttle
+}
+
+// allocStalls returns the allocations that stalled in direct reclaim. Linux 4.8 split the
+// allocstall counter per zone, e.g. allocstall_normal and allocstall_movable.
+func allocStalls(vmstat map[string]uint64) uint64 {
+	total := vmstat["allocstall"]
+	for key, value := range vmstat {
+		if strings.HasPrefix(key, "allocstall_") {
+			total += value
+		}
+	}
+	return total
+}
+
+// parsePressure parses /proc/pressure/<resource>. It returns nil if the kernel has no
+// pressure stall information.
+//
+// Format:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//
+// Totals are in microseconds.
+func (c *MemoryPressureCollector) parsePressure(resource string) (*performance.PressureStats, error) {
+	data, err := os.ReadFile(filepath.Join(c.procPath, "pressure", resource))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var pressure performance.PressureStats
+	for line := range strings.SplitSeq(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var stall *performance.PressureStall
+		switch fields[0] {
+		case "some":
+			stall = &pressure.Some
+		case "full":
+			stall = &pressure.Full
+		default:
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if key == "total" {
+				us, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid total %q in %s pressure", value, resource)
+				}
+				stall.Total = time.Duration(us) * time.Microsecond
+				continue
+			}
+			avg, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q in %s pressure", key, value, resource)
+			}
+			switch key {
+			case "avg10":
+				stall.Avg10 = avg
+			case "avg60":
+				stall.Avg60 = avg
+			case "avg300":
+				stall.Avg300 = avg
+			}
+		}
+	}
+	return &pressure, nil
+}
+
+// memoryPressureState is the persisted state of the MemoryPressureCollector
+type memoryPressureState struct {
+	Time     time.Time       `json:"time"`
+	Counters reclaimCounters `json:"counters"`
+}
+
+func (c *MemoryPressureCollector) SaveState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prevTime.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(memoryPressureState{Time: c.prevTime, Counters: c.prev})
+}
+
+func (c *MemoryPressureCollector) RestoreState(data []byte) error {
+	var state memoryPressureState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prevTime = state.Time
+	c.prev = state.Counters
+	return nil
+}
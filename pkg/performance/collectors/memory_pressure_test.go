@@ -0,0 +1,196 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 1 GiB of dirtyable memory: 256 MiB free and 768 MiB of file pages
+const testPressureMeminfo = `MemTotal:        4194304 kB
+MemFree:          262144 kB
+Active(file):     524288 kB
+Inactive(file):   262144 kB
+Dirty:            153600 kB
+Writeback:         51200 kB
+`
+
+const testPressureVmstat = `nr_dirty 38400
+pgscan_kswapd 1000
+pgsteal_kswapd 900
+pgscan_direct 100
+pgscan_direct_throttle 7
+pgsteal_direct 50
+allocstall_dma 0
+allocstall_dma32 1
+allocstall_normal 4
+allocstall_movable 5
+`
+
+func createMemoryPressureCollector(t *testing.T, files map[string]string) (*collectors.MemoryPressureCollector, string) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, files)
+
+	collector, err := collectors.NewMemoryPressureCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+	})
+	require.NoError(t, err)
+	return collector, procPath
+}
+
+func collectMemoryPressureStats(t *testing.T, collector *collectors.MemoryPressureCollector) *performance.MemoryPressureStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.MemoryPressureStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestMemoryPressureCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewMemoryPressureCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewMemoryPressureCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestMemoryPressureCollector_MissingFiles(t *testing.T) {
+	collector, _ := createMemoryPressureCollector(t, map[string]string{"vmstat": testPressureVmstat})
+	_, err := collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to read meminfo")
+
+	collector, _ = createMemoryPressureCollector(t, map[string]string{"meminfo": testPressureMeminfo})
+	_, err = collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to read vmstat")
+}
+
+func TestMemoryPressureCollector_Collect(t *testing.T) {
+	collector, _ := createMemoryPressureCollector(t, map[string]string{
+		"meminfo":                       testPressureMeminfo,
+		"vmstat":                        testPressureVmstat,
+		"sys/vm/dirty_ratio":            "20\n",
+		"sys/vm/dirty_bytes":            "0\n",
+		"sys/vm/dirty_background_ratio": "10\n",
+		"sys/vm/dirty_background_bytes": "0\n",
+		"pressure/memory": "some avg10=1.50 avg60=0.75 avg300=0.25 total=123456\n" +
+			"full avg10=0.50 avg60=0.10 avg300=0.00 total=4000\n",
+		"pressure/io": "some avg10=30.00 avg60=20.00 avg300=5.00 total=9000000\n" +
+			"full avg10=25.00 avg60=15.00 avg300=4.00 total=8000000\n",
+	})
+	stats := collectMemoryPressureStats(t, collector)
+
+	assert.Equal(t, uint64(150<<20), stats.DirtyBytes)
+	assert.Equal(t, uint64(50<<20), stats.WritebackBytes)
+	assert.Equal(t, uint64(1<<30)*20/100, stats.DirtyThresholdBytes)
+	assert.Equal(t, uint64(1<<30)*10/100, stats.DirtyBackgroundThresholdBytes)
+	assert.InDelta(t, 100*200.0/(1024*0.2), stats.DirtyPercent, 0.001)
+
+	assert.Equal(t, uint64(1000), stats.KswapdScanned)
+	assert.Equal(t, uint64(900), stats.KswapdReclaimed)
+	assert.Equal(t, uint64(100), stats.DirectScanned)
+	assert.Equal(t, uint64(50), stats.DirectReclaimed)
+	assert.Equal(t, uint64(10), stats.AllocStalls, "allocstall is summed over zones")
+	assert.Zero(t, stats.Interval, "rates need a previous collection")
+	assert.Zero(t, stats.KswapdScanRate, "rates need a previous collection")
+
+	assert.Equal(t, &performance.PressureStats{
+		Some: performance.PressureStall{Avg10: 1.5, Avg60: 0.75, Avg300: 0.25, Total: 123456 * time.Microsecond},
+		Full: performance.PressureStall{Avg10: 0.5, Avg60: 0.1, Total: 4 * time.Millisecond},
+	}, stats.Memory)
+	require.NotNil(t, stats.IO)
+	assert.Equal(t, 25.0, stats.IO.Full.Avg10)
+	assert.Equal(t, 9*time.Second, stats.IO.Some.Total)
+}
+
+func TestMemoryPressureCollector_DirtyBytes(t *testing.T) {
+	// vm.dirty_bytes overrides vm.dirty_ratio, and a background threshold above the
+	// throttling one is halved
+	collector, _ := createMemoryPressureCollector(t, map[string]string{
+		"meminfo":                       testPressureMeminfo,
+		"vmstat":                        testPressureVmstat,
+		"sys/vm/dirty_ratio":            "20\n",
+		"sys/vm/dirty_bytes":            "104857600\n",
+		"sys/vm/dirty_background_ratio": "50\n",
+		"sys/vm/dirty_background_bytes": "0\n",
+	})
+	stats := collectMemoryPressureStats(t, collector)
+
+	assert.Equal(t, uint64(100<<20), stats.DirtyThresholdBytes)
+	assert.Equal(t, uint64(50<<20), stats.DirtyBackgroundThresholdBytes)
+	assert.InDelta(t, 200.0, stats.DirtyPercent, 0.001, "dirty pages can exceed the threshold")
+	assert.Nil(t, stats.Memory, "kernels without PSI have no pressure")
+	assert.Nil(t, stats.IO, "kernels without PSI have no pressure")
+}
+
+func TestMemoryPressureCollector_LegacyAllocStall(t *testing.T) {
+	collector, _ := createMemoryPressureCollector(t, map[string]string{
+		"meminfo": testPressureMeminfo,
+		"vmstat":  "pgscan_kswapd 10\nallocstall 42\n",
+	})
+	stats := collectMemoryPressureStats(t, collector)
+
+	assert.Equal(t, uint64(42), stats.AllocStalls)
+	assert.Zero(t, stats.DirtyThresholdBytes, "no dirty sysctls")
+	assert.Zero(t, stats.DirtyPercent)
+}
+
+func TestMemoryPressureCollector_Rates(t *testing.T) {
+	collector, procPath := createMemoryPressureCollector(t, map[string]string{
+		"meminfo": testPressureMeminfo,
+		"vmstat":  "pgscan_kswapd 100\npgscan_direct 100\nallocstall_normal 10\n",
+	})
+
+	first := collectMemoryPressureStats(t, collector)
+	assert.Zero(t, first.KswapdScanRate)
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, procPath, map[string]string{"vmstat": "pgscan_kswapd 200\npgscan_direct 100\nallocstall_normal 15\n"})
+
+	second := collectMemoryPressureStats(t, collector)
+	assert.Greater(t, second.Interval, time.Duration(0))
+	assert.Greater(t, second.KswapdScanRate, 0.0)
+	assert.LessOrEqual(t, second.KswapdScanRate, 100/0.05)
+	assert.Zero(t, second.DirectScanRate)
+	assert.Greater(t, second.AllocStallRate, 0.0)
+
+	// Counters going backwards were reset and must not produce a rate
+	writeSysFiles(t, procPath, map[string]string{"vmstat": "pgscan_kswapd 5\npgscan_direct 5\nallocstall_normal 1\n"})
+	third := collectMemoryPressureStats(t, collector)
+	assert.Zero(t, third.KswapdScanRate)
+	assert.Zero(t, third.AllocStallRate)
+}
+
+func TestMemoryPressureCollector_RestoredState(t *testing.T) {
+	collector, procPath := createMemoryPressureCollector(t, map[string]string{
+		"meminfo": testPressureMeminfo,
+		"vmstat":  "allocstall_normal 10\n",
+	})
+	collectMemoryPressureStats(t, collector)
+	state, err := collector.SaveState()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+
+	// A new collector, e.g. after an agent restart, computes rates from the restored state
+	restarted, err := collectors.NewMemoryPressureCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+	})
+	require.NoError(t, err)
+	require.NoError(t, restarted.RestoreState(state))
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, procPath, map[string]string{"vmstat": "allocstall_normal 20\n"})
+	stats := collectMemoryPressureStats(t, restarted)
+	assert.Greater(t, stats.AllocStallRate, 0.0)
+}
@@ -0,0 +1,311 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*NeighborCollector)(nil)
+
+const (
+	// ARP entry flags from include/uapi/linux/if_arp.h
+	arpFlagComplete  = 0x02 // ATF_COM
+	arpFlagPermanent = 0x04 // ATF_PERM
+
+	// Route flags from include/uapi/linux/route.h
+	routeFlagUp      = 0x0001 // RTF_UP
+	routeFlagGateway = 0x0002 // RTF_GATEWAY
+
+	// gatewayProbePort is the discard port. The datagram only needs to make the kernel
+	// resolve the gateway's link layer address, the gateway doesn't have to answer.
+	gatewayProbePort = 9
+	// How long to wait for the gateway to be resolved after probing it
+	gatewayProbeTimeout  = 500 * time.Millisecond
+	gatewayProbeInterval = 50 * time.Millisecond
+)
+
+// NeighborCollector collects the IPv4 neighbor (ARP) table and checks whether the default
+// gateways are reachable at the link layer. A default gateway that can't be resolved is a
+// common cause of a node that is up but unreachable.
+//
+// Data sources:
+//   - /proc/1/net/arp: the ARP table of the host's network namespace
+//   - /proc/1/net/route: the IPv4 routing table, for the default gateways
+//   - /proc/self/net/{arp,route}: fallback when PID 1 isn't visible. They only cover the
+//     agent's own network namespace.
+//
+// /proc/net/arp doesn't tell INCOMPLETE entries, whose resolution is in progress, from
+// FAILED ones, so both are reported as unresolved.
+//
+// When a default gateway has no resolved entry, the collector probes it by sending a
+// single UDP datagram to its discard port, which makes the kernel resolve the gateway,
+// and waits briefly for the entry to resolve. The probe is sent from the agent's network
+// namespace, so it only reflects the host's gateways when the agent runs with host
+// networking.
+//
+// Reference: https://man7.org/linux/man-pages/man7/arp.7.html
+// Reference: https://git.kernel.org/pub/scm/linux/kernel/git/torvalds/linux.git/tree/net/ipv4/arp.c
+type NeighborCollector struct {
+	performance.BaseCollector
+	netPaths []string
+}
+
+func NewNeighborCollector(logger logr.Logger, config performance.CollectionConfig) (*NeighborCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &NeighborCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeNeighbor,
+			"Neighbor Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		netPaths: []string{
+			filepath.Join(config.HostProcPath, "1", "net"),
+			filepath.Join(config.HostProcPath, "self", "net"),
+		},
+	}, nil
+}
+
+func (c *NeighborCollector) Collect(ctx context.Context) (any, error) {
+	netPath, err := c.netPath()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readARPTable(filepath.Join(netPath, "arp"))
+	if err != nil {
+		return nil, err
+	}
+	stats := summarizeNeighbors(entries)
+
+	gateways, err := readDefaultGateways(filepath.Join(netPath, "route"))
+	if err != nil {
+		// The neighbor table is still useful without the gateways
+		c.Logger().V(1).Info("Failed to read default gateways", "error", err)
+		return stats, nil
+	}
+	for _, gw := range gateways {
+		if entry, ok := findNeighbor(entries, gw); ok && entry.complete() {
+			gw.HardwareAddress = entry.hwAddress
+			gw.Reachable = true
+		} else {
+			c.probeGateway(ctx, &gw, filepath.Join(netPath, "arp"))
+		}
+		stats.Gateways = append(stats.Gateways, gw)
+	}
+	return stats, nil
+}
+
+// netPath returns the first net directory with an ARP table
+func (c *NeighborCollector) netPath() (string, error) {
+	var err error
+	for _, path := range c.netPaths {
+		if _, err = os.Stat(filepath.Join(path, "arp")); err == nil {
+			return path, nil
+		}
+		c.Logger().V(1).Info("ARP table not available", "path", path, "error", err)
+	}
+	return "", fmt.Errorf("failed to find ARP table: %w", err)
+}
+
+// probeGateway makes the kernel resolve gw and waits until it is resolved or the probe
+// times out
+func (c *NeighborCollector) probeGateway(ctx context.Context, gw *performance.GatewayReachability, arpPath string) {
+	gw.Probed = true
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp4", net.JoinHostPort(gw.Address, strconv.Itoa(gatewayProbePort)))
+	if err != nil {
+		c.Logger().V(1).Info("Failed to probe gateway", "gateway", gw.Address, "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write(nil); err != nil {
+		c.Logger().V(1).Info("Failed to probe gateway", "gateway", gw.Address, "error", err)
+		return
+	}
+
+	timeout := time.NewTimer(gatewayProbeTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(gatewayProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		case <-ticker.C:
+			entries, err := readARPTable(arpPath)
+			if err != nil {
+				return
+			}
+			if entry, ok := findNeighbor(entries, *gw); ok && entry.complete() {
+				gw.HardwareAddress = entry.hwAddress
+				gw.Reachable = true
+				return
+			}
+		}
+	}
+}
+
+// arpEntry is a line of /proc/net/arp
+type arpEntry struct {
+	address   string
+	flags     uint64
+	hwAddress string
+	device    string
+}
+
+func (e arpEntry) complete() bool {
+	return e.flags&arpFlagComplete != 0
+}
+
+// readARPTable parses /proc/net/arp.
+//
+// Format:
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	10.0.0.1         0x1         0x2         52:54:00:12:34:56     *        eth0
+//	10.0.0.7         0x1         0x0         00:00:00:00:00:00     *        eth0
+func readARPTable(path string) ([]arpEntry, error) {
+	rows, err := procparse.ReadTable(path, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ARP table: %w", err)
+	}
+
+	var entries []arpEntry
+	for _, fields := range rows {
+		if len(fields) < 6 {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, arpEntry{
+			address:   fields[0],
+			flags:     flags,
+			hwAddress: fields[3],
+			device:    fields[5],
+		})
+	}
+	return entries, nil
+}
+
+func summarizeNeighbors(entries []arpEntry) *performance.NeighborStats {
+	stats := &performance.NeighborStats{
+		Interfaces: make(map[string]performance.NeighborInterfaceStats),
+	}
+	for _, entry := range entries {
+		iface := stats.Interfaces[entry.device]
+		stats.Entries++
+		iface.Entries++
+		switch {
+		case entry.flags&arpFlagPermanent != 0:
+			stats.Permanent++
+			iface.Permanent++
+		case entry.complete():
+			stats.Complete++
+			iface.Complete++
+		default:
+			stats.Unresolved++
+			iface.Unresolved++
+		}
+		stats.Interfaces[entry.device] = iface
+	}
+	return stats
+}
+
+func findNeighbor(entries []arpEntry, gw performance.GatewayReachability) (arpEntry, bool) {
+	for _, entry := range entries {
+		if entry.address == gw.Address && entry.device == gw.Interface {
+			return entry, true
+		}
+	}
+	return arpEntry{}, false
+}
+
+// readDefaultGateways returns the gateways of the default routes in /proc/net/route, ordered
+// by route metric.
+//
+// Format (addresses are hexadecimal in host byte order):
+//
+//	Iface  Destination  Gateway   Flags  RefCnt  Use  Metric  Mask      MTU  Window  IRTT
+//	eth0   00000000     0100000A  0003   0       0    100     00000000  0    0       0
+func readDefaultGateways(path string) ([]performance.GatewayReachability, error) {
+	rows, err := procparse.ReadTable(path, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing table: %w", err)
+	}
+
+	type route struct {
+		gateway performance.GatewayReachability
+		metric  uint64
+	}
+	var routes []route
+	for _, fields := range rows {
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&(routeFlagUp|routeFlagGateway) != routeFlagUp|routeFlagGateway {
+			continue
+		}
+		gateway, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		metric, _ := strconv.ParseUint(fields[6], 10, 64)
+		ip := binary.NativeEndian.AppendUint32(nil, uint32(gateway))
+		routes = append(routes, route{
+			gateway: performance.GatewayReachability{
+				Interface: fields[0],
+				Address:   net.IP(ip).String(),
+			},
+			metric: metric,
+		})
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].metric < routes[j].metric })
+	gateways := make([]performance.GatewayReachability, 0, len(routes))
+	for _, r := range routes {
+		gateways = append(gateways, r.gateway)
+	}
+	return gateways, nil
+}
@@ -0,0 +1,98 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testARPTable = `IP address       HW type     Flags       HW address            Mask     Device
+10.0.0.1         0x1         0x2         52:54:00:12:34:56     *        eth0
+10.0.0.7         0x1         0x0         00:00:00:00:00:00     *        eth0
+10.0.0.9         0x1         0x6         52:54:00:aa:bb:cc     *        eth0
+192.0.2.5        0x1         0x2         52:54:00:00:00:01     *        eth1
+`
+
+// Default routes via 10.0.0.1 on eth0 (metric 100) and 192.0.2.1 on eth1 (metric 50),
+// and a link route
+const testRouteTable = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0100000A	0003	0	0	100	00000000	0	0	0
+eth1	00000000	010200C0	0003	0	0	50	00000000	0	0	0
+eth0	0000000A	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`
+
+func collectNeighbors(t *testing.T, files map[string]string) *performance.NeighborStats {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, files)
+	collector, err := collectors.NewNeighborCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.NeighborStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestNeighborCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewNeighborCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewNeighborCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestNeighborCollector_Table(t *testing.T) {
+	stats := collectNeighbors(t, map[string]string{"1/net/arp": testARPTable})
+
+	assert.Equal(t, 4, stats.Entries)
+	assert.Equal(t, 2, stats.Complete)
+	assert.Equal(t, 1, stats.Permanent)
+	assert.Equal(t, 1, stats.Unresolved)
+	assert.Equal(t, map[string]performance.NeighborInterfaceStats{
+		"eth0": {Entries: 3, Complete: 1, Permanent: 1, Unresolved: 1},
+		"eth1": {Entries: 1, Complete: 1},
+	}, stats.Interfaces)
+	assert.Empty(t, stats.Gateways, "gateways are left out without a routing table")
+}
+
+func TestNeighborCollector_Gateways(t *testing.T) {
+	arp := testARPTable + "192.0.2.1        0x1         0x0         00:00:00:00:00:00     *        eth1\n"
+	stats := collectNeighbors(t, map[string]string{
+		"self/net/arp":   arp,
+		"self/net/route": testRouteTable,
+	})
+
+	require.Len(t, stats.Gateways, 2)
+	// The unresolved gateway is probed, but nothing updates the fixture
+	assert.Equal(t, performance.GatewayReachability{
+		Interface: "eth1",
+		Address:   "192.0.2.1",
+		Probed:    true,
+	}, stats.Gateways[0])
+	assert.Equal(t, performance.GatewayReachability{
+		Interface:       "eth0",
+		Address:         "10.0.0.1",
+		HardwareAddress: "52:54:00:12:34:56",
+		Reachable:       true,
+	}, stats.Gateways[1])
+}
+
+func TestNeighborCollector_NoARPTable(t *testing.T) {
+	procPath := t.TempDir()
+	collector, err := collectors.NewNeighborCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	_, err = collector.Collect(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,433 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*NetworkInfoCollector)(nil)
+
+// NetworkInfoCollector collects network interface configuration and the topology of
+// logical interfaces (bonds, VLANs and bridges) stacked on top of physical interfaces.
+//
+// Data sources:
+//   - /sys/class/net/[interface]/: per-interface configuration
+//   - /proc/net/bonding/[bond]: bonding mode, active slave and per-slave MII status
+//   - /proc/net/vlan/config: VLAN ID and parent interface
+//   - /sys/class/net/[bridge]/brif/: bridge membership
+//   - /proc/net/wireless: link quality, signal and noise levels of wireless interfaces
+//   - nl80211 (generic netlink): SSID, access point, frequency, signal and bitrates of
+//     associated wireless interfaces
+//
+// Only /sys/class/net is required. Bonding and VLAN proc files only exist when the
+// corresponding kernel modules are loaded, so their absence is not an error. nl80211 is
+// only queried when there are wireless interfaces and only sees the interfaces of the
+// agent's network namespace, so the agent needs host networking to report associations.
+//
+// Reference: https://www.kernel.org/doc/Documentation/networking/bonding.rst
+// Reference: https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-class-net
+type NetworkInfoCollector struct {
+	performance.BaseCollector
+	netClassPath   string
+	bondingPath    string
+	vlanConfigPath string
+	wirelessPath   string
+}
+
+func NewNetworkInfoCollector(logger logr.Logger, config performance.CollectionConfig) (*NetworkInfoCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	if _, err := os.Stat(config.HostSysPath); err != nil {
+		return nil, fmt.Errorf("HostSysPath validation failed: %w", err)
+	}
+
+	return &NetworkInfoCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeNetworkInfo,
+			"Network Info Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		netClassPath:   filepath.Join(config.HostSysPath, "class", "net"),
+		bondingPath:    filepath.Join(config.HostProcPath, "net", "bonding"),
+		vlanConfigPath: filepath.Join(config.HostProcPath, "net", "vlan", "config"),
+		wirelessPath:   filepath.Join(config.HostProcPath, "net", "wireless"),
+	}, nil
+}
+
+func (c *NetworkInfoCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectNetworkInfo(ctx)
+}
+
+func (c *NetworkInfoCollector) collectNetworkInfo(ctx context.Context) (*performance.NetworkInfo, error) {
+	entries, err := os.ReadDir(c.netClassPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.netClassPath, err)
+	}
+
+	vlans, err := c.parseVLANConfig()
+	if err != nil {
+		c.Logger().V(1).Info("Failed to read VLAN config (continuing without VLAN info)", "path", c.vlanConfigPath, "error", err)
+	}
+
+	wireless, err := c.parseWireless()
+	if err != nil {
+		c.Logger().V(1).Info("Failed to read wireless stats (continuing without them)", "path", c.wirelessPath, "error", err)
+	}
+
+	info := &performance.NetworkInfo{}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		iface := c.collectInterface(entry.Name())
+
+		if iface.Type == "bond" || exists(filepath.Join(c.netClassPath, iface.Name, "bonding")) {
+			iface.Type = "bond"
+			bond, err := c.parseBonding(iface.Name)
+			if err != nil {
+				c.Logger().V(1).Info("Failed to read bonding state", "interface", iface.Name, "error", err)
+			} else {
+				iface.Bond = bond
+				for _, slave := range bond.Slaves {
+					info.Links = append(info.Links, performance.NetworkInterfaceLink{
+						Lower: slave.Interface,
+						Upper: iface.Name,
+						Type:  performance.NetworkLinkBondSlave,
+					})
+				}
+			}
+		}
+
+		if vlan, ok := vlans[iface.Name]; ok {
+			iface.Type = "vlan"
+			iface.VLAN = &vlan
+			info.Links = append(info.Links, performance.NetworkInterfaceLink{
+				Lower: vlan.Parent,
+				Upper: iface.Name,
+				Type:  performance.NetworkLinkVLAN,
+			})
+		}
+
+		if ports, err := os.ReadDir(filepath.Join(c.netClassPath, iface.Name, "brif")); err == nil {
+			iface.Type = "bridge"
+			for _, port := range ports {
+				info.Links = append(info.Links, performance.NetworkInterfaceLink{
+					Lower: port.Name(),
+					Upper: iface.Name,
+					Type:  performance.NetworkLinkBridgePort,
+				})
+			}
+		}
+
+		if w, ok := wireless[iface.Name]; ok || iface.Type == "wlan" ||
+			exists(filepath.Join(c.netClassPath, iface.Name, "wireless")) ||
+			exists(filepath.Join(c.netClassPath, iface.Name, "phy80211")) {
+			iface.Type = "wlan"
+			iface.Wireless = &w
+		}
+
+		info.Interfaces = append(info.Interfaces, iface)
+	}
+
+	c.collectNL80211(info)
+
+	sort.Slice(info.Links, func(i, j int) bool {
+		if info.Links[i].Upper != info.Links[j].Upper {
+			return info.Links[i].Upper < info.Links[j].Upper
+		}
+		return info.Links[i].Lower < info.Links[j].Lower
+	})
+
+	return info, nil
+}
+
+// collectInterface reads the sysfs attributes of a single interface. All attributes are
+// optional since their availability depends on the driver and link state (e.g. speed
+// returns EINVAL when the link is down).
+func (c *NetworkInfoCollector) collectInterface(name string) performance.NetworkInterfaceInfo {
+	dir := filepath.Join(c.netClassPath, name)
+	iface := performance.NetworkInterfaceInfo{
+		Name:       name,
+		MACAddress: procparse.ReadStringFile(filepath.Join(dir, "address")),
+		Duplex:     procparse.ReadStringFile(filepath.Join(dir, "duplex")),
+		OperState:  procparse.ReadStringFile(filepath.Join(dir, "operstate")),
+		Type:       ueventValue(filepath.Join(dir, "uevent"), "DEVTYPE"),
+	}
+	iface.MTU, _ = procparse.ReadUintFile(filepath.Join(dir, "mtu"))
+	// speed is -1 for virtual interfaces and unknown link speeds
+	iface.Speed, _ = procparse.ReadUintFile(filepath.Join(dir, "speed"))
+
+	if driver, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil {
+		iface.Driver = filepath.Base(driver)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "device")); err != nil {
+		iface.Virtual = true
+	}
+	if master, err := os.Readlink(filepath.Join(dir, "master")); err == nil {
+		iface.Master = filepath.Base(master)
+	}
+
+	return iface
+}
+
+// parseBonding parses /proc/net/bonding/[bond].
+//
+// Format:
+//
+//	Bonding Mode: fault-tolerance (active-backup)
+//	Currently Active Slave: eth0
+//	MII Status: up
+//
+//	Slave Interface: eth0
+//	MII Status: up
+//	Speed: 1000 Mbps
+//	...
+//
+// Keys before the first "Slave Interface" line describe the bond itself, keys after it
+// describe the most recent slave.
+func (c *NetworkInfoCollector) parseBonding(bond string) (*performance.BondInfo, error) {
+	file, err := os.Open(filepath.Join(c.bondingPath, bond))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info := &performance.BondInfo{}
+	var slave *performance.BondSlaveInfo
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "Slave Interface" {
+			info.Slaves = append(info.Slaves, performance.BondSlaveInfo{Interface: value})
+			slave = &info.Slaves[len(info.Slaves)-1]
+			continue
+		}
+
+		if slave == nil {
+			switch key {
+			case "Bonding Mode":
+				info.Mode = value
+			case "Currently Active Slave":
+				info.ActiveSlave = value
+			case "MII Status":
+				info.MIIStatus = value
+			}
+			continue
+		}
+
+		switch key {
+		case "MII Status":
+			slave.MIIStatus = value
+		case "Speed":
+			slave.Speed = value
+		case "Duplex":
+			slave.Duplex = value
+		case "Link Failure Count":
+			if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+				slave.LinkFailureCount = v
+			}
+		case "Permanent HW addr":
+			// The MAC address contains colons, so re-join everything after the key
+			slave.PermanentHWAddr = strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "Permanent HW addr:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// parseVLANConfig parses /proc/net/vlan/config into a map keyed by VLAN interface name.
+//
+// Format:
+//
+//	VLAN Dev name	 | VLAN ID
+//	Name-Type: VLAN_NAME_TYPE_RAW_PLUS_VID_NO_PAD
+//	eth0.100       | 100  | eth0
+func (c *NetworkInfoCollector) parseVLANConfig() (map[string]performance.VLANInfo, error) {
+	file, err := os.Open(c.vlanConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	vlans := make(map[string]performance.VLANInfo)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 16)
+		if err != nil {
+			continue
+		}
+		vlans[strings.TrimSpace(fields[0])] = performance.VLANInfo{
+			ID:     uint16(id),
+			Parent: strings.TrimSpace(fields[2]),
+		}
+	}
+	return vlans, scanner.Err()
+}
+
+// parseWireless parses /proc/net/wireless into a map keyed by interface name.
+//
+// Format:
+//
+//	Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+//	 face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+//	wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0
+//
+// Quality values carry a trailing '.' when they were updated since the last read. Older
+// drivers report levels as unsigned 8 bit dBm values, and cfg80211 reports a noise of -256
+// when the driver doesn't measure it.
+func (c *NetworkInfoCollector) parseWireless() (map[string]performance.WirelessInfo, error) {
+	file, err := os.Open(c.wirelessPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	wireless := make(map[string]performance.WirelessInfo)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(values)
+		if len(fields) < 10 {
+			continue
+		}
+		quality := func(i int) int64 {
+			v, _ := strconv.ParseFloat(strings.TrimSuffix(fields[i], "."), 64)
+			return int64(v)
+		}
+		counter := func(i int) uint64 {
+			v, _ := strconv.ParseUint(fields[i], 10, 64)
+			return v
+		}
+		level := quality(2)
+		if level > 0 {
+			level -= 256
+		}
+		noise := quality(3)
+		if noise > 0 {
+			noise -= 256
+		}
+		if noise <= -256 {
+			noise = 0
+		}
+		wireless[strings.TrimSpace(name)] = performance.WirelessInfo{
+			LinkQuality:    uint32(max(quality(1), 0)),
+			SignalDBm:      int32(level),
+			NoiseDBm:       int32(noise),
+			DiscardedNwid:  counter(4),
+			DiscardedCrypt: counter(5),
+			DiscardedFrag:  counter(6),
+			DiscardedRetry: counter(7),
+			DiscardedMisc:  counter(8),
+			MissedBeacons:  counter(9),
+		}
+	}
+	return wireless, scanner.Err()
+}
+
+// collectNL80211 adds the association state reported by nl80211 to the wireless
+// interfaces of info. The signal from nl80211 is the one of the access point's last
+// received frames and takes precedence over /proc/net/wireless.
+func (c *NetworkInfoCollector) collectNL80211(info *performance.NetworkInfo) {
+	hasWireless := false
+	for _, iface := range info.Interfaces {
+		hasWireless = hasWireless || iface.Wireless != nil
+	}
+	if !hasWireless {
+		return
+	}
+
+	ifaces, err := queryNL80211()
+	if err != nil {
+		c.Logger().V(1).Info("Failed to query nl80211 (continuing without it)", "error", err)
+		return
+	}
+	for i := range info.Interfaces {
+		wireless := info.Interfaces[i].Wireless
+		nl, ok := ifaces[info.Interfaces[i].Name]
+		if wireless == nil || !ok {
+			continue
+		}
+		wireless.SSID = nl.ssid
+		wireless.BSSID = nl.bssid
+		wireless.FrequencyMHz = nl.frequency
+		wireless.TxBitrateMbps = nl.txBitrate
+		wireless.RxBitrateMbps = nl.rxBitrate
+		if nl.hasSignal {
+			wireless.SignalDBm = nl.signal
+		}
+	}
+}
+
+// ueventValue returns the value of key in a sysfs uevent file (KEY=value lines)
+func ueventValue(path, key string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok && k == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
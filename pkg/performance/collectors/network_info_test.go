@@ -0,0 +1,322 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bondingActiveBackup = `Ethernet Channel Bonding Driver: v5.15.0
+
+Bonding Mode: fault-tolerance (active-backup)
+Primary Slave: None
+Currently Active Slave: eth0
+MII Status: up
+MII Polling Interval (ms): 100
+Up Delay (ms): 0
+Down Delay (ms): 0
+
+Slave Interface: eth0
+MII Status: up
+Speed: 10000 Mbps
+Duplex: full
+Link Failure Count: 0
+Permanent HW addr: 52:54:00:12:34:56
+Slave queue ID: 0
+
+Slave Interface: eth1
+MII Status: down
+Speed: Unknown
+Duplex: Unknown
+Link Failure Count: 3
+Permanent HW addr: 52:54:00:12:34:57
+Slave queue ID: 0
+`
+
+const vlanConfig = `VLAN Dev name	 | VLAN ID
+Name-Type: VLAN_NAME_TYPE_RAW_PLUS_VID_NO_PAD
+bond0.100      | 100  | bond0
+`
+
+const procNetWireless = `Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+ face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+wlan9: 0000   54.  -56.  -256        0      3      0     12      7        2
+wlan8: 0000   40    200    161        0      0      0      0      0        0
+`
+
+type netInfoFixture struct {
+	procPath string
+	sysPath  string
+}
+
+func newNetInfoFixture(t *testing.T) *netInfoFixture {
+	root := t.TempDir()
+	f := &netInfoFixture{
+		procPath: filepath.Join(root, "proc"),
+		sysPath:  filepath.Join(root, "sys"),
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(f.sysPath, "class", "net"), 0755))
+	require.NoError(t, os.MkdirAll(f.procPath, 0755))
+	return f
+}
+
+// symlink creates a symlink at path (relative to root) pointing to target
+func symlink(t *testing.T, root, path, target string) {
+	fullPath := filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+	require.NoError(t, os.Symlink(target, fullPath))
+}
+
+func (f *netInfoFixture) collect(t *testing.T) *performance.NetworkInfo {
+	config := performance.CollectionConfig{
+		HostProcPath: f.procPath,
+		HostSysPath:  f.sysPath,
+	}
+	collector, err := collectors.NewNetworkInfoCollector(logr.Discard(), config)
+	require.NoError(t, err)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	info, ok := result.(*performance.NetworkInfo)
+	require.True(t, ok)
+	return info
+}
+
+func interfacesByName(info *performance.NetworkInfo) map[string]performance.NetworkInterfaceInfo {
+	m := make(map[string]performance.NetworkInterfaceInfo)
+	for _, iface := range info.Interfaces {
+		m[iface.Name] = iface
+	}
+	return m
+}
+
+func TestNetworkInfoCollector_Constructor(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  performance.CollectionConfig
+		wantErr string
+	}{
+		{
+			name:    "relative proc path",
+			config:  performance.CollectionConfig{HostProcPath: "proc", HostSysPath: "/sys"},
+			wantErr: "HostProcPath must be an absolute path",
+		},
+		{
+			name:    "relative sys path",
+			config:  performance.CollectionConfig{HostProcPath: "/proc", HostSysPath: "sys"},
+			wantErr: "HostSysPath must be an absolute path",
+		},
+		{
+			name:    "non-existent sys path",
+			config:  performance.CollectionConfig{HostProcPath: "/proc", HostSysPath: "/non/existent/path/that/should/not/exist"},
+			wantErr: "HostSysPath validation failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := collectors.NewNetworkInfoCollector(logr.Discard(), tt.config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestNetworkInfoCollector_PhysicalInterface(t *testing.T) {
+	f := newNetInfoFixture(t)
+	writeSysFiles(t, f.sysPath, map[string]string{
+		"class/net/eth0/address":   "52:54:00:12:34:56\n",
+		"class/net/eth0/mtu":       "9000\n",
+		"class/net/eth0/speed":     "10000\n",
+		"class/net/eth0/duplex":    "full\n",
+		"class/net/eth0/operstate": "up\n",
+		"class/net/lo/address":     "00:00:00:00:00:00\n",
+		"class/net/lo/mtu":         "65536\n",
+		"class/net/lo/speed":       "-1\n",
+		"class/net/lo/operstate":   "unknown\n",
+		// device is a symlink into /sys/devices on a real system; a directory is enough here
+		"class/net/eth0/device/vendor": "0x8086\n",
+	})
+	symlink(t, f.sysPath, "class/net/eth0/device/driver", "../../../../bus/pci/drivers/ixgbe")
+
+	info := f.collect(t)
+	ifaces := interfacesByName(info)
+	require.Len(t, ifaces, 2)
+
+	assert.Equal(t, performance.NetworkInterfaceInfo{
+		Name:       "eth0",
+		MACAddress: "52:54:00:12:34:56",
+		MTU:        9000,
+		Speed:      10000,
+		Duplex:     "full",
+		OperState:  "up",
+		Driver:     "ixgbe",
+	}, ifaces["eth0"])
+
+	// speed of -1 degrades to zero and interfaces without a device are virtual
+	lo := ifaces["lo"]
+	assert.Equal(t, uint64(0), lo.Speed)
+	assert.True(t, lo.Virtual)
+	assert.Empty(t, lo.Driver)
+	assert.Empty(t, info.Links)
+}
+
+func TestNetworkInfoCollector_BondAndVLAN(t *testing.T) {
+	f := newNetInfoFixture(t)
+	writeSysFiles(t, f.sysPath, map[string]string{
+		"class/net/eth0/operstate":         "up\n",
+		"class/net/eth1/operstate":         "down\n",
+		"class/net/bond0/operstate":        "up\n",
+		"class/net/bond0/uevent":           "DEVTYPE=bond\nINTERFACE=bond0\nIFINDEX=4\n",
+		"class/net/bond0/bonding/mode":     "active-backup 1\n",
+		"class/net/bond0.100/operstate":    "up\n",
+		"class/net/bond0.100/uevent":       "DEVTYPE=vlan\nINTERFACE=bond0.100\n",
+		"class/net/br0/operstate":          "up\n",
+		"class/net/br0/uevent":             "DEVTYPE=bridge\n",
+		"class/net/br0/brif/veth1/port_no": "0x1\n",
+		"class/net/br0/brif/veth2/port_no": "0x2\n",
+		"class/net/veth1/operstate":        "up\n",
+		"class/net/veth2/operstate":        "up\n",
+	})
+	writeSysFiles(t, f.procPath, map[string]string{
+		"net/bonding/bond0": bondingActiveBackup,
+		"net/vlan/config":   vlanConfig,
+	})
+	symlink(t, f.sysPath, "class/net/eth0/master", "../bond0")
+	symlink(t, f.sysPath, "class/net/eth1/master", "../bond0")
+	symlink(t, f.sysPath, "class/net/veth1/master", "../br0")
+	symlink(t, f.sysPath, "class/net/veth2/master", "../br0")
+
+	info := f.collect(t)
+	ifaces := interfacesByName(info)
+
+	bond := ifaces["bond0"]
+	assert.Equal(t, "bond", bond.Type)
+	require.NotNil(t, bond.Bond)
+	assert.Equal(t, "fault-tolerance (active-backup)", bond.Bond.Mode)
+	assert.Equal(t, "eth0", bond.Bond.ActiveSlave)
+	assert.Equal(t, "up", bond.Bond.MIIStatus)
+	assert.Equal(t, []performance.BondSlaveInfo{
+		{
+			Interface:       "eth0",
+			MIIStatus:       "up",
+			Speed:           "10000 Mbps",
+			Duplex:          "full",
+			PermanentHWAddr: "52:54:00:12:34:56",
+		},
+		{
+			Interface:        "eth1",
+			MIIStatus:        "down",
+			Speed:            "Unknown",
+			Duplex:           "Unknown",
+			LinkFailureCount: 3,
+			PermanentHWAddr:  "52:54:00:12:34:57",
+		},
+	}, bond.Bond.Slaves)
+
+	assert.Equal(t, "bond0", ifaces["eth0"].Master)
+	assert.Equal(t, "bond0", ifaces["eth1"].Master)
+	assert.Equal(t, "br0", ifaces["veth1"].Master)
+
+	vlan := ifaces["bond0.100"]
+	assert.Equal(t, "vlan", vlan.Type)
+	require.NotNil(t, vlan.VLAN)
+	assert.Equal(t, performance.VLANInfo{ID: 100, Parent: "bond0"}, *vlan.VLAN)
+
+	assert.Equal(t, "bridge", ifaces["br0"].Type)
+
+	assert.Equal(t, []performance.NetworkInterfaceLink{
+		{Lower: "eth0", Upper: "bond0", Type: performance.NetworkLinkBondSlave},
+		{Lower: "eth1", Upper: "bond0", Type: performance.NetworkLinkBondSlave},
+		{Lower: "bond0", Upper: "bond0.100", Type: performance.NetworkLinkVLAN},
+		{Lower: "veth1", Upper: "br0", Type: performance.NetworkLinkBridgePort},
+		{Lower: "veth2", Upper: "br0", Type: performance.NetworkLinkBridgePort},
+	}, info.Links)
+}
+
+func TestNetworkInfoCollector_Wireless(t *testing.T) {
+	f := newNetInfoFixture(t)
+	writeSysFiles(t, f.sysPath, map[string]string{
+		"class/net/eth0/operstate":      "up\n",
+		"class/net/wlan9/operstate":     "up\n",
+		"class/net/wlan9/uevent":        "DEVTYPE=wlan\nINTERFACE=wlan9\nIFINDEX=3\n",
+		"class/net/wlan8/operstate":     "up\n",
+		"class/net/wlan7/operstate":     "down\n",
+		"class/net/wlan7/wireless/.tmp": "",
+	})
+	writeSysFiles(t, f.procPath, map[string]string{
+		"net/wireless": procNetWireless,
+	})
+
+	ifaces := interfacesByName(f.collect(t))
+	require.Len(t, ifaces, 4)
+	assert.Nil(t, ifaces["eth0"].Wireless)
+
+	wlan9 := ifaces["wlan9"]
+	assert.Equal(t, "wlan", wlan9.Type)
+	require.NotNil(t, wlan9.Wireless)
+	assert.Equal(t, uint32(54), wlan9.Wireless.LinkQuality)
+	assert.Equal(t, int32(-56), wlan9.Wireless.SignalDBm)
+	// -256 means the driver doesn't report noise
+	assert.Equal(t, int32(0), wlan9.Wireless.NoiseDBm)
+	assert.Equal(t, uint64(3), wlan9.Wireless.DiscardedCrypt)
+	assert.Equal(t, uint64(12), wlan9.Wireless.DiscardedRetry)
+	assert.Equal(t, uint64(7), wlan9.Wireless.DiscardedMisc)
+	assert.Equal(t, uint64(2), wlan9.Wireless.MissedBeacons)
+
+	// Levels reported as unsigned 8 bit values
+	wlan8 := ifaces["wlan8"]
+	assert.Equal(t, "wlan", wlan8.Type)
+	require.NotNil(t, wlan8.Wireless)
+	assert.Equal(t, int32(-56), wlan8.Wireless.SignalDBm)
+	assert.Equal(t, int32(-95), wlan8.Wireless.NoiseDBm)
+
+	// Wireless interfaces that aren't up have no /proc/net/wireless entry
+	wlan7 := ifaces["wlan7"]
+	assert.Equal(t, "wlan", wlan7.Type)
+	require.NotNil(t, wlan7.Wireless)
+	assert.Zero(t, wlan7.Wireless.LinkQuality)
+}
+
+func TestNetworkInfoCollector_MissingBondingProcFile(t *testing.T) {
+	// The bond exists in sysfs but /proc/net/bonding is unavailable (e.g. a restricted
+	// procfs mount). The interface is still reported, only without bond details.
+	f := newNetInfoFixture(t)
+	writeSysFiles(t, f.sysPath, map[string]string{
+		"class/net/bond0/bonding/mode": "802.3ad 4\n",
+	})
+
+	info := f.collect(t)
+	require.Len(t, info.Interfaces, 1)
+	assert.Equal(t, "bond", info.Interfaces[0].Type)
+	assert.Nil(t, info.Interfaces[0].Bond)
+	assert.Empty(t, info.Links)
+}
+
+func TestNetworkInfoCollector_Cancelled(t *testing.T) {
+	f := newNetInfoFixture(t)
+	writeSysFiles(t, f.sysPath, map[string]string{"class/net/eth0/operstate": "up\n"})
+	collector, err := collectors.NewNetworkInfoCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: f.procPath,
+		HostSysPath:  f.sysPath,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
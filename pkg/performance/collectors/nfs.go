@@ -0,0 +1,295 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.StatefulCollector = (*NFSCollector)(nil)
+
+// NFSCollector collects per-mount RPC statistics of NFS mounts, so that a slow NFS server
+// can be told apart from a slow local disk.
+//
+// Data sources:
+//   - /proc/1/mountstats: per-operation RPC counters and cumulative queue, round trip and
+//     execution times of every NFS mount, as seen from the host's mount namespace
+//   - /proc/self/mountstats: fallback when PID 1 isn't visible, e.g. without host PID
+//     namespace access. It only lists the mounts of the agent's own mount namespace.
+//
+// The counters are cumulative since the mount, so average latencies are computed from the
+// difference between consecutive collections. The first collection reports the averages
+// since the mount.
+//
+// Reference: https://utcc.utoronto.ca/~cks/space/blog/linux/NFSMountstatsNFSOps
+// Reference: https://git.kernel.org/pub/scm/linux/kernel/git/torvalds/linux.git/tree/net/sunrpc/stats.c
+type NFSCollector struct {
+	performance.BaseCollector
+	mountstatsPaths []string
+
+	mu sync.Mutex
+	// Operation counters of the previous collection keyed by mount point
+	prevOps  map[string]map[string]performance.NFSOperationStats
+	prevTime time.Time
+}
+
+func NewNFSCollector(logger logr.Logger, config performance.CollectionConfig) (*NFSCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.17", // /proc/[pid]/mountstats
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &NFSCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeNFS,
+			"NFS Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		mountstatsPaths: []string{
+			filepath.Join(config.HostProcPath, "1", "mountstats"),
+			filepath.Join(config.HostProcPath, "self", "mountstats"),
+		},
+	}, nil
+}
+
+func (c *NFSCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectNFS(ctx, time.Now())
+}
+
+func (c *NFSCollector) collectNFS(ctx context.Context, now time.Time) (*performance.NFSStats, error) {
+	mounts, err := c.readMountstats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prevOps := c.prevOps
+	window := now.Sub(c.prevTime)
+	c.prevOps = make(map[string]map[string]performance.NFSOperationStats, len(mounts))
+	c.prevTime = now
+
+	for i := range mounts {
+		mount := &mounts[i]
+		ops := make(map[string]performance.NFSOperationStats, len(mount.Operations))
+		for _, op := range mount.Operations {
+			ops[op.Operation] = op
+		}
+		c.prevOps[mount.MountPoint] = ops
+
+		// Without a previous collection, or if the mount was remounted since, the
+		// difference to zero is the average since the mount
+		prev, ok := prevOps[mount.MountPoint]
+		mount.Window = window
+		if !ok || c.remounted(mount, prev) {
+			prev = nil
+			mount.Window = mount.Age
+		}
+		for j := range mount.Operations {
+			setNFSAverages(&mount.Operations[j], prev[mount.Operations[j].Operation], mount.Window)
+		}
+	}
+
+	return &performance.NFSStats{Mounts: mounts}, nil
+}
+
+// nfsState is the persisted state of the NFSCollector
+type nfsState struct {
+	Time time.Time                                           `json:"time"`
+	Ops  map[string]map[string]performance.NFSOperationStats `json:"ops"`
+}
+
+func (c *NFSCollector) SaveState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prevTime.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(nfsState{Time: c.prevTime, Ops: c.prevOps})
+}
+
+func (c *NFSCollector) RestoreState(data []byte) error {
+	var state nfsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prevTime = state.Time
+	c.prevOps = state.Ops
+	return nil
+}
+
+// remounted reports whether the counters of mount went backwards, i.e. it was unmounted
+// and mounted again since the previous collection
+func (c *NFSCollector) remounted(mount *performance.NFSMountStats, prev map[string]performance.NFSOperationStats) bool {
+	for _, op := range mount.Operations {
+		if op.Ops < prev[op.Operation].Ops {
+			return true
+		}
+	}
+	return false
+}
+
+// setNFSAverages sets the averages of op over window from its difference to prev
+func setNFSAverages(op *performance.NFSOperationStats, prev performance.NFSOperationStats, window time.Duration) {
+	ops := op.Ops - prev.Ops
+	if ops == 0 {
+		return
+	}
+	if window > 0 {
+		op.OpsPerSecond = float64(ops) / window.Seconds()
+	}
+	op.AvgQueueTime = (op.QueueTime - prev.QueueTime) / time.Duration(ops)
+	op.AvgRTT = (op.RTT - prev.RTT) / time.Duration(ops)
+	op.AvgExecuteTime = (op.ExecuteTime - prev.ExecuteTime) / time.Duration(ops)
+}
+
+// readMountstats reads the NFS mounts from the first readable mountstats file
+func (c *NFSCollector) readMountstats(ctx context.Context) ([]performance.NFSMountStats, error) {
+	var err error
+	for _, path := range c.mountstatsPaths {
+		var file *os.File
+		file, err = os.Open(path)
+		if err != nil {
+			c.Logger().V(1).Info("Failed to open mountstats", "path", path, "error", err)
+			continue
+		}
+		defer file.Close()
+		return parseMountstats(ctx, bufio.NewScanner(file))
+	}
+	return nil, fmt.Errorf("failed to read mountstats: %w", err)
+}
+
+// parseMountstats parses the NFS mounts in a mountstats file.
+//
+// Format (per-op lines are indented and most lines are elided):
+//
+//	device proc mounted on /proc with fstype proc
+//	device 10.0.0.1:/export mounted on /mnt/data with fstype nfs4 statvers=1.1
+//		opts:	rw,vers=4.1,rsize=1048576,wsize=1048576,proto=tcp,...
+//		age:	86400
+//		bytes:	1024 2048 0 0 4096 8192 2 2
+//		per-op statistics
+//		        NULL: 0 0 0 0 0 0 0 0
+//		        READ: 10 10 0 1600 40960 2 35 38 0
+//
+// The per-op fields are operations, transmissions, major timeouts, bytes sent, bytes
+// received, and the cumulative queue, round trip and execution times in milliseconds,
+// followed by the number of errors on kernels 5.3 and later.
+func parseMountstats(ctx context.Context, scanner *bufio.Scanner) ([]performance.NFSMountStats, error) {
+	var mounts []performance.NFSMountStats
+	var mount *performance.NFSMountStats
+	perOp := false
+
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "device" {
+			mount, perOp = nil, false
+			// device <dev> mounted on <dir> with fstype <type> [statvers=<v>]
+			if len(fields) >= 8 && fields[2] == "mounted" && fields[3] == "on" &&
+				fields[5] == "with" && fields[6] == "fstype" && (fields[7] == "nfs" || fields[7] == "nfs4") {
+				mounts = append(mounts, performance.NFSMountStats{
+					Device:     fields[1],
+					MountPoint: fields[4],
+					FSType:     fields[7],
+				})
+				mount = &mounts[len(mounts)-1]
+			}
+			continue
+		}
+		if mount == nil {
+			continue
+		}
+
+		switch {
+		case fields[0] == "opts:" && len(fields) >= 2:
+			for _, opt := range strings.Split(fields[1], ",") {
+				if v, ok := strings.CutPrefix(opt, "vers="); ok {
+					mount.Version = v
+				}
+			}
+		case fields[0] == "age:" && len(fields) >= 2:
+			if age, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				mount.Age = time.Duration(age) * time.Second
+			}
+		case fields[0] == "bytes:" && len(fields) >= 7:
+			mount.ServerReadBytes, _ = strconv.ParseUint(fields[5], 10, 64)
+			mount.ServerWriteBytes, _ = strconv.ParseUint(fields[6], 10, 64)
+		case len(fields) == 2 && fields[0] == "per-op" && fields[1] == "statistics":
+			perOp = true
+		case perOp && strings.HasSuffix(fields[0], ":") && len(fields) >= 9:
+			op, ok := parseNFSOperation(fields)
+			if ok && op.Ops > 0 {
+				mount.Operations = append(mount.Operations, op)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// parseNFSOperation parses a per-op statistics line split into fields
+func parseNFSOperation(fields []string) (performance.NFSOperationStats, bool) {
+	var values [9]uint64
+	n := min(len(fields)-1, len(values))
+	for i := 0; i < n; i++ {
+		v, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			return performance.NFSOperationStats{}, false
+		}
+		values[i] = v
+	}
+	return performance.NFSOperationStats{
+		Operation:     strings.TrimSuffix(fields[0], ":"),
+		Ops:           values[0],
+		Transmissions: values[1],
+		MajorTimeouts: values[2],
+		BytesSent:     values[3],
+		BytesReceived: values[4],
+		QueueTime:     time.Duration(values[5]) * time.Millisecond,
+		RTT:           time.Duration(values[6]) * time.Millisecond,
+		ExecuteTime:   time.Duration(values[7]) * time.Millisecond,
+		Errors:        values[8],
+	}, true
+}
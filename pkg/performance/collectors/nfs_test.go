@@ -0,0 +1,191 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mountstats returns a mountstats file with a local mount, the nfsd filesystem and an NFS
+// mount whose READ operation has the given counters
+func mountstats(readOps, readRTT, readExec uint64) string {
+	return fmt.Sprintf(`device rootfs mounted on / with fstype rootfs
+device proc mounted on /proc with fstype proc
+device nfsd mounted on /proc/fs/nfsd with fstype nfsd
+device 10.0.0.1:/export mounted on /mnt/data with fstype nfs4 statvers=1.1
+	opts:	rw,vers=4.1,rsize=1048576,wsize=1048576,namlen=255,acregmin=3,proto=tcp,timeo=600,retrans=2,sec=sys
+	age:	3600
+	impl_id:	name='',domain='',date='0,0'
+	caps:	caps=0x3ffbffff,wtmult=512,dtsize=32768,bsize=0,namlen=255
+	sec:	flavor=1,pseudoflavor=1
+	events:	0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+	bytes:	1024 2048 0 0 40960 8192 10 2
+	RPC iostats version: 1.1  p/v: 100003/4 (nfs)
+	xprt:	tcp 0 0 1 0 12 100 100 0 100 0 2 0 0
+	per-op statistics
+	        NULL: 1 1 0 44 24 0 0 0 0
+	        READ: %d %d 1 1600 40960 12 %d %d 0
+	       WRITE: 2 2 0 8400 160 0 5 6 1
+	      COMMIT: 0 0 0 0 0 0 0 0 0
+
+device 10.0.0.2:/home mounted on /home with fstype nfs statvers=1.1
+	opts:	rw,vers=3,rsize=65536,wsize=65536,proto=tcp
+	age:	60
+	bytes:	0 0 0 0 0 0 0 0
+	per-op statistics
+	        NULL: 0 0 0 0 0 0 0 0
+	     GETATTR: 4 4 0 400 448 0 8 8
+`, readOps, readOps+1, readRTT, readExec)
+}
+
+func createNFSCollector(t *testing.T, files map[string]string) (*collectors.NFSCollector, string) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, files)
+	collector, err := collectors.NewNFSCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	return collector, procPath
+}
+
+func collectNFS(t *testing.T, collector *collectors.NFSCollector) *performance.NFSStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.NFSStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestNFSCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewNFSCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "proc"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewNFSCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "HostProcPath validation failed")
+}
+
+func TestNFSCollector_Mounts(t *testing.T) {
+	collector, _ := createNFSCollector(t, map[string]string{
+		"1/mountstats": mountstats(10, 35, 38),
+	})
+	stats := collectNFS(t, collector)
+	require.Len(t, stats.Mounts, 2)
+
+	data := stats.Mounts[0]
+	assert.Equal(t, "10.0.0.1:/export", data.Device)
+	assert.Equal(t, "/mnt/data", data.MountPoint)
+	assert.Equal(t, "nfs4", data.FSType)
+	assert.Equal(t, "4.1", data.Version)
+	assert.Equal(t, time.Hour, data.Age)
+	assert.Equal(t, uint64(40960), data.ServerReadBytes)
+	assert.Equal(t, uint64(8192), data.ServerWriteBytes)
+	// The first collection averages over the age of the mount
+	assert.Equal(t, time.Hour, data.Window)
+
+	// Operations that were never called are left out
+	require.Len(t, data.Operations, 3)
+	assert.Equal(t, "NULL", data.Operations[0].Operation)
+	read := data.Operations[1]
+	assert.Equal(t, performance.NFSOperationStats{
+		Operation:      "READ",
+		Ops:            10,
+		Transmissions:  11,
+		MajorTimeouts:  1,
+		BytesSent:      1600,
+		BytesReceived:  40960,
+		QueueTime:      12 * time.Millisecond,
+		RTT:            35 * time.Millisecond,
+		ExecuteTime:    38 * time.Millisecond,
+		OpsPerSecond:   10.0 / 3600,
+		AvgQueueTime:   1200 * time.Microsecond,
+		AvgRTT:         3500 * time.Microsecond,
+		AvgExecuteTime: 3800 * time.Microsecond,
+	}, read)
+	assert.Equal(t, uint64(1), data.Operations[2].Errors)
+
+	// Kernels before 5.3 don't report errors
+	home := stats.Mounts[1]
+	assert.Equal(t, "3", home.Version)
+	require.Len(t, home.Operations, 1)
+	assert.Equal(t, "GETATTR", home.Operations[0].Operation)
+	assert.Equal(t, 2*time.Millisecond, home.Operations[0].AvgRTT)
+	assert.Zero(t, home.Operations[0].Errors)
+}
+
+func TestNFSCollector_IntervalAverages(t *testing.T) {
+	collector, procPath := createNFSCollector(t, map[string]string{
+		"1/mountstats": mountstats(10, 35, 38),
+	})
+	collectNFS(t, collector)
+
+	// 5 more reads that took 100ms each on the wire
+	writeSysFiles(t, procPath, map[string]string{
+		"1/mountstats": mountstats(15, 535, 548),
+	})
+	stats := collectNFS(t, collector)
+	data := stats.Mounts[0]
+	assert.Less(t, data.Window, time.Minute)
+	read := data.Operations[1]
+	assert.Equal(t, uint64(15), read.Ops)
+	assert.Equal(t, 100*time.Millisecond, read.AvgRTT)
+	assert.Equal(t, 102*time.Millisecond, read.AvgExecuteTime)
+	assert.Zero(t, read.AvgQueueTime)
+	assert.Greater(t, read.OpsPerSecond, 0.0)
+
+	// Operations that weren't called during the window have no averages
+	assert.Zero(t, data.Operations[2].AvgRTT)
+
+	// Counters going backwards mean the export was mounted again
+	writeSysFiles(t, procPath, map[string]string{
+		"1/mountstats": mountstats(3, 30, 30),
+	})
+	stats = collectNFS(t, collector)
+	assert.Equal(t, time.Hour, stats.Mounts[0].Window)
+	assert.Equal(t, 10*time.Millisecond, stats.Mounts[0].Operations[1].AvgRTT)
+}
+
+func TestNFSCollector_SelfFallback(t *testing.T) {
+	collector, _ := createNFSCollector(t, map[string]string{
+		"self/mountstats": mountstats(10, 35, 38),
+	})
+	stats := collectNFS(t, collector)
+	assert.Len(t, stats.Mounts, 2)
+}
+
+func TestNFSCollector_NoNFSMounts(t *testing.T) {
+	collector, _ := createNFSCollector(t, map[string]string{
+		"1/mountstats": "device proc mounted on /proc with fstype proc\n",
+	})
+	stats := collectNFS(t, collector)
+	assert.Empty(t, stats.Mounts)
+}
+
+func TestNFSCollector_MissingMountstats(t *testing.T) {
+	collector, _ := createNFSCollector(t, nil)
+	_, err := collector.Collect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNFSCollector_Cancelled(t *testing.T) {
+	collector, _ := createNFSCollector(t, map[string]string{
+		"1/mountstats": mountstats(1, 1, 1),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,295 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// nl80211Timeout bounds each receive from the netlink socket
+const nl80211Timeout = 2 * time.Second
+
+// nl80211Interface is the state of a wireless interface reported by nl80211
+type nl80211Interface struct {
+	ifindex   uint32
+	ssid      string
+	frequency uint32
+	// Station info of the access point the interface is associated with
+	bssid     string
+	signal    int32
+	hasSignal bool
+	txBitrate float64 // Mbps
+	rxBitrate float64 // Mbps
+}
+
+// queryNL80211 returns the nl80211 state of the wireless interfaces of the agent's network
+// namespace keyed by interface name. It speaks generic netlink directly: it resolves the
+// nl80211 family, dumps the interfaces (NL80211_CMD_GET_INTERFACE) and for each of them
+// the stations it is associated with (NL80211_CMD_GET_STATION), which for a client is the
+// access point.
+//
+// Reference: https://git.kernel.org/pub/scm/linux/kernel/git/netdev/net-next.git/tree/include/uapi/linux/nl80211.h
+func queryNL80211() (map[string]nl80211Interface, error) {
+	conn, err := dialGenetlink()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	family, err := conn.resolveFamily("nl80211")
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := conn.request(family, unix.NLM_F_REQUEST|unix.NLM_F_DUMP, unix.NL80211_CMD_GET_INTERFACE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump nl80211 interfaces: %w", err)
+	}
+	ifaces := parseNL80211Interfaces(msgs)
+
+	for name, iface := range ifaces {
+		msgs, err := conn.request(family, unix.NLM_F_REQUEST|unix.NLM_F_DUMP, unix.NL80211_CMD_GET_STATION,
+			netlinkAttr(unix.NL80211_ATTR_IFINDEX, binary.NativeEndian.AppendUint32(nil, iface.ifindex)))
+		if err != nil {
+			// Interfaces in monitor or AP mode may not support the station dump
+			continue
+		}
+		parseNL80211Station(msgs, &iface)
+		ifaces[name] = iface
+	}
+	return ifaces, nil
+}
+
+// parseNL80211Interfaces parses the attributes of NL80211_CMD_GET_INTERFACE replies
+func parseNL80211Interfaces(msgs [][]byte) map[string]nl80211Interface {
+	ifaces := make(map[string]nl80211Interface)
+	for _, msg := range msgs {
+		attrs := parseNetlinkAttrs(msg)
+		name := strings.TrimRight(string(attrs[unix.NL80211_ATTR_IFNAME]), "\x00")
+		if name == "" {
+			continue
+		}
+		iface := nl80211Interface{
+			ifindex:   netlinkUint32(attrs[unix.NL80211_ATTR_IFINDEX]),
+			ssid:      string(attrs[unix.NL80211_ATTR_SSID]),
+			frequency: netlinkUint32(attrs[unix.NL80211_ATTR_WIPHY_FREQ]),
+		}
+		ifaces[name] = iface
+	}
+	return ifaces
+}
+
+// parseNL80211Station parses the first station of NL80211_CMD_GET_STATION replies into
+// iface. A client interface has a single station: its access point.
+func parseNL80211Station(msgs [][]byte, iface *nl80211Interface) {
+	for _, msg := range msgs {
+		attrs := parseNetlinkAttrs(msg)
+		info, ok := attrs[unix.NL80211_ATTR_STA_INFO]
+		if !ok {
+			continue
+		}
+		if mac := attrs[unix.NL80211_ATTR_MAC]; len(mac) == 6 {
+			iface.bssid = net.HardwareAddr(mac).String()
+		}
+		staInfo := parseNetlinkAttrs(info)
+		if signal := staInfo[unix.NL80211_STA_INFO_SIGNAL]; len(signal) >= 1 {
+			iface.signal = int32(int8(signal[0]))
+			iface.hasSignal = true
+		}
+		iface.txBitrate = nl80211Bitrate(staInfo[unix.NL80211_STA_INFO_TX_BITRATE])
+		iface.rxBitrate = nl80211Bitrate(staInfo[unix.NL80211_STA_INFO_RX_BITRATE])
+		return
+	}
+}
+
+// nl80211Bitrate returns the bitrate in Mbps of a nested rate info attribute. Rates are in
+// units of 100 kbit/s; the 32 bit attribute is only set for rates that overflow 16 bits.
+func nl80211Bitrate(rateInfo []byte) float64 {
+	if rateInfo == nil {
+		return 0
+	}
+	attrs := parseNetlinkAttrs(rateInfo)
+	if v, ok := attrs[unix.NL80211_RATE_INFO_BITRATE32]; ok {
+		return float64(netlinkUint32(v)) / 10
+	}
+	if v := attrs[unix.NL80211_RATE_INFO_BITRATE]; len(v) >= 2 {
+		return float64(binary.NativeEndian.Uint16(v)) / 10
+	}
+	return 0
+}
+
+// genetlinkConn is a generic netlink socket
+type genetlinkConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialGenetlink() (*genetlinkConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open generic netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind generic netlink socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(nl80211Timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to set netlink receive timeout: %w", err)
+	}
+	return &genetlinkConn{fd: fd}, nil
+}
+
+func (c *genetlinkConn) close() {
+	unix.Close(c.fd)
+}
+
+// resolveFamily returns the ID of the generic netlink family name
+func (c *genetlinkConn) resolveFamily(name string) (uint16, error) {
+	msgs, err := c.request(unix.GENL_ID_CTRL, unix.NLM_F_REQUEST, unix.CTRL_CMD_GETFAMILY,
+		netlinkAttr(unix.CTRL_ATTR_FAMILY_NAME, append([]byte(name), 0)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve generic netlink family %s: %w", name, err)
+	}
+	for _, msg := range msgs {
+		if id := parseNetlinkAttrs(msg)[unix.CTRL_ATTR_FAMILY_ID]; len(id) >= 2 {
+			return binary.NativeEndian.Uint16(id), nil
+		}
+	}
+	return 0, fmt.Errorf("generic netlink family %s has no ID", name)
+}
+
+// request sends a generic netlink command and returns the attributes of the replies, i.e.
+// their payloads without the netlink and generic netlink headers
+func (c *genetlinkConn) request(family uint16, flags uint16, cmd uint8, attrs ...[]byte) ([][]byte, error) {
+	c.seq++
+	msg := netlinkMessage(family, flags, c.seq, cmd, attrs...)
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	dump := flags&unix.NLM_F_DUMP == unix.NLM_F_DUMP
+	var replies [][]byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		payloads, done, err := parseNetlinkMessages(buf[:n], c.seq)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, payloads...)
+		if done || (!dump && len(replies) > 0) {
+			return replies, nil
+		}
+	}
+}
+
+// netlinkMessage builds a generic netlink request
+func netlinkMessage(family uint16, flags uint16, seq uint32, cmd uint8, attrs ...[]byte) []byte {
+	length := unix.NLMSG_HDRLEN + unix.GENL_HDRLEN
+	for _, attr := range attrs {
+		length += len(attr)
+	}
+	msg := make([]byte, 0, length)
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(length))
+	msg = binary.NativeEndian.AppendUint16(msg, family)
+	msg = binary.NativeEndian.AppendUint16(msg, flags)
+	msg = binary.NativeEndian.AppendUint32(msg, seq)
+	msg = binary.NativeEndian.AppendUint32(msg, 0) // port ID, assigned by the kernel
+	msg = append(msg, cmd, 1, 0, 0)                // genlmsghdr: command, version, reserved
+	for _, attr := range attrs {
+		msg = append(msg, attr...)
+	}
+	return msg
+}
+
+// netlinkAttr encodes a netlink attribute padded to 4 bytes
+func netlinkAttr(typ uint16, data []byte) []byte {
+	length := unix.NLA_HDRLEN + len(data)
+	attr := make([]byte, 0, netlinkAlign(length))
+	attr = binary.NativeEndian.AppendUint16(attr, uint16(length))
+	attr = binary.NativeEndian.AppendUint16(attr, typ)
+	attr = append(attr, data...)
+	return append(attr, make([]byte, netlinkAlign(length)-length)...)
+}
+
+// parseNetlinkMessages parses the netlink messages in b and returns the generic netlink
+// payloads of the replies to seq. done is true once the end of a dump was reached.
+func parseNetlinkMessages(b []byte, seq uint32) (payloads [][]byte, done bool, err error) {
+	for len(b) >= unix.NLMSG_HDRLEN {
+		length := int(binary.NativeEndian.Uint32(b[0:4]))
+		typ := binary.NativeEndian.Uint16(b[4:6])
+		msgSeq := binary.NativeEndian.Uint32(b[8:12])
+		if length < unix.NLMSG_HDRLEN || length > len(b) {
+			return nil, false, errors.New("malformed netlink message")
+		}
+		body := b[unix.NLMSG_HDRLEN:length]
+		b = b[min(netlinkAlign(length), len(b)):]
+
+		if msgSeq != seq {
+			continue
+		}
+		switch typ {
+		case unix.NLMSG_DONE:
+			return payloads, true, nil
+		case unix.NLMSG_ERROR:
+			if len(body) < 4 {
+				return nil, false, errors.New("malformed netlink error")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(body[0:4])); errno != 0 {
+				return nil, false, syscall.Errno(-errno)
+			}
+			// An acknowledgement
+			return payloads, true, nil
+		default:
+			if len(body) < unix.GENL_HDRLEN {
+				continue
+			}
+			payloads = append(payloads, body[unix.GENL_HDRLEN:])
+		}
+	}
+	return payloads, false, nil
+}
+
+// parseNetlinkAttrs returns the attributes in b keyed by type. Nested attributes are
+// returned as is and can be parsed again.
+func parseNetlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= unix.NLA_HDRLEN {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if length < unix.NLA_HDRLEN || length > len(b) {
+			break
+		}
+		attrs[typ] = b[unix.NLA_HDRLEN:length]
+		b = b[min(netlinkAlign(length), len(b)):]
+	}
+	return attrs
+}
+
+func netlinkUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.NativeEndian.Uint32(b)
+}
+
+func netlinkAlign(n int) int {
+	return (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
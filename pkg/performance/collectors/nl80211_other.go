@@ -0,0 +1,25 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build !linux
+
+package collectors
+
+import "errors"
+
+type nl80211Interface struct {
+	ssid      string
+	frequency uint32
+	bssid     string
+	signal    int32
+	hasSignal bool
+	txBitrate float64
+	rxBitrate float64
+}
+
+func queryNL80211() (map[string]nl80211Interface, error) {
+	return nil, errors.New("nl80211 is only available on Linux")
+}
@@ -0,0 +1,213 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package opensnoop traces the files opened on the host with eBPF, to find out what keeps
+// opening a config file or filling a volume.
+package opensnoop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/go-logr/logr"
+	"golang.org/x/sys/unix"
+
+	"github.com/antimetal/agent/pkg/ebpf/loader"
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Compile-time interface check
+var _ performance.ContinuousCollector = (*Collector)(nil)
+
+const (
+	objectName = "opensnoop.bpf.o"
+	eventsMap  = "events"
+	// Size of the channel buffering events for the consumer. Events are dropped while it
+	// is full rather than stalling the ring buffer reader.
+	eventBuffer = 1024
+)
+
+// tracepoints are the programs of the BPF object and the syscall tracepoints they attach to
+var tracepoints = map[string]loader.Tracepoint{
+	"tracepoint__syscalls__sys_enter_openat": {Group: "syscalls", Name: "sys_enter_openat"},
+	"tracepoint__syscalls__sys_exit_openat":  {Group: "syscalls", Name: "sys_exit_openat"},
+}
+
+// Collector streams a performance.FileOpenEvent for every openat(2) call on the host
+// whose path matches CollectionConfig.FileOpenPathPrefixes.
+//
+// The BPF program saves the arguments of openat on syscall entry and sends them with the
+// result on syscall exit through a ring buffer. Paths are truncated to 255 bytes and are
+// reported as passed to openat, so opens relative to a directory file descriptor only
+// match an empty path filter. open(2) and openat2(2) are not traced.
+type Collector struct {
+	performance.BaseContinuousCollector
+	loader *loader.Manager
+	filter Filter
+
+	mu      sync.Mutex
+	bpf     *loader.Handle
+	reader  *ringbuf.Reader
+	done    chan struct{}
+	dropped uint64
+}
+
+func NewCollector(logger logr.Logger, config performance.CollectionConfig) (*Collector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    false,
+		SupportsContinuous: true,
+		RequiresRoot:       true,
+		RequiresEBPF:       true,
+		MinKernelVersion:   "5.8", // BPF ring buffer
+	}
+
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	return &Collector{
+		BaseContinuousCollector: performance.NewBaseContinuousCollector(
+			performance.MetricTypeFileOpen,
+			"File Open Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		loader: loader.Shared(config.HostSysPath),
+		filter: NewFilter(config.FileOpenPathPrefixes),
+	}, nil
+}
+
+// Start loads the BPF program, attaches it to the openat tracepoints and streams the
+// traced opens until ctx is done or Stop is called
+func (c *Collector) Start(ctx context.Context) (<-chan any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bpf != nil {
+		return nil, errors.New("collector is already running")
+	}
+
+	if err := c.load(); err != nil {
+		c.close()
+		c.SetError(err)
+		return nil, err
+	}
+	bootTime, err := monotonicBootTime()
+	if err != nil {
+		c.close()
+		c.SetError(err)
+		return nil, err
+	}
+
+	ch := make(chan any, eventBuffer)
+	c.done = make(chan struct{})
+	go c.read(ctx, ch, c.reader, bootTime, c.done)
+	c.SetStatus(performance.CollectorStatusActive)
+	return ch, nil
+}
+
+// load loads the BPF object and attaches its programs. Whatever was set up is released
+// by close if it fails.
+func (c *Collector) load() error {
+	var err error
+	c.bpf, err = c.loader.Load(loader.Object{Name: objectName, Tracepoints: tracepoints})
+	if err != nil {
+		return err
+	}
+
+	events, err := c.bpf.Map(eventsMap)
+	if err != nil {
+		return err
+	}
+	c.reader, err = ringbuf.NewReader(events)
+	if err != nil {
+		return fmt.Errorf("failed to open ring buffer: %w", err)
+	}
+	return nil
+}
+
+// read sends the events of reader to ch until the reader is closed
+func (c *Collector) read(ctx context.Context, ch chan<- any, reader *ringbuf.Reader, bootTime time.Time, done chan<- struct{}) {
+	defer close(done)
+	defer close(ch)
+
+	// Closing the reader unblocks Read
+	stop := context.AfterFunc(ctx, func() { _ = c.Stop() })
+	defer stop()
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, ringbuf.ErrClosed) {
+			return
+		}
+		if err != nil {
+			c.Logger().Error(err, "failed to read from ring buffer")
+			continue
+		}
+
+		event, err := decodeEvent(record.RawSample, bootTime)
+		if err != nil {
+			c.Logger().V(1).Info("dropping malformed event", "error", err)
+			continue
+		}
+		if !c.filter.Match(event.Path) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			c.mu.Lock()
+			c.dropped++
+			dropped := c.dropped
+			c.mu.Unlock()
+			if dropped%eventBuffer == 1 {
+				c.Logger().Info("consumer is too slow, dropping file open events", "dropped", dropped)
+			}
+		}
+	}
+}
+
+// Stop detaches the BPF programs and waits for the event channel to be closed
+func (c *Collector) Stop() error {
+	c.mu.Lock()
+	done := c.done
+	c.close()
+	c.SetStatus(performance.CollectorStatusDisabled)
+	c.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+	return nil
+}
+
+// close releases the BPF resources. c.mu must be held.
+func (c *Collector) close() {
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+	if c.bpf != nil {
+		c.bpf.Close()
+		c.bpf = nil
+	}
+	c.done = nil
+}
+
+// monotonicBootTime returns the wall clock time at which CLOCK_MONOTONIC, the clock of
+// bpf_ktime_get_ns, started
+func monotonicBootTime() (time.Time, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+	return time.Now().Add(-time.Duration(ts.Nano())), nil
+}
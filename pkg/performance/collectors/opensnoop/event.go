@@ -0,0 +1,92 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package opensnoop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Layout of struct opensnoop_event in ebpf/include/opensnoop_types.h
+const (
+	taskCommLen = 16
+	pathMax     = 256
+	eventSize   = 8 + 6*4 + taskCommLen + pathMax
+)
+
+// decodeEvent decodes an event sent by the BPF program. bootTime converts the event's
+// monotonic timestamp to wall clock time.
+func decodeEvent(raw []byte, bootTime time.Time) (performance.FileOpenEvent, error) {
+	if len(raw) < eventSize {
+		return performance.FileOpenEvent{}, fmt.Errorf("event too short: %d bytes, want %d", len(raw), eventSize)
+	}
+	le := binary.NativeEndian
+	ret := int32(le.Uint32(raw[20:24]))
+	event := performance.FileOpenEvent{
+		Timestamp: bootTime.Add(time.Duration(le.Uint64(raw[0:8]))),
+		PID:       le.Uint32(raw[8:12]),
+		TID:       le.Uint32(raw[12:16]),
+		UID:       le.Uint32(raw[16:20]),
+		Flags:     int32(le.Uint32(raw[24:28])),
+		Mode:      le.Uint32(raw[28:32]),
+		Command:   cString(raw[32 : 32+taskCommLen]),
+		Path:      cString(raw[32+taskCommLen : eventSize]),
+		FD:        ret,
+	}
+	// The syscall returns the negated errno on failure
+	if ret < 0 {
+		event.FD = -1
+		event.Errno = -ret
+	}
+	return event, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Filter selects the opens to report by the path of the opened file
+type Filter struct {
+	prefixes []string
+}
+
+// NewFilter returns a filter matching the paths under any of prefixes, or every path if
+// prefixes is empty. A prefix matches whole path components: /etc/kubernetes matches
+// /etc/kubernetes/admin.conf but not /etc/kubernetes-old.
+func NewFilter(prefixes []string) Filter {
+	cleaned := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			cleaned = append(cleaned, filepath.Clean(prefix))
+		}
+	}
+	return Filter{prefixes: cleaned}
+}
+
+// Match reports whether the open of path is reported. Paths relative to a directory file
+// descriptor can't be resolved and only match an empty filter.
+func (f Filter) Match(path string) bool {
+	if len(f.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if path == prefix || prefix == "/" && strings.HasPrefix(path, "/") ||
+			strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
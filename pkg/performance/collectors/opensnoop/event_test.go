@@ -0,0 +1,80 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package opensnoop
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// rawEvent encodes an event the way the BPF program lays it out
+func rawEvent(ret int32, comm, path string) []byte {
+	raw := make([]byte, eventSize)
+	le := binary.NativeEndian
+	le.PutUint64(raw[0:8], uint64(5*time.Second))
+	le.PutUint32(raw[8:12], 1234)
+	le.PutUint32(raw[12:16], 1235)
+	le.PutUint32(raw[16:20], 1000)
+	le.PutUint32(raw[20:24], uint32(ret))
+	le.PutUint32(raw[24:28], 0x241) // O_WRONLY|O_CREAT|O_TRUNC
+	le.PutUint32(raw[28:32], 0o644)
+	copy(raw[32:32+taskCommLen], comm)
+	copy(raw[32+taskCommLen:], path)
+	return raw
+}
+
+func TestDecodeEvent(t *testing.T) {
+	boot := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	event, err := decodeEvent(rawEvent(3, "kubelet", "/etc/kubernetes/kubelet.conf"), boot)
+	require.NoError(t, err)
+	assert.Equal(t, performance.FileOpenEvent{
+		Timestamp: boot.Add(5 * time.Second),
+		PID:       1234,
+		TID:       1235,
+		UID:       1000,
+		Command:   "kubelet",
+		Path:      "/etc/kubernetes/kubelet.conf",
+		Flags:     0x241,
+		Mode:      0o644,
+		FD:        3,
+	}, event)
+
+	event, err = decodeEvent(rawEvent(-2, "cat", "/missing"), boot)
+	require.NoError(t, err)
+	assert.Equal(t, int32(-1), event.FD)
+	assert.Equal(t, int32(2), event.Errno, "ENOENT")
+
+	_, err = decodeEvent(make([]byte, eventSize-1), boot)
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		prefixes []string
+		path     string
+		expected bool
+	}{
+		{nil, "relative/path", true},
+		{[]string{"/etc/kubernetes"}, "/etc/kubernetes/admin.conf", true},
+		{[]string{"/etc/kubernetes/"}, "/etc/kubernetes", true},
+		{[]string{"/etc/kubernetes"}, "/etc/kubernetes-old/admin.conf", false},
+		{[]string{"/var/log", "/data"}, "/data/db/wal", true},
+		{[]string{"/var/log"}, "log/app.log", false},
+		{[]string{"/"}, "/anything", true},
+		{[]string{" ", ""}, "/anything", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NewFilter(tt.prefixes).Match(tt.path), "prefixes %q, path %q", tt.prefixes, tt.path)
+	}
+}
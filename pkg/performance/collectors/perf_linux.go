@@ -0,0 +1,113 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// perfEventConfigs are the generic hardware events counted, in the order of
+// perfReading.values
+var perfEventConfigs = [numPerfEvents]uint64{
+	perfCycles:          unix.PERF_COUNT_HW_CPU_CYCLES,
+	perfInstructions:    unix.PERF_COUNT_HW_INSTRUCTIONS,
+	perfCacheReferences: unix.PERF_COUNT_HW_CACHE_REFERENCES,
+	perfCacheMisses:     unix.PERF_COUNT_HW_CACHE_MISSES,
+}
+
+// perfGroup is a group of perf events counting on a CPU. The events of a group are
+// scheduled on the PMU together, so their counts cover the same time and can be divided
+// by one another.
+type perfGroup struct {
+	cpu int
+	// The group leader comes first
+	fds []int
+}
+
+// openPerfGroup opens and enables the perfEventConfigs counters of all processes running
+// on cpu
+func openPerfGroup(cpu int) (*perfGroup, error) {
+	g := &perfGroup{cpu: cpu, fds: make([]int, 0, numPerfEvents)}
+	leader := -1
+	for i, config := range perfEventConfigs {
+		attr := unix.PerfEventAttr{
+			Type:        unix.PERF_TYPE_HARDWARE,
+			Size:        uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+			Config:      config,
+			Read_format: unix.PERF_FORMAT_GROUP | unix.PERF_FORMAT_TOTAL_TIME_ENABLED | unix.PERF_FORMAT_TOTAL_TIME_RUNNING,
+		}
+		if i == 0 {
+			// Enabled with the whole group once all its events are open
+			attr.Bits = unix.PerfBitDisabled
+		}
+		fd, err := unix.PerfEventOpen(&attr, -1, cpu, leader, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			g.close()
+			return nil, perfOpenError(err)
+		}
+		if i == 0 {
+			leader = fd
+		}
+		g.fds = append(g.fds, fd)
+	}
+
+	if err := unix.IoctlSetInt(leader, unix.PERF_EVENT_IOC_ENABLE, unix.PERF_IOC_FLAG_GROUP); err != nil {
+		g.close()
+		return nil, fmt.Errorf("failed to enable perf events: %w", err)
+	}
+	return g, nil
+}
+
+// perfOpenError explains the errors of perf_event_open caused by the host rather than
+// the agent
+func perfOpenError(err error) error {
+	switch {
+	case errors.Is(err, unix.EACCES), errors.Is(err, unix.EPERM):
+		return fmt.Errorf("system-wide perf events need CAP_PERFMON or kernel.perf_event_paranoid <= 0: %w", err)
+	case errors.Is(err, unix.ENOENT), errors.Is(err, unix.EOPNOTSUPP), errors.Is(err, unix.ENODEV):
+		return fmt.Errorf("hardware performance counters aren't available, e.g. in a VM without a virtual PMU: %w", err)
+	}
+	return err
+}
+
+// read reads the counters of the group.
+//
+// Format with PERF_FORMAT_GROUP: nr, time_enabled, time_running, then a value per event
+func (g *perfGroup) read() (perfReading, error) {
+	buf := make([]byte, 8*(3+numPerfEvents))
+	n, err := unix.Read(g.fds[0], buf)
+	if err != nil {
+		return perfReading{}, err
+	}
+	if n != len(buf) {
+		return perfReading{}, fmt.Errorf("short read of %d bytes", n)
+	}
+	if nr := binary.NativeEndian.Uint64(buf); nr != numPerfEvents {
+		return perfReading{}, fmt.Errorf("expected %d events in the group, got %d", numPerfEvents, nr)
+	}
+
+	reading := perfReading{
+		enabled: binary.NativeEndian.Uint64(buf[8:]),
+		running: binary.NativeEndian.Uint64(buf[16:]),
+	}
+	for i := range reading.values {
+		reading.values[i] = binary.NativeEndian.Uint64(buf[24+8*i:])
+	}
+	return reading, nil
+}
+
+func (g *perfGroup) close() {
+	for _, fd := range g.fds {
+		unix.Close(fd)
+	}
+	g.fds = nil
+}
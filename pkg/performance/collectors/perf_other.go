@@ -0,0 +1,25 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build !linux
+
+package collectors
+
+import "errors"
+
+type perfGroup struct {
+	cpu int
+}
+
+func openPerfGroup(cpu int) (*perfGroup, error) {
+	return nil, errors.New("perf events are only available on Linux")
+}
+
+func (g *perfGroup) read() (perfReading, error) {
+	return perfReading{}, errors.New("perf events are only available on Linux")
+}
+
+func (g *perfGroup) close() {}
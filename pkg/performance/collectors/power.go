@@ -0,0 +1,309 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*PowerCollector)(nil)
+
+// PowerCollector collects power supply, suspend/resume and CPU idle state information
+//
+// Data sources:
+// - /sys/class/power_supply/*: batteries, AC adapters and UPS devices
+// - /sys/power/suspend_stats/: suspend/resume success and failure counters
+// - /sys/devices/system/cpu/cpu*/cpuidle/state*/: C-state residency per CPU
+//
+// The suspend_stats counters are cumulative since boot, so suspends and resumes are reported
+// as a SuspendEvent when the counters changed since the previous collection, along with the
+// time the system spent suspended.
+//
+// Every source is optional. Servers usually have no power supplies, VMs often expose no
+// cpuidle states and suspend_stats requires CONFIG_PM_SLEEP, so missing files never fail
+// the collection.
+//
+// Reference: https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-class-power
+// Reference: https://www.kernel.org/doc/html/latest/admin-guide/pm/cpuidle.html
+type PowerCollector struct {
+	performance.BaseCollector
+	powerSupplyPath  string
+	suspendStatsPath string
+	cpuPath          string
+
+	mu sync.Mutex
+	// Suspend counters, sleep offset (see sleepOffset) and time of the previous collection.
+	// The sleep offset is zero if the clocks couldn't be read.
+	prevSuspend *performance.SuspendStats
+	prevSleep   time.Duration
+	prevTime    time.Time
+}
+
+func NewPowerCollector(logger logr.Logger, config performance.CollectionConfig) (*PowerCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.24", // cpuidle sysfs interface
+	}
+
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	if _, err := os.Stat(config.HostSysPath); err != nil {
+		return nil, fmt.Errorf("HostSysPath validation failed: %w", err)
+	}
+
+	return &PowerCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypePower,
+			"Power State Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		powerSupplyPath:  filepath.Join(config.HostSysPath, "class", "power_supply"),
+		suspendStatsPath: filepath.Join(config.HostSysPath, "power", "suspend_stats"),
+		cpuPath:          filepath.Join(config.HostSysPath, "devices", "system", "cpu"),
+	}, nil
+}
+
+func (c *PowerCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectPowerStats(ctx, time.Now())
+}
+
+func (c *PowerCollector) collectPowerStats(ctx context.Context, now time.Time) (*performance.PowerStats, error) {
+	stats := &performance.PowerStats{}
+
+	supplies, err := c.collectPowerSupplies(ctx)
+	if err != nil {
+		c.Logger().V(1).Info("Failed to read power supplies (continuing without them)", "path", c.powerSupplyPath, "error", err)
+	}
+	stats.Supplies = supplies
+
+	suspend, err := c.collectSuspendStats()
+	if err != nil {
+		c.Logger().V(1).Info("Failed to read suspend stats (continuing without them)", "path", c.suspendStatsPath, "error", err)
+	}
+	stats.Suspend = suspend
+	stats.SuspendEvent = c.observeSuspend(suspend, now)
+
+	idle, err := c.collectCPUIdle(ctx)
+	if err != nil {
+		c.Logger().V(1).Info("Failed to read cpuidle states (continuing without them)", "path", c.cpuPath, "error", err)
+	}
+	stats.CPUIdle = idle
+
+	// The parts above only log their errors, so a cancellation surfaces here
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// collectPowerSupplies reads every device in /sys/class/power_supply.
+// Drivers expose either energy_* (µWh) or charge_* (µAh) attributes, which are reported in
+// separate fields as their units differ.
+func (c *PowerCollector) collectPowerSupplies(ctx context.Context) ([]performance.PowerSupply, error) {
+	entries, err := os.ReadDir(c.powerSupplyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	supplies := make([]performance.PowerSupply, 0, len(entries))
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(c.powerSupplyPath, entry.Name())
+		supply := performance.PowerSupply{
+			Name:   entry.Name(),
+			Type:   procparse.ReadStringFile(filepath.Join(dir, "type")),
+			Status: procparse.ReadStringFile(filepath.Join(dir, "status")),
+			Health: procparse.ReadStringFile(filepath.Join(dir, "health")),
+		}
+		supply.Online, _ = readSysfsBool(filepath.Join(dir, "online"))
+		supply.Present, _ = readSysfsBool(filepath.Join(dir, "present"))
+		supply.CapacityPercent, _ = procparse.ReadUintFile(filepath.Join(dir, "capacity"))
+		supply.CycleCount, _ = procparse.ReadUintFile(filepath.Join(dir, "cycle_count"))
+		supply.EnergyNow, _ = procparse.ReadUintFile(filepath.Join(dir, "energy_now"))
+		supply.EnergyFull, _ = procparse.ReadUintFile(filepath.Join(dir, "energy_full"))
+		supply.EnergyFullDesign, _ = procparse.ReadUintFile(filepath.Join(dir, "energy_full_design"))
+		supply.ChargeNow, _ = procparse.ReadUintFile(filepath.Join(dir, "charge_now"))
+		supply.ChargeFull, _ = procparse.ReadUintFile(filepath.Join(dir, "charge_full"))
+		supply.ChargeFullDesign, _ = procparse.ReadUintFile(filepath.Join(dir, "charge_full_design"))
+		supply.PowerNow, _ = procparse.ReadUintFile(filepath.Join(dir, "power_now"))
+		supply.VoltageNow, _ = procparse.ReadUintFile(filepath.Join(dir, "voltage_now"))
+		supplies = append(supplies, supply)
+	}
+	return supplies, nil
+}
+
+// collectSuspendStats reads /sys/power/suspend_stats/. Returns nil when the kernel was built
+// without CONFIG_PM_SLEEP.
+func (c *PowerCollector) collectSuspendStats() (*performance.SuspendStats, error) {
+	if _, err := os.Stat(c.suspendStatsPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stats := &performance.SuspendStats{}
+	counters := map[string]*uint64{
+		"success":              &stats.Success,
+		"fail":                 &stats.Fail,
+		"failed_freeze":        &stats.FailedFreeze,
+		"failed_prepare":       &stats.FailedPrepare,
+		"failed_suspend":       &stats.FailedSuspend,
+		"failed_suspend_late":  &stats.FailedSuspendLate,
+		"failed_suspend_noirq": &stats.FailedSuspendNoirq,
+		"failed_resume":        &stats.FailedResume,
+		"failed_resume_early":  &stats.FailedResumeEarly,
+		"failed_resume_noirq":  &stats.FailedResumeNoirq,
+	}
+
+	for name, field := range counters {
+		v, err := procparse.ReadUintFile(filepath.Join(c.suspendStatsPath, name))
+		if err != nil {
+			c.Logger().V(2).Info("Failed to read suspend counter", "counter", name, "error", err)
+			continue
+		}
+		*field = v
+	}
+
+	stats.LastFailedDev = procparse.ReadStringFile(filepath.Join(c.suspendStatsPath, "last_failed_dev"))
+	stats.LastFailedStep = procparse.ReadStringFile(filepath.Join(c.suspendStatsPath, "last_failed_step"))
+	if errno := procparse.ReadStringFile(filepath.Join(c.suspendStatsPath, "last_failed_errno")); errno != "" {
+		if v, err := strconv.ParseInt(errno, 10, 64); err == nil {
+			stats.LastFailedErrno = v
+		}
+	}
+
+	return stats, nil
+}
+
+// observeSuspend returns the suspend/resume activity since the previous collection, or nil
+// if there was none
+func (c *PowerCollector) observeSuspend(cur *performance.SuspendStats, now time.Time) *performance.SuspendEvent {
+	sleep, err := sleepOffset()
+	if err != nil {
+		c.Logger().V(2).Info("Failed to read the time suspended", "error", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev, prevSleep, prevTime := c.prevSuspend, c.prevSleep, c.prevTime
+	c.prevSuspend, c.prevSleep, c.prevTime = cur, sleep, now
+	if prev == nil || cur == nil {
+		return nil
+	}
+
+	// The counters restart from zero when the system reboots, which counterDelta ignores
+	event := &performance.SuspendEvent{
+		Start:    prevTime,
+		End:      now,
+		Resumes:  counterDelta(prev.Success, cur.Success),
+		Failures: counterDelta(prev.Fail, cur.Fail),
+	}
+	if event.Resumes == 0 && event.Failures == 0 {
+		return nil
+	}
+	if prevSleep > 0 && sleep > prevSleep {
+		event.Suspended = sleep - prevSleep
+	}
+	if event.Failures > 0 {
+		event.LastFailedDev = cur.LastFailedDev
+		event.LastFailedErrno = cur.LastFailedErrno
+		event.LastFailedStep = cur.LastFailedStep
+	}
+	return event
+}
+
+// collectCPUIdle reads the cpuidle states of every CPU. CPUs without a cpuidle directory
+// (e.g. when no cpuidle driver is loaded) are skipped.
+func (c *PowerCollector) collectCPUIdle(ctx context.Context) ([]performance.CPUIdleStats, error) {
+	cpuDirs, err := filepath.Glob(filepath.Join(c.cpuPath, "cpu[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []performance.CPUIdleStats
+	for _, cpuDir := range cpuDirs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		index, err := strconv.ParseInt(strings.TrimPrefix(filepath.Base(cpuDir), "cpu"), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		stateDirs, err := filepath.Glob(filepath.Join(cpuDir, "cpuidle", "state[0-9]*"))
+		if err != nil || len(stateDirs) == 0 {
+			continue
+		}
+		sort.Slice(stateDirs, func(i, j int) bool {
+			return stateIndex(stateDirs[i]) < stateIndex(stateDirs[j])
+		})
+
+		cpu := performance.CPUIdleStats{
+			CPUIndex: int32(index),
+			States:   make([]performance.CPUIdleState, 0, len(stateDirs)),
+		}
+		for _, stateDir := range stateDirs {
+			state := performance.CPUIdleState{
+				Name: procparse.ReadStringFile(filepath.Join(stateDir, "name")),
+			}
+			state.Latency, _ = procparse.ReadUintFile(filepath.Join(stateDir, "latency"))
+			state.Usage, _ = procparse.ReadUintFile(filepath.Join(stateDir, "usage"))
+			state.Time, _ = procparse.ReadUintFile(filepath.Join(stateDir, "time"))
+			state.Disabled, _ = readSysfsBool(filepath.Join(stateDir, "disable"))
+			cpu.States = append(cpu.States, state)
+		}
+		result = append(result, cpu)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CPUIndex < result[j].CPUIndex
+	})
+	return result, nil
+}
+
+func stateIndex(dir string) int {
+	i, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "state"))
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+func readSysfsBool(path string) (bool, error) {
+	v, err := procparse.ReadUintFile(path)
+	if err != nil {
+		return false, err
+	}
+	return v != 0, nil
+}
@@ -0,0 +1,28 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sleepOffset returns how far CLOCK_BOOTTIME is ahead of CLOCK_MONOTONIC. Both start at
+// boot, but only CLOCK_BOOTTIME advances while the system is suspended, so the offset is
+// the time spent suspended since boot.
+func sleepOffset() (time.Duration, error) {
+	var mono, boot unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono); err != nil {
+		return 0, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot); err != nil {
+		return 0, fmt.Errorf("failed to read boot clock: %w", err)
+	}
+	return time.Duration(boot.Nano() - mono.Nano()), nil
+}
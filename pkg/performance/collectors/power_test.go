@@ -0,0 +1,265 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSysFiles creates files relative to root, creating parent directories as needed
+func writeSysFiles(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		fullPath := filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0644))
+	}
+}
+
+func createPowerCollector(t *testing.T, files map[string]string) *collectors.PowerCollector {
+	return createPowerCollectorIn(t, t.TempDir(), files)
+}
+
+func createPowerCollectorIn(t *testing.T, tmpDir string, files map[string]string) *collectors.PowerCollector {
+	writeSysFiles(t, tmpDir, files)
+
+	config := performance.CollectionConfig{
+		HostSysPath: tmpDir,
+	}
+	collector, err := collectors.NewPowerCollector(logr.Discard(), config)
+	require.NoError(t, err)
+	return collector
+}
+
+func collectPowerStats(t *testing.T, collector *collectors.PowerCollector) *performance.PowerStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.PowerStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestPowerCollector_Constructor(t *testing.T) {
+	t.Run("error on relative path", func(t *testing.T) {
+		config := performance.CollectionConfig{HostSysPath: "relative/path"}
+		_, err := collectors.NewPowerCollector(logr.Discard(), config)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "must be an absolute path")
+	})
+
+	t.Run("error on non-existent path", func(t *testing.T) {
+		config := performance.CollectionConfig{HostSysPath: "/non/existent/path/that/should/not/exist"}
+		_, err := collectors.NewPowerCollector(logr.Discard(), config)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "HostSysPath validation failed")
+	})
+}
+
+func TestPowerCollector_EmptySysfs(t *testing.T) {
+	// A server or VM with none of the optional interfaces should still collect successfully
+	stats := collectPowerStats(t, createPowerCollector(t, nil))
+	assert.Empty(t, stats.Supplies)
+	assert.Nil(t, stats.Suspend)
+	assert.Empty(t, stats.CPUIdle)
+}
+
+func TestPowerCollector_PowerSupplies(t *testing.T) {
+	collector := createPowerCollector(t, map[string]string{
+		"class/power_supply/AC/type":                          "Mains\n",
+		"class/power_supply/AC/online":                        "1\n",
+		"class/power_supply/BAT0/type":                        "Battery\n",
+		"class/power_supply/BAT0/status":                      "Discharging\n",
+		"class/power_supply/BAT0/health":                      "Good\n",
+		"class/power_supply/BAT0/present":                     "1\n",
+		"class/power_supply/BAT0/capacity":                    "87\n",
+		"class/power_supply/BAT0/cycle_count":                 "312\n",
+		"class/power_supply/BAT0/energy_now":                  "45120000\n",
+		"class/power_supply/BAT0/energy_full":                 "51860000\n",
+		"class/power_supply/BAT0/energy_full_design":          "57000000\n",
+		"class/power_supply/BAT0/power_now":                   "9875000\n",
+		"class/power_supply/BAT0/voltage_now":                 "12480000\n",
+		"class/power_supply/BAT1/type":                        "Battery\n",
+		"class/power_supply/BAT1/charge_now":                  "2100000\n",
+		"class/power_supply/BAT1/charge_full":                 "4200000\n",
+		"class/power_supply/BAT1/charge_full_design":          "4400000\n",
+		"class/power_supply/BAT1/capacity":                    "invalid\n",
+		"class/power_supply/ucsi-source-psy-USBC000:001/type": "USB\n",
+	})
+
+	stats := collectPowerStats(t, collector)
+	require.Len(t, stats.Supplies, 4)
+
+	supplies := make(map[string]performance.PowerSupply)
+	for _, s := range stats.Supplies {
+		supplies[s.Name] = s
+	}
+
+	assert.Equal(t, performance.PowerSupply{Name: "AC", Type: "Mains", Online: true}, supplies["AC"])
+	assert.Equal(t, performance.PowerSupply{
+		Name:             "BAT0",
+		Type:             "Battery",
+		Status:           "Discharging",
+		Health:           "Good",
+		Present:          true,
+		CapacityPercent:  87,
+		CycleCount:       312,
+		EnergyNow:        45120000,
+		EnergyFull:       51860000,
+		EnergyFullDesign: 57000000,
+		PowerNow:         9875000,
+		VoltageNow:       12480000,
+	}, supplies["BAT0"])
+
+	// charge_* attributes are reported apart from energy_* as they are in µAh; invalid
+	// values degrade to zero
+	bat1 := supplies["BAT1"]
+	assert.Equal(t, uint64(2100000), bat1.ChargeNow)
+	assert.Equal(t, uint64(4200000), bat1.ChargeFull)
+	assert.Equal(t, uint64(4400000), bat1.ChargeFullDesign)
+	assert.Zero(t, bat1.EnergyNow)
+	assert.Equal(t, uint64(0), bat1.CapacityPercent)
+
+	assert.Equal(t, "USB", supplies["ucsi-source-psy-USBC000:001"].Type)
+}
+
+func TestPowerCollector_SuspendStats(t *testing.T) {
+	collector := createPowerCollector(t, map[string]string{
+		"power/suspend_stats/success":              "42\n",
+		"power/suspend_stats/fail":                 "3\n",
+		"power/suspend_stats/failed_freeze":        "0\n",
+		"power/suspend_stats/failed_prepare":       "0\n",
+		"power/suspend_stats/failed_suspend":       "2\n",
+		"power/suspend_stats/failed_suspend_late":  "0\n",
+		"power/suspend_stats/failed_suspend_noirq": "0\n",
+		"power/suspend_stats/failed_resume":        "1\n",
+		"power/suspend_stats/failed_resume_early":  "0\n",
+		"power/suspend_stats/failed_resume_noirq":  "0\n",
+		"power/suspend_stats/last_failed_dev":      "0000:00:14.0\n",
+		"power/suspend_stats/last_failed_errno":    "-16\n",
+		"power/suspend_stats/last_failed_step":     "suspend\n",
+	})
+
+	stats := collectPowerStats(t, collector)
+	require.NotNil(t, stats.Suspend)
+	assert.Equal(t, performance.SuspendStats{
+		Success:         42,
+		Fail:            3,
+		FailedSuspend:   2,
+		FailedResume:    1,
+		LastFailedDev:   "0000:00:14.0",
+		LastFailedErrno: -16,
+		LastFailedStep:  "suspend",
+	}, *stats.Suspend)
+}
+
+func TestPowerCollector_SuspendEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	collector := createPowerCollectorIn(t, tmpDir, map[string]string{
+		"power/suspend_stats/success": "5\n",
+		"power/suspend_stats/fail":    "1\n",
+	})
+
+	// The first collection has nothing to compare the counters with
+	first := collectPowerStats(t, collector)
+	assert.Nil(t, first.SuspendEvent)
+
+	// Nor is there an event while the counters don't change
+	assert.Nil(t, collectPowerStats(t, collector).SuspendEvent)
+
+	// Two resumes
+	writeSysFiles(t, tmpDir, map[string]string{"power/suspend_stats/success": "7\n"})
+	stats := collectPowerStats(t, collector)
+	require.NotNil(t, stats.SuspendEvent)
+	assert.Equal(t, uint64(2), stats.SuspendEvent.Resumes)
+	assert.Zero(t, stats.SuspendEvent.Failures)
+	assert.Empty(t, stats.SuspendEvent.LastFailedStep)
+	assert.False(t, stats.SuspendEvent.Start.After(stats.SuspendEvent.End))
+	// The test doesn't suspend the system, so at most the skew of reading both clocks
+	assert.Less(t, stats.SuspendEvent.Suspended, time.Second)
+
+	// A failure reports the last failed step
+	writeSysFiles(t, tmpDir, map[string]string{
+		"power/suspend_stats/fail":              "2\n",
+		"power/suspend_stats/last_failed_dev":   "0000:00:14.0\n",
+		"power/suspend_stats/last_failed_errno": "-16\n",
+		"power/suspend_stats/last_failed_step":  "suspend\n",
+	})
+	stats = collectPowerStats(t, collector)
+	require.NotNil(t, stats.SuspendEvent)
+	assert.Zero(t, stats.SuspendEvent.Resumes)
+	assert.Equal(t, uint64(1), stats.SuspendEvent.Failures)
+	assert.Equal(t, "0000:00:14.0", stats.SuspendEvent.LastFailedDev)
+	assert.Equal(t, int64(-16), stats.SuspendEvent.LastFailedErrno)
+	assert.Equal(t, "suspend", stats.SuspendEvent.LastFailedStep)
+
+	// Counters restarting from zero after a reboot aren't an event
+	writeSysFiles(t, tmpDir, map[string]string{
+		"power/suspend_stats/success": "0\n",
+		"power/suspend_stats/fail":    "0\n",
+	})
+	assert.Nil(t, collectPowerStats(t, collector).SuspendEvent)
+}
+
+func TestPowerCollector_CPUIdle(t *testing.T) {
+	files := map[string]string{
+		// cpu1 is listed first to verify ordering by CPU index
+		"devices/system/cpu/cpu1/cpuidle/state0/name":     "POLL\n",
+		"devices/system/cpu/cpu1/cpuidle/state0/latency":  "0\n",
+		"devices/system/cpu/cpu1/cpuidle/state0/usage":    "100\n",
+		"devices/system/cpu/cpu1/cpuidle/state0/time":     "2000\n",
+		"devices/system/cpu/cpu1/cpuidle/state0/disable":  "0\n",
+		"devices/system/cpu/cpu0/cpuidle/state0/name":     "POLL\n",
+		"devices/system/cpu/cpu0/cpuidle/state0/latency":  "0\n",
+		"devices/system/cpu/cpu0/cpuidle/state0/usage":    "5432\n",
+		"devices/system/cpu/cpu0/cpuidle/state0/time":     "98765\n",
+		"devices/system/cpu/cpu0/cpuidle/state0/disable":  "0\n",
+		"devices/system/cpu/cpu0/cpuidle/state1/name":     "C1\n",
+		"devices/system/cpu/cpu0/cpuidle/state1/latency":  "2\n",
+		"devices/system/cpu/cpu0/cpuidle/state1/usage":    "123456\n",
+		"devices/system/cpu/cpu0/cpuidle/state1/time":     "87654321\n",
+		"devices/system/cpu/cpu0/cpuidle/state1/disable":  "0\n",
+		"devices/system/cpu/cpu0/cpuidle/state10/name":    "C10\n",
+		"devices/system/cpu/cpu0/cpuidle/state10/usage":   "7\n",
+		"devices/system/cpu/cpu0/cpuidle/state10/disable": "1\n",
+		// cpu2 has no cpuidle directory and must be skipped
+		"devices/system/cpu/cpu2/online": "1\n",
+		// non-CPU entries in the cpu directory must be ignored
+		"devices/system/cpu/cpuidle/current_driver": "intel_idle\n",
+	}
+	stats := collectPowerStats(t, createPowerCollector(t, files))
+
+	require.Len(t, stats.CPUIdle, 2)
+	assert.Equal(t, int32(0), stats.CPUIdle[0].CPUIndex)
+	assert.Equal(t, int32(1), stats.CPUIdle[1].CPUIndex)
+
+	cpu0 := stats.CPUIdle[0].States
+	require.Len(t, cpu0, 3)
+	assert.Equal(t, performance.CPUIdleState{Name: "POLL", Usage: 5432, Time: 98765}, cpu0[0])
+	assert.Equal(t, performance.CPUIdleState{Name: "C1", Latency: 2, Usage: 123456, Time: 87654321}, cpu0[1])
+	// state10 sorts numerically after state1
+	assert.Equal(t, performance.CPUIdleState{Name: "C10", Usage: 7, Disabled: true}, cpu0[2])
+}
+
+func TestPowerCollector_Cancelled(t *testing.T) {
+	collector := createPowerCollector(t, map[string]string{
+		"class/power_supply/BAT0/type": "Battery\n",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,207 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*ProcessCollector)(nil)
+
+// DefaultProcessLimit is the number of processes reported by each collection of the
+// process collector
+const DefaultProcessLimit = 20
+
+// ProcessCollector reports the processes that used the most CPU since the previous
+// collection, busiest first. CPUPercent is relative to a single CPU, so a process
+// keeping several CPUs busy reports more than 100. Processes first seen report their
+// average since they started.
+//
+// Data sources:
+// - /proc/[pid]/stat: identity, state, CPU time, memory, threads and page faults
+// - /proc/stat: boot time, to compute start times, unless shared by the Manager
+//
+// Only the fields from /proc/[pid]/stat are filled, so that collecting stays cheap
+// enough to run at sub-second intervals during bursts.
+//
+// Reference: https://man7.org/linux/man-pages/man5/proc_pid_stat.5.html
+type ProcessCollector struct {
+	performance.BaseCollector
+	procPath string
+	limit    int
+
+	mu   sync.Mutex
+	host performance.HostContext
+	// prev holds the CPU time of every process at the previous collection
+	prev     map[processKey]uint64
+	prevTime time.Time
+}
+
+// processKey identifies a process. The start time guards against PID reuse.
+type processKey struct {
+	pid       int32
+	startTime uint64
+}
+
+func NewProcessCollector(logger logr.Logger, config performance.CollectionConfig) (*ProcessCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &ProcessCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeProcess,
+			"Process Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath: config.HostProcPath,
+		limit:    DefaultProcessLimit,
+		prev:     make(map[processKey]uint64),
+	}, nil
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectProcessStats(ctx, time.Now())
+}
+
+func (c *ProcessCollector) collectProcessStats(ctx context.Context, now time.Time) ([]performance.ProcessStats, error) {
+	entries, err := os.ReadDir(c.procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.procPath, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.host.BootTime.IsZero() {
+		if c.host, err = hostContext(ctx, c.procPath); err != nil {
+			return nil, err
+		}
+	}
+
+	procs := make([]performance.ProcessStats, 0)
+	cpuTimes := make(map[processKey]uint64)
+	elapsed := now.Sub(c.prevTime)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		proc, startTicks, err := c.readProcess(int32(pid))
+		if err != nil {
+			// Processes can exit between listing /proc and reading their stat
+			c.Logger().V(2).Info("Failed to read process stat", "pid", pid, "error", err)
+			continue
+		}
+		key := processKey{pid: proc.PID, startTime: startTicks}
+		cpuTimes[key] = proc.CPUTime
+
+		ticks, since := proc.CPUTime, now.Sub(proc.StartTime)
+		if prev, ok := c.prev[key]; ok && prev <= proc.CPUTime {
+			ticks, since = proc.CPUTime-prev, elapsed
+		}
+		if since > 0 {
+			proc.CPUPercent = 100 * (float64(ticks) / float64(c.host.ClockTicks)) / since.Seconds()
+		}
+		procs = append(procs, proc)
+	}
+	c.prev = cpuTimes
+	c.prevTime = now
+
+	sort.Slice(procs, func(i, j int) bool {
+		if procs[i].CPUPercent != procs[j].CPUPercent {
+			return procs[i].CPUPercent > procs[j].CPUPercent
+		}
+		return procs[i].PID < procs[j].PID
+	})
+	if len(procs) > c.limit {
+		procs = procs[:c.limit]
+	}
+	return procs, nil
+}
+
+// readProcess parses /proc/[pid]/stat. It also returns the start time in clock ticks
+// since boot, which identifies the process along with its PID.
+//
+// Format: pid (comm) state ppid pgrp session tty_nr tpgid flags minflt cminflt majflt
+// cmajflt utime stime cutime cstime priority nice num_threads itrealvalue starttime
+// vsize rss ...
+func (c *ProcessCollector) readProcess(pid int32) (performance.ProcessStats, uint64, error) {
+	data, err := os.ReadFile(filepath.Join(c.procPath, strconv.Itoa(int(pid)), "stat"))
+	if err != nil {
+		return performance.ProcessStats{}, 0, err
+	}
+	line := string(data)
+
+	open := strings.IndexByte(line, '(')
+	closing := strings.LastIndexByte(line, ')')
+	if open < 0 || closing < open {
+		return performance.ProcessStats{}, 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	// fields[0] is field 3 (state)
+	fields := strings.Fields(line[closing+1:])
+	if len(fields) < 22 {
+		return performance.ProcessStats{}, 0, fmt.Errorf("unexpected stat format for pid %d: got %d fields after comm", pid, len(fields))
+	}
+	// Parsed as signed since rss can briefly be negative while a process exits
+	var values [22]int64
+	for i := 1; i < len(values); i++ {
+		if values[i], err = strconv.ParseInt(fields[i], 10, 64); err != nil {
+			return performance.ProcessStats{}, 0, fmt.Errorf("failed to parse stat field %d %q: %w", i+3, fields[i], err)
+		}
+	}
+
+	startTicks := uint64(values[19])
+	return performance.ProcessStats{
+		PID:         pid,
+		PPID:        int32(values[1]),
+		PGID:        int32(values[2]),
+		SID:         int32(values[3]),
+		Command:     line[open+1 : closing],
+		State:       fields[0],
+		MinorFaults: uint64(values[7]),
+		MajorFaults: uint64(values[9]),
+		CPUTime:     uint64(values[11]) + uint64(values[12]),
+		Priority:    int32(values[15]),
+		Nice:        int32(values[16]),
+		Threads:     int32(values[17]),
+		StartTime:   c.host.BootTime.Add(time.Duration(startTicks) * time.Second / time.Duration(c.host.ClockTicks)),
+		MemoryVSZ:   uint64(values[20]),
+		MemoryRSS:   uint64(max(values[21], 0)) * c.host.PageSize,
+	}, startTicks, nil
+}
@@ -0,0 +1,251 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*ProcessStateCollector)(nil)
+
+// DefaultBlockedThreshold is how long a process has to stay in uninterruptible sleep
+// before it is counted as long blocked. Short D-state waits are normal for disk I/O.
+const DefaultBlockedThreshold = 30 * time.Second
+
+// ProcessStateCollector counts processes by scheduler state and lists zombie (Z) and
+// uninterruptible (D) processes with their kernel wait channel.
+//
+// Accumulating D-state processes usually indicate a storage or NFS problem and precede
+// node lockups, while accumulating zombies indicate a parent that doesn't reap its
+// children. Since /proc only reports the current state, the collector remembers when it
+// first saw each process in D or Z state and reports how long it has been stuck across
+// consecutive collections.
+//
+// Data sources:
+// - /proc/[pid]/stat: state, ppid, command and start time
+// - /proc/[pid]/wchan: kernel function a sleeping process is blocked in
+//
+// Only the main thread of each process is inspected.
+//
+// Reference: https://man7.org/linux/man-pages/man5/proc.5.html
+type ProcessStateCollector struct {
+	performance.BaseCollector
+	procPath string
+
+	mu sync.Mutex
+	// firstSeen tracks when a process was first seen in its current stuck state
+	firstSeen map[stuckKey]time.Time
+}
+
+// stuckKey identifies a process in a state. The start time guards against PID reuse.
+type stuckKey struct {
+	pid       int32
+	startTime uint64
+	state     string
+}
+
+func NewProcessStateCollector(logger logr.Logger, config performance.CollectionConfig) (*ProcessStateCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false, // wchan of other users' processes reads as 0 without root
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &ProcessStateCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeProcessState,
+			"Process State Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath:  config.HostProcPath,
+		firstSeen: make(map[stuckKey]time.Time),
+	}, nil
+}
+
+func (c *ProcessStateCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectProcessStates(ctx, time.Now())
+}
+
+func (c *ProcessStateCollector) collectProcessStates(ctx context.Context, now time.Time) (*performance.ProcessStateStats, error) {
+	entries, err := os.ReadDir(c.procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.procPath, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := &performance.ProcessStateStats{BlockedThreshold: DefaultBlockedThreshold}
+	seen := make(map[stuckKey]time.Time)
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		stat, err := c.readStat(int32(pid))
+		if err != nil {
+			// Processes can exit between listing /proc and reading their stat
+			c.Logger().V(2).Info("Failed to read process stat", "pid", pid, "error", err)
+			continue
+		}
+
+		stats.Total++
+		switch stat.state {
+		case "R":
+			stats.Running++
+		case "S":
+			stats.Sleeping++
+		case "D":
+			stats.Blocked++
+		case "Z":
+			stats.Zombie++
+		case "T", "t":
+			stats.Stopped++
+		case "I":
+			stats.Idle++
+		}
+
+		if stat.state != "D" && stat.state != "Z" {
+			continue
+		}
+
+		key := stuckKey{pid: stat.pid, startTime: stat.startTime, state: stat.state}
+		first, ok := c.firstSeen[key]
+		if !ok {
+			first = now
+		}
+		seen[key] = first
+
+		proc := performance.StuckProcess{
+			PID:      stat.pid,
+			PPID:     stat.ppid,
+			Command:  stat.command,
+			State:    stat.state,
+			Duration: now.Sub(first),
+		}
+		if stat.state == "D" {
+			proc.WaitChannel = c.readWaitChannel(stat.pid)
+			if proc.Duration >= DefaultBlockedThreshold {
+				stats.LongBlocked++
+			}
+			stats.BlockedProcs = append(stats.BlockedProcs, proc)
+		} else {
+			stats.Zombies = append(stats.Zombies, proc)
+		}
+	}
+
+	// Forget processes that exited or left the state
+	c.firstSeen = seen
+
+	sortStuck := func(procs []performance.StuckProcess) {
+		sort.Slice(procs, func(i, j int) bool {
+			if procs[i].Duration != procs[j].Duration {
+				return procs[i].Duration > procs[j].Duration
+			}
+			return procs[i].PID < procs[j].PID
+		})
+	}
+	sortStuck(stats.BlockedProcs)
+	sortStuck(stats.Zombies)
+
+	return stats, nil
+}
+
+type procStat struct {
+	pid       int32
+	ppid      int32
+	command   string
+	state     string
+	startTime uint64
+}
+
+// readStat parses the fields of /proc/[pid]/stat needed to identify a stuck process.
+//
+// Format: pid (comm) state ppid pgrp session ... starttime(22) ...
+//
+// comm can contain spaces and parentheses, so the fields after it are located from the
+// last ')' in the line.
+func (c *ProcessStateCollector) readStat(pid int32) (*procStat, error) {
+	return readProcStat(c.procPath, pid)
+}
+
+func readProcStat(procPath string, pid int32) (*procStat, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(int(pid)), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	line := string(data)
+
+	open := strings.IndexByte(line, '(')
+	closing := strings.LastIndexByte(line, ')')
+	if open < 0 || closing < open {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	// fields[0] is field 3 (state)
+	fields := strings.Fields(line[closing+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("unexpected stat format for pid %d: got %d fields after comm", pid, len(fields))
+	}
+
+	ppid, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ppid %q: %w", fields[1], err)
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse starttime %q: %w", fields[19], err)
+	}
+
+	return &procStat{
+		pid:       pid,
+		ppid:      int32(ppid),
+		command:   line[open+1 : closing],
+		state:     fields[0],
+		startTime: startTime,
+	}, nil
+}
+
+// readWaitChannel returns the symbol in /proc/[pid]/wchan. The kernel reports "0" when
+// the process isn't blocked or the address is hidden by kptr_restrict.
+func (c *ProcessStateCollector) readWaitChannel(pid int32) string {
+	wchan := procparse.ReadStringFile(filepath.Join(c.procPath, strconv.Itoa(int(pid)), "wchan"))
+	if wchan == "0" {
+		return ""
+	}
+	return wchan
+}
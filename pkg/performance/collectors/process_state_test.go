@@ -0,0 +1,134 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// procStatLine builds a /proc/[pid]/stat line with the given comm, state, ppid and starttime
+func procStatLine(pid int, comm, state string, ppid int, startTime uint64) string {
+	return fmt.Sprintf("%d (%s) %s %d %d %d 0 -1 4194560 100 0 0 0 10 5 0 0 20 0 1 0 %d 1000000 100 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n",
+		pid, comm, state, ppid, pid, pid, startTime)
+}
+
+func collectProcessStates(t *testing.T, collector *collectors.ProcessStateCollector) *performance.ProcessStateStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.ProcessStateStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestProcessStateCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewProcessStateCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "must be an absolute path")
+
+	_, err = collectors.NewProcessStateCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestProcessStateCollector_States(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{
+		"1/stat":     procStatLine(1, "systemd", "S", 0, 10),
+		"2/stat":     procStatLine(2, "kthreadd", "S", 0, 10),
+		"3/stat":     procStatLine(3, "rcu_gp", "I", 2, 10),
+		"100/stat":   procStatLine(100, "stress", "R", 1, 500),
+		"200/stat":   procStatLine(200, "defunct child", "Z", 100, 600),
+		"300/stat":   procStatLine(300, "postgres: (writer)", "D", 1, 700),
+		"300/wchan":  "io_schedule",
+		"301/stat":   procStatLine(301, "jbd2/sda1-8", "D", 2, 20),
+		"301/wchan":  "0",
+		"400/stat":   procStatLine(400, "gdb-target", "t", 1, 800),
+		"500/stat":   "malformed",
+		"self/stat":  procStatLine(1, "not a pid dir", "R", 0, 0),
+		"loadavg":    "0.00 0.00 0.00 1/100 1",
+		"999/status": "exited before stat was read",
+	})
+
+	collector, err := collectors.NewProcessStateCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	stats := collectProcessStates(t, collector)
+
+	assert.Equal(t, uint64(8), stats.Total)
+	assert.Equal(t, uint64(1), stats.Running)
+	assert.Equal(t, uint64(2), stats.Sleeping)
+	assert.Equal(t, uint64(2), stats.Blocked)
+	assert.Equal(t, uint64(1), stats.Zombie)
+	assert.Equal(t, uint64(1), stats.Stopped)
+	assert.Equal(t, uint64(1), stats.Idle)
+	assert.Equal(t, uint64(0), stats.LongBlocked)
+	assert.Equal(t, collectors.DefaultBlockedThreshold, stats.BlockedThreshold)
+
+	require.Len(t, stats.Zombies, 1)
+	assert.Equal(t, performance.StuckProcess{PID: 200, PPID: 100, Command: "defunct child", State: "Z"}, stats.Zombies[0])
+
+	require.Len(t, stats.BlockedProcs, 2)
+	assert.Equal(t, performance.StuckProcess{
+		PID: 300, PPID: 1, Command: "postgres: (writer)", State: "D", WaitChannel: "io_schedule",
+	}, stats.BlockedProcs[0])
+	// A wchan of 0 means the symbol is hidden
+	assert.Equal(t, "", stats.BlockedProcs[1].WaitChannel)
+}
+
+func TestProcessStateCollector_TracksDuration(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{
+		"300/stat": procStatLine(300, "dd", "D", 1, 700),
+		"301/stat": procStatLine(301, "sync", "D", 1, 710),
+	})
+
+	collector, err := collectors.NewProcessStateCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	first := collectProcessStates(t, collector)
+	require.Len(t, first.BlockedProcs, 2)
+	for _, p := range first.BlockedProcs {
+		assert.Zero(t, p.Duration)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// 301 left the D state and 300 was replaced by a new process with the same PID
+	writeSysFiles(t, procPath, map[string]string{
+		"300/stat": procStatLine(300, "dd", "D", 1, 900),
+		"301/stat": procStatLine(301, "sync", "S", 1, 710),
+	})
+	second := collectProcessStates(t, collector)
+	require.Len(t, second.BlockedProcs, 1)
+	assert.Zero(t, second.BlockedProcs[0].Duration, "reused PID must not inherit the previous duration")
+
+	time.Sleep(10 * time.Millisecond)
+
+	third := collectProcessStates(t, collector)
+	require.Len(t, third.BlockedProcs, 1)
+	assert.GreaterOrEqual(t, third.BlockedProcs[0].Duration, 10*time.Millisecond)
+}
+
+func TestProcessStateCollector_DeadlineExceeded(t *testing.T) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, map[string]string{
+		"1/stat": procStatLine(1, "systemd", "S", 0, 10),
+	})
+	collector, err := collectors.NewProcessStateCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,87 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processStatLine builds a /proc/[pid]/stat line with the given comm, CPU times in clock
+// ticks and starttime
+func processStatLine(pid int, comm string, utime, stime, startTime uint64) string {
+	return fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 100 0 7 0 %d %d 0 0 20 0 3 0 %d 1000000 25 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n",
+		pid, comm, pid, pid, utime, stime, startTime)
+}
+
+func collectProcesses(t *testing.T, collector *collectors.ProcessCollector) []performance.ProcessStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.([]performance.ProcessStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestProcessCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewProcessCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "must be an absolute path")
+}
+
+func TestProcessCollector_BusiestFirst(t *testing.T) {
+	procPath := t.TempDir()
+	files := map[string]string{
+		"stat":     "cpu  0 0 0 0 0 0 0 0 0 0\nbtime 1700000000\n",
+		"1/stat":   processStatLine(1, "init", 10, 10, 100),
+		"100/stat": processStatLine(100, "web server", 100, 50, 200),
+		"500/stat": "malformed",
+	}
+	writeSysFiles(t, procPath, files)
+	collector, err := collectors.NewProcessCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	procs := collectProcesses(t, collector)
+	require.Len(t, procs, 2)
+	assert.Equal(t, int32(100), procs[0].PID)
+	assert.Equal(t, "web server", procs[0].Command)
+	assert.Equal(t, uint64(150), procs[0].CPUTime)
+	assert.Equal(t, int32(3), procs[0].Threads)
+	assert.Equal(t, uint64(7), procs[0].MajorFaults)
+	assert.Equal(t, time.Unix(1700000002, 0), procs[0].StartTime)
+
+	// Only the web server used CPU since the previous collection
+	files["100/stat"] = processStatLine(100, "web server", 10100, 50, 200)
+	writeSysFiles(t, procPath, files)
+	procs = collectProcesses(t, collector)
+	require.Len(t, procs, 2)
+	assert.Equal(t, int32(100), procs[0].PID)
+	assert.Greater(t, procs[0].CPUPercent, 0.0)
+	assert.Equal(t, int32(1), procs[1].PID)
+	assert.Zero(t, procs[1].CPUPercent)
+}
+
+func TestProcessCollector_Limit(t *testing.T) {
+	procPath := t.TempDir()
+	files := map[string]string{"stat": "btime 1700000000\n"}
+	for pid := 1; pid <= collectors.DefaultProcessLimit+5; pid++ {
+		files[fmt.Sprintf("%d/stat", pid)] = processStatLine(pid, "worker", uint64(pid), 0, 0)
+	}
+	writeSysFiles(t, procPath, files)
+	collector, err := collectors.NewProcessCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+
+	procs := collectProcesses(t, collector)
+	require.Len(t, procs, collectors.DefaultProcessLimit)
+	assert.Equal(t, int32(collectors.DefaultProcessLimit+5), procs[0].PID)
+}
@@ -0,0 +1,64 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors/lockcontention"
+	"github.com/go-logr/logr"
+)
+
+// PointCollectorFactory creates a PointCollector
+type PointCollectorFactory func(logger logr.Logger, config performance.CollectionConfig) (performance.PointCollector, error)
+
+func pointFactory[T performance.PointCollector](
+	constructor func(logr.Logger, performance.CollectionConfig) (T, error),
+) PointCollectorFactory {
+	return func(logger logr.Logger, config performance.CollectionConfig) (performance.PointCollector, error) {
+		return constructor(logger, config)
+	}
+}
+
+// PointCollectorFactories returns the factories of all available point collectors keyed by
+// the metric type they collect.
+func PointCollectorFactories() map[performance.MetricType]PointCollectorFactory {
+	return map[performance.MetricType]PointCollectorFactory{
+		performance.MetricTypeLoad:            pointFactory(NewLoadCollector),
+		performance.MetricTypeCPU:             pointFactory(NewCPUCollector),
+		performance.MetricTypeProcess:         pointFactory(NewProcessCollector),
+		performance.MetricTypeDisk:            pointFactory(NewDiskCollector),
+		performance.MetricTypePower:           pointFactory(NewPowerCollector),
+		performance.MetricTypeProcessState:    pointFactory(NewProcessStateCollector),
+		performance.MetricTypeSwap:            pointFactory(NewSwapCollector),
+		performance.MetricTypeCertificate:     pointFactory(NewCertificateCollector),
+		performance.MetricTypeCostHints:       pointFactory(NewCostHintsCollector),
+		performance.MetricTypeKernel:          pointFactory(NewKernelCollector),
+		performance.MetricTypeKernelTaint:     pointFactory(NewKernelTaintCollector),
+		performance.MetricTypeNFS:             pointFactory(NewNFSCollector),
+		performance.MetricTypeNeighbor:        pointFactory(NewNeighborCollector),
+		performance.MetricTypeIPVS:            pointFactory(NewIPVSCollector),
+		performance.MetricTypeBoot:            pointFactory(NewBootCollector),
+		performance.MetricTypeCPUPerf:         pointFactory(NewCPUPerfCollector),
+		performance.MetricTypeNetworkInfo:     pointFactory(NewNetworkInfoCollector),
+		performance.MetricTypeSlab:            pointFactory(NewSlabCollector),
+		performance.MetricTypeCgroupCPU:       pointFactory(NewCgroupCPUCollector),
+		performance.MetricTypeTCPSockets:      pointFactory(NewTCPSocketsCollector),
+		performance.MetricTypeMemoryPressure:  pointFactory(NewMemoryPressureCollector),
+		performance.MetricTypeMemoryBandwidth: pointFactory(NewMemoryBandwidthCollector),
+		performance.MetricTypeTopology:        pointFactory(NewTopologyCollector),
+		performance.MetricTypeLockContention:  pointFactory(lockcontention.NewCollector),
+	}
+}
+
+// OptInCollectors returns the point collectors that only run when selected explicitly,
+// because they add overhead to the workloads of the host, with the reason
+func OptInCollectors() map[performance.MetricType]string {
+	return map[performance.MetricType]string{
+		performance.MetricTypeLockContention: "opt-in, traces every futex wait and contended " +
+			"kernel lock of the host",
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.StatefulCollector = (*SlabCollector)(nil)
+
+// slabTopCaches is the number of the largest slab caches reported
+const slabTopCaches = 20
+
+// SlabCollector reports the largest slab caches and the kernel allocation counters, to
+// tell which kernel allocations a growing Slab or SUnreclaim of MemoryStats are, e.g. a
+// dentry cache explosion from a workload creating millions of files, or nf_conntrack
+// growing with the connection tracking table.
+//
+// The collector remembers the size of every cache at the previous collection and reports
+// how fast each grows.
+//
+// Data sources:
+//   - /proc/slabinfo: objects and slabs of every slab cache. Only readable by root.
+//   - /proc/vmstat: nr_slab_reclaimable, nr_slab_unreclaimable, nr_kernel_misc_reclaimable,
+//     nr_kernel_stack and nr_page_table_pages
+//
+// Reference: https://man7.org/linux/man-pages/man5/slabinfo.5.html
+type SlabCollector struct {
+	performance.BaseCollector
+	procPath string
+	pageSize uint64
+
+	mu sync.Mutex
+	// Cache sizes of the previous collection used to compute growth
+	prevTime  time.Time
+	prevSizes map[string]uint64
+}
+
+func NewSlabCollector(logger logr.Logger, config performance.CollectionConfig) (*SlabCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       true, // /proc/slabinfo is mode 0400
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &SlabCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeSlab,
+			"Slab Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath: config.HostProcPath,
+		pageSize: uint64(os.Getpagesize()),
+	}, nil
+}
+
+func (c *SlabCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectSlabStats(ctx, time.Now())
+}
+
+func (c *SlabCollector) collectSlabStats(ctx context.Context, now time.Time) (*performance.SlabStats, error) {
+	caches, err := c.parseSlabinfo()
+	if err != nil {
+		return nil, err
+	}
+	vmstat, err := procparse.ParseKVFile(filepath.Join(c.procPath, "vmstat"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vmstat: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &performance.SlabStats{
+		TotalCaches:     len(caches),
+		Reclaimable:     vmstat["nr_slab_reclaimable"] * c.pageSize,
+		Unreclaimable:   vmstat["nr_slab_unreclaimable"] * c.pageSize,
+		MiscReclaimable: vmstat["nr_kernel_misc_reclaimable"] * c.pageSize,
+		KernelStack:     vmstat["nr_kernel_stack"] * 1024, // in kB
+		PageTables:      vmstat["nr_page_table_pages"] * c.pageSize,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sizes := make(map[string]uint64, len(caches))
+	elapsed := 0.0
+	if !c.prevTime.IsZero() {
+		elapsed = now.Sub(c.prevTime).Seconds()
+	}
+	for i := range caches {
+		cache := &caches[i]
+		stats.TotalSize += cache.Size
+		stats.TotalActiveSize += cache.ActiveSize
+		sizes[cache.Name] = cache.Size
+		if prev, ok := c.prevSizes[cache.Name]; ok && elapsed > 0 {
+			cache.Growth = (float64(cache.Size) - float64(prev)) / elapsed
+		}
+	}
+	c.prevTime, c.prevSizes = now, sizes
+
+	slices.SortFunc(caches, func(a, b performance.SlabCache) int {
+		if a.Size != b.Size {
+			return cmp.Compare(b.Size, a.Size)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	stats.Caches = caches[:min(len(caches), slabTopCaches)]
+	return stats, nil
+}
+
+// slabState is the persisted state of the SlabCollector
+type slabState struct {
+	Time  time.Time         `json:"time"`
+	Sizes map[string]uint64 `json:"sizes"`
+}
+
+func (c *SlabCollector) SaveState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prevTime.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(slabState{Time: c.prevTime, Sizes: c.prevSizes})
+}
+
+func (c *SlabCollector) RestoreState(data []byte) error {
+	var state slabState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prevTime = state.Time
+	c.prevSizes = state.Sizes
+	return nil
+}
+
+// parseSlabinfo parses /proc/slabinfo.
+//
+// Format (version 2.1):
+//
+//	slabinfo - version: 2.1
+//	# name            <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables <limit> <batchcount> <sharedfactor> : slabdata <active_slabs> <num_slabs> <sharedavail>
+//	dentry            182256 183393    192   21    1 : tunables    0    0    0 : slabdata   8733   8733      0
+func (c *SlabCollector) parseSlabinfo() ([]performance.SlabCache, error) {
+	path := filepath.Join(c.procPath, "slabinfo")
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var caches []performance.SlabCache
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "slabinfo") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 16 || fields[6] != ":" || fields[11] != ":" {
+			continue
+		}
+
+		var values [6]uint64
+		// active_objs num_objs objsize objperslab pagesperslab, then num_slabs
+		for i, field := range []string{fields[1], fields[2], fields[3], fields[4], fields[5], fields[14]} {
+			if values[i], err = strconv.ParseUint(field, 10, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			c.Logger().V(2).Info("Failed to parse slab cache", "cache", fields[0], "error", err)
+			continue
+		}
+		activeObjs, numObjs, objSize, pagesPerSlab, numSlabs := values[0], values[1], values[2], values[4], values[5]
+
+		caches = append(caches, performance.SlabCache{
+			Name:          fields[0],
+			ActiveObjects: activeObjs,
+			Objects:       numObjs,
+			ObjectSize:    objSize,
+			Size:          numSlabs * pagesPerSlab * c.pageSize,
+			ActiveSize:    activeObjs * objSize,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return caches, nil
+}
@@ -0,0 +1,163 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSlabinfo = `slabinfo - version: 2.1
+# name            <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables <limit> <batchcount> <sharedfactor> : slabdata <active_slabs> <num_slabs> <sharedavail>
+nf_conntrack        1200   1248    320   12    1 : tunables    0    0    0 : slabdata    104    104      0
+dentry            182256 183393    192   21    1 : tunables    0    0    0 : slabdata   8733   8733      0
+kmalloc-8k            40     48   8192    4    8 : tunables    0    0    0 : slabdata     12     12      0
+`
+
+const testSlabVmstat = `nr_free_pages 1000
+nr_slab_reclaimable 9000
+nr_slab_unreclaimable 3000
+nr_kernel_misc_reclaimable 10
+nr_kernel_stack 16384
+nr_page_table_pages 500
+`
+
+func createSlabCollector(t *testing.T, files map[string]string) (*collectors.SlabCollector, string) {
+	procPath := t.TempDir()
+	writeSysFiles(t, procPath, files)
+	collector, err := collectors.NewSlabCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	return collector, procPath
+}
+
+func collectSlabStats(t *testing.T, collector *collectors.SlabCollector) *performance.SlabStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.SlabStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestSlabCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewSlabCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewSlabCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+
+	collector, err := collectors.NewSlabCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/proc"})
+	require.NoError(t, err)
+	assert.True(t, collector.Capabilities().RequiresRoot)
+}
+
+func TestSlabCollector_Collect(t *testing.T) {
+	collector, _ := createSlabCollector(t, map[string]string{
+		"slabinfo": testSlabinfo,
+		"vmstat":   testSlabVmstat,
+	})
+	stats := collectSlabStats(t, collector)
+	page := uint64(os.Getpagesize())
+
+	assert.Equal(t, []performance.SlabCache{
+		{Name: "dentry", ActiveObjects: 182256, Objects: 183393, ObjectSize: 192, Size: 8733 * page, ActiveSize: 182256 * 192},
+		{Name: "nf_conntrack", ActiveObjects: 1200, Objects: 1248, ObjectSize: 320, Size: 104 * page, ActiveSize: 1200 * 320},
+		{Name: "kmalloc-8k", ActiveObjects: 40, Objects: 48, ObjectSize: 8192, Size: 12 * 8 * page, ActiveSize: 40 * 8192},
+	}, stats.Caches)
+	assert.Equal(t, 3, stats.TotalCaches)
+	assert.Equal(t, (8733+96+104)*page, stats.TotalSize)
+	assert.Equal(t, uint64(182256*192+40*8192+1200*320), stats.TotalActiveSize)
+
+	assert.Equal(t, 9000*page, stats.Reclaimable)
+	assert.Equal(t, 3000*page, stats.Unreclaimable)
+	assert.Equal(t, 10*page, stats.MiscReclaimable)
+	assert.Equal(t, uint64(16384*1024), stats.KernelStack)
+	assert.Equal(t, 500*page, stats.PageTables)
+}
+
+func TestSlabCollector_TopCaches(t *testing.T) {
+	var slabinfo strings.Builder
+	slabinfo.WriteString("slabinfo - version: 2.1\n")
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&slabinfo, "cache-%02d %d %d 64 64 1 : tunables 0 0 0 : slabdata %d %d 0\n", i, i*64, i*64, i, i)
+	}
+	collector, _ := createSlabCollector(t, map[string]string{
+		"slabinfo": slabinfo.String(),
+		"vmstat":   testSlabVmstat,
+	})
+	stats := collectSlabStats(t, collector)
+
+	require.Len(t, stats.Caches, 20)
+	assert.Equal(t, "cache-30", stats.Caches[0].Name)
+	assert.Equal(t, "cache-11", stats.Caches[19].Name)
+	assert.Equal(t, 30, stats.TotalCaches)
+	assert.Equal(t, uint64(465*os.Getpagesize()), stats.TotalSize, "totals include the caches not reported")
+}
+
+func TestSlabCollector_Growth(t *testing.T) {
+	collector, procPath := createSlabCollector(t, map[string]string{
+		"slabinfo": testSlabinfo,
+		"vmstat":   testSlabVmstat,
+	})
+	first := collectSlabStats(t, collector)
+	for _, cache := range first.Caches {
+		assert.Zero(t, cache.Growth, "growth needs a previous collection")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, procPath, map[string]string{"slabinfo": strings.ReplaceAll(testSlabinfo,
+		"slabdata    104    104", "slabdata    208    208")})
+
+	second := collectSlabStats(t, collector)
+	for _, cache := range second.Caches {
+		if cache.Name == "nf_conntrack" {
+			assert.Greater(t, cache.Growth, 0.0)
+			assert.LessOrEqual(t, cache.Growth, float64(104*os.Getpagesize())/0.05)
+		} else {
+			assert.Zero(t, cache.Growth)
+		}
+	}
+}
+
+func TestSlabCollector_RestoredState(t *testing.T) {
+	files := map[string]string{"slabinfo": testSlabinfo, "vmstat": testSlabVmstat}
+	collector, procPath := createSlabCollector(t, files)
+	collectSlabStats(t, collector)
+	state, err := collector.SaveState()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+
+	restarted, err := collectors.NewSlabCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: procPath})
+	require.NoError(t, err)
+	require.NoError(t, restarted.RestoreState(state))
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, procPath, map[string]string{"slabinfo": strings.ReplaceAll(testSlabinfo,
+		"slabdata   8733   8733", "slabdata   9733   9733")})
+	stats := collectSlabStats(t, restarted)
+	require.Equal(t, "dentry", stats.Caches[0].Name)
+	assert.Greater(t, stats.Caches[0].Growth, 0.0)
+}
+
+func TestSlabCollector_MissingFiles(t *testing.T) {
+	collector, _ := createSlabCollector(t, map[string]string{"vmstat": testSlabVmstat})
+	_, err := collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to open")
+
+	collector, _ = createSlabCollector(t, map[string]string{"slabinfo": testSlabinfo})
+	_, err = collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to read vmstat")
+}
@@ -0,0 +1,178 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sockDiagTimeout bounds each receive from the sock_diag socket
+	sockDiagTimeout = 5 * time.Second
+
+	// Sizes of struct inet_diag_req_v2 and struct inet_diag_msg
+	inetDiagReqV2Len = 56
+	inetDiagMsgLen   = 72
+
+	// INET_DIAG_INFO, the tcp_info of a socket, and the idiag_ext bit requesting it
+	inetDiagInfo    = 2
+	inetDiagInfoExt = 1 << (inetDiagInfo - 1)
+)
+
+// dumpTCPSockets returns the IPv4 and IPv6 TCP sockets of the agent's network namespace
+// whose state is in the states bitmask, 1 << state for each state, with their tcp_info.
+// The kernel filters the sockets by state, so sockets in other states cost nothing.
+//
+// Reference: https://man7.org/linux/man-pages/man7/sock_diag.7.html
+func dumpTCPSockets(states uint32) ([]tcpSocket, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sock_diag socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to bind sock_diag socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(sockDiagTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return nil, fmt.Errorf("failed to set sock_diag receive timeout: %w", err)
+	}
+
+	var sockets []tcpSocket
+	buf := make([]byte, 1<<16)
+	for seq, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		req := inetDiagRequest(uint32(seq+1), family, states)
+		if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+			return nil, fmt.Errorf("failed to send sock_diag request: %w", err)
+		}
+		for done := false; !done; {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to receive sock_diag reply: %w", err)
+			}
+			if sockets, done, err = parseInetDiagMessages(buf[:n], uint32(seq+1), sockets); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sockets, nil
+}
+
+// inetDiagRequest builds a SOCK_DIAG_BY_FAMILY dump request of the TCP sockets of family
+func inetDiagRequest(seq uint32, family uint8, states uint32) []byte {
+	length := unix.NLMSG_HDRLEN + inetDiagReqV2Len
+	msg := make([]byte, 0, length)
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(length))
+	msg = binary.NativeEndian.AppendUint16(msg, unix.SOCK_DIAG_BY_FAMILY)
+	msg = binary.NativeEndian.AppendUint16(msg, unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	msg = binary.NativeEndian.AppendUint32(msg, seq)
+	msg = binary.NativeEndian.AppendUint32(msg, 0) // port ID, assigned by the kernel
+	// inet_diag_req_v2: family, protocol, extensions, padding and states, followed by a
+	// zero inet_diag_sockid matching every socket
+	msg = append(msg, family, unix.IPPROTO_TCP, inetDiagInfoExt, 0)
+	msg = binary.NativeEndian.AppendUint32(msg, states)
+	return append(msg, make([]byte, inetDiagReqV2Len-8)...)
+}
+
+// parseInetDiagMessages appends the sockets of the inet_diag_msg replies to seq in b to
+// sockets. done is true once the end of the dump was reached.
+func parseInetDiagMessages(b []byte, seq uint32, sockets []tcpSocket) (_ []tcpSocket, done bool, err error) {
+	for len(b) >= unix.NLMSG_HDRLEN {
+		length := int(binary.NativeEndian.Uint32(b[0:4]))
+		typ := binary.NativeEndian.Uint16(b[4:6])
+		msgSeq := binary.NativeEndian.Uint32(b[8:12])
+		if length < unix.NLMSG_HDRLEN || length > len(b) {
+			return nil, false, errors.New("malformed netlink message")
+		}
+		body := b[unix.NLMSG_HDRLEN:length]
+		b = b[min(netlinkAlign(length), len(b)):]
+
+		if msgSeq != seq {
+			continue
+		}
+		switch typ {
+		case unix.NLMSG_DONE:
+			return sockets, true, nil
+		case unix.NLMSG_ERROR:
+			if len(body) < 4 {
+				return nil, false, errors.New("malformed netlink error")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(body[0:4])); errno != 0 {
+				return nil, false, fmt.Errorf("sock_diag dump failed: %w", syscall.Errno(-errno))
+			}
+			return sockets, true, nil
+		case unix.SOCK_DIAG_BY_FAMILY:
+			if s, ok := parseInetDiagMsg(body); ok {
+				sockets = append(sockets, s)
+			}
+		}
+	}
+	return sockets, false, nil
+}
+
+// parseInetDiagMsg parses a struct inet_diag_msg and its attributes
+func parseInetDiagMsg(b []byte) (tcpSocket, bool) {
+	if len(b) < inetDiagMsgLen {
+		return tcpSocket{}, false
+	}
+	s := tcpSocket{
+		state:     b[1],
+		cookie:    uint64(binary.NativeEndian.Uint32(b[44:48])) | uint64(binary.NativeEndian.Uint32(b[48:52]))<<32,
+		recvQueue: binary.NativeEndian.Uint32(b[56:60]),
+		sendQueue: binary.NativeEndian.Uint32(b[60:64]),
+		uid:       binary.NativeEndian.Uint32(b[64:68]),
+		inode:     binary.NativeEndian.Uint32(b[68:72]),
+	}
+	// inet_diag_sockid: ports and addresses in network byte order
+	sport, dport := binary.BigEndian.Uint16(b[4:6]), binary.BigEndian.Uint16(b[6:8])
+	switch b[0] {
+	case unix.AF_INET:
+		s.local = netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[8:12])), sport)
+		s.remote = netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[24:28])), dport)
+	case unix.AF_INET6:
+		s.local = netip.AddrPortFrom(netip.AddrFrom16([16]byte(b[8:24])).Unmap(), sport)
+		s.remote = netip.AddrPortFrom(netip.AddrFrom16([16]byte(b[24:40])).Unmap(), dport)
+	default:
+		return tcpSocket{}, false
+	}
+
+	if info, ok := parseNetlinkAttrs(b[inetDiagMsgLen:])[inetDiagInfo]; ok {
+		parseTCPInfo(info, &s)
+	}
+	return s, true
+}
+
+// parseTCPInfo parses the fields of a struct tcp_info. Older kernels return a shorter
+// struct, whose missing fields are left zero.
+func parseTCPInfo(b []byte, s *tcpSocket) {
+	u32 := func(offset int) uint32 {
+		if len(b) < offset+4 {
+			return 0
+		}
+		return binary.NativeEndian.Uint32(b[offset:])
+	}
+	u64 := func(offset int) uint64 {
+		if len(b) < offset+8 {
+			return 0
+		}
+		return binary.NativeEndian.Uint64(b[offset:])
+	}
+	s.hasInfo = len(b) >= 104
+	s.rtt = time.Duration(u32(68)) * time.Microsecond
+	s.rttVar = time.Duration(u32(72)) * time.Microsecond
+	s.sendCwnd = u32(80)
+	s.totalRetrans = u32(100)
+	s.bytesAcked = u64(120)
+	s.bytesReceived = u64(128)
+}
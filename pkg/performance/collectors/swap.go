@@ -0,0 +1,314 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.StatefulCollector = (*SwapCollector)(nil)
+
+// SwapCollector collects per-device swap usage, swap in/out activity and compressed swap
+// statistics from zram and zswap.
+//
+// Swap usage alone doesn't say whether a node is actively swapping: pages can sit in swap
+// long after the memory pressure that pushed them out is gone. The swap in/out rates are
+// what show a node thrashing, so the collector remembers the pswpin/pswpout counters of
+// the previous collection and reports the rate between the two.
+//
+// Data sources:
+// - /proc/swaps: active swap areas with size, usage and priority
+// - /proc/vmstat: pswpin, pswpout, zswpin and zswpout counters
+// - /proc/meminfo: Zswap and Zswapped pool sizes
+// - /sys/block/zram*/: zram disksize, comp_algorithm and mm_stat
+// - /sys/module/zswap/parameters/: zswap enabled, compressor and max_pool_percent
+//
+// Only /proc/vmstat is required. Kernels built without swap, zram or zswap support just
+// report no devices or a nil Zswap.
+//
+// Reference: https://www.kernel.org/doc/html/latest/admin-guide/blockdev/zram.html
+// Reference: https://www.kernel.org/doc/html/latest/admin-guide/mm/zswap.html
+type SwapCollector struct {
+	performance.BaseCollector
+	procPath  string
+	blockPath string
+	zswapPath string
+
+	mu sync.Mutex
+	// Counters from the previous collection used to compute rates
+	prevTime    time.Time
+	prevSwapIn  uint64
+	prevSwapOut uint64
+}
+
+func NewSwapCollector(logger logr.Logger, config performance.CollectionConfig) (*SwapCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostProcPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", config.HostProcPath)
+	}
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	if _, err := os.Stat(config.HostProcPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+
+	return &SwapCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeSwap,
+			"Swap Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		procPath:  config.HostProcPath,
+		blockPath: filepath.Join(config.HostSysPath, "block"),
+		zswapPath: filepath.Join(config.HostSysPath, "module", "zswap", "parameters"),
+	}, nil
+}
+
+func (c *SwapCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectSwapStats(ctx, time.Now())
+}
+
+func (c *SwapCollector) collectSwapStats(ctx context.Context, now time.Time) (*performance.SwapStats, error) {
+	vmstat, err := procparse.ParseKVFile(filepath.Join(c.procPath, "vmstat"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vmstat: %w", err)
+	}
+
+	devices, err := c.parseSwaps()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swaps: %w", err)
+	}
+
+	stats := &performance.SwapStats{
+		Devices:         devices,
+		PagesSwappedIn:  vmstat["pswpin"],
+		PagesSwappedOut: vmstat["pswpout"],
+		Zram:            c.collectZram(ctx),
+		Zswap:           c.collectZswap(vmstat),
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.prevTime.IsZero() {
+		if elapsed := now.Sub(c.prevTime).Seconds(); elapsed > 0 {
+			stats.SwapInRate = counterRate(c.prevSwapIn, stats.PagesSwappedIn, elapsed)
+			stats.SwapOutRate = counterRate(c.prevSwapOut, stats.PagesSwappedOut, elapsed)
+		}
+	}
+	c.prevTime = now
+	c.prevSwapIn = stats.PagesSwappedIn
+	c.prevSwapOut = stats.PagesSwappedOut
+
+	return stats, nil
+}
+
+// swapState is the persisted state of the SwapCollector
+type swapState struct {
+	Time    time.Time `json:"time"`
+	SwapIn  uint64    `json:"swapIn"`
+	SwapOut uint64    `json:"swapOut"`
+}
+
+func (c *SwapCollector) SaveState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prevTime.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(swapState{Time: c.prevTime, SwapIn: c.prevSwapIn, SwapOut: c.prevSwapOut})
+}
+
+func (c *SwapCollector) RestoreState(data []byte) error {
+	var state swapState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prevTime = state.Time
+	c.prevSwapIn = state.SwapIn
+	c.prevSwapOut = state.SwapOut
+	return nil
+}
+
+// counterRate returns the per second rate between two readings of a monotonic counter.
+// A counter that went backwards was reset, so no rate can be computed.
+func counterRate(prev, cur uint64, seconds float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / seconds
+}
+
+// parseSwaps parses /proc/swaps.
+//
+// Format:
+//
+//	Filename				Type		Size		Used		Priority
+//	/dev/zram0                              partition	8388604		0		100
+//
+// The kernel escapes whitespace in filenames as octal (e.g. \040), so fields never contain
+// spaces.
+func (c *SwapCollector) parseSwaps() ([]performance.SwapDevice, error) {
+	rows, err := procparse.ReadTable(filepath.Join(c.procPath, "swaps"), 1)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Kernel built without CONFIG_SWAP
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var devices []performance.SwapDevice
+	for _, fields := range rows {
+		if len(fields) < 5 {
+			continue
+		}
+
+		size, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			c.Logger().V(2).Info("Failed to parse swap size", "device", fields[0], "error", err)
+			continue
+		}
+		used, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			c.Logger().V(2).Info("Failed to parse swap usage", "device", fields[0], "error", err)
+			continue
+		}
+		priority, err := strconv.ParseInt(fields[4], 10, 32)
+		if err != nil {
+			c.Logger().V(2).Info("Failed to parse swap priority", "device", fields[0], "error", err)
+			continue
+		}
+
+		devices = append(devices, performance.SwapDevice{
+			Filename: fields[0],
+			Type:     fields[1],
+			Size:     size,
+			Used:     used,
+			Priority: int32(priority),
+		})
+	}
+	return devices, nil
+}
+
+func (c *SwapCollector) collectZram(ctx context.Context) []performance.ZramDevice {
+	entries, err := os.ReadDir(c.blockPath)
+	if err != nil {
+		return nil
+	}
+
+	var devices []performance.ZramDevice
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !strings.HasPrefix(entry.Name(), "zram") {
+			continue
+		}
+		dir := filepath.Join(c.blockPath, entry.Name())
+
+		dev := performance.ZramDevice{
+			Name:          entry.Name(),
+			CompAlgorithm: selectedOption(procparse.ReadStringFile(filepath.Join(dir, "comp_algorithm"))),
+		}
+		dev.DiskSize, _ = procparse.ReadUintFile(filepath.Join(dir, "disksize"))
+
+		// mm_stat: orig_data_size compr_data_size mem_used_total mem_limit mem_used_max
+		// same_pages pages_compacted [huge_pages huge_pages_since]
+		mmStat := strings.Fields(procparse.ReadStringFile(filepath.Join(dir, "mm_stat")))
+		fields := []*uint64{
+			&dev.OrigDataSize,
+			&dev.ComprDataSize,
+			&dev.MemUsedTotal,
+			&dev.MemLimit,
+			&dev.MemUsedMax,
+			&dev.SamePages,
+			&dev.PagesCompacted,
+		}
+		for i, field := range fields {
+			if i >= len(mmStat) {
+				break
+			}
+			*field, _ = strconv.ParseUint(mmStat[i], 10, 64)
+		}
+
+		devices = append(devices, dev)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+func (c *SwapCollector) collectZswap(vmstat map[string]uint64) *performance.ZswapStats {
+	if !exists(c.zswapPath) {
+		return nil
+	}
+
+	zswap := &performance.ZswapStats{
+		Enabled:         procparse.ReadStringFile(filepath.Join(c.zswapPath, "enabled")) == "Y",
+		Compressor:      procparse.ReadStringFile(filepath.Join(c.zswapPath, "compressor")),
+		PagesSwappedIn:  vmstat["zswpin"],
+		PagesSwappedOut: vmstat["zswpout"],
+	}
+	zswap.MaxPoolPercent, _ = procparse.ReadUintFile(filepath.Join(c.zswapPath, "max_pool_percent"))
+
+	meminfo, err := procparse.ParseKVFile(filepath.Join(c.procPath, "meminfo"))
+	if err != nil {
+		c.Logger().V(2).Info("Failed to read meminfo", "error", err)
+		return zswap
+	}
+	zswap.PoolSize = meminfo["Zswap"]
+	zswap.StoredSize = meminfo["Zswapped"]
+
+	return zswap
+}
+
+// selectedOption returns the option in brackets from a sysfs attribute listing the
+// available choices, e.g. "lzo lzo-rle [lz4] zstd" returns "lz4". Attributes that only
+// contain a single value are returned as is.
+func selectedOption(s string) string {
+	start := strings.IndexByte(s, '[')
+	end := strings.IndexByte(s, ']')
+	if start < 0 || end < start {
+		return s
+	}
+	return s[start+1 : end]
+}
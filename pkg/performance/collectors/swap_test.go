@@ -0,0 +1,186 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSwaps = `Filename				Type		Size		Used		Priority
+/dev/zram0                              partition	4194300		1024		100
+/swap\040file                           file		2097148		0		-2
+`
+
+func createSwapCollector(t *testing.T, procFiles, sysFiles map[string]string) (*collectors.SwapCollector, string) {
+	root := t.TempDir()
+	procPath := filepath.Join(root, "proc")
+	sysPath := filepath.Join(root, "sys")
+	writeSysFiles(t, procPath, procFiles)
+	writeSysFiles(t, sysPath, sysFiles)
+
+	collector, err := collectors.NewSwapCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostSysPath:  sysPath,
+	})
+	require.NoError(t, err)
+	return collector, procPath
+}
+
+func collectSwapStats(t *testing.T, collector *collectors.SwapCollector) *performance.SwapStats {
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.SwapStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestSwapCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewSwapCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "relative", HostSysPath: "/sys"})
+	assert.ErrorContains(t, err, "HostProcPath must be an absolute path")
+
+	_, err = collectors.NewSwapCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/proc", HostSysPath: "relative"})
+	assert.ErrorContains(t, err, "HostSysPath must be an absolute path")
+
+	_, err = collectors.NewSwapCollector(logr.Discard(), performance.CollectionConfig{HostProcPath: "/non/existent/path/that/should/not/exist", HostSysPath: "/sys"})
+	assert.ErrorContains(t, err, "HostProcPath validation failed")
+}
+
+func TestSwapCollector_MissingVmstat(t *testing.T) {
+	collector, _ := createSwapCollector(t, map[string]string{"swaps": testSwaps}, nil)
+	_, err := collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "failed to read vmstat")
+}
+
+func TestSwapCollector_NoSwap(t *testing.T) {
+	collector, _ := createSwapCollector(t, map[string]string{
+		"vmstat": "nr_free_pages 1000\npswpin 0\npswpout 0\n",
+	}, nil)
+	stats := collectSwapStats(t, collector)
+
+	assert.Empty(t, stats.Devices)
+	assert.Empty(t, stats.Zram)
+	assert.Nil(t, stats.Zswap)
+	assert.Zero(t, stats.PagesSwappedIn)
+	assert.Zero(t, stats.PagesSwappedOut)
+}
+
+func TestSwapCollector_Devices(t *testing.T) {
+	collector, _ := createSwapCollector(t, map[string]string{
+		"swaps":   testSwaps,
+		"vmstat":  "pswpin 120\npswpout 450\nzswpin 10\nzswpout 30\n",
+		"meminfo": "MemTotal:       16384000 kB\nZswap:              2048 kB\nZswapped:           8192 kB\n",
+	}, map[string]string{
+		"block/zram0/disksize":       "4294967296\n",
+		"block/zram0/comp_algorithm": "lzo lzo-rle [lz4] zstd\n",
+		"block/zram0/mm_stat":        "1048576 262144 327680 0 393216 12 3 0 0\n",
+		"block/sda/size":             "1000\n",
+
+		"module/zswap/parameters/enabled":          "Y\n",
+		"module/zswap/parameters/compressor":       "zstd\n",
+		"module/zswap/parameters/max_pool_percent": "20\n",
+	})
+	stats := collectSwapStats(t, collector)
+
+	assert.Equal(t, []performance.SwapDevice{
+		{Filename: "/dev/zram0", Type: "partition", Size: 4194300, Used: 1024, Priority: 100},
+		{Filename: `/swap\040file`, Type: "file", Size: 2097148, Used: 0, Priority: -2},
+	}, stats.Devices)
+	assert.Equal(t, uint64(120), stats.PagesSwappedIn)
+	assert.Equal(t, uint64(450), stats.PagesSwappedOut)
+	assert.Zero(t, stats.SwapInRate, "rates need a previous collection")
+	assert.Zero(t, stats.SwapOutRate, "rates need a previous collection")
+
+	assert.Equal(t, []performance.ZramDevice{{
+		Name:           "zram0",
+		CompAlgorithm:  "lz4",
+		DiskSize:       4294967296,
+		OrigDataSize:   1048576,
+		ComprDataSize:  262144,
+		MemUsedTotal:   327680,
+		MemUsedMax:     393216,
+		SamePages:      12,
+		PagesCompacted: 3,
+	}}, stats.Zram)
+
+	assert.Equal(t, &performance.ZswapStats{
+		Enabled:         true,
+		Compressor:      "zstd",
+		MaxPoolPercent:  20,
+		PoolSize:        2048,
+		StoredSize:      8192,
+		PagesSwappedIn:  10,
+		PagesSwappedOut: 30,
+	}, stats.Zswap)
+}
+
+func TestSwapCollector_Rates(t *testing.T) {
+	collector, procPath := createSwapCollector(t, map[string]string{
+		"vmstat": "pswpin 100\npswpout 100\n",
+	}, nil)
+
+	first := collectSwapStats(t, collector)
+	assert.Zero(t, first.SwapInRate)
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, procPath, map[string]string{"vmstat": "pswpin 200\npswpout 100\n"})
+
+	second := collectSwapStats(t, collector)
+	assert.Greater(t, second.SwapInRate, 0.0)
+	assert.LessOrEqual(t, second.SwapInRate, 100/0.05)
+	assert.Zero(t, second.SwapOutRate)
+
+	// Counters going backwards were reset and must not produce a rate
+	writeSysFiles(t, procPath, map[string]string{"vmstat": "pswpin 5\npswpout 5\n"})
+	third := collectSwapStats(t, collector)
+	assert.Zero(t, third.SwapInRate)
+	assert.Zero(t, third.SwapOutRate)
+}
+
+func TestSwapCollector_Cancelled(t *testing.T) {
+	collector, _ := createSwapCollector(t, map[string]string{
+		"vmstat": "pswpin 0\npswpout 0\n",
+		"swaps":  testSwaps,
+	}, map[string]string{
+		"block/zram0/disksize": "4294967296\n",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := collector.Collect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSwapCollector_RestoredState(t *testing.T) {
+	files := map[string]string{"swaps": testSwaps, "vmstat": "pswpin 100\npswpout 0\n"}
+	collector, procPath := createSwapCollector(t, files, nil)
+	collectSwapStats(t, collector)
+	state, err := collector.SaveState()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+
+	// A new collector, e.g. after an agent restart, computes rates from the restored state
+	restarted, err := collectors.NewSwapCollector(logr.Discard(), performance.CollectionConfig{
+		HostProcPath: procPath,
+		HostSysPath:  "/sys",
+	})
+	require.NoError(t, err)
+	require.NoError(t, restarted.RestoreState(state))
+
+	time.Sleep(50 * time.Millisecond)
+	writeSysFiles(t, procPath, map[string]string{"vmstat": "pswpin 200\npswpout 0\n"})
+	stats := collectSwapStats(t, restarted)
+	assert.Greater(t, stats.SwapInRate, 0.0)
+}
@@ -0,0 +1,263 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*TCPSocketsCollector)(nil)
+
+// tcpSocketsSlowest is the number of the sampled sockets with the longest RTT reported
+const tcpSocketsSlowest = 50
+
+// tcpStates are the TCP states of include/net/tcp_states.h by name
+var tcpStates = map[string]uint8{
+	"ESTABLISHED":  1,
+	"SYN_SENT":     2,
+	"SYN_RECV":     3,
+	"FIN_WAIT1":    4,
+	"FIN_WAIT2":    5,
+	"TIME_WAIT":    6,
+	"CLOSE":        7,
+	"CLOSE_WAIT":   8,
+	"LAST_ACK":     9,
+	"LISTEN":       10,
+	"CLOSING":      11,
+	"NEW_SYN_RECV": 12,
+}
+
+// tcpStateName returns the name of a TCP state
+func tcpStateName(state uint8) string {
+	for name, s := range tcpStates {
+		if s == state {
+			return name
+		}
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", state)
+}
+
+// tcpSocket is a TCP socket as reported by sock_diag
+type tcpSocket struct {
+	cookie        uint64
+	state         uint8
+	local, remote netip.AddrPort
+	uid, inode    uint32
+	recvQueue     uint32
+	sendQueue     uint32
+	// Whether the tcp_info of the socket was reported
+	hasInfo       bool
+	rtt, rttVar   time.Duration
+	sendCwnd      uint32
+	totalRetrans  uint32
+	bytesAcked    uint64
+	bytesReceived uint64
+}
+
+// TCPSocketsCollector samples the TCP sockets of the agent's network namespace with
+// sock_diag. Unlike /proc/net/tcp, which formats every socket of the namespace as text,
+// sock_diag filters the sockets by state in the kernel and reports the tcp_info of each
+// socket, so it stays cheap on hosts with millions of connections and reports the round
+// trip time and congestion window of every socket.
+//
+// Sockets are filtered by state in the kernel and by local or remote port in the agent,
+// and 1 in SampleRate of them is sampled by a hash of its cookie, so a socket is either
+// sampled at every collection or never. The collector remembers the byte counters of the
+// sampled sockets to compute their throughput.
+//
+// Only the sockets of the network namespace the agent runs in are visible, those of the
+// host when the agent runs with hostNetwork.
+//
+// Data sources:
+//   - NETLINK_SOCK_DIAG: inet_diag_msg and the INET_DIAG_INFO tcp_info of every socket
+//
+// Reference: https://man7.org/linux/man-pages/man7/sock_diag.7.html
+type TCPSocketsCollector struct {
+	performance.BaseCollector
+	// Bitmask of the states dumped, 1 << state for each state
+	states     uint32
+	ports      map[uint16]bool
+	sampleRate uint64
+	// dump returns the sockets in the states of the bitmask
+	dump func(states uint32) ([]tcpSocket, error)
+
+	mu sync.Mutex
+	// Sampled sockets of the previous collection by cookie
+	prevTime    time.Time
+	prevSockets map[uint64]tcpSocket
+}
+
+func NewTCPSocketsCollector(logger logr.Logger, config performance.CollectionConfig) (*TCPSocketsCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "4.1.0", // Socket cookies
+	}
+
+	var states uint32
+	for _, name := range config.TCPSocketStates {
+		state, ok := tcpStates[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown TCP state %q", name)
+		}
+		states |= 1 << state
+	}
+	if states == 0 {
+		for _, state := range tcpStates {
+			states |= 1 << state
+		}
+	}
+	var ports map[uint16]bool
+	if len(config.TCPSocketPorts) > 0 {
+		ports = make(map[uint16]bool, len(config.TCPSocketPorts))
+		for _, port := range config.TCPSocketPorts {
+			ports[port] = true
+		}
+	}
+
+	return &TCPSocketsCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeTCPSockets,
+			"TCP Sockets Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		states:     states,
+		ports:      ports,
+		sampleRate: max(1, config.TCPSocketSampleRate),
+		dump:       dumpTCPSockets,
+	}, nil
+}
+
+func (c *TCPSocketsCollector) Collect(ctx context.Context) (any, error) {
+	return c.collectTCPSocketStats(ctx, time.Now())
+}
+
+func (c *TCPSocketsCollector) collectTCPSocketStats(ctx context.Context, now time.Time) (*performance.TCPSocketStats, error) {
+	sockets, err := c.dump(c.states)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := &performance.TCPSocketStats{
+		ByState:    make(map[string]uint64),
+		SampleRate: c.sampleRate,
+	}
+	if !c.prevTime.IsZero() {
+		stats.Interval = now.Sub(c.prevTime)
+	}
+	current := make(map[uint64]tcpSocket)
+	var sampled []performance.TCPSocket
+	var rttSum time.Duration
+	var rttCount int64
+	for _, s := range sockets {
+		if c.ports != nil && !c.ports[s.local.Port()] && !c.ports[s.remote.Port()] {
+			continue
+		}
+		stats.Sockets++
+		stats.ByState[tcpStateName(s.state)]++
+		if !sampleCookie(s.cookie, c.sampleRate) {
+			continue
+		}
+		// A dump isn't atomic, a socket changing state during it can be seen twice
+		if _, ok := current[s.cookie]; ok {
+			continue
+		}
+		current[s.cookie] = s
+		stats.Sampled++
+
+		socket := performance.TCPSocket{
+			Cookie:        s.cookie,
+			Local:         s.local.String(),
+			Remote:        s.remote.String(),
+			State:         tcpStateName(s.state),
+			UID:           s.uid,
+			Inode:         s.inode,
+			RecvQueue:     s.recvQueue,
+			SendQueue:     s.sendQueue,
+			RTT:           s.rtt,
+			RTTVar:        s.rttVar,
+			SendCwnd:      s.sendCwnd,
+			TotalRetrans:  s.totalRetrans,
+			BytesAcked:    s.bytesAcked,
+			BytesReceived: s.bytesReceived,
+		}
+		if s.hasInfo && s.rtt > 0 {
+			rttSum += s.rtt
+			rttCount++
+		}
+
+		prev, ok := c.prevSockets[s.cookie]
+		if !ok {
+			if c.prevSockets != nil {
+				stats.Opened++
+			}
+		} else if stats.Interval > 0 {
+			if s.bytesAcked >= prev.bytesAcked {
+				socket.SendBytesPerSec = float64(s.bytesAcked-prev.bytesAcked) / stats.Interval.Seconds()
+			}
+			if s.bytesReceived >= prev.bytesReceived {
+				socket.RecvBytesPerSec = float64(s.bytesReceived-prev.bytesReceived) / stats.Interval.Seconds()
+			}
+		}
+		sampled = append(sampled, socket)
+	}
+	for cookie := range c.prevSockets {
+		if _, ok := current[cookie]; !ok {
+			stats.Closed++
+		}
+	}
+	c.prevTime, c.prevSockets = now, current
+
+	if rttCount > 0 {
+		stats.MeanRTT = rttSum / time.Duration(rttCount)
+	}
+	slices.SortFunc(sampled, func(a, b performance.TCPSocket) int {
+		if a.RTT != b.RTT {
+			return cmp.Compare(b.RTT, a.RTT)
+		}
+		return cmp.Compare(a.Cookie, b.Cookie)
+	})
+	stats.Slowest = sampled[:min(len(sampled), tcpSocketsSlowest)]
+	return stats, nil
+}
+
+// sampleCookie returns whether the socket with cookie is one of the 1 in rate sampled.
+// Cookies are allocated in sequence, so they are mixed first not to sample the sockets
+// opened at a regular interval.
+func sampleCookie(cookie, rate uint64) bool {
+	if rate <= 1 {
+		return true
+	}
+	// splitmix64 finalizer
+	cookie ^= cookie >> 30
+	cookie *= 0xbf58476d1ce4e5b9
+	cookie ^= cookie >> 27
+	cookie *= 0x94d049bb133111eb
+	cookie ^= cookie >> 31
+	return cookie%rate == 0
+}
@@ -0,0 +1,123 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build linux
+
+package collectors_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectTCPSocketStats(t *testing.T, collector *collectors.TCPSocketsCollector) *performance.TCPSocketStats {
+	result, err := collector.Collect(context.Background())
+	if err != nil {
+		t.Skipf("sock_diag not available: %v", err)
+	}
+	stats, ok := result.(*performance.TCPSocketStats)
+	require.True(t, ok)
+	return stats
+}
+
+func TestTCPSocketsCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewTCPSocketsCollector(logr.Discard(), performance.CollectionConfig{
+		TCPSocketStates: []string{"ESTABLISHED", "BOGUS"},
+	})
+	assert.ErrorContains(t, err, `unknown TCP state "BOGUS"`)
+
+	_, err = collectors.NewTCPSocketsCollector(logr.Discard(), performance.CollectionConfig{
+		TCPSocketStates: []string{"established", "listen"},
+	})
+	assert.NoError(t, err)
+}
+
+func TestTCPSocketsCollector_Collect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	collector, err := collectors.NewTCPSocketsCollector(logr.Discard(), performance.CollectionConfig{
+		TCPSocketStates: []string{"ESTABLISHED", "LISTEN"},
+		TCPSocketPorts:  []uint16{port},
+	})
+	require.NoError(t, err)
+
+	stats := collectTCPSocketStats(t, collector)
+	assert.Equal(t, uint64(3), stats.Sockets)
+	assert.Equal(t, map[string]uint64{"ESTABLISHED": 2, "LISTEN": 1}, stats.ByState)
+	assert.Equal(t, uint64(1), stats.SampleRate)
+	assert.Equal(t, uint64(3), stats.Sampled)
+	assert.Zero(t, stats.Opened, "the first collection has nothing to compare with")
+	require.Len(t, stats.Slowest, 3)
+	for _, s := range stats.Slowest {
+		assert.NotZero(t, s.Cookie)
+		if s.State == "ESTABLISHED" {
+			assert.NotZero(t, s.RTT)
+			assert.NotZero(t, s.SendCwnd)
+		}
+	}
+
+	_, err = client.Write(make([]byte, 4096))
+	require.NoError(t, err)
+	_, err = io.ReadFull(server, make([]byte, 4096))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	stats = collectTCPSocketStats(t, collector)
+	assert.Positive(t, stats.Interval)
+	assert.Zero(t, stats.Opened+stats.Closed)
+	var sent bool
+	for _, s := range stats.Slowest {
+		if s.Local == client.LocalAddr().String() {
+			assert.GreaterOrEqual(t, s.BytesAcked, uint64(4096))
+			sent = s.SendBytesPerSec > 0
+		}
+	}
+	assert.True(t, sent, "expected the throughput of the client socket")
+
+	// Closing the connection moves both of its sockets out of ESTABLISHED
+	server.Close()
+	time.Sleep(10 * time.Millisecond)
+	stats = collectTCPSocketStats(t, collector)
+	assert.Equal(t, uint64(2), stats.Closed)
+	assert.Equal(t, map[string]uint64{"LISTEN": 1}, stats.ByState)
+
+	// Sampling is stable across collections
+	sampled, err := collectors.NewTCPSocketsCollector(logr.Discard(), performance.CollectionConfig{
+		TCPSocketPorts:      []uint16{port},
+		TCPSocketSampleRate: 2,
+	})
+	require.NoError(t, err)
+	first := collectTCPSocketStats(t, sampled)
+	second := collectTCPSocketStats(t, sampled)
+	assert.LessOrEqual(t, first.Sampled, first.Sockets)
+	assert.Equal(t, first.Sampled, second.Sampled)
+	assert.Zero(t, second.Opened+second.Closed)
+}
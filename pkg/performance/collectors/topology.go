@@ -0,0 +1,246 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/procparse"
+	"github.com/go-logr/logr"
+)
+
+// Compile-time interface check
+var _ performance.PointCollector = (*TopologyCollector)(nil)
+
+// topologyDeviceClasses are the PCI base classes of the devices reported by the topology
+// collector: those pods are given, or whose locality matters to them
+var topologyDeviceClasses = map[string]bool{
+	"0x01": true, // Mass storage controller
+	"0x02": true, // Network controller
+	"0x03": true, // Display controller, e.g. GPUs
+	"0x0b": true, // Processor, e.g. co-processors
+	"0x12": true, // Processing accelerator
+}
+
+// pciAddressRegexp matches a PCI address, e.g. 0000:3b:00.0
+var pciAddressRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// TopologyCollector collects the NUMA topology of the host: the CPUs, memory and
+// distances of each NUMA node and the NUMA node and local CPUs of each PCI device. These
+// are the hints the kubelet's Topology Manager aligns the CPUs and devices of a pod
+// with, so schedulers and capacity tools can reason about NUMA-affine placement.
+//
+// Data sources:
+//   - /sys/devices/system/node/node*/{cpulist,meminfo,distance}: NUMA nodes
+//   - /sys/bus/pci/devices/*/{class,vendor,device,numa_node,local_cpulist,driver}: PCI
+//     devices
+//   - /sys/class/net/* and /sys/class/block/*: interfaces and disks of each device
+//
+// Reference: https://docs.kernel.org/admin-guide/mm/numaperf.html
+// Reference: https://kubernetes.io/docs/tasks/administer-cluster/topology-manager/
+type TopologyCollector struct {
+	performance.BaseCollector
+	nodePath  string
+	pciPath   string
+	netPath   string
+	blockPath string
+}
+
+func NewTopologyCollector(logger logr.Logger, config performance.CollectionConfig) (*TopologyCollector, error) {
+	capabilities := performance.CollectorCapabilities{
+		SupportsOneShot:    true,
+		SupportsContinuous: false,
+		RequiresRoot:       false,
+		RequiresEBPF:       false,
+		MinKernelVersion:   "2.6.0",
+	}
+
+	if !filepath.IsAbs(config.HostSysPath) {
+		return nil, fmt.Errorf("HostSysPath must be an absolute path, got: %q", config.HostSysPath)
+	}
+
+	if _, err := os.Stat(config.HostSysPath); err != nil {
+		return nil, fmt.Errorf("HostSysPath validation failed: %w", err)
+	}
+
+	return &TopologyCollector{
+		BaseCollector: performance.NewBaseCollector(
+			performance.MetricTypeTopology,
+			"Topology Collector",
+			logger,
+			config,
+			capabilities,
+		),
+		nodePath:  filepath.Join(config.HostSysPath, "devices", "system", "node"),
+		pciPath:   filepath.Join(config.HostSysPath, "bus", "pci", "devices"),
+		netPath:   filepath.Join(config.HostSysPath, "class", "net"),
+		blockPath: filepath.Join(config.HostSysPath, "class", "block"),
+	}, nil
+}
+
+func (c *TopologyCollector) Collect(ctx context.Context) (any, error) {
+	nodes, err := c.readNodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &performance.TopologyStats{
+		Nodes:   nodes,
+		Devices: c.readDevices(),
+	}, nil
+}
+
+// readNodes reads the NUMA nodes. A kernel without NUMA support has no node directory.
+func (c *TopologyCollector) readNodes() ([]performance.NUMANode, error) {
+	entries, err := os.ReadDir(c.nodePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.nodePath, err)
+	}
+
+	var nodes []performance.NUMANode
+	for _, entry := range entries {
+		idStr, ok := strings.CutPrefix(entry.Name(), "node")
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(c.nodePath, entry.Name())
+		node := performance.NUMANode{ID: id}
+		// Memory-only nodes have no CPUs
+		node.CPUs, _ = procparse.ParseCPUList(procparse.ReadStringFile(filepath.Join(dir, "cpulist")))
+		node.MemoryBytes = readNodeMemTotal(filepath.Join(dir, "meminfo"))
+		for _, field := range strings.Fields(procparse.ReadStringFile(filepath.Join(dir, "distance"))) {
+			distance, err := strconv.Atoi(field)
+			if err != nil {
+				break
+			}
+			node.Distances = append(node.Distances, distance)
+		}
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(a, b performance.NUMANode) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return nodes, nil
+}
+
+// readNodeMemTotal returns the MemTotal of a node meminfo, whose lines are prefixed with
+// the node, e.g. "Node 0 MemTotal:       32801792 kB"
+func readNodeMemTotal(path string) uint64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
+
+// readDevices reads the PCI devices of topologyDeviceClasses
+func (c *TopologyCollector) readDevices() []performance.PCIDevice {
+	entries, err := os.ReadDir(c.pciPath)
+	if err != nil {
+		// No PCI bus, e.g. some VMs and ARM boards
+		return nil
+	}
+	interfaces := classDevices(c.netPath, nil)
+	blockDevices := classDevices(c.blockPath, func(dir string) bool {
+		_, err := os.Stat(filepath.Join(dir, "partition"))
+		return err != nil
+	})
+
+	var devices []performance.PCIDevice
+	for _, entry := range entries {
+		dir := filepath.Join(c.pciPath, entry.Name())
+		class := procparse.ReadStringFile(filepath.Join(dir, "class"))
+		if len(class) < 4 || !topologyDeviceClasses[class[:4]] {
+			continue
+		}
+		device := performance.PCIDevice{
+			Address:      entry.Name(),
+			Class:        class,
+			Vendor:       procparse.ReadStringFile(filepath.Join(dir, "vendor")),
+			Device:       procparse.ReadStringFile(filepath.Join(dir, "device")),
+			NUMANode:     -1,
+			Interfaces:   interfaces[entry.Name()],
+			BlockDevices: blockDevices[entry.Name()],
+		}
+		if driver, err := os.Readlink(filepath.Join(dir, "driver")); err == nil {
+			device.Driver = filepath.Base(driver)
+		}
+		if node, err := strconv.Atoi(procparse.ReadStringFile(filepath.Join(dir, "numa_node"))); err == nil {
+			device.NUMANode = node
+		}
+		device.LocalCPUs, _ = procparse.ParseCPUList(procparse.ReadStringFile(filepath.Join(dir, "local_cpulist")))
+		devices = append(devices, device)
+	}
+	slices.SortFunc(devices, func(a, b performance.PCIDevice) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	return devices
+}
+
+// classDevices returns the devices of the sysfs class directory classPath accepted by
+// include, if set, by the address of the PCI device they belong to. A device belongs
+// to the closest PCI device in the path its class link resolves to, e.g. eth0 to
+// 0000:00:05.0 for devices/pci0000:00/0000:00:05.0/virtio1/net/eth0.
+func classDevices(classPath string, include func(dir string) bool) map[string][]string {
+	entries, err := os.ReadDir(classPath)
+	if err != nil {
+		return nil
+	}
+	devices := make(map[string][]string)
+	for _, entry := range entries {
+		dir, err := filepath.EvalSymlinks(filepath.Join(classPath, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if include != nil && !include(dir) {
+			continue
+		}
+		for path := filepath.Dir(dir); path != filepath.Dir(path); path = filepath.Dir(path) {
+			if address := filepath.Base(path); pciAddressRegexp.MatchString(address) {
+				devices[address] = append(devices[address], entry.Name())
+				break
+			}
+		}
+	}
+	for _, names := range devices {
+		slices.Sort(names)
+	}
+	return devices
+}
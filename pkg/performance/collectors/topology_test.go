@@ -0,0 +1,139 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package collectors_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTopologyFiles is a two socket host with a NIC and an NVMe disk on node 1 and a
+// GPU without NUMA affinity
+var testTopologyFiles = map[string]string{
+	"devices/system/node/node0/cpulist":  "0-3,8-11\n",
+	"devices/system/node/node0/meminfo":  "Node 0 MemTotal:       32801792 kB\nNode 0 MemFree:        1000 kB\n",
+	"devices/system/node/node0/distance": "10 21\n",
+	"devices/system/node/node1/cpulist":  "4-7,12-15\n",
+	"devices/system/node/node1/meminfo":  "Node 1 MemTotal:       33017380 kB\n",
+	"devices/system/node/node1/distance": "21 10\n",
+	"devices/system/node/possible":       "0-1\n",
+
+	"devices/pci0000:00/0000:00:00.0/class":                                               "0x060000\n", // Host bridge
+	"devices/pci0000:00/0000:00:02.0/class":                                               "0x030000\n",
+	"devices/pci0000:00/0000:00:02.0/vendor":                                              "0x10de\n",
+	"devices/pci0000:00/0000:00:02.0/device":                                              "0x20b5\n",
+	"devices/pci0000:00/0000:00:02.0/numa_node":                                           "-1\n",
+	"devices/pci0000:00/0000:00:02.0/local_cpulist":                                       "0-15\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/class":                                  "0x020000\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/vendor":                                 "0x8086\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/device":                                 "0x1593\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/numa_node":                              "1\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/local_cpulist":                          "4-7,12-15\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/net/eth0/address":                       "02:00:00:00:00:01\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/class":                                  "0x010802\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/vendor":                                 "0x144d\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/device":                                 "0xa808\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/numa_node":                              "1\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/local_cpulist":                          "4-7,12-15\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/nvme/nvme0/nvme0n1/size":                "1000\n",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/nvme/nvme0/nvme0n1/nvme0n1p1/partition": "1\n",
+	"devices/virtual/net/lo/address":                                                      "00:00:00:00:00:00\n",
+}
+
+// testTopologyLinks are the symlinks of sysfs, relative to its root
+var testTopologyLinks = map[string]string{
+	"bus/pci/devices/0000:00:00.0":                        "../../../devices/pci0000:00/0000:00:00.0",
+	"bus/pci/devices/0000:00:02.0":                        "../../../devices/pci0000:00/0000:00:02.0",
+	"bus/pci/devices/0000:3a:00.0":                        "../../../devices/pci0000:3a/0000:3a:00.0",
+	"bus/pci/devices/0000:3b:00.0":                        "../../../devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0",
+	"bus/pci/devices/0000:3c:00.0":                        "../../../devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0",
+	"class/net/eth0":                                      "../../devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/net/eth0",
+	"class/net/lo":                                        "../../devices/virtual/net/lo",
+	"class/block/nvme0n1":                                 "../../devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/nvme/nvme0/nvme0n1",
+	"class/block/nvme0n1p1":                               "../../devices/pci0000:3a/0000:3a:00.0/0000:3c:00.0/nvme/nvme0/nvme0n1/nvme0n1p1",
+	"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/driver": "../../../../bus/pci/drivers/ice",
+}
+
+func createTopologyCollector(t *testing.T, files, links map[string]string) *collectors.TopologyCollector {
+	sysPath := t.TempDir()
+	writeSysFiles(t, sysPath, files)
+	for path, target := range links {
+		fullPath := filepath.Join(sysPath, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.Symlink(target, fullPath))
+	}
+	collector, err := collectors.NewTopologyCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: sysPath})
+	require.NoError(t, err)
+	return collector
+}
+
+func TestTopologyCollector_Constructor(t *testing.T) {
+	_, err := collectors.NewTopologyCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: "relative"})
+	assert.ErrorContains(t, err, "HostSysPath must be an absolute path")
+
+	_, err = collectors.NewTopologyCollector(logr.Discard(), performance.CollectionConfig{HostSysPath: "/non/existent/path/that/should/not/exist"})
+	assert.ErrorContains(t, err, "HostSysPath validation failed")
+}
+
+func TestTopologyCollector_Collect(t *testing.T) {
+	collector := createTopologyCollector(t, testTopologyFiles, testTopologyLinks)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	stats, ok := result.(*performance.TopologyStats)
+	require.True(t, ok)
+
+	assert.Equal(t, []performance.NUMANode{
+		{ID: 0, CPUs: []int{0, 1, 2, 3, 8, 9, 10, 11}, MemoryBytes: 32801792 * 1024, Distances: []int{10, 21}},
+		{ID: 1, CPUs: []int{4, 5, 6, 7, 12, 13, 14, 15}, MemoryBytes: 33017380 * 1024, Distances: []int{21, 10}},
+	}, stats.Nodes)
+	assert.Equal(t, []performance.PCIDevice{
+		{
+			Address:   "0000:00:02.0",
+			Class:     "0x030000",
+			Vendor:    "0x10de",
+			Device:    "0x20b5",
+			NUMANode:  -1,
+			LocalCPUs: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		},
+		{
+			Address:    "0000:3b:00.0",
+			Class:      "0x020000",
+			Vendor:     "0x8086",
+			Device:     "0x1593",
+			Driver:     "ice",
+			NUMANode:   1,
+			LocalCPUs:  []int{4, 5, 6, 7, 12, 13, 14, 15},
+			Interfaces: []string{"eth0"},
+		},
+		{
+			Address:      "0000:3c:00.0",
+			Class:        "0x010802",
+			Vendor:       "0x144d",
+			Device:       "0xa808",
+			NUMANode:     1,
+			LocalCPUs:    []int{4, 5, 6, 7, 12, 13, 14, 15},
+			BlockDevices: []string{"nvme0n1"},
+		},
+	}, stats.Devices)
+}
+
+func TestTopologyCollector_NoNUMA(t *testing.T) {
+	collector := createTopologyCollector(t, map[string]string{"kernel/version": ""}, nil)
+
+	result, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &performance.TopologyStats{}, result)
+}
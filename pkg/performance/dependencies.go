@@ -0,0 +1,139 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userHZ is the unit of the CPU times in /proc. It is fixed at 100 on all architectures
+// by the kernel ABI, independently of the kernel's internal tick rate.
+const userHZ = 100
+
+// HostContext is the information about the host that several collectors need. The
+// Manager reads it once and passes it to every collection, so collectors don't each
+// read it again.
+type HostContext struct {
+	BootTime   time.Time // From btime in /proc/stat
+	PageSize   uint64    // In bytes, the unit of the memory page counters of /proc
+	ClockTicks uint64    // USER_HZ, the unit of the CPU times of /proc
+}
+
+// ReadHostContext reads the HostContext of the host whose /proc is mounted at procPath
+func ReadHostContext(procPath string) (HostContext, error) {
+	path := filepath.Join(procPath, "stat")
+	file, err := os.Open(path)
+	if err != nil {
+		return HostContext{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "btime ")
+		if !ok {
+			continue
+		}
+		btime, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return HostContext{}, fmt.Errorf("failed to parse btime %q: %w", value, err)
+		}
+		return HostContext{
+			BootTime:   time.Unix(btime, 0),
+			PageSize:   uint64(os.Getpagesize()),
+			ClockTicks: userHZ,
+		}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return HostContext{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return HostContext{}, fmt.Errorf("no btime in %s", path)
+}
+
+// DependentCollector is implemented by collectors that use the data of other collectors
+// of the same snapshot. The Manager runs a collector after the collectors it depends on,
+// and its Collect gets their data with DependencyData.
+type DependentCollector interface {
+	Collector
+
+	// DependsOn returns the metric types of the collectors to run first. Collectors that
+	// aren't enabled are ignored, so a dependent collector must still work without them.
+	DependsOn() []MetricType
+}
+
+// collectionKey is the context key of the collection a Collect call belongs to
+type collectionKey struct{}
+
+// collection is what the collectors of a snapshot share
+type collection struct {
+	host   HostContext
+	hostOK bool
+
+	mu   sync.Mutex
+	data map[MetricType]any
+}
+
+// WithCollection returns a context for the collections of a snapshot sharing host and
+// the data collected by previous collectors. data may be nil; the Manager adds the data
+// of every collector that succeeds to it.
+func WithCollection(ctx context.Context, host HostContext, data map[MetricType]any) context.Context {
+	return context.WithValue(ctx, collectionKey{}, newCollection(host, data))
+}
+
+func newCollection(host HostContext, data map[MetricType]any) *collection {
+	if data == nil {
+		data = make(map[MetricType]any)
+	}
+	return &collection{host: host, hostOK: !host.BootTime.IsZero(), data: data}
+}
+
+// HostContextFrom returns the HostContext of the collection ctx belongs to. It returns
+// false if the collector isn't run by a Manager, or the Manager couldn't read it.
+func HostContextFrom(ctx context.Context) (HostContext, bool) {
+	c, ok := ctx.Value(collectionKey{}).(*collection)
+	if !ok || !c.hostOK {
+		return HostContext{}, false
+	}
+	return c.host, true
+}
+
+// DependencyData returns the data the collector of metricType collected earlier in the
+// same snapshot, or false if it didn't run or failed. Only the collectors a collector
+// declares in DependsOn are sure to have run before it.
+func DependencyData(ctx context.Context, metricType MetricType) (any, bool) {
+	c, ok := ctx.Value(collectionKey{}).(*collection)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.data[metricType]
+	return data, ok
+}
+
+// setData records the data of a collector that succeeded for the collectors after it
+func (c *collection) setData(metricType MetricType, data any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[metricType] = data
+}
+
+// dependencies returns the dependencies collector declares, if any
+func dependencies(collector Collector) []MetricType {
+	if dependent, ok := collector.(DependentCollector); ok {
+		return dependent.DependsOn()
+	}
+	return nil
+}
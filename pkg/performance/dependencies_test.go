@@ -0,0 +1,163 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+)
+
+// dependentPointCollector records what its Collect call gets from its dependencies
+type dependentPointCollector struct {
+	*fakePointCollector
+	dependsOn []MetricType
+	host      HostContext
+	hostOK    bool
+	got       map[MetricType]any
+}
+
+func (d *dependentPointCollector) DependsOn() []MetricType {
+	return d.dependsOn
+}
+
+func (d *dependentPointCollector) Collect(ctx context.Context) (any, error) {
+	d.host, d.hostOK = HostContextFrom(ctx)
+	d.got = make(map[MetricType]any)
+	for _, dep := range d.dependsOn {
+		if data, ok := DependencyData(ctx, dep); ok {
+			d.got[dep] = data
+		}
+	}
+	return d.data, d.err
+}
+
+func newDependentPointCollector(metricType MetricType, data any, dependsOn ...MetricType) *dependentPointCollector {
+	return &dependentPointCollector{
+		fakePointCollector: newFakePointCollector(metricType, data, nil),
+		dependsOn:          dependsOn,
+	}
+}
+
+func TestReadHostContext(t *testing.T) {
+	procPath := t.TempDir()
+	if _, err := ReadHostContext(procPath); err == nil {
+		t.Error("expected an error without /proc/stat")
+	}
+
+	if err := os.WriteFile(filepath.Join(procPath, "stat"), []byte("cpu  1 2 3 4\nbtime 1767225600\n"), 0644); err != nil {
+		t.Fatalf("failed to write stat: %v", err)
+	}
+	host, err := ReadHostContext(procPath)
+	if err != nil {
+		t.Fatalf("failed to read host context: %v", err)
+	}
+	want := HostContext{BootTime: time.Unix(1767225600, 0), PageSize: uint64(os.Getpagesize()), ClockTicks: 100}
+	if host != want {
+		t.Errorf("ReadHostContext() = %+v, want %+v", host, want)
+	}
+}
+
+func TestCollectorRegistry_GetOrderedPoint(t *testing.T) {
+	r := NewCollectorRegistry(logr.Discard())
+	for _, c := range []PointCollector{
+		// cost_hints sorts first but needs memory, which needs process
+		newDependentPointCollector(MetricTypeCostHints, nil, MetricTypeMemory),
+		newDependentPointCollector(MetricTypeMemory, nil, MetricTypeProcess),
+		newFakePointCollector(MetricTypeLoad, nil, nil),
+		newFakePointCollector(MetricTypeProcess, nil, nil),
+		// Dependencies that aren't enabled are ignored
+		newDependentPointCollector(MetricTypeCPU, nil, MetricTypeTCP),
+	} {
+		if err := r.RegisterPoint(c); err != nil {
+			t.Fatalf("failed to register collector: %v", err)
+		}
+	}
+
+	config := CollectionConfig{EnabledCollectors: map[MetricType]bool{
+		MetricTypeCostHints: true,
+		MetricTypeMemory:    true,
+		MetricTypeLoad:      true,
+		MetricTypeProcess:   true,
+		MetricTypeCPU:       true,
+	}}
+	var got []MetricType
+	for _, c := range r.GetOrderedPoint(config) {
+		got = append(got, c.Type())
+	}
+	want := []MetricType{MetricTypeCPU, MetricTypeLoad, MetricTypeProcess, MetricTypeMemory, MetricTypeCostHints}
+	if !slices.Equal(got, want) {
+		t.Errorf("GetOrderedPoint() = %v, want %v", got, want)
+	}
+}
+
+func TestCollectorRegistry_DependencyCycle(t *testing.T) {
+	r := NewCollectorRegistry(logr.Discard())
+	for _, c := range []PointCollector{
+		newDependentPointCollector(MetricTypeLoad, nil, MetricTypeMemory),
+		newDependentPointCollector(MetricTypeMemory, nil, MetricTypeCPU),
+	} {
+		if err := r.RegisterPoint(c); err != nil {
+			t.Fatalf("failed to register collector: %v", err)
+		}
+	}
+
+	err := r.RegisterPoint(newDependentPointCollector(MetricTypeCPU, nil, MetricTypeLoad))
+	if err == nil || !strings.Contains(err.Error(), "[cpu load memory cpu]") {
+		t.Fatalf("expected a dependency cycle error, got %v", err)
+	}
+	if r.GetPoint(MetricTypeCPU) != nil {
+		t.Error("collector with a dependency cycle should not be registered")
+	}
+	if err := r.RegisterPoint(newDependentPointCollector(MetricTypeCPU, nil, MetricTypeCPU)); err == nil {
+		t.Error("expected an error for a collector depending on itself")
+	}
+}
+
+func TestManager_CollectSnapshot_Dependencies(t *testing.T) {
+	procPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(procPath, "stat"), []byte("btime 1767225600\n"), 0644); err != nil {
+		t.Fatalf("failed to write stat: %v", err)
+	}
+	m, err := NewManager(ManagerOptions{
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Config: CollectionConfig{
+			HostProcPath: procPath,
+			EnabledCollectors: map[MetricType]bool{
+				MetricTypeCostHints: true,
+				MetricTypeMemory:    true,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	memory := &MemoryStats{MemTotal: 1024}
+	dependent := newDependentPointCollector(MetricTypeCostHints, &CostHints{}, MetricTypeMemory)
+	for _, c := range []PointCollector{dependent, newFakePointCollector(MetricTypeMemory, memory, nil)} {
+		if err := m.RegisterPointCollector(c); err != nil {
+			t.Fatalf("failed to register collector: %v", err)
+		}
+	}
+
+	m.CollectSnapshot(context.Background())
+	if dependent.got[MetricTypeMemory] != memory {
+		t.Errorf("dependency data = %v, want %v", dependent.got, memory)
+	}
+	if !dependent.hostOK || !dependent.host.BootTime.Equal(time.Unix(1767225600, 0)) {
+		t.Errorf("HostContextFrom() = %+v, %v, want the host's boot time", dependent.host, dependent.hostOK)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Cloud providers detected by DetectEnvironment
+const (
+	CloudAWS   = "aws"
+	CloudGCP   = "gcp"
+	CloudAzure = "azure"
+)
+
+// azureChassisAssetTag is the DMI chassis asset tag of all Azure VMs
+const azureChassisAssetTag = "7783-7084-3265-9085-8269-3286-77"
+
+// ErrCollectorDisabled is wrapped by the errors of collectors that aren't run because
+// they are irrelevant in the Environment
+var ErrCollectorDisabled = errors.New("collector disabled")
+
+// Environment describes the platform the node runs on, as far as it decides which
+// collectors are relevant
+type Environment struct {
+	// Whether the node is a virtual machine
+	Virtualized bool
+	// Cloud provider of the node, one of the Cloud constants, or empty outside of the
+	// known clouds
+	Cloud string
+	// Whether the kernel registered a hardware PMU, without which there are no hardware
+	// performance counters
+	HardwarePMU bool
+}
+
+// DetectEnvironment detects the environment of the host whose /proc and /sys are at
+// procPath and sysPath. Undetectable properties are left at their zero value, so on
+// unknown platforms no collector is disabled but the PMU dependent ones.
+//
+// Data sources:
+//   - /proc/cpuinfo: the hypervisor flag x86 CPUs report in VMs
+//   - /sys/hypervisor/type: set in Xen guests
+//   - /sys/class/dmi/id/: the vendor, product and asset tag the firmware reports. EC2
+//     reports the instance type as product, which ends in .metal on bare metal instances.
+//   - /sys/bus/event_source/devices/: the PMUs registered by the kernel
+func DetectEnvironment(procPath, sysPath string) Environment {
+	dmi := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(sysPath, "class", "dmi", "id", name))
+		return strings.TrimSpace(string(data))
+	}
+	sysVendor, product := dmi("sys_vendor"), dmi("product_name")
+
+	var env Environment
+	switch {
+	case sysVendor == "Amazon EC2" || dmi("bios_vendor") == "Amazon EC2" ||
+		strings.Contains(dmi("bios_version"), "amazon"):
+		env.Cloud = CloudAWS
+		env.Virtualized = !strings.HasSuffix(product, ".metal")
+	case sysVendor == "Google" || product == "Google Compute Engine":
+		env.Cloud = CloudGCP
+	case dmi("chassis_asset_tag") == azureChassisAssetTag:
+		env.Cloud = CloudAzure
+	}
+
+	if !env.Virtualized {
+		hypervisor, _ := os.ReadFile(filepath.Join(sysPath, "hypervisor", "type"))
+		env.Virtualized = strings.TrimSpace(string(hypervisor)) != "" ||
+			slices.Contains(cpuFlags(filepath.Join(procPath, "cpuinfo")), "hypervisor")
+	}
+
+	pmus, _ := os.ReadDir(filepath.Join(sysPath, "bus", "event_source", "devices"))
+	for _, pmu := range pmus {
+		if isHardwarePMU(pmu.Name()) {
+			env.HardwarePMU = true
+			break
+		}
+	}
+	return env
+}
+
+// isHardwarePMU returns whether name is the event source of the core PMU: cpu on x86,
+// cpu_core and cpu_atom on hybrid Intel CPUs and armv8_* on arm64
+func isHardwarePMU(name string) bool {
+	return name == "cpu" || name == "cpu_core" || name == "cpu_atom" || strings.HasPrefix(name, "armv8_")
+}
+
+// cpuFlags returns the flags of the first CPU in cpuinfo, none on architectures that
+// don't report flags
+func cpuFlags(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "flags" {
+			return strings.Fields(value)
+		}
+	}
+	return nil
+}
+
+// DisabledCollectors returns the collectors that are irrelevant in the environment, with
+// the reason. They would only report nothing or fail on every collection.
+func (e Environment) DisabledCollectors() map[MetricType]string {
+	disabled := make(map[MetricType]string)
+	if e.Virtualized {
+		disabled[MetricTypePower] = "virtual machines have no power supplies and don't expose the " +
+			"suspend and C-state counters of the host"
+	}
+	if !e.HardwarePMU {
+		disabled[MetricTypeCPUPerf] = "the kernel has no hardware PMU, e.g. in a VM without a virtual PMU"
+	}
+	return disabled
+}
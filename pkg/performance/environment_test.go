@@ -0,0 +1,139 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeEnvFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDetectEnvironment(t *testing.T) {
+	const (
+		x86VMCPUInfo    = "processor\t: 0\nflags\t\t: fpu vme de pse hypervisor lahf_lm\n"
+		x86MetalCPUInfo = "processor\t: 0\nflags\t\t: fpu vme de pse arch_perfmon lahf_lm\n"
+	)
+
+	tests := []struct {
+		name         string
+		procFiles    map[string]string
+		sysFiles     map[string]string
+		want         Environment
+		wantDisabled []MetricType
+	}{
+		{
+			name:      "EC2 instance",
+			procFiles: map[string]string{"cpuinfo": x86VMCPUInfo},
+			sysFiles: map[string]string{
+				"class/dmi/id/sys_vendor":                   "Amazon EC2\n",
+				"class/dmi/id/product_name":                 "m5.large\n",
+				"bus/event_source/devices/software/type":    "1\n",
+				"bus/event_source/devices/tracepoint/type":  "2\n",
+				"bus/event_source/devices/breakpoint/type":  "5\n",
+				"bus/event_source/devices/uprobe/type":      "7\n",
+				"bus/event_source/devices/kprobe/type":      "6\n",
+				"bus/event_source/devices/msr/type":         "8\n",
+				"bus/event_source/devices/power/type":       "9\n",
+				"bus/event_source/devices/cstate_core/type": "10\n",
+			},
+			want:         Environment{Virtualized: true, Cloud: CloudAWS},
+			wantDisabled: []MetricType{MetricTypeCPUPerf, MetricTypePower},
+		},
+		{
+			name:      "EC2 metal instance",
+			procFiles: map[string]string{"cpuinfo": x86MetalCPUInfo},
+			sysFiles: map[string]string{
+				"class/dmi/id/sys_vendor":                  "Amazon EC2\n",
+				"class/dmi/id/product_name":                "c5.metal\n",
+				"bus/event_source/devices/cpu/type":        "4\n",
+				"bus/event_source/devices/msr/type":        "8\n",
+				"bus/event_source/devices/uncore_imc/type": "13\n",
+			},
+			want: Environment{Cloud: CloudAWS, HardwarePMU: true},
+		},
+		{
+			name: "EC2 Graviton instance with a virtual PMU",
+			sysFiles: map[string]string{
+				"class/dmi/id/bios_vendor":                    "Amazon EC2\n",
+				"class/dmi/id/product_name":                   "m7g.xlarge\n",
+				"bus/event_source/devices/armv8_pmuv3_0/type": "8\n",
+			},
+			want:         Environment{Virtualized: true, Cloud: CloudAWS, HardwarePMU: true},
+			wantDisabled: []MetricType{MetricTypePower},
+		},
+		{
+			name:      "GCE VM",
+			procFiles: map[string]string{"cpuinfo": x86VMCPUInfo},
+			sysFiles: map[string]string{
+				"class/dmi/id/sys_vendor":   "Google\n",
+				"class/dmi/id/product_name": "Google Compute Engine\n",
+			},
+			want:         Environment{Virtualized: true, Cloud: CloudGCP},
+			wantDisabled: []MetricType{MetricTypeCPUPerf, MetricTypePower},
+		},
+		{
+			name:      "Azure VM",
+			procFiles: map[string]string{"cpuinfo": x86VMCPUInfo},
+			sysFiles: map[string]string{
+				"class/dmi/id/sys_vendor":        "Microsoft Corporation\n",
+				"class/dmi/id/chassis_asset_tag": azureChassisAssetTag + "\n",
+			},
+			want:         Environment{Virtualized: true, Cloud: CloudAzure},
+			wantDisabled: []MetricType{MetricTypeCPUPerf, MetricTypePower},
+		},
+		{
+			name:         "Xen guest",
+			sysFiles:     map[string]string{"hypervisor/type": "xen\n"},
+			want:         Environment{Virtualized: true},
+			wantDisabled: []MetricType{MetricTypeCPUPerf, MetricTypePower},
+		},
+		{
+			name:      "bare metal server",
+			procFiles: map[string]string{"cpuinfo": x86MetalCPUInfo},
+			sysFiles: map[string]string{
+				"class/dmi/id/sys_vendor":                  "Dell Inc.\n",
+				"class/dmi/id/product_name":                "PowerEdge R650\n",
+				"bus/event_source/devices/cpu_core/type":   "4\n",
+				"bus/event_source/devices/cpu_atom/type":   "10\n",
+				"bus/event_source/devices/software/type":   "1\n",
+				"bus/event_source/devices/breakpoint/type": "5\n",
+			},
+			want: Environment{HardwarePMU: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procPath, sysPath := t.TempDir(), t.TempDir()
+			writeEnvFiles(t, procPath, tt.procFiles)
+			writeEnvFiles(t, sysPath, tt.sysFiles)
+
+			env := DetectEnvironment(procPath, sysPath)
+			if env != tt.want {
+				t.Errorf("DetectEnvironment() = %+v, want %+v", env, tt.want)
+			}
+			disabled := slices.Sorted(maps.Keys(env.DisabledCollectors()))
+			if !slices.Equal(disabled, tt.wantDisabled) {
+				t.Errorf("DisabledCollectors() = %v, want %v", disabled, tt.wantDisabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package history
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/samples"
+)
+
+var (
+	snapshotPrefix = []byte("snap/")
+	rollupPrefix   = "rollup/"
+)
+
+// ErrUnknownResolution is returned when querying a resolution that isn't a rollup tier
+var ErrUnknownResolution = errors.New("unknown resolution")
+
+// Record is the persisted form of a performance snapshot.
+// CollectorStat isn't stored directly since its error doesn't serialize and its data
+// duplicates Metrics.
+type Record struct {
+	Timestamp   time.Time                               `json:"timestamp"`
+	NodeName    string                                  `json:"nodeName"`
+	ClusterName string                                  `json:"clusterName,omitempty"`
+	Tags        map[string]string                       `json:"tags,omitempty"`
+	Duration    time.Duration                           `json:"duration"`
+	Collectors  map[performance.MetricType]CollectorRun `json:"collectors"`
+	Metrics     performance.Metrics                     `json:"metrics"`
+	// Resolution is the interval a rollup stands for, 0 for a raw snapshot
+	Resolution time.Duration `json:"resolution,omitempty"`
+	// Samples is the number of snapshots taken in the interval of a rollup
+	Samples int `json:"samples,omitempty"`
+	// Aggregates are the numeric metrics of the snapshots of a rollup by their path in
+	// Metrics, e.g. Load.Load1Min or Network.eth0.RxBytes, see samples.Flatten
+	Aggregates map[string]Aggregate `json:"aggregates,omitempty"`
+}
+
+// Aggregate is the minimum, maximum and average of a metric over the snapshots of the
+// interval of a rollup
+type Aggregate struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+	// Samples is the number of snapshots the metric was in, fewer than those of the
+	// rollup for e.g. a process that exited during the interval
+	Samples int `json:"samples"`
+}
+
+func (a *Aggregate) add(v float64) {
+	if a.Samples == 0 || v < a.Min {
+		a.Min = v
+	}
+	if a.Samples == 0 || v > a.Max {
+		a.Max = v
+	}
+	a.Samples++
+	a.Avg += (v - a.Avg) / float64(a.Samples)
+}
+
+// CollectorRun is the outcome of a single collector in a Record
+type CollectorRun struct {
+	Status   performance.CollectorStatus `json:"status"`
+	Duration time.Duration               `json:"duration"`
+	Error    string                      `json:"error,omitempty"`
+}
+
+// NewRecord converts snapshot to its persisted form
+func NewRecord(snapshot *performance.Snapshot) Record {
+	r := Record{
+		Timestamp:   snapshot.Timestamp,
+		NodeName:    snapshot.NodeName,
+		ClusterName: snapshot.ClusterName,
+		Tags:        snapshot.Tags,
+		Duration:    snapshot.CollectorRun.Duration,
+		Collectors:  make(map[performance.MetricType]CollectorRun, len(snapshot.CollectorRun.CollectorStats)),
+		Metrics:     snapshot.Metrics,
+	}
+	for metricType, stat := range snapshot.CollectorRun.CollectorStats {
+		run := CollectorRun{Status: stat.Status, Duration: stat.Duration}
+		if stat.Error != nil {
+			run.Error = stat.Error.Error()
+		}
+		r.Collectors[metricType] = run
+	}
+	return r
+}
+
+// History keeps the performance snapshots of the last retention period so they can be
+// inspected after an incident, even if they were never shipped upstream.
+//
+// Snapshots are keyed by timestamp and written with a TTL of the retention period, so
+// badger drops expired snapshots on its own and no pruning is needed.
+//
+// With rollup tiers, e.g. 1m and 10m, every snapshot is also added to the rollup of its
+// interval in each tier, kept for the retention of the tier. A rollup aggregates the
+// minimum, maximum and average of every numeric metric over the snapshots of its interval
+// and counts them. Its Metrics are those of the last snapshot of the interval since the
+// process and connection lists of different snapshots can't be averaged. Kernel messages
+// and bursts, logs and sub-second samples rather than metrics, aren't aggregated.
+type History struct {
+	db        *badger.DB
+	retention time.Duration
+	tiers     []Tier
+}
+
+// New creates a new History. By default the history is kept in memory.
+func New(opts ...Option) (*History, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.retention <= 0 {
+		o.retention = DefaultRetention
+	}
+	tiers := slices.Clone(o.tiers)
+	for _, tier := range tiers {
+		if tier.Resolution <= 0 || tier.Retention <= 0 {
+			return nil, fmt.Errorf("invalid rollup tier %s: resolution and retention must be positive", tier)
+		}
+	}
+	slices.SortFunc(tiers, func(a, b Tier) int {
+		return cmp.Compare(a.Resolution, b.Resolution)
+	})
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Resolution == tiers[i-1].Resolution {
+			return nil, fmt.Errorf("duplicate rollup tier resolution %s", tiers[i].Resolution)
+		}
+	}
+
+	badgerOpts := badger.DefaultOptions(o.dataDir).WithLogger(nil)
+	if o.dataDir == "" {
+		badgerOpts = badgerOpts.WithInMemory(true)
+	}
+	if o.readOnly {
+		badgerOpts = badgerOpts.WithReadOnly(true)
+	}
+
+	db, err := badger.Open(badgerOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &History{db: db, retention: o.retention, tiers: tiers}, nil
+}
+
+// Retention returns how long snapshots are kept
+func (h *History) Retention() time.Duration {
+	return h.retention
+}
+
+// Tiers returns the rollup tiers of the history, finest first
+func (h *History) Tiers() []Tier {
+	return slices.Clone(h.tiers)
+}
+
+// Add stores snapshot and replaces the rollups of its intervals. Snapshots with the same
+// timestamp overwrite each other.
+func (h *History) Add(snapshot *performance.Snapshot) error {
+	record := NewRecord(snapshot)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	var values map[string]float64
+	if len(h.tiers) > 0 {
+		if values, err = metricValues(record.Metrics); err != nil {
+			return fmt.Errorf("failed to aggregate snapshot: %w", err)
+		}
+	}
+
+	age := time.Since(snapshot.Timestamp)
+	return h.db.Update(func(txn *badger.Txn) error {
+		// Snapshots older than the retention period expire immediately
+		if ttl := h.retention - age; ttl > 0 {
+			entry := badger.NewEntry(timeKey(snapshotPrefix, snapshot.Timestamp), data).WithTTL(ttl)
+			if err := txn.SetEntry(entry); err != nil {
+				return err
+			}
+		}
+		for _, tier := range h.tiers {
+			ttl := tier.Retention - age
+			if ttl <= 0 {
+				continue
+			}
+			if err := h.addRollup(txn, tier, record, values, ttl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addRollup adds record and the values of its metrics to the rollup of the interval of
+// tier record was taken in
+func (h *History) addRollup(txn *badger.Txn, tier Tier, record Record, values map[string]float64,
+	ttl time.Duration,
+) error {
+	key := timeKey(tierPrefix(tier.Resolution), record.Timestamp.Truncate(tier.Resolution))
+	record.Resolution = tier.Resolution
+	rollup := record
+
+	item, err := txn.Get(key)
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+	case err != nil:
+		return err
+	default:
+		var prev Record
+		if err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &prev)
+		}); err != nil {
+			return fmt.Errorf("failed to decode rollup %x: %w", key, err)
+		}
+		// A snapshot added again overwrites the last one without being counted twice
+		if prev.Timestamp.Equal(record.Timestamp) {
+			rollup.Samples, rollup.Aggregates = prev.Samples, prev.Aggregates
+			break
+		}
+		// A snapshot added late is aggregated but doesn't replace a later one
+		if prev.Timestamp.After(record.Timestamp) {
+			rollup = prev
+		}
+		rollup.Samples, rollup.Aggregates = prev.Samples, prev.Aggregates
+		rollup.Samples++
+		aggregate(rollup.Aggregates, values)
+	}
+	if rollup.Samples == 0 {
+		rollup.Samples = 1
+		rollup.Aggregates = make(map[string]Aggregate, len(values))
+		aggregate(rollup.Aggregates, values)
+	}
+
+	data, err := json.Marshal(rollup)
+	if err != nil {
+		return fmt.Errorf("failed to encode rollup: %w", err)
+	}
+	return txn.SetEntry(badger.NewEntry(key, data).WithTTL(ttl))
+}
+
+// aggregate adds values to the aggregates of their metrics
+func aggregate(aggregates map[string]Aggregate, values map[string]float64) {
+	for metric, v := range values {
+		a := aggregates[metric]
+		a.add(v)
+		aggregates[metric] = a
+	}
+}
+
+// metricValues returns the numeric metrics of m by path
+func metricValues(m performance.Metrics) (map[string]float64, error) {
+	m.Kernel, m.Burst = nil, nil
+	values := make(map[string]float64)
+	err := samples.Flatten(m, func(metric, value string) {
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			values[metric] = v
+		}
+	})
+	return values, err
+}
+
+// Range returns the snapshots taken in [from, to) in chronological order.
+// A zero to means no upper bound. If limit is > 0, only the latest limit snapshots
+// are returned.
+func (h *History) Range(from, to time.Time, limit int) ([]Record, error) {
+	return h.rangePrefix(snapshotPrefix, from, to, limit)
+}
+
+// RangeAt is Range at a resolution: 0 returns the snapshots and the resolution of a rollup
+// tier the rollups of the intervals starting in [from, to). It returns
+// ErrUnknownResolution if resolution isn't 0 or that of a tier.
+func (h *History) RangeAt(resolution time.Duration, from, to time.Time, limit int) ([]Record, error) {
+	if resolution == 0 {
+		return h.Range(from, to, limit)
+	}
+	if !slices.ContainsFunc(h.tiers, func(tier Tier) bool { return tier.Resolution == resolution }) {
+		return nil, fmt.Errorf("%w %s: must be 0 or that of a rollup tier", ErrUnknownResolution, resolution)
+	}
+	return h.rangePrefix(tierPrefix(resolution), from, to, limit)
+}
+
+func (h *History) rangePrefix(prefix []byte, from, to time.Time, limit int) ([]Record, error) {
+	var records []Record
+	err := h.db.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = prefix
+		iterOpts.Reverse = true
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		// Iterate backwards from the end of the range so limit keeps the latest snapshots
+		seek := append(prefix[:len(prefix):len(prefix)], 0xff)
+		if !to.IsZero() {
+			seek = timeKey(prefix, to.Add(-1))
+		}
+		fromKey := timeKey(prefix, from)
+		for it.Seek(seek); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.Compare(item.Key(), fromKey) < 0 {
+				break
+			}
+			var r Record
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &r)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to decode snapshot %x: %w", item.Key(), err)
+			}
+			records = append(records, r)
+			if limit > 0 && len(records) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+// Close closes the history
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// timeKey encodes t after prefix so keys sort chronologically.
+// Timestamps before the Unix epoch are clamped to it.
+func timeKey(prefix []byte, t time.Time) []byte {
+	nanos := t.UnixNano()
+	if nanos < 0 {
+		nanos = 0
+	}
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(nanos))
+	return key
+}
+
+// tierPrefix is the key prefix of the rollups of resolution, e.g. rollup/1m0s/
+func tierPrefix(resolution time.Duration) []byte {
+	return []byte(rollupPrefix + resolution.String() + "/")
+}
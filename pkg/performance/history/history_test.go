@@ -0,0 +1,361 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+func newSnapshot(ts time.Time, load float64) *performance.Snapshot {
+	return &performance.Snapshot{
+		Timestamp: ts,
+		NodeName:  "node-1",
+		CollectorRun: performance.CollectorRunInfo{
+			Duration: time.Millisecond,
+			CollectorStats: map[performance.MetricType]performance.CollectorStat{
+				performance.MetricTypeLoad: {Status: performance.CollectorStatusActive},
+				performance.MetricTypeMemory: {
+					Status: performance.CollectorStatusFailed,
+					Error:  errors.New("boom"),
+				},
+			},
+		},
+		Metrics: performance.Metrics{
+			Load: &performance.LoadStats{Load1Min: load},
+		},
+	}
+}
+
+func TestHistory_Range(t *testing.T) {
+	h, err := New()
+	if err != nil {
+		t.Fatalf("failed to create history: %v", err)
+	}
+	defer h.Close()
+
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		ts := now.Add(time.Duration(i-4) * time.Minute)
+		if err := h.Add(newSnapshot(ts, float64(i))); err != nil {
+			t.Fatalf("failed to add snapshot: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		limit    int
+		want     []float64
+	}{
+		{name: "all", from: now.Add(-time.Hour), want: []float64{0, 1, 2, 3, 4}},
+		{name: "from is inclusive", from: now.Add(-2 * time.Minute), want: []float64{2, 3, 4}},
+		{name: "to is exclusive", from: now.Add(-time.Hour), to: now.Add(-2 * time.Minute), want: []float64{0, 1}},
+		{name: "limit keeps latest", from: now.Add(-time.Hour), limit: 2, want: []float64{3, 4}},
+		{name: "limit with to", from: now.Add(-time.Hour), to: now.Add(-time.Minute), limit: 2, want: []float64{1, 2}},
+		{name: "empty", from: now.Add(time.Minute), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := h.Range(tt.from, tt.to, tt.limit)
+			if err != nil {
+				t.Fatalf("Range() error = %v", err)
+			}
+			var got []float64
+			for _, r := range records {
+				got = append(got, r.Metrics.Load.Load1Min)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Range() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Range() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistory_Record(t *testing.T) {
+	h, err := New()
+	if err != nil {
+		t.Fatalf("failed to create history: %v", err)
+	}
+	defer h.Close()
+
+	ts := time.Now()
+	if err := h.Add(newSnapshot(ts, 1)); err != nil {
+		t.Fatalf("failed to add snapshot: %v", err)
+	}
+	records, err := h.Range(ts.Add(-time.Second), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Range() returned %d records, want 1", len(records))
+	}
+
+	r := records[0]
+	if !r.Timestamp.Equal(ts) {
+		t.Errorf("Timestamp = %v, want %v", r.Timestamp, ts)
+	}
+	if r.NodeName != "node-1" {
+		t.Errorf("NodeName = %q, want %q", r.NodeName, "node-1")
+	}
+	if got := r.Collectors[performance.MetricTypeMemory]; got.Status != performance.CollectorStatusFailed || got.Error != "boom" {
+		t.Errorf("memory collector run = %+v, want failed with error boom", got)
+	}
+	if got := r.Collectors[performance.MetricTypeLoad]; got.Status != performance.CollectorStatusActive || got.Error != "" {
+		t.Errorf("load collector run = %+v, want active without error", got)
+	}
+}
+
+func TestHistory_Retention(t *testing.T) {
+	h, err := New(WithRetention(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to create history: %v", err)
+	}
+	defer h.Close()
+
+	now := time.Now()
+	if err := h.Add(newSnapshot(now.Add(-2*time.Minute), 1)); err != nil {
+		t.Fatalf("failed to add snapshot: %v", err)
+	}
+	if err := h.Add(newSnapshot(now, 2)); err != nil {
+		t.Fatalf("failed to add snapshot: %v", err)
+	}
+
+	records, err := h.Range(now.Add(-time.Hour), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	if len(records) != 1 || records[0].Metrics.Load.Load1Min != 2 {
+		t.Errorf("expected only the snapshot within retention, got %d records", len(records))
+	}
+}
+
+func TestHistory_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	h, err := New(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("failed to create history: %v", err)
+	}
+	ts := time.Now()
+	if err := h.Add(newSnapshot(ts, 1)); err != nil {
+		t.Fatalf("failed to add snapshot: %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("failed to close history: %v", err)
+	}
+
+	h, err = New(WithDataDir(dir), WithReadOnly())
+	if err != nil {
+		t.Fatalf("failed to reopen history: %v", err)
+	}
+	defer h.Close()
+	records, err := h.Range(ts.Add(-time.Second), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Range() returned %d records after reopening, want 1", len(records))
+	}
+}
+
+func TestHistory_ServeHTTP(t *testing.T) {
+	h, err := New()
+	if err != nil {
+		t.Fatalf("failed to create history: %v", err)
+	}
+	defer h.Close()
+
+	now := time.Now()
+	for i, age := range []time.Duration{2 * time.Hour, 30 * time.Minute, time.Minute} {
+		if err := h.Add(newSnapshot(now.Add(-age), float64(i))); err != nil {
+			t.Fatalf("failed to add snapshot: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCount  int
+	}{
+		{name: "default window", query: "", wantStatus: http.StatusOK, wantCount: 2},
+		{name: "since", query: "?since=10m", wantStatus: http.StatusOK, wantCount: 1},
+		{name: "from", query: "?from=" + now.Add(-3*time.Hour).Format(time.RFC3339), wantStatus: http.StatusOK, wantCount: 3},
+		{name: "limit", query: "?since=3h&limit=1", wantStatus: http.StatusOK, wantCount: 1},
+		{name: "no results", query: "?since=1s", wantStatus: http.StatusOK, wantCount: 0},
+		{name: "invalid since", query: "?since=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "invalid from", query: "?from=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "invalid limit", query: "?limit=-1", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var records []Record
+			if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(records) != tt.wantCount {
+				t.Errorf("got %d records, want %d", len(records), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestHistory_Rollups(t *testing.T) {
+	h, err := New(
+		WithRetention(time.Minute),
+		WithRollups(
+			Tier{Resolution: 10 * time.Minute, Retention: 24 * time.Hour},
+			Tier{Resolution: time.Minute, Retention: time.Hour},
+		),
+	)
+	if err != nil {
+		t.Fatalf("failed to create history: %v", err)
+	}
+	defer h.Close()
+
+	if tiers := h.Tiers(); len(tiers) != 2 || tiers[0].Resolution != time.Minute {
+		t.Errorf("Tiers() = %v, want finest first", tiers)
+	}
+
+	// Snapshots every 15s for 3m, 2h ago, and every 15s for the last 2m
+	start := time.Now().Truncate(10 * time.Minute).Add(-2 * time.Hour)
+	var load float64
+	for ts := start; ts.Before(start.Add(3 * time.Minute)); ts = ts.Add(15 * time.Second) {
+		load++
+		if err := h.Add(newSnapshot(ts, load)); err != nil {
+			t.Fatalf("failed to add snapshot: %v", err)
+		}
+	}
+	recent := time.Now().Truncate(time.Minute).Add(-time.Minute)
+	for ts := recent; ts.Before(recent.Add(2 * time.Minute)); ts = ts.Add(15 * time.Second) {
+		load++
+		if err := h.Add(newSnapshot(ts, load)); err != nil {
+			t.Fatalf("failed to add snapshot: %v", err)
+		}
+	}
+	// A snapshot added late is aggregated but doesn't replace the last one of its interval
+	if err := h.Add(newSnapshot(recent.Add(5*time.Second), 0)); err != nil {
+		t.Fatalf("failed to add snapshot: %v", err)
+	}
+
+	minutes, err := h.RangeAt(time.Minute, start.Add(-time.Hour), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("RangeAt() error = %v", err)
+	}
+	if len(minutes) != 2 {
+		t.Fatalf("RangeAt(1m) returned %d rollups, want the 2 recent minutes", len(minutes))
+	}
+	for i, r := range minutes {
+		want := recent.Add(time.Duration(i)*time.Minute + 45*time.Second)
+		if !r.Timestamp.Equal(want) || r.Resolution != time.Minute || r.Samples != 4+1-i {
+			t.Errorf("rollup %d = %v with resolution %s and %d samples, want the last of %d snapshots at %v",
+				i, r.Timestamp, r.Resolution, r.Samples, 4+1-i, want)
+		}
+	}
+	if got, want := minutes[0].Aggregates["Load.Load1Min"], (Aggregate{Min: 0, Max: 16, Avg: 11.6, Samples: 5}); got != want {
+		t.Errorf("Load1Min of the first minute = %+v, want %+v", got, want)
+	}
+	if got, want := minutes[1].Aggregates["Load.Load1Min"], (Aggregate{Min: 17, Max: 20, Avg: 18.5, Samples: 4}); got != want {
+		t.Errorf("Load1Min of the second minute = %+v, want %+v", got, want)
+	}
+	if minutes[0].Metrics.Load.Load1Min != 16 {
+		t.Errorf("Load1Min of the first minute's last snapshot = %v, want 16", minutes[0].Metrics.Load.Load1Min)
+	}
+
+	tens, err := h.RangeAt(10*time.Minute, start.Add(-time.Hour), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("RangeAt() error = %v", err)
+	}
+	if len(tens) == 0 || !tens[0].Timestamp.Equal(start.Add(2*time.Minute+45*time.Second)) || tens[0].Samples != 12 {
+		t.Errorf("expected the 10m rollup of 2h ago to be the last of its 12 snapshots, got %v", tens)
+	}
+	if tens[0].Metrics.Load.Load1Min != 12 {
+		t.Errorf("Load1Min of rollup = %v, want 12", tens[0].Metrics.Load.Load1Min)
+	}
+	if got, want := tens[0].Aggregates["Load.Load1Min"], (Aggregate{Min: 1, Max: 12, Avg: 6.5, Samples: 12}); got != want {
+		t.Errorf("Load1Min aggregate of rollup = %+v, want %+v", got, want)
+	}
+
+	// Adding a snapshot again doesn't count it twice
+	if err := h.Add(newSnapshot(recent.Add(time.Minute+45*time.Second), 20)); err != nil {
+		t.Fatalf("failed to add snapshot: %v", err)
+	}
+	again, err := h.RangeAt(time.Minute, recent.Add(time.Minute), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("RangeAt() error = %v", err)
+	}
+	if len(again) != 1 || again[0].Samples != 4 || again[0].Aggregates["Load.Load1Min"].Samples != 4 {
+		t.Errorf("expected a snapshot added again not to be counted twice, got %+v", again)
+	}
+
+	if _, err := h.RangeAt(5*time.Minute, start, time.Time{}, 0); !errors.Is(err, ErrUnknownResolution) {
+		t.Errorf("RangeAt(5m) error = %v, want ErrUnknownResolution", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?since=3h&resolution=10m", nil))
+	var records []Record
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(records) != len(tens) {
+		t.Errorf("got %d rollups, want %d", len(records), len(tens))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?resolution=5m", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an unknown resolution, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers(DefaultRollups + ", 1h:8760h,")
+	if err != nil {
+		t.Fatalf("ParseTiers() error = %v", err)
+	}
+	want := []Tier{
+		{Resolution: time.Minute, Retention: 7 * 24 * time.Hour},
+		{Resolution: 10 * time.Minute, Retention: 30 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+	}
+	if len(tiers) != len(want) {
+		t.Fatalf("ParseTiers() = %v, want %v", tiers, want)
+	}
+	for i := range want {
+		if tiers[i] != want[i] {
+			t.Errorf("ParseTiers() = %v, want %v", tiers, want)
+		}
+	}
+	for _, s := range []string{"1m", "1m:forever", "often:1h"} {
+		if _, err := ParseTiers(s); err == nil {
+			t.Errorf("ParseTiers(%q) expected an error", s)
+		}
+	}
+	if _, err := New(WithRollups(Tier{Resolution: time.Minute, Retention: time.Hour},
+		Tier{Resolution: time.Minute, Retention: 2 * time.Hour})); err == nil {
+		t.Error("expected an error for tiers with the same resolution")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultQueryWindow is the window returned when a query sets neither since nor from
+const defaultQueryWindow = time.Hour
+
+// ServeHTTP returns the stored snapshots as a JSON array. The range is selected with
+// query parameters:
+//
+//   - since: a duration such as 30m, returning the snapshots of that long ago until now
+//   - from, to: RFC 3339 timestamps bounding the range. to is optional
+//   - limit: maximum number of snapshots, keeping the latest ones
+//   - resolution: the resolution of a rollup tier, e.g. 10m, returning its rollups instead
+//     of the snapshots
+//
+// Without since or from the snapshots of the last hour are returned.
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, limit, resolution, err := parseQuery(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := h.RangeAt(resolution, from, to, limit)
+	if errors.Is(err, ErrUnknownResolution) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []Record{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func parseQuery(r *http.Request, now time.Time) (from, to time.Time, limit int, resolution time.Duration, err error) {
+	q := r.URL.Query()
+
+	from = now.Add(-defaultQueryWindow)
+	if v := q.Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil || since <= 0 {
+			return from, to, 0, 0, fmt.Errorf("invalid since %q: must be a positive duration", v)
+		}
+		from = now.Add(-since)
+	}
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, 0, 0, fmt.Errorf("invalid from %q: %w", v, err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, 0, 0, fmt.Errorf("invalid to %q: %w", v, err)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return from, to, 0, 0, fmt.Errorf("invalid limit %q: must be a non-negative integer", v)
+		}
+	}
+	if v := q.Get("resolution"); v != "" {
+		if resolution, err = time.ParseDuration(v); err != nil || resolution < 0 {
+			return from, to, 0, 0, fmt.Errorf("invalid resolution %q: must be a non-negative duration", v)
+		}
+	}
+	return from, to, limit, resolution, nil
+}
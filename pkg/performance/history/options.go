@@ -0,0 +1,98 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package history
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetention is how long snapshots are kept when no retention is configured
+	DefaultRetention = 6 * time.Hour
+	// DefaultRollups are the rollup tiers of a persisted history, parsed by ParseTiers: a
+	// week of 1m rollups and a month of 10m rollups
+	DefaultRollups = "1m:168h,10m:720h"
+)
+
+// Tier is a rollup tier of the history: one rollup per Resolution, kept for Retention
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// String formats t as resolution:retention, e.g. 1m0s:168h0m0s
+func (t Tier) String() string {
+	return t.Resolution.String() + ":" + t.Retention.String()
+}
+
+// ParseTiers parses a comma separated list of resolution:retention tiers, e.g.
+// 1m:168h,10m:720h
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for item := range strings.SplitSeq(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		resolution, retention, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rollup tier %q: expected resolution:retention", item)
+		}
+		var tier Tier
+		var err error
+		if tier.Resolution, err = time.ParseDuration(resolution); err != nil {
+			return nil, fmt.Errorf("invalid resolution of rollup tier %q: %w", item, err)
+		}
+		if tier.Retention, err = time.ParseDuration(retention); err != nil {
+			return nil, fmt.Errorf("invalid retention of rollup tier %q: %w", item, err)
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+type options struct {
+	dataDir   string
+	retention time.Duration
+	tiers     []Tier
+	readOnly  bool
+}
+
+// Option configures a History created with New.
+type Option func(*options)
+
+// WithDataDir persists the history to dir instead of keeping it in memory.
+func WithDataDir(dir string) Option {
+	return func(o *options) {
+		o.dataDir = dir
+	}
+}
+
+// WithRetention sets how long snapshots are kept. Defaults to DefaultRetention.
+func WithRetention(d time.Duration) Option {
+	return func(o *options) {
+		o.retention = d
+	}
+}
+
+// WithRollups keeps rollups of the snapshots in tiers, each for the retention of its
+// tier. Tiers must have different resolutions.
+func WithRollups(tiers ...Tier) Option {
+	return func(o *options) {
+		o.tiers = tiers
+	}
+}
+
+// WithReadOnly opens a persistent history in read-only mode, e.g. to inspect the data dir
+// of a stopped agent. Adding snapshots to a read-only history fails.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
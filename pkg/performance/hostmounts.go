@@ -0,0 +1,172 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotHostMount is wrapped by the errors of ValidateHostMounts for paths that aren't the
+// host's /proc, /sys or /dev
+var ErrNotHostMount = errors.New("not mounted from the host")
+
+// containerCgroups are the cgroup path components of processes in containers
+var containerCgroups = []string{"kubepods", "docker", "containerd", "libpod", "lxc", "crio"}
+
+// InContainer reports whether the agent runs in a container, from the markers container
+// runtimes leave in the environment and the root filesystem at root, and the cgroup of
+// the agent in its own /proc at selfProcPath. With a private cgroup namespace the cgroup
+// is /, so a container without markers isn't detected.
+func InContainer(root, selfProcPath string) bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" || os.Getenv("container") != "" {
+		return true
+	}
+	for _, marker := range []string{".dockerenv", "run/.containerenv"} {
+		if _, err := os.Stat(filepath.Join(root, marker)); err == nil {
+			return true
+		}
+	}
+	cgroup, _ := os.ReadFile(filepath.Join(selfProcPath, "self", "cgroup"))
+	for line := range strings.SplitSeq(string(cgroup), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for component := range strings.SplitSeq(parts[2], "/") {
+			for _, name := range containerCgroups {
+				if strings.HasPrefix(component, name) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ValidateHostMounts checks that procPath, sysPath and devPath are the host's /proc, /sys
+// and /dev rather than those of the container the agent runs in, which the agent observes
+// when the hostPath mounts or the host PID namespace are missing. selfProcPath is the
+// agent's own /proc. It must only be called in a container: on the host, the agent's own
+// /proc is the host's.
+//
+//   - /proc is the host's if its boot ID is that of the running kernel and its PID 1 is in
+//     another mount namespace than the agent. When the namespaces can't be read, PID 1
+//     having the same mounts as the agent gives the container's /proc away.
+//   - /sys is the host's if it has the network interfaces of PID 1's network namespace,
+//     since sysfs shows those of the network namespace it was mounted in.
+//   - /dev is the host's if it has the nodes of the block devices in /sys. A container's
+//     /dev is a tmpfs with a few character devices.
+//
+// It returns an error wrapping ErrNotHostMount for every path that isn't the host's, and
+// nil if they all are.
+func ValidateHostMounts(selfProcPath, procPath, sysPath, devPath string) []error {
+	var errs []error
+	procOK := true
+	if err := validateHostProc(selfProcPath, procPath); err != nil {
+		errs = append(errs, err)
+		procOK = false
+	}
+	// The interfaces of the host are only known from the host's /proc
+	if procOK {
+		if err := validateHostSys(procPath, sysPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := validateHostDev(sysPath, devPath); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func validateHostProc(selfProcPath, procPath string) error {
+	bootID, err := ReadBootID(procPath)
+	if err != nil {
+		return fmt.Errorf("%s isn't a procfs: %w: %w", procPath, ErrNotHostMount, err)
+	}
+	if selfBootID, err := ReadBootID(selfProcPath); err == nil && selfBootID != bootID {
+		return fmt.Errorf("%s has boot ID %s but the running kernel %s: %w", procPath, bootID, selfBootID,
+			ErrNotHostMount)
+	}
+
+	hostNS, hostErr := os.Readlink(filepath.Join(procPath, "1", "ns", "mnt"))
+	selfNS, selfErr := os.Readlink(filepath.Join(selfProcPath, "self", "ns", "mnt"))
+	if hostErr == nil && selfErr == nil {
+		if hostNS == selfNS {
+			return fmt.Errorf("%s is the container's /proc, its PID 1 is in the agent's mount namespace; "+
+				"mount the host's /proc and use the host PID namespace: %w", procPath, ErrNotHostMount)
+		}
+		return nil
+	}
+	hostMounts, hostErr := os.ReadFile(filepath.Join(procPath, "1", "mountinfo"))
+	selfMounts, selfErr := os.ReadFile(filepath.Join(selfProcPath, "self", "mountinfo"))
+	if hostErr == nil && selfErr == nil && bytes.Equal(hostMounts, selfMounts) {
+		return fmt.Errorf("%s is the container's /proc, its PID 1 has the agent's mounts; "+
+			"mount the host's /proc and use the host PID namespace: %w", procPath, ErrNotHostMount)
+	}
+	return nil
+}
+
+func validateHostSys(procPath, sysPath string) error {
+	hostInterfaces, err := readNetDevInterfaces(filepath.Join(procPath, "1", "net", "dev"))
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(sysPath, "class", "net"))
+	if err != nil {
+		return fmt.Errorf("%s isn't a sysfs: %w: %w", sysPath, ErrNotHostMount, err)
+	}
+	sysInterfaces := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		sysInterfaces[entry.Name()] = true
+	}
+	if !maps.Equal(hostInterfaces, sysInterfaces) {
+		return fmt.Errorf("%s has the network interfaces of another network namespace than the host's; "+
+			"mount the host's /sys: %w", sysPath, ErrNotHostMount)
+	}
+	return nil
+}
+
+func validateHostDev(sysPath, devPath string) error {
+	devices, err := os.ReadDir(filepath.Join(sysPath, "class", "block"))
+	if err != nil || len(devices) == 0 {
+		return nil
+	}
+	for _, device := range devices {
+		if _, err := os.Stat(filepath.Join(devPath, device.Name())); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has none of the %d block devices of the host; mount the host's /dev: %w",
+		devPath, len(devices), ErrNotHostMount)
+}
+
+// readNetDevInterfaces returns the network interfaces listed in /proc/[pid]/net/dev
+func readNetDevInterfaces(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	interfaces := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The first two lines are headers without a colon
+		name, _, ok := strings.Cut(scanner.Text(), ":")
+		if ok {
+			interfaces[strings.TrimSpace(name)] = true
+		}
+	}
+	return interfaces, scanner.Err()
+}
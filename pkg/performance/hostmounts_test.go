@@ -0,0 +1,176 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testNetDev = "Inter-|   Receive                  |  Transmit\n" +
+	" face |bytes    packets errs drop |bytes    packets errs drop\n" +
+	"    lo: 100 1 0 0 100 1 0 0\n" +
+	"  eth0: 200 2 0 0 200 2 0 0\n"
+
+// newTestHostMounts creates the agent's own /proc, the host's /proc, /sys and /dev of a
+// correctly mounted agent and returns their paths
+func newTestHostMounts(t *testing.T) (selfProc, proc, sys, dev string) {
+	t.Helper()
+	root := t.TempDir()
+	selfProc, proc = filepath.Join(root, "self-proc"), filepath.Join(root, "host/proc")
+	sys, dev = filepath.Join(root, "host/sys"), filepath.Join(root, "host/dev")
+	writeEnvFiles(t, selfProc, map[string]string{
+		"sys/kernel/random/boot_id": "boot-1\n",
+		"self/mountinfo":            "1 0 0:1 / / rw - overlay overlay rw\n",
+	})
+	writeEnvFiles(t, proc, map[string]string{
+		"sys/kernel/random/boot_id": "boot-1\n",
+		"1/mountinfo":               "1 0 8:1 / / rw - ext4 /dev/sda1 rw\n",
+		"1/net/dev":                 testNetDev,
+	})
+	writeEnvFiles(t, sys, map[string]string{
+		"class/net/lo/mtu":     "65536\n",
+		"class/net/eth0/mtu":   "1500\n",
+		"class/block/sda/size": "1000\n",
+	})
+	writeEnvFiles(t, dev, map[string]string{"sda": ""})
+	symlink := func(target, path string) {
+		t.Helper()
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(selfProc, "self", "ns"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(proc, "1", "ns"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	symlink("mnt:[100]", filepath.Join(selfProc, "self", "ns", "mnt"))
+	symlink("mnt:[1]", filepath.Join(proc, "1", "ns", "mnt"))
+	return selfProc, proc, sys, dev
+}
+
+func TestValidateHostMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, selfProc, proc, sys, dev string)
+		wantErr []string
+	}{
+		{
+			name:   "host mounts",
+			modify: func(*testing.T, string, string, string, string) {},
+		},
+		{
+			name: "container /proc",
+			modify: func(t *testing.T, selfProc, proc, sys, dev string) {
+				link := filepath.Join(proc, "1", "ns", "mnt")
+				if err := os.Remove(link); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink("mnt:[100]", link); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: []string{"proc"},
+		},
+		{
+			name: "container /proc without namespaces",
+			modify: func(t *testing.T, selfProc, proc, sys, dev string) {
+				for _, link := range []string{filepath.Join(proc, "1", "ns", "mnt"), filepath.Join(selfProc, "self", "ns", "mnt")} {
+					if err := os.Remove(link); err != nil {
+						t.Fatal(err)
+					}
+				}
+				writeEnvFiles(t, proc, map[string]string{"1/mountinfo": "1 0 0:1 / / rw - overlay overlay rw\n"})
+			},
+			wantErr: []string{"proc"},
+		},
+		{
+			name: "other kernel",
+			modify: func(t *testing.T, selfProc, proc, sys, dev string) {
+				writeEnvFiles(t, proc, map[string]string{"sys/kernel/random/boot_id": "boot-2\n"})
+			},
+			wantErr: []string{"proc"},
+		},
+		{
+			name: "container /sys",
+			modify: func(t *testing.T, selfProc, proc, sys, dev string) {
+				if err := os.RemoveAll(filepath.Join(sys, "class", "net", "eth0")); err != nil {
+					t.Fatal(err)
+				}
+				writeEnvFiles(t, sys, map[string]string{"class/net/veth1/mtu": "1500\n"})
+			},
+			wantErr: []string{"sys"},
+		},
+		{
+			name: "container /dev",
+			modify: func(t *testing.T, selfProc, proc, sys, dev string) {
+				if err := os.Remove(filepath.Join(dev, "sda")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: []string{"dev"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selfProc, proc, sys, dev := newTestHostMounts(t)
+			tt.modify(t, selfProc, proc, sys, dev)
+			errs := ValidateHostMounts(selfProc, proc, sys, dev)
+			if len(errs) != len(tt.wantErr) {
+				t.Fatalf("expected %d errors, got %v", len(tt.wantErr), errs)
+			}
+			paths := map[string]string{"proc": proc, "sys": sys, "dev": dev}
+			for i, err := range errs {
+				if !errors.Is(err, ErrNotHostMount) {
+					t.Errorf("expected %v to wrap ErrNotHostMount", err)
+				}
+				if path := paths[tt.wantErr[i]]; !strings.HasPrefix(err.Error(), path) {
+					t.Errorf("expected %v to be about %s", err, path)
+				}
+			}
+		})
+	}
+}
+
+func TestInContainer(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("container", "")
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  bool
+	}{
+		{"host", map[string]string{"proc/self/cgroup": "0::/system.slice/agent.service\n"}, false},
+		{"docker", map[string]string{".dockerenv": ""}, true},
+		{"podman", map[string]string{"run/.containerenv": ""}, true},
+		{
+			"kubernetes cgroup v1",
+			map[string]string{"proc/self/cgroup": "4:memory:/kubepods/burstable/pod1/abc\n"},
+			true,
+		},
+		{
+			"cgroup v2 scope",
+			map[string]string{"proc/self/cgroup": "0::/system.slice/docker-abc.scope\n"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeEnvFiles(t, root, tt.files)
+			if got := InContainer(root, filepath.Join(root, "proc")); got != tt.want {
+				t.Errorf("InContainer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
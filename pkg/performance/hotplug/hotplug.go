@@ -0,0 +1,189 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package hotplug detects CPUs and memory brought online or taken offline while the agent
+// runs, e.g. when a hypervisor resizes a VM or an operator offlines a faulty core, so that
+// the hardware the agent reports follows without waiting for its next periodic refresh.
+//
+// sysfs attributes don't support inotify, so the Watcher polls the files below. Both are
+// a single small read.
+//
+// Data sources:
+//   - /sys/devices/system/cpu/online: the online CPUs
+//   - /proc/meminfo: MemTotal, which grows and shrinks as memory blocks are onlined and
+//     offlined
+//
+// Reference: https://docs.kernel.org/core-api/cpu_hotplug.html
+// Reference: https://docs.kernel.org/admin-guide/mm/memory-hotplug.html
+package hotplug
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/antimetal/agent/pkg/performance/procparse"
+)
+
+// DefaultInterval is how often the Watcher polls by default
+const DefaultInterval = 5 * time.Second
+
+// State is the online hardware of the host
+type State struct {
+	// CPUs are the online CPUs, in ascending order
+	CPUs []int
+	// MemoryBytes is the memory of the online memory blocks, as MemTotal
+	MemoryBytes uint64
+}
+
+// Equal returns whether s and o have the same CPUs and memory online
+func (s State) Equal(o State) bool {
+	return slices.Equal(s.CPUs, o.CPUs) && s.MemoryBytes == o.MemoryBytes
+}
+
+// Change is a change of the online hardware
+type Change struct {
+	Before State
+	After  State
+}
+
+// OnlinedCPUs returns the CPUs brought online by the change
+func (c Change) OnlinedCPUs() []int {
+	return difference(c.After.CPUs, c.Before.CPUs)
+}
+
+// OfflinedCPUs returns the CPUs taken offline by the change
+func (c Change) OfflinedCPUs() []int {
+	return difference(c.Before.CPUs, c.After.CPUs)
+}
+
+// difference returns the CPUs of a that aren't in b
+func difference(a, b []int) []int {
+	var cpus []int
+	for _, cpu := range a {
+		if _, found := slices.BinarySearch(b, cpu); !found {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+// Watcher polls the online CPUs and memory of the host and notifies its subscribers of
+// changes. It implements sigs.k8s.io/controller-runtime/pkg/manager.Runnable.
+type Watcher struct {
+	logger     logr.Logger
+	onlinePath string
+	memPath    string
+	interval   time.Duration
+
+	mu          sync.Mutex
+	state       State
+	subscribers []chan Change
+}
+
+// NewWatcher returns a Watcher of the host whose /proc and /sys are at procPath and
+// sysPath, polling every interval, DefaultInterval if 0. It fails if the online CPUs
+// can't be read, e.g. on platforms without CPU hotplug support.
+func NewWatcher(logger logr.Logger, procPath, sysPath string, interval time.Duration) (*Watcher, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	w := &Watcher{
+		logger:     logger,
+		onlinePath: filepath.Join(sysPath, "devices", "system", "cpu", "online"),
+		memPath:    filepath.Join(procPath, "meminfo"),
+		interval:   interval,
+	}
+	state, err := w.read()
+	if err != nil {
+		return nil, err
+	}
+	w.state = state
+	return w, nil
+}
+
+// State returns the online hardware as of the last poll
+func (w *Watcher) State() State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state
+}
+
+// Subscribe returns a channel receiving the changes of the online hardware. Subscribers
+// that fall behind receive the changes since the last one they received as one.
+func (w *Watcher) Subscribe() <-chan Change {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan Change, 1)
+	w.subscribers = append(w.subscribers, ch)
+	return ch
+}
+
+// Start polls until ctx is done
+func (w *Watcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll reads the online hardware and notifies the subscribers if it changed
+func (w *Watcher) poll() {
+	state, err := w.read()
+	if err != nil {
+		w.logger.Error(err, "failed to read online hardware")
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if state.Equal(w.state) {
+		return
+	}
+	change := Change{Before: w.state, After: state}
+	w.state = state
+	w.logger.Info("online hardware changed",
+		"cpus", len(state.CPUs), "onlinedCPUs", change.OnlinedCPUs(), "offlinedCPUs", change.OfflinedCPUs(),
+		"memoryBytesBefore", change.Before.MemoryBytes, "memoryBytes", state.MemoryBytes)
+
+	for _, ch := range w.subscribers {
+		// Only poll sends, so after draining a change the subscriber didn't receive yet
+		// there is room for the coalesced one
+		c := change
+		select {
+		case pending := <-ch:
+			c.Before = pending.Before
+		default:
+		}
+		ch <- c
+	}
+}
+
+// read reads the online CPUs and memory
+func (w *Watcher) read() (State, error) {
+	cpus, err := procparse.ParseCPUList(procparse.ReadStringFile(w.onlinePath))
+	if err != nil {
+		return State{}, fmt.Errorf("failed to read online CPUs from %s: %w", w.onlinePath, err)
+	}
+	meminfo, err := procparse.ParseKVFile(w.memPath)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to read %s: %w", w.memPath, err)
+	}
+	// The kernel lists CPUs in ascending order, but the comparisons rely on it
+	slices.Sort(cpus)
+	return State{CPUs: cpus, MemoryBytes: meminfo["MemTotal"] * 1024}, nil
+}
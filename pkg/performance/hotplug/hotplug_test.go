@@ -0,0 +1,87 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package hotplug
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHost struct {
+	t        *testing.T
+	procPath string
+	sysPath  string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	h := &fakeHost{t: t, procPath: t.TempDir(), sysPath: t.TempDir()}
+	require.NoError(t, os.MkdirAll(filepath.Join(h.sysPath, "devices", "system", "cpu"), 0o755))
+	return h
+}
+
+func (h *fakeHost) set(online string, memTotalKB string) {
+	require.NoError(h.t, os.WriteFile(filepath.Join(h.sysPath, "devices", "system", "cpu", "online"),
+		[]byte(online+"\n"), 0o644))
+	require.NoError(h.t, os.WriteFile(filepath.Join(h.procPath, "meminfo"),
+		[]byte("MemTotal:       "+memTotalKB+" kB\nMemFree:          1024 kB\n"), 0o644))
+}
+
+func TestNewWatcher(t *testing.T) {
+	h := newFakeHost(t)
+	_, err := NewWatcher(logr.Discard(), h.procPath, h.sysPath, 0)
+	assert.ErrorContains(t, err, "failed to read online CPUs")
+
+	h.set("0-3", "4096")
+	w, err := NewWatcher(logr.Discard(), h.procPath, h.sysPath, 0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultInterval, w.interval)
+	assert.Equal(t, State{CPUs: []int{0, 1, 2, 3}, MemoryBytes: 4 << 20}, w.State())
+}
+
+func TestWatcher_Poll(t *testing.T) {
+	h := newFakeHost(t)
+	h.set("0-3", "4096")
+	w, err := NewWatcher(logr.Discard(), h.procPath, h.sysPath, 0)
+	require.NoError(t, err)
+	changes := w.Subscribe()
+
+	w.poll()
+	assert.Empty(t, changes, "nothing changed")
+
+	h.set("0,2-5", "8192")
+	w.poll()
+	require.Len(t, changes, 1)
+	change := <-changes
+	assert.Equal(t, []int{4, 5}, change.OnlinedCPUs())
+	assert.Equal(t, []int{1}, change.OfflinedCPUs())
+	assert.Equal(t, uint64(4<<20), change.Before.MemoryBytes)
+	assert.Equal(t, uint64(8<<20), change.After.MemoryBytes)
+	assert.Equal(t, change.After, w.State())
+
+	// Changes a subscriber hasn't received yet are coalesced
+	h.set("0-1", "8192")
+	w.poll()
+	h.set("0-7", "2048")
+	w.poll()
+	require.Len(t, changes, 1)
+	change = <-changes
+	assert.Equal(t, []int{0, 2, 3, 4, 5}, change.Before.CPUs)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, change.After.CPUs)
+	assert.Equal(t, []int{1, 6, 7}, change.OnlinedCPUs())
+	assert.Empty(t, change.OfflinedCPUs())
+
+	// A failed read keeps the last state
+	require.NoError(t, os.Remove(filepath.Join(h.procPath, "meminfo")))
+	w.poll()
+	assert.Empty(t, changes)
+	assert.Equal(t, change.After, w.State())
+}
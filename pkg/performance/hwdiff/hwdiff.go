@@ -0,0 +1,229 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package hwdiff compares the hardware inventory of two performance snapshots, e.g. to
+// check that the changes made during a maintenance match its records.
+package hwdiff
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Hardware components compared
+const (
+	ComponentCPU      = "cpu"
+	ComponentMemory   = "memory"
+	ComponentInstance = "instance"
+	ComponentDisk     = "disk"
+	ComponentNetwork  = "network"
+)
+
+// Kind is the kind of a hardware change
+type Kind string
+
+const (
+	KindAdded     Kind = "added"
+	KindRemoved   Kind = "removed"
+	KindIncreased Kind = "increased"
+	KindDecreased Kind = "decreased"
+	KindChanged   Kind = "changed"
+)
+
+// Change is a single difference between two hardware inventories
+type Change struct {
+	Component string `json:"component"`
+	// Device is the disk or interface that changed, empty for node-wide components
+	Device string `json:"device,omitempty"`
+	Kind   Kind   `json:"kind"`
+	// Field is the property that changed, empty when a device was added or removed
+	Field  string `json:"field,omitempty"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+func (c Change) String() string {
+	s := c.Component
+	if c.Device != "" {
+		s += " " + c.Device
+	}
+	if c.Field != "" {
+		s += " " + c.Field
+	}
+	s += " " + string(c.Kind)
+	if c.Field != "" {
+		s += fmt.Sprintf(": %s -> %s", c.Before, c.After)
+	}
+	return s
+}
+
+// Diff returns the hardware changes from before to after. Components are only compared
+// when both snapshots have them, so a collector that didn't run in one of them doesn't
+// show up as removed hardware. Changes are ordered by component and device.
+func Diff(before, after *performance.Metrics) []Change {
+	var changes []Change
+	changes = append(changes, diffCPU(before, after)...)
+	changes = append(changes, diffMemory(before, after)...)
+	changes = append(changes, diffInstance(before, after)...)
+	changes = append(changes, diffDisks(before.Disks, after.Disks)...)
+	if before.NetworkInfo != nil && after.NetworkInfo != nil {
+		changes = append(changes, diffInterfaces(before.NetworkInfo.Interfaces, after.NetworkInfo.Interfaces)...)
+	}
+	return changes
+}
+
+// cpuCount returns the number of CPUs in m, preferring the per-CPU statistics over the
+// cost hints. It returns false if m has neither.
+func cpuCount(m *performance.Metrics) (int, bool) {
+	count := 0
+	for _, cpu := range m.CPU {
+		// Index -1 is the aggregate of all CPUs
+		if cpu.CPUIndex >= 0 {
+			count++
+		}
+	}
+	if count > 0 {
+		return count, true
+	}
+	if m.CostHints != nil && m.CostHints.CPUCores > 0 {
+		return m.CostHints.CPUCores, true
+	}
+	return 0, false
+}
+
+// memoryBytes returns the usable memory in m, preferring the memory statistics over the
+// cost hints. It returns false if m has neither.
+func memoryBytes(m *performance.Metrics) (uint64, bool) {
+	if m.Memory != nil && m.Memory.MemTotal > 0 {
+		return m.Memory.MemTotal * 1024, true
+	}
+	if m.CostHints != nil && m.CostHints.MemoryBytes > 0 {
+		return m.CostHints.MemoryBytes, true
+	}
+	return 0, false
+}
+
+func diffCPU(before, after *performance.Metrics) []Change {
+	b, ok := cpuCount(before)
+	if !ok {
+		return nil
+	}
+	a, ok := cpuCount(after)
+	if !ok {
+		return nil
+	}
+	return diffNumber(ComponentCPU, "", "CPUs", uint64(b), uint64(a))
+}
+
+func diffMemory(before, after *performance.Metrics) []Change {
+	b, ok := memoryBytes(before)
+	if !ok {
+		return nil
+	}
+	a, ok := memoryBytes(after)
+	if !ok {
+		return nil
+	}
+	return diffNumber(ComponentMemory, "", "Bytes", b, a)
+}
+
+func diffInstance(before, after *performance.Metrics) []Change {
+	if before.CostHints == nil || after.CostHints == nil {
+		return nil
+	}
+	return diffString(ComponentInstance, "", "InstanceType", before.CostHints.InstanceType, after.CostHints.InstanceType)
+}
+
+func diffDisks(before, after []performance.DiskStats) []Change {
+	if before == nil || after == nil {
+		return nil
+	}
+	return diffDevices(ComponentDisk, before, after,
+		func(d performance.DiskStats) string { return d.Device },
+		func(b, a performance.DiskStats) []Change {
+			return diffString(ComponentDisk, b.Device, "DeviceNumber",
+				fmt.Sprintf("%d:%d", b.Major, b.Minor), fmt.Sprintf("%d:%d", a.Major, a.Minor))
+		})
+}
+
+func diffInterfaces(before, after []performance.NetworkInterfaceInfo) []Change {
+	return diffDevices(ComponentNetwork, before, after,
+		func(i performance.NetworkInterfaceInfo) string { return i.Name },
+		func(b, a performance.NetworkInterfaceInfo) []Change {
+			var changes []Change
+			changes = append(changes, diffString(ComponentNetwork, b.Name, "MACAddress", b.MACAddress, a.MACAddress)...)
+			changes = append(changes, diffString(ComponentNetwork, b.Name, "Driver", b.Driver, a.Driver)...)
+			changes = append(changes, diffNumber(ComponentNetwork, b.Name, "Speed", b.Speed, a.Speed)...)
+			changes = append(changes, diffString(ComponentNetwork, b.Name, "Duplex", b.Duplex, a.Duplex)...)
+			changes = append(changes, diffNumber(ComponentNetwork, b.Name, "MTU", b.MTU, a.MTU)...)
+			changes = append(changes, diffString(ComponentNetwork, b.Name, "Master", b.Master, a.Master)...)
+			return changes
+		})
+}
+
+// diffDevices reports the devices only in before as removed, the devices only in after as
+// added, and the changes returned by compare for the devices in both
+func diffDevices[T any](component string, before, after []T, name func(T) string, compare func(b, a T) []Change) []Change {
+	afterByName := make(map[string]T, len(after))
+	for _, dev := range after {
+		afterByName[name(dev)] = dev
+	}
+
+	var changes []Change
+	seen := make(map[string]bool, len(before))
+	for _, b := range before {
+		seen[name(b)] = true
+		a, ok := afterByName[name(b)]
+		if !ok {
+			changes = append(changes, Change{Component: component, Device: name(b), Kind: KindRemoved})
+			continue
+		}
+		changes = append(changes, compare(b, a)...)
+	}
+	for _, a := range after {
+		if !seen[name(a)] {
+			changes = append(changes, Change{Component: component, Device: name(a), Kind: KindAdded})
+		}
+	}
+	slices.SortStableFunc(changes, func(x, y Change) int { return cmp.Compare(x.Device, y.Device) })
+	return changes
+}
+
+func diffNumber(component, device, field string, before, after uint64) []Change {
+	if before == after {
+		return nil
+	}
+	kind := KindIncreased
+	if after < before {
+		kind = KindDecreased
+	}
+	return []Change{{
+		Component: component,
+		Device:    device,
+		Kind:      kind,
+		Field:     field,
+		Before:    strconv.FormatUint(before, 10),
+		After:     strconv.FormatUint(after, 10),
+	}}
+}
+
+func diffString(component, device, field, before, after string) []Change {
+	if before == after {
+		return nil
+	}
+	return []Change{{
+		Component: component,
+		Device:    device,
+		Kind:      KindChanged,
+		Field:     field,
+		Before:    before,
+		After:     after,
+	}}
+}
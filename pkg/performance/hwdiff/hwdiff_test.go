@@ -0,0 +1,137 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package hwdiff_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/hwdiff"
+)
+
+func baseline() *performance.Metrics {
+	return &performance.Metrics{
+		CostHints: &performance.CostHints{
+			InstanceType: "m5.xlarge",
+			CPUCores:     4,
+			MemoryBytes:  16 << 30,
+		},
+		Disks: []performance.DiskStats{
+			{Device: "nvme0n1", Major: 259, Minor: 0},
+			{Device: "nvme1n1", Major: 259, Minor: 1},
+		},
+		NetworkInfo: &performance.NetworkInfo{
+			Interfaces: []performance.NetworkInterfaceInfo{
+				{Name: "eth0", MACAddress: "02:00:00:00:00:01", Driver: "ena", Speed: 10000, Duplex: "full", MTU: 9001},
+				{Name: "lo", MTU: 65536, Virtual: true},
+			},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(m *performance.Metrics)
+		expected []hwdiff.Change
+	}{
+		{
+			name:   "no changes",
+			modify: func(m *performance.Metrics) {},
+		},
+		{
+			name: "disk removed and added",
+			modify: func(m *performance.Metrics) {
+				m.Disks = []performance.DiskStats{
+					{Device: "nvme0n1", Major: 259, Minor: 0},
+					{Device: "nvme2n1", Major: 259, Minor: 2},
+				}
+			},
+			expected: []hwdiff.Change{
+				{Component: hwdiff.ComponentDisk, Device: "nvme1n1", Kind: hwdiff.KindRemoved},
+				{Component: hwdiff.ComponentDisk, Device: "nvme2n1", Kind: hwdiff.KindAdded},
+			},
+		},
+		{
+			name: "memory reduced and instance resized",
+			modify: func(m *performance.Metrics) {
+				m.CostHints.MemoryBytes = 8 << 30
+				m.CostHints.CPUCores = 2
+				m.CostHints.InstanceType = "m5.large"
+			},
+			expected: []hwdiff.Change{
+				{Component: hwdiff.ComponentCPU, Kind: hwdiff.KindDecreased, Field: "CPUs", Before: "4", After: "2"},
+				{Component: hwdiff.ComponentMemory, Kind: hwdiff.KindDecreased, Field: "Bytes", Before: "17179869184", After: "8589934592"},
+				{Component: hwdiff.ComponentInstance, Kind: hwdiff.KindChanged, Field: "InstanceType", Before: "m5.xlarge", After: "m5.large"},
+			},
+		},
+		{
+			name: "NIC speed changed",
+			modify: func(m *performance.Metrics) {
+				m.NetworkInfo.Interfaces[0].Speed = 1000
+				m.NetworkInfo.Interfaces[0].Duplex = "half"
+			},
+			expected: []hwdiff.Change{
+				{Component: hwdiff.ComponentNetwork, Device: "eth0", Kind: hwdiff.KindDecreased, Field: "Speed", Before: "10000", After: "1000"},
+				{Component: hwdiff.ComponentNetwork, Device: "eth0", Kind: hwdiff.KindChanged, Field: "Duplex", Before: "full", After: "half"},
+			},
+		},
+		{
+			name: "components missing from one snapshot are not compared",
+			modify: func(m *performance.Metrics) {
+				m.CostHints = nil
+				m.Disks = nil
+				m.NetworkInfo = nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := baseline()
+			tt.modify(after)
+			changes := hwdiff.Diff(baseline(), after)
+			if !reflect.DeepEqual(changes, tt.expected) {
+				t.Errorf("Diff() = %v, want %v", changes, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiff_PrefersDetailedStats(t *testing.T) {
+	before := baseline()
+	before.Memory = &performance.MemoryStats{MemTotal: 16 << 20}
+	after := baseline()
+	// The cost hints agree, but the memory statistics show a failed DIMM
+	after.Memory = &performance.MemoryStats{MemTotal: 12 << 20}
+
+	changes := hwdiff.Diff(before, after)
+	expected := []hwdiff.Change{
+		{Component: hwdiff.ComponentMemory, Kind: hwdiff.KindDecreased, Field: "Bytes", Before: "17179869184", After: "12884901888"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Diff() = %v, want %v", changes, expected)
+	}
+}
+
+func TestChange_String(t *testing.T) {
+	tests := []struct {
+		change   hwdiff.Change
+		expected string
+	}{
+		{hwdiff.Change{Component: "disk", Device: "sdb", Kind: hwdiff.KindRemoved}, "disk sdb removed"},
+		{
+			hwdiff.Change{Component: "network", Device: "eth0", Kind: hwdiff.KindDecreased, Field: "Speed", Before: "10000", After: "1000"},
+			"network eth0 Speed decreased: 10000 -> 1000",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.change.String(); got != tt.expected {
+			t.Errorf("String() = %q, want %q", got, tt.expected)
+		}
+	}
+}
@@ -7,12 +7,29 @@
 package performance
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/antimetal/agent/pkg/ebpf"
+	"github.com/antimetal/agent/pkg/tracing"
 )
 
+var tracer = tracing.Tracer("github.com/antimetal/agent/pkg/performance")
+
+// Throttle delays collections, e.g. to keep the agent within a CPU budget
+type Throttle interface {
+	// Wait blocks until the next collection may run or ctx is done
+	Wait(ctx context.Context) error
+}
+
 // Manager coordinates collector registration and will eventually handle collection
 type Manager struct {
 	config      CollectionConfig
@@ -20,6 +37,27 @@ type Manager struct {
 	registry    *CollectorRegistry
 	nodeName    string
 	clusterName string
+	tags        func() map[string]string
+	onSnapshot  func(*Snapshot)
+	throttle    Throttle
+	// state persists the state of stateful collectors, nil if it isn't persisted
+	state *StateStore
+	// ebpfSupport is nil if collectors requiring eBPF can run on this host
+	ebpfSupport error
+	environment Environment
+	// host is shared with the collectors, zero if it couldn't be read
+	host HostContext
+
+	mu sync.Mutex
+	// running tracks the collectors whose Collect hasn't returned yet
+	running map[MetricType]bool
+	// lastSuccess is when each collector last collected without error
+	lastSuccess map[MetricType]time.Time
+	// burstCollectors are sampled during bursts. bursting is set while a burst runs, and
+	// burst is the last one that ended, until it is attached to a snapshot.
+	burstCollectors []PointCollector
+	bursting        bool
+	burst           *Burst
 }
 
 type ManagerOptions struct {
@@ -27,6 +65,16 @@ type ManagerOptions struct {
 	Logger      logr.Logger
 	NodeName    string
 	ClusterName string
+	// Tags returns the tags attached to every snapshot, if set
+	Tags func() map[string]string
+	// OnSnapshot is called with every snapshot collected by Start
+	OnSnapshot func(*Snapshot)
+	// Throttle is waited for before every collection of Start and every sample of a burst,
+	// if set
+	Throttle Throttle
+	// StateDir persists the state of StatefulCollectors to this directory so that their
+	// rates survive agent restarts. If empty, the state is lost on restart.
+	StateDir string
 }
 
 func NewManager(opts ManagerOptions) (*Manager, error) {
@@ -68,13 +116,76 @@ func NewManager(opts ManagerOptions) (*Manager, error) {
 		registry:    NewCollectorRegistry(opts.Logger),
 		nodeName:    nodeName,
 		clusterName: opts.ClusterName,
+		tags:        opts.Tags,
+		onSnapshot:  opts.OnSnapshot,
+		throttle:    opts.Throttle,
+		ebpfSupport: ebpf.CheckSupport(config.HostSysPath),
+		environment: DetectEnvironment(config.HostProcPath, config.HostSysPath),
+		running:     make(map[MetricType]bool),
+		lastSuccess: make(map[MetricType]time.Time),
+	}
+	if host, err := ReadHostContext(config.HostProcPath); err != nil {
+		m.logger.Info("collectors will read the host context themselves", "reason", err.Error())
+	} else {
+		m.host = host
+	}
+	if m.ebpfSupport != nil {
+		m.logger.Info("eBPF collectors are disabled", "reason", m.ebpfSupport.Error())
+	}
+	m.logger.Info("detected environment", "virtualized", m.environment.Virtualized,
+		"cloud", m.environment.Cloud, "hardwarePMU", m.environment.HardwarePMU)
+	if opts.StateDir != "" {
+		state, err := NewStateStore(opts.StateDir, config.HostProcPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open collector state: %w", err)
+		}
+		m.state = state
 	}
 
 	return m, nil
 }
 
+// RegisterPointCollector registers collector. The state of a StatefulCollector saved
+// during the current boot is restored.
 func (m *Manager) RegisterPointCollector(collector PointCollector) error {
-	return m.registry.RegisterPoint(collector)
+	if err := m.registry.RegisterPoint(collector); err != nil {
+		return err
+	}
+	if stateful, ok := collector.(StatefulCollector); ok && m.state != nil {
+		m.restoreState(stateful)
+	}
+	return nil
+}
+
+// restoreState restores the saved state of collector. A state that can't be restored is
+// dropped; the collector then starts over as after a reboot.
+func (m *Manager) restoreState(collector StatefulCollector) {
+	data, ok, err := m.state.Load(collector.Type())
+	if err != nil {
+		m.logger.Error(err, "failed to load collector state", "type", collector.Type())
+		return
+	}
+	if !ok {
+		return
+	}
+	if err := collector.RestoreState(data); err != nil {
+		m.logger.Error(err, "failed to restore collector state", "type", collector.Type())
+	}
+}
+
+// saveState persists the state of collector after a successful collection
+func (m *Manager) saveState(collector StatefulCollector) {
+	data, err := collector.SaveState()
+	if err != nil {
+		m.logger.Error(err, "failed to get collector state", "type", collector.Type())
+		return
+	}
+	if data == nil {
+		return
+	}
+	if err := m.state.Save(collector.Type(), data); err != nil {
+		m.logger.Error(err, "failed to save collector state", "type", collector.Type())
+	}
 }
 
 func (m *Manager) RegisterContinuousCollector(collector ContinuousCollector) error {
@@ -86,6 +197,11 @@ func (m *Manager) GetRegistry() *CollectorRegistry {
 	return m.registry
 }
 
+// Environment returns the environment detected on the host
+func (m *Manager) Environment() Environment {
+	return m.environment
+}
+
 // GetConfig returns the current configuration
 func (m *Manager) GetConfig() CollectionConfig {
 	return m.config
@@ -101,9 +217,156 @@ func (m *Manager) GetClusterName() string {
 	return m.clusterName
 }
 
+// CollectSnapshot runs every enabled point collector once, in metric type order except
+// that dependent collectors run after their dependencies, and returns the combined
+// results. Collectors share the host's HostContext and the data of their dependencies
+// through the context of their Collect call. A failing collector doesn't fail the snapshot; its error
+// is recorded in the snapshot's CollectorRun stats. Collectors requiring eBPF on a host
+// that doesn't support it aren't run and are reported as unsupported. Each collector is
+// bounded by CollectionConfig.CollectorTimeout.
+//
+// The snapshot is traced with a span, with a child span for each collector run.
+func (m *Manager) CollectSnapshot(ctx context.Context) *Snapshot {
+	ctx, span := tracer.Start(ctx, "performance.CollectSnapshot")
+	defer span.End()
+	start := time.Now()
+	snapshot := &Snapshot{
+		Timestamp:   start,
+		NodeName:    m.nodeName,
+		ClusterName: m.clusterName,
+		CollectorRun: CollectorRunInfo{
+			CollectorStats: make(map[MetricType]CollectorStat),
+		},
+	}
+	if m.tags != nil {
+		snapshot.Tags = m.tags()
+	}
+
+	shared := newCollection(m.host, nil)
+	ctx = context.WithValue(ctx, collectionKey{}, shared)
+	for _, collector := range m.registry.GetOrderedPoint(m.config) {
+		if ctx.Err() != nil {
+			break
+		}
+		if collector.Capabilities().RequiresEBPF && m.ebpfSupport != nil {
+			snapshot.CollectorRun.CollectorStats[collector.Type()] = CollectorStat{
+				Status: CollectorStatusUnsupported,
+				Error:  m.ebpfSupport,
+			}
+			continue
+		}
+
+		collectorStart := time.Now()
+		collectorCtx, collectorSpan := tracer.Start(ctx, "performance.Collect",
+			trace.WithAttributes(attribute.String("collector.type", string(collector.Type()))))
+		data, err := m.collect(collectorCtx, collector)
+		tracing.End(collectorSpan, err)
+		stat := CollectorStat{
+			Status:   CollectorStatusActive,
+			Duration: time.Since(collectorStart),
+			Error:    err,
+			Data:     data,
+		}
+		if err != nil {
+			stat.Status = CollectorStatusFailed
+			m.logger.Error(err, "collector failed", "type", collector.Type(), "name", collector.Name())
+		} else {
+			shared.setData(collector.Type(), data)
+			if !snapshot.Metrics.set(data) {
+				m.logger.V(1).Info("collector returned unknown data type",
+					"type", collector.Type(), "dataType", fmt.Sprintf("%T", data))
+			}
+			if stateful, ok := collector.(StatefulCollector); ok && m.state != nil {
+				m.saveState(stateful)
+			}
+			m.mu.Lock()
+			m.lastSuccess[collector.Type()] = collectorStart
+			m.mu.Unlock()
+		}
+		snapshot.CollectorRun.CollectorStats[collector.Type()] = stat
+	}
+
+	snapshot.CollectorRun.Duration = time.Since(start)
+	span.SetAttributes(attribute.Int("collectors", len(snapshot.CollectorRun.CollectorStats)))
+	return snapshot
+}
+
+// LastSuccessfulCollections returns when each point collector last started a collection
+// that succeeded. Collectors that never succeeded are left out.
+func (m *Manager) LastSuccessfulCollections() map[MetricType]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.lastSuccess)
+}
+
+// collect runs collector with the collector timeout. Reads of a hung filesystem (e.g. a
+// dead NFS mount or a device stuck in a driver timeout) can't be interrupted, so a
+// collector that doesn't return in time is abandoned and fails, and isn't run again until
+// the abandoned call returned.
+func (m *Manager) collect(ctx context.Context, collector PointCollector) (any, error) {
+	metricType := collector.Type()
+	m.mu.Lock()
+	if m.running[metricType] {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("previous collection is still running")
+	}
+	m.running[metricType] = true
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, m.config.CollectorTimeout)
+	defer cancel()
+
+	type result struct {
+		data any
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := InstrumentedCollect(ctx, collector)
+		m.mu.Lock()
+		delete(m.running, metricType)
+		m.mu.Unlock()
+		done <- result{data: data, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("collector didn't finish in time: %w", ctx.Err())
+	}
+}
+
+// Start collects a snapshot every collection interval and passes it to OnSnapshot until
+// ctx is done. Snapshots whose CPU utilization crosses the burst threshold start a burst,
+// attached to the first snapshot collected after it ended. It implements
+// controller-runtime's manager.Runnable.
+func (m *Manager) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if m.throttle != nil && m.throttle.Wait(ctx) != nil {
+				return nil
+			}
+			snapshot := m.CollectSnapshot(ctx)
+			if ctx.Err() != nil {
+				return nil
+			}
+			snapshot.Metrics.Burst = m.takeBurst()
+			m.maybeStartBurst(ctx, snapshot)
+			if m.onSnapshot != nil {
+				m.onSnapshot(snapshot)
+			}
+		}
+	}
+}
+
 // TODO: Add methods for:
 // - Starting/stopping collection based on external signals
-// - Performing on-demand collection
 // - Managing collector lifecycle
-// - Integrating with BadgerDB for storage
 // - Forwarding data to intake service
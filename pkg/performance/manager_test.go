@@ -0,0 +1,381 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+
+	"github.com/antimetal/agent/pkg/ebpf"
+)
+
+type fakePointCollector struct {
+	BaseCollector
+	data any
+	err  error
+}
+
+func (f *fakePointCollector) Collect(ctx context.Context) (any, error) {
+	return f.data, f.err
+}
+
+func newFakePointCollector(metricType MetricType, data any, err error) *fakePointCollector {
+	return &fakePointCollector{
+		BaseCollector: NewBaseCollector(metricType, string(metricType), logr.Discard(), CollectionConfig{}, CollectorCapabilities{SupportsOneShot: true}),
+		data:          data,
+		err:           err,
+	}
+}
+
+func TestManager_CollectSnapshot(t *testing.T) {
+	m, err := NewManager(ManagerOptions{
+		// NewManager requires a logger with a sink, which logr.Discard() doesn't have
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Tags:     func() map[string]string { return map[string]string{"rack": "r12"} },
+		Config: CollectionConfig{
+			EnabledCollectors: map[MetricType]bool{
+				MetricTypeLoad:   true,
+				MetricTypeMemory: true,
+				MetricTypeTCP:    false,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	load := &LoadStats{Load1Min: 1.5}
+	collectErr := errors.New("boom")
+	for _, c := range []PointCollector{
+		newFakePointCollector(MetricTypeLoad, load, nil),
+		newFakePointCollector(MetricTypeMemory, nil, collectErr),
+		newFakePointCollector(MetricTypeTCP, &TCPStats{}, nil),
+	} {
+		if err := m.RegisterPointCollector(c); err != nil {
+			t.Fatalf("failed to register collector: %v", err)
+		}
+	}
+
+	snapshot := m.CollectSnapshot(context.Background())
+
+	if snapshot.NodeName != "node-1" {
+		t.Errorf("NodeName = %q, want %q", snapshot.NodeName, "node-1")
+	}
+	if snapshot.Tags["rack"] != "r12" {
+		t.Errorf("Tags = %v, want the manager's tags", snapshot.Tags)
+	}
+	if snapshot.Metrics.Load != load {
+		t.Errorf("Metrics.Load = %v, want %v", snapshot.Metrics.Load, load)
+	}
+	if snapshot.Metrics.TCP != nil {
+		t.Errorf("disabled collector should not run, got TCP = %v", snapshot.Metrics.TCP)
+	}
+
+	stats := snapshot.CollectorRun.CollectorStats
+	if len(stats) != 2 {
+		t.Fatalf("CollectorStats length = %d, want 2", len(stats))
+	}
+	if stats[MetricTypeLoad].Status != CollectorStatusActive {
+		t.Errorf("load status = %v, want %v", stats[MetricTypeLoad].Status, CollectorStatusActive)
+	}
+	if stats[MetricTypeMemory].Status != CollectorStatusFailed {
+		t.Errorf("memory status = %v, want %v", stats[MetricTypeMemory].Status, CollectorStatusFailed)
+	}
+	if !errors.Is(stats[MetricTypeMemory].Error, collectErr) {
+		t.Errorf("memory error = %v, want %v", stats[MetricTypeMemory].Error, collectErr)
+	}
+
+	last := m.LastSuccessfulCollections()
+	if len(last) != 1 || last[MetricTypeLoad].Before(snapshot.Timestamp) {
+		t.Errorf("LastSuccessfulCollections = %v, want only load at or after %v", last, snapshot.Timestamp)
+	}
+}
+
+func TestManager_Start(t *testing.T) {
+	snapshots := make(chan *Snapshot, 10)
+	m, err := NewManager(ManagerOptions{
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Config: CollectionConfig{
+			Interval:          10 * time.Millisecond,
+			EnabledCollectors: map[MetricType]bool{MetricTypeLoad: true},
+		},
+		OnSnapshot: func(s *Snapshot) {
+			select {
+			case snapshots <- s:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	load := &LoadStats{Load1Min: 0.5}
+	if err := m.RegisterPointCollector(newFakePointCollector(MetricTypeLoad, load, nil)); err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.Start(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-snapshots:
+			if s.Metrics.Load != load {
+				t.Errorf("Metrics.Load = %v, want %v", s.Metrics.Load, load)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for snapshot")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after context cancellation")
+	}
+}
+
+func TestManager_CollectSnapshot_EBPFUnsupported(t *testing.T) {
+	m, err := NewManager(ManagerOptions{
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Config: CollectionConfig{
+			EnabledCollectors: map[MetricType]bool{MetricTypeLoad: true, MetricTypeKernel: true},
+			// No kernel/btf/vmlinux
+			HostSysPath: t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	bpf := newFakePointCollector(MetricTypeKernel, []KernelMessage{}, nil)
+	bpf.capabilities.RequiresEBPF = true
+	load := &LoadStats{Load1Min: 1}
+	for _, c := range []PointCollector{newFakePointCollector(MetricTypeLoad, load, nil), bpf} {
+		if err := m.RegisterPointCollector(c); err != nil {
+			t.Fatalf("failed to register collector: %v", err)
+		}
+	}
+
+	snapshot := m.CollectSnapshot(context.Background())
+
+	stat := snapshot.CollectorRun.CollectorStats[MetricTypeKernel]
+	if stat.Status != CollectorStatusUnsupported {
+		t.Errorf("eBPF collector status = %v, want %v", stat.Status, CollectorStatusUnsupported)
+	}
+	if !errors.Is(stat.Error, ebpf.ErrUnsupported) {
+		t.Errorf("eBPF collector error = %v, want %v", stat.Error, ebpf.ErrUnsupported)
+	}
+	if snapshot.Metrics.Kernel != nil {
+		t.Errorf("unsupported collector should not run, got Kernel = %v", snapshot.Metrics.Kernel)
+	}
+	if snapshot.Metrics.Load != load {
+		t.Errorf("Metrics.Load = %v, want %v", snapshot.Metrics.Load, load)
+	}
+}
+
+// blockingPointCollector ignores its context and blocks until released, like a read of a
+// hung filesystem
+type blockingPointCollector struct {
+	*fakePointCollector
+	release chan struct{}
+}
+
+func (b *blockingPointCollector) Collect(ctx context.Context) (any, error) {
+	<-b.release
+	return b.data, nil
+}
+
+func TestManager_CollectSnapshot_Timeout(t *testing.T) {
+	m, err := NewManager(ManagerOptions{
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Config: CollectionConfig{
+			CollectorTimeout: 50 * time.Millisecond,
+			EnabledCollectors: map[MetricType]bool{
+				MetricTypeLoad:   true,
+				MetricTypeMemory: true,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	load := &LoadStats{Load1Min: 1.5}
+	hung := &blockingPointCollector{
+		fakePointCollector: newFakePointCollector(MetricTypeMemory, &MemoryStats{}, nil),
+		release:            make(chan struct{}),
+	}
+	for _, c := range []PointCollector{newFakePointCollector(MetricTypeLoad, load, nil), hung} {
+		if err := m.RegisterPointCollector(c); err != nil {
+			t.Fatalf("failed to register collector: %v", err)
+		}
+	}
+
+	snapshot := m.CollectSnapshot(context.Background())
+	if snapshot.Metrics.Load != load {
+		t.Errorf("Metrics.Load = %v, want %v", snapshot.Metrics.Load, load)
+	}
+	stat := snapshot.CollectorRun.CollectorStats[MetricTypeMemory]
+	if stat.Status != CollectorStatusFailed || !errors.Is(stat.Error, context.DeadlineExceeded) {
+		t.Fatalf("memory stat = %+v, want failed with deadline exceeded", stat)
+	}
+
+	// The abandoned call is still running, so the collector isn't run again
+	snapshot = m.CollectSnapshot(context.Background())
+	stat = snapshot.CollectorRun.CollectorStats[MetricTypeMemory]
+	if stat.Status != CollectorStatusFailed || stat.Error == nil || errors.Is(stat.Error, context.DeadlineExceeded) {
+		t.Fatalf("memory stat = %+v, want failed because the previous run is still running", stat)
+	}
+
+	close(hung.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		running := m.running[MetricTypeMemory]
+		m.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned collector did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	snapshot = m.CollectSnapshot(context.Background())
+	if stat := snapshot.CollectorRun.CollectorStats[MetricTypeMemory]; stat.Status != CollectorStatusActive {
+		t.Fatalf("memory stat = %+v, want active after the collector recovered", stat)
+	}
+}
+
+func TestManager_Burst(t *testing.T) {
+	snapshots := make(chan *Snapshot, 100)
+	m, err := NewManager(ManagerOptions{
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Config: CollectionConfig{
+			Interval:          10 * time.Millisecond,
+			EnabledCollectors: map[MetricType]bool{MetricTypeCPU: true},
+			BurstThreshold:    80,
+			BurstInterval:     time.Millisecond,
+			BurstDuration:     20 * time.Millisecond,
+		},
+		OnSnapshot: func(s *Snapshot) {
+			select {
+			case snapshots <- s:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	busy := []CPUStats{{CPUIndex: -1, Utilization: 95}, {CPUIndex: 0, Utilization: 95}}
+	if err := m.RegisterPointCollector(newFakePointCollector(MetricTypeCPU, busy, nil)); err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+	if err := m.RegisterBurstCollector(newFakePointCollector(MetricTypeLoad, &LoadStats{}, nil)); err == nil {
+		t.Errorf("RegisterBurstCollector() of a load collector succeeded, want an error")
+	}
+	if err := m.RegisterBurstCollector(newFakePointCollector(MetricTypeCPU, busy, nil)); err != nil {
+		t.Fatalf("failed to register burst collector: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = m.Start(ctx)
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case s := <-snapshots:
+			if s.Metrics.Burst == nil {
+				continue
+			}
+			burst := s.Metrics.Burst
+			if burst.Utilization != 95 {
+				t.Errorf("Burst.Utilization = %v, want 95", burst.Utilization)
+			}
+			if len(burst.Samples) == 0 {
+				t.Fatal("burst has no samples")
+			}
+			if got := burst.Samples[0].CPU; len(got) != len(busy) {
+				t.Errorf("burst sample CPU = %v, want %v", got, busy)
+			}
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for a snapshot with a burst")
+		}
+	}
+}
+
+// countingThrottle counts the collections it was waited for
+type countingThrottle struct {
+	waits atomic.Int32
+}
+
+func (c *countingThrottle) Wait(ctx context.Context) error {
+	c.waits.Add(1)
+	return ctx.Err()
+}
+
+func TestManager_Throttle(t *testing.T) {
+	throttle := &countingThrottle{}
+	snapshots := make(chan *Snapshot, 10)
+	m, err := NewManager(ManagerOptions{
+		Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+		NodeName: "node-1",
+		Config: CollectionConfig{
+			Interval:          time.Millisecond,
+			EnabledCollectors: map[MetricType]bool{MetricTypeLoad: true},
+		},
+		Throttle: throttle,
+		OnSnapshot: func(s *Snapshot) {
+			select {
+			case snapshots <- s:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = m.Start(ctx)
+	}()
+	select {
+	case <-snapshots:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for snapshot")
+	}
+	if throttle.waits.Load() == 0 {
+		t.Error("collection ran without waiting for the throttle")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/antimetal/agent/pkg/failpoint"
+)
+
+// Operations of the collector metrics
+const (
+	operationCollect = "collect"
+	operationStart   = "start"
+)
+
+var (
+	collectorDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "antimetal_collector_duration_seconds",
+		Help: "Duration of the Collect calls of point collectors and Start calls of continuous collectors.",
+		// 1ms to ~33s, collectors being bounded by the collector timeout
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"collector", "operation"})
+	collectorErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "antimetal_collector_errors_total",
+		Help: "Number of Collect or Start calls of collectors that returned an error.",
+	}, []string{"collector", "operation"})
+	collectorDataSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "antimetal_collector_data_size",
+		Help: "Number of entries returned by the last successful collection: the length of a slice or map, 1 otherwise.",
+	}, []string{"collector"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		collectorDurationSeconds,
+		collectorErrorsTotal,
+		collectorDataSize,
+	)
+}
+
+// InstrumentedCollect calls collector.Collect, recording its duration, whether it failed
+// and the size of the data it returned, labeled by the collector's MetricType. Collectors
+// don't instrument themselves; the Manager collects through this. The collector's
+// failpoint is checked first.
+func InstrumentedCollect(ctx context.Context, collector PointCollector) (any, error) {
+	start := time.Now()
+	var data any
+	err := failpoint.Inject(failpoint.Collector(string(collector.Type())))
+	if err == nil {
+		data, err = collector.Collect(ctx)
+	}
+	observe(collector.Type(), operationCollect, start, err)
+	if err == nil {
+		collectorDataSize.WithLabelValues(string(collector.Type())).Set(float64(dataSize(data)))
+	}
+	return data, err
+}
+
+// InstrumentedStart calls collector.Start, recording its duration and whether it failed,
+// labeled by the collector's MetricType. The collector's failpoint is checked first.
+func InstrumentedStart(ctx context.Context, collector ContinuousCollector) (<-chan any, error) {
+	start := time.Now()
+	if err := failpoint.Inject(failpoint.Collector(string(collector.Type()))); err != nil {
+		observe(collector.Type(), operationStart, start, err)
+		return nil, err
+	}
+	ch, err := collector.Start(ctx)
+	observe(collector.Type(), operationStart, start, err)
+	return ch, err
+}
+
+func observe(metricType MetricType, operation string, start time.Time, err error) {
+	collectorDurationSeconds.WithLabelValues(string(metricType), operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		collectorErrorsTotal.WithLabelValues(string(metricType), operation).Inc()
+	}
+}
+
+// dataSize returns the number of entries of data collected: the length of a slice or map,
+// 0 for nil and 1 for anything else, e.g. a pointer to a stats struct
+func dataSize(data any) int {
+	v := reflect.ValueOf(data)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0
+	case reflect.Slice, reflect.Map:
+		return v.Len()
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+	}
+	return 1
+}
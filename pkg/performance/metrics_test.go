@@ -0,0 +1,91 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/antimetal/agent/pkg/failpoint"
+)
+
+func TestInstrumentedCollect(t *testing.T) {
+	ok := newFakePointCollector("metrics-test-ok", []CPUStats{{CPUIndex: -1}, {CPUIndex: 0}}, nil)
+	failing := newFakePointCollector("metrics-test-failing", nil, errors.New("boom"))
+
+	for range 2 {
+		if _, err := InstrumentedCollect(context.Background(), ok); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := InstrumentedCollect(context.Background(), failing); err == nil {
+		t.Fatalf("expected the collector's error")
+	}
+
+	var duration dto.Metric
+	observer := collectorDurationSeconds.WithLabelValues("metrics-test-ok", operationCollect)
+	if err := observer.(prometheus.Metric).Write(&duration); err != nil {
+		t.Fatalf("failed to read duration histogram: %v", err)
+	}
+	if got := duration.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("expected 2 durations, got %d", got)
+	}
+	if got := testutil.ToFloat64(collectorErrorsTotal.WithLabelValues("metrics-test-ok", operationCollect)); got != 0 {
+		t.Errorf("expected no errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(collectorErrorsTotal.WithLabelValues("metrics-test-failing", operationCollect)); got != 1 {
+		t.Errorf("expected 1 error, got %v", got)
+	}
+	if got := testutil.ToFloat64(collectorDataSize.WithLabelValues("metrics-test-ok")); got != 2 {
+		t.Errorf("expected a data size of 2, got %v", got)
+	}
+}
+
+func TestInstrumentedCollect_Failpoint(t *testing.T) {
+	t.Cleanup(failpoint.Reset)
+	collector := newFakePointCollector("metrics-test-failpoint", &LoadStats{}, nil)
+	if err := failpoint.Set("collector/metrics-test-failpoint=error"); err != nil {
+		t.Fatalf("failed to set failpoint: %v", err)
+	}
+	if _, err := InstrumentedCollect(context.Background(), collector); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("expected an injected failure, got %v", err)
+	}
+	if got := testutil.ToFloat64(collectorErrorsTotal.WithLabelValues("metrics-test-failpoint", operationCollect)); got != 1 {
+		t.Errorf("expected the injected failure to be counted, got %v", got)
+	}
+
+	failpoint.Reset()
+	if _, err := InstrumentedCollect(context.Background(), collector); err != nil {
+		t.Errorf("expected no failure without the failpoint, got %v", err)
+	}
+}
+
+func TestDataSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data any
+		want int
+	}{
+		{"nil", nil, 0},
+		{"slice", []DiskStats{{}, {}, {}}, 3},
+		{"empty slice", []DiskStats{}, 0},
+		{"struct pointer", &LoadStats{}, 1},
+		{"nil pointer", (*LoadStats)(nil), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dataSize(tt.data); got != tt.want {
+				t.Errorf("dataSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
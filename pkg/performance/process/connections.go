@@ -0,0 +1,223 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// Connections is the map of the TCP connections of the host's processes at the time it
+// was read
+type Connections struct {
+	// Processes with listening or established TCP sockets by PID
+	Processes map[int32]*ConnectedProcess
+}
+
+// ConnectedProcess is a process with listening or established TCP sockets
+type ConnectedProcess struct {
+	PID     int32
+	Command string // From /proc/[pid]/comm
+	// Addresses the process listens on, host:port, sorted
+	Listening []string
+	// Local processes the process connected to, sorted. They are in
+	// Connections.Processes too.
+	Peers []int32
+	// Remote endpoints the process connected to, host:port, sorted
+	Remotes []string
+}
+
+// tcpSocket is a socket of a TCP table of a network namespace
+type tcpSocket struct {
+	netns         string
+	inode         uint64
+	local, remote string
+	state         string
+}
+
+// ReadConnections maps the TCP connections of the processes of the host whose /proc is
+// mounted at procPath, joining the socket inodes in /proc/[pid]/fd with the socket tables
+// of the network namespace of each process.
+//
+// A connection is attributed to its client, the process whose socket isn't bound to a
+// listening address. It connects the client to the process owning the other end of the
+// connection if that end is on the host too, possibly in another network namespace, or
+// to the remote endpoint otherwise. Connections accepted from remote clients aren't
+// mapped, their ports being ephemeral, nor connections to loopback addresses whose other
+// end isn't owned by a process that could be read.
+//
+// Processes that can't be read, which is common for processes of other users without
+// root, are left out. A socket shared by several processes, e.g. after a fork, is
+// attributed to the one with the lowest PID.
+func ReadConnections(procPath string) (*Connections, error) {
+	owners, namespaces, err := readSocketOwners(procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []tcpSocket
+	for netns, pid := range namespaces {
+		for _, protocol := range []string{"tcp", "tcp6"} {
+			path := filepath.Join(procPath, strconv.Itoa(int(pid)), "net", protocol)
+			err := readInetSockets(path, func(inode uint64, local, remote, state string) {
+				if state == tcpEstablished || state == tcpListen {
+					sockets = append(sockets, tcpSocket{netns: netns, inode: inode, local: local, remote: remote, state: state})
+				}
+			})
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	conns := &Connections{Processes: make(map[int32]*ConnectedProcess)}
+	process := func(pid int32) *ConnectedProcess {
+		p, ok := conns.Processes[pid]
+		if !ok {
+			p = &ConnectedProcess{PID: pid, Command: readComm(filepath.Join(procPath, strconv.Itoa(int(pid))))}
+			conns.Processes[pid] = p
+		}
+		return p
+	}
+
+	// Listening addresses by network namespace, with the unspecified addresses listening
+	// on every address keyed by port
+	listening := make(map[string]bool)
+	established := make(map[string]tcpSocket)
+	for _, s := range sockets {
+		switch s.state {
+		case tcpListen:
+			host, port, err := net.SplitHostPort(s.local)
+			if err != nil {
+				continue
+			}
+			if net.ParseIP(host).IsUnspecified() {
+				listening[s.netns+" :"+port] = true
+			} else {
+				listening[s.netns+" "+s.local] = true
+			}
+			if pid, ok := owners[s.inode]; ok {
+				p := process(pid)
+				p.Listening = append(p.Listening, s.local)
+			}
+		case tcpEstablished:
+			established[connectionKey(s.netns, s.local, s.remote)] = s
+		}
+	}
+	isListening := func(netns, addr string) bool {
+		_, port, err := net.SplitHostPort(addr)
+		return err == nil && (listening[netns+" "+addr] || listening[netns+" :"+port])
+	}
+
+	for _, s := range established {
+		pid, ok := owners[s.inode]
+		if !ok || isListening(s.netns, s.local) {
+			continue
+		}
+		if peer, ok := established[connectionKey(s.netns, s.remote, s.local)]; ok {
+			if peerPID, ok := owners[peer.inode]; ok {
+				if peerPID != pid {
+					p := process(pid)
+					p.Peers = append(p.Peers, peerPID)
+					process(peerPID)
+				}
+				continue
+			}
+		}
+		if isLoopback(s.remote) {
+			continue
+		}
+		p := process(pid)
+		p.Remotes = append(p.Remotes, s.remote)
+	}
+
+	for _, p := range conns.Processes {
+		slices.Sort(p.Listening)
+		p.Listening = slices.Compact(p.Listening)
+		slices.Sort(p.Peers)
+		p.Peers = slices.Compact(p.Peers)
+		slices.Sort(p.Remotes)
+		p.Remotes = slices.Compact(p.Remotes)
+	}
+	return conns, nil
+}
+
+// readSocketOwners returns the owner of every socket inode of the processes of the host
+// whose /proc is mounted at procPath, the one with the lowest PID for shared sockets, and
+// a process of every network namespace to read its socket tables from, by namespace
+func readSocketOwners(procPath string) (owners map[uint64]int32, namespaces map[string]int32, err error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", procPath, err)
+	}
+
+	owners = make(map[uint64]int32)
+	namespaces = make(map[string]int32)
+	pids := make([]int32, 0, len(entries))
+	for _, entry := range entries {
+		if pid, err := strconv.ParseInt(entry.Name(), 10, 32); err == nil {
+			pids = append(pids, int32(pid))
+		}
+	}
+	slices.Sort(pids)
+	for _, pid := range pids {
+		pidPath := filepath.Join(procPath, strconv.Itoa(int(pid)))
+		netns, err := os.Readlink(filepath.Join(pidPath, "ns", "net"))
+		if err != nil {
+			continue
+		}
+		fds, err := readFileDescriptors(pidPath)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if inode, ok := socketInode(fd.Target); ok {
+				if _, owned := owners[inode]; !owned {
+					owners[inode] = pid
+				}
+			}
+		}
+		if _, ok := namespaces[netns]; !ok {
+			namespaces[netns] = pid
+		}
+	}
+	return owners, namespaces, nil
+}
+
+// connectionKey identifies the connection between the local and remote addresses of a
+// socket. Loopback addresses are only unique within a network namespace.
+func connectionKey(netns, local, remote string) string {
+	if isLoopback(local) {
+		return netns + " " + local + " " + remote
+	}
+	return local + " " + remote
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && net.ParseIP(host).IsLoopback()
+}
+
+// readComm returns the command name of the process, empty if it can't be read
+func readComm(pidPath string) string {
+	data, err := os.ReadFile(filepath.Join(pidPath, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,105 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+	// Network namespace of a web server listening on 0.0.0.0:80 with a connection from
+	// 10.0.0.2:40000, and an unowned loopback connection mirroring the one in appNetTCP
+	webNetTCP = tcpHeader +
+		"   0: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 101 1\n" +
+		"   1: 0100000A:0050 0200000A:9C40 01 00000000:00000000 00:00000000 00000000     0        0 102 1\n" +
+		"   2: 0100007F:18EB 0100007F:1388 01 00000000:00000000 00:00000000 00000000     0        0 103 1\n"
+
+	// Network namespace of an app connected to the web server, to 93.184.216.34:443 and
+	// to a redis listening on 127.0.0.1:6379
+	appNetTCP = tcpHeader +
+		"   0: 0200000A:9C40 0100000A:0050 01 00000000:00000000 00:00000000 00000000     0        0 201 1\n" +
+		"   1: 0200000A:9C41 22D8B85D:01BB 01 00000000:00000000 00:00000000 00000000     0        0 202 1\n" +
+		"   2: 0200000A:9C42 22D8B85D:01BB 06 00000000:00000000 00:00000000 00000000     0        0 0 1\n" +
+		"   3: 0100007F:1388 0100007F:18EB 01 00000000:00000000 00:00000000 00000000     0        0 203 1\n" +
+		"   4: 0100007F:18EB 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 301 1\n" +
+		"   5: 0100007F:18EB 0100007F:1388 01 00000000:00000000 00:00000000 00000000     0        0 302 1\n"
+)
+
+// newTestConnectionsProc creates a /proc with a web server (10) and its fork (11) in one
+// network namespace, an app (20) and a redis (30) in another, and a process whose
+// namespace can't be read (40)
+func newTestConnectionsProc(t *testing.T) string {
+	t.Helper()
+	procPath := t.TempDir()
+	processes := []struct {
+		pid, comm, netns, tcp string
+		sockets               []string
+	}{
+		{"10", "nginx", "net:[1]", webNetTCP, []string{"101", "102"}},
+		{"11", "nginx", "net:[1]", webNetTCP, []string{"101"}},
+		{"20", "app", "net:[2]", appNetTCP, []string{"201", "202", "203"}},
+		{"30", "redis", "net:[2]", appNetTCP, []string{"301", "302"}},
+		{"40", "hidden", "", appNetTCP, []string{"401"}},
+	}
+	for _, p := range processes {
+		pidPath := filepath.Join(procPath, p.pid)
+		for _, dir := range []string{"fd", "ns", "net"} {
+			if err := os.MkdirAll(filepath.Join(pidPath, dir), 0755); err != nil {
+				t.Fatalf("failed to create dir: %v", err)
+			}
+		}
+		for name, content := range map[string]string{"comm": p.comm + "\n", "net/tcp": p.tcp} {
+			if err := os.WriteFile(filepath.Join(pidPath, name), []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+		}
+		if p.netns != "" {
+			if err := os.Symlink(p.netns, filepath.Join(pidPath, "ns", "net")); err != nil {
+				t.Fatalf("failed to create ns link: %v", err)
+			}
+		}
+		for i, inode := range p.sockets {
+			link := filepath.Join(pidPath, "fd", string(rune('3'+i)))
+			if err := os.Symlink("socket:["+inode+"]", link); err != nil {
+				t.Fatalf("failed to create fd link: %v", err)
+			}
+		}
+	}
+	return procPath
+}
+
+func TestReadConnections(t *testing.T) {
+	conns, err := ReadConnections(newTestConnectionsProc(t))
+	if err != nil {
+		t.Fatalf("ReadConnections failed: %v", err)
+	}
+
+	want := map[int32]*ConnectedProcess{
+		// The connection from the app is accepted by the server, which owns the
+		// listening socket shared with its fork
+		10: {PID: 10, Command: "nginx", Listening: []string{"0.0.0.0:80"}},
+		20: {PID: 20, Command: "app", Peers: []int32{10, 30}, Remotes: []string{"93.184.216.34:443"}},
+		30: {PID: 30, Command: "redis", Listening: []string{"127.0.0.1:6379"}},
+	}
+	if !reflect.DeepEqual(conns.Processes, want) {
+		for pid, p := range conns.Processes {
+			t.Logf("got %d: %+v", pid, *p)
+		}
+		t.Fatalf("unexpected processes")
+	}
+}
+
+func TestReadConnections_MissingProc(t *testing.T) {
+	if _, err := ReadConnections("/does/not/exist"); err == nil {
+		t.Fatalf("expected error for a missing path")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ServeHTTP inspects the process selected by the pid query parameter and returns it as
+// JSON. It responds with 404 if the process doesn't exist.
+func (i *Inspector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := r.URL.Query().Get("pid")
+	pid, err := strconv.ParseInt(v, 10, 32)
+	if err != nil || pid <= 0 {
+		http.Error(w, fmt.Sprintf("invalid pid %q: must be a positive integer", v), http.StatusBadRequest)
+		return
+	}
+
+	proc, err := i.Inspect(int32(pid))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(proc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
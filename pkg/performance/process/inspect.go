@@ -0,0 +1,445 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package process inspects a single process on demand: its command line, its open file
+// descriptors, the sockets among them with their addresses, and its memory mappings. It is meant for
+// deep-dive debugging of a node without shelling into it, not for periodic collection.
+//
+// It also maps the TCP connections between the processes of the host, and to remote
+// endpoints, and lists the sockets listening on the host from the same socket tables.
+package process
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
+)
+
+// ErrNotFound is returned when the inspected process doesn't exist
+var ErrNotFound = errors.New("process not found")
+
+// Process is the state of a process at the time it was inspected
+type Process struct {
+	PID     int32
+	Command string // From /proc/[pid]/comm
+	UID     uint32 // Real UID from /proc/[pid]/status
+	// Arguments from /proc/[pid]/cmdline, argv[0] included, as left by the argument
+	// policy of the inspector
+	Cmdline       []string
+	CmdlineAction argpolicy.Action `json:",omitempty"`
+	// Open file descriptors from /proc/[pid]/fd, ordered by number
+	FileDescriptors []FileDescriptor
+	// The socket file descriptors resolved against the socket tables of the process's
+	// network namespace
+	Sockets []Socket
+	// Memory mappings from /proc/[pid]/maps
+	Maps []MemoryMap
+	// Parts that couldn't be read, e.g. due to missing permissions, keyed by the part
+	// (status, cmdline, fd, net, maps)
+	Errors map[string]string `json:",omitempty"`
+}
+
+// FileDescriptor is an open file descriptor
+type FileDescriptor struct {
+	FD     int
+	Target string // Link target, e.g. /var/log/syslog, socket:[12345] or pipe:[678]
+	Type   string // file, socket, pipe, anon_inode or other
+}
+
+// Socket is a socket file descriptor
+type Socket struct {
+	FD            int
+	Inode         uint64
+	Protocol      string // tcp, tcp6, udp, udp6, unix or unknown if not found in the socket tables
+	LocalAddress  string // host:port for inet sockets
+	RemoteAddress string // host:port of the peer for inet sockets
+	State         string // TCP state name, e.g. ESTABLISHED or LISTEN
+	Path          string `json:",omitempty"` // Bound path of unix sockets
+}
+
+// MemoryMap is a memory mapping of the process
+type MemoryMap struct {
+	Start  uint64
+	End    uint64
+	Perms  string // e.g. r-xp
+	Offset uint64
+	Device string // major:minor
+	Inode  uint64
+	Path   string // File path or pseudo path such as [heap], empty for anonymous mappings
+}
+
+// tcpStates maps the hex state in /proc/net/tcp to its name (include/net/tcp_states.h)
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// Inspector inspects processes of the host whose /proc is mounted at procPath
+type Inspector struct {
+	procPath   string
+	argsPolicy *argpolicy.Policy
+}
+
+// Option configures an Inspector
+type Option func(*Inspector)
+
+// WithArgsPolicy sets the policy deciding whether the command lines of inspected processes
+// are captured, truncated, hashed or dropped. Without it they are captured as is.
+func WithArgsPolicy(policy *argpolicy.Policy) Option {
+	return func(i *Inspector) {
+		i.argsPolicy = policy
+	}
+}
+
+// NewInspector returns an Inspector reading from procPath, the path of the host's /proc
+func NewInspector(procPath string, opts ...Option) (*Inspector, error) {
+	if !filepath.IsAbs(procPath) {
+		return nil, fmt.Errorf("HostProcPath must be an absolute path, got: %q", procPath)
+	}
+	if _, err := os.Stat(procPath); err != nil {
+		return nil, fmt.Errorf("HostProcPath validation failed: %w", err)
+	}
+	i := &Inspector{procPath: procPath}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i, nil
+}
+
+// Inspect returns the command line, open file descriptors, sockets and memory maps of
+// pid. It returns an error wrapping ErrNotFound if the process doesn't exist. Other parts
+// that can't be read, which is common for processes of other users without root, are
+// reported in Process.Errors.
+func (i *Inspector) Inspect(pid int32) (*Process, error) {
+	pidPath := filepath.Join(i.procPath, strconv.Itoa(int(pid)))
+	comm, err := os.ReadFile(filepath.Join(pidPath, "comm"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %d", ErrNotFound, pid)
+		}
+		return nil, fmt.Errorf("failed to read comm of %d: %w", pid, err)
+	}
+
+	proc := &Process{
+		PID:     pid,
+		Command: strings.TrimSpace(string(comm)),
+		Errors:  make(map[string]string),
+	}
+
+	if proc.UID, err = readUID(pidPath); err != nil {
+		proc.Errors["status"] = err.Error()
+	}
+	if cmdline, err := readCmdline(pidPath); err != nil {
+		proc.Errors["cmdline"] = err.Error()
+	} else {
+		proc.Cmdline, proc.CmdlineAction = i.argsPolicy.Apply(argpolicy.Process{
+			PID:     pid,
+			UID:     proc.UID,
+			Command: proc.Command,
+		}, cmdline)
+	}
+	if proc.FileDescriptors, err = readFileDescriptors(pidPath); err != nil {
+		proc.Errors["fd"] = err.Error()
+	}
+	if proc.Sockets, err = readSockets(pidPath, proc.FileDescriptors); err != nil {
+		proc.Errors["net"] = err.Error()
+	}
+	if proc.Maps, err = readMaps(pidPath); err != nil {
+		proc.Errors["maps"] = err.Error()
+	}
+	return proc, nil
+}
+
+// readUID returns the real UID of the process from /proc/[pid]/status
+func readUID(pidPath string) (uint32, error) {
+	f, err := os.Open(filepath.Join(pidPath, "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Uid: real effective saved filesystem
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Uid line %q: %w", scanner.Text(), err)
+		}
+		return uint32(uid), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no Uid line in status")
+}
+
+// readCmdline returns the NUL-separated arguments of /proc/[pid]/cmdline. It is empty for
+// kernel threads and zombies.
+func readCmdline(pidPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(pidPath, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	cmdline := strings.TrimRight(string(data), "\x00")
+	if cmdline == "" {
+		return nil, nil
+	}
+	return strings.Split(cmdline, "\x00"), nil
+}
+
+func readFileDescriptors(pidPath string) ([]FileDescriptor, error) {
+	fdPath := filepath.Join(pidPath, "fd")
+	entries, err := os.ReadDir(fdPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fds := make([]FileDescriptor, 0, len(entries))
+	for _, entry := range entries {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(fdPath, entry.Name()))
+		if err != nil {
+			// Closed since the directory was read
+			continue
+		}
+		fds = append(fds, FileDescriptor{FD: fd, Target: target, Type: fdType(target)})
+	}
+	sort.Slice(fds, func(a, b int) bool { return fds[a].FD < fds[b].FD })
+	return fds, nil
+}
+
+func fdType(target string) string {
+	switch {
+	case strings.HasPrefix(target, "/"):
+		return "file"
+	case strings.HasPrefix(target, "socket:["):
+		return "socket"
+	case strings.HasPrefix(target, "pipe:["):
+		return "pipe"
+	case strings.HasPrefix(target, "anon_inode:"):
+		return "anon_inode"
+	default:
+		return "other"
+	}
+}
+
+// socketInode returns the inode of a socket:[inode] link target
+func socketInode(target string) (uint64, bool) {
+	inode, ok := strings.CutPrefix(target, "socket:[")
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSuffix(inode, "]"), 10, 64)
+	return v, err == nil
+}
+
+// readSockets resolves the socket file descriptors against the socket tables in
+// /proc/[pid]/net, which show the network namespace of the process
+func readSockets(pidPath string, fds []FileDescriptor) ([]Socket, error) {
+	sockets := make([]Socket, 0)
+	byInode := make(map[uint64][]int)
+	for _, fd := range fds {
+		inode, ok := socketInode(fd.Target)
+		if !ok {
+			continue
+		}
+		byInode[inode] = append(byInode[inode], len(sockets))
+		sockets = append(sockets, Socket{FD: fd.FD, Inode: inode, Protocol: "unknown"})
+	}
+	if len(sockets) == 0 {
+		return sockets, nil
+	}
+
+	var errs []error
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		err := readInetSockets(filepath.Join(pidPath, "net", protocol), func(inode uint64, local, remote, state string) {
+			for _, idx := range byInode[inode] {
+				sockets[idx].Protocol = protocol
+				sockets[idx].LocalAddress = local
+				sockets[idx].RemoteAddress = remote
+				if strings.HasPrefix(protocol, "tcp") {
+					sockets[idx].State = tcpStates[state]
+				}
+			}
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	err := readUnixSockets(filepath.Join(pidPath, "net", "unix"), func(inode uint64, path string) {
+		for _, idx := range byInode[inode] {
+			sockets[idx].Protocol = "unix"
+			sockets[idx].Path = path
+		}
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	return sockets, errors.Join(errs...)
+}
+
+// readInetSockets parses a /proc/net/{tcp,udp}[6] table.
+//
+// Format: sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+func readInetSockets(path string, fn func(inode uint64, local, remote, state string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// Skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		local, err := parseInetAddress(fields[1])
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		remote, err := parseInetAddress(fields[2])
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		fn(inode, local, remote, fields[3])
+	}
+	return scanner.Err()
+}
+
+// parseInetAddress converts an address of /proc/net/tcp such as 0100007F:1F90 to
+// 127.0.0.1:8080. The IP is hex encoded in 32 bit words of host byte order, the port in
+// big endian.
+func parseInetAddress(s string) (string, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	raw, err := hex.DecodeString(ipHex)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port in address %q", s)
+	}
+
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		binary.BigEndian.PutUint32(ip[w:], binary.NativeEndian.Uint32(raw[w:]))
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
+
+// readUnixSockets parses /proc/net/unix.
+//
+// Format: Num RefCount Protocol Flags Type St Inode [Path]
+func readUnixSockets(path string, fn func(inode uint64, path string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// Skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			continue
+		}
+		var socketPath string
+		if len(fields) > 7 {
+			socketPath = fields[7]
+		}
+		fn(inode, socketPath)
+	}
+	return scanner.Err()
+}
+
+// readMaps parses /proc/[pid]/maps.
+//
+// Format: address perms offset dev inode [pathname]
+func readMaps(pidPath string) ([]MemoryMap, error) {
+	file, err := os.Open(filepath.Join(pidPath, "maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	maps := make([]MemoryMap, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid maps line: %q", line)
+		}
+		m := MemoryMap{Perms: fields[1], Device: fields[3]}
+		if m.Start, err = strconv.ParseUint(start, 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid maps line: %q", line)
+		}
+		if m.End, err = strconv.ParseUint(end, 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid maps line: %q", line)
+		}
+		if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid maps line: %q", line)
+		}
+		if m.Inode, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid maps line: %q", line)
+		}
+		if len(fields) > 5 {
+			// Paths can contain spaces
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		maps = append(maps, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return maps, nil
+}
@@ -0,0 +1,279 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
+)
+
+const (
+	tcpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 0200007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+`
+	tcp6Table = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 100 0 0 10 0
+`
+	udpTable = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1004 2 0000000000000000 0
+`
+	unixTable = `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 1005 /run/containerd/containerd.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 1006
+`
+	mapsFile = `55d0c0a00000-55d0c0a21000 r--p 00000000 08:01 393228                     /usr/bin/app
+55d0c0a21000-55d0c0b00000 r-xp 00021000 08:01 393228                     /usr/bin/app
+55d0c2000000-55d0c2021000 rw-p 00000000 00:00 0                          [heap]
+7f0000000000-7f0000001000 rw-p 00000000 00:00 0
+7f0000100000-7f0000200000 r--p 00000000 08:01 4242                       /data/my file (deleted)
+`
+)
+
+// newTestProc creates a /proc with process 42 and returns its path
+func newTestProc(t *testing.T) string {
+	t.Helper()
+	procPath := t.TempDir()
+	pidPath := filepath.Join(procPath, "42")
+	files := map[string]string{
+		"comm":     "app\n",
+		"cmdline":  "/usr/bin/app\x00--token=s3cret\x00",
+		"status":   "Name:\tapp\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\n",
+		"maps":     mapsFile,
+		"net/tcp":  tcpTable,
+		"net/tcp6": tcp6Table,
+		"net/udp":  udpTable,
+		"net/unix": unixTable,
+		"fd/.keep": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(pidPath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	for fd, target := range map[string]string{
+		"0":  "/dev/null",
+		"1":  "pipe:[900]",
+		"3":  "socket:[1001]",
+		"4":  "socket:[1002]",
+		"5":  "socket:[1003]",
+		"6":  "socket:[1004]",
+		"7":  "socket:[1005]",
+		"8":  "socket:[1006]",
+		"9":  "socket:[9999]",
+		"10": "anon_inode:[eventpoll]",
+	} {
+		if err := os.Symlink(target, filepath.Join(pidPath, "fd", fd)); err != nil {
+			t.Fatalf("failed to create fd link: %v", err)
+		}
+	}
+	return procPath
+}
+
+func TestNewInspector(t *testing.T) {
+	if _, err := NewInspector("relative/proc"); err == nil {
+		t.Fatalf("expected error for a relative path")
+	}
+	if _, err := NewInspector("/does/not/exist"); err == nil {
+		t.Fatalf("expected error for a missing path")
+	}
+}
+
+func TestInspector_Inspect(t *testing.T) {
+	inspector, err := NewInspector(newTestProc(t))
+	if err != nil {
+		t.Fatalf("failed to create inspector: %v", err)
+	}
+
+	proc, err := inspector.Inspect(42)
+	if err != nil {
+		t.Fatalf("Inspect() failed: %v", err)
+	}
+	if proc.Command != "app" {
+		t.Errorf("Command = %q, want app", proc.Command)
+	}
+	if proc.UID != 1000 {
+		t.Errorf("UID = %d, want 1000", proc.UID)
+	}
+	wantCmdline := []string{"/usr/bin/app", "--token=s3cret"}
+	if !reflect.DeepEqual(proc.Cmdline, wantCmdline) || proc.CmdlineAction != argpolicy.ActionCapture {
+		t.Errorf("Cmdline = %q (%s), want %q captured", proc.Cmdline, proc.CmdlineAction, wantCmdline)
+	}
+	if len(proc.Errors) != 0 {
+		t.Errorf("unexpected errors: %v", proc.Errors)
+	}
+
+	var types []string
+	for _, fd := range proc.FileDescriptors {
+		types = append(types, fd.Type)
+	}
+	wantTypes := []string{"file", "pipe", "socket", "socket", "socket", "socket", "socket", "socket", "socket", "anon_inode"}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("fd types = %v, want %v", types, wantTypes)
+	}
+
+	wantSockets := []Socket{
+		{FD: 3, Inode: 1001, Protocol: "tcp", LocalAddress: "127.0.0.1:8080", RemoteAddress: "0.0.0.0:0", State: "LISTEN"},
+		{FD: 4, Inode: 1002, Protocol: "tcp", LocalAddress: "127.0.0.1:8080", RemoteAddress: "127.0.0.2:54321", State: "ESTABLISHED"},
+		{FD: 5, Inode: 1003, Protocol: "tcp6", LocalAddress: "[::1]:80", RemoteAddress: "[::]:0", State: "LISTEN"},
+		{FD: 6, Inode: 1004, Protocol: "udp", LocalAddress: "0.0.0.0:53", RemoteAddress: "0.0.0.0:0"},
+		{FD: 7, Inode: 1005, Protocol: "unix", Path: "/run/containerd/containerd.sock"},
+		{FD: 8, Inode: 1006, Protocol: "unix"},
+		{FD: 9, Inode: 9999, Protocol: "unknown"},
+	}
+	if !reflect.DeepEqual(proc.Sockets, wantSockets) {
+		t.Errorf("Sockets = %+v, want %+v", proc.Sockets, wantSockets)
+	}
+
+	if len(proc.Maps) != 5 {
+		t.Fatalf("got %d maps, want 5", len(proc.Maps))
+	}
+	wantText := MemoryMap{Start: 0x55d0c0a21000, End: 0x55d0c0b00000, Perms: "r-xp", Offset: 0x21000, Device: "08:01", Inode: 393228, Path: "/usr/bin/app"}
+	if proc.Maps[1] != wantText {
+		t.Errorf("Maps[1] = %+v, want %+v", proc.Maps[1], wantText)
+	}
+	if proc.Maps[2].Path != "[heap]" || proc.Maps[3].Path != "" {
+		t.Errorf("unexpected pseudo and anonymous map paths: %q, %q", proc.Maps[2].Path, proc.Maps[3].Path)
+	}
+	if proc.Maps[4].Path != "/data/my file (deleted)" {
+		t.Errorf("Maps[4].Path = %q", proc.Maps[4].Path)
+	}
+}
+
+func TestInspector_InspectArgsPolicy(t *testing.T) {
+	truncate, err := argpolicy.Parse("truncate:8 uid=1000")
+	if err != nil {
+		t.Fatalf("failed to parse rule: %v", err)
+	}
+	drop, err := argpolicy.Parse("drop")
+	if err != nil {
+		t.Fatalf("failed to parse rule: %v", err)
+	}
+	policy := argpolicy.New([]argpolicy.Rule{truncate}, drop)
+	inspector, err := NewInspector(newTestProc(t), WithArgsPolicy(policy))
+	if err != nil {
+		t.Fatalf("failed to create inspector: %v", err)
+	}
+
+	proc, err := inspector.Inspect(42)
+	if err != nil {
+		t.Fatalf("Inspect() failed: %v", err)
+	}
+	wantCmdline := []string{"/usr/bin", "--token="}
+	if !reflect.DeepEqual(proc.Cmdline, wantCmdline) || proc.CmdlineAction != argpolicy.ActionTruncate {
+		t.Errorf("Cmdline = %q (%s), want %q truncated", proc.Cmdline, proc.CmdlineAction, wantCmdline)
+	}
+}
+
+func TestInspector_InspectPartial(t *testing.T) {
+	procPath := newTestProc(t)
+	if err := os.Remove(filepath.Join(procPath, "42", "maps")); err != nil {
+		t.Fatalf("failed to remove maps: %v", err)
+	}
+	inspector, err := NewInspector(procPath)
+	if err != nil {
+		t.Fatalf("failed to create inspector: %v", err)
+	}
+
+	proc, err := inspector.Inspect(42)
+	if err != nil {
+		t.Fatalf("Inspect() failed: %v", err)
+	}
+	if _, ok := proc.Errors["maps"]; !ok {
+		t.Errorf("expected a maps error, got %v", proc.Errors)
+	}
+	if len(proc.Sockets) != 7 {
+		t.Errorf("got %d sockets, want 7", len(proc.Sockets))
+	}
+
+	if _, err := inspector.Inspect(43); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Inspect() of a missing process = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInspector_ServeHTTP(t *testing.T) {
+	inspector, err := NewInspector(newTestProc(t))
+	if err != nil {
+		t.Fatalf("failed to create inspector: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		wantStatus int
+	}{
+		{name: "process", method: http.MethodGet, query: "?pid=42", wantStatus: http.StatusOK},
+		{name: "missing process", method: http.MethodGet, query: "?pid=43", wantStatus: http.StatusNotFound},
+		{name: "no pid", method: http.MethodGet, query: "", wantStatus: http.StatusBadRequest},
+		{name: "invalid pid", method: http.MethodGet, query: "?pid=-1", wantStatus: http.StatusBadRequest},
+		{name: "method", method: http.MethodPost, query: "?pid=42", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			inspector.ServeHTTP(rec, httptest.NewRequest(tt.method, "/"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var proc Process
+			if err := json.Unmarshal(rec.Body.Bytes(), &proc); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if proc.PID != 42 || len(proc.Sockets) != 7 {
+				t.Errorf("unexpected response: %+v", proc)
+			}
+		})
+	}
+}
+
+func TestParseInetAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0100007F:1F90", want: "127.0.0.1:8080"},
+		{in: "00000000000000000000000001000000:0050", want: "[::1]:80"},
+		{in: "0000000000000000FFFF00000100007F:0016", want: "127.0.0.1:22"},
+		{in: "0100007F", wantErr: true},
+		{in: "0100:1F90", wantErr: true},
+		{in: "0100007F:XYZ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseInetAddress(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("parseInetAddress(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package process
+
+import (
+	"cmp"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// udpClose is the state of unconnected UDP sockets, which receive from any peer
+const udpClose = "07"
+
+// Listener is a socket accepting TCP connections or UDP datagrams from any peer
+type Listener struct {
+	Protocol string // tcp or udp
+	// Address the socket is bound to, the unspecified address 0.0.0.0 or :: for all
+	// addresses of the network namespace
+	Address string
+	Port    uint16
+	// Process owning the socket
+	PID     int32
+	Command string // From /proc/[pid]/comm
+	// HostNetwork is set if the socket is in the network namespace of the host's init
+	// process, rather than e.g. that of a container, so it is exposed on the host's
+	// addresses
+	HostNetwork bool
+}
+
+// ReadListeners lists the listening TCP sockets and unconnected UDP sockets of the
+// processes of the host whose /proc is mounted at procPath, joining the socket inodes in
+// /proc/[pid]/fd with the socket tables of the network namespace of each process. They
+// are sorted by protocol, address, port and PID.
+//
+// Processes that can't be read, which is common for processes of other users without
+// root, are left out. A socket shared by several processes, e.g. the listening socket of
+// a pre-forking server, is attributed to the one with the lowest PID.
+func ReadListeners(procPath string) ([]Listener, error) {
+	owners, namespaces, err := readSocketOwners(procPath)
+	if err != nil {
+		return nil, err
+	}
+	hostNetns, _ := os.Readlink(filepath.Join(procPath, "1", "ns", "net"))
+
+	var listeners []Listener
+	comms := make(map[int32]string)
+	for netns, nsPID := range namespaces {
+		for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+			path := filepath.Join(procPath, strconv.Itoa(int(nsPID)), "net", protocol)
+			isTCP := strings.HasPrefix(protocol, "tcp")
+			err := readInetSockets(path, func(inode uint64, local, remote, state string) {
+				if (isTCP && state != tcpListen) || (!isTCP && (state != udpClose || !isUnspecified(remote))) {
+					return
+				}
+				pid, ok := owners[inode]
+				if !ok {
+					return
+				}
+				host, portStr, err := net.SplitHostPort(local)
+				if err != nil {
+					return
+				}
+				port, _ := strconv.ParseUint(portStr, 10, 16)
+				comm, ok := comms[pid]
+				if !ok {
+					comm = readComm(filepath.Join(procPath, strconv.Itoa(int(pid))))
+					comms[pid] = comm
+				}
+				listeners = append(listeners, Listener{
+					Protocol:    strings.TrimSuffix(protocol, "6"),
+					Address:     host,
+					Port:        uint16(port),
+					PID:         pid,
+					Command:     comm,
+					HostNetwork: hostNetns != "" && netns == hostNetns,
+				})
+			})
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	slices.SortFunc(listeners, func(a, b Listener) int {
+		return cmp.Or(
+			cmp.Compare(a.Protocol, b.Protocol),
+			cmp.Compare(a.Address, b.Address),
+			cmp.Compare(a.Port, b.Port),
+			cmp.Compare(a.PID, b.PID),
+		)
+	})
+	return listeners, nil
+}
+
+// isUnspecified reports whether addr is a host:port of the unspecified address and port 0,
+// the remote address of an unconnected socket
+func isUnspecified(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	return err == nil && port == "0" && net.ParseIP(host).IsUnspecified()
+}
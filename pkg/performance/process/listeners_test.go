@@ -0,0 +1,68 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Unconnected UDP socket on 0.0.0.0:53 and a UDP socket connected to 10.0.0.53:53
+const appNetUDP = tcpHeader +
+	"   0: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 303 2\n" +
+	"   1: 0200000A:9C43 3500000A:0035 01 00000000:00000000 00:00000000 00000000     0        0 304 2\n"
+
+func TestReadListeners(t *testing.T) {
+	procPath := newTestConnectionsProc(t)
+	// init shares the network namespace of the web server, the host's
+	init := filepath.Join(procPath, "1")
+	for _, dir := range []string{"fd", "ns", "net"} {
+		if err := os.MkdirAll(filepath.Join(init, dir), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	if err := os.Symlink("net:[1]", filepath.Join(init, "ns", "net")); err != nil {
+		t.Fatalf("failed to create ns link: %v", err)
+	}
+	files := map[string]string{
+		"1/comm":     "systemd\n",
+		"1/net/tcp":  webNetTCP,
+		"20/net/udp": appNetUDP,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(procPath, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	for fd, inode := range map[string]string{"8": "303", "9": "304"} {
+		if err := os.Symlink("socket:["+inode+"]", filepath.Join(procPath, "30", "fd", fd)); err != nil {
+			t.Fatalf("failed to create fd link: %v", err)
+		}
+	}
+
+	listeners, err := ReadListeners(procPath)
+	if err != nil {
+		t.Fatalf("ReadListeners failed: %v", err)
+	}
+	want := []Listener{
+		// The listening socket shared by nginx and its fork is attributed to the parent
+		{Protocol: "tcp", Address: "0.0.0.0", Port: 80, PID: 10, Command: "nginx", HostNetwork: true},
+		{Protocol: "tcp", Address: "127.0.0.1", Port: 6379, PID: 30, Command: "redis"},
+		{Protocol: "udp", Address: "0.0.0.0", Port: 53, PID: 30, Command: "redis"},
+	}
+	if !reflect.DeepEqual(listeners, want) {
+		t.Fatalf("expected %+v, got %+v", want, listeners)
+	}
+}
+
+func TestReadListeners_MissingProc(t *testing.T) {
+	if _, err := ReadListeners("/does/not/exist"); err == nil {
+		t.Fatalf("expected error for a missing path")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package procparse parses the common formats of procfs, sysfs and cgroupfs files so that
+// collectors degrade the same way on malformed content:
+//
+//   - single values, e.g. /sys/class/net/eth0/mtu or memory.current
+//   - "key value [unit]" lines, e.g. /proc/meminfo, /proc/vmstat or cpu.stat
+//   - whitespace-separated tables, e.g. /proc/net/arp, /proc/net/route or /proc/swaps
+//
+// Lines that can't be parsed are skipped rather than failing the whole file, since the
+// kernel adds fields and lines over time.
+package procparse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseUint parses a decimal unsigned integer, ignoring surrounding whitespace such as the
+// trailing newline of sysfs attributes
+func ParseUint(s string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+}
+
+// ReadUintFile reads a file holding a single decimal unsigned integer
+func ReadUintFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return ParseUint(string(data))
+}
+
+// ReadStringFile returns the trimmed content of a file holding a single value, "" if it
+// can't be read
+func ReadStringFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// ParseKV parses "key value [unit]" lines. Trailing ':'s are removed from keys, so that
+// /proc/meminfo's "MemTotal: 16310000 kB" is MemTotal. Values aren't converted to their
+// unit. Lines whose value isn't a decimal unsigned integer are skipped, and a key repeated
+// on a later line overrides the earlier one.
+func ParseKV(r io.Reader) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		key := strings.TrimRight(fields[0], ":")
+		if key == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[key] = v
+	}
+	return values, scanner.Err()
+}
+
+// ParseKVFile parses the "key value [unit]" lines of the file at path with ParseKV
+func ParseKVFile(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseKV(file)
+}
+
+// ParseTable parses a table of whitespace-separated fields, skipping the first
+// headerLines lines. It returns the fields of every non-empty row; rows are left for the
+// caller to validate, as their number of fields varies with the kernel version.
+func ParseTable(r io.Reader, headerLines int) ([][]string, error) {
+	var rows [][]string
+	scanner := bufio.NewScanner(r)
+	for n := 0; scanner.Scan(); n++ {
+		if n < headerLines {
+			continue
+		}
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			rows = append(rows, fields)
+		}
+	}
+	return rows, scanner.Err()
+}
+
+// ReadTable parses the table in the file at path with ParseTable
+func ReadTable(path string, headerLines int) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseTable(file, headerLines)
+}
+
+// ParseCPUList parses a kernel CPU list, e.g. "0-3,8,10-11" in
+// /sys/devices/system/cpu/online or cpuset.cpus
+func ParseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(list, ",") {
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid CPU range %q", part)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no CPUs in %q", list)
+	}
+	return cpus, nil
+}
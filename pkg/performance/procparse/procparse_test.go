@@ -0,0 +1,158 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package procparse
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadUintFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"mtu": "1500\n", "max": "max\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := ReadUintFile(filepath.Join(dir, "mtu")); err != nil || v != 1500 {
+		t.Errorf("ReadUintFile(mtu) = %d, %v, want 1500", v, err)
+	}
+	if _, err := ReadUintFile(filepath.Join(dir, "max")); err == nil {
+		t.Error("expected an error for a non-numeric value")
+	}
+	if _, err := ReadUintFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if got := ReadStringFile(filepath.Join(dir, "max")); got != "max" {
+		t.Errorf("ReadStringFile(max) = %q, want max", got)
+	}
+}
+
+func TestParseKV(t *testing.T) {
+	content := "MemTotal:       16310000 kB\n" +
+		"MemFree:         1000 kB\n" +
+		"nr_free_pages 250\n" +
+		"HugePages_Total:       0\n" +
+		"malformed\n" +
+		"negative -1\n" +
+		": 5\n" +
+		"nr_free_pages 300\n"
+	got, err := ParseKV(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseKV() failed: %v", err)
+	}
+	want := map[string]uint64{
+		"MemTotal":        16310000,
+		"MemFree":         1000,
+		"nr_free_pages":   300,
+		"HugePages_Total": 0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseKV() = %v, want %v", got, want)
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	content := "IP address       HW type     Flags       HW address            Mask     Device\n" +
+		"10.0.0.1         0x1         0x2         52:54:00:12:34:56     *        eth0\n" +
+		"\n" +
+		"10.0.0.7         0x1         0x0         00:00:00:00:00:00     *        eth0\n"
+	got, err := ParseTable(strings.NewReader(content), 1)
+	if err != nil {
+		t.Fatalf("ParseTable() failed: %v", err)
+	}
+	want := [][]string{
+		{"10.0.0.1", "0x1", "0x2", "52:54:00:12:34:56", "*", "eth0"},
+		{"10.0.0.7", "0x1", "0x0", "00:00:00:00:00:00", "*", "eth0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseTable() = %v, want %v", got, want)
+	}
+}
+
+func FuzzParseUint(f *testing.F) {
+	for _, seed := range []string{"0", "1500\n", " 42 ", "max", "-1", "18446744073709551616", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := ParseUint(s)
+		if err != nil {
+			return
+		}
+		// A value that parses is the decimal representation of the result
+		if strings.TrimLeft(strings.TrimSpace(s), "+0") != strings.TrimLeft(strconv.FormatUint(v, 10), "0") {
+			t.Errorf("ParseUint(%q) = %d", s, v)
+		}
+	})
+}
+
+func FuzzParseKV(f *testing.F) {
+	for _, seed := range []string{
+		"MemTotal:       16310000 kB\nMemFree: 1000 kB\n",
+		"nr_free_pages 250\npgfault 1\n",
+		"usage_usec 100\nuser_usec 50\nsystem_usec 50\n",
+		"malformed\n: 5\nkey -1\n",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		values, err := ParseKV(strings.NewReader(content))
+		if err != nil {
+			return
+		}
+		for key := range values {
+			if key == "" || strings.HasSuffix(key, ":") || strings.ContainsAny(key, " \t\n") {
+				t.Errorf("ParseKV(%q) returned invalid key %q", content, key)
+			}
+		}
+	})
+}
+
+func FuzzParseTable(f *testing.F) {
+	for _, seed := range []string{
+		"Filename Type Size Used Priority\n/dev/sda2 partition 8388604 0 -2\n",
+		"Iface Destination Gateway Flags\neth0 00000000 0100000A 0003\n\n",
+		"",
+	} {
+		f.Add(seed, 1)
+	}
+	f.Fuzz(func(t *testing.T, content string, headerLines int) {
+		rows, err := ParseTable(strings.NewReader(content), headerLines)
+		if err != nil {
+			return
+		}
+		for _, row := range rows {
+			if len(row) == 0 {
+				t.Errorf("ParseTable(%q) returned an empty row", content)
+			}
+			for _, field := range row {
+				if field == "" || strings.ContainsAny(field, " \t\n") {
+					t.Errorf("ParseTable(%q) returned invalid field %q", content, field)
+				}
+			}
+		}
+	})
+}
+
+func TestParseCPUList(t *testing.T) {
+	got, err := ParseCPUList("0-3,8,10-11")
+	if err != nil {
+		t.Fatalf("ParseCPUList() failed: %v", err)
+	}
+	if want := []int{0, 1, 2, 3, 8, 10, 11}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCPUList() = %v, want %v", got, want)
+	}
+	for _, list := range []string{"", "a", "3-1", "0-b"} {
+		if _, err := ParseCPUList(list); err == nil {
+			t.Errorf("ParseCPUList(%q) succeeded, want an error", list)
+		}
+	}
+}
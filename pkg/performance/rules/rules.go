@@ -0,0 +1,437 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package rules evaluates threshold rules against performance snapshots on the node, so
+// that basic alerting works even when the upstream analytics pipeline is unavailable.
+//
+// A rule is written as
+//
+//	[<name>:] <metric> <op> <threshold>[%] [for <duration>]
+//
+// e.g. "low-memory: mem available < 5% for 2m" or "disk util > 95% for 5m". The rule fires
+// once its condition held for the duration and resolves as soon as it no longer holds.
+// Metrics of several instances, such as the utilization of each disk, are evaluated per
+// instance.
+package rules
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Comparison operators
+const (
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+)
+
+// State is the state of an alert
+type State string
+
+const (
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// sample is the value of a metric for one instance, e.g. a disk
+type sample struct {
+	instance string
+	value    float64
+}
+
+// metric computes the samples of a metric from the metrics of a snapshot. ok is false
+// when the snapshot has no data for the metric, e.g. because its collector failed.
+type metric struct {
+	percent bool
+	samples func(m *performance.Metrics) (samples []sample, ok bool)
+}
+
+var metrics = map[string]metric{
+	"mem available": {percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.Memory == nil || m.Memory.MemTotal == 0 {
+			return nil, false
+		}
+		return []sample{{value: percent(m.Memory.MemAvailable, m.Memory.MemTotal)}}, true
+	}},
+	"mem used": {percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.Memory == nil || m.Memory.MemTotal == 0 {
+			return nil, false
+		}
+		return []sample{{value: 100 - percent(m.Memory.MemAvailable, m.Memory.MemTotal)}}, true
+	}},
+	"swap used": {percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.Memory == nil {
+			return nil, false
+		}
+		if m.Memory.SwapTotal == 0 {
+			return nil, true
+		}
+		used := m.Memory.SwapTotal - min(m.Memory.SwapFree, m.Memory.SwapTotal)
+		return []sample{{value: percent(used, m.Memory.SwapTotal)}}, true
+	}},
+	"disk util": {percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.Disks == nil {
+			return nil, false
+		}
+		samples := make([]sample, 0, len(m.Disks))
+		for _, disk := range m.Disks {
+			samples = append(samples, sample{instance: disk.Device, value: disk.Utilization})
+		}
+		return samples, true
+	}},
+	"cpu steal": {percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		for _, cpu := range m.CPU {
+			if cpu.CPUIndex == -1 {
+				return []sample{{value: cpu.StealPercent}}, true
+			}
+		}
+		return nil, false
+	}},
+	"dirty limit": {percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.MemoryPressure == nil {
+			return nil, false
+		}
+		return []sample{{value: m.MemoryPressure.DirtyPercent}}, true
+	}},
+	"kswapd scan":  memoryPressureMetric(func(p *performance.MemoryPressureStats) float64 { return p.KswapdScanRate }),
+	"alloc stalls": memoryPressureMetric(func(p *performance.MemoryPressureStats) float64 { return p.AllocStallRate }),
+	"memory pressure": pressureMetric(func(p *performance.MemoryPressureStats) *performance.PressureStats {
+		return p.Memory
+	}),
+	"io pressure": pressureMetric(func(p *performance.MemoryPressureStats) *performance.PressureStats {
+		return p.IO
+	}),
+	"load1":  loadMetric(func(l *performance.LoadStats) float64 { return l.Load1Min }),
+	"load5":  loadMetric(func(l *performance.LoadStats) float64 { return l.Load5Min }),
+	"load15": loadMetric(func(l *performance.LoadStats) float64 { return l.Load15Min }),
+}
+
+func loadMetric(value func(*performance.LoadStats) float64) metric {
+	return metric{samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.Load == nil {
+			return nil, false
+		}
+		return []sample{{value: value(m.Load)}}, true
+	}}
+}
+
+// memoryPressureMetric is a rate of the memory pressure collector. Rates need a previous
+// collection, so the first one has none.
+func memoryPressureMetric(value func(*performance.MemoryPressureStats) float64) metric {
+	return metric{samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.MemoryPressure == nil || m.MemoryPressure.Interval == 0 {
+			return nil, false
+		}
+		return []sample{{value: value(m.MemoryPressure)}}, true
+	}}
+}
+
+// pressureMetric is the share of the last 10s all non-idle tasks stalled on a resource,
+// from the pressure stall information. Kernels without it have no samples.
+func pressureMetric(pressure func(*performance.MemoryPressureStats) *performance.PressureStats) metric {
+	return metric{percent: true, samples: func(m *performance.Metrics) ([]sample, bool) {
+		if m.MemoryPressure == nil {
+			return nil, false
+		}
+		p := pressure(m.MemoryPressure)
+		if p == nil {
+			return nil, true
+		}
+		return []sample{{value: p.Full.Avg10}}, true
+	}}
+}
+
+func percent(value, total uint64) float64 {
+	return 100 * float64(value) / float64(total)
+}
+
+// Metrics returns the names of the metrics rules can be written against
+func Metrics() []string {
+	return slices.Sorted(maps.Keys(metrics))
+}
+
+// Rule is a parsed alert rule
+type Rule struct {
+	// Name of the rule, the expression itself if it wasn't named
+	Name      string
+	Metric    string
+	Op        string
+	Threshold float64
+	// For is how long the condition must hold before the rule fires
+	For time.Duration
+}
+
+// NoisyNeighborRule is the name of the rule returned by NoisyNeighbor
+const NoisyNeighborRule = "noisy-neighbor"
+
+// NoisyNeighbor returns a rule firing when the hypervisor steals more than threshold
+// percent of the node's CPU time for d. Sustained steal means other tenants of the host
+// compete for its CPUs, slowing the node down while its own utilization looks normal.
+func NoisyNeighbor(threshold float64, d time.Duration) Rule {
+	return Rule{
+		Name:      NoisyNeighborRule,
+		Metric:    "cpu steal",
+		Op:        OpGreater,
+		Threshold: threshold,
+		For:       d,
+	}
+}
+
+// Names of the rules returned by DirtyPressure, ReclaimStall and IOStall
+const (
+	DirtyPressureRule = "dirty-pressure"
+	ReclaimStallRule  = "reclaim-stall"
+	IOStallRule       = "io-stall"
+)
+
+// DirtyPressure returns a rule firing when the dirty and writeback page cache stays above
+// threshold percent of the dirty threshold for d. Processes writing to the page cache are
+// throttled as it approaches the threshold, stalling on the disks behind it.
+func DirtyPressure(threshold float64, d time.Duration) Rule {
+	return Rule{
+		Name:      DirtyPressureRule,
+		Metric:    "dirty limit",
+		Op:        OpGreater,
+		Threshold: threshold,
+		For:       d,
+	}
+}
+
+// ReclaimStall returns a rule firing when more than threshold allocations per second stall
+// in direct reclaim for d. kswapd then can't free pages as fast as they are allocated, and
+// the allocating processes spend their time reclaiming pages instead.
+func ReclaimStall(threshold float64, d time.Duration) Rule {
+	return Rule{
+		Name:      ReclaimStallRule,
+		Metric:    "alloc stalls",
+		Op:        OpGreater,
+		Threshold: threshold,
+		For:       d,
+	}
+}
+
+// IOStall returns a rule firing when all non-idle tasks of the node stall on IO more than
+// threshold percent of the time for d, as reported by the pressure stall information.
+func IOStall(threshold float64, d time.Duration) Rule {
+	return Rule{
+		Name:      IOStallRule,
+		Metric:    "io pressure",
+		Op:        OpGreater,
+		Threshold: threshold,
+		For:       d,
+	}
+}
+
+// Parse parses a rule
+func Parse(rule string) (Rule, error) {
+	var r Rule
+	expr := rule
+	if name, rest, ok := strings.Cut(rule, ":"); ok {
+		r.Name = strings.TrimSpace(name)
+		expr = rest
+		if r.Name == "" {
+			return Rule{}, fmt.Errorf("rule %q: empty name", rule)
+		}
+	}
+
+	fields := strings.Fields(expr)
+	if n := len(fields); n >= 2 && fields[n-2] == "for" {
+		d, err := time.ParseDuration(fields[n-1])
+		if err != nil || d < 0 {
+			return Rule{}, fmt.Errorf("rule %q: invalid duration %q", rule, fields[n-1])
+		}
+		r.For = d
+		fields = fields[:n-2]
+	}
+	opIdx := slices.IndexFunc(fields, func(f string) bool {
+		switch f {
+		case OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+			return true
+		}
+		return false
+	})
+	if opIdx < 1 || opIdx != len(fields)-2 {
+		return Rule{}, fmt.Errorf("rule %q: expected <metric> <op> <threshold> [for <duration>]", rule)
+	}
+
+	r.Metric = strings.Join(fields[:opIdx], " ")
+	m, ok := metrics[r.Metric]
+	if !ok {
+		return Rule{}, fmt.Errorf("rule %q: unknown metric %q, available metrics: %s",
+			rule, r.Metric, strings.Join(Metrics(), ", "))
+	}
+	r.Op = fields[opIdx]
+	threshold, isPercent := strings.CutSuffix(fields[opIdx+1], "%")
+	if isPercent && !m.percent {
+		return Rule{}, fmt.Errorf("rule %q: %s is not a percentage", rule, r.Metric)
+	}
+	var err error
+	if r.Threshold, err = strconv.ParseFloat(threshold, 64); err != nil {
+		return Rule{}, fmt.Errorf("rule %q: invalid threshold %q", rule, fields[opIdx+1])
+	}
+	if r.Name == "" {
+		r.Name = strings.Join(strings.Fields(expr), " ")
+	}
+	return r, nil
+}
+
+func (r Rule) holds(value float64) bool {
+	switch r.Op {
+	case OpLess:
+		return value < r.Threshold
+	case OpLessEqual:
+		return value <= r.Threshold
+	case OpGreater:
+		return value > r.Threshold
+	case OpGreaterEqual:
+		return value >= r.Threshold
+	}
+	return false
+}
+
+// Alert is a rule firing, or resolving, for an instance of its metric
+type Alert struct {
+	Rule Rule
+	// Instance of the metric, e.g. the disk device. Empty for metrics of the whole node.
+	Instance string
+	State    State
+	// Value of the metric at the last evaluation
+	Value float64
+	// Since is when the condition started to hold
+	Since time.Time
+	// Time of the evaluation that changed the state of the alert
+	Time time.Time
+}
+
+type alertKey struct {
+	rule     int
+	instance string
+}
+
+// pending is a condition that holds, and may be firing
+type pending struct {
+	since time.Time
+	value float64
+	// firing is whether the rule fired, at firedAt
+	firing  bool
+	firedAt time.Time
+}
+
+// Engine evaluates rules against the snapshots of a performance.Manager
+type Engine struct {
+	rules []Rule
+
+	mu      sync.Mutex
+	pending map[alertKey]*pending
+}
+
+// NewEngine returns an engine evaluating rules
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{
+		rules:   rules,
+		pending: make(map[alertKey]*pending),
+	}
+}
+
+// Rules returns the rules of the engine
+func (e *Engine) Rules() []Rule {
+	return e.rules
+}
+
+// Evaluate evaluates the rules against snapshot and returns the alerts that started
+// firing or resolved. Rules whose metric is missing from snapshot, e.g. because its
+// collector failed, keep their state until the metric is collected again.
+func (e *Engine) Evaluate(snapshot *performance.Snapshot) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := snapshot.Timestamp
+	var alerts []Alert
+	for i, rule := range e.rules {
+		samples, ok := metrics[rule.Metric].samples(&snapshot.Metrics)
+		if !ok {
+			continue
+		}
+		seen := make(map[string]bool, len(samples))
+		for _, s := range samples {
+			seen[s.instance] = true
+			key := alertKey{rule: i, instance: s.instance}
+			p := e.pending[key]
+			if !rule.holds(s.value) {
+				if p != nil {
+					delete(e.pending, key)
+					if p.firing {
+						alerts = append(alerts, newAlert(rule, s.instance, StateResolved, s.value, p.since, now))
+					}
+				}
+				continue
+			}
+			if p == nil {
+				p = &pending{since: now}
+				e.pending[key] = p
+			}
+			p.value = s.value
+			if !p.firing && now.Sub(p.since) >= rule.For {
+				p.firing, p.firedAt = true, now
+				alerts = append(alerts, newAlert(rule, s.instance, StateFiring, s.value, p.since, now))
+			}
+		}
+		// Instances that are gone, e.g. a detached disk, no longer hold
+		for key, p := range e.pending {
+			if key.rule != i || seen[key.instance] {
+				continue
+			}
+			delete(e.pending, key)
+			if p.firing {
+				alerts = append(alerts, newAlert(rule, key.instance, StateResolved, p.value, p.since, now))
+			}
+		}
+	}
+	sortAlerts(alerts)
+	return alerts
+}
+
+// Firing returns the alerts that are firing
+func (e *Engine) Firing() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var alerts []Alert
+	for key, p := range e.pending {
+		if p.firing {
+			alerts = append(alerts, newAlert(e.rules[key.rule], key.instance, StateFiring, p.value, p.since, p.firedAt))
+		}
+	}
+	sortAlerts(alerts)
+	return alerts
+}
+
+func newAlert(rule Rule, instance string, state State, value float64, since, now time.Time) Alert {
+	return Alert{
+		Rule:     rule,
+		Instance: instance,
+		State:    state,
+		Value:    value,
+		Since:    since,
+		Time:     now,
+	}
+}
+
+func sortAlerts(alerts []Alert) {
+	slices.SortFunc(alerts, func(a, b Alert) int {
+		return cmp.Or(cmp.Compare(a.Rule.Name, b.Rule.Name), cmp.Compare(a.Instance, b.Instance))
+	})
+}
@@ -0,0 +1,246 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package rules_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/rules"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    rules.Rule
+		wantErr bool
+	}{
+		{
+			rule: "mem available < 5% for 2m",
+			want: rules.Rule{Name: "mem available < 5% for 2m", Metric: "mem available", Op: "<", Threshold: 5, For: 2 * time.Minute},
+		},
+		{
+			rule: "full-disk: disk util >= 95 for 5m",
+			want: rules.Rule{Name: "full-disk", Metric: "disk util", Op: ">=", Threshold: 95, For: 5 * time.Minute},
+		},
+		{
+			rule: "load1 > 32.5",
+			want: rules.Rule{Name: "load1 > 32.5", Metric: "load1", Op: ">", Threshold: 32.5},
+		},
+		{rule: "load1 > 50%", wantErr: true},
+		{
+			rule: "cpu steal > 10% for 5m",
+			want: rules.Rule{Name: "cpu steal > 10% for 5m", Metric: "cpu steal", Op: ">", Threshold: 10, For: 5 * time.Minute},
+		},
+		{
+			rule: "io pressure > 20% for 1m",
+			want: rules.Rule{Name: "io pressure > 20% for 1m", Metric: "io pressure", Op: ">", Threshold: 20, For: time.Minute},
+		},
+		{rule: "alloc stalls > 10%", wantErr: true},
+		{rule: "gpu util > 5%", wantErr: true},
+		{rule: "mem available = 5%", wantErr: true},
+		{rule: "mem available < 5% for ever", wantErr: true},
+		{rule: "mem available < five", wantErr: true},
+		{rule: "< 5%", wantErr: true},
+		{rule: ": mem available < 5%", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := rules.Parse(tt.rule)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse rule: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func memory(availablePct uint64) *performance.Snapshot {
+	return &performance.Snapshot{Metrics: performance.Metrics{
+		Memory: &performance.MemoryStats{MemTotal: 100, MemAvailable: availablePct},
+	}}
+}
+
+func disks(utilization map[string]float64) *performance.Snapshot {
+	snapshot := &performance.Snapshot{Metrics: performance.Metrics{Disks: []performance.DiskStats{}}}
+	for device, util := range utilization {
+		snapshot.Metrics.Disks = append(snapshot.Metrics.Disks, performance.DiskStats{Device: device, Utilization: util})
+	}
+	return snapshot
+}
+
+func mustParse(t *testing.T, rule string) rules.Rule {
+	t.Helper()
+	r, err := rules.Parse(rule)
+	if err != nil {
+		t.Fatalf("failed to parse rule: %v", err)
+	}
+	return r
+}
+
+func TestEngine_Duration(t *testing.T) {
+	engine := rules.NewEngine([]rules.Rule{mustParse(t, "mem available < 5% for 2m")})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		offset    time.Duration
+		available uint64
+		want      rules.State
+	}{
+		{0, 10, ""},
+		{time.Minute, 4, ""},
+		{2 * time.Minute, 3, ""},
+		// Held for 2m since the first low reading
+		{3 * time.Minute, 2, rules.StateFiring},
+		{4 * time.Minute, 1, ""},
+		{5 * time.Minute, 20, rules.StateResolved},
+		{6 * time.Minute, 20, ""},
+	}
+	for _, step := range steps {
+		snapshot := memory(step.available)
+		snapshot.Timestamp = start.Add(step.offset)
+		alerts := engine.Evaluate(snapshot)
+		if step.want == "" {
+			if len(alerts) != 0 {
+				t.Fatalf("at %s: expected no alerts, got %+v", step.offset, alerts)
+			}
+			continue
+		}
+		if len(alerts) != 1 || alerts[0].State != step.want {
+			t.Fatalf("at %s: expected a %s alert, got %+v", step.offset, step.want, alerts)
+		}
+		if got := alerts[0].Since; !got.Equal(start.Add(time.Minute)) {
+			t.Errorf("at %s: expected the alert to hold since %s, got %s", step.offset, start.Add(time.Minute), got)
+		}
+		if step.want == rules.StateFiring && alerts[0].Value != 2 {
+			t.Errorf("at %s: expected value 2, got %v", step.offset, alerts[0].Value)
+		}
+	}
+}
+
+func TestEngine_Instances(t *testing.T) {
+	engine := rules.NewEngine([]rules.Rule{mustParse(t, "disk util > 95%")})
+	now := time.Now()
+	evaluate := func(snapshot *performance.Snapshot) []rules.Alert {
+		now = now.Add(time.Minute)
+		snapshot.Timestamp = now
+		return engine.Evaluate(snapshot)
+	}
+
+	alerts := evaluate(disks(map[string]float64{"sda": 99, "sdb": 10, "sdc": 97}))
+	if len(alerts) != 2 || alerts[0].Instance != "sda" || alerts[1].Instance != "sdc" {
+		t.Fatalf("expected sda and sdc to fire, got %+v", alerts)
+	}
+	if firing := engine.Firing(); len(firing) != 2 {
+		t.Fatalf("expected 2 firing alerts, got %+v", firing)
+	}
+
+	// A failed collection keeps the alerts firing
+	if alerts := evaluate(&performance.Snapshot{}); len(alerts) != 0 {
+		t.Fatalf("expected no alerts without disk stats, got %+v", alerts)
+	}
+
+	// sdc was detached
+	alerts = evaluate(disks(map[string]float64{"sda": 99, "sdb": 10}))
+	if len(alerts) != 1 || alerts[0].Instance != "sdc" || alerts[0].State != rules.StateResolved {
+		t.Fatalf("expected sdc to resolve, got %+v", alerts)
+	}
+	if firing := engine.Firing(); len(firing) != 1 || firing[0].Instance != "sda" {
+		t.Fatalf("expected sda to be firing, got %+v", firing)
+	}
+}
+
+func TestNoisyNeighbor(t *testing.T) {
+	engine := rules.NewEngine([]rules.Rule{rules.NoisyNeighbor(10, 2*time.Minute)})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	steal := func(offset time.Duration, aggregate, cpu0 float64) []rules.Alert {
+		return engine.Evaluate(&performance.Snapshot{
+			Timestamp: start.Add(offset),
+			Metrics: performance.Metrics{CPU: []performance.CPUStats{
+				{CPUIndex: -1, StealPercent: aggregate},
+				{CPUIndex: 0, StealPercent: cpu0},
+			}},
+		})
+	}
+
+	// Steal on one CPU of the node isn't a noisy neighbor
+	if alerts := steal(0, 2, 50); len(alerts) != 0 {
+		t.Fatalf("expected no alerts, got %+v", alerts)
+	}
+	if alerts := steal(time.Minute, 15, 15); len(alerts) != 0 {
+		t.Fatalf("expected no alerts before the steal was sustained, got %+v", alerts)
+	}
+	alerts := steal(3*time.Minute, 20, 20)
+	if len(alerts) != 1 || alerts[0].State != rules.StateFiring || alerts[0].Rule.Name != rules.NoisyNeighborRule {
+		t.Fatalf("expected the noisy neighbor rule to fire, got %+v", alerts)
+	}
+	alerts = steal(4*time.Minute, 1, 1)
+	if len(alerts) != 1 || alerts[0].State != rules.StateResolved {
+		t.Fatalf("expected the noisy neighbor rule to resolve, got %+v", alerts)
+	}
+}
+
+func TestMemoryPressureRules(t *testing.T) {
+	engine := rules.NewEngine([]rules.Rule{
+		rules.DirtyPressure(80, time.Minute),
+		rules.ReclaimStall(10, time.Minute),
+		rules.IOStall(20, time.Minute),
+	})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	evaluate := func(offset time.Duration, stats *performance.MemoryPressureStats) []rules.Alert {
+		return engine.Evaluate(&performance.Snapshot{
+			Timestamp: start.Add(offset),
+			Metrics:   performance.Metrics{MemoryPressure: stats},
+		})
+	}
+	names := func(alerts []rules.Alert) []string {
+		var names []string
+		for _, a := range alerts {
+			names = append(names, a.Rule.Name+" "+string(a.State))
+		}
+		return names
+	}
+
+	// Rates of the first collection aren't evaluated, and nodes without pressure stall
+	// information have no IO stalls
+	if alerts := evaluate(0, &performance.MemoryPressureStats{DirtyPercent: 95, AllocStalls: 100}); len(alerts) != 0 {
+		t.Fatalf("expected no alerts, got %+v", alerts)
+	}
+	stalled := &performance.MemoryPressureStats{
+		Interval:       time.Minute,
+		DirtyPercent:   95,
+		AllocStallRate: 50,
+		IO:             &performance.PressureStats{Full: performance.PressureStall{Avg10: 40}},
+	}
+	if alerts := evaluate(30*time.Second, stalled); len(alerts) != 0 {
+		t.Fatalf("expected no alerts before the pressure was sustained, got %+v", alerts)
+	}
+	alerts := evaluate(90*time.Second, stalled)
+	want := []string{"dirty-pressure firing", "io-stall firing", "reclaim-stall firing"}
+	if got := names(alerts); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	alerts = evaluate(2*time.Minute, &performance.MemoryPressureStats{Interval: time.Minute, DirtyPercent: 95})
+	want = []string{"io-stall resolved", "reclaim-stall resolved"}
+	if got := names(alerts); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if firing := engine.Firing(); len(firing) != 1 || firing[0].Rule.Name != rules.DirtyPressureRule {
+		t.Fatalf("expected the dirty pressure rule to keep firing, got %+v", firing)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package samples
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+// csvEncoder writes samples as CSV with a header row and RFC 3339 timestamps in UTC
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer) (*csvEncoder, error) {
+	enc := &csvEncoder{w: csv.NewWriter(w)}
+	if err := enc.w.Write([]string{"timestamp", "collector", "metric", "value"}); err != nil {
+		return nil, fmt.Errorf("failed to write sample file header: %w", err)
+	}
+	return enc, nil
+}
+
+func (e *csvEncoder) write(timestamp time.Time, collector, metric, value string) error {
+	return e.w.Write([]string{timestamp.UTC().Format(time.RFC3339Nano), collector, metric, value})
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	return e.flush()
+}
@@ -0,0 +1,352 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package samples
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The file layout is described in https://parquet.apache.org/docs/file-format/ and the
+// metadata structures in parquet.thrift of github.com/apache/parquet-format.
+
+const (
+	parquetMagic = "PAR1"
+	// parquetRowGroupRows is how many samples are buffered before being written as a row group
+	parquetRowGroupRows = 1 << 16
+	parquetCreatedBy    = "antimetal-agent"
+)
+
+// Parquet physical types
+const (
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet converted types
+const (
+	parquetNoConvertedType int32 = -1
+	parquetUTF8            int32 = 0
+	parquetTimestampMicros int32 = 10
+)
+
+// parquetColumn is a column of the samples schema. All columns are required.
+type parquetColumn struct {
+	name          string
+	typ           int32
+	convertedType int32
+}
+
+var parquetColumns = []parquetColumn{
+	{name: "timestamp", typ: parquetInt64, convertedType: parquetTimestampMicros},
+	{name: "collector", typ: parquetByteArray, convertedType: parquetUTF8},
+	{name: "metric", typ: parquetByteArray, convertedType: parquetUTF8},
+	{name: "value", typ: parquetDouble, convertedType: parquetNoConvertedType},
+}
+
+// parquetChunk is the metadata of a column chunk written to the file
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+// parquetEncoder writes samples as an uncompressed Parquet file with a single data page per
+// column chunk and PLAIN encoded values. Samples are buffered in memory until a row group is
+// complete, and the file is only readable once closed, as the metadata is in its footer.
+// Values are written as doubles, so integers above 2^53 lose precision.
+type parquetEncoder struct {
+	w      *bufio.Writer
+	offset int64
+
+	timestamps []int64
+	collectors []string
+	metrics    []string
+	values     []float64
+
+	rowGroups []parquetRowGroup
+	numRows   int64
+}
+
+func newParquetEncoder(w io.Writer) *parquetEncoder {
+	return &parquetEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *parquetEncoder) write(timestamp time.Time, collector, metric, value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q of %s: %w", value, metric, err)
+	}
+	e.timestamps = append(e.timestamps, timestamp.UnixMicro())
+	e.collectors = append(e.collectors, collector)
+	e.metrics = append(e.metrics, metric)
+	e.values = append(e.values, v)
+	return nil
+}
+
+// flush writes the buffered samples once they fill a row group
+func (e *parquetEncoder) flush() error {
+	if len(e.values) < parquetRowGroupRows {
+		return nil
+	}
+	return e.writeRowGroup()
+}
+
+func (e *parquetEncoder) close() error {
+	if len(e.values) > 0 {
+		if err := e.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	if e.offset == 0 {
+		if err := e.writeBytes([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+	footer := e.fileMetaData()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	if err := e.writeBytes(footer); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *parquetEncoder) writeRowGroup() error {
+	if e.offset == 0 {
+		if err := e.writeBytes([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+
+	rows := len(e.values)
+	group := parquetRowGroup{numRows: int64(rows)}
+	for _, col := range parquetColumns {
+		var values []byte
+		switch col.name {
+		case "timestamp":
+			values = make([]byte, 0, 8*rows)
+			for _, ts := range e.timestamps {
+				values = binary.LittleEndian.AppendUint64(values, uint64(ts))
+			}
+		case "collector":
+			values = appendByteArrays(values, e.collectors)
+		case "metric":
+			values = appendByteArrays(values, e.metrics)
+		case "value":
+			values = make([]byte, 0, 8*rows)
+			for _, v := range e.values {
+				values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+			}
+		}
+
+		chunk := parquetChunk{offset: e.offset, numValues: int64(rows)}
+		header := parquetPageHeader(len(values), rows)
+		if err := e.writeBytes(header); err != nil {
+			return err
+		}
+		if err := e.writeBytes(values); err != nil {
+			return err
+		}
+		chunk.size = e.offset - chunk.offset
+		group.chunks = append(group.chunks, chunk)
+	}
+
+	e.rowGroups = append(e.rowGroups, group)
+	e.numRows += int64(rows)
+	e.timestamps = e.timestamps[:0]
+	e.collectors = e.collectors[:0]
+	e.metrics = e.metrics[:0]
+	e.values = e.values[:0]
+	return e.w.Flush()
+}
+
+func (e *parquetEncoder) writeBytes(b []byte) error {
+	n, err := e.w.Write(b)
+	e.offset += int64(n)
+	return err
+}
+
+// appendByteArrays appends the PLAIN encoding of strs: each is prefixed with its length
+func appendByteArrays(b []byte, strs []string) []byte {
+	for _, s := range strs {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// parquetPageHeader encodes the PageHeader of an uncompressed data page of size bytes
+// holding numValues values. Required columns have no repetition or definition levels.
+func parquetPageHeader(size, numValues int) []byte {
+	var c compactWriter
+	c.beginStruct()
+	c.i32(1, 0) // type: DATA_PAGE
+	c.i32(2, int32(size))
+	c.i32(3, int32(size))
+	c.structField(5) // data_page_header
+	c.i32(1, int32(numValues))
+	c.i32(2, 0) // encoding: PLAIN
+	c.i32(3, 3) // definition_level_encoding: RLE
+	c.i32(4, 3) // repetition_level_encoding: RLE
+	c.endStruct()
+	c.endStruct()
+	return c.b
+}
+
+// fileMetaData encodes the FileMetaData of the footer
+func (e *parquetEncoder) fileMetaData() []byte {
+	var c compactWriter
+	c.beginStruct()
+	c.i32(1, 1) // version
+	c.list(2, compactStruct, 1+len(parquetColumns))
+	c.beginStruct()
+	c.binary(4, "schema")
+	c.i32(5, int32(len(parquetColumns)))
+	c.endStruct()
+	for _, col := range parquetColumns {
+		c.beginStruct()
+		c.i32(1, col.typ)
+		c.i32(3, 0) // repetition_type: REQUIRED
+		c.binary(4, col.name)
+		if col.convertedType != parquetNoConvertedType {
+			c.i32(6, col.convertedType)
+		}
+		c.endStruct()
+	}
+	c.i64(3, e.numRows)
+	c.list(4, compactStruct, len(e.rowGroups))
+	for _, group := range e.rowGroups {
+		c.beginStruct()
+		c.list(1, compactStruct, len(group.chunks))
+		var total int64
+		for i, chunk := range group.chunks {
+			col := parquetColumns[i]
+			total += chunk.size
+			c.beginStruct()
+			c.i64(2, chunk.offset) // file_offset
+			c.structField(3)       // meta_data
+			c.i32(1, col.typ)
+			c.list(2, compactI32, 1)
+			c.zigzag(0) // encodings: PLAIN
+			c.list(3, compactBinary, 1)
+			c.str(col.name) // path_in_schema
+			c.i32(4, 0)     // codec: UNCOMPRESSED
+			c.i64(5, chunk.numValues)
+			c.i64(6, chunk.size) // total_uncompressed_size
+			c.i64(7, chunk.size) // total_compressed_size
+			c.i64(9, chunk.offset)
+			c.endStruct()
+			c.endStruct()
+		}
+		c.i64(2, total)
+		c.i64(3, group.numRows)
+		c.endStruct()
+	}
+	c.binary(6, parquetCreatedBy)
+	c.endStruct()
+	return c.b
+}
+
+// Thrift compact protocol types
+const (
+	compactI32    byte = 5
+	compactI64    byte = 6
+	compactBinary byte = 8
+	compactList   byte = 9
+	compactStruct byte = 12
+)
+
+// compactWriter encodes the subset of the Thrift compact protocol used by Parquet metadata.
+// Structs, including the outermost one, start with beginStruct or structField and end with
+// endStruct, and their fields must be written in increasing order of ID.
+type compactWriter struct {
+	b []byte
+	// last is the ID of the last field written in the current struct, stack the ones of the
+	// enclosing structs
+	last  int16
+	stack []int16
+}
+
+func (c *compactWriter) varint(v uint64) {
+	c.b = binary.AppendUvarint(c.b, v)
+}
+
+func (c *compactWriter) zigzag(v int64) {
+	c.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (c *compactWriter) field(id int16, typ byte) {
+	if delta := id - c.last; delta > 0 && delta <= 15 {
+		c.b = append(c.b, byte(delta)<<4|typ)
+	} else {
+		c.b = append(c.b, typ)
+		c.zigzag(int64(id))
+	}
+	c.last = id
+}
+
+func (c *compactWriter) i32(id int16, v int32) {
+	c.field(id, compactI32)
+	c.zigzag(int64(v))
+}
+
+func (c *compactWriter) i64(id int16, v int64) {
+	c.field(id, compactI64)
+	c.zigzag(v)
+}
+
+func (c *compactWriter) binary(id int16, s string) {
+	c.field(id, compactBinary)
+	c.str(s)
+}
+
+// str encodes a string list element
+func (c *compactWriter) str(s string) {
+	c.varint(uint64(len(s)))
+	c.b = append(c.b, s...)
+}
+
+// list starts a list field of n elements of type elem, which follow without field headers
+func (c *compactWriter) list(id int16, elem byte, n int) {
+	c.field(id, compactList)
+	if n < 15 {
+		c.b = append(c.b, byte(n)<<4|elem)
+		return
+	}
+	c.b = append(c.b, 0xf0|elem)
+	c.varint(uint64(n))
+}
+
+// beginStruct starts a struct list element or the outermost struct
+func (c *compactWriter) beginStruct() {
+	c.stack = append(c.stack, c.last)
+	c.last = 0
+}
+
+// structField starts a struct field
+func (c *compactWriter) structField(id int16) {
+	c.field(id, compactStruct)
+	c.beginStruct()
+}
+
+func (c *compactWriter) endStruct() {
+	c.b = append(c.b, 0)
+	c.last = c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+}
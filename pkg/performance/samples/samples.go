@@ -0,0 +1,215 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package samples writes the values collected in performance snapshots to files in long
+// format, one row per sample, i.e. per numeric value collected, with the columns
+//
+//	timestamp,collector,metric,value
+//
+// metric is the path of the value in the collector's data, e.g. Interfaces.eth0.RxBytes,
+// so the files load directly into pandas or DuckDB. Strings and timestamps are not samples
+// and are left out; booleans are written as 0 or 1.
+package samples
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Format is the file format of a Writer
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+)
+
+// elementKeys are the fields identifying an element of a list of metrics, e.g. a network
+// interface or a disk. They name the element in metric paths instead of its index so that
+// samples of the same device line up across snapshots.
+var elementKeys = []string{"Name", "Device", "Interface"}
+
+// encoder encodes samples in a file format
+type encoder interface {
+	write(timestamp time.Time, collector, metric, value string) error
+	// flush writes what was encoded so far to the file, if the format allows it
+	flush() error
+	// close writes the end of the file
+	close() error
+}
+
+// Writer writes the samples of snapshots to a file
+type Writer struct {
+	f      *os.File
+	enc    encoder
+	closed bool
+}
+
+// Create creates the file at path and returns a Writer of samples to it in format
+func Create(path string, format Format) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sample file: %w", err)
+	}
+	var enc encoder
+	switch format {
+	case FormatCSV:
+		enc, err = newCSVEncoder(f)
+	case FormatParquet:
+		enc = newParquetEncoder(f)
+	default:
+		err = fmt.Errorf("unknown sample file format %q", format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Writer{f: f, enc: enc}, nil
+}
+
+// WriteSnapshot writes the samples of the collectors that succeeded in snapshot
+func (w *Writer) WriteSnapshot(snapshot *performance.Snapshot) error {
+	types := make([]performance.MetricType, 0, len(snapshot.CollectorRun.CollectorStats))
+	for metricType, stat := range snapshot.CollectorRun.CollectorStats {
+		if stat.Error == nil && stat.Data != nil {
+			types = append(types, metricType)
+		}
+	}
+	slices.Sort(types)
+
+	for _, metricType := range types {
+		var werr error
+		err := Flatten(snapshot.CollectorRun.CollectorStats[metricType].Data, func(metric, value string) {
+			if werr == nil {
+				werr = w.enc.write(snapshot.Timestamp, string(metricType), metric, value)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to flatten %s data: %w", metricType, err)
+		}
+		if werr != nil {
+			return fmt.Errorf("failed to write %s samples: %w", metricType, werr)
+		}
+	}
+
+	// Flush every snapshot so that an interrupted capture keeps what was collected
+	return w.enc.flush()
+}
+
+// Close writes the end of the file and closes it. Closing a closed Writer does nothing.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.enc.close(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// Flatten calls emit for each sample of data, e.g. the data of a collector, with its
+// metric path and value
+func Flatten(data any, emit func(metric, value string)) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	flatten("", v, emit)
+	return nil
+}
+
+// flatten calls emit for each number and boolean in v, a decoded JSON value, with its dot
+// separated path. Dots and backslashes within a path segment are escaped with a backslash,
+// e.g. the RxBytes of eth0.100 are Interfaces.eth0\.100.RxBytes.
+func flatten(path string, v any, emit func(metric, value string)) {
+	join := func(segment string) string {
+		segment = escapeSegment(segment)
+		if path == "" {
+			return segment
+		}
+		return path + "." + segment
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		emit(path, v.String())
+	case bool:
+		if v {
+			emit(path, "1")
+		} else {
+			emit(path, "0")
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			flatten(join(key), v[key], emit)
+		}
+	case []any:
+		keys := elementKeysOf(v)
+		for i, elem := range v {
+			flatten(join(keys[i]), elem, emit)
+		}
+	}
+}
+
+// elementKeysOf returns the path segments of the elements of a list: their identifying
+// field, or their index if they have none or share it with another element, e.g. processes
+// with the same name
+func elementKeysOf(list []any) []string {
+	keys := make([]string, len(list))
+	counts := make(map[string]int, len(list))
+	for i, elem := range list {
+		keys[i] = elementKey(elem)
+		counts[keys[i]]++
+	}
+	for i, key := range keys {
+		if key == "" || counts[key] > 1 {
+			keys[i] = strconv.Itoa(i)
+		}
+	}
+	return keys
+}
+
+// elementKey returns the identifying field of a list element, empty if it has none
+func elementKey(elem any) string {
+	if obj, ok := elem.(map[string]any); ok {
+		for _, key := range elementKeys {
+			if name, ok := obj[key].(string); ok && name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+var segmentEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`)
+
+func escapeSegment(segment string) string {
+	if !strings.ContainsAny(segment, `.\`) {
+		return segment
+	}
+	return segmentEscaper.Replace(segment)
+}
@@ -0,0 +1,309 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package samples
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+type sample struct {
+	metric, value string
+}
+
+func flattenJSON(t *testing.T, data string) []sample {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	var v any
+	require.NoError(t, dec.Decode(&v))
+	var samples []sample
+	flatten("", v, func(metric, value string) {
+		samples = append(samples, sample{metric, value})
+	})
+	return samples
+}
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []sample
+	}{
+		{
+			name: "nested objects in key order",
+			data: `{"Load": {"Load5": 0.5, "Load1": 1.25}, "Up": true, "Down": false}`,
+			want: []sample{{"Down", "0"}, {"Load.Load1", "1.25"}, {"Load.Load5", "0.5"}, {"Up", "1"}},
+		},
+		{
+			name: "strings and nulls are not samples",
+			data: `{"Kernel": "6.1.0", "Boot": null, "Procs": 12}`,
+			want: []sample{{"Procs", "12"}},
+		},
+		{
+			name: "elements named by their key",
+			data: `[{"Interface": "eth0", "RxBytes": 1}, {"Device": "sda", "Reads": 2}, {"Name": "init", "PID": 1}]`,
+			want: []sample{{"eth0.RxBytes", "1"}, {"sda.Reads", "2"}, {"init.PID", "1"}},
+		},
+		{
+			name: "elements without a key by index",
+			data: `{"Cores": [{"Usage": 10}, {"Usage": 20}], "Loads": [1, 2]}`,
+			want: []sample{{"Cores.0.Usage", "10"}, {"Cores.1.Usage", "20"}, {"Loads.0", "1"}, {"Loads.1", "2"}},
+		},
+		{
+			name: "dots and backslashes in keys are escaped",
+			data: `{"Interfaces": [{"Interface": "eth0.100", "RxBytes": 1}], "Mounts": {"/var/lib\\x": {"Used": 2}}}`,
+			want: []sample{{`Interfaces.eth0\.100.RxBytes`, "1"}, {`Mounts./var/lib\\x.Used`, "2"}},
+		},
+		{
+			name: "elements sharing a key by index",
+			data: `[{"Name": "nginx", "PID": 10}, {"Name": "init", "PID": 1}, {"Name": "nginx", "PID": 11}]`,
+			want: []sample{{"0.PID", "10"}, {"init.PID", "1"}, {"2.PID", "11"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, flattenJSON(t, tt.data))
+		})
+	}
+}
+
+func testSnapshots() []*performance.Snapshot {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	snapshot := func(offset time.Duration, rxBytes uint64) *performance.Snapshot {
+		return &performance.Snapshot{
+			Timestamp: start.Add(offset),
+			CollectorRun: performance.CollectorRunInfo{
+				CollectorStats: map[performance.MetricType]performance.CollectorStat{
+					performance.MetricTypeLoad: {
+						Data: &performance.LoadStats{Load1Min: 0.5, RunningProcs: 3},
+					},
+					performance.MetricTypeNetwork: {
+						Data: []*performance.NetworkStats{{Interface: "eth0", RxBytes: rxBytes}},
+					},
+					performance.MetricTypeMemory: {Error: errors.New("failed")},
+				},
+			},
+		}
+	}
+	return []*performance.Snapshot{snapshot(0, 100), snapshot(10*time.Second, 250)}
+}
+
+func writeSamples(t *testing.T, format Format) string {
+	path := filepath.Join(t.TempDir(), "samples."+string(format))
+	w, err := Create(path, format)
+	require.NoError(t, err)
+	for _, snapshot := range testSnapshots() {
+		require.NoError(t, w.WriteSnapshot(snapshot))
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close(), "closing twice")
+	return path
+}
+
+func TestWriter_CSV(t *testing.T) {
+	data, err := os.ReadFile(writeSamples(t, FormatCSV))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	assert.Equal(t, "timestamp,collector,metric,value", lines[0])
+	assert.Contains(t, lines, "2025-03-01T12:00:00Z,load,Load1Min,0.5")
+	assert.Contains(t, lines, "2025-03-01T12:00:10Z,network,eth0.RxBytes,250")
+	for _, line := range lines {
+		assert.NotContains(t, line, ",memory,", "failed collectors have no samples")
+	}
+}
+
+func TestWriter_Parquet(t *testing.T) {
+	data, err := os.ReadFile(writeSamples(t, FormatParquet))
+	require.NoError(t, err)
+	columns := readParquet(t, data)
+
+	require.Len(t, columns, 4)
+	rows := len(columns[0])
+	require.Positive(t, rows)
+	var rxBytes []float64
+	for i := range rows {
+		if columns[1][i] == "network" && columns[2][i] == "eth0.RxBytes" {
+			rxBytes = append(rxBytes, columns[3][i].(float64))
+		}
+		assert.NotEqual(t, "memory", columns[1][i], "failed collectors have no samples")
+	}
+	assert.Equal(t, []float64{100, 250}, rxBytes)
+	assert.Equal(t, time.Date(2025, 3, 1, 12, 0, 10, 0, time.UTC).UnixMicro(), columns[0][rows-1])
+}
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testdata/samples.parquet holds the samples of testSnapshots as written by parquetEncoder.
+// It is checked byte for byte so that any change to the encoding shows up in review, and a
+// regenerated file (go test -update) should be loaded with an independent reader before
+// being checked in:
+//
+//	duckdb -c "SELECT * FROM 'testdata/samples.parquet'"
+//	python3 -c "import pyarrow.parquet as pq; print(pq.read_table('testdata/samples.parquet'))"
+func TestWriter_ParquetGolden(t *testing.T) {
+	golden := filepath.Join("testdata", "samples.parquet")
+	data, err := os.ReadFile(writeSamples(t, FormatParquet))
+	require.NoError(t, err)
+	if *update {
+		require.NoError(t, os.WriteFile(golden, data, 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, want, data, "encoding changed, regenerate %s with -update and check it loads", golden)
+}
+
+func TestWriter_ParquetRowGroups(t *testing.T) {
+	enc := newParquetEncoder(&strings.Builder{})
+	for i := range parquetRowGroupRows + 1 {
+		require.NoError(t, enc.write(time.Unix(int64(i), 0), "load", "Load1", "1"))
+		require.NoError(t, enc.flush())
+	}
+	require.NoError(t, enc.close())
+	require.Len(t, enc.rowGroups, 2)
+	assert.Equal(t, int64(parquetRowGroupRows), enc.rowGroups[0].numRows)
+	assert.Equal(t, int64(parquetRowGroupRows+1), enc.numRows)
+}
+
+func TestWriter_ParquetEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.parquet")
+	w, err := Create(path, FormatParquet)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, readParquet(t, data)[0])
+}
+
+// readParquet returns the values of the columns of the samples in a Parquet file written
+// by parquetEncoder, decoding its metadata independently of the encoder
+func readParquet(t *testing.T, data []byte) [][]any {
+	require.True(t, strings.HasPrefix(string(data), parquetMagic))
+	require.True(t, strings.HasSuffix(string(data), parquetMagic))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	meta, n := readCompactStruct(t, footer)
+	require.Equal(t, footerLen, n, "footer length")
+
+	schema := meta[2].([]any)
+	require.Len(t, schema, 5)
+	assert.Equal(t, "schema", schema[0].(map[int16]any)[4])
+	types := make([]int64, 4)
+	for i, elem := range schema[1:] {
+		fields := elem.(map[int16]any)
+		assert.Equal(t, parquetColumns[i].name, fields[4])
+		types[i] = fields[1].(int64)
+	}
+
+	columns := make([][]any, 4)
+	var rows int64
+	for _, group := range meta[4].([]any) {
+		for i, chunk := range group.(map[int16]any)[1].([]any) {
+			colMeta := chunk.(map[int16]any)[3].(map[int16]any)
+			offset := colMeta[9].(int64)
+			header, n := readCompactStruct(t, data[offset:])
+			page := data[offset+int64(n):]
+			page = page[:header[2].(int64)]
+			numValues := header[5].(map[int16]any)[1].(int64)
+			require.Equal(t, colMeta[5].(int64), numValues)
+			for range numValues {
+				switch types[i] {
+				case int64(parquetInt64):
+					columns[i] = append(columns[i], int64(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				case int64(parquetDouble):
+					columns[i] = append(columns[i], math.Float64frombits(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				case int64(parquetByteArray):
+					size := binary.LittleEndian.Uint32(page)
+					columns[i] = append(columns[i], string(page[4:4+size]))
+					page = page[4+size:]
+				}
+			}
+			assert.Empty(t, page, "page fully decoded")
+		}
+		rows += group.(map[int16]any)[3].(int64)
+	}
+	assert.Equal(t, meta[3].(int64), rows)
+	return columns
+}
+
+// readCompactStruct decodes a Thrift compact struct into its fields by ID and returns the
+// number of bytes read. Integers are returned as int64, binaries as strings and lists as
+// []any.
+func readCompactStruct(t *testing.T, b []byte) (map[int16]any, int) {
+	pos := 0
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(b[pos:])
+		require.Positive(t, n)
+		pos += n
+		return v
+	}
+	zigzag := func() int64 {
+		v := uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	}
+	var value func(typ byte) any
+	value = func(typ byte) any {
+		switch typ {
+		case compactI32, compactI64:
+			return zigzag()
+		case compactBinary:
+			size := int(uvarint())
+			s := string(b[pos : pos+size])
+			pos += size
+			return s
+		case compactList:
+			header := b[pos]
+			pos++
+			size := int(header >> 4)
+			if size == 15 {
+				size = int(uvarint())
+			}
+			list := make([]any, size)
+			for i := range list {
+				list[i] = value(header & 0x0f)
+			}
+			return list
+		case compactStruct:
+			fields, n := readCompactStruct(t, b[pos:])
+			pos += n
+			return fields
+		}
+		t.Fatalf("unexpected compact type %d", typ)
+		return nil
+	}
+
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		header := b[pos]
+		pos++
+		if header == 0 {
+			return fields, pos
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(zigzag())
+		}
+		fields[id] = value(header & 0x0f)
+		last = id
+	}
+}
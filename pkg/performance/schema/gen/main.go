@@ -0,0 +1,48 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Command gen writes the schemas of the collectors to the schemas directory of the
+// schema package. It is run by go generate. The schema package embeds the directory, so it
+// must hold at least one schema for gen to build.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/antimetal/agent/pkg/performance/schema"
+)
+
+func main() {
+	if err := run("schemas"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string) error {
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	// Schemas of removed collectors are deleted
+	for _, path := range existing {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	for metricType, t := range schema.Types {
+		b, err := schema.Marshal(metricType, t)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, string(metricType)+".json"), b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
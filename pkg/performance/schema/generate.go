@@ -0,0 +1,230 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Types are the Go types of the data of each collector, which the schemas are generated
+// from
+var Types = map[performance.MetricType]reflect.Type{
+	performance.MetricTypeLoad:            reflect.TypeFor[*performance.LoadStats](),
+	performance.MetricTypeMemory:          reflect.TypeFor[*performance.MemoryStats](),
+	performance.MetricTypeCPU:             reflect.TypeFor[[]performance.CPUStats](),
+	performance.MetricTypeProcess:         reflect.TypeFor[[]performance.ProcessStats](),
+	performance.MetricTypeDisk:            reflect.TypeFor[[]performance.DiskStats](),
+	performance.MetricTypeNetwork:         reflect.TypeFor[[]performance.NetworkStats](),
+	performance.MetricTypeTCP:             reflect.TypeFor[*performance.TCPStats](),
+	performance.MetricTypeKernel:          reflect.TypeFor[[]performance.KernelMessage](),
+	performance.MetricTypePower:           reflect.TypeFor[*performance.PowerStats](),
+	performance.MetricTypeProcessState:    reflect.TypeFor[*performance.ProcessStateStats](),
+	performance.MetricTypeSwap:            reflect.TypeFor[*performance.SwapStats](),
+	performance.MetricTypeCertificate:     reflect.TypeFor[*performance.CertificateStats](),
+	performance.MetricTypeCostHints:       reflect.TypeFor[*performance.CostHints](),
+	performance.MetricTypeKernelTaint:     reflect.TypeFor[*performance.KernelTaintStats](),
+	performance.MetricTypeNFS:             reflect.TypeFor[*performance.NFSStats](),
+	performance.MetricTypeNeighbor:        reflect.TypeFor[*performance.NeighborStats](),
+	performance.MetricTypeIPVS:            reflect.TypeFor[*performance.IPVSStats](),
+	performance.MetricTypeBoot:            reflect.TypeFor[*performance.BootStats](),
+	performance.MetricTypeCPUPerf:         reflect.TypeFor[*performance.CPUPerfStats](),
+	performance.MetricTypeSlab:            reflect.TypeFor[*performance.SlabStats](),
+	performance.MetricTypeCgroupCPU:       reflect.TypeFor[*performance.CgroupCPUStats](),
+	performance.MetricTypeTCPSockets:      reflect.TypeFor[*performance.TCPSocketStats](),
+	performance.MetricTypeMemoryPressure:  reflect.TypeFor[*performance.MemoryPressureStats](),
+	performance.MetricTypeMemoryBandwidth: reflect.TypeFor[*performance.MemoryBandwidthStats](),
+	performance.MetricTypeNetworkInfo:     reflect.TypeFor[*performance.NetworkInfo](),
+	performance.MetricTypeTopology:        reflect.TypeFor[*performance.TopologyStats](),
+	performance.MetricTypeLockContention:  reflect.TypeFor[*performance.LockContentionStats](),
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+)
+
+// Generate returns the schema of the JSON encoding of the data of the collector of
+// metricType, of type t. A collector always returns data when it succeeds, so a pointer
+// to its data isn't nullable.
+func Generate(metricType performance.MetricType, t reflect.Type) (*Schema, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	s, err := generate(t, make(map[reflect.Type]bool))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", metricType, err)
+	}
+	s.Dialect = Dialect
+	s.Title = string(metricType)
+	return s, nil
+}
+
+// generate returns the schema of the JSON encoding of values of type t. parents are the
+// struct types t is nested in, to reject recursive types.
+func generate(t reflect.Type, parents map[reflect.Type]bool) (*Schema, error) {
+	switch t {
+	case timeType:
+		return &Schema{Type: TypeList{"string"}, Format: "date-time"}, nil
+	case durationType:
+		// Nanoseconds
+		return &Schema{Type: TypeList{"integer"}}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: TypeList{"boolean"}}, nil
+	case reflect.String:
+		return &Schema{Type: TypeList{"string"}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: TypeList{"integer"}}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeList{"number"}}, nil
+	case reflect.Interface:
+		// Anything, e.g. an error, which encodes as an empty object
+		return &Schema{}, nil
+	case reflect.Pointer:
+		s, err := generate(t.Elem(), parents)
+		if err != nil {
+			return nil, err
+		}
+		return nullable(s), nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64
+			return &Schema{Type: TypeList{"string", "null"}}, nil
+		}
+		items, err := generate(t.Elem(), parents)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeList{"array", "null"}, Items: items}, nil
+	case reflect.Array:
+		items, err := generate(t.Elem(), parents)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeList{"array"}, Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := generate(t.Elem(), parents)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeList{"object", "null"}, AdditionalProperties: values}, nil
+	case reflect.Struct:
+		return generateStruct(t, parents)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func generateStruct(t reflect.Type, parents map[reflect.Type]bool) (*Schema, error) {
+	if parents[t] {
+		return nil, fmt.Errorf("recursive type %s", t)
+	}
+	parents[t] = true
+	defer delete(parents, t)
+
+	s := &Schema{
+		Type:                 TypeList{"object"},
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: False(),
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			return nil, fmt.Errorf("unsupported embedded field %s.%s", t, field.Name)
+		}
+		name, omitempty := field.Name, false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, opts, _ := strings.Cut(tag, ",")
+			if tagName == "-" && opts == "" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+			omitempty = strings.Contains(","+opts+",", ",omitempty,")
+		}
+
+		prop, err := generate(field.Type, parents)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t, field.Name, err)
+		}
+		if tag, ok := field.Tag.Lookup("schema"); ok {
+			if err := constrain(prop, tag); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t, field.Name, err)
+			}
+		}
+		s.Properties[name] = prop
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s, nil
+}
+
+// constrain adds the constraints of a schema tag to s, or to its items if s is an array
+func constrain(s *Schema, tag string) error {
+	for s.Items != nil {
+		s = s.Items
+	}
+	for _, constraint := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(constraint, "=")
+		switch key {
+		case "minimum", "maximum":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			if key == "minimum" {
+				s.Minimum = &f
+			} else {
+				s.Maximum = &f
+			}
+		case "enum":
+			s.Enum = strings.Split(value, "|")
+		default:
+			return fmt.Errorf("unknown schema constraint %q", key)
+		}
+	}
+	return nil
+}
+
+// nullable returns s accepting null
+func nullable(s *Schema) *Schema {
+	if len(s.Type) > 0 && !slices.Contains(s.Type, "null") {
+		s.Type = append(s.Type, "null")
+	}
+	return s
+}
+
+// Marshal returns the schema file of the collector of metricType, whose data is of type t
+func Marshal(metricType performance.MetricType, t reflect.Type) ([]byte, error) {
+	s, err := Generate(metricType, t)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
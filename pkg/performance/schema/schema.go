@@ -0,0 +1,270 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package schema describes the JSON encoding of the data of each performance collector
+// with a JSON Schema, and checks collected data against it.
+//
+// The schemas are generated from the Go types of the data, see Types, and embedded in the
+// package. The `schema` tag of a field adds the constraints its values must satisfy, e.g.
+// `schema:"minimum=0,maximum=100"` for a percentage. After changing these types, run
+//
+//	go generate ./pkg/performance/schema
+//
+// The schemas use a subset of JSON Schema 2020-12: type, format (date-time), properties,
+// required, additionalProperties, items, enum (of strings), minimum and maximum.
+package schema
+
+//go:generate go run ./gen
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// Dialect is the JSON Schema dialect of the schemas
+const Dialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ErrNoSchema is returned for collectors without a schema
+var ErrNoSchema = errors.New("no schema")
+
+//go:embed schemas/*.json
+var files embed.FS
+
+// Schema is a JSON Schema
+type Schema struct {
+	Dialect              string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 TypeList           `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+
+	// never is the false schema, which no value is valid against
+	never bool
+}
+
+// False returns the schema no value is valid against, e.g. as additionalProperties of
+// objects without other properties
+func False() *Schema {
+	return &Schema{never: true}
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	if s.never {
+		return []byte("false"), nil
+	}
+	type schema Schema
+	return json.Marshal((*schema)(s))
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	switch string(bytes.TrimSpace(b)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+	type schema Schema
+	return json.Unmarshal(b, (*schema)(s))
+}
+
+// TypeList is the type keyword: the JSON types a value can have. A single type is
+// encoded as a string.
+type TypeList []string
+
+func (t TypeList) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *TypeList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = TypeList{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// Get returns the schema of the data of the collector of metricType, or ErrNoSchema
+func Get(metricType performance.MetricType) (*Schema, error) {
+	b, err := files.ReadFile("schemas/" + string(metricType) + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("%w for collector %s", ErrNoSchema, metricType)
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("invalid schema of collector %s: %w", metricType, err)
+	}
+	return s, nil
+}
+
+// Validate returns how data, collected by the collector of metricType, doesn't match its
+// schema. It returns ErrNoSchema if the collector has none.
+func Validate(metricType performance.MetricType, data any) ([]ValidationError, error) {
+	s, err := Get(metricType)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	// Numbers are kept as is, so that integers aren't rounded to float64
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode data: %w", err)
+	}
+	return s.Validate(v), nil
+}
+
+// ValidationError is a value that doesn't match its schema
+type ValidationError struct {
+	// Path is the JSON Pointer of the value, e.g. /0/Utilization
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + e.Message
+}
+
+// Validate returns how v, a decoded JSON value with numbers decoded as json.Number,
+// doesn't match s
+func (s *Schema) Validate(v any) []ValidationError {
+	var errs []ValidationError
+	s.validate("", v, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, v any, errs *[]ValidationError) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.never {
+		fail("not allowed")
+		return
+	}
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(typ string) bool { return isType(v, typ) }) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+			fail("%q is not one of %s", v, strings.Join(s.Enum, ", "))
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				fail("%q is not a date-time", v)
+			}
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			fail("%s is not a number", v)
+			return
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("%s is less than the minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("%s is greater than the maximum %v", v, *s.Maximum)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(path+"/"+strconv.Itoa(i), item, errs)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing property %s", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propPath := path + "/" + escapePointer(name)
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(propPath, v[name], errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(propPath, v[name], errs)
+			}
+		}
+	}
+}
+
+// isType returns whether v is of the JSON type typ
+func isType(v any, typ string) bool {
+	switch typ {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		return ok && !strings.ContainsAny(n.String(), ".eE")
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	for _, typ := range []string{"null", "boolean", "string", "integer", "number", "array", "object"} {
+		if isType(v, typ) {
+			return typ
+		}
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// escapePointer escapes name as a JSON Pointer reference token
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
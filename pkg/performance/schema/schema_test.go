@@ -0,0 +1,125 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package schema_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+	"github.com/antimetal/agent/pkg/performance/schema"
+)
+
+func TestSchemasUpToDate(t *testing.T) {
+	files, err := filepath.Glob("schemas/*.json")
+	require.NoError(t, err)
+	assert.Len(t, files, len(schema.Types), "run go generate ./pkg/performance/schema")
+
+	for metricType, typ := range schema.Types {
+		expected, err := schema.Marshal(metricType, typ)
+		require.NoError(t, err)
+		actual, err := os.ReadFile(filepath.Join("schemas", string(metricType)+".json"))
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(actual),
+			"schema of %s is out of date, run go generate ./pkg/performance/schema", metricType)
+	}
+}
+
+func TestSchemasCoverCollectors(t *testing.T) {
+	for metricType := range collectors.PointCollectorFactories() {
+		assert.Contains(t, schema.Types, metricType, "collector %s has no schema", metricType)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		metricType performance.MetricType
+		data       any
+		expected   []schema.ValidationError
+	}{
+		{
+			name:       "valid list",
+			metricType: performance.MetricTypeCPU,
+			data:       []*performance.CPUStats{{CPUIndex: -1, Utilization: 42.5}, {CPUIndex: 0}},
+		},
+		{
+			name:       "valid struct",
+			metricType: performance.MetricTypeLoad,
+			data:       &performance.LoadStats{Load1Min: 0.5, Uptime: time.Hour},
+		},
+		{
+			name:       "percentage above maximum",
+			metricType: performance.MetricTypeCPU,
+			data:       []*performance.CPUStats{{CPUIndex: -1, Utilization: 150}},
+			expected: []schema.ValidationError{
+				{Path: "/0/Utilization", Message: "150 is greater than the maximum 100"},
+			},
+		},
+		{
+			name:       "negative load",
+			metricType: performance.MetricTypeLoad,
+			data:       &performance.LoadStats{Load5Min: -1},
+			expected: []schema.ValidationError{
+				{Path: "/Load5Min", Message: "-1 is less than the minimum 0"},
+			},
+		},
+		{
+			name:       "missing property",
+			metricType: performance.MetricTypeLoad,
+			data:       map[string]any{"Load1Min": 1, "Load5Min": 1, "Load15Min": 1, "RunningProcs": 1, "TotalProcs": 1, "LastPID": 1},
+			expected: []schema.ValidationError{
+				{Path: "", Message: "missing property Uptime"},
+			},
+		},
+		{
+			name:       "unknown property",
+			metricType: performance.MetricTypeLoad,
+			data:       map[string]any{"Load1Min": 1, "Load5Min": 1, "Load15Min": 1, "RunningProcs": 1, "TotalProcs": 1, "LastPID": 1, "Uptime": 1, "Load30Min": 1},
+			expected: []schema.ValidationError{
+				{Path: "/Load30Min", Message: "not allowed"},
+			},
+		},
+		{
+			name:       "wrong type",
+			metricType: performance.MetricTypeLoad,
+			data:       map[string]any{"Load1Min": "high", "Load5Min": 1, "Load15Min": 1, "RunningProcs": 1.5, "TotalProcs": 1, "LastPID": 1, "Uptime": 1},
+			expected: []schema.ValidationError{
+				{Path: "/Load1Min", Message: "expected number, got string"},
+				{Path: "/RunningProcs", Message: "expected integer, got number"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, err := schema.Validate(tt.metricType, tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, errs)
+		})
+	}
+}
+
+func TestValidate_NoSchema(t *testing.T) {
+	_, err := schema.Validate(performance.MetricType("unknown"), nil)
+	assert.ErrorIs(t, err, schema.ErrNoSchema)
+}
+
+func TestSchema_JSON(t *testing.T) {
+	s, err := schema.Get(performance.MetricTypeLoad)
+	require.NoError(t, err)
+	assert.Equal(t, schema.Dialect, s.Dialect)
+	assert.Equal(t, schema.TypeList{"object"}, s.Type)
+	// additionalProperties: false survives decoding
+	assert.NotEmpty(t, s.Validate(map[string]any{"Unknown": true}))
+}
@@ -0,0 +1,246 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/antimetal/agent/pkg/performance/procparse"
+)
+
+// Capability is a Linux capability, by its bit number
+type Capability uint
+
+// Capabilities the collectors need
+const (
+	CapDACOverride   Capability = 1
+	CapDACReadSearch Capability = 2
+	CapSysAdmin      Capability = 21
+	CapSyslog        Capability = 34
+	CapPerfmon       Capability = 38 // Linux 5.8
+	CapBPF           Capability = 39 // Linux 5.8
+)
+
+// capabilityNames are the names of the capabilities by bit number
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID",
+	"CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST", "CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK",
+	"CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE", "CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL", "CAP_SETFCAP", "CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG",
+	"CAP_WAKE_ALARM", "CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+func (c Capability) String() string {
+	if int(c) < len(capabilityNames) {
+		return capabilityNames[c]
+	}
+	return "CAP_" + strconv.Itoa(int(c))
+}
+
+// CapabilitySet is a set of capabilities, a bit per capability as in /proc/[pid]/status
+type CapabilitySet uint64
+
+// Has reports whether c is in the set
+func (s CapabilitySet) Has(c Capability) bool {
+	return c < 64 && s&(1<<c) != 0
+}
+
+// Names returns the names of the capabilities in the set, in the order of their bits
+func (s CapabilitySet) Names() []string {
+	names := []string{}
+	for c := Capability(0); c < 64; c++ {
+		if s.Has(c) {
+			names = append(names, c.String())
+		}
+	}
+	return names
+}
+
+// Seccomp modes of a process, as in the Seccomp field of /proc/[pid]/status
+const (
+	SeccompDisabled = 0
+	SeccompStrict   = 1
+	SeccompFilter   = 2
+)
+
+// Security is what the kernel lets a process do
+type Security struct {
+	// UID is the effective user ID
+	UID int
+	// Capabilities are the effective capabilities
+	Capabilities CapabilitySet
+	// Seccomp is the seccomp mode, one of the Seccomp constants. A filter, e.g. the default
+	// profile of the container runtime, may block system calls the collectors need.
+	Seccomp int
+}
+
+// ReadSecurity reads what the kernel lets the agent do from /proc/self/status in the
+// agent's own /proc at selfProcPath
+//
+// Format: "Uid:\t<real>\t<effective>\t<saved>\t<fs>", "CapEff:\t<hex>" and "Seccomp:\t<mode>"
+func ReadSecurity(selfProcPath string) (Security, error) {
+	path := filepath.Join(selfProcPath, "self", "status")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Security{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var security Security
+	found := false
+	for line := range strings.SplitSeq(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "Uid":
+			if len(fields) > 1 {
+				security.UID, _ = strconv.Atoi(fields[1])
+			}
+		case "CapEff":
+			caps, err := strconv.ParseUint(fields[0], 16, 64)
+			if err != nil {
+				return Security{}, fmt.Errorf("invalid CapEff %q in %s", fields[0], path)
+			}
+			security.Capabilities = CapabilitySet(caps)
+			found = true
+		case "Seccomp":
+			security.Seccomp, _ = strconv.Atoi(fields[0])
+		}
+	}
+	if !found {
+		return Security{}, fmt.Errorf("no CapEff in %s", path)
+	}
+	return security, nil
+}
+
+// requirement is something a collector can't do without one of a set of capabilities,
+// unless the host configuration lets any process do it
+type requirement struct {
+	anyOf   []Capability
+	purpose string
+	// syscalls are the system calls the collector makes that seccomp filters may block
+	syscalls []string
+	// needed reports whether the capability is needed on the host whose /proc is at
+	// procPath by a process with security. Always if nil.
+	needed func(procPath string, security Security) bool
+}
+
+// dmesgRestricted reports whether reading the kernel log needs CAP_SYSLOG
+func dmesgRestricted(procPath string, _ Security) bool {
+	return procparse.ReadStringFile(filepath.Join(procPath, "sys", "kernel", "dmesg_restrict")) != "0"
+}
+
+// perfEventsRestricted reports whether counting system-wide needs CAP_PERFMON
+func perfEventsRestricted(procPath string, _ Security) bool {
+	paranoid, err := strconv.Atoi(procparse.ReadStringFile(filepath.Join(procPath, "sys", "kernel", "perf_event_paranoid")))
+	return err != nil || paranoid > 0
+}
+
+// notRoot reports whether the agent doesn't own root-only files
+func notRoot(_ string, security Security) bool {
+	return security.UID != 0
+}
+
+var ebpfRequirements = []requirement{
+	{anyOf: []Capability{CapBPF, CapSysAdmin}, purpose: "load eBPF programs", syscalls: []string{"bpf"}},
+	{anyOf: []Capability{CapPerfmon, CapSysAdmin}, purpose: "attach eBPF programs to tracepoints",
+		syscalls: []string{"perf_event_open"}},
+}
+
+// collectorRequirements are the capabilities the collectors need by metric type.
+// Collectors that only read world-readable files need none.
+var collectorRequirements = map[MetricType][]requirement{
+	MetricTypeKernel: {
+		{anyOf: []Capability{CapSyslog}, purpose: "read the kernel log, since kernel.dmesg_restrict is set",
+			needed: dmesgRestricted},
+	},
+	MetricTypeBoot: {
+		{anyOf: []Capability{CapSyslog}, purpose: "read the boot messages of the kernel log, since " +
+			"kernel.dmesg_restrict is set", needed: dmesgRestricted},
+	},
+	MetricTypeCPUPerf: {
+		{anyOf: []Capability{CapPerfmon, CapSysAdmin}, purpose: "count hardware events system-wide, since " +
+			"kernel.perf_event_paranoid is above 0", syscalls: []string{"perf_event_open"},
+			needed: perfEventsRestricted},
+	},
+	MetricTypeSlab: {
+		{anyOf: []Capability{CapDACReadSearch, CapDACOverride}, purpose: "read /proc/slabinfo, which only " +
+			"root can read", needed: notRoot},
+	},
+	MetricTypeLockContention: ebpfRequirements,
+	MetricTypeFileOpen:       ebpfRequirements,
+	MetricTypeProcessExec:    ebpfRequirements,
+}
+
+// SecurityFinding is something an enabled collector needs but the agent isn't allowed
+type SecurityFinding struct {
+	Collector MetricType `json:"collector"`
+	// Capabilities are the capabilities any of which the collector needs, empty if the
+	// finding is about seccomp
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Syscalls are the system calls a seccomp filter may block
+	Syscalls []string `json:"syscalls,omitempty"`
+	// Hint is how to give the collector what it needs
+	Hint string `json:"hint"`
+}
+
+// CheckSecurity returns what the enabled collectors need but security doesn't allow on
+// the host whose /proc is at procPath, sorted by collector. A seccomp filter can't be
+// inspected, so the system calls it may block are reported for every collector making
+// them.
+func CheckSecurity(procPath string, security Security, enabled map[MetricType]bool) []SecurityFinding {
+	var findings []SecurityFinding
+	for metricType, requirements := range collectorRequirements {
+		if !enabled[metricType] {
+			continue
+		}
+		for _, req := range requirements {
+			if req.needed != nil && !req.needed(procPath, security) {
+				continue
+			}
+			if !slices.ContainsFunc(req.anyOf, security.Capabilities.Has) {
+				names := make([]string, len(req.anyOf))
+				for i, c := range req.anyOf {
+					names[i] = c.String()
+				}
+				findings = append(findings, SecurityFinding{
+					Collector:    metricType,
+					Capabilities: names,
+					Hint: fmt.Sprintf("add %s for the %s collector to %s", strings.Join(names, " or "),
+						metricType, req.purpose),
+				})
+				continue
+			}
+			if security.Seccomp == SeccompFilter && len(req.syscalls) > 0 {
+				findings = append(findings, SecurityFinding{
+					Collector: metricType,
+					Syscalls:  req.syscalls,
+					Hint: fmt.Sprintf("allow %s in the seccomp profile for the %s collector to %s, e.g. "+
+						"with the Unconfined profile", strings.Join(req.syscalls, " and "), metricType, req.purpose),
+				})
+			}
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b SecurityFinding) int {
+		return cmp.Compare(a.Collector, b.Collector)
+	})
+	return findings
+}
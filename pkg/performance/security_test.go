@@ -0,0 +1,217 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+
+	"github.com/antimetal/agent/pkg/ebpf/loader"
+)
+
+func writeProcFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range files {
+		fullPath := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestReadSecurity(t *testing.T) {
+	procPath := writeProcFiles(t, map[string]string{
+		"self/status": "Name:\tagent\n" +
+			"Uid:\t1000\t0\t0\t0\n" +
+			"CapInh:\t0000000000000000\n" +
+			"CapEff:\t0000004400000000\n" +
+			"Seccomp:\t2\n",
+	})
+	security, err := ReadSecurity(procPath)
+	if err != nil {
+		t.Fatalf("ReadSecurity() failed: %v", err)
+	}
+	want := Security{UID: 0, Capabilities: 1<<CapSyslog | 1<<CapPerfmon, Seccomp: SeccompFilter}
+	if security != want {
+		t.Errorf("ReadSecurity() = %+v, want %+v", security, want)
+	}
+	if names := security.Capabilities.Names(); !reflect.DeepEqual(names, []string{"CAP_SYSLOG", "CAP_PERFMON"}) {
+		t.Errorf("Names() = %v", names)
+	}
+
+	for name, status := range map[string]string{
+		"missing CapEff": "Name:\tagent\n",
+		"invalid CapEff": "CapEff:\tzz\n",
+	} {
+		procPath := writeProcFiles(t, map[string]string{"self/status": status})
+		if _, err := ReadSecurity(procPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCheckSecurity(t *testing.T) {
+	restricted := writeProcFiles(t, map[string]string{
+		"sys/kernel/dmesg_restrict":      "1\n",
+		"sys/kernel/perf_event_paranoid": "2\n",
+	})
+	open := writeProcFiles(t, map[string]string{
+		"sys/kernel/dmesg_restrict":      "0\n",
+		"sys/kernel/perf_event_paranoid": "-1\n",
+	})
+	enabled := map[MetricType]bool{
+		MetricTypeKernel:         true,
+		MetricTypeCPUPerf:        true,
+		MetricTypeSlab:           true,
+		MetricTypeLockContention: true,
+		MetricTypeLoad:           true,
+	}
+	collectors := func(findings []SecurityFinding) []string {
+		var got []string
+		for _, f := range findings {
+			got = append(got, fmt.Sprintf("%s %v %v", f.Collector, f.Capabilities, f.Syscalls))
+		}
+		return got
+	}
+
+	tests := []struct {
+		name     string
+		procPath string
+		security Security
+		want     []string
+	}{
+		{
+			name:     "unprivileged on a restricted host",
+			procPath: restricted,
+			security: Security{UID: 1000},
+			want: []string{
+				"cpu_perf [CAP_PERFMON CAP_SYS_ADMIN] []",
+				"kernel [CAP_SYSLOG] []",
+				"lock_contention [CAP_BPF CAP_SYS_ADMIN] []",
+				"lock_contention [CAP_PERFMON CAP_SYS_ADMIN] []",
+				"slab [CAP_DAC_READ_SEARCH CAP_DAC_OVERRIDE] []",
+			},
+		},
+		{
+			name:     "unprivileged on an open host",
+			procPath: open,
+			security: Security{UID: 1000},
+			want: []string{
+				"lock_contention [CAP_BPF CAP_SYS_ADMIN] []",
+				"lock_contention [CAP_PERFMON CAP_SYS_ADMIN] []",
+				"slab [CAP_DAC_READ_SEARCH CAP_DAC_OVERRIDE] []",
+			},
+		},
+		{
+			name:     "root without capabilities",
+			procPath: open,
+			security: Security{UID: 0},
+			want: []string{
+				"lock_contention [CAP_BPF CAP_SYS_ADMIN] []",
+				"lock_contention [CAP_PERFMON CAP_SYS_ADMIN] []",
+			},
+		},
+		{
+			name:     "CAP_SYS_ADMIN before Linux 5.8",
+			procPath: restricted,
+			security: Security{UID: 0, Capabilities: 1<<CapSysAdmin | 1<<CapSyslog},
+		},
+		{
+			name:     "seccomp filter",
+			procPath: restricted,
+			security: Security{UID: 0, Capabilities: 1<<CapSysAdmin | 1<<CapSyslog, Seccomp: SeccompFilter},
+			want: []string{
+				"cpu_perf [] [perf_event_open]",
+				"lock_contention [] [bpf]",
+				"lock_contention [] [perf_event_open]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collectors(CheckSecurity(tt.procPath, tt.security, enabled))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckSecurity() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	findings := CheckSecurity(restricted, Security{UID: 1000}, map[MetricType]bool{MetricTypeKernel: true})
+	if len(findings) != 1 || findings[0].Hint !=
+		"add CAP_SYSLOG for the kernel collector to read the kernel log, since kernel.dmesg_restrict is set" {
+		t.Errorf("CheckSecurity() = %+v, want a CAP_SYSLOG hint", findings)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	m, err := NewManager(ManagerOptions{
+		Logger: funcr.New(func(string, string) {}, funcr.Options{}),
+		Config: CollectionConfig{
+			EnabledCollectors: map[MetricType]bool{MetricTypeLoad: true, MetricTypeKernel: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	if err := m.RegisterPointCollector(newFakePointCollector(MetricTypeLoad, &LoadStats{}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	handler := &StatusHandler{
+		Manager: m,
+		Unavailable: map[MetricType]error{
+			MetricTypeKernel:         errors.New("failed to create collector: permission denied"),
+			MetricTypeLockContention: fmt.Errorf("%w: opt-in", ErrCollectorDisabled),
+		},
+		Security: Security{Capabilities: 1 << CapSysAdmin},
+		Findings: []SecurityFinding{{Collector: MetricTypeKernel, Capabilities: []string{"CAP_SYSLOG"}}},
+		BPF:      loader.NewManager(t.TempDir()),
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/performance/collectors", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var status CollectorsStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(status.Capabilities, []string{"CAP_SYS_ADMIN"}) {
+		t.Errorf("Capabilities = %v", status.Capabilities)
+	}
+	var got []string
+	for _, report := range status.Collectors {
+		got = append(got, fmt.Sprintf("%s %s %d", report.Collector, report.Status, len(report.Findings)))
+	}
+	want := []string{"kernel failed 1", "load active 0", "lock_contention disabled 0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Collectors = %q, want %q", got, want)
+	}
+	if len(status.Programs) != 0 {
+		t.Errorf("Programs = %v, want none without a BPF collector", status.Programs)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/performance/collectors", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StatefulCollector is a PointCollector that keeps counters between collections, e.g. to
+// compute rates. Its state is saved after every snapshot and restored when the agent
+// restarts, so the first collection after a restart can still compute rates and detect
+// counter resets.
+type StatefulCollector interface {
+	PointCollector
+
+	// SaveState returns the state kept from the last collection, nil if there is none
+	SaveState() ([]byte, error)
+	// RestoreState restores a state returned by SaveState before the first collection
+	RestoreState(data []byte) error
+}
+
+// StateStore persists the state of StatefulCollectors to a directory, one file per
+// collector. Kernel counters restart from zero when the host reboots, so a state is only
+// restored within the boot it was saved in.
+type StateStore struct {
+	dir    string
+	bootID string
+}
+
+// collectorState is the persisted form of a collector state
+type collectorState struct {
+	BootID  string          `json:"bootId"`
+	SavedAt time.Time       `json:"savedAt"`
+	State   json.RawMessage `json:"state"`
+}
+
+// NewStateStore creates a state store in dir for the current boot of the host whose /proc
+// is mounted at procPath
+func NewStateStore(dir, procPath string) (*StateStore, error) {
+	bootID, err := ReadBootID(procPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+	return &StateStore{dir: dir, bootID: bootID}, nil
+}
+
+// ReadBootID returns the random ID the kernel generates on every boot
+func ReadBootID(procPath string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, "sys", "kernel", "random", "boot_id"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read boot ID: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *StateStore) path(metricType MetricType) string {
+	return filepath.Join(s.dir, string(metricType)+".json")
+}
+
+// Load returns the state saved for the collector of metricType. It returns false if no
+// state was saved during the current boot.
+func (s *StateStore) Load(metricType MetricType) ([]byte, bool, error) {
+	data, err := os.ReadFile(s.path(metricType))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s state: %w", metricType, err)
+	}
+	var state collectorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, false, fmt.Errorf("failed to decode %s state: %w", metricType, err)
+	}
+	if state.BootID != s.bootID {
+		return nil, false, nil
+	}
+	return state.State, true, nil
+}
+
+// Save stores the state of the collector of metricType, replacing the previous one
+func (s *StateStore) Save(metricType MetricType, data []byte) error {
+	encoded, err := json.Marshal(collectorState{
+		BootID:  s.bootID,
+		SavedAt: time.Now(),
+		State:   data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s state: %w", metricType, err)
+	}
+
+	// Write to a temporary file first so that a crash never leaves a partial state behind
+	tmp, err := os.CreateTemp(s.dir, string(metricType)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save %s state: %w", metricType, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save %s state: %w", metricType, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save %s state: %w", metricType, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(metricType)); err != nil {
+		return fmt.Errorf("failed to save %s state: %w", metricType, err)
+	}
+	return nil
+}
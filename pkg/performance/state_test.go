@@ -0,0 +1,130 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+func writeBootID(t *testing.T, procPath, bootID string) {
+	t.Helper()
+	dir := filepath.Join(procPath, "sys", "kernel", "random")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "boot_id"), []byte(bootID+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	procPath := t.TempDir()
+	stateDir := filepath.Join(t.TempDir(), "state")
+	writeBootID(t, procPath, "boot-1")
+
+	store, err := NewStateStore(stateDir, procPath)
+	if err != nil {
+		t.Fatalf("failed to create state store: %v", err)
+	}
+	if _, ok, err := store.Load(MetricTypeDisk); ok || err != nil {
+		t.Fatalf("Load() of unsaved state = %v, %v, want false, nil", ok, err)
+	}
+	if err := store.Save(MetricTypeDisk, []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	// A new store in the same boot, e.g. after an agent restart
+	store, err = NewStateStore(stateDir, procPath)
+	if err != nil {
+		t.Fatalf("failed to create state store: %v", err)
+	}
+	data, ok, err := store.Load(MetricTypeDisk)
+	if err != nil || !ok || string(data) != `{"a":1}` {
+		t.Fatalf("Load() = %s, %v, %v, want saved state", data, ok, err)
+	}
+
+	// After a reboot the counters restarted, so the state is stale
+	writeBootID(t, procPath, "boot-2")
+	store, err = NewStateStore(stateDir, procPath)
+	if err != nil {
+		t.Fatalf("failed to create state store: %v", err)
+	}
+	if _, ok, err := store.Load(MetricTypeDisk); ok || err != nil {
+		t.Fatalf("Load() after reboot = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestNewStateStore_NoBootID(t *testing.T) {
+	if _, err := NewStateStore(t.TempDir(), t.TempDir()); err == nil {
+		t.Fatal("expected an error without a boot ID")
+	}
+}
+
+type fakeStatefulCollector struct {
+	fakePointCollector
+	state    []byte
+	restored []byte
+}
+
+func (f *fakeStatefulCollector) SaveState() ([]byte, error) {
+	return f.state, nil
+}
+
+func (f *fakeStatefulCollector) RestoreState(data []byte) error {
+	f.restored = data
+	return nil
+}
+
+func TestManager_CollectorState(t *testing.T) {
+	procPath := t.TempDir()
+	stateDir := t.TempDir()
+	writeBootID(t, procPath, "boot-1")
+
+	newManager := func() *Manager {
+		m, err := NewManager(ManagerOptions{
+			Logger:   funcr.New(func(string, string) {}, funcr.Options{}),
+			NodeName: "node-1",
+			Config: CollectionConfig{
+				HostProcPath:      procPath,
+				EnabledCollectors: map[MetricType]bool{MetricTypeDisk: true},
+			},
+			StateDir: stateDir,
+		})
+		if err != nil {
+			t.Fatalf("failed to create manager: %v", err)
+		}
+		return m
+	}
+
+	first := &fakeStatefulCollector{
+		fakePointCollector: *newFakePointCollector(MetricTypeDisk, []DiskStats{}, nil),
+		state:              []byte(`{"sectors":42}`),
+	}
+	m := newManager()
+	if err := m.RegisterPointCollector(first); err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+	if first.restored != nil {
+		t.Errorf("restored state %s without a saved state", first.restored)
+	}
+	m.CollectSnapshot(context.Background())
+
+	second := &fakeStatefulCollector{
+		fakePointCollector: *newFakePointCollector(MetricTypeDisk, []DiskStats{}, nil),
+	}
+	if err := newManager().RegisterPointCollector(second); err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+	if string(second.restored) != `{"sectors":42}` {
+		t.Errorf("restored state = %s, want the state saved by the previous manager", second.restored)
+	}
+}
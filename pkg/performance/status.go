@@ -0,0 +1,114 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package performance
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/antimetal/agent/pkg/ebpf/loader"
+)
+
+// CollectorsStatus is the status of the collectors of a Manager and what the kernel lets
+// them do
+type CollectorsStatus struct {
+	// Capabilities are the effective capabilities of the agent
+	Capabilities []string `json:"capabilities"`
+	// Seccomp is the seccomp mode of the agent, one of the Seccomp constants
+	Seccomp    int               `json:"seccomp"`
+	Collectors []CollectorReport `json:"collectors"`
+	// Programs are the BPF programs attached by the collectors
+	Programs []loader.ProgramHealth `json:"programs,omitempty"`
+}
+
+// CollectorReport is the status of a single collector
+type CollectorReport struct {
+	Collector MetricType      `json:"collector"`
+	Status    CollectorStatus `json:"status"`
+	// Error is why the collector isn't running, empty if it is
+	Error string `json:"error,omitempty"`
+	// LastSuccess is when the collector last started a collection that succeeded
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	// Findings are what the collector needs but the agent isn't allowed
+	Findings []SecurityFinding `json:"findings,omitempty"`
+}
+
+// StatusHandler serves the status of the collectors of Manager
+type StatusHandler struct {
+	Manager *Manager
+	// Unavailable are the enabled collectors that couldn't be registered and why. Errors
+	// wrapping ErrCollectorDisabled are reported as disabled, the others as failed.
+	Unavailable map[MetricType]error
+	Security    Security
+	// Findings are the results of CheckSecurity for the enabled collectors
+	Findings []SecurityFinding
+	// BPF loads the programs of the eBPF collectors, e.g. loader.Shared(HostSysPath).
+	// Optional.
+	BPF *loader.Manager
+}
+
+// Status returns the status of the registered and unavailable collectors, sorted by
+// collector
+func (h *StatusHandler) Status() CollectorsStatus {
+	lastSuccess := h.Manager.LastSuccessfulCollections()
+	findings := make(map[MetricType][]SecurityFinding)
+	for _, finding := range h.Findings {
+		findings[finding.Collector] = append(findings[finding.Collector], finding)
+	}
+
+	reports := []CollectorReport{}
+	for _, collector := range h.Manager.GetRegistry().GetEnabledPoint(h.Manager.GetConfig()) {
+		metricType := collector.Type()
+		reports = append(reports, CollectorReport{
+			Collector:   metricType,
+			Status:      CollectorStatusActive,
+			LastSuccess: lastSuccess[metricType],
+			Findings:    findings[metricType],
+		})
+	}
+	for metricType, err := range h.Unavailable {
+		status := CollectorStatusFailed
+		if errors.Is(err, ErrCollectorDisabled) {
+			status = CollectorStatusDisabled
+		}
+		reports = append(reports, CollectorReport{
+			Collector: metricType,
+			Status:    status,
+			Error:     err.Error(),
+			Findings:  findings[metricType],
+		})
+	}
+	slices.SortFunc(reports, func(a, b CollectorReport) int {
+		return cmp.Compare(a.Collector, b.Collector)
+	})
+	status := CollectorsStatus{
+		Capabilities: h.Security.Capabilities.Names(),
+		Seccomp:      h.Security.Seccomp,
+		Collectors:   reports,
+	}
+	if h.BPF != nil {
+		status.Programs = h.BPF.Health()
+	}
+	return status
+}
+
+// ServeHTTP returns the status of the collectors as JSON
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.Status()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
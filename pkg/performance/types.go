@@ -8,20 +8,47 @@ package performance
 
 import (
 	"time"
+
+	"github.com/antimetal/agent/pkg/performance/argpolicy"
 )
 
 // MetricType represents the type of performance metric
 type MetricType string
 
 const (
-	MetricTypeLoad    MetricType = "load"
-	MetricTypeMemory  MetricType = "memory"
-	MetricTypeCPU     MetricType = "cpu"
-	MetricTypeProcess MetricType = "process"
-	MetricTypeDisk    MetricType = "disk"
-	MetricTypeNetwork MetricType = "network"
-	MetricTypeTCP     MetricType = "tcp"
-	MetricTypeKernel  MetricType = "kernel"
+	MetricTypeLoad           MetricType = "load"
+	MetricTypeMemory         MetricType = "memory"
+	MetricTypeCPU            MetricType = "cpu"
+	MetricTypeProcess        MetricType = "process"
+	MetricTypeDisk           MetricType = "disk"
+	MetricTypeNetwork        MetricType = "network"
+	MetricTypeTCP            MetricType = "tcp"
+	MetricTypeKernel         MetricType = "kernel"
+	MetricTypePower          MetricType = "power"
+	MetricTypeProcessState   MetricType = "process_state"
+	MetricTypeSwap           MetricType = "swap"
+	MetricTypeCertificate    MetricType = "certificate"
+	MetricTypeCostHints      MetricType = "cost_hints"
+	MetricTypeKernelTaint    MetricType = "kernel_taint"
+	MetricTypeNFS            MetricType = "nfs"
+	MetricTypeNeighbor       MetricType = "neighbor"
+	MetricTypeIPVS           MetricType = "ipvs"
+	MetricTypeBoot           MetricType = "boot"
+	MetricTypeCPUPerf        MetricType = "cpu_perf"
+	MetricTypeSlab           MetricType = "slab"
+	MetricTypeCgroupCPU      MetricType = "cgroup_cpu"
+	MetricTypeTCPSockets     MetricType = "tcp_sockets"
+	MetricTypeMemoryPressure MetricType = "memory_pressure"
+	// Optional, needs Intel RDT or AMD QoS and the resctrl filesystem
+	MetricTypeMemoryBandwidth MetricType = "memory_bandwidth"
+	// Optional, needs eBPF
+	MetricTypeLockContention MetricType = "lock_contention"
+	// Event streams of continuous collectors
+	MetricTypeFileOpen    MetricType = "file_open"
+	MetricTypeProcessExec MetricType = "process_exec"
+	// Hardware/configuration information
+	MetricTypeNetworkInfo MetricType = "network_info"
+	MetricTypeTopology    MetricType = "topology"
 )
 
 // CollectorStatus represents the operational status of a collector
@@ -32,13 +59,17 @@ const (
 	CollectorStatusDegraded CollectorStatus = "degraded"
 	CollectorStatusFailed   CollectorStatus = "failed"
 	CollectorStatusDisabled CollectorStatus = "disabled"
+	// The collector can't run on this host's architecture or kernel
+	CollectorStatusUnsupported CollectorStatus = "unsupported"
 )
 
 // Snapshot represents a complete performance snapshot at a point in time
 type Snapshot struct {
-	Timestamp    time.Time
-	NodeName     string
-	ClusterName  string
+	Timestamp   time.Time
+	NodeName    string
+	ClusterName string
+	// Tags from node-local metadata, e.g. the node's datacenter or team
+	Tags         map[string]string
 	CollectorRun CollectorRunInfo
 	Metrics      Metrics
 }
@@ -59,22 +90,109 @@ type CollectorStat struct {
 
 // Metrics contains all collected performance metrics
 type Metrics struct {
-	Load      *LoadStats
-	Memory    *MemoryStats
-	CPU       []CPUStats
-	Processes []ProcessStats
-	Disks     []DiskStats
-	Network   []NetworkStats
-	TCP       *TCPStats
-	Kernel    []KernelMessage
+	Load            *LoadStats
+	Memory          *MemoryStats
+	CPU             []CPUStats
+	Processes       []ProcessStats
+	Disks           []DiskStats
+	Network         []NetworkStats
+	TCP             *TCPStats
+	Kernel          []KernelMessage
+	Power           *PowerStats
+	ProcessStates   *ProcessStateStats
+	Swap            *SwapStats
+	Certificates    *CertificateStats
+	CostHints       *CostHints
+	KernelTaint     *KernelTaintStats
+	NFS             *NFSStats
+	Neighbors       *NeighborStats
+	IPVS            *IPVSStats
+	Boot            *BootStats
+	CPUPerf         *CPUPerfStats
+	Slab            *SlabStats
+	CgroupCPU       *CgroupCPUStats
+	TCPSockets      *TCPSocketStats
+	MemoryPressure  *MemoryPressureStats
+	MemoryBandwidth *MemoryBandwidthStats
+	LockContention  *LockContentionStats
+	// Hardware/configuration information
+	NetworkInfo *NetworkInfo
+	Topology    *TopologyStats
+	// Sub-second CPU and process samples of the last burst, if one ended since the
+	// previous snapshot
+	Burst *Burst
+}
+
+// set stores data in the Metrics field matching its type.
+// Returns false if data isn't a known metric type.
+func (m *Metrics) set(data any) bool {
+	switch v := data.(type) {
+	case *LoadStats:
+		m.Load = v
+	case *MemoryStats:
+		m.Memory = v
+	case []CPUStats:
+		m.CPU = v
+	case []ProcessStats:
+		m.Processes = v
+	case []DiskStats:
+		m.Disks = v
+	case []NetworkStats:
+		m.Network = v
+	case *TCPStats:
+		m.TCP = v
+	case []KernelMessage:
+		m.Kernel = v
+	case *PowerStats:
+		m.Power = v
+	case *ProcessStateStats:
+		m.ProcessStates = v
+	case *SwapStats:
+		m.Swap = v
+	case *CertificateStats:
+		m.Certificates = v
+	case *CostHints:
+		m.CostHints = v
+	case *KernelTaintStats:
+		m.KernelTaint = v
+	case *NFSStats:
+		m.NFS = v
+	case *NeighborStats:
+		m.Neighbors = v
+	case *IPVSStats:
+		m.IPVS = v
+	case *BootStats:
+		m.Boot = v
+	case *CPUPerfStats:
+		m.CPUPerf = v
+	case *SlabStats:
+		m.Slab = v
+	case *CgroupCPUStats:
+		m.CgroupCPU = v
+	case *TCPSocketStats:
+		m.TCPSockets = v
+	case *MemoryPressureStats:
+		m.MemoryPressure = v
+	case *MemoryBandwidthStats:
+		m.MemoryBandwidth = v
+	case *LockContentionStats:
+		m.LockContention = v
+	case *NetworkInfo:
+		m.NetworkInfo = v
+	case *TopologyStats:
+		m.Topology = v
+	default:
+		return false
+	}
+	return true
 }
 
 // LoadStats represents system load information
 type LoadStats struct {
 	// Load averages from /proc/loadavg (1st, 2nd, 3rd fields)
-	Load1Min  float64
-	Load5Min  float64
-	Load15Min float64
+	Load1Min  float64 `schema:"minimum=0"`
+	Load5Min  float64 `schema:"minimum=0"`
+	Load15Min float64 `schema:"minimum=0"`
 	// Running/total processes from /proc/loadavg (4th field, e.g., "2/1234")
 	RunningProcs int32
 	TotalProcs   int32
@@ -125,6 +243,263 @@ type MemoryStats struct {
 	HugePagesize    uint64 // Hugepagesize: Default hugepage size (in kB)
 }
 
+// SlabStats represents the kernel memory of the slab allocator and the other kernel
+// allocations, in more detail than the Slab total of MemoryStats. All sizes are in bytes.
+type SlabStats struct {
+	// Largest slab caches by memory from /proc/slabinfo, largest first
+	Caches []SlabCache
+	// Totals across all caches from /proc/slabinfo
+	TotalCaches     int
+	TotalSize       uint64 // Memory of the slabs of all caches
+	TotalActiveSize uint64 // Memory of the objects in use
+	// Kernel allocation counters from /proc/vmstat
+	Reclaimable     uint64 // nr_slab_reclaimable: slabs the kernel can free under pressure, e.g. dentries
+	Unreclaimable   uint64 // nr_slab_unreclaimable
+	MiscReclaimable uint64 // nr_kernel_misc_reclaimable: reclaimable non-slab kernel allocations
+	KernelStack     uint64 // nr_kernel_stack
+	PageTables      uint64 // nr_page_table_pages
+}
+
+// SlabCache represents a slab cache from /proc/slabinfo
+type SlabCache struct {
+	Name          string // e.g. dentry, nf_conntrack, kmalloc-256
+	ActiveObjects uint64 // Objects in use
+	Objects       uint64 // Allocated objects
+	ObjectSize    uint64
+	Size          uint64 // Memory of the slabs of the cache
+	ActiveSize    uint64 // Memory of the objects in use
+	// Growth of Size since the previous collection in bytes per second, negative when
+	// the cache shrank. A cache that keeps growing is a kernel memory leak candidate.
+	Growth float64
+}
+
+// CgroupCPUStats represents the CFS bandwidth throttling of the cgroups of the host since
+// the previous collection. A cgroup that uses its CPU quota before the end of a period
+// can't run until the next one, however idle the host is, which makes a throttled
+// container slow on an idle node.
+type CgroupCPUStats struct {
+	// Period covered by the stats. The first collection covers nothing.
+	Interval time.Duration `schema:"minimum=0"`
+	// Number of cgroups with a CPU quota, i.e. whose cpu.stat counts periods
+	LimitedCgroups int `schema:"minimum=0"`
+	// Number of cgroups throttled during the interval
+	ThrottledCgroups int `schema:"minimum=0"`
+	// Cgroups throttled the longest during the interval, longest first
+	Throttled []CgroupThrottling
+}
+
+// CgroupThrottling represents the throttling of a cgroup during an interval
+type CgroupThrottling struct {
+	// Path of the cgroup relative to the root of the hierarchy, e.g.
+	// /kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/cri-containerd-<id>.scope
+	Path string
+	// UID of the pod and ID of the container of the cgroup, empty if it isn't one
+	PodUID      string
+	ContainerID string
+	// CPU quota in CPUs, e.g. 0.5 for 50ms per 100ms period
+	QuotaCPUs float64 `schema:"minimum=0"`
+	// CPUs the cgroup may run on from its cpuset, empty if the cpuset controller isn't
+	// enabled for it. A quota above their number can't be used.
+	CPUs []int `schema:"minimum=0"`
+	// CPU used during the interval in CPUs
+	UsageCPUs float64 `schema:"minimum=0"`
+	// Enforcement periods that elapsed while the cgroup was runnable, and those at
+	// whose end it was throttled
+	Periods          uint64
+	ThrottledPeriods uint64
+	// Percentage of Periods that were throttled
+	ThrottledPercent float64 `schema:"minimum=0,maximum=100"`
+	// Time the threads of the cgroup couldn't run because it was throttled, summed
+	// over CPUs on cgroup v1
+	ThrottledTime time.Duration `schema:"minimum=0"`
+}
+
+// TCPSocketStats represents the TCP sockets of the agent's network namespace, read from
+// the kernel with sock_diag, and the sockets sampled among them. Sockets are sampled by
+// their cookie, which identifies a socket for its whole life unlike its addresses, so the
+// same sockets are sampled at every collection and their throughput is tracked across
+// collections.
+type TCPSocketStats struct {
+	// Period covered by the throughputs. The first collection covers nothing.
+	Interval time.Duration `schema:"minimum=0"`
+	// Number of sockets matching the state and port filters, in total and by state, e.g.
+	// ESTABLISHED or TIME_WAIT
+	Sockets uint64
+	ByState map[string]uint64
+	// 1 in SampleRate of the matching sockets is sampled
+	SampleRate uint64 `schema:"minimum=1"`
+	Sampled    uint64
+	// Sampled sockets that appeared and disappeared since the previous collection. A
+	// socket leaving the matching states disappears.
+	Opened uint64
+	Closed uint64
+	// Mean smoothed round trip time of the sampled sockets reporting one
+	MeanRTT time.Duration `schema:"minimum=0"`
+	// Sampled sockets with the longest round trip time, longest first
+	Slowest []TCPSocket
+}
+
+// TCPSocket represents a TCP socket reported by sock_diag
+type TCPSocket struct {
+	// Cookie identifies the socket until the host reboots
+	Cookie uint64
+	// Local and remote addresses, host:port
+	Local  string
+	Remote string
+	State  string
+	UID    uint32
+	Inode  uint32
+	// Bytes in the receive and send queues. For a listening socket, the connections
+	// waiting to be accepted and the maximum backlog.
+	RecvQueue uint32
+	SendQueue uint32
+	// From the tcp_info of the socket: smoothed round trip time and its variance,
+	// congestion window in segments and retransmitted segments since it was opened
+	RTT          time.Duration `schema:"minimum=0"`
+	RTTVar       time.Duration `schema:"minimum=0"`
+	SendCwnd     uint32
+	TotalRetrans uint32
+	// Bytes acknowledged by the peer and received since the socket was opened, and
+	// their rates during the interval
+	BytesAcked      uint64
+	BytesReceived   uint64
+	SendBytesPerSec float64 `schema:"minimum=0"`
+	RecvBytesPerSec float64 `schema:"minimum=0"`
+}
+
+// MemoryPressureStats represents dirty page cache building up against the dirty
+// thresholds and the page reclaim of the host, which stall processes waiting on memory or
+// IO while they don't look busy
+type MemoryPressureStats struct {
+	// Period covered by the rates. The first collection covers nothing.
+	Interval time.Duration `schema:"minimum=0"`
+	// Page cache waiting to be written back and being written back (Dirty and Writeback in
+	// /proc/meminfo)
+	DirtyBytes     uint64
+	WritebackBytes uint64
+	// Dirty page cache above which the kernel starts writing back in the background, and
+	// above which processes writing to the page cache are throttled (vm.dirty_background_*
+	// and vm.dirty_*)
+	DirtyBackgroundThresholdBytes uint64
+	DirtyThresholdBytes           uint64
+	// DirtyBytes and WritebackBytes as a percentage of DirtyThresholdBytes. Writers are
+	// throttled as it approaches 100.
+	DirtyPercent float64 `schema:"minimum=0"`
+	// Cumulative pages scanned and reclaimed by kswapd and by allocating processes in
+	// direct reclaim, and allocations that stalled in direct reclaim, since boot
+	// (pgscan_kswapd, pgsteal_kswapd, pgscan_direct, pgsteal_direct and allocstall in
+	// /proc/vmstat)
+	KswapdScanned   uint64
+	KswapdReclaimed uint64
+	DirectScanned   uint64
+	DirectReclaimed uint64
+	AllocStalls     uint64
+	// The same per second during Interval
+	KswapdScanRate    float64 `schema:"minimum=0"`
+	KswapdReclaimRate float64 `schema:"minimum=0"`
+	DirectScanRate    float64 `schema:"minimum=0"`
+	DirectReclaimRate float64 `schema:"minimum=0"`
+	AllocStallRate    float64 `schema:"minimum=0"`
+	// Pressure stall information of memory and IO, nil if the kernel has none
+	Memory *PressureStats
+	IO     *PressureStats
+}
+
+// PressureStats represents the time tasks stalled waiting on a resource, from
+// /proc/pressure
+type PressureStats struct {
+	// Some task stalled
+	Some PressureStall
+	// All non-idle tasks stalled at once, so the time was lost
+	Full PressureStall
+}
+
+// PressureStall is the share of time tasks stalled over the last 10s, 60s and 300s, in
+// percent, and the total time they stalled since boot
+type PressureStall struct {
+	Avg10  float64       `schema:"minimum=0,maximum=100"`
+	Avg60  float64       `schema:"minimum=0,maximum=100"`
+	Avg300 float64       `schema:"minimum=0,maximum=100"`
+	Total  time.Duration `schema:"minimum=0"`
+}
+
+// LockContentionStats represents how long the processes of the host waited on futexes and
+// contended kernel locks since the previous collection, traced with eBPF. A process that
+// is slow while its CPU usage is low often spends its time there.
+//
+// Futex waits include those of idle threads waiting for work on a condition variable, so
+// they are best compared with the same process under normal load. Waits are counted when
+// they end, and a process whose threads wait on many different futexes or locks at once
+// can lose a few concurrent waits.
+type LockContentionStats struct {
+	// Period covered by the stats. The first collection starts tracing and covers nothing.
+	Interval time.Duration `schema:"minimum=0"`
+	// KernelLocks is whether contended kernel locks are traced, which needs the
+	// lock/contention_begin tracepoint of Linux 5.19
+	KernelLocks bool
+	// Processes that waited the longest, futex and lock waits combined, longest first
+	Processes []ProcessLockContention
+	// Number of processes that waited but aren't in Processes
+	OtherProcesses int `schema:"minimum=0"`
+}
+
+// ProcessLockContention represents the waits of the threads of a process
+type ProcessLockContention struct {
+	PID     int32
+	Command string
+	// Blocking futex calls, e.g. of contended pthread mutexes and condition variables
+	FutexWaits    uint64
+	FutexWaitTime time.Duration `schema:"minimum=0"` // Summed over threads
+	FutexMaxWait  time.Duration `schema:"minimum=0"`
+	// Contended kernel locks: mutexes, rwsems, spinlocks and rwlocks
+	LockContentions uint64
+	LockWaitTime    time.Duration `schema:"minimum=0"` // Summed over threads
+	LockMaxWait     time.Duration `schema:"minimum=0"`
+}
+
+// MemoryBandwidthStats represents the memory bandwidth and last level cache occupancy
+// measured by the cache monitoring (CMT) and memory bandwidth monitoring (MBM) of Intel RDT
+// or AMD QoS, read from the resctrl filesystem. The hardware counts per L3 cache domain,
+// so the usage of a core is that of the domain in Domains listing it in CPUs.
+type MemoryBandwidthStats struct {
+	// Whether resctrl is mounted with L3 monitoring. Without it the other fields are
+	// empty.
+	Supported bool
+	// Monitoring events of info/L3_MON/mon_features, e.g. llc_occupancy, mbm_total_bytes
+	// and mbm_local_bytes
+	Features []string
+	// Usage of the whole host per L3 cache domain, sorted by ID
+	Domains []MemoryBandwidthDomain
+	// Usage of the resource and monitoring groups other than the default group, e.g.
+	// those a container runtime creates for the RDT class of pods, sorted by name
+	Groups []MemoryBandwidthGroup
+}
+
+// MemoryBandwidthDomain represents the usage of an L3 cache domain, usually a socket or
+// a core complex. Counters of events the hardware doesn't support are 0.
+type MemoryBandwidthDomain struct {
+	ID   int   // L3 cache ID, from mon_data/mon_L3_<id>
+	CPUs []int // CPUs sharing the L3 cache, only set in MemoryBandwidthStats.Domains
+	// llc_occupancy: bytes of the L3 cache in use
+	LLCOccupancy uint64
+	// mbm_total_bytes and mbm_local_bytes: cumulative bytes transferred to and from
+	// memory, all NUMA nodes and the local node respectively
+	TotalBytes uint64
+	LocalBytes uint64
+	// Bandwidth since the previous collection in bytes per second, 0 on the first
+	// collection
+	TotalBandwidth float64 `schema:"minimum=0"`
+	LocalBandwidth float64 `schema:"minimum=0"`
+}
+
+// MemoryBandwidthGroup represents the usage of a resctrl resource or monitoring group
+type MemoryBandwidthGroup struct {
+	// Path of the group relative to the resctrl mount, e.g. "guaranteed" or
+	// "guaranteed/mon_groups/pod1"
+	Name    string
+	Domains []MemoryBandwidthDomain
+}
+
 // CPUStats represents per-CPU statistics from /proc/stat
 type CPUStats struct {
 	// CPU index (-1 for aggregate "cpu" line, 0+ for "cpu0", "cpu1", etc.)
@@ -141,7 +516,10 @@ type CPUStats struct {
 	Guest     uint64 // Time spent running a virtual CPU for guest OS
 	GuestNice uint64 // Time spent running a niced guest
 	// Calculated fields
-	Utilization float64 // Percentage 0-100
+	Utilization float64 `schema:"minimum=0,maximum=100"` // Percentage 0-100
+	// Percentage 0-100 of the time the hypervisor ran something else while the CPU had
+	// work, the primary indicator of noisy neighbors on shared cloud instances
+	StealPercent float64 `schema:"minimum=0,maximum=100"`
 	// Delta values for rate calculation
 	DeltaTotal uint64
 }
@@ -157,7 +535,7 @@ type ProcessStats struct {
 	State   string // Process state (field 3 in stat: R, S, D, Z, T, etc.)
 	// CPU stats from /proc/[pid]/stat
 	CPUTime    uint64  // Total CPU time: utime + stime (fields 14+15)
-	CPUPercent float64 // Calculated CPU usage percentage
+	CPUPercent float64 `schema:"minimum=0"` // Calculated CPU usage percentage
 	// Memory stats
 	MemoryVSZ uint64 // Virtual memory size from /proc/[pid]/stat (field 23)
 	MemoryRSS uint64 // Resident set size from /proc/[pid]/stat (field 24) * page_size
@@ -181,6 +559,362 @@ type ProcessStats struct {
 	InvoluntaryCtxt uint64 // nonvoluntary_ctxt_switches
 }
 
+// ProcessStateStats summarizes process states from /proc/[pid]/stat and lists the
+// processes in states that indicate a problem.
+type ProcessStateStats struct {
+	// Number of processes in each state (field 3 in stat)
+	Total    uint64
+	Running  uint64 // R
+	Sleeping uint64 // S: interruptible sleep
+	Blocked  uint64 // D: uninterruptible sleep, usually waiting on I/O
+	Zombie   uint64 // Z: exited but not yet reaped by the parent
+	Stopped  uint64 // T and t: stopped by a signal or being traced
+	Idle     uint64 // I: idle kernel threads
+	// Blocked processes that have been in uninterruptible sleep for at least
+	// BlockedThreshold across consecutive collections
+	LongBlocked      uint64
+	BlockedThreshold time.Duration
+	// Zombie and blocked processes
+	Zombies      []StuckProcess
+	BlockedProcs []StuckProcess
+}
+
+// StuckProcess is a process in zombie or uninterruptible sleep state
+type StuckProcess struct {
+	PID     int32
+	PPID    int32
+	Command string
+	State   string
+	// Kernel function the process is waiting in, from /proc/[pid]/wchan.
+	// Empty if unavailable (e.g. restricted by kernel.kptr_restrict).
+	WaitChannel string
+	// How long the process has been observed in its current state across collections.
+	// Zero the first time it is seen.
+	Duration time.Duration
+}
+
+// SwapStats describes swap devices and swap activity, including compressed swap in RAM
+// provided by zram and zswap.
+type SwapStats struct {
+	// Active swap areas from /proc/swaps
+	Devices []SwapDevice
+	// Cumulative pages swapped in/out since boot (pswpin/pswpout in /proc/vmstat)
+	PagesSwappedIn  uint64
+	PagesSwappedOut uint64
+	// Pages swapped in/out per second since the previous collection.
+	// Zero on the first collection.
+	SwapInRate  float64 `schema:"minimum=0"`
+	SwapOutRate float64 `schema:"minimum=0"`
+	// zram block devices from /sys/block/zram*
+	Zram []ZramDevice
+	// zswap frontswap cache, nil if the kernel has no zswap support
+	Zswap *ZswapStats
+}
+
+// SwapDevice is an active swap area from /proc/swaps
+type SwapDevice struct {
+	Filename string // Device or file path
+	Type     string // partition or file
+	Size     uint64 // Size in kB
+	Used     uint64 // Used in kB
+	Priority int32  // Higher priority areas are used first
+}
+
+// ZramDevice represents a compressed RAM block device from /sys/block/zram*
+type ZramDevice struct {
+	Name          string
+	CompAlgorithm string // Active compression algorithm from comp_algorithm
+	DiskSize      uint64 // Uncompressed capacity in bytes from disksize
+	// Fields of mm_stat, all in bytes except the page counters
+	OrigDataSize   uint64 // Uncompressed size of stored data
+	ComprDataSize  uint64 // Compressed size of stored data
+	MemUsedTotal   uint64 // Memory allocated for the device including overhead
+	MemLimit       uint64 // Maximum memory the device may use, 0 if unlimited
+	MemUsedMax     uint64 // Peak memory used
+	SamePages      uint64 // Pages filled with the same value, stored without memory
+	PagesCompacted uint64 // Pages freed by compaction
+}
+
+// ZswapStats describes the zswap compressed swap cache
+type ZswapStats struct {
+	// Module parameters from /sys/module/zswap/parameters
+	Enabled        bool
+	Compressor     string
+	MaxPoolPercent uint64
+	// Pool usage from /proc/meminfo (kernel 5.19+), in kB
+	PoolSize   uint64 // Zswap: memory used by the compressed pool
+	StoredSize uint64 // Zswapped: uncompressed size of the pages in the pool
+	// Cumulative pages loaded from and stored to zswap from /proc/vmstat (kernel 6.4+)
+	PagesSwappedIn  uint64 // zswpin
+	PagesSwappedOut uint64 // zswpout
+}
+
+// CertificateStats reports the expiry of the x509 certificates found in the configured
+// files and TLS endpoints
+type CertificateStats struct {
+	// Certificates ordered by expiry, soonest first
+	Certificates []CertificateInfo
+	// Files or endpoints that exist but couldn't be read or parsed, keyed by source
+	Errors map[string]string
+}
+
+// CertificateInfo describes a single x509 certificate
+type CertificateInfo struct {
+	Source       string // File path or endpoint the certificate was read from
+	Subject      string
+	Issuer       string
+	SerialNumber string
+	DNSNames     []string
+	IsCA         bool
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// Whole days left until NotAfter, negative once expired
+	DaysRemaining int
+	Expired       bool
+}
+
+// CostHints combines the node's instance details with its utilization into idle capacity
+// estimates, so cost can be attributed to nodes without joining metrics on the backend.
+type CostHints struct {
+	// Instance details from the cloud provider's instance metadata service.
+	// Empty if the node doesn't run on a supported provider.
+	Provider         string // e.g. aws
+	InstanceType     string // e.g. m5.xlarge
+	Lifecycle        string // on-demand or spot
+	Region           string
+	AvailabilityZone string
+	// Node capacity
+	CPUCores    int    // Online CPUs from /proc/stat
+	MemoryBytes uint64 // MemTotal from /proc/meminfo
+	// Utilization between 0 and 1 over UtilizationWindow, which is the time since the
+	// previous collection or the uptime on the first collection
+	CPUUtilization    float64 `schema:"minimum=0,maximum=1"`
+	MemoryUtilization float64 `schema:"minimum=0,maximum=1"` // 1 - MemAvailable/MemTotal
+	UtilizationWindow time.Duration
+	// Idle capacity estimates
+	IdleCPUCores    float64 `schema:"minimum=0"`
+	IdleMemoryBytes uint64  // MemAvailable
+}
+
+// KernelTaintStats reports whether the kernel is tainted and whether it crashed before
+type KernelTaintStats struct {
+	// Raw bitmask from /proc/sys/kernel/tainted, 0 if the kernel is not tainted
+	Tainted uint64
+	// Decoded taint flags, ordered by bit
+	Taints []KernelTaint
+	// Crash records left by previous panics or oopses, ordered by modification time,
+	// most recent first
+	CrashRecords []CrashRecord
+}
+
+// KernelTaint is a single taint flag set in /proc/sys/kernel/tainted
+type KernelTaint struct {
+	Bit         uint   // Bit number in the taint mask
+	Flag        string // Letter shown in oops reports, e.g. P or D. Empty for unknown bits
+	Description string
+}
+
+// CrashRecord is a record of a previous kernel crash
+type CrashRecord struct {
+	Source  string // pstore or crash_dir
+	Path    string
+	Type    string // pstore record type (e.g. dmesg, console) or empty for crash dumps
+	Size    int64  // Size in bytes, the total size of the directory for kdump directories
+	ModTime time.Time
+}
+
+// NFSStats represents the per-mount RPC statistics of NFS mounts from mountstats
+type NFSStats struct {
+	Mounts []NFSMountStats
+}
+
+// NFSMountStats represents a single NFS mount
+type NFSMountStats struct {
+	Device     string // server:/export
+	MountPoint string
+	FSType     string // nfs or nfs4
+	Version    string // NFS protocol version from the vers mount option
+	Age        time.Duration
+	// Bytes read from and written to the server since the mount
+	ServerReadBytes  uint64
+	ServerWriteBytes uint64
+	// Window the operation averages are computed over: the time since the previous
+	// collection, or the age of the mount on the first collection
+	Window     time.Duration
+	Operations []NFSOperationStats // Operations called at least once, in kernel order
+}
+
+// NFSOperationStats represents the RPC statistics of one NFS operation on a mount.
+// Counters are cumulative since the mount, averages cover NFSMountStats.Window.
+type NFSOperationStats struct {
+	Operation     string // e.g. READ, WRITE, GETATTR
+	Ops           uint64
+	Transmissions uint64 // Ops plus retransmissions
+	MajorTimeouts uint64
+	BytesSent     uint64
+	BytesReceived uint64
+	QueueTime     time.Duration // Time requests waited to be transmitted
+	RTT           time.Duration // Time from transmission to the server's reply
+	ExecuteTime   time.Duration // Time from queueing to completion
+	Errors        uint64        // Only reported by kernels 5.3 and later
+	// Averages per operation over the window. Zero if there were no operations.
+	OpsPerSecond   float64 `schema:"minimum=0"`
+	AvgQueueTime   time.Duration
+	AvgRTT         time.Duration
+	AvgExecuteTime time.Duration
+}
+
+// FileOpenEvent is a single openat(2) call traced by the file open collector
+type FileOpenEvent struct {
+	Timestamp time.Time
+	PID       uint32
+	TID       uint32
+	UID       uint32
+	Command   string // Task name of the calling thread
+	Path      string // Path as passed to openat, possibly relative and truncated
+	Flags     int32  // O_* flags
+	Mode      uint32 // Mode of created files
+	FD        int32  // File descriptor, -1 if the call failed
+	Errno     int32  // Error number if the call failed, e.g. 2 (ENOENT)
+}
+
+// ProcessExecEvent is a single execve(2) call traced by the exec collector
+type ProcessExecEvent struct {
+	Timestamp time.Time
+	PID       uint32
+	PPID      uint32
+	UID       uint32
+	Command   string   // Task name after the exec
+	Filename  string   // Path of the executed file as passed to execve
+	Args      []string // argv[1:]
+	// ArgsTruncated is set when arguments were left out or cut short by the capture limits
+	ArgsTruncated bool
+	// ArgsAction is what the argument policy did with Args, e.g. "hash"
+	ArgsAction argpolicy.Action
+	Errno      int32 // Error number if the call failed, e.g. 2 (ENOENT)
+}
+
+// NeighborStats represents the IPv4 neighbor (ARP) table and the reachability of the
+// default gateways
+type NeighborStats struct {
+	// Entry counts of the whole table by state
+	Entries    int
+	Complete   int // Resolved entries
+	Permanent  int // Static entries
+	Unresolved int // Entries being resolved (INCOMPLETE) or that failed to resolve (FAILED)
+	// Entry counts per interface, keyed by interface name
+	Interfaces map[string]NeighborInterfaceStats
+	// Gateways of the default routes ordered by route metric
+	Gateways []GatewayReachability
+}
+
+// NeighborInterfaceStats represents the ARP entries of a single interface
+type NeighborInterfaceStats struct {
+	Entries    int
+	Complete   int
+	Permanent  int
+	Unresolved int
+}
+
+// GatewayReachability reports whether a default gateway is reachable at the link layer
+type GatewayReachability struct {
+	Interface       string
+	Address         string
+	HardwareAddress string // MAC address of the gateway, empty unless it is reachable
+	// Whether the gateway wasn't resolved and had to be probed
+	Probed bool
+	// Whether the gateway has a resolved ARP entry, after probing it if needed
+	Reachable bool
+}
+
+// BootStats represents how long the node took from boot until it could run pods
+type BootStats struct {
+	BootTime time.Time // From btime in /proc/stat
+	Uptime   time.Duration
+	// Time from boot until the kernel started the first userspace process, the init of
+	// the initrd or of the system. Zero if the kernel log no longer holds the boot
+	// messages or can't be read.
+	Kernel time.Duration
+	// Time from boot until the kubelet and the container runtime had both started. Zero
+	// if the kubelet isn't running.
+	TimeToReady time.Duration
+	// Node services that are running, ordered by start
+	Services []BootService
+}
+
+// BootService represents a running node service, like the kubelet or the container runtime
+type BootService struct {
+	Name string // Task name, e.g. kubelet
+	PID  int32
+	// Time from boot until the running instance started. Services restarted since boot
+	// report their last start.
+	Started time.Duration
+}
+
+// CPUPerfStats represents the hardware performance counters of the CPUs since the
+// previous collection
+type CPUPerfStats struct {
+	Interval time.Duration // Time the counts cover
+	// Totals across CPUs
+	Cycles          uint64
+	Instructions    uint64
+	CacheReferences uint64  // Usually last level cache references
+	CacheMisses     uint64  // Usually last level cache misses
+	IPC             float64 `schema:"minimum=0"`             // Instructions per cycle
+	CacheMissRate   float64 `schema:"minimum=0,maximum=100"` // Percentage of cache references that missed
+	CPUs            []CPUPerfCounters
+}
+
+// CPUPerfCounters represents the hardware performance counters of a single CPU
+type CPUPerfCounters struct {
+	CPU             int32
+	Cycles          uint64
+	Instructions    uint64
+	CacheReferences uint64
+	CacheMisses     uint64
+	IPC             float64 `schema:"minimum=0"`
+	CacheMissRate   float64 `schema:"minimum=0,maximum=100"`
+	// Percentage of the interval the counters were counting. Below 100 they were
+	// multiplexed with other perf events and the counts are scaled estimates.
+	Running float64 `schema:"minimum=0,maximum=100"`
+}
+
+// IPVSStats represents the IPVS virtual services that kube-proxy programs in ipvs mode
+type IPVSStats struct {
+	// Whether the ip_vs module is loaded. Without it kube-proxy isn't in ipvs mode and
+	// the other fields are empty.
+	Enabled bool
+	// Totals across all virtual services
+	Services      int
+	Destinations  int    // Real servers, e.g. the endpoints of a Kubernetes Service
+	ActiveConns   uint64 // Established connections
+	InactiveConns uint64 // Connections in other states, e.g. TIME_WAIT
+	// Counters since the module was loaded, from /proc/net/ip_vs_stats
+	Conns      uint64
+	InPackets  uint64
+	OutPackets uint64
+	InBytes    uint64
+	OutBytes   uint64
+	// Rates estimated by the kernel over the last few seconds
+	ConnsPerSecond      uint64
+	InPacketsPerSecond  uint64
+	OutPacketsPerSecond uint64
+	InBytesPerSecond    uint64
+	OutBytesPerSecond   uint64
+	// Virtual services in kernel order
+	VirtualServices []IPVSVirtualService
+}
+
+// IPVSVirtualService represents a single IPVS virtual service and its real servers
+type IPVSVirtualService struct {
+	Protocol      string // TCP, UDP, SCTP or FWM
+	Address       string // host:port, or the firewall mark of FWM services
+	Scheduler     string // e.g. rr, lc, sh
+	Destinations  int
+	ActiveConns   uint64
+	InactiveConns uint64
+}
+
 // DiskStats represents disk I/O statistics from /proc/diskstats
 type DiskStats struct {
 	// Device identification
@@ -202,13 +936,42 @@ type DiskStats struct {
 	IOTime         uint64 // Time spent doing I/Os (milliseconds)
 	WeightedIOTime uint64 // Weighted time spent doing I/Os (milliseconds)
 	// Calculated fields
-	IOPS             float64
-	ReadBytesPerSec  float64
-	WriteBytesPerSec float64
-	Utilization      float64 // Percentage 0-100
-	AvgQueueSize     float64
-	AvgReadLatency   float64 // milliseconds
-	AvgWriteLatency  float64 // milliseconds
+	IOPS             float64 `schema:"minimum=0"`
+	ReadBytesPerSec  float64 `schema:"minimum=0"`
+	WriteBytesPerSec float64 `schema:"minimum=0"`
+	Utilization      float64 `schema:"minimum=0,maximum=100"` // Percentage 0-100
+	AvgQueueSize     float64 `schema:"minimum=0"`
+	AvgReadLatency   float64 `schema:"minimum=0"` // milliseconds
+	AvgWriteLatency  float64 `schema:"minimum=0"` // milliseconds
+	// Extended statistics, named after the columns of iostat -x
+	ReadsPerSec       float64 `schema:"minimum=0"`             // r/s
+	WritesPerSec      float64 `schema:"minimum=0"`             // w/s
+	ReadMergesPerSec  float64 `schema:"minimum=0"`             // rrqm/s
+	WriteMergesPerSec float64 `schema:"minimum=0"`             // wrqm/s
+	ReadMergeRatio    float64 `schema:"minimum=0,maximum=100"` // %rrqm: percentage of reads merged before queuing
+	WriteMergeRatio   float64 `schema:"minimum=0,maximum=100"` // %wrqm: percentage of writes merged before queuing
+	AvgReadSize       float64 `schema:"minimum=0"`             // rareq-sz, in bytes
+	AvgWriteSize      float64 `schema:"minimum=0"`             // wareq-sz, in bytes
+	Await             float64 `schema:"minimum=0"`             // await: average time of reads and writes (milliseconds)
+	ServiceTime       float64 `schema:"minimum=0"`             // svctm: time the disk was busy per read or write (milliseconds)
+	// Saturation episodes of the device that are ongoing or ended since the previous
+	// collection. An ongoing episode is reported by every collection until it ends.
+	Saturation []DiskSaturationEvent
+}
+
+// DiskSaturationEvent is an episode in which a disk was saturated: its utilization stayed
+// above the saturation threshold, or its queue depth spiked, for several consecutive
+// collections
+type DiskSaturationEvent struct {
+	Device string
+	Start  time.Time // Start of the first saturated collection interval
+	End    time.Time // End of the last saturated interval, zero while the episode is ongoing
+	// What saturated the disk at any point of the episode
+	HighUtilization bool
+	QueueSpike      bool
+	Samples         int     // Saturated collections so far
+	PeakUtilization float64 `schema:"minimum=0,maximum=100"` // Percentage 0-100
+	PeakQueueSize   float64 `schema:"minimum=0"`
 }
 
 // NetworkStats represents network interface statistics
@@ -234,10 +997,10 @@ type NetworkStats struct {
 	TxCarrier    uint64 // Carrier losses
 	TxCompressed uint64 // Compressed packets transmitted
 	// Calculated fields
-	RxBytesPerSec   float64
-	RxPacketsPerSec float64
-	TxBytesPerSec   float64
-	TxPacketsPerSec float64
+	RxBytesPerSec   float64 `schema:"minimum=0"`
+	RxPacketsPerSec float64 `schema:"minimum=0"`
+	TxBytesPerSec   float64 `schema:"minimum=0"`
+	TxPacketsPerSec float64 `schema:"minimum=0"`
 	// Interface metadata from /sys/class/net/[interface]/
 	Speed        uint64 // Link speed in Mbps from /sys/class/net/[interface]/speed
 	Duplex       string // Duplex mode from /sys/class/net/[interface]/duplex
@@ -245,6 +1008,132 @@ type NetworkStats struct {
 	LinkDetected bool   // Link detection from /sys/class/net/[interface]/carrier
 }
 
+// TopologyStats represents the NUMA topology of the host as the kubelet's Topology
+// Manager sees it: the CPUs and memory of each NUMA node and the node each PCI device is
+// attached to, so that placement can be checked for NUMA affinity
+type TopologyStats struct {
+	Nodes []NUMANode // Sorted by ID. Empty on hosts without NUMA support.
+	// The network, storage, display and accelerator devices, sorted by address. Bridges
+	// and other system devices aren't reported.
+	Devices []PCIDevice
+}
+
+// NUMANode represents a NUMA node from /sys/devices/system/node/node[id]/
+type NUMANode struct {
+	ID          int
+	CPUs        []int  // cpulist
+	MemoryBytes uint64 // MemTotal of meminfo
+	// Distance to every node in the order of their IDs, from distance. The distance
+	// of a node to itself is 10.
+	Distances []int
+}
+
+// PCIDevice represents a PCI device from /sys/bus/pci/devices/[address]/
+type PCIDevice struct {
+	Address string // e.g. 0000:3b:00.0
+	Class   string // class, e.g. 0x020000 for an ethernet controller
+	Vendor  string // vendor ID, e.g. 0x8086
+	Device  string // device ID
+	Driver  string // Kernel driver from the driver symlink, empty if unbound
+	// NUMA node the device is attached to from numa_node, -1 if the device has no
+	// NUMA affinity
+	NUMANode int
+	// CPUs local to the device from local_cpulist
+	LocalCPUs []int
+	// Network interfaces and block devices of the device, e.g. eth0 or nvme0n1
+	Interfaces   []string
+	BlockDevices []string
+}
+
+// NetworkInfo represents network interface configuration and the topology between
+// physical and logical interfaces (bonds, VLANs, bridges)
+type NetworkInfo struct {
+	Interfaces []NetworkInterfaceInfo
+	// Links from lower (e.g. physical) interfaces to the logical interfaces built on them
+	Links []NetworkInterfaceLink
+}
+
+// NetworkInterfaceInfo represents the configuration of a single interface from /sys/class/net/[interface]/
+type NetworkInterfaceInfo struct {
+	Name       string
+	Type       string // DEVTYPE from uevent (bond, bridge, vlan, wlan); empty for plain ethernet
+	MACAddress string // address
+	MTU        uint64 // mtu
+	Speed      uint64 // speed in Mbps (0 if unknown)
+	Duplex     string // duplex
+	OperState  string // operstate
+	Driver     string // Kernel driver from the device/driver symlink
+	Virtual    bool   // True if the interface has no backing device (no device symlink)
+	Master     string // Bond or bridge this interface is enslaved to, from the master symlink
+	Bond       *BondInfo
+	VLAN       *VLANInfo
+	Wireless   *WirelessInfo // nil for wired interfaces
+}
+
+// BondInfo represents bonding driver state from /proc/net/bonding/[bond]
+type BondInfo struct {
+	Mode        string // Bonding Mode (e.g. "fault-tolerance (active-backup)", "IEEE 802.3ad Dynamic link aggregation")
+	ActiveSlave string // Currently Active Slave (active-backup modes only)
+	MIIStatus   string // MII Status of the bond (up/down)
+	Slaves      []BondSlaveInfo
+}
+
+// BondSlaveInfo represents a single "Slave Interface" section in /proc/net/bonding/[bond]
+type BondSlaveInfo struct {
+	Interface        string
+	MIIStatus        string
+	Speed            string // e.g. "1000 Mbps" or "Unknown"
+	Duplex           string
+	LinkFailureCount uint64
+	PermanentHWAddr  string
+}
+
+// VLANInfo represents VLAN configuration from /proc/net/vlan/config
+type VLANInfo struct {
+	ID     uint16
+	Parent string // Underlying interface carrying the tagged traffic
+}
+
+// WirelessInfo represents the state of a wireless interface from /proc/net/wireless and
+// nl80211. The nl80211 fields are empty if the interface isn't associated or nl80211 isn't
+// reachable from the agent's network namespace.
+type WirelessInfo struct {
+	// Association from nl80211
+	SSID          string
+	BSSID         string  // MAC address of the access point
+	FrequencyMHz  uint32  // Operating channel frequency
+	TxBitrateMbps float64 // Bitrate of the last transmitted frame
+	RxBitrateMbps float64 // Bitrate of the last received frame
+	// Signal from nl80211, falling back to /proc/net/wireless. The level is in dBm.
+	SignalDBm int32
+	// Link quality, noise and discarded packet counters from /proc/net/wireless
+	LinkQuality    uint32 // Driver-specific scale, e.g. 0-70
+	NoiseDBm       int32  // 0 if the driver doesn't report noise
+	DiscardedNwid  uint64 // Packets with a different network ID
+	DiscardedCrypt uint64 // Packets that couldn't be decrypted
+	DiscardedFrag  uint64 // Packets that couldn't be reassembled
+	DiscardedRetry uint64 // Packets discarded after exhausting MAC retries
+	DiscardedMisc  uint64 // Packets discarded for other reasons
+	MissedBeacons  uint64 // Beacons missed from the access point
+}
+
+// NetworkLinkType describes how a lower interface is attached to an upper interface
+type NetworkLinkType string
+
+const (
+	NetworkLinkBondSlave  NetworkLinkType = "bond_slave"
+	NetworkLinkVLAN       NetworkLinkType = "vlan"
+	NetworkLinkBridgePort NetworkLinkType = "bridge_port"
+)
+
+// NetworkInterfaceLink relates a lower interface to the logical interface stacked on it
+// e.g. eth0 -[bond_slave]-> bond0 -[vlan]-> bond0.100 -[bridge_port]-> br0
+type NetworkInterfaceLink struct {
+	Lower string
+	Upper string
+	Type  NetworkLinkType
+}
+
 // TCPStats represents TCP connection statistics
 type TCPStats struct {
 	// Connection counts from /proc/net/snmp (Tcp: line)
@@ -287,6 +1176,123 @@ type KernelMessage struct {
 	// Parsed fields from message content
 	Subsystem string // Kernel subsystem if identifiable
 	Device    string // Device name if present in message
+	// Typed event if the message matches a known pattern, nil otherwise
+	Event *KernelEvent
+	// Repeats is the number of identical messages folded into this one because they
+	// were logged within CollectionConfig.KernelDedupWindow of the first, which is
+	// reported on its own. Timestamp and SequenceNum are those of the last of them and
+	// FirstTimestamp that of the first. 0 for a message reported as logged.
+	Repeats        int
+	FirstTimestamp time.Time
+}
+
+// KernelEventKind is the kind of a typed kernel event
+type KernelEventKind string
+
+const (
+	KernelEventOOMKill         KernelEventKind = "oom_kill"
+	KernelEventIOError         KernelEventKind = "io_error"
+	KernelEventLinkDown        KernelEventKind = "link_down"
+	KernelEventLinkUp          KernelEventKind = "link_up"
+	KernelEventReadOnlyRemount KernelEventKind = "readonly_remount"
+	KernelEventHungTask        KernelEventKind = "hung_task"
+)
+
+// KernelEvent is a kernel message recognized by a known pattern, with the fields extracted
+// from it. Fields a message doesn't name are left empty.
+type KernelEvent struct {
+	Kind     KernelEventKind
+	Device   string        // Block device, network interface or filesystem device
+	PID      int32         // Process killed or hung
+	Process  string        // Command of the process
+	Duration time.Duration // How long a hung task was blocked
+	// Other fields extracted by the pattern, e.g. the sector of an I/O error or the speed
+	// of a link
+	Fields map[string]string
+}
+
+// PowerStats represents power supply, suspend and CPU idle state information from /sys
+type PowerStats struct {
+	// Power supplies from /sys/class/power_supply/*
+	Supplies []PowerSupply
+	// Suspend/resume counters from /sys/power/suspend_stats/ (nil if unavailable)
+	Suspend *SuspendStats
+	// Suspends and resumes since the previous collection, nil if there were none. The
+	// first collection reports none.
+	SuspendEvent *SuspendEvent
+	// Per-CPU idle state residency from /sys/devices/system/cpu/cpu*/cpuidle/
+	CPUIdle []CPUIdleStats
+}
+
+// PowerSupply represents a single entry in /sys/class/power_supply
+type PowerSupply struct {
+	Name            string // Directory name in /sys/class/power_supply (e.g. BAT0, AC)
+	Type            string // Battery, Mains, USB, UPS, etc. from type
+	Status          string // Charging, Discharging, Full, Not charging, Unknown from status
+	Health          string // Good, Overheat, Dead, etc. from health
+	Online          bool   // External power connected from online (Mains/USB)
+	Present         bool   // Battery present from present
+	CapacityPercent uint64 `schema:"maximum=100"` // Remaining capacity 0-100 from capacity
+	CycleCount      uint64 // Charge cycles from cycle_count
+	// Drivers report either energy in µWh (energy_*) or charge in µAh (charge_*), so only
+	// one of the two sets is non-zero. Multiply charge by VoltageNow to compare them.
+	EnergyNow        uint64 // energy_now in µWh
+	EnergyFull       uint64 // energy_full in µWh
+	EnergyFullDesign uint64 // energy_full_design in µWh
+	ChargeNow        uint64 // charge_now in µAh
+	ChargeFull       uint64 // charge_full in µAh
+	ChargeFullDesign uint64 // charge_full_design in µAh
+	PowerNow         uint64 // power_now in µW
+	VoltageNow       uint64 // voltage_now in µV
+}
+
+// SuspendStats represents system suspend/resume counters from /sys/power/suspend_stats/
+type SuspendStats struct {
+	Success            uint64 // success: completed suspend/resume cycles
+	Fail               uint64 // fail: failed suspend attempts
+	FailedFreeze       uint64 // failed_freeze
+	FailedPrepare      uint64 // failed_prepare
+	FailedSuspend      uint64 // failed_suspend
+	FailedSuspendLate  uint64 // failed_suspend_late
+	FailedSuspendNoirq uint64 // failed_suspend_noirq
+	FailedResume       uint64 // failed_resume
+	FailedResumeEarly  uint64 // failed_resume_early
+	FailedResumeNoirq  uint64 // failed_resume_noirq
+	LastFailedDev      string // last_failed_dev: device that caused the last failure
+	LastFailedErrno    int64  // last_failed_errno
+	LastFailedStep     string // last_failed_step: suspend step that last failed
+}
+
+// SuspendEvent is the suspend/resume activity between two collections, found from the
+// change of the suspend_stats counters
+type SuspendEvent struct {
+	Start    time.Time // Time of the previous collection
+	End      time.Time // Time of the collection that observed the activity
+	Resumes  uint64    // Completed suspend/resume cycles, from the change of success
+	Failures uint64    // Failed suspend attempts, from the change of fail
+	// Time the system was suspended: how much further CLOCK_BOOTTIME advanced than
+	// CLOCK_MONOTONIC, which stops while the system is suspended. Zero if the clocks
+	// couldn't be read.
+	Suspended time.Duration
+	// The last failure, set when Failures is non-zero
+	LastFailedDev   string
+	LastFailedErrno int64
+	LastFailedStep  string
+}
+
+// CPUIdleStats represents C-state residency for a single CPU
+type CPUIdleStats struct {
+	CPUIndex int32
+	States   []CPUIdleState
+}
+
+// CPUIdleState represents a single idle state from /sys/devices/system/cpu/cpuN/cpuidle/stateM/
+type CPUIdleState struct {
+	Name     string // name (e.g. POLL, C1, C1E, C6)
+	Latency  uint64 // latency: exit latency in microseconds
+	Usage    uint64 // usage: number of times the state was entered
+	Time     uint64 // time: total residency in microseconds
+	Disabled bool   // disable: state disabled by the user or driver
 }
 
 // KernelSeverity represents kernel message severity levels
@@ -306,16 +1312,103 @@ const (
 // CollectionConfig represents configuration for performance collection
 type CollectionConfig struct {
 	Interval          time.Duration
+	CollectorTimeout  time.Duration // Bounds a single run of a point collector
 	EnabledCollectors map[MetricType]bool
 	HostProcPath      string // Path to /proc (useful for containers)
 	HostSysPath       string // Path to /sys (useful for containers)
 	HostDevPath       string // Path to /dev (useful for containers)
+	// Certificate files (glob patterns allowed) and host:port TLS endpoints checked by
+	// the certificate collector
+	CertificatePaths     []string
+	CertificateEndpoints []string
+	// Directory where crash dumps are written (kdump, apport), checked by the kernel taint
+	// collector
+	CrashDumpDir string
+	// A disk is saturated when its utilization percentage is at least
+	// DiskSaturationUtilization, or its queue depth spikes, for DiskSaturationSamples
+	// consecutive collections
+	DiskSaturationUtilization float64
+	DiskSaturationSamples     int
+	// Path prefixes of the files whose opens are reported by the file open collector.
+	// Empty reports every open.
+	FileOpenPathPrefixes []string
+	// Limits of the exec collector, for nodes that exec thousands of processes per second.
+	// At most ExecArgsMaxLength bytes of the filename and arguments are captured per exec,
+	// 0 capturing up to the collector's maximum. Only execs by ExecUIDs with a task name
+	// in ExecCommands are reported, empty reporting all. At most ExecEventRateLimit events
+	// are reported per second, 0 being unlimited.
+	ExecArgsMaxLength  int
+	ExecUIDs           []uint32
+	ExecCommands       []string
+	ExecEventRateLimit float64
+	// ArgsPolicy decides whether the arguments of traced execs are captured, truncated,
+	// hashed or dropped. nil captures them as is.
+	ArgsPolicy *argpolicy.Policy
+	// Number of the most recent kernel log messages returned by each collection of the
+	// kernel collector. When started continuously, the kernel collector first delivers
+	// the KernelBackfill most recent messages and then follows new ones, so that the
+	// messages logged right before the agent started aren't lost. 0 only follows the
+	// messages logged after it started.
+	KernelMessageLimit int
+	KernelBackfill     int
+	// Identical kernel messages logged within KernelDedupWindow of one reported are
+	// folded into a single message with a repeat count, so that a flapping device doesn't
+	// flood the log stream. 0 reports every message.
+	KernelDedupWindow time.Duration
+	// When the utilization of all CPUs is at least BurstThreshold percent, the CPU and
+	// process collectors are sampled every BurstInterval for BurstDuration, capturing
+	// spikes shorter than the collection interval. 0 disables bursts.
+	BurstThreshold float64
+	BurstInterval  time.Duration
+	BurstDuration  time.Duration
+	// Filters and sampling of the TCP sockets collector. Only sockets in TCPSocketStates,
+	// e.g. ESTABLISHED, with a local or remote port in TCPSocketPorts are counted, empty
+	// counting all of them, and 1 in TCPSocketSampleRate of them is sampled, 0 sampling
+	// all of them.
+	TCPSocketStates     []string
+	TCPSocketPorts      []uint16
+	TCPSocketSampleRate uint64
 }
 
+// DefaultCertificatePaths are the kubelet, control plane and etcd certificates of
+// kubeadm-style nodes
+var DefaultCertificatePaths = []string{
+	"/var/lib/kubelet/pki/*.crt",
+	"/var/lib/kubelet/pki/*.pem",
+	"/etc/kubernetes/pki/*.crt",
+	"/etc/kubernetes/pki/etcd/*.crt",
+}
+
+// DefaultCertificateEndpoints is the kubelet's serving endpoint
+var DefaultCertificateEndpoints = []string{"localhost:10250"}
+
+// DefaultCrashDumpDir is where kdump and apport write crash dumps
+const DefaultCrashDumpDir = "/var/crash"
+
+// DefaultKernelMessageLimit is the number of kernel log messages returned by each
+// collection of the kernel collector
+const DefaultKernelMessageLimit = 50
+
+// DefaultKernelDedupWindow is how long identical kernel messages are folded into one
+const DefaultKernelDedupWindow = 30 * time.Second
+
+// Default sampling of bursts
+const (
+	DefaultBurstInterval = 250 * time.Millisecond
+	DefaultBurstDuration = 10 * time.Second
+)
+
+// Default disk saturation detection thresholds
+const (
+	DefaultDiskSaturationUtilization = 90
+	DefaultDiskSaturationSamples     = 3
+)
+
 // DefaultCollectionConfig returns a default configuration
 func DefaultCollectionConfig() CollectionConfig {
 	return CollectionConfig{
-		Interval: time.Second,
+		Interval:         time.Second,
+		CollectorTimeout: 10 * time.Second,
 		EnabledCollectors: map[MetricType]bool{
 			MetricTypeLoad:    true,
 			MetricTypeMemory:  true,
@@ -329,6 +1422,17 @@ func DefaultCollectionConfig() CollectionConfig {
 		HostProcPath: "/proc",
 		HostSysPath:  "/sys",
 		HostDevPath:  "/dev",
+
+		CertificatePaths:     DefaultCertificatePaths,
+		CertificateEndpoints: DefaultCertificateEndpoints,
+		CrashDumpDir:         DefaultCrashDumpDir,
+
+		DiskSaturationUtilization: DefaultDiskSaturationUtilization,
+		DiskSaturationSamples:     DefaultDiskSaturationSamples,
+		KernelMessageLimit:        DefaultKernelMessageLimit,
+		KernelDedupWindow:         DefaultKernelDedupWindow,
+		BurstInterval:             DefaultBurstInterval,
+		BurstDuration:             DefaultBurstDuration,
 	}
 }
 
@@ -339,6 +1443,9 @@ func (c *CollectionConfig) ApplyDefaults() {
 	if c.Interval == 0 {
 		c.Interval = defaults.Interval
 	}
+	if c.CollectorTimeout == 0 {
+		c.CollectorTimeout = defaults.CollectorTimeout
+	}
 	if c.EnabledCollectors == nil {
 		c.EnabledCollectors = defaults.EnabledCollectors
 	}
@@ -351,4 +1458,28 @@ func (c *CollectionConfig) ApplyDefaults() {
 	if c.HostDevPath == "" {
 		c.HostDevPath = defaults.HostDevPath
 	}
+	if c.CertificatePaths == nil {
+		c.CertificatePaths = defaults.CertificatePaths
+	}
+	if c.CertificateEndpoints == nil {
+		c.CertificateEndpoints = defaults.CertificateEndpoints
+	}
+	if c.CrashDumpDir == "" {
+		c.CrashDumpDir = defaults.CrashDumpDir
+	}
+	if c.DiskSaturationUtilization == 0 {
+		c.DiskSaturationUtilization = defaults.DiskSaturationUtilization
+	}
+	if c.DiskSaturationSamples == 0 {
+		c.DiskSaturationSamples = defaults.DiskSaturationSamples
+	}
+	if c.KernelMessageLimit == 0 {
+		c.KernelMessageLimit = defaults.KernelMessageLimit
+	}
+	if c.BurstInterval == 0 {
+		c.BurstInterval = defaults.BurstInterval
+	}
+	if c.BurstDuration == 0 {
+		c.BurstDuration = defaults.BurstDuration
+	}
 }
@@ -0,0 +1,26 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package redact
+
+import (
+	"github.com/antimetal/agent/pkg/performance"
+)
+
+// KernelMessages redacts the secret-like strings of the text of msgs in place. Kernel log
+// messages can carry command lines and arguments of userspace processes, e.g. from audit
+// or segfault reports.
+func (p *Policy) KernelMessages(msgs []performance.KernelMessage) {
+	for i := range msgs {
+		msgs[i].Message = p.String(msgs[i].Message)
+	}
+}
+
+// Snapshot redacts the kernel log messages of snapshot in place. The collector stats hold
+// the same slice as the snapshot's metrics, so they are redacted as well.
+func (p *Policy) Snapshot(snapshot *performance.Snapshot) {
+	p.KernelMessages(snapshot.Metrics.Kernel)
+}
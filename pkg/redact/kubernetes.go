@@ -0,0 +1,107 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package redact
+
+import (
+	"fmt"
+	"reflect"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubernetesObject is a Kubernetes API object in its protobuf encoding
+type kubernetesObject interface {
+	gogoproto.Message
+	metav1.Object
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// KubernetesObject redacts obj in place: the annotations of the object and of its pod
+// template, and the environment variables, commands and arguments of its containers.
+func (p *Policy) KubernetesObject(obj metav1.Object) {
+	p.AnnotationValues(obj.GetAnnotations())
+
+	var template *corev1.PodTemplateSpec
+	switch obj := obj.(type) {
+	case *corev1.Pod:
+		p.podSpec(&obj.Spec)
+	case *corev1.ReplicationController:
+		template = obj.Spec.Template
+	case *appsv1.Deployment:
+		template = &obj.Spec.Template
+	case *appsv1.DaemonSet:
+		template = &obj.Spec.Template
+	case *appsv1.ReplicaSet:
+		template = &obj.Spec.Template
+	case *appsv1.StatefulSet:
+		template = &obj.Spec.Template
+	case *batchv1.Job:
+		template = &obj.Spec.Template
+	case *batchv1.CronJob:
+		template = &obj.Spec.JobTemplate.Spec.Template
+	}
+	if template != nil {
+		p.AnnotationValues(template.Annotations)
+		p.podSpec(&template.Spec)
+	}
+}
+
+// KubernetesProto redacts a Kubernetes object in its protobuf encoding. typeName is the
+// object's protobuf message name, e.g. k8s.io.api.core.v1.Pod. Data of types that aren't
+// Kubernetes objects is returned as is.
+func (p *Policy) KubernetesProto(typeName string, data []byte) ([]byte, error) {
+	typ := gogoproto.MessageType(typeName)
+	if typ == nil || typ.Kind() != reflect.Pointer {
+		return data, nil
+	}
+	obj, ok := reflect.New(typ.Elem()).Interface().(kubernetesObject)
+	if !ok {
+		return data, nil
+	}
+	if err := obj.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", typeName, err)
+	}
+	p.KubernetesObject(obj)
+	redacted, err := obj.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", typeName, err)
+	}
+	return redacted, nil
+}
+
+func (p *Policy) podSpec(spec *corev1.PodSpec) {
+	for i := range spec.InitContainers {
+		p.container(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		p.container(&spec.Containers[i])
+	}
+	for i := range spec.EphemeralContainers {
+		p.container((*corev1.Container)(&spec.EphemeralContainers[i].EphemeralContainerCommon))
+	}
+}
+
+func (p *Policy) container(c *corev1.Container) {
+	p.Strings(c.Command)
+	p.Strings(c.Args)
+	for i := range c.Env {
+		env := &c.Env[i]
+		if env.Value == "" {
+			continue
+		}
+		if p.EnvVars {
+			env.Value = Placeholder
+		} else {
+			env.Value = p.String(env.Value)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package redact strips sensitive data from what the agent ships: values of container
+// environment variables, annotations and secret-like strings such as private keys, access
+// keys and tokens.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Placeholder replaces redacted values
+const Placeholder = "[REDACTED]"
+
+// secretGroup is the name of the subexpression of a secret pattern that holds the secret.
+// Patterns without it redact the whole match.
+const secretGroup = "secret"
+
+var (
+	// DefaultAnnotationPatterns match the keys of annotations whose values are always
+	// redacted. The last applied configuration of kubectl holds a full copy of the object,
+	// including its environment variables.
+	DefaultAnnotationPatterns = []string{
+		`^kubectl\.kubernetes\.io/last-applied-configuration$`,
+		`(?i)(secret|token|passw(or)?d|credential|api[-_]?key|private[-_]?key)`,
+	}
+
+	// DefaultSecretPatterns match secret-like strings that are redacted from all strings
+	// the policy is applied to
+	DefaultSecretPatterns = []string{
+		// PEM encoded private keys
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+		// AWS access key IDs
+		`\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+		// JSON web tokens
+		`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+		// Authorization headers
+		`(?i)\b(bearer|basic)\s+(?P<secret>[A-Za-z0-9._~+/-]+=*)`,
+		// key=value and key: value pairs of secret-like keys, e.g. in command line flags
+		`(?i)(secret|token|passw(or)?d|pwd|api[-_]?key|access[-_]?key)[A-Za-z0-9_-]*["']?\s*[=:]\s*["']?(?P<secret>[^\s"',;&]+)`,
+		// Credentials in URLs
+		`[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`,
+	}
+)
+
+// Policy describes what is redacted
+type Policy struct {
+	// EnvVars redacts the values of environment variables set inline in container specs.
+	// Variables referencing secrets or config maps don't carry their values and are kept.
+	EnvVars bool
+	// Annotations match the keys of annotations whose values are redacted
+	Annotations []*regexp.Regexp
+	// Secrets match secret-like strings. If a pattern has a subexpression named "secret"
+	// only that part of the match is redacted.
+	Secrets []*regexp.Regexp
+}
+
+// NewPolicy compiles a policy from annotation key and secret patterns
+func NewPolicy(envVars bool, annotationPatterns, secretPatterns []string) (*Policy, error) {
+	p := &Policy{EnvVars: envVars}
+	for _, pattern := range annotationPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation pattern %q: %w", pattern, err)
+		}
+		p.Annotations = append(p.Annotations, re)
+	}
+	for _, pattern := range secretPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %q: %w", pattern, err)
+		}
+		p.Secrets = append(p.Secrets, re)
+	}
+	return p, nil
+}
+
+// DefaultPolicy returns the conservative default policy: all inline environment variable
+// values, the DefaultAnnotationPatterns and the DefaultSecretPatterns are redacted.
+func DefaultPolicy() *Policy {
+	p, err := NewPolicy(true, DefaultAnnotationPatterns, DefaultSecretPatterns)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default redaction policy: %v", err))
+	}
+	return p
+}
+
+// String returns s with the secret-like strings matched by the policy redacted, e.g. for
+// kernel log messages or command lines
+func (p *Policy) String(s string) string {
+	for _, re := range p.Secrets {
+		group := re.SubexpIndex(secretGroup)
+		if group < 0 {
+			s = re.ReplaceAllLiteralString(s, Placeholder)
+			continue
+		}
+		s = replaceGroup(re, s, group)
+	}
+	return s
+}
+
+// replaceGroup replaces the text of the subexpression group of every match of re in s
+func replaceGroup(re *regexp.Regexp, s string, group int) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2*group], m[2*group+1]
+		if start < 0 {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(Placeholder)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// Strings redacts each string of ss in place
+func (p *Policy) Strings(ss []string) {
+	for i := range ss {
+		ss[i] = p.String(ss[i])
+	}
+}
+
+// AnnotationValues redacts annotations in place: the values of keys matched by the policy are
+// replaced and the other values have their secret-like strings redacted
+func (p *Policy) AnnotationValues(annotations map[string]string) {
+	for key, value := range annotations {
+		if p.annotationRedacted(key) {
+			annotations[key] = Placeholder
+			continue
+		}
+		annotations[key] = p.String(value)
+	}
+}
+
+func (p *Policy) annotationRedacted(key string) bool {
+	for _, re := range p.Annotations {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
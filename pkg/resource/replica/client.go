@@ -0,0 +1,239 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package replica
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// defaultTimeout bounds each read of the Server, since the methods of resource.Store take
+// no context
+const defaultTimeout = 10 * time.Second
+
+// Client is a resource.Store reading the resources of the remote types from a Server
+// and everything else from a local store. Writes, subscriptions and Close go to the local
+// store.
+//
+// Listing resources of any type lists the resources of the local types from the local
+// store and those of the remote types from the Server. Relationships are read from both
+// stores, since relationships between node-local and cluster-scoped resources are
+// written by whichever agent indexes them.
+type Client struct {
+	resource.Store
+	conn    grpc.ClientConnInterface
+	remote  map[string]bool
+	leader  func() bool
+	timeout time.Duration
+}
+
+// Option configures a Client created with NewClient.
+type Option func(*Client)
+
+// WithLeader reads everything from the local store while isLeader returns true, e.g. once
+// the agent is elected and indexes the remote types itself.
+func WithLeader(isLeader func() bool) Option {
+	return func(c *Client) {
+		c.leader = isLeader
+	}
+}
+
+// WithTimeout bounds each read of the Server to timeout instead of 10 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// NewClient returns a Client reading the resources of remoteTypes, type names or URLs,
+// from the Server at the other end of conn and everything else from local. conn should be
+// created with ServiceConfig.
+func NewClient(local resource.Store, conn grpc.ClientConnInterface, remoteTypes []string, opts ...Option) *Client {
+	c := &Client{
+		Store:   local,
+		conn:    conn,
+		remote:  make(map[string]bool, len(remoteTypes)),
+		timeout: defaultTimeout,
+	}
+	for _, typ := range remoteTypes {
+		c.remote[typeurl.ToName(typ)] = true
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GetResource returns the resource ref from the Server if its type is remote, otherwise
+// from the local store.
+func (c *Client) GetResource(ref *resourcev1.ResourceRef) (*resourcev1.Resource, error) {
+	if !c.isRemote(ref.GetTypeUrl()) {
+		return c.Store.GetResource(ref)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	rsrc := &resourcev1.Resource{}
+	if err := c.conn.Invoke(ctx, getResourceMethod, ref, rsrc); err != nil {
+		return nil, fromStatus(err, resource.ErrResourceNotFound)
+	}
+	return rsrc, nil
+}
+
+// ListResources returns the resources of typeDef from the Server if its type is remote,
+// otherwise from the local store. Without a type, the resources of the local types are
+// listed from the local store and those of the remote types from the Server.
+func (c *Client) ListResources(typeDef *resourcev1.TypeDescriptor) ([]*resourcev1.Resource, error) {
+	if typeDef.GetType() != "" {
+		if !c.isRemote(typeDef.GetType()) {
+			return c.Store.ListResources(typeDef)
+		}
+		return c.listResources(typeDef)
+	}
+
+	rsrcs, err := c.Store.ListResources(typeDef)
+	if err != nil || c.isLeader() {
+		return rsrcs, err
+	}
+	rsrcs = slices.DeleteFunc(rsrcs, func(rsrc *resourcev1.Resource) bool {
+		return c.remote[typeurl.ToName(rsrc.GetType().GetType())]
+	})
+	remote, err := c.listResources(typeDef)
+	if err != nil {
+		return nil, err
+	}
+	remote = slices.DeleteFunc(remote, func(rsrc *resourcev1.Resource) bool {
+		return !c.remote[typeurl.ToName(rsrc.GetType().GetType())]
+	})
+	return append(rsrcs, remote...), nil
+}
+
+// ListNamespacedResources returns the resources of typeDef in namespace ns from the Server
+// if its type is remote, otherwise from the local store.
+func (c *Client) ListNamespacedResources(typeDef *resourcev1.TypeDescriptor, ns *resourcev1.Namespace,
+) ([]*resourcev1.Resource, error) {
+	if !c.isRemote(typeDef.GetType()) {
+		return c.Store.ListNamespacedResources(typeDef, ns)
+	}
+	rsrcs, err := c.listResources(typeDef)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(rsrcs, func(rsrc *resourcev1.Resource) bool {
+		return !proto.Equal(rsrc.GetMetadata().GetNamespace(), ns)
+	}), nil
+}
+
+func (c *Client) listResources(typeDef *resourcev1.TypeDescriptor) ([]*resourcev1.Resource, error) {
+	if typeDef == nil {
+		typeDef = &resourcev1.TypeDescriptor{}
+	}
+	rsrcs, err := receive(c, &serviceDesc.Streams[0], listResourcesMethod, typeDef,
+		func() *resourcev1.Resource { return &resourcev1.Resource{} })
+	if err != nil {
+		return nil, fromStatus(err, resource.ErrResourceNotFound)
+	}
+	return rsrcs, nil
+}
+
+// GetRelationships returns the relationships matching subject, object and predicateT in
+// the local store and, if they can involve a resource of a remote type, on the Server.
+// It returns resource.ErrRelationshipsNotFound if neither has any.
+func (c *Client) GetRelationships(subject, object *resourcev1.ResourceRef, predicateT proto.Message) ([]*resourcev1.Relationship, error) {
+	rels, err := c.Store.GetRelationships(subject, object, predicateT)
+	if err != nil && !errors.Is(err, resource.ErrRelationshipsNotFound) {
+		return nil, err
+	}
+	local := subject != nil && !c.isRemote(subject.GetTypeUrl()) &&
+		object != nil && !c.isRemote(object.GetTypeUrl())
+	if local || c.isLeader() {
+		return rels, err
+	}
+
+	query := &resourcev1.Relationship{Subject: subject, Object: object}
+	if predicateT != nil {
+		query.Predicate = &anypb.Any{
+			TypeUrl: typeurl.FromName(string(predicateT.ProtoReflect().Descriptor().FullName())),
+		}
+	}
+	remote, err := receive(c, &serviceDesc.Streams[1], getRelationshipsMethod, query,
+		func() *resourcev1.Relationship { return &resourcev1.Relationship{} })
+	if err != nil {
+		err = fromStatus(err, resource.ErrRelationshipsNotFound)
+		if !errors.Is(err, resource.ErrRelationshipsNotFound) {
+			return nil, err
+		}
+	}
+	rels = append(rels, remote...)
+	if len(rels) == 0 {
+		return nil, resource.ErrRelationshipsNotFound
+	}
+	return rels, nil
+}
+
+// isRemote returns whether the resources of typ, a type name or URL, are read from the
+// Server
+func (c *Client) isRemote(typ string) bool {
+	return c.remote[typeurl.ToName(typ)] && !c.isLeader()
+}
+
+func (c *Client) isLeader() bool {
+	return c.leader != nil && c.leader()
+}
+
+// receive sends req on a new stream of method and returns the messages the Server
+// streams back
+func receive[T proto.Message](c *Client, desc *grpc.StreamDesc, method string, req proto.Message, newT func() T) ([]T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	stream, err := c.conn.NewStream(ctx, desc, method)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var msgs []T
+	for {
+		msg := newT()
+		err := stream.RecvMsg(msg)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// fromStatus returns the store error of err, a gRPC status error: notFound if the Server
+// had nothing to return
+func fromStatus(err error, notFound error) error {
+	if status.Code(err) == codes.NotFound {
+		return notFound
+	}
+	return fmt.Errorf("failed to read the store replica: %w", err)
+}
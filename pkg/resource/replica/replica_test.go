@@ -0,0 +1,229 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package replica
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+const (
+	clusterType = "cluster.v1.Pod"
+	localType   = "node.v1.Process"
+)
+
+func newResource(typ, name string) *resourcev1.Resource {
+	return &resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "resource", Type: typ},
+		Metadata: &resourcev1.ResourceMeta{Name: name},
+	}
+}
+
+func ref(typ, name string) *resourcev1.ResourceRef {
+	return &resourcev1.ResourceRef{TypeUrl: typ, Name: name}
+}
+
+// newReplica returns a leader store served by a Server and a Client of a local store
+// reading clusterType from it
+func newReplica(t *testing.T, opts ...Option) (leader, local resource.Store, client *Client) {
+	t.Helper()
+	leaderStore, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create leader store: %v", err)
+	}
+	t.Cleanup(func() { leaderStore.Close() })
+	localStore, err := store.New()
+	if err != nil {
+		t.Fatalf("failed to create local store: %v", err)
+	}
+	t.Cleanup(func() { localStore.Close() })
+
+	lis := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(leaderStore).Serve(ctx, lis)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("server failed: %v", err)
+		}
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(ServiceConfig),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client connection: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return leaderStore, localStore, NewClient(localStore, conn, []string{clusterType}, opts...)
+}
+
+func TestClient_GetResource(t *testing.T) {
+	leader, local, client := newReplica(t)
+	if err := leader.AddResource(newResource(clusterType, "pod")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	if err := local.AddResource(newResource(localType, "process")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	rsrc, err := client.GetResource(ref(clusterType, "pod"))
+	if err != nil {
+		t.Fatalf("failed to get remote resource: %v", err)
+	}
+	if rsrc.GetMetadata().GetName() != "pod" {
+		t.Errorf("expected pod, got %v", rsrc)
+	}
+	rsrc, err = client.GetResource(ref(localType, "process"))
+	if err != nil {
+		t.Fatalf("failed to get local resource: %v", err)
+	}
+	if rsrc.GetMetadata().GetName() != "process" {
+		t.Errorf("expected process, got %v", rsrc)
+	}
+
+	_, err = client.GetResource(ref(clusterType, "missing"))
+	if !errors.Is(err, resource.ErrResourceNotFound) {
+		t.Errorf("expected %v, got %v", resource.ErrResourceNotFound, err)
+	}
+}
+
+func TestClient_ListResources(t *testing.T) {
+	leader, local, client := newReplica(t)
+	for _, rsrc := range []*resourcev1.Resource{
+		newResource(clusterType, "pod1"),
+		newResource(clusterType, "pod2"),
+		// Node-local resources of the leader aren't read from it
+		newResource(localType, "leader-process"),
+	} {
+		if err := leader.AddResource(rsrc); err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+	for _, rsrc := range []*resourcev1.Resource{
+		newResource(localType, "process"),
+		// A stale copy of a remote type isn't read from the local store
+		newResource(clusterType, "stale"),
+	} {
+		if err := local.AddResource(rsrc); err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+
+	names := func(typeDef *resourcev1.TypeDescriptor) map[string]bool {
+		t.Helper()
+		rsrcs, err := client.ListResources(typeDef)
+		if err != nil {
+			t.Fatalf("failed to list resources: %v", err)
+		}
+		names := make(map[string]bool, len(rsrcs))
+		for _, rsrc := range rsrcs {
+			names[rsrc.GetMetadata().GetName()] = true
+		}
+		return names
+	}
+	for _, tc := range []struct {
+		name     string
+		typeDef  *resourcev1.TypeDescriptor
+		expected []string
+	}{
+		{"Remote", &resourcev1.TypeDescriptor{Kind: "resource", Type: clusterType}, []string{"pod1", "pod2"}},
+		{"Local", &resourcev1.TypeDescriptor{Kind: "resource", Type: localType}, []string{"process"}},
+		{"Any", nil, []string{"pod1", "pod2", "process"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := names(tc.typeDef)
+			if len(got) != len(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+			for _, name := range tc.expected {
+				if !got[name] {
+					t.Errorf("expected %s in %v", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_GetRelationships(t *testing.T) {
+	leader, local, client := newReplica(t)
+	predicate, err := anypb.New(&emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("failed to create predicate: %v", err)
+	}
+	if err := leader.AddRelationships(&resourcev1.Relationship{
+		Subject:   ref(clusterType, "pod"),
+		Object:    ref(clusterType, "node"),
+		Predicate: predicate,
+	}); err != nil {
+		t.Fatalf("failed to add relationship: %v", err)
+	}
+	if err := local.AddRelationships(&resourcev1.Relationship{
+		Subject:   ref(localType, "process"),
+		Object:    ref(clusterType, "pod"),
+		Predicate: predicate,
+	}); err != nil {
+		t.Fatalf("failed to add relationship: %v", err)
+	}
+
+	rels, err := client.GetRelationships(nil, nil, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	if len(rels) != 2 {
+		t.Errorf("expected the local and remote relationships, got %v", rels)
+	}
+
+	rels, err = client.GetRelationships(ref(clusterType, "pod"), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	if len(rels) != 1 || rels[0].GetObject().GetName() != "node" {
+		t.Errorf("expected the remote relationship, got %v", rels)
+	}
+
+	_, err = client.GetRelationships(ref(clusterType, "missing"), nil, nil)
+	if !errors.Is(err, resource.ErrRelationshipsNotFound) {
+		t.Errorf("expected %v, got %v", resource.ErrRelationshipsNotFound, err)
+	}
+}
+
+func TestClient_Leader(t *testing.T) {
+	elected := false
+	_, local, client := newReplica(t, WithLeader(func() bool { return elected }))
+	if err := local.AddResource(newResource(clusterType, "pod")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	if _, err := client.GetResource(ref(clusterType, "pod")); !errors.Is(err, resource.ErrResourceNotFound) {
+		t.Errorf("expected the resource to be read from the leader, got %v", err)
+	}
+	elected = true
+	if _, err := client.GetResource(ref(clusterType, "pod")); err != nil {
+		t.Errorf("expected the resource to be read from the local store once elected, got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package replica shares the resource store of one agent with the others over gRPC.
+//
+// Only the elected agent of a DaemonSet indexes the cluster-scoped resources, such as
+// pods and nodes, so rather than every agent keeping a copy of the cluster inventory, the
+// elected agent serves its store with a Server and the others read the cluster-scoped
+// resources through a Client.
+//
+// The service is read only. It has no generated stubs: its methods exchange the messages
+// of the resource API, which gRPC encodes with its default protobuf codec.
+package replica
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+const serviceName = "antimetal.agent.store.v1.StoreReplica"
+
+const (
+	getResourceMethod      = "/" + serviceName + "/GetResource"
+	listResourcesMethod    = "/" + serviceName + "/ListResources"
+	getRelationshipsMethod = "/" + serviceName + "/GetRelationships"
+)
+
+// ServiceConfig is the gRPC service config of client connections to a Server. Dialing
+// all the agents, e.g. through the DNS name of a headless Service selecting their pods,
+// round_robin only sends calls to the agents that accept connections: the elected one.
+const ServiceConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// storeReplicaServer is the handler type of the service
+type storeReplicaServer interface {
+	getResource(ctx context.Context, ref *resourcev1.ResourceRef) (*resourcev1.Resource, error)
+	listResources(typeDef *resourcev1.TypeDescriptor, stream grpc.ServerStream) error
+	getRelationships(query *resourcev1.Relationship, stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*storeReplicaServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetResource", Handler: getResourceHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ListResources", Handler: listResourcesHandler, ServerStreams: true},
+		{StreamName: "GetRelationships", Handler: getRelationshipsHandler, ServerStreams: true},
+	},
+}
+
+func getResourceHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := &resourcev1.ResourceRef{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(storeReplicaServer).getResource(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: getResourceMethod}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(storeReplicaServer).getResource(ctx, req.(*resourcev1.ResourceRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func listResourcesHandler(srv any, stream grpc.ServerStream) error {
+	in := &resourcev1.TypeDescriptor{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(storeReplicaServer).listResources(in, stream)
+}
+
+func getRelationshipsHandler(srv any, stream grpc.ServerStream) error {
+	in := &resourcev1.Relationship{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(storeReplicaServer).getRelationships(in, stream)
+}
+
+// Server serves the reads of a store to Clients
+type Server struct {
+	store resource.Store
+}
+
+// NewServer returns a Server of store
+func NewServer(store resource.Store) *Server {
+	return &Server{store: store}
+}
+
+// Register registers the service on r, e.g. a grpc.Server serving other services
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&serviceDesc, s)
+}
+
+// ListenAndServe serves the store on the TCP address addr until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(ctx, lis)
+}
+
+// Serve serves the store on lis until ctx is done. Pending calls are completed before it
+// returns.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	srv := grpc.NewServer()
+	s.Register(srv)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+	select {
+	case <-ctx.Done():
+		srv.GracefulStop()
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (s *Server) getResource(_ context.Context, ref *resourcev1.ResourceRef) (*resourcev1.Resource, error) {
+	rsrc, err := s.store.GetResource(ref)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return rsrc, nil
+}
+
+func (s *Server) listResources(typeDef *resourcev1.TypeDescriptor, stream grpc.ServerStream) error {
+	if typeDef.GetKind() == "" && typeDef.GetType() == "" {
+		// Protobuf has no nil message, an empty descriptor matches any type
+		typeDef = nil
+	}
+	rsrcs, err := s.store.ListResources(typeDef)
+	if err != nil {
+		return toStatus(err)
+	}
+	for _, rsrc := range rsrcs {
+		if err := stream.SendMsg(rsrc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRelationships sends the relationships matching the subject, object and predicate
+// type of query. Unset fields match anything, like the nil arguments of
+// resource.Store.GetRelationships.
+func (s *Server) getRelationships(query *resourcev1.Relationship, stream grpc.ServerStream) error {
+	var predicateT proto.Message
+	if url := query.GetPredicate().GetTypeUrl(); url != "" {
+		mt, err := protoregistry.GlobalTypes.FindMessageByURL(typeurl.Normalize(url))
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "unknown predicate type %s", url)
+		}
+		predicateT = mt.New().Interface()
+	}
+	rels, err := s.store.GetRelationships(query.GetSubject(), query.GetObject(), predicateT)
+	if err != nil {
+		return toStatus(err)
+	}
+	for _, rel := range rels {
+		if err := stream.SendMsg(rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toStatus returns the gRPC status of the store error err
+func toStatus(err error) error {
+	if errors.Is(err, resource.ErrResourceNotFound) || errors.Is(err, resource.ErrRelationshipsNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,233 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"github.com/antimetal/agent/pkg/errors"
+)
+
+// metaCompressed is set in the badger user meta of resource values that are gzip compressed.
+const metaCompressed byte = 1 << 0
+
+// budgetCandidate is a key considered for compression or eviction
+type budgetCandidate struct {
+	key     []byte
+	size    int64
+	version uint64
+}
+
+// storedValue returns a copy of the encoded value stored in item, decompressing it if
+// needed.
+func storedValue(item *badger.Item) ([]byte, error) {
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if item.UserMeta()&metaCompressed == 0 {
+		return val, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(val))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress resource: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func compress(val []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(val); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// enforceBudget brings the store back under its size budget by first compressing the
+// largest resources and then evicting the oldest relationships.
+func (s *store) enforceBudget() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("store is closed")
+	}
+
+	size, err := s.size()
+	if err != nil {
+		return fmt.Errorf("failed to compute store size: %w", err)
+	}
+	sizeBytes.Set(float64(size))
+	if s.sizeBudget <= 0 || size <= s.sizeBudget {
+		return nil
+	}
+	budgetExceededTotal.Inc()
+
+	excess := size - s.sizeBudget
+	freed, err := s.compressResources(excess)
+	if err != nil {
+		return fmt.Errorf("failed to compress resources: %w", err)
+	}
+	if freed < excess {
+		evicted, err := s.evictRelationships(excess - freed)
+		if err != nil {
+			return fmt.Errorf("failed to evict relationships: %w", err)
+		}
+		freed += evicted
+	}
+	sizeBytes.Set(float64(size - freed))
+
+	if !s.inMemory {
+		// Reclaim the space of the rewritten and deleted values on disk
+		for {
+			if err := s.store.RunValueLogGC(0.5); err != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// size returns the estimated size of the keys and values in the store
+func (s *store) size() (int64, error) {
+	var size int64
+	err := s.store.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			size += it.Item().EstimatedSize()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// candidates returns the keys with prefix for which keep returns true
+func (s *store) candidates(prefix []byte, keep func(*badger.Item) bool) ([]budgetCandidate, error) {
+	var candidates []budgetCandidate
+	err := s.store.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if !keep(item) {
+				continue
+			}
+			candidates = append(candidates, budgetCandidate{
+				key:     item.KeyCopy(nil),
+				size:    item.EstimatedSize(),
+				version: item.Version(),
+			})
+		}
+		return nil
+	})
+	return candidates, err
+}
+
+// compressResources compresses uncompressed resources larger than the compression
+// threshold, largest first, until at least target bytes have been freed.
+// It returns the number of bytes freed.
+func (s *store) compressResources(target int64) (int64, error) {
+	candidates, err := s.candidates(append(buildKey(resourceKey), '/'), func(item *badger.Item) bool {
+		return compressible(item.UserMeta()) && item.ValueSize() > int64(s.compressionThreshold)
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].size > candidates[j].size
+	})
+
+	var freed int64
+	for _, c := range candidates {
+		if freed >= target {
+			break
+		}
+		var written uint64
+		err := s.store.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(c.key)
+			if err != nil {
+				return err
+			}
+			// Compressed as encoded, the codec tag is kept
+			val, err := storedValue(item)
+			if err != nil {
+				return err
+			}
+			compressed, err := compress(val)
+			if err != nil {
+				return err
+			}
+			if len(compressed) >= len(val) {
+				return nil
+			}
+			if err := txn.SetEntry(badger.NewEntry(c.key, compressed).WithMeta(item.UserMeta() | metaCompressed)); err != nil {
+				return err
+			}
+			written = item.Version()
+			freed += int64(len(val) - len(compressed))
+			compressedResourcesTotal.Inc()
+			return nil
+		})
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return freed, fmt.Errorf("failed to compress %s: %w", c.key, err)
+		}
+		if written != 0 {
+			// The rewrite bumped the version of the resource, which didn't change
+			s.history.rewritten(c.key, s.version(), written)
+		}
+	}
+	return freed, nil
+}
+
+// evictRelationships deletes the oldest relationships until at least target bytes have
+// been freed. Like DeleteRelationships, it emits their delete events and records their
+// tombstones, so that subscribers drop them too. It returns the number of bytes freed.
+func (s *store) evictRelationships(target int64) (int64, error) {
+	prefix := append(buildKey(relationshipKey), '/')
+	candidates, err := s.candidates(prefix, func(*badger.Item) bool { return true })
+	if err != nil {
+		return 0, err
+	}
+	// Badger versions are commit timestamps so the lowest version was written first
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].version < candidates[j].version
+	})
+
+	var freed int64
+	for _, c := range candidates {
+		if freed >= target {
+			break
+		}
+		deleted, err := s.deleteRelationships(c.key)
+		if err != nil {
+			return freed, fmt.Errorf("failed to evict relationship %x: %w", c.key[len(prefix):], err)
+		}
+		if deleted == 0 {
+			continue
+		}
+		// Each index shrinks by one object key as well
+		freed += c.size + 3*objKeySize
+		evictedRelationshipsTotal.Inc()
+	}
+	return freed, nil
+}
@@ -0,0 +1,316 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/resource"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func budgetTestResource(name string, specSize int) *resourcev1.Resource {
+	return &resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: name},
+		Spec: &anypb.Any{
+			TypeUrl: "type.googleapis.com/foo",
+			Value:   bytes.Repeat([]byte("spec"), specSize/4),
+		},
+	}
+}
+
+func budgetTestRelationship(t *testing.T, subject, object string) *resourcev1.Relationship {
+	predicate, err := anypb.New(&resourcev1.Resource{})
+	if err != nil {
+		t.Fatalf("failed to create predicate: %v", err)
+	}
+	return &resourcev1.Relationship{
+		Subject:   &resourcev1.ResourceRef{TypeUrl: "foo", Name: subject},
+		Object:    &resourcev1.ResourceRef{TypeUrl: "foo", Name: object},
+		Predicate: predicate,
+	}
+}
+
+// setBudget sets the budget to the current store size minus excess
+func setBudget(t *testing.T, s *store, excess int64) {
+	size, err := s.size()
+	if err != nil {
+		t.Fatalf("failed to get store size: %v", err)
+	}
+	s.sizeBudget = size - excess
+}
+
+func TestStore_EnforceBudget_UnderBudget(t *testing.T) {
+	inv, err := New(WithSizeBudget(1 << 30))
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	if err := inv.AddResource(budgetTestResource("big", 16<<10)); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	before, err := inv.size()
+	if err != nil {
+		t.Fatalf("failed to get store size: %v", err)
+	}
+	if err := inv.enforceBudget(); err != nil {
+		t.Fatalf("failed to enforce budget: %v", err)
+	}
+	after, err := inv.size()
+	if err != nil {
+		t.Fatalf("failed to get store size: %v", err)
+	}
+	if before != after {
+		t.Fatalf("expected store under budget to be unchanged, size went from %d to %d", before, after)
+	}
+}
+
+func TestStore_EnforceBudget_CompressesLargestResources(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	rsrcs := []*resourcev1.Resource{
+		budgetTestResource("small", 1<<10),
+		budgetTestResource("big", 64<<10),
+		budgetTestResource("bigger", 128<<10),
+	}
+	for _, rsrc := range rsrcs {
+		if err := inv.AddResource(rsrc); err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+	rel := budgetTestRelationship(t, "small", "big")
+	if err := inv.AddRelationships(rel); err != nil {
+		t.Fatalf("failed to add relationship: %v", err)
+	}
+
+	// Compressing the largest resource alone is enough
+	setBudget(t, inv, 1<<10)
+	if err := inv.enforceBudget(); err != nil {
+		t.Fatalf("failed to enforce budget: %v", err)
+	}
+
+	size, err := inv.size()
+	if err != nil {
+		t.Fatalf("failed to get store size: %v", err)
+	}
+	if size > inv.sizeBudget {
+		t.Fatalf("expected store size %d to be within budget %d", size, inv.sizeBudget)
+	}
+
+	err = inv.store.View(func(txn *badger.Txn) error {
+		for name, wantCompressed := range map[string]bool{"small": false, "big": false, "bigger": true} {
+			key, err := encodeResourceKey(&resourcev1.ResourceRef{TypeUrl: "foo", Name: name})
+			if err != nil {
+				return err
+			}
+			item, err := txn.Get(buildKey(resourceKey, []byte(key)))
+			if err != nil {
+				return err
+			}
+			if compressed := item.UserMeta()&metaCompressed != 0; compressed != wantCompressed {
+				return fmt.Errorf("resource %s compressed = %t, want %t", name, compressed, wantCompressed)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compression is transparent to readers
+	for _, want := range rsrcs {
+		got, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: "foo", Name: want.GetMetadata().GetName()})
+		if err != nil {
+			t.Fatalf("failed to get resource: %v", err)
+		}
+		if !proto.Equal(got, want) {
+			t.Fatalf("resource %s changed after compression", want.GetMetadata().GetName())
+		}
+	}
+	listed, err := inv.ListResources(nil)
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(listed) != len(rsrcs) {
+		t.Fatalf("expected %d resources, got %d", len(rsrcs), len(listed))
+	}
+
+	// Updates preserve the creation time of compressed resources
+	update := budgetTestResource("bigger", 8)
+	if err := inv.UpdateResource(update); err != nil {
+		t.Fatalf("failed to update resource: %v", err)
+	}
+	if !proto.Equal(update.GetMetadata().GetCreatedAt(), rsrcs[2].GetMetadata().GetCreatedAt()) {
+		t.Fatalf("expected created at %v to be preserved, got %v",
+			rsrcs[2].GetMetadata().GetCreatedAt(), update.GetMetadata().GetCreatedAt())
+	}
+
+	if rels, err := inv.ListRelationships(); err != nil || len(rels) != 1 {
+		t.Fatalf("expected relationship to be kept, got %v: %v", rels, err)
+	}
+}
+
+func TestStore_EnforceBudget_EvictsOldestRelationships(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	// Add the relationships one at a time so each has its own version
+	rels := []*resourcev1.Relationship{
+		budgetTestRelationship(t, "a", "b"),
+		budgetTestRelationship(t, "a", "c"),
+		budgetTestRelationship(t, "b", "c"),
+	}
+	for _, rel := range rels {
+		if err := inv.AddRelationships(rel); err != nil {
+			t.Fatalf("failed to add relationship: %v", err)
+		}
+	}
+
+	setBudget(t, inv, 1)
+	if err := inv.enforceBudget(); err != nil {
+		t.Fatalf("failed to enforce budget: %v", err)
+	}
+
+	got, err := inv.ListRelationships()
+	if err != nil {
+		t.Fatalf("failed to list relationships: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 relationships to remain, got %d", len(got))
+	}
+	for _, rel := range got {
+		if proto.Equal(rel, rels[0]) {
+			t.Fatalf("expected oldest relationship to be evicted")
+		}
+	}
+
+	// Indexes no longer reference the evicted relationship
+	_, err = inv.GetRelationships(rels[0].GetSubject(), rels[0].GetObject(), nil)
+	if !errors.Is(err, resource.ErrRelationshipsNotFound) {
+		t.Fatalf("expected error %v, got %v", resource.ErrRelationshipsNotFound, err)
+	}
+	fromA, err := inv.GetRelationships(rels[0].GetSubject(), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to get relationships of a: %v", err)
+	}
+	if len(fromA) != 1 || !proto.Equal(fromA[0], rels[1]) {
+		t.Fatalf("expected only %v from a, got %v", rels[1], fromA)
+	}
+}
+
+// nextEvent returns the next event of ch, failing the test unless one is sent within a
+// second
+func nextEvent(t *testing.T, ch <-chan resource.Event) resource.Event {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("expected an event")
+		return resource.Event{}
+	}
+}
+
+func TestStore_EnforceBudget_ResumeAfterCompression(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	if err := inv.AddResource(budgetTestResource("big", 16<<10)); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	ch := inv.Subscribe(nil)
+	token := nextEvent(t, ch).ResumeToken
+	inv.Unsubscribe(ch)
+
+	setBudget(t, inv, 1<<10)
+	if err := inv.enforceBudget(); err != nil {
+		t.Fatalf("failed to enforce budget: %v", err)
+	}
+	if err := inv.AddResource(budgetTestResource("small", 16)); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	// The compressed resource didn't change, so only the new one is sent
+	ch = inv.Subscribe(nil, resource.WithResumeToken(token))
+	defer inv.Unsubscribe(ch)
+	event := nextEvent(t, ch)
+	if event.Type != resource.EventTypeUpdate || len(event.Objs) != 1 {
+		t.Fatalf("expected the update of one resource, got %s of %d objects", event.Type, len(event.Objs))
+	}
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(event.Objs[0].GetObject().GetValue(), rsrc); err != nil {
+		t.Fatalf("failed to unmarshal resource: %v", err)
+	}
+	if name := rsrc.GetMetadata().GetName(); name != "small" {
+		t.Fatalf("expected only the resource added since to be sent, got %s", name)
+	}
+}
+
+func TestStore_EnforceBudget_EvictionDeletes(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	rels := []*resourcev1.Relationship{
+		budgetTestRelationship(t, "a", "b"),
+		budgetTestRelationship(t, "a", "c"),
+	}
+	for _, rel := range rels {
+		if err := inv.AddRelationships(rel); err != nil {
+			t.Fatalf("failed to add relationship: %v", err)
+		}
+	}
+	ch := inv.Subscribe(nil)
+	token := nextEvent(t, ch).ResumeToken
+
+	setBudget(t, inv, 1)
+	if err := inv.enforceBudget(); err != nil {
+		t.Fatalf("failed to enforce budget: %v", err)
+	}
+
+	isEvicted := func(event resource.Event) {
+		t.Helper()
+		if event.Type != resource.EventTypeDelete || len(event.Objs) != 1 {
+			t.Fatalf("expected the delete of one relationship, got %s of %d objects", event.Type, len(event.Objs))
+		}
+		rel := &resourcev1.Relationship{}
+		if err := proto.Unmarshal(event.Objs[0].GetObject().GetValue(), rel); err != nil {
+			t.Fatalf("failed to unmarshal relationship: %v", err)
+		}
+		if !proto.Equal(rel, rels[0]) {
+			t.Fatalf("expected the delete of the oldest relationship, got %v", rel)
+		}
+	}
+	// Subscribers receive the delete of the evicted relationship, or its tombstone when
+	// they resume
+	isEvicted(nextEvent(t, ch))
+	inv.Unsubscribe(ch)
+	ch = inv.Subscribe(nil, resource.WithResumeToken(token))
+	defer inv.Unsubscribe(ch)
+	isEvicted(nextEvent(t, ch))
+}
@@ -0,0 +1,184 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec is the encoding of the resources and relationships persisted in the store.
+//
+// Every value is tagged with the codec it was written with, so a store can be reopened
+// with another codec: values already written are still read with their own codec and
+// new writes use the new one.
+type Codec string
+
+const (
+	// CodecProto persists the protobuf wire encoding. It is the default.
+	CodecProto Codec = "proto"
+	// CodecProtoZstd persists the zstd compressed protobuf wire encoding, trading CPU for
+	// size.
+	CodecProtoZstd Codec = "proto+zstd"
+	// CodecJSON persists the protobuf JSON encoding so that the raw contents of the store
+	// can be read with badger's tools. It is larger and slower to encode, and meant for
+	// debugging. Values whose specs aren't registered protobuf messages, which protojson
+	// can't encode, are persisted with CodecProto.
+	CodecJSON Codec = "json"
+)
+
+// The codec of a value is tagged in bits 1-2 of its badger user meta. Bit 0 is
+// metaCompressed, set when the size budget gzips the encoded value. Values written
+// before codecs were tagged have none, which is CodecProto.
+const (
+	metaCodecShift = 1
+	metaCodecMask  = 0b11 << metaCodecShift
+)
+
+// valueCodec encodes values from their protobuf wire encoding and decodes them back to it,
+// which is what the store indexes and sends to subscribers
+type valueCodec interface {
+	// encode encodes msg, whose wire encoding is wire
+	encode(msg proto.Message, wire []byte) ([]byte, error)
+	// decode returns the wire encoding of data, an encoded message of the type of msg.
+	// msg may be overwritten.
+	decode(data []byte, msg proto.Message) ([]byte, error)
+}
+
+// codecs holds the codecs by the tag of the values written with them. Tags are persisted
+// and must never change.
+var codecs = [...]struct {
+	name  Codec
+	codec valueCodec
+	// compressed is set for codecs whose values the size budget can't compress further
+	compressed bool
+}{
+	0: {name: CodecProto, codec: protoCodec{}},
+	1: {name: CodecProtoZstd, codec: zstdCodec{}, compressed: true},
+	2: {name: CodecJSON, codec: jsonCodec{}},
+}
+
+// codecTag returns the tag of the values written with c
+func codecTag(c Codec) (byte, error) {
+	for tag, codec := range codecs {
+		if codec.name == c {
+			return byte(tag), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown store codec %q", c)
+}
+
+// setValue sets key to msg, whose wire encoding is wire, encoded with the store's codec
+func (s *store) setValue(txn *badger.Txn, key []byte, msg proto.Message, wire []byte) error {
+	if s.codec == 0 {
+		return txn.Set(key, wire)
+	}
+	val, err := codecs[s.codec].codec.encode(msg, wire)
+	if err != nil {
+		// Falls back to the wire encoding, tagged as such
+		return txn.Set(key, wire)
+	}
+	return txn.SetEntry(badger.NewEntry(key, val).WithMeta(s.codec << metaCodecShift))
+}
+
+// value returns the wire encoding of the value of item, a message of the type of msg,
+// decompressing and decoding it as tagged in its user meta
+func value(item *badger.Item, msg proto.Message) ([]byte, error) {
+	val, err := storedValue(item)
+	if err != nil {
+		return nil, err
+	}
+	tag := metaCodec(item.UserMeta())
+	if tag == 0 {
+		return val, nil
+	}
+	if tag >= len(codecs) {
+		return nil, fmt.Errorf("unknown codec tag %d", tag)
+	}
+	val, err = codecs[tag].codec.decode(val, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s value: %w", codecs[tag].name, err)
+	}
+	return val, nil
+}
+
+// metaCodec returns the codec tag of a value from its user meta
+func metaCodec(meta byte) int {
+	return int(meta&metaCodecMask) >> metaCodecShift
+}
+
+// compressible returns whether the size budget can compress a value with user meta meta
+func compressible(meta byte) bool {
+	if meta&metaCompressed != 0 {
+		return false
+	}
+	tag := metaCodec(meta)
+	return tag < len(codecs) && !codecs[tag].compressed
+}
+
+// resourceValue returns the wire encoding of the resource stored in item
+func resourceValue(item *badger.Item) ([]byte, error) {
+	return value(item, &resourcev1.Resource{})
+}
+
+// relationshipValue returns the wire encoding of the relationship stored in item
+func relationshipValue(item *badger.Item) ([]byte, error) {
+	return value(item, &resourcev1.Relationship{})
+}
+
+type protoCodec struct{}
+
+func (protoCodec) encode(_ proto.Message, wire []byte) ([]byte, error) {
+	return wire, nil
+}
+
+func (protoCodec) decode(data []byte, _ proto.Message) ([]byte, error) {
+	return data, nil
+}
+
+type zstdCodec struct{}
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+func (zstdCodec) encode(_ proto.Message, wire []byte) ([]byte, error) {
+	enc, err := zstdEncoder()
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(wire, nil), nil
+}
+
+func (zstdCodec) decode(data []byte, _ proto.Message) ([]byte, error) {
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(data, nil)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) encode(msg proto.Message, _ []byte) ([]byte, error) {
+	return protojson.Marshal(msg)
+}
+
+func (jsonCodec) decode(data []byte, msg proto.Message) ([]byte, error) {
+	proto.Reset(msg)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
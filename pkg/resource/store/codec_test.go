@@ -0,0 +1,214 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// codecTestResource returns a resource whose spec is a registered message, so that it can
+// be encoded with every codec
+func codecTestResource(t *testing.T, name string) *resourcev1.Resource {
+	spec, err := anypb.New(wrapperspb.String(string(bytes.Repeat([]byte("spec"), 256))))
+	if err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	return &resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: name},
+		Spec:     spec,
+	}
+}
+
+// rawValues returns the user meta and raw value of every resource and relationship
+func rawValues(t *testing.T, s *store) map[string]struct {
+	meta byte
+	val  []byte
+} {
+	t.Helper()
+	values := make(map[string]struct {
+		meta byte
+		val  []byte
+	})
+	err := s.store.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for _, prefix := range [][]byte{buildKey(resourceKey), buildKey(relationshipKey)} {
+			prefix = append(prefix, '/')
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				val, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				values[string(it.Item().KeyCopy(nil))] = struct {
+					meta byte
+					val  []byte
+				}{it.Item().UserMeta(), val}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read raw values: %v", err)
+	}
+	return values
+}
+
+func TestStore_Codecs(t *testing.T) {
+	for _, codec := range []Codec{CodecProto, CodecProtoZstd, CodecJSON} {
+		t.Run(string(codec), func(t *testing.T) {
+			inv, err := New(WithCodec(codec))
+			if err != nil {
+				t.Fatalf("failed to create inventory: %v", err)
+			}
+			defer inv.Close()
+
+			rsrc := codecTestResource(t, "a")
+			if err := inv.AddResource(rsrc); err != nil {
+				t.Fatalf("failed to add resource: %v", err)
+			}
+			rel := budgetTestRelationship(t, "a", "b")
+			if err := inv.AddRelationships(rel); err != nil {
+				t.Fatalf("failed to add relationship: %v", err)
+			}
+
+			tag, _ := codecTag(codec)
+			raw := rawValues(t, inv)
+			if len(raw) != 2 {
+				t.Fatalf("expected 2 values, got %d", len(raw))
+			}
+			for key, v := range raw {
+				if got := metaCodec(v.meta); got != int(tag) {
+					t.Errorf("%s: expected codec tag %d, got %d", key, tag, got)
+				}
+				if codec == CodecJSON && !protojson.Valid(v.val) {
+					t.Errorf("%s: expected a JSON value, got %q", key, v.val)
+				}
+			}
+
+			got, err := inv.GetResource(ref(rsrc))
+			if err != nil {
+				t.Fatalf("failed to get resource: %v", err)
+			}
+			if !proto.Equal(got, rsrc) {
+				t.Fatalf("expected %v, got %v", rsrc, got)
+			}
+			rels, err := inv.GetRelationships(rel.GetSubject(), nil, nil)
+			if err != nil {
+				t.Fatalf("failed to get relationships: %v", err)
+			}
+			if len(rels) != 1 || !proto.Equal(rels[0], rel) {
+				t.Fatalf("expected %v, got %v", rel, rels)
+			}
+		})
+	}
+}
+
+func TestStore_CodecJSONFallsBackToProto(t *testing.T) {
+	inv, err := New(WithCodec(CodecJSON))
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	// The spec isn't a registered message, protojson can't encode it
+	rsrc := budgetTestResource("a", 64)
+	if err := inv.AddResource(rsrc); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	for key, v := range rawValues(t, inv) {
+		if v.meta != 0 {
+			t.Errorf("%s: expected an untagged proto value, got user meta %b", key, v.meta)
+		}
+	}
+	got, err := inv.GetResource(ref(rsrc))
+	if err != nil {
+		t.Fatalf("failed to get resource: %v", err)
+	}
+	if !proto.Equal(got, rsrc) {
+		t.Fatalf("expected %v, got %v", rsrc, got)
+	}
+}
+
+func TestStore_ReopenWithAnotherCodec(t *testing.T) {
+	dir := t.TempDir()
+	inv, err := New(WithDataDir(dir), WithCodec(CodecProtoZstd))
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	if err := inv.AddResource(codecTestResource(t, "a")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	if err := inv.Close(); err != nil {
+		t.Fatalf("failed to close inventory: %v", err)
+	}
+
+	inv, err = New(WithDataDir(dir), WithCodec(CodecJSON))
+	if err != nil {
+		t.Fatalf("failed to reopen inventory: %v", err)
+	}
+	defer inv.Close()
+	if err := inv.AddResource(codecTestResource(t, "b")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	rsrcs, err := inv.ListResources(nil)
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(rsrcs) != 2 {
+		t.Fatalf("expected the resources written with both codecs, got %d", len(rsrcs))
+	}
+	for _, r := range rsrcs {
+		want := codecTestResource(t, r.GetMetadata().GetName())
+		if !proto.Equal(r.GetSpec(), want.GetSpec()) {
+			t.Errorf("%s: expected spec %v, got %v", r.GetMetadata().GetName(), want.GetSpec(), r.GetSpec())
+		}
+	}
+}
+
+func TestStore_CompressKeepsCodecTag(t *testing.T) {
+	inv, err := New(WithCodec(CodecJSON), WithCompressionThreshold(64))
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	rsrc := codecTestResource(t, "a")
+	if err := inv.AddResource(rsrc); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	if _, err := inv.compressResources(1); err != nil {
+		t.Fatalf("failed to compress resources: %v", err)
+	}
+	for key, v := range rawValues(t, inv) {
+		tag, _ := codecTag(CodecJSON)
+		if v.meta&metaCompressed == 0 || metaCodec(v.meta) != int(tag) {
+			t.Errorf("%s: expected a compressed JSON value, got user meta %b", key, v.meta)
+		}
+	}
+	got, err := inv.GetResource(ref(rsrc))
+	if err != nil {
+		t.Fatalf("failed to get resource: %v", err)
+	}
+	if !proto.Equal(got, rsrc) {
+		t.Fatalf("expected %v, got %v", rsrc, got)
+	}
+}
+
+func TestNew_UnknownCodec(t *testing.T) {
+	if _, err := New(WithCodec("xml")); err == nil {
+		t.Fatalf("expected error for an unknown codec")
+	}
+}
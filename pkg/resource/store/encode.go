@@ -38,34 +38,41 @@ func encodeResourceKey(r *resourcev1.ResourceRef) (string, error) {
 		return "", fmt.Errorf("missing type")
 	}
 
-	var name string
+	obj := base64.URLEncoding.EncodeToString([]byte(qualifiedName(r)))
+	return fmt.Sprintf("%s/%s", r.GetTypeUrl(), obj), nil
+}
 
-	ns := r.GetNamespace()
-	if ns == nil {
-		name = r.Name
-	} else {
-		switch ns := ns.GetNamespace().(type) {
-		case *resourcev1.Namespace_Cloud:
-			name = fmt.Sprintf("%s/%s/%s/%s/%s",
-				cloudNs,
-				ns.Cloud.GetAccount().GetAccountId(),
-				ns.Cloud.GetRegion(),
-				ns.Cloud.GetGroup(),
-				r.Name,
-			)
-		case *resourcev1.Namespace_Kube:
-			name = fmt.Sprintf("%s/%s/%s/%s",
-				kubeNs,
-				ns.Kube.GetCluster(),
-				ns.Kube.GetNamespace(),
-				r.Name,
-			)
-		default:
-			name = r.Name
-		}
+// qualifiedName returns the name of r prefixed by its namespace, as encoded in its key.
+func qualifiedName(r *resourcev1.ResourceRef) string {
+	switch ns := r.GetNamespace().GetNamespace().(type) {
+	case *resourcev1.Namespace_Cloud:
+		return fmt.Sprintf("%s/%s/%s/%s/%s",
+			cloudNs,
+			ns.Cloud.GetAccount().GetAccountId(),
+			ns.Cloud.GetRegion(),
+			ns.Cloud.GetGroup(),
+			r.Name,
+		)
+	case *resourcev1.Namespace_Kube:
+		return fmt.Sprintf("%s/%s/%s/%s",
+			kubeNs,
+			ns.Kube.GetCluster(),
+			ns.Kube.GetNamespace(),
+			r.Name,
+		)
+	default:
+		return r.Name
 	}
-	obj := base64.URLEncoding.EncodeToString([]byte(name))
-	return fmt.Sprintf("%s/%s", r.GetTypeUrl(), obj), nil
+}
+
+// encodeNamespaceKeyPrefix returns the prefix of the keys of the resources of typeURL in ns,
+// and the prefix of their qualified names. Names are base64 encoded by groups of 3 bytes, so
+// the key prefix only covers the whole groups of the name prefix: the names of the keys
+// sharing it have to be checked against the name prefix.
+func encodeNamespaceKeyPrefix(typeURL string, ns *resourcev1.Namespace) (keyPrefix, namePrefix string) {
+	namePrefix = qualifiedName(&resourcev1.ResourceRef{TypeUrl: typeURL, Namespace: ns})
+	whole := len(namePrefix) / 3 * 3
+	return fmt.Sprintf("%s/%s", typeURL, base64.URLEncoding.EncodeToString([]byte(namePrefix[:whole]))), namePrefix
 }
 
 // decodeResourceKey decodes the ResourceRef from string format.
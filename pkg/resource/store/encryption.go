@@ -0,0 +1,102 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"github.com/antimetal/agent/pkg/errors"
+)
+
+// EncryptionKeyEnv is the environment variable LoadEncryptionKey falls back to when no
+// key file is given. It holds the base64 encoded key so that it can be injected from a
+// KMS backed secret store (e.g. External Secrets or the Secrets Store CSI driver).
+const EncryptionKeyEnv = "ANTIMETAL_STORE_ENCRYPTION_KEY"
+
+// LoadEncryptionKey loads a store encryption key from file, or from the EncryptionKeyEnv
+// environment variable if file is empty. The key file may contain either the raw key
+// bytes or the base64 encoded key.
+// It returns a nil key if neither a file nor the environment variable is set.
+func LoadEncryptionKey(file string) ([]byte, error) {
+	if file == "" {
+		encoded, ok := os.LookupEnv(EncryptionKeyEnv)
+		if !ok || encoded == "" {
+			return nil, nil
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", EncryptionKeyEnv, err)
+		}
+		return key, validateEncryptionKey(key)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+	}
+	if err := validateEncryptionKey(data); err == nil {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key file %s is neither a raw nor a base64 encoded key", file)
+	}
+	return key, validateEncryptionKey(key)
+}
+
+func validateEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid encryption key length %d: must be 16, 24 or 32 bytes", len(key))
+	}
+}
+
+// rotateMasterKey re-encrypts the key registry in dir from oldKey to newKey if it is
+// not already encrypted with newKey. The data keys themselves are unchanged so no
+// data has to be rewritten.
+func rotateMasterKey(dir string, oldKey, newKey []byte, dataKeyRotation time.Duration) error {
+	if _, err := os.Stat(filepath.Join(dir, badger.KeyRegistryFileName)); os.IsNotExist(err) {
+		return nil
+	}
+
+	opts := badger.KeyRegistryOptions{
+		Dir:                           dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 newKey,
+		EncryptionKeyRotationDuration: dataKeyRotation,
+	}
+	kr, err := badger.OpenKeyRegistry(opts)
+	if err == nil {
+		// Already rotated
+		return kr.Close()
+	}
+	if !errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return fmt.Errorf("failed to open key registry: %w", err)
+	}
+
+	opts.EncryptionKey = oldKey
+	kr, err = badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open key registry with previous key: %w", err)
+	}
+	defer kr.Close()
+
+	opts.EncryptionKey = newKey
+	if err := badger.WriteKeyRegistry(kr, opts); err != nil {
+		return fmt.Errorf("failed to write key registry: %w", err)
+	}
+	return nil
+}
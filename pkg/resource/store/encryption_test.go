@@ -0,0 +1,144 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+)
+
+var (
+	testKey     = bytes.Repeat([]byte{0x01}, 32)
+	testNewKey  = bytes.Repeat([]byte{0x02}, 32)
+	testRsrcRef = &resourcev1.ResourceRef{TypeUrl: "foo", Name: "secret"}
+)
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	rawFile := filepath.Join(dir, "raw")
+	b64File := filepath.Join(dir, "b64")
+	badFile := filepath.Join(dir, "bad")
+	if err := os.WriteFile(rawFile, testKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b64File, []byte(base64.StdEncoding.EncodeToString(testKey)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(badFile, []byte("too short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		env     string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "raw key file", file: rawFile, want: testKey},
+		{name: "base64 key file", file: b64File, want: testKey},
+		{name: "invalid key file", file: badFile, wantErr: true},
+		{name: "missing key file", file: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "env", env: base64.StdEncoding.EncodeToString(testKey), want: testKey},
+		{name: "env invalid length", env: base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{name: "file takes precedence over env", file: rawFile, env: "not base64", want: testKey},
+		{name: "no key", want: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(EncryptionKeyEnv, tc.env)
+			key, err := LoadEncryptionKey(tc.file)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(key, tc.want) {
+				t.Fatalf("expected key %x, got %x", tc.want, key)
+			}
+		})
+	}
+}
+
+func addTestResource(t *testing.T, opts ...Option) {
+	inv, err := New(opts...)
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	err = inv.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Type: testRsrcRef.TypeUrl},
+		Metadata: &resourcev1.ResourceMeta{Name: testRsrcRef.Name},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+}
+
+func assertTestResource(t *testing.T, opts ...Option) {
+	inv, err := New(opts...)
+	if err != nil {
+		t.Fatalf("failed to reopen inventory: %v", err)
+	}
+	defer inv.Close()
+
+	if _, err := inv.GetResource(testRsrcRef); err != nil {
+		t.Fatalf("failed to get resource after reopen: %v", err)
+	}
+}
+
+func TestStore_EncryptionAtRest(t *testing.T) {
+	dir := t.TempDir()
+	addTestResource(t, WithDataDir(dir), WithEncryptionKey(testKey))
+	assertTestResource(t, WithDataDir(dir), WithEncryptionKey(testKey))
+
+	if _, err := New(WithDataDir(dir), WithEncryptionKey(testNewKey)); err == nil {
+		t.Fatalf("expected error opening store with the wrong key")
+	}
+	if _, err := New(WithDataDir(dir)); err == nil {
+		t.Fatalf("expected error opening encrypted store without a key")
+	}
+}
+
+func TestStore_EncryptionInvalidKey(t *testing.T) {
+	if _, err := New(WithEncryptionKey([]byte("short"))); err == nil {
+		t.Fatalf("expected error for invalid key length")
+	}
+}
+
+func TestStore_EncryptionKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	addTestResource(t, WithDataDir(dir), WithEncryptionKey(testKey))
+
+	rotated := []Option{WithDataDir(dir), WithEncryptionKey(testNewKey), WithPreviousEncryptionKey(testKey)}
+	assertTestResource(t, rotated...)
+	// Rotation is idempotent: the previous key is ignored once the registry uses the new key
+	assertTestResource(t, rotated...)
+	assertTestResource(t, WithDataDir(dir), WithEncryptionKey(testNewKey))
+
+	if _, err := New(WithDataDir(dir), WithEncryptionKey(testKey)); err == nil {
+		t.Fatalf("expected error opening store with the old key after rotation")
+	}
+}
+
+func TestStore_EncryptionKeyRotationFreshDir(t *testing.T) {
+	// Rotation with a previous key must not fail when there is no existing store
+	dir := t.TempDir()
+	addTestResource(t, WithDataDir(dir), WithEncryptionKey(testNewKey), WithPreviousEncryptionKey(testKey))
+	assertTestResource(t, WithDataDir(dir), WithEncryptionKey(testNewKey))
+}
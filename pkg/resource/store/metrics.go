@@ -0,0 +1,60 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	sizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "antimetal_store_size_bytes",
+		Help: "Size of the keys and values in the resource store at the last budget check.",
+	})
+	sizeBudgetBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "antimetal_store_size_budget_bytes",
+		Help: "Configured size budget of the resource store. 0 if unlimited.",
+	})
+	budgetExceededTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "antimetal_store_budget_exceeded_total",
+		Help: "Number of budget checks that found the resource store over its size budget.",
+	})
+	compressedResourcesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "antimetal_store_compressed_resources_total",
+		Help: "Number of resources compressed to bring the resource store under its size budget.",
+	})
+	evictedRelationshipsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "antimetal_store_evicted_relationships_total",
+		Help: "Number of relationships evicted to bring the resource store under its size budget.",
+	})
+	subscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "antimetal_store_subscribers",
+		Help: "Number of open resource store subscriptions.",
+	})
+	subscriberStallsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "antimetal_store_subscriber_stalls_total",
+		Help: "Number of times a resource store subscriber did not drain an event within the stall timeout.",
+	})
+	eventQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "antimetal_store_event_queue_length",
+		Help: "Number of resource store events waiting to be delivered to subscribers.",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		sizeBytes,
+		sizeBudgetBytes,
+		budgetExceededTotal,
+		compressedResourcesTotal,
+		evictedRelationshipsTotal,
+		subscribers,
+		subscriberStallsTotal,
+		eventQueueLength,
+	)
+}
@@ -0,0 +1,71 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"fmt"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// migrateTypeURLs rewrites the specs of resources persisted by older agents, which set
+// the bare type name of Kubernetes objects as type URL, to the full type URL.
+// It returns the number of resources migrated.
+func migrateTypeURLs(db *badger.DB) (int, error) {
+	type migrated struct {
+		key []byte
+		val []byte
+	}
+	var pending []migrated
+	err := db.View(func(txn *badger.Txn) error {
+		prefix := append(buildKey(resourceKey), '/')
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := resourceValue(it.Item())
+			if err != nil {
+				return err
+			}
+			rsrc := &resourcev1.Resource{}
+			if err := proto.Unmarshal(val, rsrc); err != nil {
+				return fmt.Errorf("failed to unmarshal resource: %w", err)
+			}
+			url := rsrc.GetSpec().GetTypeUrl()
+			if url == typeurl.Normalize(url) {
+				continue
+			}
+			rsrc.Spec.TypeUrl = typeurl.Normalize(url)
+			// Written back uncompressed with CodecProto, the size budget compresses it
+			// again if needed and the next update encodes it with the store's codec
+			val, err = proto.Marshal(rsrc)
+			if err != nil {
+				return fmt.Errorf("failed to marshal resource: %w", err)
+			}
+			pending = append(pending, migrated{key: it.Item().KeyCopy(nil), val: val})
+		}
+		return nil
+	})
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, m := range pending {
+		if err := wb.Set(m.key, m.val); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return len(pending), nil
+}
@@ -0,0 +1,65 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"testing"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestStore_MigrateTypeURLs(t *testing.T) {
+	dir := t.TempDir()
+	inv, err := New(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	specs := map[string]string{
+		"legacy":  "k8s.io.api.core.v1.Pod",
+		"current": "type.googleapis.com/k8s.io.api.core.v1.Pod",
+		"empty":   "",
+	}
+	for name, url := range specs {
+		rsrc := &resourcev1.Resource{
+			Type:     &resourcev1.TypeDescriptor{Type: "k8s.io.api.core.v1.Pod"},
+			Metadata: &resourcev1.ResourceMeta{Name: name},
+		}
+		if url != "" {
+			rsrc.Spec = &anypb.Any{TypeUrl: url, Value: []byte(name)}
+		}
+		if err := inv.AddResource(rsrc); err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+	if err := inv.Close(); err != nil {
+		t.Fatalf("failed to close inventory: %v", err)
+	}
+
+	inv, err = New(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("failed to reopen inventory: %v", err)
+	}
+	defer inv.Close()
+	want := map[string]string{
+		"legacy":  "type.googleapis.com/k8s.io.api.core.v1.Pod",
+		"current": "type.googleapis.com/k8s.io.api.core.v1.Pod",
+		"empty":   "",
+	}
+	for name, url := range want {
+		rsrc, err := inv.GetResource(&resourcev1.ResourceRef{TypeUrl: "k8s.io.api.core.v1.Pod", Name: name})
+		if err != nil {
+			t.Fatalf("failed to get resource %s: %v", name, err)
+		}
+		if got := rsrc.GetSpec().GetTypeUrl(); got != url {
+			t.Errorf("expected type URL %q for %s, got %q", url, name, got)
+		}
+		if url != "" && string(rsrc.GetSpec().GetValue()) != name {
+			t.Errorf("expected spec of %s to be kept, got %q", name, rsrc.GetSpec().GetValue())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// defaultIndexCacheSize is the block index cache used when encryption is enabled.
+	// Badger has to decrypt table indices on every read without it.
+	defaultIndexCacheSize = 64 << 20
+
+	// defaultCompressionThreshold is the encoded size above which resources are compressed
+	// when the store is over its size budget.
+	defaultCompressionThreshold = 4 << 10
+
+	// defaultBudgetCheckInterval is how often the size budget is enforced.
+	defaultBudgetCheckInterval = time.Minute
+
+	// defaultSubscriberStallTimeout is how long a subscriber can leave an event undrained
+	// before a warning is logged.
+	defaultSubscriberStallTimeout = time.Minute
+)
+
+type options struct {
+	dataDir                string
+	encryptionKey          []byte
+	previousEncryptionKey  []byte
+	dataKeyRotation        time.Duration
+	readOnly               bool
+	sizeBudget             int64
+	compressionThreshold   int
+	budgetCheckInterval    time.Duration
+	logger                 logr.Logger
+	subscriberStallTimeout time.Duration
+	codec                  Codec
+}
+
+// Option configures a store created with New.
+type Option func(*options)
+
+// WithDataDir persists the store to dir instead of keeping it in memory.
+func WithDataDir(dir string) Option {
+	return func(o *options) {
+		o.dataDir = dir
+	}
+}
+
+// WithEncryptionKey enables AES encryption at rest with key, which must be 16, 24 or
+// 32 bytes long to select AES-128, AES-192 or AES-256.
+//
+// key is the master key that encrypts badger's data keys. The data keys are what
+// actually encrypt the tables and value logs, and are rotated automatically
+// (see WithDataKeyRotation).
+func WithEncryptionKey(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// WithPreviousEncryptionKey rotates the master key of an existing persistent store.
+// If the store in the data dir can't be opened with the key set by WithEncryptionKey,
+// its key registry is re-encrypted from key to the new key before opening. Once
+// rotated, the previous key is no longer needed and the option is a no-op.
+func WithPreviousEncryptionKey(key []byte) Option {
+	return func(o *options) {
+		o.previousEncryptionKey = key
+	}
+}
+
+// WithDataKeyRotation sets how often a new data key is generated. Defaults to badger's
+// default of 10 days.
+func WithDataKeyRotation(d time.Duration) Option {
+	return func(o *options) {
+		o.dataKeyRotation = d
+	}
+}
+
+// WithReadOnly opens a persistent store in read-only mode, e.g. to inspect the data dir of
+// a stopped agent. Writes to a read-only store fail.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithSizeBudget limits the size of the keys and values held by the store to budget bytes.
+// The budget is checked periodically while the store is running (see Start), so it can be
+// exceeded in between checks. A budget <= 0, the default, disables the limit.
+//
+// When over budget the store first compresses the largest resources and then evicts the
+// oldest relationships until it is back under budget. Evicted relationships are only
+// dropped locally; no delete events are sent to subscribers.
+func WithSizeBudget(budget int64) Option {
+	return func(o *options) {
+		o.sizeBudget = budget
+	}
+}
+
+// WithCompressionThreshold sets the encoded size in bytes above which resources are
+// compressed when the store is over budget. Defaults to 4KiB.
+func WithCompressionThreshold(size int) Option {
+	return func(o *options) {
+		o.compressionThreshold = size
+	}
+}
+
+// WithBudgetCheckInterval sets how often the size budget is enforced. Defaults to 1 minute.
+func WithBudgetCheckInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.budgetCheckInterval = d
+	}
+}
+
+// WithLogger sets the logger used to report stalled subscribers and migrations. Defaults to
+// discarding logs.
+func WithLogger(logger logr.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithSubscriberStallTimeout sets how long a subscriber can leave an event undrained before a
+// warning is logged. The warning repeats every timeout until the event is received or the
+// subscriber unsubscribes. A timeout <= 0 disables the warnings. Defaults to 1 minute.
+func WithSubscriberStallTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.subscriberStallTimeout = d
+	}
+}
+
+// WithCodec sets the encoding of the resources and relationships persisted in the store.
+// Defaults to CodecProto. Values are tagged with their codec, so a persistent store can be
+// reopened with a different codec.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+)
+
+// defaultMaxTombstones is the number of resource deletes remembered to resume
+// subscriptions. Subscriptions can't be resumed from before the oldest.
+const defaultMaxTombstones = 10000
+
+// tombstone is a deleted resource remembered to resume subscriptions
+type tombstone struct {
+	version uint64
+	obj     *resourcev1.Object
+}
+
+// rewrite is a rewrite of a value that didn't change it, e.g. compressing it
+type rewrite struct {
+	version uint64
+	// written is the version of the value rewritten
+	written uint64
+}
+
+// history is what the store remembers to resume subscriptions. Writes are versioned by
+// the badger commit timestamp, which also versions every key, so the objects written
+// since a version are found by iterating from it. Deletes leave no key behind and are
+// remembered as tombstones, in memory: tokens of a previous run of the store, whose
+// epoch differs, can't be resumed from. Rewrites of unchanged values bump their version
+// too and are remembered so that they aren't sent as changes.
+//
+// history is guarded by the mutex of the store.
+type history struct {
+	epoch      string
+	tombstones map[string]tombstone
+	// order holds the keys of tombstones, oldest first. A key whose resource was written
+	// again after its delete stays in order until it is compacted.
+	order []string
+	// compacted is the version of the newest forgotten tombstone
+	compacted uint64
+	max       int
+	// rewrites holds the last rewrite of the keys whose value is unchanged since
+	rewrites map[string]rewrite
+}
+
+func newHistory(maxTombstones int) *history {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return &history{
+		epoch:      hex.EncodeToString(b),
+		tombstones: make(map[string]tombstone),
+		max:        maxTombstones,
+		rewrites:   make(map[string]rewrite),
+	}
+}
+
+// token returns the resume token of version
+func (h *history) token(version uint64) string {
+	return h.epoch + "." + strconv.FormatUint(version, 10)
+}
+
+// resumeVersion returns the version of the oldest of tokens, and whether the changes
+// since all of them are known
+func (h *history) resumeVersion(tokens []string) (uint64, bool) {
+	var oldest uint64
+	for i, token := range tokens {
+		epoch, v, ok := strings.Cut(token, ".")
+		if !ok || epoch != h.epoch {
+			return 0, false
+		}
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || version < h.compacted {
+			return 0, false
+		}
+		if i == 0 || version < oldest {
+			oldest = version
+		}
+	}
+	return oldest, len(tokens) > 0
+}
+
+// written forgets the delete of the resource at key, written again, and its rewrites
+func (h *history) written(key []byte) {
+	delete(h.tombstones, string(key))
+	delete(h.rewrites, string(key))
+}
+
+// rewritten remembers that the value at key, written in version written, was rewritten
+// unchanged in version
+func (h *history) rewritten(key []byte, version, written uint64) {
+	if prev, ok := h.rewrites[string(key)]; ok && prev.version == written {
+		written = prev.written
+	}
+	h.rewrites[string(key)] = rewrite{version: version, written: written}
+}
+
+// changedSince returns whether the value at key, whose item has version, changed after
+// version since
+func (h *history) changedSince(key []byte, version, since uint64) bool {
+	if r, ok := h.rewrites[string(key)]; ok && r.version == version {
+		return r.written > since
+	}
+	return version > since
+}
+
+// deleted remembers the delete of the resource at key in version, obj being its delete
+// event object. The oldest tombstones are forgotten past max.
+func (h *history) deleted(key []byte, version uint64, obj *resourcev1.Object) {
+	delete(h.rewrites, string(key))
+	h.tombstones[string(key)] = tombstone{version: version, obj: obj}
+	h.order = append(h.order, string(key))
+	for len(h.tombstones) > h.max && len(h.order) > 0 {
+		oldest := h.order[0]
+		h.order = h.order[1:]
+		if t, ok := h.tombstones[oldest]; ok {
+			h.compacted = max(h.compacted, t.version)
+			delete(h.tombstones, oldest)
+		}
+	}
+	// Keys written again after their delete accumulate in order otherwise
+	if len(h.order) > 2*h.max {
+		h.order = slices.DeleteFunc(h.order, func(key string) bool {
+			_, ok := h.tombstones[key]
+			return !ok
+		})
+	}
+}
+
+// since returns the objects of the deletes after version, oldest first
+func (h *history) since(version uint64) []*resourcev1.Object {
+	seen := make(map[string]bool)
+	objs := make([]*resourcev1.Object, 0)
+	for _, key := range h.order {
+		t, ok := h.tombstones[key]
+		if !ok || t.version <= version || seen[key] {
+			continue
+		}
+		seen[key] = true
+		objs = append(objs, &resourcev1.Object{
+			Type: t.obj.GetType(),
+			Object: &anypb.Any{
+				TypeUrl: t.obj.GetObject().GetTypeUrl(),
+				Value:   bytes.Clone(t.obj.GetObject().GetValue()),
+			},
+		})
+	}
+	return objs
+}
+
+// version returns the version of the store, that of the last committed write. s.mu must
+// be held to get the version of a write of the caller.
+func (s *store) version() uint64 {
+	var version uint64
+	_ = s.store.View(func(txn *badger.Txn) error {
+		version = txn.ReadTs()
+		return nil
+	})
+	return version
+}
+
+// sendChanges sends the changes since the oldest of o.ResumeTokens to subscriber: the
+// deletes since, followed by the objects written since, except those only rewritten
+// unchanged. Deletes go first so that a resource deleted and written again is received
+// in its current state. It returns false without sending anything if the store can't
+// resume from the tokens.
+func (s *store) sendChanges(subscriber *subscriber, o *resource.SubscribeOptions) bool {
+	s.mu.RLock()
+	since, ok := s.history.resumeVersion(o.ResumeTokens)
+	if !ok {
+		s.mu.RUnlock()
+		return false
+	}
+	deletes := slices.DeleteFunc(s.history.since(since), func(obj *resourcev1.Object) bool {
+		return !o.MatchesInitialListTypes(obj.GetType())
+	})
+
+	rsrcs := make([]*resourcev1.Object, 0)
+	rels := make([]*resourcev1.Object, 0)
+	var version uint64
+	_ = s.store.View(func(txn *badger.Txn) error {
+		version = txn.ReadTs()
+		opts := badger.DefaultIteratorOptions
+		opts.SinceTs = since
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(buildKey(resourceKey)); it.ValidForPrefix(buildKey(resourceKey)); it.Next() {
+			if !s.history.changedSince(it.Item().Key(), it.Item().Version(), since) {
+				continue
+			}
+			val, err := resourceValue(it.Item())
+			if err != nil {
+				continue
+			}
+			r := &resourcev1.Resource{}
+			if err := proto.Unmarshal(val, r); err != nil {
+				continue
+			}
+			if !o.MatchesInitialListTypes(r.GetType()) {
+				continue
+			}
+			rsrcs = append(rsrcs, &resourcev1.Object{
+				Type: r.GetType(),
+				Object: &anypb.Any{
+					TypeUrl: typeurl.FromName(r.GetType().GetType()),
+					Value:   val,
+				},
+			})
+		}
+		for it.Seek(buildKey(relationshipKey)); it.ValidForPrefix(buildKey(relationshipKey)); it.Next() {
+			val, err := relationshipValue(it.Item())
+			if err != nil {
+				continue
+			}
+			rel := &resourcev1.Relationship{}
+			if err := proto.Unmarshal(val, rel); err != nil {
+				continue
+			}
+			if !o.MatchesInitialListTypes(rel.GetType()) {
+				continue
+			}
+			rels = append(rels, &resourcev1.Object{
+				Type:   rel.GetType(),
+				Object: &anypb.Any{Value: val},
+			})
+		}
+		return nil
+	})
+	s.mu.RUnlock()
+
+	token := s.history.token(version)
+	s.logger.V(1).Info("Resuming subscription", "subscriber", subscriber.id, "token", token,
+		"deleted", len(deletes), "written", len(rsrcs)+len(rels))
+	changes := []struct {
+		typ   resource.EventType
+		class resource.EventClass
+		objs  []*resourcev1.Object
+	}{
+		{resource.EventTypeDelete, resource.EventClassCritical, deletes},
+		{resource.EventTypeUpdate, resource.EventClassBulk, rsrcs},
+		{resource.EventTypeAdd, resource.EventClassBulk, rels},
+	}
+	for _, c := range changes {
+		if len(c.objs) == 0 {
+			continue
+		}
+		batchSize := o.InitialListBatchSize
+		if batchSize <= 0 {
+			batchSize = len(c.objs)
+		}
+		for batch := range slices.Chunk(c.objs, batchSize) {
+			ok := s.send(subscriber, resource.Event{
+				Type:        c.typ,
+				Class:       c.class,
+				Objs:        batch,
+				ResumeToken: token,
+				Revision:    version,
+			})
+			if !ok {
+				return true
+			}
+		}
+	}
+	if subscriber.progress != nil {
+		subscriber.progress.listed(version)
+	}
+	return true
+}
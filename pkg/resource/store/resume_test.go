@@ -0,0 +1,152 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+func TestStore_SubscribeResume(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer s.Close()
+
+	rsrc := func(name string) *resourcev1.Resource {
+		return &resourcev1.Resource{
+			Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+			Metadata: &resourcev1.ResourceMeta{Name: name},
+		}
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := s.AddResource(rsrc(name)); err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+
+	next := func(ch <-chan resource.Event) resource.Event {
+		t.Helper()
+		select {
+		case event := <-ch:
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("expected an event")
+			return resource.Event{}
+		}
+	}
+	names := func(event resource.Event) []string {
+		t.Helper()
+		var names []string
+		for _, obj := range event.Objs {
+			r := &resourcev1.Resource{}
+			if err := proto.Unmarshal(obj.GetObject().GetValue(), r); err != nil {
+				t.Fatalf("failed to unmarshal resource: %v", err)
+			}
+			names = append(names, r.GetMetadata().GetName())
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	ch := s.Subscribe(nil)
+	token := next(ch).ResumeToken
+	if token == "" {
+		t.Fatal("expected the initial list to have a resume token")
+	}
+	s.Unsubscribe(ch)
+
+	if err := s.UpdateResource(rsrc("b")); err != nil {
+		t.Fatalf("failed to update resource: %v", err)
+	}
+	if err := s.DeleteResource(ref(rsrc("c"))); err != nil {
+		t.Fatalf("failed to delete resource: %v", err)
+	}
+	if err := s.AddResource(rsrc("d")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	// Deleted and added again, so it is received in its current state
+	if err := s.DeleteResource(ref(rsrc("a"))); err != nil {
+		t.Fatalf("failed to delete resource: %v", err)
+	}
+	if err := s.AddResource(rsrc("a")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	ch = s.Subscribe(nil, resource.WithResumeToken(token))
+	event := next(ch)
+	if event.Type != resource.EventTypeDelete || !slices.Equal(names(event), []string{"c"}) {
+		t.Fatalf("expected the delete of c, got %s of %v", event.Type, names(event))
+	}
+	event = next(ch)
+	if event.Type != resource.EventTypeUpdate || !slices.Equal(names(event), []string{"a", "b", "d"}) {
+		t.Fatalf("expected updates of a, b and d, got %s of %v", event.Type, names(event))
+	}
+	resumed := event.ResumeToken
+	s.Unsubscribe(ch)
+
+	// Nothing changed since
+	ch = s.Subscribe(nil, resource.WithResumeToken(resumed))
+	if err := s.UpdateResource(rsrc("d")); err != nil {
+		t.Fatalf("failed to update resource: %v", err)
+	}
+	if event := next(ch); !slices.Equal(names(event), []string{"d"}) || event.ResumeToken == resumed {
+		t.Fatalf("expected only the next change, got %v", names(event))
+	}
+	s.Unsubscribe(ch)
+
+	// Resumed from the oldest token
+	ch = s.Subscribe(nil, resource.WithResumeToken(resumed), resource.WithResumeToken(token))
+	if event := next(ch); event.Type != resource.EventTypeDelete {
+		t.Fatalf("expected changes since the oldest token, got %s", event.Type)
+	}
+	s.Unsubscribe(ch)
+
+	// Tokens of another store list everything
+	ch = s.Subscribe(nil, resource.WithResumeToken("00000000.1"))
+	event = next(ch)
+	if event.Type != resource.EventTypeAdd || !slices.Equal(names(event), []string{"a", "b", "d"}) {
+		t.Fatalf("expected the full list, got %s of %v", event.Type, names(event))
+	}
+	s.Unsubscribe(ch)
+}
+
+func TestHistory_Compaction(t *testing.T) {
+	h := newHistory(2)
+	for i, key := range []string{"a", "b", "c"} {
+		h.deleted([]byte(key), uint64(i+1), &resourcev1.Object{})
+	}
+	if _, ok := h.resumeVersion([]string{h.token(0)}); ok {
+		t.Error("expected to not resume from before the forgotten delete")
+	}
+	if v, ok := h.resumeVersion([]string{h.token(1)}); !ok || v != 1 {
+		t.Errorf("expected to resume from version 1, got %d %t", v, ok)
+	}
+	if n := len(h.since(1)); n != 2 {
+		t.Errorf("expected 2 deletes since version 1, got %d", n)
+	}
+
+	// Writing a resource again forgets its delete
+	h.written([]byte("c"))
+	if n := len(h.since(1)); n != 1 {
+		t.Errorf("expected 1 delete since version 1, got %d", n)
+	}
+	for i := range 10 {
+		h.deleted([]byte("d"), uint64(10+i), &resourcev1.Object{})
+		h.written([]byte("d"))
+	}
+	if len(h.order) > 2*h.max {
+		t.Errorf("expected the keys of forgotten deletes to be dropped, got %d", len(h.order))
+	}
+}
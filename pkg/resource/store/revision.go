@@ -0,0 +1,130 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+// progress tracks the revision a subscriber observed. Events are routed to subscribers in
+// the order of their writes, so a subscriber observed every revision up to that of the
+// last event routed to it, or skipped because it doesn't match its type. While its initial
+// list is being sent, it observed nothing more than when it subscribed; once sent, it
+// observed the revision of the list too.
+type progress struct {
+	mu       sync.Mutex
+	observed uint64
+	// last is the revision of the last event routed to the subscriber
+	last    uint64
+	listing bool
+	// changed is closed when observed grows
+	changed chan struct{}
+}
+
+// newProgress returns the progress of a subscriber that subscribed after the event router
+// started to route the event of revision routed, and is sent an initial list if listing
+func newProgress(routed uint64, listing bool) *progress {
+	return &progress{
+		observed: routed,
+		last:     routed,
+		listing:  listing,
+		changed:  make(chan struct{}),
+	}
+}
+
+// routed records that the event of revision was routed to the subscriber
+func (p *progress) routed(revision uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = max(p.last, revision)
+	if !p.listing {
+		p.observe(p.last)
+	}
+}
+
+// listed records that the initial list, of the store at revision, was sent
+func (p *progress) listed(revision uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listing = false
+	p.observe(max(p.last, revision))
+}
+
+// observe raises the observed revision to revision. p.mu must be held.
+func (p *progress) observe(revision uint64) {
+	if revision <= p.observed {
+		return
+	}
+	p.observed = revision
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// get returns the observed revision, and a channel closed when it grows
+func (p *progress) get() (uint64, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.observed, p.changed
+}
+
+// commit records that a write emitting events was committed and returns its revision.
+// s.mu must be held.
+func (s *store) commit() uint64 {
+	s.revision = s.version()
+	return s.revision
+}
+
+// Revision returns the revision of the last write to the store. Every event of a write
+// carries its revision in Event.Revision.
+func (s *store) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision
+}
+
+// WaitForRevision blocks until the subscription of ch, created with
+// resource.WithRevisions, observed every write up to revision: its subscriber received
+// the events of the writes matching its type and the initial list, if it has one. Events
+// are only observed once received, so the goroutine receiving from ch must not wait.
+//
+// It returns resource.ErrSubscriptionClosed if ch is unknown or was unsubscribed, and the
+// error of ctx if it is done first.
+func (s *store) WaitForRevision(ctx context.Context, ch <-chan resource.Event, revision uint64) error {
+	s.subMu.Lock()
+	var sub *subscriber
+	if i := slices.IndexFunc(s.subscribers, func(candidate *subscriber) bool {
+		return candidate.ch == ch
+	}); i >= 0 {
+		sub = s.subscribers[i]
+	}
+	s.subMu.Unlock()
+
+	if sub == nil {
+		return resource.ErrSubscriptionClosed
+	}
+	if sub.progress == nil {
+		return fmt.Errorf("subscription doesn't track revisions, subscribe with resource.WithRevisions")
+	}
+	for {
+		observed, changed := sub.progress.get()
+		if observed >= revision {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-sub.done:
+			return resource.ErrSubscriptionClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
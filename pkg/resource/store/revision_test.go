@@ -0,0 +1,123 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+
+	"github.com/antimetal/agent/pkg/resource"
+)
+
+func TestStore_WaitForRevision(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer s.Close()
+
+	rsrc := func(typ, name string) *resourcev1.Resource {
+		return &resourcev1.Resource{
+			Type:     &resourcev1.TypeDescriptor{Kind: typ, Type: typ},
+			Metadata: &resourcev1.ResourceMeta{Name: name},
+		}
+	}
+	wait := func(ch <-chan resource.Event, revision uint64) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return s.WaitForRevision(ctx, ch, revision)
+	}
+
+	if err := s.AddResource(rsrc("foo", "a")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	listed := s.Revision()
+	if listed == 0 {
+		t.Fatal("expected a write to have a revision")
+	}
+
+	ch := s.Subscribe(nil, resource.WithRevisions())
+	if err := wait(ch, listed); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait for the initial list to be received, got %v", err)
+	}
+	if event := <-ch; event.Revision < listed {
+		t.Fatalf("expected the initial list to have revision %d or later, got %d", listed, event.Revision)
+	}
+	if err := wait(ch, listed); err != nil {
+		t.Fatalf("expected the initial list to be observed, got %v", err)
+	}
+
+	if err := s.UpdateResource(rsrc("foo", "a")); err != nil {
+		t.Fatalf("failed to update resource: %v", err)
+	}
+	updated := s.Revision()
+	if updated <= listed {
+		t.Fatalf("expected revision to grow from %d, got %d", listed, updated)
+	}
+	if err := wait(ch, updated); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait for the update to be received, got %v", err)
+	}
+	if event := <-ch; event.Revision != updated {
+		t.Fatalf("expected the update to have revision %d, got %d", updated, event.Revision)
+	}
+	if err := wait(ch, updated); err != nil {
+		t.Fatalf("expected the update to be observed, got %v", err)
+	}
+
+	// Writes of other types are observed without being received
+	typed := s.Subscribe(&resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+		resource.WithoutInitialList(), resource.WithRevisions())
+	if err := s.AddResource(rsrc("bar", "b")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	<-ch
+	if err := wait(typed, s.Revision()); err != nil {
+		t.Fatalf("expected a write of another type to be observed, got %v", err)
+	}
+
+	untracked := s.Subscribe(nil, resource.WithoutInitialList())
+	if err := wait(untracked, 0); err == nil {
+		t.Error("expected an error waiting on a subscription without revisions")
+	}
+
+	s.Unsubscribe(typed)
+	if err := wait(typed, 0); !errors.Is(err, resource.ErrSubscriptionClosed) {
+		t.Errorf("expected ErrSubscriptionClosed, got %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p := newProgress(5, true)
+	p.routed(7)
+	if observed, _ := p.get(); observed != 5 {
+		t.Errorf("expected nothing more observed while listing, got %d", observed)
+	}
+
+	_, changed := p.get()
+	p.listed(6)
+	select {
+	case <-changed:
+	default:
+		t.Error("expected a change once listed")
+	}
+	if observed, _ := p.get(); observed != 7 {
+		t.Errorf("expected the last routed revision observed, got %d", observed)
+	}
+
+	p.routed(6)
+	if observed, _ := p.get(); observed != 7 {
+		t.Errorf("expected the observed revision to never go back, got %d", observed)
+	}
+	p.routed(9)
+	if observed, _ := p.get(); observed != 9 {
+		t.Errorf("expected revision 9 observed, got %d", observed)
+	}
+}
@@ -10,27 +10,49 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"reflect"
 	"slices"
+	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
 
 	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
 	badger "github.com/dgraph-io/badger/v4"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/failpoint"
 	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/typeurl"
+	"github.com/antimetal/agent/pkg/tracing"
 )
 
 const (
 	objKeySize = sha256.Size
 )
 
+var tracer = tracing.Tracer("github.com/antimetal/agent/pkg/resource/store")
+
+// startSpan starts the span of a store write as a child of parent, or as a root span if
+// parent is invalid. It starts before the store is locked, so that the time spent waiting
+// on other writes shows in the span.
+func startSpan(parent trace.SpanContext, operation, resourceType string) trace.Span {
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+	_, span := tracer.Start(ctx, "store."+operation)
+	if resourceType != "" {
+		span.SetAttributes(attribute.String("resource.type", resourceType))
+	}
+	return span
+}
+
 type keyPart = []byte
 type indexKey = []byte
 type indexVal = []byte
@@ -46,8 +68,29 @@ var (
 )
 
 type subscriber struct {
+	id      uint64
 	typeDef *resourcev1.TypeDescriptor
 	ch      chan resource.Event
+	// progress tracks the revision the subscriber observed, nil unless it subscribed
+	// with resource.WithRevisions
+	progress *progress
+
+	// mu serializes sends with closing ch; done unblocks a pending send when the
+	// subscriber is closed.
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// close unblocks pending sends and closes the subscriber's channel. It is idempotent.
+func (sub *subscriber) close() {
+	sub.closeOnce.Do(func() {
+		close(sub.done)
+		sub.mu.Lock()
+		close(sub.ch)
+		sub.mu.Unlock()
+		subscribers.Dec()
+	})
 }
 
 // Store is a simple store for resources and their relationships.
@@ -63,25 +106,109 @@ type store struct {
 	closed bool
 
 	store           *badger.DB
-	opGauge         *atomic.Int32
-	eventRouter     chan resource.Event
+	inMemory        bool
 	stopEventRouter chan struct{}
+	subMu           sync.Mutex
 	subscribers     []*subscriber
+	nextSubID       uint64
+
+	// events queues the events of writes in order until the event router delivers them,
+	// so that a slow subscriber never blocks a write
+	eventsMu    sync.Mutex
+	events      []resource.Event
+	eventsReady chan struct{}
+
+	// history resumes subscriptions from the resume tokens of their events
+	history *history
+	// revision is that of the last write that emitted events, guarded by mu
+	revision uint64
+	// routed is the revision of the last event the event router started to route,
+	// guarded by subMu
+	routed uint64
+
+	sizeBudget           int64
+	compressionThreshold int
+	budgetCheckInterval  time.Duration
+
+	logger                 logr.Logger
+	subscriberStallTimeout time.Duration
+
+	// codec is the tag of the codec values are written with
+	codec byte
 }
 
-// New creates a new Store.
-func New() (*store, error) {
-	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true))
+// New creates a new Store. By default the store is kept in memory and unencrypted.
+func New(opts ...Option) (*store, error) {
+	o := &options{
+		compressionThreshold:   defaultCompressionThreshold,
+		budgetCheckInterval:    defaultBudgetCheckInterval,
+		logger:                 logr.Discard(),
+		subscriberStallTimeout: defaultSubscriberStallTimeout,
+		codec:                  CodecProto,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	codec, err := codecTag(o.codec)
 	if err != nil {
 		return nil, err
 	}
+
+	badgerOpts := badger.DefaultOptions(o.dataDir)
+	if o.dataDir == "" {
+		badgerOpts = badgerOpts.WithInMemory(true)
+	}
+	if o.readOnly {
+		badgerOpts = badgerOpts.WithReadOnly(true)
+	}
+	if o.encryptionKey != nil {
+		if err := validateEncryptionKey(o.encryptionKey); err != nil {
+			return nil, err
+		}
+		badgerOpts = badgerOpts.
+			WithEncryptionKey(o.encryptionKey).
+			WithIndexCacheSize(defaultIndexCacheSize)
+		if o.dataKeyRotation > 0 {
+			badgerOpts = badgerOpts.WithEncryptionKeyRotationDuration(o.dataKeyRotation)
+		}
+		if o.previousEncryptionKey != nil && o.dataDir != "" && !o.readOnly {
+			err := rotateMasterKey(o.dataDir, o.previousEncryptionKey, o.encryptionKey,
+				badgerOpts.EncryptionKeyRotationDuration)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rotate encryption key: %w", err)
+			}
+		}
+	}
+
+	db, err := badger.Open(badgerOpts)
+	if err != nil {
+		return nil, err
+	}
+	if o.dataDir != "" && !o.readOnly {
+		n, err := migrateTypeURLs(db)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate type URLs: %w", err)
+		}
+		if n > 0 {
+			o.logger.Info("Migrated type URLs of persisted resources", "resources", n)
+		}
+	}
 	s := &store{
-		store:           db,
-		opGauge:         &atomic.Int32{},
-		eventRouter:     make(chan resource.Event),
-		stopEventRouter: make(chan struct{}),
-		subscribers:     make([]*subscriber, 0),
+		store:                  db,
+		inMemory:               o.dataDir == "",
+		eventsReady:            make(chan struct{}, 1),
+		history:                newHistory(defaultMaxTombstones),
+		stopEventRouter:        make(chan struct{}),
+		subscribers:            make([]*subscriber, 0),
+		sizeBudget:             o.sizeBudget,
+		compressionThreshold:   o.compressionThreshold,
+		budgetCheckInterval:    o.budgetCheckInterval,
+		logger:                 o.logger,
+		subscriberStallTimeout: o.subscriberStallTimeout,
+		codec:                  codec,
 	}
+	sizeBudgetBytes.Set(float64(max(o.sizeBudget, 0)))
 	go s.startEventRouter()
 	return s, nil
 }
@@ -89,16 +216,24 @@ func New() (*store, error) {
 // AddResource adds rsrc to the inventory located by name and updates rsrc for
 // created and updated timestamps.
 // If a resource already exists with the same name and namespace, it will return an error.
-func (s *store) AddResource(rsrc *resourcev1.Resource) error {
+func (s *store) AddResource(rsrc *resourcev1.Resource, opts ...resource.WriteOption) (err error) {
+	o := &resource.WriteOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	span := startSpan(o.Parent, "AddResource", rsrc.GetType().GetType())
+	defer func() { tracing.End(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.closed {
 		return fmt.Errorf("store is closed")
 	}
-
-	s.opGauge.Add(1)
-	defer s.opGauge.Add(-1)
+	if err := failpoint.Inject(failpoint.StoreWrite); err != nil {
+		return fmt.Errorf("failed to add resource: %w", err)
+	}
 
 	r, err := encodeResourceKey(ref(rsrc))
 	if err != nil {
@@ -123,16 +258,21 @@ func (s *store) AddResource(rsrc *resourcev1.Resource) error {
 			return fmt.Errorf("failed to marshal resource: %w", err)
 		}
 
-		return txn.Set(key, objAny.GetValue())
+		return s.setValue(txn, key, rsrc, objAny.GetValue())
 	})
 	if err != nil {
 		return fmt.Errorf("failed to add resource: %w", err)
 	}
+	s.history.written(key)
+	revision := s.commit()
 
 	// Create a new copy of the Any object.
 	// Set explicitly rather than proto.Clone to avoid using reflection.
-	s.eventRouter <- resource.Event{
-		Type: resource.EventTypeAdd,
+	s.emit(resource.Event{
+		Type:        resource.EventTypeAdd,
+		Class:       o.EventClass,
+		ResumeToken: s.history.token(revision),
+		Revision:    revision,
 		Objs: []*resourcev1.Object{{
 			Type: rsrc.GetType(),
 			Object: &anypb.Any{
@@ -140,7 +280,7 @@ func (s *store) AddResource(rsrc *resourcev1.Resource) error {
 				Value:   bytes.Clone(objAny.GetValue()),
 			},
 		}},
-	}
+	})
 	return nil
 }
 
@@ -149,16 +289,24 @@ func (s *store) AddResource(rsrc *resourcev1.Resource) error {
 // with rsrc and updates rsrc with updated at timestamp. The created at timestamp from the
 // originally added resource is preserved. Otherwise a new resource
 // will be added and rsrc will be updated for created and updated timestamps.
-func (s *store) UpdateResource(rsrc *resourcev1.Resource) error {
+func (s *store) UpdateResource(rsrc *resourcev1.Resource, opts ...resource.WriteOption) (err error) {
+	o := &resource.WriteOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	span := startSpan(o.Parent, "UpdateResource", rsrc.GetType().GetType())
+	defer func() { tracing.End(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.closed {
 		return fmt.Errorf("store is closed")
 	}
-
-	s.opGauge.Add(1)
-	defer s.opGauge.Add(-1)
+	if err := failpoint.Inject(failpoint.StoreWrite); err != nil {
+		return fmt.Errorf("failed to update resource: %w", err)
+	}
 
 	r, err := encodeResourceKey(ref(rsrc))
 	if err != nil {
@@ -178,38 +326,40 @@ func (s *store) UpdateResource(rsrc *resourcev1.Resource) error {
 			if err != nil {
 				return fmt.Errorf("failed to marshal resource: %w", err)
 			}
-			return txn.Set(key, objAny.GetValue())
+			return s.setValue(txn, key, rsrc, objAny.GetValue())
 		}
 		if err != nil {
 			return fmt.Errorf("failed to read resource: %w", err)
 		}
-		err = item.Value(func(val []byte) error {
-			r := &resourcev1.Resource{}
-			err := proto.Unmarshal(val, r)
-			if err != nil {
-				return fmt.Errorf("failed to unmarshal resource: %w", err)
-			}
-			rsrc.GetMetadata().CreatedAt = r.Metadata.GetCreatedAt()
-			rsrc.GetMetadata().UpdatedAt = timestamppb.Now()
-			objAny, err = anypb.New(rsrc)
-			if err != nil {
-				return fmt.Errorf("failed to marshal resource: %w", err)
-			}
-			return txn.Set(key, objAny.GetValue())
-		})
+		val, err := resourceValue(item)
 		if err != nil {
-			return fmt.Errorf("failed to update resource: %w", err)
+			return fmt.Errorf("failed to read resource: %w", err)
 		}
-		return nil
+		r := &resourcev1.Resource{}
+		if err := proto.Unmarshal(val, r); err != nil {
+			return fmt.Errorf("failed to unmarshal resource: %w", err)
+		}
+		rsrc.GetMetadata().CreatedAt = r.Metadata.GetCreatedAt()
+		rsrc.GetMetadata().UpdatedAt = timestamppb.Now()
+		objAny, err = anypb.New(rsrc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal resource: %w", err)
+		}
+		return s.setValue(txn, key, rsrc, objAny.GetValue())
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update resource: %w", err)
 	}
+	s.history.written(key)
+	revision := s.commit()
 
 	// Create a new copy of the Any object.
 	// Set explicitly rather than proto.Clone to avoid using reflection.
-	s.eventRouter <- resource.Event{
-		Type: resource.EventTypeUpdate,
+	s.emit(resource.Event{
+		Type:        resource.EventTypeUpdate,
+		Class:       o.EventClass,
+		ResumeToken: s.history.token(revision),
+		Revision:    revision,
 		Objs: []*resourcev1.Object{{
 			Type: rsrc.GetType(),
 			Object: &anypb.Any{
@@ -217,7 +367,7 @@ func (s *store) UpdateResource(rsrc *resourcev1.Resource) error {
 				Value:   bytes.Clone(objAny.GetValue()),
 			},
 		}},
-	}
+	})
 	return nil
 }
 
@@ -231,9 +381,6 @@ func (s *store) GetResource(ref *resourcev1.ResourceRef) (*resourcev1.Resource,
 		return nil, fmt.Errorf("store is closed")
 	}
 
-	s.opGauge.Add(1)
-	defer s.opGauge.Add(-1)
-
 	r, err := encodeResourceKey(ref)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode resource key: %w", err)
@@ -245,7 +392,7 @@ func (s *store) GetResource(ref *resourcev1.ResourceRef) (*resourcev1.Resource,
 		if err != nil {
 			return err
 		}
-		val, err = item.ValueCopy(val)
+		val, err = resourceValue(item)
 		return err
 	})
 	if errors.Is(err, badger.ErrKeyNotFound) {
@@ -259,19 +406,95 @@ func (s *store) GetResource(ref *resourcev1.ResourceRef) (*resourcev1.Resource,
 	return rsrc, err
 }
 
+// ListResources returns the resources of type typeDef ordered by key.
+// typeDef == nil returns all resources.
+func (s *store) ListResources(typeDef *resourcev1.TypeDescriptor) ([]*resourcev1.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	prefix := buildKey(resourceKey)
+	if typeDef != nil {
+		// Resource keys are <type>/<name>; the separator keeps e.g. type foo from matching foobar
+		prefix = append(buildKey(resourceKey, []byte(typeDef.GetType())), '/')
+	}
+	return s.listResources(prefix, nil)
+}
+
+// ListNamespacedResources returns the resources of type typeDef in namespace ns ordered by
+// key. Only the keys of the namespace, and of the few namespaces sharing their encoded
+// prefix, are read.
+func (s *store) ListNamespacedResources(typeDef *resourcev1.TypeDescriptor, ns *resourcev1.Namespace,
+) ([]*resourcev1.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	keyPrefix, namePrefix := encodeNamespaceKeyPrefix(typeDef.GetType(), ns)
+	prefix := buildKey(resourceKey, []byte(keyPrefix))
+	nameOffset := len(buildKey(resourceKey, []byte(typeDef.GetType()))) + 1
+	return s.listResources(prefix, func(key []byte) bool {
+		name, err := base64.URLEncoding.DecodeString(string(key[nameOffset:]))
+		return err == nil && strings.HasPrefix(string(name), namePrefix)
+	})
+}
+
+// listResources returns the resources with keys with prefix for which match, if not nil,
+// returns true. s.mu must be held.
+func (s *store) listResources(prefix []byte, match func(key []byte) bool) ([]*resourcev1.Resource, error) {
+	rsrcs := make([]*resourcev1.Resource, 0)
+	err := s.store.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if match != nil && !match(it.Item().Key()) {
+				continue
+			}
+			val, err := resourceValue(it.Item())
+			if err != nil {
+				return err
+			}
+			r := &resourcev1.Resource{}
+			if err := proto.Unmarshal(val, r); err != nil {
+				return fmt.Errorf("failed to unmarshal resource: %w", err)
+			}
+			rsrcs = append(rsrcs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resources: %w", err)
+	}
+	return rsrcs, nil
+}
+
 // DeleteResource deletes the resource identfied by ref.
 // It also cascade deletes all relationships where the resource is the subject
-// or object.
-func (s *store) DeleteResource(ref *resourcev1.ResourceRef) error {
+// or object. Delete events are EventClassCritical, whatever the EventClass of opts.
+func (s *store) DeleteResource(ref *resourcev1.ResourceRef, opts ...resource.WriteOption) (err error) {
+	o := &resource.WriteOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	span := startSpan(o.Parent, "DeleteResource", ref.GetTypeUrl())
+	defer func() { tracing.End(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.closed {
 		return fmt.Errorf("store is closed")
 	}
-
-	s.opGauge.Add(1)
-	defer s.opGauge.Add(-1)
+	if err := failpoint.Inject(failpoint.StoreWrite); err != nil {
+		return fmt.Errorf("failed to delete resource: %w", err)
+	}
 
 	r, err := encodeResourceKey(ref)
 	if err != nil {
@@ -346,10 +569,19 @@ func (s *store) DeleteResource(ref *resourcev1.ResourceRef) error {
 		return fmt.Errorf("failed to marshal resource: %w", err)
 	}
 
+	revision := s.commit()
+	s.history.deleted(buildKey(resourceKey, []byte(r)), revision, &resourcev1.Object{
+		Type:   rsrc.GetType(),
+		Object: objAny,
+	})
+
 	// Create a new copy of the Any object.
 	// Set explicitly rather than proto.Clone to avoid using reflection.
-	s.eventRouter <- resource.Event{
-		Type: resource.EventTypeDelete,
+	s.emit(resource.Event{
+		Type:        resource.EventTypeDelete,
+		Class:       resource.EventClassCritical,
+		ResumeToken: s.history.token(revision),
+		Revision:    revision,
 		Objs: []*resourcev1.Object{{
 			Type: rsrc.GetType(),
 			Object: &anypb.Any{
@@ -357,12 +589,16 @@ func (s *store) DeleteResource(ref *resourcev1.ResourceRef) error {
 				Value:   bytes.Clone(objAny.GetValue()),
 			},
 		}},
-	}
+	})
 	return nil
 }
 
 // AddRelationships adds rels to the inventory.
-func (s *store) AddRelationships(rels ...*resourcev1.Relationship) error {
+func (s *store) AddRelationships(rels ...*resourcev1.Relationship) (err error) {
+	span := startSpan(trace.SpanContext{}, "AddRelationships", "")
+	span.SetAttributes(attribute.Int("relationships", len(rels)))
+	defer func() { tracing.End(span, err) }()
+
 	for _, rel := range rels {
 		if rel.GetPredicate() == nil {
 			return fmt.Errorf("predicate cannot be nil")
@@ -384,12 +620,12 @@ func (s *store) AddRelationships(rels ...*resourcev1.Relationship) error {
 	if s.closed {
 		return fmt.Errorf("store is closed")
 	}
-
-	s.opGauge.Add(1)
-	defer s.opGauge.Add(-1)
+	if err := failpoint.Inject(failpoint.StoreWrite); err != nil {
+		return fmt.Errorf("failed to add relationships: %w", err)
+	}
 
 	objs := make([]*resourcev1.Object, len(rels))
-	err := s.store.Update(func(txn *badger.Txn) error {
+	err = s.store.Update(func(txn *badger.Txn) error {
 		for i, rel := range rels {
 			// 1. Write the relationship object
 			objAny, err := anypb.New(rel)
@@ -397,33 +633,19 @@ func (s *store) AddRelationships(rels ...*resourcev1.Relationship) error {
 				return fmt.Errorf("failed to marshal relationship: %w", err)
 			}
 			h := sha256.Sum256(objAny.GetValue())
-			if err := txn.Set(buildKey(relationshipKey, h[:]), objAny.GetValue()); err != nil {
+			if err := s.setValue(txn, buildKey(relationshipKey, h[:]), rel, objAny.GetValue()); err != nil {
 				return fmt.Errorf("failed to write relationship: %w", err)
 			}
 
 			// 2. Update the indexes
-			predicate := keyPart(strings.TrimPrefix(rel.Predicate.GetTypeUrl(), "type.googleapis.com/"))
-			predicateIdxKey := buildKey(index, predicateIdx, predicate)
-			if err := addObjKeyToIndex(txn, predicateIdxKey, h[:]); err != nil {
-				return fmt.Errorf("failed to update predicate index: %w", err)
-			}
-
-			objectKey, err := encodeResourceKey(rel.GetObject())
-			if err != nil {
-				return fmt.Errorf("failed to encode object key: %w", err)
-			}
-			objectIdxKey := buildKey(index, objectIdx, []byte(objectKey))
-			if err := addObjKeyToIndex(txn, objectIdxKey, h[:]); err != nil {
-				return fmt.Errorf("failed to update object index: %w", err)
-			}
-
-			subjectKey, err := encodeResourceKey(rel.GetSubject())
+			indexes, err := relationshipIndexKeys(rel)
 			if err != nil {
-				return fmt.Errorf("failed to encode subject key: %w", err)
+				return err
 			}
-			subjectIdxKey := buildKey(index, subjectIdx, []byte(subjectKey))
-			if err := addObjKeyToIndex(txn, subjectIdxKey, h[:]); err != nil {
-				return fmt.Errorf("failed to update subject index: %w", err)
+			for _, idx := range indexes {
+				if err := addObjKeyToIndex(txn, idx, h[:]); err != nil {
+					return fmt.Errorf("failed to update index %s: %w", idx, err)
+				}
 			}
 
 			// Create a new copy of the Any object.
@@ -443,15 +665,126 @@ func (s *store) AddRelationships(rels ...*resourcev1.Relationship) error {
 	}
 
 	// send objects individually so that it can be filtered downstream
+	revision := s.commit()
+	token := s.history.token(revision)
+	events := make([]resource.Event, 0, len(objs))
 	for _, obj := range objs {
-		s.eventRouter <- resource.Event{
-			Type: resource.EventTypeAdd,
-			Objs: []*resourcev1.Object{obj},
-		}
+		events = append(events, resource.Event{
+			Type:        resource.EventTypeAdd,
+			Objs:        []*resourcev1.Object{obj},
+			ResumeToken: token,
+			Revision:    revision,
+		})
 	}
+	s.emit(events...)
 	return nil
 }
 
+// DeleteRelationships deletes rels from the inventory. Relationships that aren't in the
+// inventory are skipped. Delete events are EventClassCritical.
+func (s *store) DeleteRelationships(rels ...*resourcev1.Relationship) (err error) {
+	span := startSpan(trace.SpanContext{}, "DeleteRelationships", "")
+	span.SetAttributes(attribute.Int("relationships", len(rels)))
+	defer func() { tracing.End(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("store is closed")
+	}
+	if err := failpoint.Inject(failpoint.StoreWrite); err != nil {
+		return fmt.Errorf("failed to delete relationships: %w", err)
+	}
+
+	keys := make([][]byte, 0, len(rels))
+	for _, rel := range rels {
+		objAny, err := anypb.New(rel)
+		if err != nil {
+			return fmt.Errorf("failed to marshal relationship: %w", err)
+		}
+		h := sha256.Sum256(objAny.GetValue())
+		keys = append(keys, buildKey(relationshipKey, h[:]))
+	}
+	_, err = s.deleteRelationships(keys...)
+	return err
+}
+
+// deleteRelationships deletes the relationships stored at keys, skipping the missing ones,
+// and emits their delete events. Their tombstones let resumed subscribers catch up on the
+// deletes. It returns the number of relationships deleted. s.mu must be held.
+func (s *store) deleteRelationships(keys ...[]byte) (int, error) {
+	var deletedKeys [][]byte
+	var deleted []*resourcev1.Object
+	err := s.store.Update(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			item, err := txn.Get(key)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get relationship %x: %w", key, err)
+			}
+			val, err := relationshipValue(item)
+			if err != nil {
+				return err
+			}
+			rel := &resourcev1.Relationship{}
+			if err := proto.Unmarshal(val, rel); err != nil {
+				return fmt.Errorf("failed to unmarshal relationship: %w", err)
+			}
+			indexes, err := relationshipIndexKeys(rel)
+			if err != nil {
+				return err
+			}
+			obj := objKey(key[len(buildKey(relationshipKey))+1:])
+			for _, idx := range indexes {
+				if err := deleteObjKeyFromIndex(txn, idx, obj); err != nil {
+					return fmt.Errorf("failed to update index: %w", err)
+				}
+			}
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+			objAny, err := anypb.New(rel)
+			if err != nil {
+				return fmt.Errorf("failed to marshal relationship: %w", err)
+			}
+			deletedKeys = append(deletedKeys, key)
+			deleted = append(deleted, &resourcev1.Object{Type: rel.GetType(), Object: objAny})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete relationships: %w", err)
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	revision := s.commit()
+	token := s.history.token(revision)
+	events := make([]resource.Event, 0, len(deleted))
+	for i, obj := range deleted {
+		s.history.deleted(deletedKeys[i], revision, obj)
+		events = append(events, resource.Event{
+			Type:        resource.EventTypeDelete,
+			Class:       resource.EventClassCritical,
+			ResumeToken: token,
+			Revision:    revision,
+			Objs: []*resourcev1.Object{{
+				Type: obj.GetType(),
+				Object: &anypb.Any{
+					TypeUrl: obj.GetObject().GetTypeUrl(),
+					Value:   bytes.Clone(obj.GetObject().GetValue()),
+				},
+			}},
+		})
+	}
+	s.emit(events...)
+	return len(deleted), nil
+}
+
 // GetRelationships returns all relationships that match the combination subject, object,
 // and predicate with the following invariants:
 //
@@ -484,9 +817,6 @@ func (s *store) GetRelationships(subject, object *resourcev1.ResourceRef, predic
 		return nil, fmt.Errorf("store is closed")
 	}
 
-	s.opGauge.Add(1)
-	defer s.opGauge.Add(-1)
-
 	var rels []*resourcev1.Relationship
 
 	err := s.store.View(func(txn *badger.Txn) error {
@@ -530,9 +860,10 @@ func (s *store) GetRelationships(subject, object *resourcev1.ResourceRef, predic
 				return fmt.Errorf("failed to get relationship %x: %w", obj, err)
 			}
 			rel := &resourcev1.Relationship{}
-			err = item.Value(func(val []byte) error {
-				return proto.Unmarshal(val, rel)
-			})
+			val, err := relationshipValue(item)
+			if err == nil {
+				err = proto.Unmarshal(val, rel)
+			}
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal relationship %x: %w", obj, err)
 			}
@@ -548,13 +879,55 @@ func (s *store) GetRelationships(subject, object *resourcev1.ResourceRef, predic
 	return rels, err
 }
 
+// ListRelationships returns all relationships in the store ordered by key.
+func (s *store) ListRelationships() ([]*resourcev1.Relationship, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	prefix := append(buildKey(relationshipKey), '/')
+	rels := make([]*resourcev1.Relationship, 0)
+	err := s.store.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := relationshipValue(it.Item())
+			if err != nil {
+				return err
+			}
+			rel := &resourcev1.Relationship{}
+			if err := proto.Unmarshal(val, rel); err != nil {
+				return fmt.Errorf("failed to unmarshal relationship %x: %w", it.Item().Key(), err)
+			}
+			rels = append(rels, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list relationships: %w", err)
+	}
+	return rels, nil
+}
+
 // Subscribe returns a channel that will emit events on resource changes. An Event contains both
 // the event type (add, update delete) etc. and a list of Objects. The Object values are protobuf
 // clones of the original so they can be modified without modifiying the underlying resource.
 //
-// The returned channel will be closed when Close() is called. If Close() has already been called,
-// then it will return a closed channel.
-func (s *store) Subscribe(typeDef *resourcev1.TypeDescriptor) <-chan resource.Event {
+// The first events list the objects already in the store, as configured by opts. They are
+// EventClassBulk. With resource.WithResumeToken they are the changes since the token
+// instead, if the store can resume from it.
+//
+// The returned channel will be closed when Unsubscribe or Close() is called. If Close() has
+// already been called, then it will return a closed channel.
+func (s *store) Subscribe(typeDef *resourcev1.TypeDescriptor, opts ...resource.SubscribeOption) <-chan resource.Event {
+	o := &resource.SubscribeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -563,67 +936,165 @@ func (s *store) Subscribe(typeDef *resourcev1.TypeDescriptor) <-chan resource.Ev
 		close(ch)
 		return ch
 	}
+
+	s.subMu.Lock()
+	s.nextSubID++
 	subscriber := &subscriber{
+		id:      s.nextSubID,
 		typeDef: typeDef,
 		ch:      ch,
+		done:    make(chan struct{}),
+	}
+	if o.Revisions {
+		subscriber.progress = newProgress(s.routed, !o.SkipInitialList)
 	}
 	s.subscribers = append(s.subscribers, subscriber)
-	go s.sendInitialObjects(subscriber)
+	s.subMu.Unlock()
+	subscribers.Inc()
+
+	if !o.SkipInitialList {
+		go s.sendInitialObjects(subscriber, o)
+	}
 	return ch
 }
 
-func (s *store) sendInitialObjects(subscriber *subscriber) {
+// Unsubscribe stops sending events to ch, a channel returned by Subscribe, and closes it.
+// Events that were not received yet are dropped. Unsubscribing an unknown or already
+// closed channel is a no-op.
+func (s *store) Unsubscribe(ch <-chan resource.Event) {
+	s.subMu.Lock()
+	var sub *subscriber
+	s.subscribers = slices.DeleteFunc(s.subscribers, func(candidate *subscriber) bool {
+		if candidate.ch == ch {
+			sub = candidate
+			return true
+		}
+		return false
+	})
+	s.subMu.Unlock()
+
+	if sub != nil {
+		sub.close()
+	}
+}
+
+// send delivers e to sub. It gives up if sub is closed or the store is closing and warns
+// every subscriberStallTimeout the subscriber doesn't drain its channel, since a subscriber
+// that stopped reading blocks event delivery to all others.
+func (s *store) send(sub *subscriber, e resource.Event) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	// ch is closed under mu once done is closed, so it is open past this check
+	select {
+	case <-sub.done:
+		return false
+	default:
+	}
+	select {
+	case sub.ch <- e:
+		return true
+	default:
+	}
+
+	var stalled <-chan time.Time
+	if s.subscriberStallTimeout > 0 {
+		timer := time.NewTimer(s.subscriberStallTimeout)
+		defer timer.Stop()
+		stalled = timer.C
+	}
+	start := time.Now()
+	for {
+		select {
+		case <-sub.done:
+			return false
+		case <-s.stopEventRouter:
+			return false
+		case sub.ch <- e:
+			return true
+		case <-stalled:
+			subscriberStallsTotal.Inc()
+			s.logger.Info("Subscriber is not draining resource store events",
+				"subscriber", sub.id, "type", sub.typeDef.GetType(), "blocked", time.Since(start).Round(time.Second))
+			stalled = time.After(s.subscriberStallTimeout)
+		}
+	}
+}
+
+// sendInitialObjects sends the objects in the store matching o to subscriber, in events
+// of at most o.InitialListBatchSize objects
+func (s *store) sendInitialObjects(subscriber *subscriber, o *resource.SubscribeOptions) {
+	if len(o.ResumeTokens) > 0 {
+		if s.sendChanges(subscriber, o) {
+			return
+		}
+		s.logger.V(1).Info("Can't resume subscription, sending all objects", "subscriber", subscriber.id)
+	}
+
 	objs := make([]*resourcev1.Object, 0)
+	var version uint64
 	_ = s.store.View(func(txn *badger.Txn) error {
+		version = txn.ReadTs()
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		for it.Seek(buildKey(resourceKey)); it.ValidForPrefix(buildKey(resourceKey)); it.Next() {
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				r := &resourcev1.Resource{}
-				err := proto.Unmarshal(val, r)
-				if err != nil {
-					return fmt.Errorf("failed to unmarshal resource: %w", err)
-				}
-				objs = append(objs, &resourcev1.Object{
-					Type: r.GetType(),
-					Object: &anypb.Any{
-						TypeUrl: fmt.Sprintf("%s/%s", "type.googleapis.com", r.GetType().GetType()),
-						Value:   val,
-					},
-				})
-				return nil
-			})
+			val, err := resourceValue(it.Item())
 			if err != nil {
 				continue
 			}
+			r := &resourcev1.Resource{}
+			if err := proto.Unmarshal(val, r); err != nil {
+				continue
+			}
+			if !o.MatchesInitialListTypes(r.GetType()) {
+				continue
+			}
+			objs = append(objs, &resourcev1.Object{
+				Type: r.GetType(),
+				Object: &anypb.Any{
+					TypeUrl: typeurl.FromName(r.GetType().GetType()),
+					Value:   val,
+				},
+			})
 		}
 		for it.Seek(buildKey(relationshipKey)); it.ValidForPrefix(buildKey(relationshipKey)); it.Next() {
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				rel := &resourcev1.Relationship{}
-				err := proto.Unmarshal(val, rel)
-				if err != nil {
-					return fmt.Errorf("failed to unmarshal relationship: %w", err)
-				}
-				objs = append(objs, &resourcev1.Object{
-					Type:   rel.GetType(),
-					Object: &anypb.Any{Value: val},
-				})
-				return nil
-			})
+			val, err := relationshipValue(it.Item())
 			if err != nil {
 				continue
 			}
+			rel := &resourcev1.Relationship{}
+			if err := proto.Unmarshal(val, rel); err != nil {
+				continue
+			}
+			if !o.MatchesInitialListTypes(rel.GetType()) {
+				continue
+			}
+			objs = append(objs, &resourcev1.Object{
+				Type:   rel.GetType(),
+				Object: &anypb.Any{Value: val},
+			})
 		}
 		return nil
 	})
-	if len(objs) > 0 {
-		subscriber.ch <- resource.Event{
-			Type: resource.EventTypeAdd,
-			Objs: objs,
+	batchSize := o.InitialListBatchSize
+	if batchSize <= 0 {
+		batchSize = len(objs)
+	}
+	for batch := range slices.Chunk(objs, max(batchSize, 1)) {
+		ok := s.send(subscriber, resource.Event{
+			Type:        resource.EventTypeAdd,
+			Class:       resource.EventClassBulk,
+			Objs:        batch,
+			ResumeToken: s.history.token(version),
+			Revision:    version,
+		})
+		if !ok {
+			return
 		}
 	}
+	if subscriber.progress != nil {
+		subscriber.progress.listed(version)
+	}
 }
 
 // Close closes the inventory store.
@@ -642,11 +1113,26 @@ func (s *store) Close() error {
 }
 
 // Start implements the controller-runtime.Manager Runnable interface.
-// It blocks until ctx is done, at which point it will close the store in order
-// to clean up subscriptions.
+// It enforces the size budget if one is set and blocks until ctx is done, at which point
+// it will close the store in order to clean up subscriptions.
 func (s *store) Start(ctx context.Context) error {
-	<-ctx.Done()
-	return s.Close()
+	if s.sizeBudget <= 0 {
+		<-ctx.Done()
+		return s.Close()
+	}
+
+	ticker := time.NewTicker(s.budgetCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return s.Close()
+		case <-ticker.C:
+			if err := s.enforceBudget(); err != nil {
+				return fmt.Errorf("failed to enforce size budget: %w", err)
+			}
+		}
+	}
 }
 
 func (s *store) startEventRouter() {
@@ -655,33 +1141,93 @@ func (s *store) startEventRouter() {
 
 	for {
 		select {
-		case e := <-s.eventRouter:
-			if len(e.Objs) == 0 {
-				continue
-			}
-			for _, subscriber := range s.subscribers {
-				if subscriber.typeDef != nil &&
-					subscriber.typeDef.GetKind() != e.Objs[0].GetType().GetKind() &&
-					subscriber.typeDef.GetType() != e.Objs[0].GetType().GetType() {
-					continue
-				}
-				subscriber.ch <- e
+		case <-s.eventsReady:
+			s.eventsMu.Lock()
+			events := s.events
+			s.events = nil
+			eventQueueLength.Set(0)
+			s.eventsMu.Unlock()
+
+			for _, e := range events {
+				s.route(e)
 			}
 		case <-s.stopEventRouter:
-			for {
-				if s.opGauge.Load() == 0 {
-					close(s.eventRouter)
-					break
-				}
-			}
+			// Close holds mu, so no write can queue more events. Events still queued are
+			// dropped like the ones blocked on a subscriber.
+			s.eventsMu.Lock()
+			s.events = nil
+			eventQueueLength.Set(0)
+			s.eventsMu.Unlock()
+
+			s.subMu.Lock()
 			for _, subscriber := range s.subscribers {
-				close(subscriber.ch)
+				subscriber.close()
 			}
+			s.subscribers = nil
+			s.subMu.Unlock()
 			return
 		}
 	}
 }
 
+// emit queues events for the event router without waiting for them to be delivered
+func (s *store) emit(events ...resource.Event) {
+	if len(events) == 0 {
+		return
+	}
+	s.eventsMu.Lock()
+	s.events = append(s.events, events...)
+	eventQueueLength.Set(float64(len(s.events)))
+	s.eventsMu.Unlock()
+
+	select {
+	case s.eventsReady <- struct{}{}:
+	default:
+		// The router has yet to drain the previous signal, and will pick these up too
+	}
+}
+
+// route delivers e to the subscribers of its type, and records that the subscribers
+// tracking revisions observed its revision, whether they got it or not
+func (s *store) route(e resource.Event) {
+	s.subMu.Lock()
+	s.routed = max(s.routed, e.Revision)
+	subs := slices.Clone(s.subscribers)
+	s.subMu.Unlock()
+	for _, subscriber := range subs {
+		if len(e.Objs) > 0 && (subscriber.typeDef == nil ||
+			subscriber.typeDef.GetKind() == e.Objs[0].GetType().GetKind() ||
+			subscriber.typeDef.GetType() == e.Objs[0].GetType().GetType()) {
+			if !s.send(subscriber, e) {
+				continue
+			}
+		}
+		if subscriber.progress != nil {
+			subscriber.progress.routed(e.Revision)
+		}
+	}
+}
+
+// relationshipIndexKeys returns the predicate, object and subject index keys of rel
+func relationshipIndexKeys(rel *resourcev1.Relationship) ([]indexKey, error) {
+	predicate := keyPart(typeurl.ToName(rel.GetPredicate().GetTypeUrl()))
+
+	objectKey, err := encodeResourceKey(rel.GetObject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode object key: %w", err)
+	}
+	subjectKey, err := encodeResourceKey(rel.GetSubject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode subject key: %w", err)
+	}
+
+	return []indexKey{
+		buildKey(index, predicateIdx, predicate),
+		buildKey(index, objectIdx, []byte(objectKey)),
+		buildKey(index, subjectIdx, []byte(subjectKey)),
+	}, nil
+}
+
 func buildKey(parts ...keyPart) []byte {
 	b := bytes.Buffer{}
 	for _, p := range parts {
@@ -722,7 +1268,8 @@ func addObjKeyToIndex(txn *badger.Txn, key indexKey, value objKey) error {
 		return txn.Set(key, value[:])
 	}
 	return item.Value(func(val []byte) error {
-		val = append(val, value[:]...)
+		// val is owned by badger and must not be modified
+		val = slices.Concat(val, value[:])
 		objs := splitObjects(val)
 		slices.SortFunc(objs, func(a, b objKey) int {
 			return bytes.Compare(a[:], b[:])
@@ -742,22 +1289,15 @@ func deleteObjKeyFromIndex(txn *badger.Txn, key indexKey, value []byte) error {
 	}
 	return item.Value(func(val []byte) error {
 		// use binary search to find and remove the objKey
-		n := numObjects(val)
-		l, h := 0, n
-		for l < h {
-			m := (l + h) >> 1 // avoids overflow
-			r := bytes.Compare(val[m*objKeySize:(m+1)*objKeySize], value)
-			if r < 0 {
-				l = m + 1
-			} else if r > 0 {
-				h = m - 1
-			} else {
-				// found the objKey, remove it
-				newVal := append(val[:m*objKeySize], val[(m+1)*objKeySize:]...)
-				return txn.Set(key, newVal)
-			}
+		i, found := sort.Find(numObjects(val), func(i int) int {
+			return bytes.Compare(value, val[i*objKeySize:(i+1)*objKeySize])
+		})
+		if !found {
+			return nil
 		}
-		return nil
+		// val is owned by badger and must not be modified
+		newVal := slices.Concat(val[:i*objKeySize], val[(i+1)*objKeySize:])
+		return txn.Set(key, newVal)
 	})
 }
 
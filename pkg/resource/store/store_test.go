@@ -7,13 +7,25 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/antimetal/agent/pkg/errors"
+	"github.com/antimetal/agent/pkg/failpoint"
 	"github.com/antimetal/agent/pkg/resource"
 	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/go-logr/logr/funcr"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 )
@@ -62,6 +74,34 @@ func TestStore_AddResource(t *testing.T) {
 	}
 }
 
+func TestStore_WriteFailpoint(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	t.Cleanup(failpoint.Reset)
+	if err := failpoint.Set(failpoint.StoreWrite + "=error"); err != nil {
+		t.Fatalf("failed to set failpoint: %v", err)
+	}
+	rsrc := &resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: "foo"},
+	}
+	if err := inv.AddResource(rsrc); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("expected an injected failure, got %v", err)
+	}
+	if _, err := inv.GetResource(ref(rsrc)); !errors.Is(err, resource.ErrResourceNotFound) {
+		t.Errorf("expected the failed write to not be stored, got %v", err)
+	}
+
+	failpoint.Reset()
+	if err := inv.AddResource(rsrc); err != nil {
+		t.Errorf("expected writes to succeed without the failpoint, got %v", err)
+	}
+}
+
 func TestStore_UpdateResourceNewResource(t *testing.T) {
 	inv, err := New()
 	if err != nil {
@@ -245,6 +285,10 @@ func TestStore_GetRelationships(t *testing.T) {
 	}
 
 	testCases := []testCase{
+		{
+			name:              "no filter",
+			expectedNumResult: 0,
+		},
 		{
 			name: "empty",
 			subject: &resourcev1.ResourceRef{
@@ -380,6 +424,14 @@ func TestStore_GetRelationships(t *testing.T) {
 			}
 		})
 	}
+
+	all, err := inv.ListRelationships()
+	if err != nil {
+		t.Fatalf("failed to list relationships: %v", err)
+	}
+	if len(all) != len(rels) {
+		t.Fatalf("expected %d relationships, got %d", len(rels), len(all))
+	}
 }
 
 func TestStore_DeleteResource_CascadeDelete(t *testing.T) {
@@ -479,6 +531,56 @@ func TestStore_DeleteResource_CascadeDelete(t *testing.T) {
 	}
 }
 
+func TestDeleteObjKeyFromIndex(t *testing.T) {
+	keys := [][]byte{
+		bytes.Repeat([]byte{1}, objKeySize),
+		bytes.Repeat([]byte{2}, objKeySize),
+		bytes.Repeat([]byte{3}, objKeySize),
+	}
+	idx := []byte("idx")
+
+	for i, del := range keys {
+		t.Run(fmt.Sprintf("delete key %d", i), func(t *testing.T) {
+			db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+
+			err = db.Update(func(txn *badger.Txn) error {
+				for _, key := range keys {
+					if err := addObjKeyToIndex(txn, idx, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to build index: %v", err)
+			}
+			err = db.Update(func(txn *badger.Txn) error {
+				return deleteObjKeyFromIndex(txn, idx, del)
+			})
+			if err != nil {
+				t.Fatalf("failed to delete from index: %v", err)
+			}
+
+			var got []objKey
+			err = db.View(func(txn *badger.Txn) error {
+				got, err = readObjKeysFromIndexes(txn, idx)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("failed to read index: %v", err)
+			}
+			want := slices.Concat(keys[:i], keys[i+1:])
+			if !slices.EqualFunc(got, want, bytes.Equal) {
+				t.Errorf("index after deleting key %d = %x, want %x", i, got, want)
+			}
+		})
+	}
+}
+
 func TestStore_DeleteResource_NoRelationships(t *testing.T) {
 	inv, err := New()
 	if err != nil {
@@ -606,3 +708,544 @@ func TestStore_Subscribe(t *testing.T) {
 		t.Fatalf("expected relationship %s to be in the event stream", "qux/qux")
 	}
 }
+
+// waitClosed fails the test unless ch is closed within a second, discarding pending events
+func waitClosed(t *testing.T, ch <-chan resource.Event) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("expected subscriber channel to be closed")
+		}
+	}
+}
+
+func TestStore_Unsubscribe(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+
+	unsubscribed := s.Subscribe(nil)
+	subscribed := s.Subscribe(nil)
+	s.Unsubscribe(unsubscribed)
+	waitClosed(t, unsubscribed)
+	// Unsubscribing twice is a no-op
+	s.Unsubscribe(unsubscribed)
+
+	events := make(chan resource.Event, 1)
+	go func() {
+		for event := range subscribed {
+			events <- event
+		}
+		close(events)
+	}()
+
+	err = s.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: "rsrc1"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != resource.EventTypeAdd {
+			t.Fatalf("expected %s event, got %s", resource.EventTypeAdd, event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected remaining subscriber to receive the event")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close inventory: %v", err)
+	}
+	waitClosed(t, events)
+	// Unsubscribing after Close is a no-op
+	s.Unsubscribe(subscribed)
+}
+
+func TestStore_SubscribeOptions(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer s.Close()
+
+	for _, name := range []string{"a", "b", "c"} {
+		err := s.AddResource(&resourcev1.Resource{
+			Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+			Metadata: &resourcev1.ResourceMeta{Name: name},
+		})
+		if err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+	err = s.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "bar"},
+		Metadata: &resourcev1.ResourceMeta{Name: "d"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	err = s.AddRelationships(&resourcev1.Relationship{
+		Type:      &resourcev1.TypeDescriptor{Kind: "qux", Type: "qux"},
+		Subject:   &resourcev1.ResourceRef{TypeUrl: "foo", Name: "a"},
+		Object:    &resourcev1.ResourceRef{TypeUrl: "foo", Name: "b"},
+		Predicate: &anypb.Any{TypeUrl: "qux"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add relationship: %v", err)
+	}
+
+	next := func(ch <-chan resource.Event) resource.Event {
+		t.Helper()
+		select {
+		case event := <-ch:
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("expected an event")
+			return resource.Event{}
+		}
+	}
+
+	// Batches of 2 objects, only resources of kind foo/foo
+	batched := s.Subscribe(nil,
+		resource.WithInitialListBatchSize(2),
+		resource.WithInitialListTypes(&resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"}),
+	)
+	if event := next(batched); len(event.Objs) != 2 {
+		t.Fatalf("expected first batch of 2 objects, got %d", len(event.Objs))
+	}
+	event := next(batched)
+	if len(event.Objs) != 1 {
+		t.Fatalf("expected second batch of 1 object, got %d", len(event.Objs))
+	}
+	if typ := event.Objs[0].GetType(); typ.GetKind() != "foo" || typ.GetType() != "foo" {
+		t.Fatalf("expected only foo/foo objects, got %s/%s", typ.GetKind(), typ.GetType())
+	}
+
+	// A descriptor without a type matches the whole kind
+	byKind := s.Subscribe(nil, resource.WithInitialListTypes(&resourcev1.TypeDescriptor{Kind: "qux"}))
+	if event := next(byKind); len(event.Objs) != 1 || event.Objs[0].GetType().GetKind() != "qux" {
+		t.Fatalf("expected only the qux relationship, got %v", event.Objs)
+	}
+	// They would block delivery of the next change to other subscribers
+	s.Unsubscribe(batched)
+	s.Unsubscribe(byKind)
+
+	// Without the initial list the first event is the next change
+	skipped := s.Subscribe(nil, resource.WithoutInitialList())
+	err = s.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: "e"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	event = next(skipped)
+	if len(event.Objs) != 1 {
+		t.Fatalf("expected only the added resource, got %d objects", len(event.Objs))
+	}
+	rsrc := &resourcev1.Resource{}
+	if err := proto.Unmarshal(event.Objs[0].GetObject().GetValue(), rsrc); err != nil {
+		t.Fatalf("failed to unmarshal resource: %v", err)
+	}
+	if rsrc.GetMetadata().GetName() != "e" {
+		t.Fatalf("expected added resource e, got %s", rsrc.GetMetadata().GetName())
+	}
+}
+
+func TestStore_EventClass(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer s.Close()
+
+	rsrc := func(name string) *resourcev1.Resource {
+		return &resourcev1.Resource{
+			Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+			Metadata: &resourcev1.ResourceMeta{Name: name},
+		}
+	}
+	if err := s.AddResource(rsrc("a")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	next := func(ch <-chan resource.Event) resource.Event {
+		t.Helper()
+		select {
+		case event := <-ch:
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("expected an event")
+			return resource.Event{}
+		}
+	}
+
+	ch := s.Subscribe(nil)
+	if event := next(ch); event.Class != resource.EventClassBulk {
+		t.Errorf("expected initial list to be %s, got %s", resource.EventClassBulk, event.Class)
+	}
+
+	if err := s.AddResource(rsrc("b")); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	if event := next(ch); event.Class != resource.EventClassNormal {
+		t.Errorf("expected add to be %s, got %s", resource.EventClassNormal, event.Class)
+	}
+
+	if err := s.UpdateResource(rsrc("b"), resource.WithEventClass(resource.EventClassBulk)); err != nil {
+		t.Fatalf("failed to update resource: %v", err)
+	}
+	if event := next(ch); event.Class != resource.EventClassBulk {
+		t.Errorf("expected update to be %s, got %s", resource.EventClassBulk, event.Class)
+	}
+
+	if err := s.DeleteResource(ref(rsrc("b"))); err != nil {
+		t.Fatalf("failed to delete resource: %v", err)
+	}
+	if event := next(ch); event.Type != resource.EventTypeDelete || event.Class != resource.EventClassCritical {
+		t.Errorf("expected %s delete, got %s %s", resource.EventClassCritical, event.Class, event.Type)
+	}
+}
+
+func TestStore_SubscriberStall(t *testing.T) {
+	var mu sync.Mutex
+	var logs []string
+	logger := funcr.New(func(prefix, args string) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, args)
+	}, funcr.Options{})
+
+	s, err := New(WithLogger(logger), WithSubscriberStallTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+
+	// Never read from the subscription
+	ch := s.Subscribe(&resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"})
+	err = s.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: "rsrc1"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		stalled := slices.ContainsFunc(logs, func(log string) bool {
+			return strings.Contains(log, "not draining") && strings.Contains(log, `"type"="foo"`)
+		})
+		mu.Unlock()
+		if stalled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a stalled subscriber warning, got logs: %v", logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A stalled subscriber doesn't prevent the store from closing
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("failed to close inventory: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close blocked on a stalled subscriber")
+	}
+	waitClosed(t, ch)
+}
+
+func TestStore_WritesDontWaitForSubscribers(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer s.Close()
+
+	typeDef := &resourcev1.TypeDescriptor{Kind: "foo", Type: "foo"}
+	ch := s.Subscribe(typeDef, resource.WithoutInitialList())
+
+	// Nothing reads from the subscription while writing
+	const n = 50
+	written := make(chan error, 1)
+	go func() {
+		for i := range n {
+			err := s.AddResource(&resourcev1.Resource{
+				Type:     typeDef,
+				Metadata: &resourcev1.ResourceMeta{Name: fmt.Sprintf("rsrc%d", i)},
+			})
+			if err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("writes blocked on a subscriber that isn't reading")
+	}
+
+	// Events are delivered in the order of the writes
+	for i := range n {
+		select {
+		case e := <-ch:
+			rsrc := &resourcev1.Resource{}
+			if err := proto.Unmarshal(e.Objs[0].GetObject().GetValue(), rsrc); err != nil {
+				t.Fatalf("failed to unmarshal resource: %v", err)
+			}
+			if want := fmt.Sprintf("rsrc%d", i); rsrc.GetMetadata().GetName() != want {
+				t.Fatalf("event %d is for %s, want %s", i, rsrc.GetMetadata().GetName(), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestStore_ListResources(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	rsrcs, err := inv.ListResources(nil)
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(rsrcs) != 0 {
+		t.Fatalf("expected no resources, got %d", len(rsrcs))
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		err := inv.AddResource(&resourcev1.Resource{
+			Type:     &resourcev1.TypeDescriptor{Type: "foo"},
+			Metadata: &resourcev1.ResourceMeta{Name: name},
+		})
+		if err != nil {
+			t.Fatalf("failed to add resource: %v", err)
+		}
+	}
+
+	rsrcs, err = inv.ListResources(nil)
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(rsrcs) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(rsrcs))
+	}
+	names := map[string]bool{}
+	for _, r := range rsrcs {
+		names[r.GetMetadata().GetName()] = true
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if !names[name] {
+			t.Errorf("expected resource %q in list", name)
+		}
+	}
+
+	// Types sharing a prefix must not match
+	err = inv.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Type: "foobar"},
+		Metadata: &resourcev1.ResourceMeta{Name: "d"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	rsrcs, err = inv.ListResources(&resourcev1.TypeDescriptor{Type: "foo"})
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(rsrcs) != 3 {
+		t.Fatalf("expected 3 resources of type foo, got %d", len(rsrcs))
+	}
+	rsrcs, err = inv.ListResources(&resourcev1.TypeDescriptor{Type: "foobar"})
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(rsrcs) != 1 || rsrcs[0].GetMetadata().GetName() != "d" {
+		t.Fatalf("expected only resource d of type foobar, got %v", rsrcs)
+	}
+}
+
+func TestStore_ListNamespacedResources(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	kube := func(namespace string) *resourcev1.Namespace {
+		return &resourcev1.Namespace{
+			Namespace: &resourcev1.Namespace_Kube{
+				Kube: &resourcev1.KubernetesNamespace{Cluster: "test", Namespace: namespace},
+			},
+		}
+	}
+	// Namespaces sharing a prefix, whose keys share the encoded prefix of the shorter one
+	for _, namespace := range []string{"ab", "abc", "abcdef", "b"} {
+		for _, name := range []string{"x", "y"} {
+			err := inv.AddResource(&resourcev1.Resource{
+				Type:     &resourcev1.TypeDescriptor{Type: "foo"},
+				Metadata: &resourcev1.ResourceMeta{Name: name, Namespace: kube(namespace)},
+			})
+			if err != nil {
+				t.Fatalf("failed to add resource: %v", err)
+			}
+		}
+	}
+	err = inv.AddResource(&resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Type: "foobar"},
+		Metadata: &resourcev1.ResourceMeta{Name: "z", Namespace: kube("ab")},
+	})
+	if err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+
+	for _, namespace := range []string{"ab", "abc", "abcdef", "b"} {
+		rsrcs, err := inv.ListNamespacedResources(&resourcev1.TypeDescriptor{Type: "foo"}, kube(namespace))
+		if err != nil {
+			t.Fatalf("failed to list resources: %v", err)
+		}
+		if len(rsrcs) != 2 {
+			t.Fatalf("expected 2 resources in namespace %s, got %v", namespace, rsrcs)
+		}
+		for _, rsrc := range rsrcs {
+			if got := rsrc.GetMetadata().GetNamespace().GetKube().GetNamespace(); got != namespace {
+				t.Errorf("expected resources in namespace %s, got %s", namespace, got)
+			}
+		}
+	}
+	rsrcs, err := inv.ListNamespacedResources(&resourcev1.TypeDescriptor{Type: "foo"}, kube("a"))
+	if err != nil {
+		t.Fatalf("failed to list resources: %v", err)
+	}
+	if len(rsrcs) != 0 {
+		t.Fatalf("expected no resources in namespace a, got %v", rsrcs)
+	}
+}
+
+func TestStore_DeleteRelationships(t *testing.T) {
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	predicate, err := anypb.New(&resourcev1.Resource{})
+	if err != nil {
+		t.Fatalf("failed to create predicate: %v", err)
+	}
+	rel := func(subject, object string) *resourcev1.Relationship {
+		return &resourcev1.Relationship{
+			Type:      &resourcev1.TypeDescriptor{Kind: "rel", Type: "rel"},
+			Subject:   &resourcev1.ResourceRef{TypeUrl: "foo", Name: subject},
+			Object:    &resourcev1.ResourceRef{TypeUrl: "foo", Name: object},
+			Predicate: predicate,
+		}
+	}
+	if err := inv.AddRelationships(rel("a", "b"), rel("a", "c")); err != nil {
+		t.Fatalf("failed to add relationships: %v", err)
+	}
+	ch := inv.Subscribe(nil, resource.WithoutInitialList())
+	defer inv.Unsubscribe(ch)
+
+	// Missing relationships are skipped
+	if err := inv.DeleteRelationships(rel("a", "b"), rel("b", "c")); err != nil {
+		t.Fatalf("failed to delete relationships: %v", err)
+	}
+	rels, err := inv.GetRelationships(&resourcev1.ResourceRef{TypeUrl: "foo", Name: "a"}, nil, &resourcev1.Resource{})
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	if len(rels) != 1 || rels[0].GetObject().GetName() != "c" {
+		t.Fatalf("expected only the relationship to c to remain, got %v", rels)
+	}
+
+	select {
+	case event := <-ch:
+		if event.Type != resource.EventTypeDelete || event.Class != resource.EventClassCritical || len(event.Objs) != 1 {
+			t.Fatalf("expected a critical delete of one relationship, got %+v", event)
+		}
+		got := &resourcev1.Relationship{}
+		if err := event.Objs[0].GetObject().UnmarshalTo(got); err != nil {
+			t.Fatalf("failed to unmarshal relationship: %v", err)
+		}
+		if !proto.Equal(got, rel("a", "b")) {
+			t.Fatalf("expected the delete of the relationship to b, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a delete event")
+	}
+}
+
+func TestStore_WriteSpanParent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	// The store tracer was obtained from the global provider, which delegates to the first
+	// provider set
+	otel.SetTracerProvider(provider)
+
+	inv, err := New()
+	if err != nil {
+		t.Fatalf("failed to create inventory: %v", err)
+	}
+	defer inv.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "reconcile")
+	rsrc := &resourcev1.Resource{
+		Type:     &resourcev1.TypeDescriptor{Type: "foo"},
+		Metadata: &resourcev1.ResourceMeta{Name: "test"},
+	}
+	if err := inv.AddResource(rsrc, resource.WithParent(ctx)); err != nil {
+		t.Fatalf("failed to add resource: %v", err)
+	}
+	if err := inv.UpdateResource(rsrc); err != nil {
+		t.Fatalf("failed to update resource: %v", err)
+	}
+	if err := inv.DeleteResource(ref(rsrc), resource.WithParent(ctx)); err != nil {
+		t.Fatalf("failed to delete resource: %v", err)
+	}
+	parent.End()
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	for _, name := range []string{"store.AddResource", "store.DeleteResource"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %s span, got %v", name, slices.Collect(maps.Keys(spans)))
+		}
+		if !span.Parent.Equal(parent.SpanContext()) {
+			t.Errorf("expected %s to be a child of the reconcile span, got parent %v", name, span.Parent)
+		}
+		if span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("expected %s to be in the trace of the reconcile span", name)
+		}
+	}
+	if span := spans["store.UpdateResource"]; span.Parent.IsValid() {
+		t.Errorf("expected store.UpdateResource without a parent to be a root span, got parent %v", span.Parent)
+	}
+}
@@ -7,15 +7,18 @@
 package resource
 
 import (
+	"context"
 	"errors"
 
 	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
 var (
 	ErrResourceNotFound      = errors.New("resource not found")
 	ErrRelationshipsNotFound = errors.New("relationships not found")
+	ErrSubscriptionClosed    = errors.New("subscription closed")
 )
 
 // Store persists Resources and their Relationships. Resources are objects that represent a type
@@ -29,22 +32,30 @@ type Store interface {
 	// If the resource does not exist, it will return ErrResourceNotFound.
 	GetResource(ref *resourcev1.ResourceRef) (*resourcev1.Resource, error)
 
+	// ListResources returns all resources of type typeDef.
+	// typeDef == nil matches any type.
+	ListResources(typeDef *resourcev1.TypeDescriptor) ([]*resourcev1.Resource, error)
+
+	// ListNamespacedResources returns the resources of type typeDef in namespace ns,
+	// without reading the resources of the type in other namespaces.
+	ListNamespacedResources(typeDef *resourcev1.TypeDescriptor, ns *resourcev1.Namespace) ([]*resourcev1.Resource, error)
+
 	// AddResource adds rsrc to the inventory located by name and updates rsrc for
 	// created and updated timestamps.
 	// If a resource already exists with the same name and namespace, it will return an error.
-	AddResource(rsrc *resourcev1.Resource) error
+	AddResource(rsrc *resourcev1.Resource, opts ...WriteOption) error
 
 	// UpdateResource updates a resource located by name with rsrc.
 	// If a resource already exists with the same namespace/name, it will be replaced
 	// with rsrc and updates rsrc with updated at timestamp. The created at timestamp from the
 	// originally added resource is preserved. Otherwise a new resource
 	// will be added and rsrc will be updated for created and updated timestamps.
-	UpdateResource(rsrc *resourcev1.Resource) error
+	UpdateResource(rsrc *resourcev1.Resource, opts ...WriteOption) error
 
 	// DeleteResource deletes the resource located by name.
 	// It also cascade deletes all relationships where the resource is the subject
-	// or object.
-	DeleteResource(ref *resourcev1.ResourceRef) error
+	// or object. Delete events are EventClassCritical, whatever the EventClass of opts.
+	DeleteResource(ref *resourcev1.ResourceRef, opts ...WriteOption) error
 
 	// GetRelationships returns all relationships that match the combination subject, object,
 	// and predicate with the following invariants:
@@ -72,22 +83,151 @@ type Store interface {
 	// 		 returns all ConnectedTo relationships between subject "foo" and object "bar".
 	GetRelationships(subject, object *resourcev1.ResourceRef, predicateT proto.Message) ([]*resourcev1.Relationship, error)
 
+	// ListRelationships returns all relationships.
+	// Unlike GetRelationships(nil, nil, nil), which matches nothing, it scans every relationship.
+	ListRelationships() ([]*resourcev1.Relationship, error)
+
 	// AddRelationships adds rels to the inventory.
 	AddRelationships(rels ...*resourcev1.Relationship) error
 
+	// DeleteRelationships deletes rels from the inventory, e.g. when the resources they
+	// relate no longer match. Relationships that aren't in the inventory are skipped.
+	// Delete events are EventClassCritical.
+	DeleteRelationships(rels ...*resourcev1.Relationship) error
+
 	// Subscribe returns a channel that will emit events on resource changes. An Event contains both
 	// the event type (add, update delete) etc. and a list of Objects. The Object values are protobuf
 	// clones of the original so they can be modified without modifiying the underlying resource.
 	//
-	// The returned channel will be closed when Close() is called. If Close()
+	// The first event lists all objects already in the store, as EventClassBulk. opts can
+	// skip, split or filter this initial list, or replace it with the changes made since a
+	// resume token.
+	//
+	// The returned channel will be closed when Unsubscribe or Close() is called. If Close()
 	// has already been called, then it will return a closed channel.
-	Subscribe(typeDef *resourcev1.TypeDescriptor) <-chan Event
+	Subscribe(typeDef *resourcev1.TypeDescriptor, opts ...SubscribeOption) <-chan Event
+
+	// Unsubscribe stops sending events to ch, a channel returned by Subscribe, and closes it.
+	// Unsubscribing an unknown or already closed channel is a no-op.
+	Unsubscribe(ch <-chan Event)
+
+	// Revision returns the revision of the last write to the store, which its events carry.
+	// Revisions grow with every write and, like resume tokens, are only comparable within a
+	// run of the store.
+	Revision() uint64
+
+	// WaitForRevision blocks until the subscription of ch, created with WithRevisions,
+	// observed every write up to revision, e.g. so that a consumer knows it received the
+	// changes made before it reconnected to a downstream service. It must not be called
+	// from the goroutine receiving from ch. It returns ErrSubscriptionClosed if ch isn't
+	// subscribed, and the error of ctx if it is done first.
+	WaitForRevision(ctx context.Context, ch <-chan Event, revision uint64) error
 
 	// Close closes the inventory store.
 	// It should be idempotent - calling Close multiple times will close only once.
 	Close() error
 }
 
+// SubscribeOptions configures the initial list of objects sent to a subscriber
+type SubscribeOptions struct {
+	// SkipInitialList only sends changes made after subscribing.
+	SkipInitialList bool
+	// InitialListBatchSize splits the initial list into events of at most this many objects.
+	// <= 0 sends it as a single event.
+	InitialListBatchSize int
+	// InitialListTypes restricts the initial list to objects of these types. A descriptor
+	// without a Type matches all types of its Kind. Empty lists all objects.
+	InitialListTypes []*resourcev1.TypeDescriptor
+	// ResumeTokens replace the initial list with the changes made since the oldest of
+	// these tokens.
+	ResumeTokens []string
+	// Revisions tracks the revision the subscriber observed, for WaitForRevision.
+	Revisions bool
+}
+
+// SubscribeOption configures a subscription created with Subscribe
+type SubscribeOption func(*SubscribeOptions)
+
+// WithoutInitialList skips the initial list of existing objects.
+func WithoutInitialList() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.SkipInitialList = true
+	}
+}
+
+// WithInitialListBatchSize sends the initial list in events of at most size objects.
+func WithInitialListBatchSize(size int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.InitialListBatchSize = size
+	}
+}
+
+// WithInitialListTypes restricts the initial list to objects of types.
+func WithInitialListTypes(types ...*resourcev1.TypeDescriptor) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.InitialListTypes = append(o.InitialListTypes, types...)
+	}
+}
+
+// WithResumeToken replaces the initial list with the changes made since token, the
+// ResumeToken of an event received from the store: the current state of the objects
+// written since, and deletes of the resources deleted since. Given several times, the
+// changes since the oldest token are sent. If the store can't resume from a token, e.g.
+// it was reopened since or forgot the deletes that far back, the full initial list is
+// sent instead.
+func WithResumeToken(token string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.ResumeTokens = append(o.ResumeTokens, token)
+	}
+}
+
+// WithRevisions tracks the revision of the store the subscriber observed, so that
+// Store.WaitForRevision can wait for it to receive every change up to a revision.
+func WithRevisions() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Revisions = true
+	}
+}
+
+// MatchesInitialListTypes returns whether objects of typ are part of the initial list.
+func (o *SubscribeOptions) MatchesInitialListTypes(typ *resourcev1.TypeDescriptor) bool {
+	if len(o.InitialListTypes) == 0 {
+		return true
+	}
+	for _, t := range o.InitialListTypes {
+		if t.GetKind() == typ.GetKind() && (t.GetType() == "" || t.GetType() == typ.GetType()) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteOptions configures a write and the event emitted for it
+type WriteOptions struct {
+	// EventClass is the class of the emitted event. Defaults to EventClassNormal.
+	EventClass EventClass
+	// Parent is the span the span of the write is a child of. The write span is a root
+	// span if Parent is invalid.
+	Parent trace.SpanContext
+}
+
+// WriteOption configures a write to the Store
+type WriteOption func(*WriteOptions)
+
+// WithEventClass sets the class of the event emitted for the write.
+func WithEventClass(class EventClass) WriteOption {
+	return func(o *WriteOptions) {
+		o.EventClass = class
+	}
+}
+
+// WithParent makes the span of the write a child of the span in ctx, if any.
+func WithParent(ctx context.Context) WriteOption {
+	return func(o *WriteOptions) {
+		o.Parent = trace.SpanContextFromContext(ctx)
+	}
+}
+
 type EventType string
 
 const (
@@ -96,7 +236,44 @@ const (
 	EventTypeDelete EventType = "DELETE"
 )
 
+// EventClass tells subscribers how urgent an event is, so they can order events or shed
+// load, e.g. send critical events ahead of others and delay or drop bulk events when they
+// fall behind.
+type EventClass int
+
+const (
+	// EventClassNormal is a regular change
+	EventClassNormal EventClass = iota
+	// EventClassCritical is a change consumers must learn about as soon as possible, e.g.
+	// the deletion of a resource
+	EventClassCritical
+	// EventClassBulk is a change consumers can process late, e.g. periodic resyncs and
+	// the initial list of a subscription, that repeat what they mostly know already
+	EventClassBulk
+)
+
+func (c EventClass) String() string {
+	switch c {
+	case EventClassCritical:
+		return "critical"
+	case EventClassBulk:
+		return "bulk"
+	default:
+		return "normal"
+	}
+}
+
 type Event struct {
-	Type EventType
-	Objs []*resourcev1.Object
+	Type  EventType
+	Class EventClass
+	Objs  []*resourcev1.Object
+	// ResumeToken is the position of the event in the history of the store, from which
+	// a subscription can be resumed with WithResumeToken. The events of a single write
+	// share a token, and the events of an initial list have the token of the state they
+	// list.
+	ResumeToken string
+	// Revision is the revision of the store after the write of the event, shared by the
+	// events of a single write. The events of an initial list have the revision of the
+	// state they list.
+	Revision uint64
 }
@@ -0,0 +1,93 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package typeurl names the types of resources, relationships and their specs the same
+// way whether they are protobuf-go messages, such as the Antimetal APIs, or gogo protobuf
+// messages, such as Kubernetes objects.
+//
+// A type has two forms:
+//
+//   - its name, e.g. k8s.io.api.core.v1.Pod, used in TypeDescriptor.Type and
+//     ResourceRef.TypeUrl, which are part of store keys and never contain a slash
+//   - its URL, the name prefixed with Prefix, used as the type URL of Any messages such as
+//     resource specs and relationship predicates
+//
+// Agents before this package stored the specs of Kubernetes resources with their name as
+// type URL. Name accepts both forms, so readers handle specs stored by older agents.
+package typeurl
+
+import (
+	"fmt"
+	"strings"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Prefix is the prefix of the type URLs of Any messages
+const Prefix = "type.googleapis.com/"
+
+// Message is a protobuf-go or gogo protobuf message
+type Message = protoiface.MessageV1
+
+// Name returns the type name of msg: the full name of a protobuf-go message or the name a
+// gogo protobuf message is registered with.
+func Name(msg Message) string {
+	if m, ok := msg.(proto.Message); ok {
+		return string(m.ProtoReflect().Descriptor().FullName())
+	}
+	return gogoproto.MessageName(msg)
+}
+
+// URL returns the type URL of msg
+func URL(msg Message) string {
+	return FromName(Name(msg))
+}
+
+// FromName returns the type URL of the type name
+func FromName(name string) string {
+	return Prefix + name
+}
+
+// ToName returns the type name of url. A name is returned as is.
+func ToName(url string) string {
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		return url[i+1:]
+	}
+	return url
+}
+
+// Normalize returns the type URL of url, which can be a type name
+func Normalize(url string) string {
+	if url == "" {
+		return ""
+	}
+	return FromName(ToName(url))
+}
+
+// Is reports whether url, a type URL or name, is the type of msg
+func Is(url string, msg Message) bool {
+	return ToName(url) == Name(msg)
+}
+
+// New packs msg into an Any with its type URL. Unlike anypb.New, it accepts gogo protobuf
+// messages.
+func New(msg Message) (*anypb.Any, error) {
+	if m, ok := msg.(proto.Message); ok {
+		return anypb.New(m)
+	}
+	marshaler, ok := msg.(gogoproto.Marshaler)
+	if !ok {
+		return nil, fmt.Errorf("%T can't be marshaled", msg)
+	}
+	data, err := marshaler.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &anypb.Any{TypeUrl: URL(msg), Value: data}, nil
+}
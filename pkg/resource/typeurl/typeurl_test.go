@@ -0,0 +1,77 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package typeurl
+
+import (
+	"testing"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestName(t *testing.T) {
+	if got := Name(&corev1.Pod{}); got != "k8s.io.api.core.v1.Pod" {
+		t.Errorf("expected the gogo name of Pod, got %s", got)
+	}
+	if got := Name(&structpb.Struct{}); got != "google.protobuf.Struct" {
+		t.Errorf("expected the full name of Struct, got %s", got)
+	}
+	if got := URL(&corev1.Pod{}); got != "type.googleapis.com/k8s.io.api.core.v1.Pod" {
+		t.Errorf("unexpected URL of Pod: %s", got)
+	}
+}
+
+func TestToName(t *testing.T) {
+	tests := []struct {
+		url, name, normalized string
+	}{
+		{"type.googleapis.com/k8s.io.api.core.v1.Pod", "k8s.io.api.core.v1.Pod", "type.googleapis.com/k8s.io.api.core.v1.Pod"},
+		// Specs of Kubernetes resources stored by older agents
+		{"k8s.io.api.core.v1.Pod", "k8s.io.api.core.v1.Pod", "type.googleapis.com/k8s.io.api.core.v1.Pod"},
+		{"example.com/types/foo.Bar", "foo.Bar", "type.googleapis.com/foo.Bar"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := ToName(tt.url); got != tt.name {
+			t.Errorf("ToName(%q) = %q, want %q", tt.url, got, tt.name)
+		}
+		if got := Normalize(tt.url); got != tt.normalized {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.url, got, tt.normalized)
+		}
+	}
+	if !Is("k8s.io.api.core.v1.Node", &corev1.Node{}) || !Is(URL(&corev1.Node{}), &corev1.Node{}) {
+		t.Errorf("expected both forms to be Node")
+	}
+	if Is(URL(&corev1.Pod{}), &corev1.Node{}) {
+		t.Errorf("expected Pod not to be Node")
+	}
+}
+
+func TestNew(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+	podAny, err := New(pod)
+	if err != nil {
+		t.Fatalf("failed to pack pod: %v", err)
+	}
+	if podAny.GetTypeUrl() != URL(pod) {
+		t.Errorf("unexpected type URL %s", podAny.GetTypeUrl())
+	}
+	decoded := &corev1.Pod{}
+	if err := gogoproto.Unmarshal(podAny.GetValue(), decoded); err != nil || decoded.Name != "web" {
+		t.Errorf("failed to decode pod %v: %v", decoded, err)
+	}
+
+	structAny, err := New(&structpb.Struct{})
+	if err != nil {
+		t.Fatalf("failed to pack struct: %v", err)
+	}
+	if structAny.GetTypeUrl() != "type.googleapis.com/google.protobuf.Struct" {
+		t.Errorf("unexpected type URL %s", structAny.GetTypeUrl())
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// Package tracing traces the internals of the agent with OpenTelemetry, so that a slow
+// snapshot cycle or a stalled intake stream can be traced to the collector or store
+// transaction responsible. The collection manager, the store writes and the intake sends
+// start spans with the tracers of this package.
+//
+// Tracing is off unless Setup is called with an endpoint: spans are then exported with
+// OTLP over HTTP, e.g. to an OpenTelemetry Collector. Until then, tracers are no-ops and
+// starting a span costs next to nothing.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the service.name of the spans of the agent
+const ServiceName = "antimetal-agent"
+
+// Options configure the export of spans
+type Options struct {
+	// Endpoint is the host:port of the OTLP/HTTP receiver, e.g. localhost:4318
+	Endpoint string
+	// Insecure sends spans over plain HTTP instead of HTTPS
+	Insecure bool
+	// SampleRatio is the fraction of the traces sampled, between 0 and 1. Spans whose
+	// parent is sampled are always sampled.
+	SampleRatio float64
+	// Attributes are added to the resource of every span, e.g. the version of the agent
+	// and the node it runs on
+	Attributes map[string]string
+}
+
+// Tracer returns the tracer of the instrumentation scope name, e.g. the import path of the
+// traced package, from the global tracer provider. Tracers returned before Setup start
+// exporting spans once it was called.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Setup exports the spans of the agent to opts.Endpoint. The returned function flushes the
+// spans not exported yet and stops the export; it must be called before the agent exits.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	if opts.Endpoint == "" {
+		return nil, errors.New("tracing endpoint must be set")
+	}
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample ratio must be between 0 and 1, got %g", opts.SampleRatio)
+	}
+
+	exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create span exporter: %w", err)
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("service.name", ServiceName)}
+	for key, value := range opts.Attributes {
+		attrs = append(attrs, attribute.String(key, value))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// End ends span, marking it failed with err if err isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
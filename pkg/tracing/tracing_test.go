@@ -0,0 +1,73 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/antimetal/agent/pkg/tracing"
+)
+
+func TestSetup_Validation(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), tracing.Options{SampleRatio: 1})
+	assert.ErrorContains(t, err, "endpoint must be set")
+
+	_, err = tracing.Setup(context.Background(), tracing.Options{Endpoint: "localhost:4318", SampleRatio: 2})
+	assert.ErrorContains(t, err, "sample ratio must be between 0 and 1")
+}
+
+func TestSetup(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:    "localhost:4318",
+		Insecure:    true,
+		SampleRatio: 1,
+		Attributes:  map[string]string{"host.name": "node-1"},
+	})
+	require.NoError(t, err)
+
+	// Tracers are sampled once the exporter is set up, but nothing is listening so the
+	// spans are dropped on shutdown
+	_, span := tracing.Tracer("test").Start(context.Background(), "op")
+	assert.True(t, span.SpanContext().IsSampled())
+	span.End()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = shutdown(ctx)
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := provider.Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "ok")
+	tracing.End(span, nil)
+	_, span = tracer.Start(context.Background(), "failed")
+	tracing.End(span, errors.New("boom"))
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 2)
+	assert.Equal(t, codes.Unset, ended[0].Status().Code)
+	assert.Empty(t, ended[0].Events())
+	assert.Equal(t, codes.Error, ended[1].Status().Code)
+	assert.Equal(t, "boom", ended[1].Status().Description)
+	require.Len(t, ended[1].Events(), 1)
+	assert.Equal(t, "exception", ended[1].Events()[0].Name)
+}
@@ -0,0 +1,195 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	k8sv1 "github.com/antimetal/apis/gengo/kubernetes/v1"
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	gogoproto "github.com/gogo/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/antimetal/agent/pkg/performance/history"
+)
+
+const waitTimeout = 2 * time.Minute
+
+var (
+	nodeType     = gogoproto.MessageName(&corev1.Node{})
+	podType      = gogoproto.MessageName(&corev1.Pod{})
+	containsType = string((&k8sv1.Contains{}).ProtoReflect().Descriptor().FullName())
+	resourceKind = string((&resourcev1.Resource{}).ProtoReflect().Descriptor().FullName())
+	relationKind = string((&resourcev1.Relationship{}).ProtoReflect().Descriptor().FullName())
+	upsertOps    = map[intakev1.DeltaOperation]bool{
+		intakev1.DeltaOperation_DELTA_OPERATION_CREATE: true,
+		intakev1.DeltaOperation_DELTA_OPERATION_UPDATE: true,
+	}
+)
+
+// inventory is what the intake server received, decoded
+type inventory struct {
+	resources     map[string][]*resourcev1.Resource // by type
+	relationships map[string][]*resourcev1.Relationship
+	errors        []error
+}
+
+func decodeDeltas(deltas []*intakev1.Delta) *inventory {
+	inv := &inventory{
+		resources:     make(map[string][]*resourcev1.Resource),
+		relationships: make(map[string][]*resourcev1.Relationship),
+	}
+	for _, d := range deltas {
+		if !upsertOps[d.GetOp()] {
+			continue
+		}
+		for _, obj := range d.GetObjects() {
+			switch obj.GetType().GetKind() {
+			case resourceKind:
+				rsrc := &resourcev1.Resource{}
+				if err := obj.GetObject().UnmarshalTo(rsrc); err != nil {
+					inv.errors = append(inv.errors, fmt.Errorf("resource %s: %w", obj.GetType().GetType(), err))
+					continue
+				}
+				inv.resources[rsrc.GetType().GetType()] = append(inv.resources[rsrc.GetType().GetType()], rsrc)
+			case relationKind:
+				rel := &resourcev1.Relationship{}
+				if err := obj.GetObject().UnmarshalTo(rel); err != nil {
+					inv.errors = append(inv.errors, fmt.Errorf("relationship %s: %w", obj.GetType().GetType(), err))
+					continue
+				}
+				inv.relationships[rel.GetType().GetType()] = append(inv.relationships[rel.GetType().GetType()], rel)
+			}
+		}
+	}
+	return inv
+}
+
+// find returns the resource of type typ named name
+func (inv *inventory) find(typ, name string) *resourcev1.Resource {
+	for _, rsrc := range inv.resources[typ] {
+		if rsrc.GetMetadata().GetName() == name {
+			return rsrc
+		}
+	}
+	return nil
+}
+
+// contains reports whether a Contains relationship from the Node to the Pod was received
+func (inv *inventory) contains(node, pod string) bool {
+	for _, rel := range inv.relationships[containsType] {
+		if rel.GetSubject().GetTypeUrl() == nodeType && rel.GetSubject().GetName() == node &&
+			rel.GetObject().GetTypeUrl() == podType && rel.GetObject().GetName() == pod {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAgent_SendsKubernetesInventory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	pod, err := agentPod(ctx)
+	if err != nil {
+		t.Fatalf("failed to get agent pod: %v", err)
+	}
+	node := env.cluster + "-control-plane"
+
+	var inv *inventory
+	err = env.intake.WaitForDeltas(ctx, func(deltas []*intakev1.Delta) bool {
+		inv = decodeDeltas(deltas)
+		return inv.find(nodeType, node) != nil && inv.find(podType, pod) != nil && inv.contains(node, pod)
+	})
+	if err != nil {
+		t.Fatalf("node %s, agent pod %s and their relationship not received: %v", node, pod, err)
+	}
+	for _, err := range inv.errors {
+		t.Errorf("failed to decode object: %v", err)
+	}
+
+	nodeRsrc := inv.find(nodeType, node)
+	if kind := nodeRsrc.GetType().GetKind(); kind != resourceKind {
+		t.Errorf("node kind = %q, want %q", kind, resourceKind)
+	}
+	if cluster := nodeRsrc.GetMetadata().GetNamespace().GetKube().GetCluster(); cluster == "" {
+		t.Errorf("node has no cluster namespace: %v", nodeRsrc.GetMetadata())
+	}
+	nodeSpec := &corev1.Node{}
+	if err := gogoproto.Unmarshal(nodeRsrc.GetSpec().GetValue(), nodeSpec); err != nil {
+		t.Fatalf("failed to unmarshal node spec: %v", err)
+	}
+	if nodeSpec.Name != node {
+		t.Errorf("node spec name = %q, want %q", nodeSpec.Name, node)
+	}
+
+	podSpec := &corev1.Pod{}
+	if err := gogoproto.Unmarshal(inv.find(podType, pod).GetSpec().GetValue(), podSpec); err != nil {
+		t.Fatalf("failed to unmarshal pod spec: %v", err)
+	}
+	if podSpec.Namespace != agentNamespace || podSpec.Spec.NodeName != node {
+		t.Errorf("unexpected pod %s/%s on node %q", podSpec.Namespace, podSpec.Name, podSpec.Spec.NodeName)
+	}
+
+	streams, rejected, _ := env.intake.Stats()
+	if streams == 0 || rejected != 0 {
+		t.Errorf("intake streams = %d, rejected = %d: the agent should authenticate with its API key", streams, rejected)
+	}
+}
+
+func TestAgent_CollectsPerformanceSnapshots(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	pod, err := agentPod(ctx)
+	if err != nil {
+		t.Fatalf("failed to get agent pod: %v", err)
+	}
+	// Read the debug server through the API server's pod proxy
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s:%d/proxy/debug/performance/snapshots?since=10m",
+		agentNamespace, pod, debugPort)
+
+	var records []history.Record
+	for {
+		out, err := kubectl(ctx, "get", "--raw", path)
+		if err == nil {
+			records = nil
+			if err := json.Unmarshal(out, &records); err != nil {
+				t.Fatalf("failed to decode performance history: %v", err)
+			}
+			if len(records) > 0 {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("no performance snapshot collected: last error: %v", err)
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	record := records[len(records)-1]
+	if time.Since(record.Timestamp) > 10*time.Minute {
+		t.Errorf("latest snapshot is too old: %s", record.Timestamp)
+	}
+	if len(record.Collectors) == 0 {
+		t.Fatalf("snapshot has no collector runs")
+	}
+	for metricType, run := range record.Collectors {
+		t.Logf("collector %s: %s in %s %s", metricType, run.Status, run.Duration, run.Error)
+	}
+	if record.Metrics.Load == nil {
+		t.Errorf("snapshot has no load metrics: %+v", record.Metrics)
+	}
+}
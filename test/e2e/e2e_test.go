@@ -0,0 +1,243 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+//go:build e2e
+
+// Package e2e tests the whole agent pipeline: the agent image is deployed to a kind
+// cluster and sends its inventory to a fake intake server running in the test process.
+//
+// The tests need docker, kind and kubectl and are configured through the environment:
+//
+//   - E2E_IMAGE: agent image to test, which must exist locally. Defaults to antimetal/agent:dev.
+//   - E2E_KIND_CLUSTER: kind cluster to deploy to. It is created if it doesn't exist and
+//     deleted afterwards unless E2E_KEEP_CLUSTER is set. Defaults to antimetal-agent-e2e.
+//   - E2E_KIND, E2E_KUBECTL: paths of the kind and kubectl binaries. Default to the ones
+//     in PATH.
+//
+// Run them with make test-e2e.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antimetal/agent/internal/intake/testserver"
+)
+
+const (
+	agentNamespace = "antimetal-system"
+	agentSelector  = "control-plane=antimetal"
+	debugPort      = 8082
+
+	intakeAPIKey = "e2e-api-key"
+
+	deployTimeout = 5 * time.Minute
+)
+
+// env is the environment shared by the tests, set up by TestMain
+var env struct {
+	kind       string
+	kubectl    string
+	cluster    string
+	image      string
+	kubeconfig string
+	intake     *testserver.Server
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	env.kind = getenv("E2E_KIND", "kind")
+	env.kubectl = getenv("E2E_KUBECTL", "kubectl")
+	env.cluster = getenv("E2E_KIND_CLUSTER", "antimetal-agent-e2e")
+	env.image = getenv("E2E_IMAGE", "antimetal/agent:dev")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*deployTimeout)
+	defer cancel()
+
+	created, err := createCluster(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create kind cluster: %v\n", err)
+		return 1
+	}
+	if created && os.Getenv("E2E_KEEP_CLUSTER") == "" {
+		defer func() {
+			if _, err := command(context.Background(), env.kind, "delete", "cluster", "--name", env.cluster); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete kind cluster: %v\n", err)
+			}
+		}()
+	}
+
+	dir, err := os.MkdirTemp("", "agent-e2e")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create temporary directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	env.kubeconfig = filepath.Join(dir, "kubeconfig")
+	if _, err := command(ctx, env.kind, "export", "kubeconfig", "--name", env.cluster,
+		"--kubeconfig", env.kubeconfig); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to export kubeconfig: %v\n", err)
+		return 1
+	}
+
+	// The agent runs in a container on the kind network, so the intake server has to
+	// listen on the address of the host on that network
+	gateway, err := kindGateway(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to determine the kind network gateway: %v\n", err)
+		return 1
+	}
+	env.intake, err = testserver.New(
+		testserver.WithAddress(net.JoinHostPort(gateway, "0")),
+		testserver.WithAPIKey(intakeAPIKey),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start intake server: %v\n", err)
+		return 1
+	}
+	defer env.intake.Stop()
+
+	if err := deployAgent(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to deploy agent: %v\n", err)
+		dumpAgentLogs()
+		return 1
+	}
+
+	code := m.Run()
+	if code != 0 {
+		dumpAgentLogs()
+	}
+	return code
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// command runs name with args and returns its stdout. The error includes stderr.
+func command(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return commandWithInput(ctx, nil, name, args...)
+}
+
+func commandWithInput(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// kubectl runs kubectl against the test cluster
+func kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	return command(ctx, env.kubectl, append([]string{"--kubeconfig", env.kubeconfig}, args...)...)
+}
+
+// createCluster creates the kind cluster unless it already exists and reports whether
+// it was created
+func createCluster(ctx context.Context) (bool, error) {
+	out, err := command(ctx, env.kind, "get", "clusters")
+	if err != nil {
+		return false, err
+	}
+	for _, name := range strings.Fields(string(out)) {
+		if name == env.cluster {
+			return false, nil
+		}
+	}
+	if _, err := command(ctx, env.kind, "create", "cluster", "--name", env.cluster, "--wait", "2m"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// kindGateway returns the IPv4 gateway of the docker network kind clusters run on, which
+// is the address of the host as seen from the cluster's containers
+func kindGateway(ctx context.Context) (string, error) {
+	out, err := command(ctx, "docker", "network", "inspect", "kind", "--format", "{{json .IPAM.Config}}")
+	if err != nil {
+		return "", err
+	}
+	var configs []struct {
+		Gateway string
+	}
+	if err := json.Unmarshal(out, &configs); err != nil {
+		return "", fmt.Errorf("failed to parse network config: %w", err)
+	}
+	for _, config := range configs {
+		if ip := net.ParseIP(config.Gateway); ip != nil && ip.To4() != nil {
+			return config.Gateway, nil
+		}
+	}
+	return "", fmt.Errorf("kind network has no IPv4 gateway: %s", out)
+}
+
+var agentImage = regexp.MustCompile(`(?m)^(\s+image: )agent$`)
+
+// deployAgent loads the agent image into the cluster, deploys test/e2e/config pointed at
+// the intake server and waits for the agent to be ready
+func deployAgent(ctx context.Context) error {
+	if _, err := command(ctx, env.kind, "load", "docker-image", env.image, "--name", env.cluster); err != nil {
+		return err
+	}
+
+	manifests, err := kubectl(ctx, "kustomize", "config")
+	if err != nil {
+		return err
+	}
+	manifests = agentImage.ReplaceAll(manifests, []byte("${1}"+env.image))
+	manifests = bytes.ReplaceAll(manifests, []byte("E2E_INTAKE_ADDRESS"), []byte(env.intake.Addr()))
+	manifests = bytes.ReplaceAll(manifests, []byte("E2E_INTAKE_API_KEY"), []byte(intakeAPIKey))
+	if _, err := commandWithInput(ctx, manifests, env.kubectl, "--kubeconfig", env.kubeconfig, "apply", "-f", "-"); err != nil {
+		return err
+	}
+
+	_, err = kubectl(ctx, "-n", agentNamespace, "rollout", "status", "deployment/agent",
+		"--timeout", deployTimeout.String())
+	return err
+}
+
+// agentPod returns the name of the running agent pod
+func agentPod(ctx context.Context) (string, error) {
+	out, err := kubectl(ctx, "-n", agentNamespace, "get", "pods", "-l", agentSelector,
+		"--field-selector", "status.phase=Running", "-o", "jsonpath={.items[0].metadata.name}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func dumpAgentLogs() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := kubectl(ctx, "-n", agentNamespace, "logs", "-l", agentSelector, "--tail", "200")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get agent logs: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "agent logs:\n%s\n", out)
+}
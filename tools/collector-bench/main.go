@@ -0,0 +1,289 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// collector-bench measures what a collection of each point collector costs on the host it
+// runs on: the time it takes and the memory it allocates.
+//
+//	go run ./tools/collector-bench -output json > baseline.json
+//	go run ./tools/collector-bench -baseline baseline.json -max-regression 20
+//
+// With -baseline, the results are compared with those of a previous run printed with
+// -output json, and the exit status is 1 if the time, allocations or allocated bytes per
+// collection of a collector grew by more than -max-regression percent. The results depend
+// on the host and what it runs, so a baseline is only meaningful on the machine, or the
+// kind of CI runner, it was recorded on.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/stdr"
+
+	"github.com/antimetal/agent/pkg/performance"
+	"github.com/antimetal/agent/pkg/performance/collectors"
+)
+
+type config struct {
+	collectors    []performance.MetricType
+	procPath      string
+	sysPath       string
+	devPath       string
+	iterations    int
+	count         int
+	output        string
+	baseline      string
+	maxRegression float64
+	verbose       bool
+}
+
+// Report is the result of a benchmark run
+type Report struct {
+	Iterations int      `json:"iterations"`
+	Count      int      `json:"count"`
+	Results    []Result `json:"results"`
+	// Comparisons with the baseline, if any
+	Comparisons []Comparison `json:"comparisons,omitempty"`
+}
+
+// Result is the cost of a collection of a collector, from the fastest of the runs of
+// Report.Count times Report.Iterations collections
+type Result struct {
+	Collector   performance.MetricType `json:"collector"`
+	NsPerOp     float64                `json:"nsPerOp"`
+	AllocsPerOp float64                `json:"allocsPerOp"`
+	BytesPerOp  float64                `json:"bytesPerOp"`
+	// Error is set if the collector couldn't be created or a collection failed, e.g.
+	// because the host doesn't have what it collects from
+	Error string `json:"error,omitempty"`
+}
+
+// Comparison is the change of a metric of a collector from the baseline
+type Comparison struct {
+	Collector     performance.MetricType `json:"collector"`
+	Metric        string                 `json:"metric"`
+	Baseline      float64                `json:"baseline"`
+	Current       float64                `json:"current"`
+	ChangePercent float64                `json:"changePercent"`
+	Regressed     bool                   `json:"regressed"`
+}
+
+func main() {
+	var cfg config
+	var names string
+	flag.StringVar(&names, "collectors", "",
+		"Comma separated metric types of the collectors to benchmark, e.g. cpu,disk. Defaults to all point collectors but the opt-in ones.")
+	flag.StringVar(&cfg.procPath, "proc-path", "/proc", "Path of the host's /proc.")
+	flag.StringVar(&cfg.sysPath, "sys-path", "/sys", "Path of the host's /sys.")
+	flag.StringVar(&cfg.devPath, "dev-path", "/dev", "Path of the host's /dev.")
+	flag.IntVar(&cfg.iterations, "iterations", 100, "Collections per run.")
+	flag.IntVar(&cfg.count, "count", 5, "Runs per collector. The fastest run is reported, to reduce the noise of the host.")
+	flag.StringVar(&cfg.output, "output", "text", "Output format, text or json.")
+	flag.StringVar(&cfg.baseline, "baseline", "", "JSON report of a previous run to compare the results with.")
+	flag.Float64Var(&cfg.maxRegression, "max-regression", 10,
+		"Percentage by which a metric can exceed the baseline before the run fails.")
+	flag.BoolVar(&cfg.verbose, "v", false, "Log the collectors' messages.")
+	flag.Parse()
+
+	if cfg.iterations <= 0 || cfg.count <= 0 || cfg.maxRegression < 0 {
+		fmt.Fprintln(os.Stderr, "-iterations and -count must be positive and -max-regression can't be negative")
+		os.Exit(2)
+	}
+	if cfg.output != "text" && cfg.output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown -output %q, must be text or json\n", cfg.output)
+		os.Exit(2)
+	}
+	factories := collectors.PointCollectorFactories()
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := factories[performance.MetricType(name)]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown collector %q\n", name)
+			os.Exit(2)
+		}
+		cfg.collectors = append(cfg.collectors, performance.MetricType(name))
+	}
+	if len(cfg.collectors) == 0 {
+		optIn := collectors.OptInCollectors()
+		for metricType := range factories {
+			if _, ok := optIn[metricType]; !ok {
+				cfg.collectors = append(cfg.collectors, metricType)
+			}
+		}
+		slices.Sort(cfg.collectors)
+	}
+
+	var baseline *Report
+	if cfg.baseline != "" {
+		var err error
+		if baseline, err = readReport(cfg.baseline); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	report := run(context.Background(), cfg, factories)
+	if baseline != nil {
+		report.Comparisons = compare(baseline, report, cfg.maxRegression)
+	}
+
+	var err error
+	if cfg.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = printReport(os.Stdout, report, cfg.maxRegression)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if slices.ContainsFunc(report.Comparisons, func(c Comparison) bool { return c.Regressed }) {
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, factories map[performance.MetricType]collectors.PointCollectorFactory) *Report {
+	logger := logr.Discard()
+	if cfg.verbose {
+		logger = stdr.New(nil)
+	}
+	collectionConfig := performance.DefaultCollectionConfig()
+	collectionConfig.HostProcPath = cfg.procPath
+	collectionConfig.HostSysPath = cfg.sysPath
+	collectionConfig.HostDevPath = cfg.devPath
+
+	report := &Report{Iterations: cfg.iterations, Count: cfg.count}
+	for _, metricType := range cfg.collectors {
+		result := Result{Collector: metricType}
+		collector, err := factories[metricType](logger.WithName(string(metricType)), collectionConfig)
+		if err == nil {
+			err = benchmark(ctx, collector, cfg, &result)
+		}
+		if err != nil {
+			result = Result{Collector: metricType, Error: err.Error()}
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// benchmark runs cfg.count times cfg.iterations collections of collector and sets the
+// cost of the fastest run in result
+func benchmark(ctx context.Context, collector performance.PointCollector, cfg config, result *Result) error {
+	// Collectors reporting rates read their first sample on the first collection
+	if _, err := collector.Collect(ctx); err != nil {
+		return err
+	}
+
+	result.NsPerOp = -1
+	var before, after runtime.MemStats
+	for range cfg.count {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		for range cfg.iterations {
+			if _, err := collector.Collect(ctx); err != nil {
+				return err
+			}
+		}
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		nsPerOp := float64(elapsed.Nanoseconds()) / float64(cfg.iterations)
+		if result.NsPerOp < 0 || nsPerOp < result.NsPerOp {
+			result.NsPerOp = nsPerOp
+			result.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(cfg.iterations)
+			result.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(cfg.iterations)
+		}
+	}
+	return nil
+}
+
+// compare returns the changes of the metrics of current from baseline. A metric regressed
+// if it exceeds the baseline by more than maxRegression percent. Collectors that failed in
+// either run or aren't in the baseline aren't compared, nor are metrics that are 0 in the
+// baseline.
+func compare(baseline, current *Report, maxRegression float64) []Comparison {
+	var comparisons []Comparison
+	for _, cur := range current.Results {
+		i := slices.IndexFunc(baseline.Results, func(r Result) bool { return r.Collector == cur.Collector })
+		if i < 0 || cur.Error != "" || baseline.Results[i].Error != "" {
+			continue
+		}
+		base := baseline.Results[i]
+		for _, m := range []struct {
+			name          string
+			base, current float64
+		}{
+			{"ns/op", base.NsPerOp, cur.NsPerOp},
+			{"allocs/op", base.AllocsPerOp, cur.AllocsPerOp},
+			{"bytes/op", base.BytesPerOp, cur.BytesPerOp},
+		} {
+			if m.base <= 0 {
+				continue
+			}
+			change := 100 * (m.current - m.base) / m.base
+			comparisons = append(comparisons, Comparison{
+				Collector:     cur.Collector,
+				Metric:        m.name,
+				Baseline:      m.base,
+				Current:       m.current,
+				ChangePercent: change,
+				Regressed:     change > maxRegression,
+			})
+		}
+	}
+	return comparisons
+}
+
+func readReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	return report, nil
+}
+
+func printReport(w io.Writer, r *Report, maxRegression float64) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "COLLECTOR\tNS/OP\tALLOCS/OP\tBYTES/OP\t\n")
+	for _, result := range r.Results {
+		if result.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\terror: %s\n", result.Collector, result.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%.0f\t%.1f\t%.0f\t\n", result.Collector, result.NsPerOp, result.AllocsPerOp, result.BytesPerOp)
+	}
+	if len(r.Comparisons) > 0 {
+		fmt.Fprintf(tw, "\nCOLLECTOR\tMETRIC\tBASELINE\tCURRENT\tCHANGE\t\n")
+		for _, c := range r.Comparisons {
+			status := ""
+			if c.Regressed {
+				status = fmt.Sprintf("REGRESSED (> %.1f%%)", maxRegression)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%.1f\t%.1f\t%+.1f%%\t%s\n",
+				c.Collector, c.Metric, c.Baseline, c.Current, c.ChangePercent, status)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,453 @@
+// Copyright Antimetal, Inc. All rights reserved.
+//
+// Use of this source code is governed by a source available license that can be found in the
+// LICENSE file or at:
+// https://polyformproject.org/wp-content/uploads/2020/06/PolyForm-Shield-1.0.0.txt
+
+// intake-loadtest measures the throughput, memory use and end-to-end latency of the intake
+// worker. It writes synthetic resources into an in-memory store at a fixed rate and runs
+// the worker against an in-process intake server, which records when each resource
+// arrives.
+//
+//	go run ./tools/intake-loadtest -rate 5000 -duration 1m -cpuprofile cpu.out
+//
+// The results are a baseline to compare against before rolling out to large clusters,
+// not an absolute measure: the store, the worker and the server share the same process.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	resourcev1 "github.com/antimetal/apis/gengo/resource/v1"
+	intakev1 "github.com/antimetal/apis/gengo/service/resource/v1"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/stdr"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/antimetal/agent/internal/intake"
+	"github.com/antimetal/agent/internal/intake/testserver"
+	"github.com/antimetal/agent/pkg/resource"
+	"github.com/antimetal/agent/pkg/resource/store"
+)
+
+// seqTag is the tag carrying the sequence number of a write, to match the resources the
+// server receives with the time they were written
+const seqTag = "loadtest.antimetal.com/seq"
+
+// tick is how often the generator writes the resources due
+const tick = 10 * time.Millisecond
+
+type config struct {
+	rate         int
+	duration     time.Duration
+	resources    int
+	payloadBytes int
+	batchSize    int
+	flushPeriod  time.Duration
+	recvDelay    time.Duration
+	drainTimeout time.Duration
+	cpuProfile   string
+	memProfile   string
+	pprofAddr    string
+	jsonOutput   bool
+	verbose      bool
+}
+
+// Report is the result of a load test run
+type Report struct {
+	Rate     int           `json:"rate"`
+	Duration time.Duration `json:"duration"`
+	// Elapsed is the time from the first write until the last was received or the drain
+	// timeout expired
+	Elapsed time.Duration `json:"elapsed"`
+	// Written is the number of resource writes to the store, Received the number of those
+	// the server received
+	Written  int64 `json:"written"`
+	Received int64 `json:"received"`
+	// Throughput is the number of writes received per second
+	Throughput float64 `json:"throughput"`
+	// GeneratorLag is how much longer than Duration the writes took, when the store can't
+	// keep up with the requested rate
+	GeneratorLag time.Duration `json:"generatorLag"`
+	// Latency from the write to the store to the receipt by the server
+	LatencyP50 time.Duration `json:"latencyP50"`
+	LatencyP90 time.Duration `json:"latencyP90"`
+	LatencyP99 time.Duration `json:"latencyP99"`
+	LatencyMax time.Duration `json:"latencyMax"`
+	// Memory of the whole process
+	PeakHeapBytes      uint64  `json:"peakHeapBytes"`
+	AllocBytesPerWrite float64 `json:"allocBytesPerWrite"`
+	NumGC              uint32  `json:"numGC"`
+}
+
+func main() {
+	var cfg config
+	flag.IntVar(&cfg.rate, "rate", 1000, "Resources written to the store per second.")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "How long to write resources.")
+	flag.IntVar(&cfg.resources, "resources", 10000,
+		"Number of distinct resources. Once all are created, writes update them in turn.")
+	flag.IntVar(&cfg.payloadBytes, "payload-bytes", 2048, "Size of the spec of each resource.")
+	flag.IntVar(&cfg.batchSize, "batch-size", 100, "Maximum number of deltas the worker sends in a request.")
+	flag.DurationVar(&cfg.flushPeriod, "flush-period", time.Second, "How often the worker flushes incomplete batches.")
+	flag.DurationVar(&cfg.recvDelay, "recv-delay", 0, "Delay of the server before each request, to simulate a slow intake.")
+	flag.DurationVar(&cfg.drainTimeout, "drain-timeout", 30*time.Second,
+		"How long to wait for the worker to send the remaining resources once writing stops.")
+	flag.StringVar(&cfg.cpuProfile, "cpuprofile", "", "Write a CPU profile of the run to this file.")
+	flag.StringVar(&cfg.memProfile, "memprofile", "", "Write a heap profile at the end of the run to this file.")
+	flag.StringVar(&cfg.pprofAddr, "pprof-addr", "", "Serve net/http/pprof on this address during the run, e.g. localhost:6060.")
+	flag.BoolVar(&cfg.jsonOutput, "json", false, "Print the report as JSON.")
+	flag.BoolVar(&cfg.verbose, "v", false, "Log the worker's messages.")
+	flag.Parse()
+
+	if cfg.rate <= 0 || cfg.resources <= 0 || cfg.duration <= 0 {
+		fmt.Fprintln(os.Stderr, "-rate, -resources and -duration must be positive")
+		os.Exit(2)
+	}
+
+	report, err := run(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = printReport(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if report.Received < report.Written {
+		os.Exit(1)
+	}
+}
+
+// tracker records when each write was made and how long it took to be received
+type tracker struct {
+	mu        sync.Mutex
+	written   map[uint64]time.Time
+	latencies []time.Duration
+	received  int64
+	done      chan struct{}
+	total     int64 // Set once writing stops, -1 until then
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		written: make(map[uint64]time.Time),
+		done:    make(chan struct{}),
+		total:   -1,
+	}
+}
+
+func (t *tracker) write(seq uint64) {
+	t.mu.Lock()
+	t.written[seq] = time.Now()
+	t.mu.Unlock()
+}
+
+// finish records that total writes were made
+func (t *tracker) finish(total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = total
+	t.checkDone()
+}
+
+// receive records the writes in delta
+func (t *tracker) receive(delta *intakev1.Delta) {
+	now := time.Now()
+	op := delta.GetOp()
+	if op != intakev1.DeltaOperation_DELTA_OPERATION_CREATE && op != intakev1.DeltaOperation_DELTA_OPERATION_UPDATE {
+		return
+	}
+	for _, obj := range delta.GetObjects() {
+		rsrc := &resourcev1.Resource{}
+		if err := proto.Unmarshal(obj.GetObject().GetValue(), rsrc); err != nil {
+			continue
+		}
+		seq, ok := sequence(rsrc)
+		if !ok {
+			continue
+		}
+
+		t.mu.Lock()
+		if written, ok := t.written[seq]; ok {
+			delete(t.written, seq)
+			t.latencies = append(t.latencies, now.Sub(written))
+			t.received++
+			t.checkDone()
+		}
+		t.mu.Unlock()
+	}
+}
+
+// checkDone closes done once all writes were received. t.mu must be held.
+func (t *tracker) checkDone() {
+	if t.total >= 0 && t.received >= t.total {
+		select {
+		case <-t.done:
+		default:
+			close(t.done)
+		}
+	}
+}
+
+func sequence(rsrc *resourcev1.Resource) (uint64, bool) {
+	for _, tag := range rsrc.GetMetadata().GetTags() {
+		if tag.GetKey() == seqTag {
+			seq, err := strconv.ParseUint(tag.GetValue(), 10, 64)
+			return seq, err == nil
+		}
+	}
+	return 0, false
+}
+
+func run(ctx context.Context, cfg config) (*Report, error) {
+	logger := logr.Discard()
+	if cfg.verbose {
+		logger = stdr.New(nil)
+	}
+
+	if cfg.pprofAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.pprofAddr, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "pprof server stopped: %v\n", err)
+			}
+		}()
+	}
+	if cfg.cpuProfile != "" {
+		f, err := os.Create(cfg.cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	t := newTracker()
+	opts := []testserver.Option{testserver.WithDeltaHandler(t.receive)}
+	if cfg.recvDelay > 0 {
+		opts = append(opts, testserver.WithRecvDelay(cfg.recvDelay))
+	}
+	srv, err := testserver.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start intake server: %w", err)
+	}
+	defer srv.Stop()
+	conn, err := srv.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial intake server: %w", err)
+	}
+	defer conn.Close()
+
+	inv, err := store.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+	w, err := intake.NewWorker(inv,
+		intake.WithLogger(logger),
+		intake.WithGRPCConn(conn),
+		intake.WithMaxBatchSize(cfg.batchSize),
+		intake.WithFlushPeriod(cfg.flushPeriod),
+	)
+	if err != nil {
+		inv.Close()
+		return nil, fmt.Errorf("failed to create intake worker: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		_ = w.Start(ctx)
+	}()
+
+	var memBefore runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	peakHeap := sampleHeap(ctx)
+
+	start := time.Now()
+	written, lag, err := generate(ctx, cfg, inv, t)
+	if err != nil {
+		cancel()
+		inv.Close()
+		<-workerDone
+		return nil, err
+	}
+	t.finish(written)
+
+	select {
+	case <-t.done:
+	case <-time.After(cfg.drainTimeout):
+	}
+	elapsed := time.Since(start)
+
+	var memAfter runtime.MemStats
+	runtime.ReadMemStats(&memAfter)
+	if cfg.memProfile != "" {
+		if err := writeHeapProfile(cfg.memProfile); err != nil {
+			return nil, err
+		}
+	}
+
+	// The worker stops once the store closes its subscription
+	cancel()
+	inv.Close()
+	<-workerDone
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := &Report{
+		Rate:               cfg.rate,
+		Duration:           cfg.duration,
+		Elapsed:            elapsed,
+		Written:            written,
+		Received:           t.received,
+		Throughput:         float64(t.received) / elapsed.Seconds(),
+		GeneratorLag:       lag,
+		PeakHeapBytes:      max(peakHeap(), memAfter.HeapAlloc),
+		AllocBytesPerWrite: float64(memAfter.TotalAlloc-memBefore.TotalAlloc) / float64(max(written, 1)),
+		NumGC:              memAfter.NumGC - memBefore.NumGC,
+	}
+	if len(t.latencies) > 0 {
+		slices.Sort(t.latencies)
+		report.LatencyP50 = percentile(t.latencies, 0.50)
+		report.LatencyP90 = percentile(t.latencies, 0.90)
+		report.LatencyP99 = percentile(t.latencies, 0.99)
+		report.LatencyMax = t.latencies[len(t.latencies)-1]
+	}
+	return report, nil
+}
+
+// generate writes cfg.rate resources per second to inv for cfg.duration. It returns the
+// number of writes and how much longer than cfg.duration they took.
+func generate(ctx context.Context, cfg config, inv resource.Store, t *tracker) (int64, time.Duration, error) {
+	payload, err := anypb.New(wrapperspb.Bytes(make([]byte, cfg.payloadBytes)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create payload: %w", err)
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	total := uint64(cfg.duration.Seconds() * float64(cfg.rate))
+	start := time.Now()
+	var seq uint64
+	for now := start; seq < total; {
+		// Catch up on the writes due so far, so that a slow write doesn't lower the rate
+		due := min(uint64(now.Sub(start).Seconds()*float64(cfg.rate)), total)
+		for ; seq < due; seq++ {
+			rsrc := &resourcev1.Resource{
+				Type: &resourcev1.TypeDescriptor{Kind: "loadtest", Type: "loadtest.Resource"},
+				Metadata: &resourcev1.ResourceMeta{
+					Name: fmt.Sprintf("resource-%d", seq%uint64(cfg.resources)),
+					Tags: []*resourcev1.Tag{{Key: seqTag, Value: strconv.FormatUint(seq, 10)}},
+				},
+				Spec: payload,
+			}
+			t.write(seq)
+			if err := inv.UpdateResource(rsrc); err != nil {
+				return int64(seq), 0, fmt.Errorf("failed to write resource: %w", err)
+			}
+		}
+		if seq >= total {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return int64(seq), 0, ctx.Err()
+		case now = <-ticker.C:
+		}
+	}
+	return int64(seq), max(time.Since(start)-cfg.duration, 0), nil
+}
+
+// sampleHeap samples the heap size every 100ms until ctx is done and returns a function
+// reporting the largest size seen
+func sampleHeap(ctx context.Context) func() uint64 {
+	var mu sync.Mutex
+	var peak uint64
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		var m runtime.MemStats
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&m)
+				mu.Lock()
+				peak = max(peak, m.HeapAlloc)
+				mu.Unlock()
+			}
+		}
+	}()
+	return func() uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return peak
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create heap profile: %w", err)
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("failed to write heap profile: %w", err)
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile of sorted
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func printReport(w io.Writer, r *Report) error {
+	_, err := fmt.Fprintf(w, `Rate:           %d/s for %s
+Elapsed:        %s
+Written:        %d
+Received:       %d
+Throughput:     %.0f/s
+Generator lag:  %s
+Latency:        p50 %s, p90 %s, p99 %s, max %s
+Peak heap:      %.1f MiB
+Alloc/write:    %.0f bytes
+GC cycles:      %d
+`,
+		r.Rate, r.Duration, r.Elapsed.Round(time.Millisecond),
+		r.Written, r.Received, r.Throughput, r.GeneratorLag.Round(time.Millisecond),
+		r.LatencyP50.Round(time.Microsecond), r.LatencyP90.Round(time.Microsecond),
+		r.LatencyP99.Round(time.Microsecond), r.LatencyMax.Round(time.Microsecond),
+		float64(r.PeakHeapBytes)/(1<<20), r.AllocBytesPerWrite, r.NumGC)
+	return err
+}